@@ -14,6 +14,10 @@ pub enum CompressionAlgorithm {
     #[cfg(feature = "gzip")]
     Gzip,
 
+    /// Deflate compression (zlib format, as used by HTTP `deflate`)
+    #[cfg(feature = "gzip")]
+    Deflate,
+
     /// Brotli compression (best ratio for text)
     #[cfg(feature = "brotli")]
     Brotli,
@@ -33,6 +37,8 @@ impl CompressionAlgorithm {
             Self::Auto => None, // Will be determined at runtime
             #[cfg(feature = "gzip")]
             Self::Gzip => Some("gzip"),
+            #[cfg(feature = "gzip")]
+            Self::Deflate => Some("deflate"),
             #[cfg(feature = "brotli")]
             Self::Brotli => Some("br"),
             #[cfg(feature = "zstd")]
@@ -46,7 +52,7 @@ impl CompressionAlgorithm {
         match self {
             Self::Auto | Self::None => true,
             #[cfg(feature = "gzip")]
-            Self::Gzip => true,
+            Self::Gzip | Self::Deflate => true,
             #[cfg(feature = "brotli")]
             Self::Brotli => true,
             #[cfg(feature = "zstd")]
@@ -57,36 +63,68 @@ impl CompressionAlgorithm {
     }
 
     /// Select the best algorithm based on Accept-Encoding header
+    ///
+    /// Quality values are honored: encodings with `q=0` are never selected
+    /// and `*` matches any encoding not listed explicitly. Among equally
+    /// weighted encodings the preference is br > zstd > gzip > deflate.
     pub fn select_from_accept_encoding(accept_encoding: &str) -> Self {
-        let encodings: Vec<&str> = accept_encoding
+        let entries: Vec<(String, f32)> = accept_encoding
             .split(',')
-            .map(|s| s.split(';').next().unwrap_or("").trim())
+            .filter_map(|entry| {
+                let mut parts = entry.split(';');
+                let name = parts.next()?.trim().to_ascii_lowercase();
+                if name.is_empty() {
+                    return None;
+                }
+                let q = parts
+                    .filter_map(|param| param.trim().strip_prefix("q="))
+                    .find_map(|q| q.trim().parse::<f32>().ok())
+                    .unwrap_or(1.0);
+                Some((name, q))
+            })
             .collect();
 
-        // Priority: br > zstd > gzip
-        #[cfg(feature = "brotli")]
-        if encodings.contains(&"br") {
-            return Self::Brotli;
-        }
-
-        #[cfg(feature = "zstd")]
-        if encodings.contains(&"zstd") {
-            return Self::Zstd;
-        }
-
-        #[cfg(feature = "gzip")]
-        if encodings.contains(&"gzip") {
-            return Self::Gzip;
+        let quality = |encoding: &str| {
+            entries
+                .iter()
+                .find(|(name, _)| name == encoding)
+                .or_else(|| entries.iter().find(|(name, _)| name == "*"))
+                .map_or(0.0, |(_, q)| *q)
+        };
+
+        let mut best = Self::None;
+        let mut best_q = 0.0;
+        for algorithm in Self::preference_order() {
+            if let Some(name) = algorithm.encoding_name() {
+                let q = quality(name);
+                if q > best_q {
+                    best = *algorithm;
+                    best_q = q;
+                }
+            }
         }
+        best
+    }
 
-        Self::None
+    /// Available algorithms, most preferred first
+    fn preference_order() -> &'static [Self] {
+        &[
+            #[cfg(feature = "brotli")]
+            Self::Brotli,
+            #[cfg(feature = "zstd")]
+            Self::Zstd,
+            #[cfg(feature = "gzip")]
+            Self::Gzip,
+            #[cfg(feature = "gzip")]
+            Self::Deflate,
+        ]
     }
 
     /// Get the minimum compression level for this algorithm
     pub fn min_level(&self) -> u32 {
         match self {
             #[cfg(feature = "gzip")]
-            Self::Gzip => 1,
+            Self::Gzip | Self::Deflate => 1,
             #[cfg(feature = "brotli")]
             Self::Brotli => 0,
             #[cfg(feature = "zstd")]
@@ -99,7 +137,7 @@ impl CompressionAlgorithm {
     pub fn max_level(&self) -> u32 {
         match self {
             #[cfg(feature = "gzip")]
-            Self::Gzip => 9,
+            Self::Gzip | Self::Deflate => 9,
             #[cfg(feature = "brotli")]
             Self::Brotli => 11,
             #[cfg(feature = "zstd")]
@@ -112,7 +150,7 @@ impl CompressionAlgorithm {
     pub fn default_level(&self) -> u32 {
         match self {
             #[cfg(feature = "gzip")]
-            Self::Gzip => 6,
+            Self::Gzip | Self::Deflate => 6,
             #[cfg(feature = "brotli")]
             Self::Brotli => 4,
             #[cfg(feature = "zstd")]
@@ -126,6 +164,8 @@ impl CompressionAlgorithm {
         match self {
             #[cfg(feature = "gzip")]
             Self::Gzip => compress_gzip(data, level),
+            #[cfg(feature = "gzip")]
+            Self::Deflate => compress_deflate(data, level),
             #[cfg(feature = "brotli")]
             Self::Brotli => compress_brotli(data, level),
             #[cfg(feature = "zstd")]
@@ -146,6 +186,8 @@ impl std::fmt::Display for CompressionAlgorithm {
             Self::Auto => write!(f, "auto"),
             #[cfg(feature = "gzip")]
             Self::Gzip => write!(f, "gzip"),
+            #[cfg(feature = "gzip")]
+            Self::Deflate => write!(f, "deflate"),
             #[cfg(feature = "brotli")]
             Self::Brotli => write!(f, "brotli"),
             #[cfg(feature = "zstd")]
@@ -171,6 +213,20 @@ fn compress_gzip(data: &[u8], level: u32) -> Result<Vec<u8>> {
         .map_err(|e| CompressionError::CompressionFailed(e.to_string()))
 }
 
+#[cfg(feature = "gzip")]
+fn compress_deflate(data: &[u8], level: u32) -> Result<Vec<u8>> {
+    use flate2::Compression;
+    use flate2::write::ZlibEncoder;
+
+    let mut encoder = ZlibEncoder::new(Vec::new(), Compression::new(level));
+    encoder
+        .write_all(data)
+        .map_err(|e| CompressionError::CompressionFailed(e.to_string()))?;
+    encoder
+        .finish()
+        .map_err(|e| CompressionError::CompressionFailed(e.to_string()))
+}
+
 // ========== Brotli Implementation ==========
 
 #[cfg(feature = "brotli")]
@@ -225,6 +281,12 @@ mod tests {
         #[cfg(feature = "gzip")]
         assert_eq!(CompressionAlgorithm::Gzip.encoding_name(), Some("gzip"));
 
+        #[cfg(feature = "gzip")]
+        assert_eq!(
+            CompressionAlgorithm::Deflate.encoding_name(),
+            Some("deflate")
+        );
+
         #[cfg(feature = "brotli")]
         assert_eq!(CompressionAlgorithm::Brotli.encoding_name(), Some("br"));
 
@@ -249,8 +311,46 @@ mod tests {
         }
 
         // Test no match
+        let algo = CompressionAlgorithm::select_from_accept_encoding("compress");
+        assert_eq!(algo, CompressionAlgorithm::None);
+    }
+
+    #[cfg(feature = "gzip")]
+    #[test]
+    fn test_select_from_accept_encoding_quality() {
+        // Deflate is used when it is the only supported encoding
         let algo = CompressionAlgorithm::select_from_accept_encoding("deflate");
+        assert_eq!(algo, CompressionAlgorithm::Deflate);
+
+        // Higher quality wins over server preference
+        let algo = CompressionAlgorithm::select_from_accept_encoding("gzip;q=0.5, deflate");
+        assert_eq!(algo, CompressionAlgorithm::Deflate);
+
+        // q=0 refuses an encoding
+        let algo = CompressionAlgorithm::select_from_accept_encoding("gzip;q=0");
         assert_eq!(algo, CompressionAlgorithm::None);
+
+        // Wildcards match unlisted encodings
+        let algo = CompressionAlgorithm::select_from_accept_encoding("*;q=0.1, gzip;q=0");
+        assert_ne!(algo, CompressionAlgorithm::Gzip);
+        assert_ne!(algo, CompressionAlgorithm::None);
+
+        // Header names are case-insensitive
+        let algo = CompressionAlgorithm::select_from_accept_encoding("GZIP");
+        assert_eq!(algo, CompressionAlgorithm::Gzip);
+    }
+
+    #[cfg(feature = "gzip")]
+    #[test]
+    fn test_deflate_compression() {
+        let data = b"Hello, World! This is a test string for compression.";
+        let compressed = CompressionAlgorithm::Deflate.compress(data, 6).unwrap();
+
+        use flate2::read::ZlibDecoder;
+        let mut decoder = ZlibDecoder::new(&compressed[..]);
+        let mut decompressed = Vec::new();
+        decoder.read_to_end(&mut decompressed).unwrap();
+        assert_eq!(decompressed, data.to_vec());
     }
 
     #[cfg(feature = "gzip")]
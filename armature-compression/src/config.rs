@@ -47,11 +47,19 @@ impl CompressionConfig {
 
     /// Get the effective compression level for the configured algorithm
     pub fn effective_level(&self) -> u32 {
+        self.level_for(self.algorithm)
+    }
+
+    /// Get the compression level to use with a specific algorithm
+    ///
+    /// This is used when the algorithm is negotiated per request, since the
+    /// valid level range depends on the algorithm actually chosen.
+    pub fn level_for(&self, algorithm: CompressionAlgorithm) -> u32 {
         if self.level == 0 {
-            self.algorithm.default_level()
+            algorithm.default_level()
         } else {
             self.level
-                .clamp(self.algorithm.min_level(), self.algorithm.max_level())
+                .clamp(algorithm.min_level(), algorithm.max_level())
         }
     }
 
@@ -134,6 +142,13 @@ impl CompressionConfigBuilder {
         self
     }
 
+    /// Use deflate compression
+    #[cfg(feature = "gzip")]
+    pub fn deflate(mut self) -> Self {
+        self.config.algorithm = CompressionAlgorithm::Deflate;
+        self
+    }
+
     /// Use brotli compression
     #[cfg(feature = "brotli")]
     pub fn brotli(mut self) -> Self {
@@ -251,6 +266,16 @@ mod tests {
         assert_eq!(config.effective_level(), 9); // Max for gzip
     }
 
+    #[cfg(feature = "gzip")]
+    #[test]
+    fn test_level_for_negotiated_algorithm() {
+        let config = CompressionConfig::default();
+        assert_eq!(config.level_for(CompressionAlgorithm::Gzip), 6);
+
+        let config = CompressionConfig::builder().level(100).build();
+        assert_eq!(config.level_for(CompressionAlgorithm::Deflate), 9);
+    }
+
     #[cfg(feature = "brotli")]
     #[test]
     fn test_builder_brotli() {
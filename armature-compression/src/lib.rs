@@ -35,6 +35,10 @@
 //! 1. **Brotli** (`br`) - Best compression ratio, preferred for text content
 //! 2. **Zstd** (`zstd`) - Fast compression with good ratios
 //! 3. **Gzip** (`gzip`) - Most widely supported, good fallback
+//! 4. **Deflate** (`deflate`) - Legacy zlib encoding, available with `gzip`
+//!
+//! Quality values are honored, so `gzip;q=0` refuses gzip and a client
+//! preferring `deflate;q=1, gzip;q=0.5` receives deflate.
 //!
 //! # Content Types
 //!
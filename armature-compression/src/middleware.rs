@@ -1,8 +1,11 @@
 //! Compression middleware implementation
 
-use crate::{CompressionAlgorithm, CompressionConfig};
+use crate::{CompressionAlgorithm, CompressionConfig, StreamingCompressor, StreamingConfig};
+use armature_core::streaming::{ByteStream, ByteStreamSender};
 use armature_core::{Error, HttpRequest, HttpResponse, Middleware};
 use async_trait::async_trait;
+use bytes::Bytes;
+use futures_util::{FutureExt, StreamExt};
 use std::future::Future;
 use std::pin::Pin;
 
@@ -11,6 +14,11 @@ use std::pin::Pin;
 /// This middleware compresses HTTP response bodies based on the client's
 /// `Accept-Encoding` header and the configured compression algorithm.
 ///
+/// Streaming responses are compressed chunk by chunk, flushing the encoder
+/// after every chunk so that data written by the handler reaches the client
+/// without waiting for the stream to end. A stream that completes before
+/// reaching the minimum size is sent uncompressed.
+///
 /// # Example
 ///
 /// ```rust,no_run
@@ -63,15 +71,20 @@ impl CompressionMiddleware {
         }
     }
 
-    /// Check if a response should be compressed
-    fn should_compress(&self, response: &HttpResponse) -> bool {
-        // Don't compress error responses or empty bodies
-        if response.status >= 400 || response.body.is_empty() {
+    /// Check if a response is eligible for compression, ignoring its size
+    fn is_compressible(&self, response: &HttpResponse) -> bool {
+        // Don't compress error responses or responses that carry no
+        // (or only a partial) representation
+        if response.status >= 400 || matches!(response.status, 204 | 206 | 304) {
             return false;
         }
 
-        // Check size threshold
-        if !self.config.should_compress_size(response.body.len()) {
+        // Respect intermediaries being asked not to transform the body
+        if response
+            .headers
+            .get("Cache-Control")
+            .is_some_and(|value| value.to_ascii_lowercase().contains("no-transform"))
+        {
             return false;
         }
 
@@ -88,18 +101,33 @@ impl CompressionMiddleware {
         if let Some(content_type) = response.headers.get("Content-Type") {
             self.config.should_compress_content_type(content_type)
         } else {
-            // No content type header, compress by default for non-empty bodies
+            // No content type header, compress by default
             true
         }
     }
 
+    /// Check if a response should be compressed
+    fn should_compress(&self, response: &HttpResponse) -> bool {
+        // Don't compress empty bodies
+        if response.body.is_empty() {
+            return false;
+        }
+
+        // Check size threshold
+        if !self.config.should_compress_size(response.body.len()) {
+            return false;
+        }
+
+        self.is_compressible(response)
+    }
+
     /// Compress the response body
     fn compress_response(
         &self,
         mut response: HttpResponse,
         algorithm: CompressionAlgorithm,
     ) -> HttpResponse {
-        let level = self.config.effective_level();
+        let level = self.config.level_for(algorithm);
 
         match algorithm.compress(&response.body, level) {
             Ok(compressed) => {
@@ -114,20 +142,9 @@ impl CompressionMiddleware {
                             .insert("Content-Encoding".to_string(), encoding.to_string());
                     }
 
-                    // Update Content-Length
-                    response.headers.insert(
-                        "Content-Length".to_string(),
-                        response.body.len().to_string(),
-                    );
-
-                    // Add Vary header to indicate response varies by Accept-Encoding
-                    let vary = response.headers.entry("Vary".to_string()).or_default();
-                    if !vary.contains("Accept-Encoding") {
-                        if !vary.is_empty() {
-                            vary.push_str(", ");
-                        }
-                        vary.push_str("Accept-Encoding");
-                    }
+                    // The length is recomputed when the response is written
+                    response.headers.remove("Content-Length");
+                    add_vary(&mut response);
                 }
             }
             Err(e) => {
@@ -138,6 +155,148 @@ impl CompressionMiddleware {
 
         response
     }
+
+    /// Compress a streaming response body
+    ///
+    /// Chunks that are already available are read ahead until the minimum
+    /// size is reached. If the stream ends first, the collected bytes are
+    /// handled like a buffered body.
+    async fn compress_stream(
+        &self,
+        mut response: HttpResponse,
+        algorithm: CompressionAlgorithm,
+    ) -> HttpResponse {
+        let config = StreamingConfig::new()
+            .algorithm(algorithm)
+            .level(self.config.level_for(algorithm));
+        let mut compressor = match StreamingCompressor::new(config) {
+            Ok(compressor) => compressor,
+            Err(e) => {
+                tracing::warn!("Compression failed: {}", e);
+                return response;
+            }
+        };
+
+        let Some(mut source) = response.take_stream() else {
+            return response;
+        };
+
+        let mut prefix = Vec::new();
+        let mut size = 0;
+        let mut failure = None;
+        let mut ended = false;
+        let mut next = source.next().await;
+        loop {
+            match next {
+                Some(Ok(chunk)) => {
+                    size += chunk.len();
+                    prefix.push(chunk);
+                }
+                Some(Err(e)) => {
+                    failure = Some(e);
+                    break;
+                }
+                None => {
+                    ended = true;
+                    break;
+                }
+            }
+            if self.config.should_compress_size(size) {
+                break;
+            }
+            match source.next().now_or_never() {
+                Some(item) => next = item,
+                None => break,
+            }
+        }
+
+        if ended {
+            response.body = prefix.concat();
+            if !self.should_compress(&response) {
+                return response;
+            }
+            return self.compress_response(response, algorithm);
+        }
+
+        if let Some(encoding) = algorithm.encoding_name() {
+            response
+                .headers
+                .insert("Content-Encoding".to_string(), encoding.to_string());
+        }
+        response.headers.remove("Content-Length");
+        add_vary(&mut response);
+
+        let (stream, sender) = ByteStream::new();
+        tokio::spawn(async move {
+            for chunk in prefix {
+                if !send_compressed(&mut compressor, &sender, &chunk).await {
+                    return;
+                }
+            }
+            if let Some(e) = failure {
+                let _ = sender.send_error(e.to_string()).await;
+                return;
+            }
+            while let Some(item) = source.next().await {
+                match item {
+                    Ok(chunk) => {
+                        if !send_compressed(&mut compressor, &sender, &chunk).await {
+                            return;
+                        }
+                    }
+                    Err(e) => {
+                        let _ = sender.send_error(e.to_string()).await;
+                        return;
+                    }
+                }
+            }
+            match compressor.finish() {
+                Ok(tail) => {
+                    if !tail.is_empty() && sender.send_bytes(tail).await.is_err() {
+                        return;
+                    }
+                    sender.close().await;
+                }
+                Err(e) => {
+                    let _ = sender.send_error(e.to_string()).await;
+                }
+            }
+        });
+
+        response.with_stream(stream)
+    }
+}
+
+/// Add `Accept-Encoding` to the response's `Vary` header
+fn add_vary(response: &mut HttpResponse) {
+    let vary = response.headers.entry("Vary".to_string()).or_default();
+    if !vary.contains("Accept-Encoding") {
+        if !vary.is_empty() {
+            vary.push_str(", ");
+        }
+        vary.push_str("Accept-Encoding");
+    }
+}
+
+/// Compress a chunk and flush it to the client.
+///
+/// Returns `false` once the stream can no longer be written to.
+async fn send_compressed(
+    compressor: &mut StreamingCompressor,
+    sender: &ByteStreamSender,
+    chunk: &[u8],
+) -> bool {
+    let output = compressor
+        .compress_chunk(chunk)
+        .and_then(|head| Ok([head, compressor.flush()?].concat()));
+    match output {
+        Ok(output) if output.is_empty() => true,
+        Ok(output) => sender.send_bytes(Bytes::from(output)).await.is_ok(),
+        Err(e) => {
+            let _ = sender.send_error(e.to_string()).await;
+            false
+        }
+    }
 }
 
 impl Default for CompressionMiddleware {
@@ -160,20 +319,33 @@ impl Middleware for CompressionMiddleware {
         >,
     ) -> Result<HttpResponse, Error> {
         // Get Accept-Encoding header before passing request
-        let accept_encoding = req
-            .headers
-            .get("Accept-Encoding")
-            .or_else(|| req.headers.get("accept-encoding"))
-            .cloned();
+        let accept_encoding = req.header("Accept-Encoding").map(str::to_string);
 
         // Call the next handler
-        let response = next(req).await?;
+        let mut response = next(req).await?;
+
+        if !self.is_compressible(&response) {
+            return Ok(response);
+        }
+
+        // The representation now depends on Accept-Encoding, even when the
+        // client gets the identity encoding
+        if self.config.algorithm == CompressionAlgorithm::Auto {
+            add_vary(&mut response);
+        }
 
         // Determine compression algorithm
         let algorithm = self.select_algorithm(accept_encoding.as_deref());
+        if algorithm == CompressionAlgorithm::None {
+            return Ok(response);
+        }
+
+        if response.is_streaming() {
+            return Ok(self.compress_stream(response, algorithm).await);
+        }
 
         // Check if we should compress
-        if algorithm == CompressionAlgorithm::None || !self.should_compress(&response) {
+        if !self.should_compress(&response) {
             return Ok(response);
         }
 
@@ -324,4 +496,205 @@ mod tests {
         assert!(vary.contains("Origin"));
         assert!(vary.contains("Accept-Encoding"));
     }
+
+    fn request(accept_encoding: &str) -> HttpRequest {
+        let mut req = HttpRequest::new("GET".to_string(), "/".to_string());
+        req.headers
+            .insert("accept-encoding".to_string(), accept_encoding.to_string());
+        req
+    }
+
+    async fn run(
+        middleware: &CompressionMiddleware,
+        req: HttpRequest,
+        response: HttpResponse,
+    ) -> HttpResponse {
+        middleware
+            .handle(
+                req,
+                Box::new(move |_| Box::pin(async move { Ok(response) })),
+            )
+            .await
+            .unwrap()
+    }
+
+    async fn collect(mut response: HttpResponse) -> Vec<u8> {
+        let mut stream = response.take_stream().expect("streaming response");
+        let mut body = Vec::new();
+        while let Some(chunk) = stream.next().await {
+            body.extend_from_slice(&chunk.unwrap());
+        }
+        body
+    }
+
+    #[cfg(feature = "gzip")]
+    fn gunzip(data: &[u8]) -> Vec<u8> {
+        use std::io::Read;
+        let mut decoded = Vec::new();
+        flate2::read::GzDecoder::new(data)
+            .read_to_end(&mut decoded)
+            .unwrap();
+        decoded
+    }
+
+    #[cfg(feature = "gzip")]
+    #[tokio::test]
+    async fn test_handle_round_trip_removes_content_length() {
+        let middleware = CompressionMiddleware::new();
+        let body = "{\"message\": \"hello\"} ".repeat(100);
+
+        let response = run(
+            &middleware,
+            request("gzip"),
+            create_response(&body, "application/json"),
+        )
+        .await;
+
+        assert_eq!(
+            response.headers.get("Content-Encoding"),
+            Some(&"gzip".to_string())
+        );
+        assert_eq!(response.headers.get("Content-Length"), None);
+        assert_eq!(
+            response.headers.get("Vary"),
+            Some(&"Accept-Encoding".to_string())
+        );
+        assert_eq!(gunzip(&response.body), body.as_bytes());
+    }
+
+    #[cfg(feature = "gzip")]
+    #[tokio::test]
+    async fn test_handle_deflate() {
+        use std::io::Read;
+
+        let middleware = CompressionMiddleware::new();
+        let body = "Hello, World! ".repeat(100);
+
+        let response = run(
+            &middleware,
+            request("deflate, gzip;q=0.5"),
+            create_response(&body, "text/plain"),
+        )
+        .await;
+
+        assert_eq!(
+            response.headers.get("Content-Encoding"),
+            Some(&"deflate".to_string())
+        );
+        let mut decoded = Vec::new();
+        flate2::read::ZlibDecoder::new(&response.body[..])
+            .read_to_end(&mut decoded)
+            .unwrap();
+        assert_eq!(decoded, body.as_bytes());
+    }
+
+    #[tokio::test]
+    async fn test_handle_skips_ineligible_responses() {
+        let middleware = CompressionMiddleware::new();
+
+        // Refused encoding
+        let response = run(
+            &middleware,
+            request("gzip;q=0"),
+            create_response(&"x".repeat(1000), "text/plain"),
+        )
+        .await;
+        assert_eq!(response.headers.get("Content-Encoding"), None);
+        assert_eq!(
+            response.headers.get("Vary"),
+            Some(&"Accept-Encoding".to_string())
+        );
+
+        // Below the minimum size
+        let response = run(
+            &middleware,
+            request("gzip"),
+            create_response("small", "text/plain"),
+        )
+        .await;
+        assert_eq!(response.headers.get("Content-Encoding"), None);
+        assert_eq!(response.body, b"small");
+
+        // Already compressed content type
+        let response = run(
+            &middleware,
+            request("gzip"),
+            create_response(&"x".repeat(1000), "image/png"),
+        )
+        .await;
+        assert_eq!(response.headers.get("Content-Encoding"), None);
+        assert_eq!(response.headers.get("Vary"), None);
+
+        // Transformations are forbidden
+        let response = run(
+            &middleware,
+            request("gzip"),
+            create_response(&"x".repeat(1000), "text/plain")
+                .with_header("Cache-Control".to_string(), "no-transform".to_string()),
+        )
+        .await;
+        assert_eq!(response.headers.get("Content-Encoding"), None);
+
+        // Not modified
+        let mut not_modified = create_response(&"x".repeat(1000), "text/plain");
+        not_modified.status = 304;
+        let response = run(&middleware, request("gzip"), not_modified).await;
+        assert_eq!(response.headers.get("Content-Encoding"), None);
+    }
+
+    #[cfg(feature = "gzip")]
+    #[tokio::test]
+    async fn test_handle_streaming_flushes_chunks() {
+        let middleware =
+            CompressionMiddleware::with_config(CompressionConfig::builder().min_size(16).build());
+        let (stream, sender) = ByteStream::new();
+        let first = "first chunk of the streamed body\n".repeat(4);
+        sender.send(first.clone()).await.unwrap();
+
+        let response = HttpResponse::ok()
+            .with_header("Content-Type".to_string(), "text/plain".to_string())
+            .with_header("Content-Length".to_string(), "1000".to_string())
+            .with_stream(stream);
+        let mut response = run(&middleware, request("gzip"), response).await;
+
+        assert!(response.is_streaming());
+        assert_eq!(
+            response.headers.get("Content-Encoding"),
+            Some(&"gzip".to_string())
+        );
+        assert_eq!(response.headers.get("Content-Length"), None);
+
+        // The first chunk is flushed before the handler finishes the stream
+        let mut compressed = response.take_stream().unwrap();
+        let head = tokio::time::timeout(std::time::Duration::from_secs(1), compressed.next())
+            .await
+            .expect("first chunk was not flushed")
+            .unwrap()
+            .unwrap();
+        assert!(!head.is_empty());
+
+        sender.send("and the rest".to_string()).await.unwrap();
+        sender.close().await;
+
+        let mut body = head.to_vec();
+        body.extend(collect(HttpResponse::ok().with_stream(compressed)).await);
+        assert_eq!(gunzip(&body), format!("{first}and the rest").as_bytes());
+    }
+
+    #[tokio::test]
+    async fn test_handle_small_stream_not_compressed() {
+        let middleware = CompressionMiddleware::new();
+        let (stream, sender) = ByteStream::new();
+        sender.send("tiny".to_string()).await.unwrap();
+        sender.close().await;
+
+        let response = HttpResponse::ok()
+            .with_header("Content-Type".to_string(), "text/plain".to_string())
+            .with_stream(stream);
+        let response = run(&middleware, request("gzip"), response).await;
+
+        assert!(!response.is_streaming());
+        assert_eq!(response.headers.get("Content-Encoding"), None);
+        assert_eq!(response.body, b"tiny");
+    }
 }
@@ -34,7 +34,7 @@ use bytes::{Bytes, BytesMut};
 #[cfg(feature = "gzip")]
 use flate2::Compression as GzipCompression;
 #[cfg(feature = "gzip")]
-use flate2::write::GzEncoder;
+use flate2::write::{GzEncoder, ZlibEncoder};
 
 #[cfg(feature = "brotli")]
 use brotli::CompressorWriter as BrotliEncoder;
@@ -136,6 +136,8 @@ enum EncoderState {
     None,
     #[cfg(feature = "gzip")]
     Gzip(GzEncoder<Vec<u8>>),
+    #[cfg(feature = "gzip")]
+    Deflate(ZlibEncoder<Vec<u8>>),
     #[cfg(feature = "brotli")]
     Brotli(BrotliEncoder<Vec<u8>>),
     #[cfg(feature = "zstd")]
@@ -206,6 +208,16 @@ impl StreamingCompressor {
                 Ok(EncoderState::Gzip(encoder))
             }
 
+            #[cfg(feature = "gzip")]
+            CompressionAlgorithm::Deflate => {
+                let level = config.level.clamp(1, 9);
+                let encoder = ZlibEncoder::new(
+                    Vec::with_capacity(config.buffer_size),
+                    GzipCompression::new(level),
+                );
+                Ok(EncoderState::Deflate(encoder))
+            }
+
             #[cfg(feature = "brotli")]
             CompressionAlgorithm::Brotli => {
                 let level = config.level.clamp(0, 11);
@@ -240,6 +252,8 @@ impl StreamingCompressor {
             EncoderState::None => None,
             #[cfg(feature = "gzip")]
             EncoderState::Gzip(_) => Some("gzip"),
+            #[cfg(feature = "gzip")]
+            EncoderState::Deflate(_) => Some("deflate"),
             #[cfg(feature = "brotli")]
             EncoderState::Brotli(_) => Some("br"),
             #[cfg(feature = "zstd")]
@@ -285,6 +299,19 @@ impl StreamingCompressor {
                 }
             }
 
+            #[cfg(feature = "gzip")]
+            EncoderState::Deflate(encoder) => {
+                encoder
+                    .write_all(data)
+                    .map_err(|e| CompressionError::CompressionFailed(e.to_string()))?;
+
+                if self.unflushed_bytes >= self.config.flush_interval {
+                    self.flush_internal()
+                } else {
+                    Ok(Bytes::new())
+                }
+            }
+
             #[cfg(feature = "brotli")]
             EncoderState::Brotli(encoder) => {
                 encoder
@@ -338,6 +365,20 @@ impl StreamingCompressor {
                 Ok(Bytes::from(output))
             }
 
+            #[cfg(feature = "gzip")]
+            EncoderState::Deflate(encoder) => {
+                encoder
+                    .flush()
+                    .map_err(|e| CompressionError::CompressionFailed(e.to_string()))?;
+                let inner = encoder.get_mut();
+                if inner.is_empty() {
+                    return Ok(Bytes::new());
+                }
+                let output = std::mem::take(inner);
+                self.bytes_out += output.len() as u64;
+                Ok(Bytes::from(output))
+            }
+
             #[cfg(feature = "brotli")]
             EncoderState::Brotli(encoder) => {
                 encoder
@@ -387,6 +428,15 @@ impl StreamingCompressor {
                 Ok(Bytes::from(output))
             }
 
+            #[cfg(feature = "gzip")]
+            EncoderState::Deflate(encoder) => {
+                let output = encoder
+                    .finish()
+                    .map_err(|e| CompressionError::CompressionFailed(e.to_string()))?;
+                self.bytes_out += output.len() as u64;
+                Ok(Bytes::from(output))
+            }
+
             #[cfg(feature = "brotli")]
             EncoderState::Brotli(mut encoder) => {
                 encoder
@@ -1,16 +1,24 @@
 // Application bootstrapper and HTTP server
 
+use crate::body_limits::{LimitedBody, read_limited};
+use crate::error_hooks::{ErrorHooks, HookScope};
+use crate::json;
 use crate::logging::{debug, error, info, trace, warn};
 use crate::pipeline::{PipelineConfig, PipelineStats, PipelinedHttp1Builder};
+use crate::server_timeouts::{ConnectionTimer, RequestTimeouts, TimedBody, TimeoutStream};
+use crate::shutdown::{ServerState, ShutdownHandle, serve_connection};
+use crate::streaming::HyperBody;
 use crate::{
-    Container, Error, HttpRequest, HttpResponse, HttpsConfig, LifecycleManager, Module, Router,
-    TlsConfig,
+    BodyLimitConfig, Container, Error, HttpRequest, HttpResponse, HttpStatus, Https, HttpsConfig,
+    LifecycleManager, Module, RecoveredPanic, RemoteAddr, RequestInfo, Router, ServerTimeouts,
+    ShutdownSignals, TlsConfig, TrustedProxies,
 };
 use http_body_util::{BodyExt, Full};
-use hyper::server::conn::http1;
+use hyper::server::conn::{http1, http2};
 use hyper::service::service_fn;
 use hyper::{Request, Response, body::Incoming as IncomingBody};
-use hyper_util::rt::TokioIo;
+use hyper_util::rt::{TokioExecutor, TokioIo, TokioTimer};
+use hyper_util::server::conn::auto;
 use std::net::SocketAddr;
 use std::sync::Arc;
 use tokio::net::TcpListener;
@@ -25,6 +33,18 @@ pub struct Application {
     pipeline_config: PipelineConfig,
     /// Shared pipeline statistics
     pipeline_stats: Arc<PipelineStats>,
+    /// Graceful shutdown coordination for the listeners
+    shutdown: ShutdownHandle,
+    /// Request body limits, enforced while bodies are read
+    body_limit: Option<Arc<BodyLimitConfig>>,
+    /// Accept cleartext HTTP/2 (h2c) on plain HTTP listeners
+    h2c: bool,
+    /// Proxies whose forwarding headers determine the client address
+    trusted_proxies: Option<Arc<TrustedProxies>>,
+    /// Hooks notified of request errors and recovered panics
+    error_hooks: Option<Arc<ErrorHooks>>,
+    /// Limits on slow clients, applied to every connection
+    server_timeouts: ServerTimeouts,
 }
 
 impl Application {
@@ -36,6 +56,12 @@ impl Application {
             lifecycle: Arc::new(LifecycleManager::new()),
             pipeline_config: PipelineConfig::default(),
             pipeline_stats: Arc::new(PipelineStats::new()),
+            shutdown: ShutdownHandle::new(),
+            body_limit: None,
+            h2c: false,
+            trusted_proxies: None,
+            error_hooks: None,
+            server_timeouts: ServerTimeouts::default(),
         }
     }
 
@@ -54,6 +80,165 @@ impl Application {
         self
     }
 
+    /// Limit the size of request bodies
+    ///
+    /// Bodies are checked while they are read from the connection, before
+    /// middleware or handlers run: a `Content-Length` above the limit is
+    /// rejected immediately and chunked bodies are cut off once they exceed
+    /// it. Oversized requests receive `413 Payload Too Large`. Per-route
+    /// limits set with [`BodyLimitConfig::route_limit`] override the default.
+    ///
+    /// # Example
+    ///
+    /// ```rust,ignore
+    /// use armature_core::{Application, BodyLimitConfig};
+    ///
+    /// let app = Application::new(container, router).with_body_limit(
+    ///     BodyLimitConfig::new()
+    ///         .default_limit_kb(64)
+    ///         .route_limit_mb("/upload", 50),
+    /// );
+    /// ```
+    pub fn with_body_limit(mut self, config: BodyLimitConfig) -> Self {
+        self.body_limit = Some(Arc::new(config));
+        self
+    }
+
+    /// Trust forwarding headers from these reverse proxies
+    ///
+    /// [`HttpRequest::client_ip`] returns the peer address unless the peer
+    /// is one of `proxies`, in which case the client address is taken from
+    /// the forwarding header chosen with [`TrustedProxies::header`]. Without
+    /// this, forwarding headers are never trusted.
+    ///
+    /// # Example
+    ///
+    /// ```rust,ignore
+    /// use armature_core::{Application, TrustedProxies};
+    ///
+    /// let app = Application::new(container, router)
+    ///     .with_trusted_proxies(TrustedProxies::new().trust("10.0.0.0/8")?);
+    /// ```
+    pub fn with_trusted_proxies(mut self, proxies: TrustedProxies) -> Self {
+        self.trusted_proxies = Some(Arc::new(proxies));
+        self
+    }
+
+    /// Call `hook` with every error a request fails with
+    ///
+    /// Hooks run in registration order before the error response is
+    /// written, and see errors of every status. Use them to forward failures
+    /// to error tracking; a hook that panics is logged and skipped. See
+    /// [`error_hooks`](crate::error_hooks).
+    ///
+    /// # Example
+    ///
+    /// ```rust,ignore
+    /// let app = Application::new(container, router).on_error(|info, err| {
+    ///     if err.status_code() >= 500 {
+    ///         sentry::capture_error(err);
+    ///     }
+    /// });
+    /// ```
+    pub fn on_error<F>(mut self, hook: F) -> Self
+    where
+        F: Fn(&RequestInfo, &Error) + Send + Sync + 'static,
+    {
+        Arc::make_mut(self.error_hooks.get_or_insert_default())
+            .errors
+            .push(Arc::new(hook));
+        self
+    }
+
+    /// Call `hook` with every panic caught by
+    /// [`RecoverMiddleware`](crate::recover::RecoverMiddleware)
+    ///
+    /// Hooks run in registration order before the 500 response is written.
+    /// The error that replaces the panic is not passed to the
+    /// [`on_error`](Self::on_error) hooks as well.
+    pub fn on_panic<F>(mut self, hook: F) -> Self
+    where
+        F: Fn(&RequestInfo, &RecoveredPanic) + Send + Sync + 'static,
+    {
+        Arc::make_mut(self.error_hooks.get_or_insert_default())
+            .panics
+            .push(Arc::new(hook));
+        self
+    }
+
+    /// Limit how long clients may take to send requests and read responses
+    ///
+    /// Connections that are idle, send their request too slowly or don't
+    /// read their response in time are closed. Every application applies
+    /// [`ServerTimeouts::default`] unless this replaces it; see
+    /// [`server_timeouts`](crate::server_timeouts) for what each limit covers
+    /// and how a handler lifts the write timeout for a long-lived response.
+    ///
+    /// # Example
+    ///
+    /// ```rust,ignore
+    /// use armature_core::{Application, ServerTimeouts};
+    /// use std::time::Duration;
+    ///
+    /// let app = Application::new(container, router).with_server_timeouts(
+    ///     ServerTimeouts::default()
+    ///         .read_timeout(Duration::from_secs(300))
+    ///         .idle_timeout(Duration::from_secs(30)),
+    /// );
+    /// ```
+    pub fn with_server_timeouts(mut self, timeouts: ServerTimeouts) -> Self {
+        self.server_timeouts = timeouts;
+        self
+    }
+
+    /// Accept cleartext HTTP/2 (h2c) on plain HTTP listeners
+    ///
+    /// With this enabled, [`listen`](Self::listen) detects the HTTP/2
+    /// connection preface and serves such connections as HTTP/2, while
+    /// HTTP/1.1 clients keep working on the same port. This suits
+    /// deployments behind a proxy that terminates TLS and forwards HTTP/2
+    /// with prior knowledge (for example gRPC-web through Envoy). The
+    /// HTTP/1.1 `Upgrade: h2c` handshake is not supported.
+    ///
+    /// HTTPS listeners always offer HTTP/2 through ALPN and don't need this.
+    ///
+    /// # Example
+    ///
+    /// ```rust,ignore
+    /// let app = Application::new(container, router).with_h2c(true);
+    /// app.listen(8080).await?;
+    /// ```
+    pub fn with_h2c(mut self, enabled: bool) -> Self {
+        self.h2c = enabled;
+        self
+    }
+
+    /// Use a custom JSON encoder for response bodies
+    ///
+    /// `HttpResponse::json` responses built while this application handles
+    /// a request are serialized with the encoder; other applications in the
+    /// process keep their own. See [`Router::json_encoder`].
+    ///
+    /// # Example
+    ///
+    /// ```rust,ignore
+    /// use armature_core::{Application, json};
+    ///
+    /// struct SonicEncoder;
+    ///
+    /// impl json::JsonEncoder for SonicEncoder {
+    ///     fn encode(&self, value: &dyn erased_serde::Serialize) -> json::Result<Vec<u8>> {
+    ///         sonic_rs::to_vec(value).map_err(|e| json::JsonError::new(e.to_string()))
+    ///     }
+    /// }
+    ///
+    /// let app = Application::new(container, router).with_json_encoder(SonicEncoder);
+    /// ```
+    pub fn with_json_encoder<E: json::JsonEncoder + 'static>(mut self, encoder: E) -> Self {
+        Arc::make_mut(&mut self.router).json_encoder(encoder);
+        self
+    }
+
     /// Get the pipeline statistics
     ///
     /// Use this to monitor pipeline performance at runtime.
@@ -124,6 +309,12 @@ impl Application {
             lifecycle,
             pipeline_config: PipelineConfig::default(),
             pipeline_stats: Arc::new(PipelineStats::new()),
+            shutdown: ShutdownHandle::new(),
+            body_limit: None,
+            h2c: false,
+            trusted_proxies: None,
+            error_hooks: None,
+            server_timeouts: ServerTimeouts::default(),
         }
     }
 
@@ -132,57 +323,31 @@ impl Application {
         &self.lifecycle
     }
 
+    /// Get a handle for gracefully stopping the server
+    ///
+    /// Take the handle before calling `listen`, since listening consumes the
+    /// application. See [`ShutdownHandle::shutdown`].
+    pub fn shutdown_handle(&self) -> ShutdownHandle {
+        self.shutdown.clone()
+    }
+
+    /// Register a hook to run when the server shuts down
+    ///
+    /// Shorthand for [`ShutdownHandle::on_shutdown`].
+    pub fn on_shutdown<F, Fut>(&self, hook: F)
+    where
+        F: Fn() -> Fut + Send + Sync + 'static,
+        Fut: std::future::Future<Output = Result<(), Error>> + Send + 'static,
+    {
+        self.shutdown.on_shutdown(hook);
+    }
+
     /// Gracefully shutdown the application
     pub async fn shutdown(
         &self,
         signal: Option<String>,
     ) -> Result<(), Box<dyn std::error::Error + Send + Sync>> {
-        info!(signal = ?signal, "Gracefully shutting down application");
-
-        // Call before shutdown hooks
-        debug!("Calling BeforeApplicationShutdown hooks");
-        if let Err(errors) = self
-            .lifecycle
-            .call_before_shutdown_hooks(signal.clone())
-            .await
-        {
-            warn!(
-                error_count = errors.len(),
-                "Some before shutdown hooks failed"
-            );
-            for (name, error) in errors {
-                error!(hook_name = %name, error = %error, "Before shutdown hook failed");
-            }
-        } else {
-            debug!("All BeforeApplicationShutdown hooks completed successfully");
-        }
-
-        // Call shutdown hooks
-        debug!("Calling OnApplicationShutdown hooks");
-        if let Err(errors) = self.lifecycle.call_shutdown_hooks(signal.clone()).await {
-            warn!(error_count = errors.len(), "Some shutdown hooks failed");
-            for (name, error) in errors {
-                error!(hook_name = %name, error = %error, "Shutdown hook failed");
-            }
-        } else {
-            debug!("All OnApplicationShutdown hooks completed successfully");
-        }
-
-        // Call module destroy hooks
-        debug!("Calling OnModuleDestroy hooks");
-        if let Err(errors) = self.lifecycle.call_module_destroy_hooks().await {
-            warn!(
-                error_count = errors.len(),
-                "Some module destroy hooks failed"
-            );
-            for (name, error) in errors {
-                error!(hook_name = %name, error = %error, "Module destroy hook failed");
-            }
-        } else {
-            debug!("All OnModuleDestroy hooks completed successfully");
-        }
-
-        info!("Application shutdown complete");
+        run_lifecycle_shutdown(&self.lifecycle, signal).await;
         Ok(())
     }
 
@@ -377,6 +542,16 @@ impl Application {
         debug!(address = %addr, "Binding to address");
         let listener = TcpListener::bind(addr).await?;
 
+        self.serve(listener).await
+    }
+
+    /// Serve HTTP on an already bound listener
+    ///
+    /// Returns once a shutdown requested through the
+    /// [`shutdown_handle`](Self::shutdown_handle) has completed.
+    pub(crate) async fn serve<L: Listener>(self, listener: L) -> Result<(), Error> {
+        let addr = listener.local_addr()?;
+
         info!(
             address = %addr,
             pipeline_mode = ?self.pipeline_config.mode,
@@ -386,50 +561,170 @@ impl Application {
         );
 
         let router = self.router.clone();
+        let body_limit = self.body_limit.clone();
+        let trusted_proxies = self.trusted_proxies.clone();
+        let error_hooks = self.error_hooks.clone();
         let pipeline_builder = PipelinedHttp1Builder::with_stats(
             self.pipeline_config.clone(),
             Arc::clone(&self.pipeline_stats),
         );
         let pipeline_stats = Arc::clone(&self.pipeline_stats);
+        let mut state = self.shutdown.subscribe();
 
         loop {
-            let (stream, client_addr) = listener.accept().await?;
-            trace!(client_address = %client_addr, "Connection accepted");
+            let (stream, client_addr) = tokio::select! {
+                accepted = listener.accept() => accepted?,
+                _ = state.wait_for(|s| *s != ServerState::Running) => break,
+            };
+            trace!(client_address = ?client_addr, "Connection accepted");
 
             // Apply TCP_NODELAY if configured
             if pipeline_builder.config().tcp_nodelay
-                && let Err(e) = stream.set_nodelay(true)
+                && let Err(e) = L::set_nodelay(&stream)
             {
                 trace!(error = %e, "Failed to set TCP_NODELAY");
             }
 
-            let io = TokioIo::new(stream);
             let router = router.clone();
-            let http_builder = pipeline_builder.configure_hyper_builder();
+            let body_limit = body_limit.clone();
+            let trusted_proxies = trusted_proxies.clone();
+            let error_hooks = error_hooks.clone();
+            let timer = ConnectionTimer::new(self.server_timeouts);
+            let protocol = if self.h2c {
+                let mut builder = auto::Builder::new(TokioExecutor::new());
+                pipeline_builder.configure_auto_builder(&mut builder);
+                ConnProtocol::Detect(builder)
+            } else {
+                ConnProtocol::Http1(pipeline_builder.configure_hyper_builder())
+            };
             let stats = Arc::clone(&pipeline_stats);
+            let connection = self.shutdown.track();
+            let state = self.shutdown.subscribe();
 
             // Track connection
             stats.connection_opened();
 
             tokio::spawn(async move {
+                let _connection = connection;
                 let stats_for_close = Arc::clone(&stats);
-                let service = service_fn(move |req: Request<IncomingBody>| {
+                let service_timer = Arc::clone(&timer);
+                let service = service_fn(move |mut req: Request<IncomingBody>| {
                     let router = router.clone();
+                    let body_limit = body_limit.clone();
+                    let trusted_proxies = trusted_proxies.clone();
+                    let error_hooks = error_hooks.clone();
                     let stats = Arc::clone(&stats);
+                    let timeouts = service_timer.start_request(req.version());
                     async move {
                         stats.request_processed();
-                        handle_request(req, router).await
+                        if let Some(client_addr) = client_addr {
+                            req.extensions_mut().insert(RemoteAddr(client_addr));
+                        }
+                        req.extensions_mut().insert(timeouts.clone());
+                        let response =
+                            handle_request(req, router, body_limit, trusted_proxies, error_hooks)
+                                .await?;
+                        Ok::<_, hyper::Error>(timeouts.respond(response))
                     }
                 });
 
-                if let Err(err) = http_builder.serve_connection(io, service).await {
-                    error!(error = %err, client = %client_addr, "Error serving connection");
+                if let Err(err) = serve_http(stream, protocol, service, timer, state).await {
+                    error!(error = %err, client = ?client_addr, "Error serving connection");
                 }
 
                 // Track connection close
                 stats_for_close.connection_closed();
             });
         }
+
+        info!(address = %addr, "HTTP server stopped accepting connections");
+        drop(listener);
+        self.shutdown.stopped().await;
+        Ok(())
+    }
+
+    /// Start the HTTP server and shut it down gracefully on a signal
+    ///
+    /// Serves like [`listen`](Self::listen) until one of the configured
+    /// signals arrives, then stops accepting connections and waits up to the
+    /// drain timeout for in-flight requests, as
+    /// [`ShutdownHandle::shutdown`] does. A second signal while draining
+    /// closes the remaining connections at once. Lifecycle shutdown hooks
+    /// run afterwards with the signal name, e.g. `SIGTERM`.
+    ///
+    /// Returns `Ok(())` after a clean shutdown, the server's error if it
+    /// fails, and [`Error::ShutdownTimeout`] if connections had to be
+    /// closed.
+    ///
+    /// # Example
+    ///
+    /// ```rust,ignore
+    /// use armature_core::{Application, ShutdownSignals};
+    /// use std::time::Duration;
+    ///
+    /// let app = Application::new(container, router);
+    /// app.listen_with_signals(
+    ///     8080,
+    ///     ShutdownSignals::new().drain_timeout(Duration::from_secs(10)),
+    /// )
+    /// .await?;
+    /// ```
+    pub async fn listen_with_signals(
+        self,
+        port: u16,
+        signals: ShutdownSignals,
+    ) -> Result<(), Error> {
+        let addr = SocketAddr::from(([0, 0, 0, 0], port));
+
+        debug!(address = %addr, "Binding to address");
+        let listener = TcpListener::bind(addr).await?;
+        let received = signals.install()?;
+
+        self.serve_until_signalled(listener, received, signals.drain_timeout_duration())
+            .await
+    }
+
+    /// Serve on `listener` until a signal name arrives on `signals`
+    async fn serve_until_signalled(
+        self,
+        listener: TcpListener,
+        mut signals: tokio::sync::mpsc::UnboundedReceiver<String>,
+        drain_timeout: std::time::Duration,
+    ) -> Result<(), Error> {
+        let handle = self.shutdown.clone();
+        let lifecycle = Arc::clone(&self.lifecycle);
+        let mut server = tokio::spawn(self.serve(listener));
+
+        let signal = tokio::select! {
+            result = &mut server => return join_server(result),
+            signal = signals.recv() => match signal {
+                Some(signal) => signal,
+                // No more signals can arrive, so serve until stopped
+                None => return join_server(server.await),
+            },
+        };
+        info!(signal = %signal, "Received signal, shutting down gracefully");
+
+        let drained = tokio::select! {
+            result = handle.shutdown(drain_timeout) => result,
+            Some(again) = signals.recv() => {
+                let remaining = handle.active_connections();
+                warn!(
+                    signal = %again,
+                    active_connections = remaining,
+                    "Received second signal, closing remaining connections"
+                );
+                handle.force_stop();
+                Err(Error::ShutdownTimeout(format!(
+                    "{} connection(s) still active when {} was received again",
+                    remaining, again
+                )))
+            }
+        };
+
+        join_server(server.await)?;
+        run_lifecycle_shutdown(&lifecycle, Some(signal)).await;
+        drained
     }
 
     /// Start the HTTPS server with TLS
@@ -459,6 +754,36 @@ impl Application {
         debug!(address = %addr, "Binding to address (HTTPS)");
         let listener = TcpListener::bind(addr).await?;
 
+        self.serve_tls(listener, tls_config).await
+    }
+
+    /// Start the HTTPS server with a certificate and key from PEM files
+    ///
+    /// Shorthand for [`TlsConfig::from_pem_files`] followed by
+    /// [`listen_https`](Self::listen_https).
+    ///
+    /// # Example
+    ///
+    /// ```rust,ignore
+    /// app.listen_tls(443, "cert.pem", "key.pem").await?;
+    /// ```
+    pub async fn listen_tls(
+        self,
+        port: u16,
+        cert_path: impl AsRef<std::path::Path>,
+        key_path: impl AsRef<std::path::Path>,
+    ) -> Result<(), Error> {
+        let tls_config = TlsConfig::from_pem_files(cert_path, key_path)?;
+        self.listen_https(port, tls_config).await
+    }
+
+    /// Serve HTTPS on an already bound listener
+    ///
+    /// Connections that negotiate `h2` through ALPN are served as HTTP/2,
+    /// all others as HTTP/1.1.
+    async fn serve_tls(self, listener: TcpListener, tls_config: TlsConfig) -> Result<(), Error> {
+        let addr = listener.local_addr()?;
+
         info!(
             address = %addr,
             pipeline_mode = ?self.pipeline_config.mode,
@@ -468,14 +793,21 @@ impl Application {
 
         let acceptor = TlsAcceptor::from(tls_config.server_config);
         let router = self.router.clone();
+        let body_limit = self.body_limit.clone();
+        let trusted_proxies = self.trusted_proxies.clone();
+        let error_hooks = self.error_hooks.clone();
         let pipeline_builder = PipelinedHttp1Builder::with_stats(
             self.pipeline_config.clone(),
             Arc::clone(&self.pipeline_stats),
         );
         let pipeline_stats = Arc::clone(&self.pipeline_stats);
+        let mut state = self.shutdown.subscribe();
 
         loop {
-            let (stream, client_addr) = listener.accept().await?;
+            let (stream, client_addr) = tokio::select! {
+                accepted = listener.accept() => accepted?,
+                _ = state.wait_for(|s| *s != ServerState::Running) => break,
+            };
             trace!(client_address = %client_addr, "HTTPS connection accepted");
 
             // Apply TCP_NODELAY if configured
@@ -487,29 +819,55 @@ impl Application {
 
             let acceptor = acceptor.clone();
             let router = router.clone();
-            let http_builder = pipeline_builder.configure_hyper_builder();
+            let body_limit = body_limit.clone();
+            let trusted_proxies = trusted_proxies.clone();
+            let error_hooks = error_hooks.clone();
+            let http1_builder = pipeline_builder.configure_hyper_builder();
             let stats = Arc::clone(&pipeline_stats);
+            let timeouts = self.server_timeouts;
+            let connection = self.shutdown.track();
+            let state = self.shutdown.subscribe();
 
             // Track connection
             stats.connection_opened();
 
             tokio::spawn(async move {
+                let _connection = connection;
                 let stats_for_close = Arc::clone(&stats);
-                match acceptor.accept(stream).await {
+                match accept_tls(&acceptor, stream, &timeouts).await {
                     Ok(tls_stream) => {
-                        debug!(client = %client_addr, "TLS handshake successful");
-                        let io = TokioIo::new(tls_stream);
+                        let protocol = negotiated_protocol(&tls_stream, http1_builder);
+                        debug!(client = %client_addr, protocol = %protocol, "TLS handshake successful");
+                        let timer = ConnectionTimer::new(timeouts);
 
-                        let service = service_fn(move |req: Request<IncomingBody>| {
+                        let service_timer = Arc::clone(&timer);
+                        let service = service_fn(move |mut req: Request<IncomingBody>| {
                             let router = router.clone();
+                            let body_limit = body_limit.clone();
+                            let trusted_proxies = trusted_proxies.clone();
+                            let error_hooks = error_hooks.clone();
                             let stats = Arc::clone(&stats);
+                            let timeouts = service_timer.start_request(req.version());
                             async move {
                                 stats.request_processed();
-                                handle_request(req, router).await
+                                req.extensions_mut().insert(RemoteAddr(client_addr));
+                                req.extensions_mut().insert(Https);
+                                req.extensions_mut().insert(timeouts.clone());
+                                let response = handle_request(
+                                    req,
+                                    router,
+                                    body_limit,
+                                    trusted_proxies,
+                                    error_hooks,
+                                )
+                                .await?;
+                                Ok::<_, hyper::Error>(timeouts.respond(response))
                             }
                         });
 
-                        if let Err(err) = http_builder.serve_connection(io, service).await {
+                        if let Err(err) =
+                            serve_http(tls_stream, protocol, service, timer, state).await
+                        {
                             error!(error = %err, client = %client_addr, "Error serving HTTPS connection");
                         }
                     }
@@ -522,6 +880,11 @@ impl Application {
                 stats_for_close.connection_closed();
             });
         }
+
+        info!(address = %addr, "HTTPS server stopped accepting connections");
+        drop(listener);
+        self.shutdown.stopped().await;
+        Ok(())
     }
 
     /// Start HTTPS server with optional HTTP to HTTPS redirect
@@ -545,6 +908,9 @@ impl Application {
     /// ```
     pub async fn listen_with_config(self, config: HttpsConfig) -> Result<(), Error> {
         let router = self.router.clone();
+        let body_limit = self.body_limit.clone();
+        let trusted_proxies = self.trusted_proxies.clone();
+        let error_hooks = self.error_hooks.clone();
 
         // Start HTTP redirect server if configured
         if let Some(ref http_addr) = config.http_redirect_addr {
@@ -577,23 +943,54 @@ impl Application {
         }
 
         let acceptor = TlsAcceptor::from(config.tls.server_config);
+        let mut state = self.shutdown.subscribe();
 
         loop {
-            let (stream, _) = listener.accept().await?;
+            let (stream, client_addr) = tokio::select! {
+                accepted = listener.accept() => accepted?,
+                _ = state.wait_for(|s| *s != ServerState::Running) => break,
+            };
             let acceptor = acceptor.clone();
             let router = router.clone();
+            let body_limit = body_limit.clone();
+            let trusted_proxies = trusted_proxies.clone();
+            let error_hooks = error_hooks.clone();
+            let timeouts = self.server_timeouts;
+            let connection = self.shutdown.track();
+            let state = self.shutdown.subscribe();
 
             tokio::spawn(async move {
-                match acceptor.accept(stream).await {
+                let _connection = connection;
+                match accept_tls(&acceptor, stream, &timeouts).await {
                     Ok(tls_stream) => {
-                        let io = TokioIo::new(tls_stream);
+                        let protocol = negotiated_protocol(&tls_stream, http1::Builder::new());
+                        let timer = ConnectionTimer::new(timeouts);
 
-                        let service = service_fn(move |req: Request<IncomingBody>| {
+                        let service_timer = Arc::clone(&timer);
+                        let service = service_fn(move |mut req: Request<IncomingBody>| {
                             let router = router.clone();
-                            async move { handle_request(req, router).await }
+                            let body_limit = body_limit.clone();
+                            let trusted_proxies = trusted_proxies.clone();
+                            let error_hooks = error_hooks.clone();
+                            let timeouts = service_timer.start_request(req.version());
+                            async move {
+                                req.extensions_mut().insert(RemoteAddr(client_addr));
+                                req.extensions_mut().insert(Https);
+                                req.extensions_mut().insert(timeouts.clone());
+                                let response = handle_request(
+                                    req,
+                                    router,
+                                    body_limit,
+                                    trusted_proxies,
+                                    error_hooks,
+                                )
+                                .await?;
+                                Ok::<_, hyper::Error>(timeouts.respond(response))
+                            }
                         });
 
-                        if let Err(err) = http1::Builder::new().serve_connection(io, service).await
+                        if let Err(err) =
+                            serve_http(tls_stream, protocol, service, timer, state).await
                         {
                             eprintln!("Error serving HTTPS connection: {:?}", err);
                         }
@@ -604,12 +1001,86 @@ impl Application {
                 }
             });
         }
+
+        drop(listener);
+        self.shutdown.stopped().await;
+        Ok(())
     }
 
     /// Get a reference to the DI container
     pub fn container(&self) -> &Container {
         &self.container
     }
+
+    /// Run a request through the application without binding a socket
+    ///
+    /// The request goes through the same path as one read from the network:
+    /// body limits, middleware, route matching and parameter extraction, and
+    /// error-to-response conversion. Streaming responses can be read from the
+    /// returned body as they are produced.
+    ///
+    /// # Example
+    ///
+    /// ```
+    /// use armature_core::{Application, Container, HttpRequest, HttpResponse, Router};
+    /// use http_body_util::BodyExt;
+    ///
+    /// # #[tokio::main]
+    /// # async fn main() -> Result<(), Box<dyn std::error::Error + Send + Sync>> {
+    /// let mut router = Router::new();
+    /// router.get("/users/:id", |req: HttpRequest| async move {
+    ///     let id = req.param("id").cloned().unwrap_or_default();
+    ///     Ok(HttpResponse::ok().with_body(id.into_bytes()))
+    /// });
+    /// let app = Application::new(Container::new(), router);
+    ///
+    /// let request = http::Request::get("/users/42").body("")?;
+    /// let response = app.test(request).await?;
+    /// assert_eq!(response.status(), 200);
+    ///
+    /// let body = response.into_body().collect().await?.to_bytes();
+    /// assert_eq!(body, "42");
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub async fn test<B>(&self, req: Request<B>) -> Result<Response<HyperBody>, Error>
+    where
+        B: Into<bytes::Bytes>,
+    {
+        let req = req.map(|body| Full::new(body.into()));
+        let response = handle_request(
+            req,
+            self.router.clone(),
+            self.body_limit.clone(),
+            self.trusted_proxies.clone(),
+            self.error_hooks.clone(),
+        )
+        .await;
+        Ok(response.unwrap_or_else(|never| match never {}))
+    }
+
+    /// Like [`test`](Self::test), but fail if the handler doesn't respond in
+    /// time
+    ///
+    /// The timeout covers producing the response head. Reading a streaming
+    /// body afterwards is up to the caller.
+    ///
+    /// Returns [`Error::RequestTimeout`] if the handler takes longer than
+    /// `timeout`.
+    pub async fn test_timeout<B>(
+        &self,
+        req: Request<B>,
+        timeout: std::time::Duration,
+    ) -> Result<Response<HyperBody>, Error>
+    where
+        B: Into<bytes::Bytes>,
+    {
+        tokio::time::timeout(timeout, self.test(req))
+            .await
+            .map_err(|_| {
+                Error::RequestTimeout(format!("handler did not respond within {:?}", timeout))
+            })?
+    }
 }
 
 /// Start HTTP server that redirects all requests to HTTPS
@@ -665,11 +1136,198 @@ async fn start_http_redirect_server(addr: &str, https_port: u16) -> Result<(), E
     }
 }
 
+/// Listener the plain HTTP server accepts connections from
+pub(crate) trait Listener: Send + 'static {
+    type Io: tokio::io::AsyncRead + tokio::io::AsyncWrite + Unpin + Send + 'static;
+
+    /// Wait for the next connection and the address of its peer, if it has one
+    fn accept(
+        &self,
+    ) -> impl Future<Output = std::io::Result<(Self::Io, Option<SocketAddr>)>> + Send;
+
+    /// Address to report in logs
+    fn local_addr(&self) -> std::io::Result<String>;
+
+    /// Disable Nagle's algorithm on sockets that use it
+    fn set_nodelay(_io: &Self::Io) -> std::io::Result<()> {
+        Ok(())
+    }
+}
+
+impl Listener for TcpListener {
+    type Io = tokio::net::TcpStream;
+
+    async fn accept(&self) -> std::io::Result<(Self::Io, Option<SocketAddr>)> {
+        let (stream, addr) = TcpListener::accept(self).await?;
+        Ok((stream, Some(addr)))
+    }
+
+    fn local_addr(&self) -> std::io::Result<String> {
+        Ok(TcpListener::local_addr(self)?.to_string())
+    }
+
+    fn set_nodelay(io: &Self::Io) -> std::io::Result<()> {
+        io.set_nodelay(true)
+    }
+}
+
+/// How a connection's HTTP version is chosen
+enum ConnProtocol {
+    /// HTTP/1.1, with upgrades such as WebSocket
+    Http1(http1::Builder),
+    /// HTTP/2, negotiated through ALPN
+    Http2,
+    /// HTTP/2 if the client opens with the HTTP/2 preface, else HTTP/1.1
+    Detect(auto::Builder<TokioExecutor>),
+}
+
+impl std::fmt::Display for ConnProtocol {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.write_str(match self {
+            ConnProtocol::Http1(_) => "http/1.1",
+            ConnProtocol::Http2 => "h2",
+            ConnProtocol::Detect(_) => "auto",
+        })
+    }
+}
+
+/// Pick HTTP/2 or HTTP/1.1 from the protocol agreed during the TLS handshake
+fn negotiated_protocol<IO>(
+    tls_stream: &tokio_rustls::server::TlsStream<IO>,
+    http1_builder: http1::Builder,
+) -> ConnProtocol {
+    if tls_stream.get_ref().1.alpn_protocol() == Some(b"h2") {
+        ConnProtocol::Http2
+    } else {
+        ConnProtocol::Http1(http1_builder)
+    }
+}
+
+/// Complete the TLS handshake within the read header timeout
+async fn accept_tls(
+    acceptor: &TlsAcceptor,
+    stream: tokio::net::TcpStream,
+    timeouts: &ServerTimeouts,
+) -> std::io::Result<tokio_rustls::server::TlsStream<tokio::net::TcpStream>> {
+    let handshake = acceptor.accept(stream);
+    match timeouts.read_header_timeout {
+        Some(timeout) => tokio::time::timeout(timeout, handshake)
+            .await
+            .map_err(|_| {
+                std::io::Error::new(std::io::ErrorKind::TimedOut, "TLS handshake timed out")
+            })?,
+        None => handshake.await,
+    }
+}
+
+/// Serve a connection with the chosen protocol until it closes or the server
+/// shuts down
+///
+/// Connections are subject to `timer`, and HTTP/2 ones to its keep-alive
+/// pings; one closed by a timeout is not an error.
+async fn serve_http<IO, S>(
+    io: IO,
+    protocol: ConnProtocol,
+    service: S,
+    timer: Arc<ConnectionTimer>,
+    state: tokio::sync::watch::Receiver<ServerState>,
+) -> Result<(), Box<dyn std::error::Error + Send + Sync>>
+where
+    IO: tokio::io::AsyncRead + tokio::io::AsyncWrite + Unpin + Send + 'static,
+    S: hyper::service::Service<Request<IncomingBody>, Response = Response<TimedBody<HyperBody>>>
+        + Send
+        + 'static,
+    S::Future: Send + 'static,
+    S::Error: Into<Box<dyn std::error::Error + Send + Sync>>,
+{
+    let timeouts = *timer.timeouts();
+    let io = TokioIo::new(TimeoutStream::new(io, timer));
+    let result: Result<(), Box<dyn std::error::Error + Send + Sync>> = match protocol {
+        ConnProtocol::Http1(builder) => {
+            let conn = builder.serve_connection(io, service).with_upgrades();
+            serve_connection(conn, state).await.map_err(Into::into)
+        }
+        ConnProtocol::Http2 => {
+            let mut builder = http2::Builder::new(TokioExecutor::new());
+            builder
+                .timer(TokioTimer::new())
+                .keep_alive_interval(timeouts.keep_alive_interval)
+                .keep_alive_timeout(timeouts.keep_alive_timeout);
+            let conn = builder.serve_connection(io, service);
+            serve_connection(conn, state).await.map_err(Into::into)
+        }
+        ConnProtocol::Detect(mut builder) => {
+            builder
+                .http2()
+                .timer(TokioTimer::new())
+                .keep_alive_interval(timeouts.keep_alive_interval)
+                .keep_alive_timeout(timeouts.keep_alive_timeout);
+            let conn = builder.serve_connection_with_upgrades(io, service);
+            serve_connection(conn, state).await
+        }
+    };
+    match result {
+        Err(err) if crate::server_timeouts::is_timeout(&*err) => {
+            debug!(error = %err, "Closed connection after a timeout");
+            Ok(())
+        }
+        result => result,
+    }
+}
+
+/// Convert a handler error into a JSON error response
+///
+/// This is the default for routers without an
+/// [`error_handler`](Router::error_handler). An [`HttpError`](crate::HttpError), even one
+/// wrapped in another error, supplies the status and message. Errors with no
+/// status of their own become a 500 whose details are logged but not sent to
+/// the client. Field-level validation failures are included as an `errors`
+/// array.
+pub fn error_response(err: &Error) -> HttpResponse {
+    let status = err.status_code();
+    let message = match err.http_error() {
+        Some(http) => http.message().to_string(),
+        None if status == 500 => {
+            error!(error = %error_chain(err), "Unhandled internal error");
+            HttpStatus::InternalServerError.reason().to_string()
+        }
+        None => err.to_string(),
+    };
+    let mut body = serde_json::json!({
+        "error": message,
+        "status": status,
+    });
+    if let Error::ValidationFailed(errors) = err {
+        body["errors"] = serde_json::to_value(errors.errors()).unwrap_or_default();
+    }
+    HttpResponse::new(status)
+        .with_json(&body)
+        .unwrap_or_else(|_| HttpResponse::internal_server_error())
+}
+
+/// An error and its sources, joined with `: `
+fn error_chain(err: &Error) -> String {
+    let mut chain = err.to_string();
+    let mut source = std::error::Error::source(err);
+    while let Some(err) = source {
+        chain.push_str(": ");
+        chain.push_str(&err.to_string());
+        source = err.source();
+    }
+    chain
+}
+
 /// Handle an incoming HTTP request
-async fn handle_request(
-    req: Request<IncomingBody>,
+async fn handle_request<B>(
+    mut req: Request<B>,
     router: Arc<Router>,
-) -> Result<Response<Full<bytes::Bytes>>, hyper::Error> {
+    body_limit: Option<Arc<BodyLimitConfig>>,
+    trusted_proxies: Option<Arc<TrustedProxies>>,
+    error_hooks: Option<Arc<ErrorHooks>>,
+) -> Result<Response<HyperBody>, B::Error>
+where
+    B: hyper::body::Body + Unpin,
+{
     use std::time::Instant;
 
     let start = Instant::now();
@@ -681,21 +1339,96 @@ async fn handle_request(
     trace!(method = %method, path = %path, "Incoming request");
 
     let mut armature_req = HttpRequest::new(method.clone(), path.clone());
+    if let Some(query) = req.uri().query() {
+        armature_req.set_query_string(query);
+    }
 
-    // Copy headers
+    // Copy headers, joining repeated ones into a single list value
     let header_count = req.headers().len();
     for (name, value) in req.headers() {
         if let Ok(value_str) = value.to_str() {
+            let separator = if name == hyper::header::COOKIE {
+                "; "
+            } else {
+                ", "
+            };
             armature_req
                 .headers
-                .insert(name.to_string(), value_str.to_string());
+                .entry(name.to_string())
+                .and_modify(|joined| {
+                    joined.push_str(separator);
+                    joined.push_str(value_str);
+                })
+                .or_insert_with(|| value_str.to_string());
         }
     }
     trace!(header_count = header_count, "Headers parsed");
 
+    if let Some(addr) = req.extensions().get::<RemoteAddr>() {
+        armature_req.extensions.insert(*addr);
+        if let Some(proxies) = &trusted_proxies {
+            if let Some(ip) = proxies.client_ip(&armature_req) {
+                armature_req
+                    .extensions
+                    .insert(crate::client_ip::ClientIp(ip));
+            }
+            if proxies.forwarded_https(&armature_req) {
+                armature_req.extensions.insert(Https);
+            }
+        }
+    }
+    if req.extensions().get::<Https>().is_some() {
+        armature_req.extensions.insert(Https);
+    }
+    let timeouts = req.extensions().get::<RequestTimeouts>().cloned();
+
+    // HTTP/2 carries the host in the :authority pseudo-header instead
+    if !req.headers().contains_key(hyper::header::HOST)
+        && let Some(authority) = req.uri().authority()
+    {
+        armature_req
+            .headers
+            .insert("host".to_string(), authority.to_string());
+    }
+
+    // Keep the connection upgradable for handlers such as WebSocket
+    if req.headers().contains_key(hyper::header::UPGRADE) {
+        let on_upgrade = hyper::upgrade::on(&mut req);
+        armature_req
+            .extensions
+            .insert(crate::websocket::PendingUpgrade::new(on_upgrade));
+    }
+
     // Read body into Bytes (zero-copy after this point)
-    let body_bytes = req.collect().await?.to_bytes();
+    let body_bytes = match body_limit {
+        None => req.collect().await?.to_bytes(),
+        Some(config) => {
+            let limit = config.get_limit_for_path(&path);
+            let content_length = req
+                .headers()
+                .get(hyper::header::CONTENT_LENGTH)
+                .and_then(|value| value.to_str().ok())
+                .and_then(|value| value.parse().ok());
+
+            match read_limited(req.into_body(), content_length, limit).await? {
+                LimitedBody::Complete(bytes) => bytes,
+                LimitedBody::TooLarge(size) => {
+                    warn!(method = %method, path = %path, size = size, limit = limit, "Request body too large");
+                    let err = Error::PayloadTooLarge(config.format_error(size, limit));
+                    // The rest of the body is never read, so the connection
+                    // can't be reused
+                    let response = error_response(&err)
+                        .with_header("Connection".to_string(), "close".to_string());
+                    return Ok(response.into_hyper_response());
+                }
+            }
+        }
+    };
     let body_size = body_bytes.len();
+    if let Some(timeouts) = timeouts {
+        timeouts.body_read();
+        armature_req.extensions.insert(timeouts);
+    }
 
     // Use zero-copy body storage
     if body_size > 0 {
@@ -703,8 +1436,16 @@ async fn handle_request(
         trace!(body_size = body_size, "Request body received (zero-copy)");
     }
 
+    let hooks = error_hooks.map(|hooks| {
+        let scope = HookScope::new(hooks, method.clone(), path.clone());
+        armature_req.extensions.insert(scope.clone());
+        scope
+    });
+
     // Route the request
     debug!(method = %method, path = %path, "Routing request");
+    // The error handler gets the request as it arrived, minus the body
+    let head = router.has_error_handler().then(|| armature_req.head());
     let response = match router.route(armature_req).await {
         Ok(resp) => {
             debug!(method = %method, path = %path, status = resp.status, "Request handled successfully");
@@ -712,15 +1453,10 @@ async fn handle_request(
         }
         Err(err) => {
             warn!(method = %method, path = %path, error = %err, "Request handling failed");
-            // Convert error to response
-            let status = err.status_code();
-            let body = serde_json::json!({
-                "error": err.to_string(),
-                "status": status,
-            });
-            HttpResponse::new(status)
-                .with_json(&body)
-                .unwrap_or_else(|_| HttpResponse::internal_server_error())
+            if let Some(hooks) = &hooks {
+                hooks.report_error(&err);
+            }
+            router.handle_error(head, err).await
         }
     };
 
@@ -734,13 +1470,992 @@ async fn handle_request(
     );
 
     // Convert our HttpResponse to hyper Response
-    let mut builder = Response::builder().status(response.status);
+    Ok(response.into_hyper_response())
+}
+
+/// Result of a spawned server task
+fn join_server(result: Result<Result<(), Error>, tokio::task::JoinError>) -> Result<(), Error> {
+    result.map_err(|err| Error::Internal(format!("server task failed: {}", err)))?
+}
+
+/// Run the lifecycle shutdown hooks, logging failures
+async fn run_lifecycle_shutdown(lifecycle: &LifecycleManager, signal: Option<String>) {
+    info!(signal = ?signal, "Gracefully shutting down application");
+
+    // Call before shutdown hooks
+    debug!("Calling BeforeApplicationShutdown hooks");
+    if let Err(errors) = lifecycle.call_before_shutdown_hooks(signal.clone()).await {
+        warn!(
+            error_count = errors.len(),
+            "Some before shutdown hooks failed"
+        );
+        for (name, error) in errors {
+            error!(hook_name = %name, error = %error, "Before shutdown hook failed");
+        }
+    } else {
+        debug!("All BeforeApplicationShutdown hooks completed successfully");
+    }
 
-    for (key, value) in &response.headers {
-        builder = builder.header(key, value);
+    // Call shutdown hooks
+    debug!("Calling OnApplicationShutdown hooks");
+    if let Err(errors) = lifecycle.call_shutdown_hooks(signal.clone()).await {
+        warn!(error_count = errors.len(), "Some shutdown hooks failed");
+        for (name, error) in errors {
+            error!(hook_name = %name, error = %error, "Shutdown hook failed");
+        }
+    } else {
+        debug!("All OnApplicationShutdown hooks completed successfully");
     }
 
-    // Zero-copy body passthrough to Hyper
-    let body = Full::new(response.into_body_bytes());
-    Ok(builder.body(body).unwrap())
+    // Call module destroy hooks
+    debug!("Calling OnModuleDestroy hooks");
+    if let Err(errors) = lifecycle.call_module_destroy_hooks().await {
+        warn!(
+            error_count = errors.len(),
+            "Some module destroy hooks failed"
+        );
+        for (name, error) in errors {
+            error!(hook_name = %name, error = %error, "Module destroy hook failed");
+        }
+    } else {
+        debug!("All OnModuleDestroy hooks completed successfully");
+    }
+
+    info!("Application shutdown complete");
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_error_response_json() {
+        let response = error_response(&Error::NotFound("user 7".to_string()));
+        assert_eq!(response.status, 404);
+
+        let body: serde_json::Value = serde_json::from_slice(response.body_ref()).unwrap();
+        assert_eq!(body["status"], 404);
+        assert!(body["error"].as_str().unwrap().contains("user 7"));
+        assert!(body.get("errors").is_none());
+    }
+
+    #[test]
+    fn test_error_response_includes_field_errors() {
+        let mut errors = crate::ValidationError::new();
+        errors
+            .add("email", "email", "must be an email")
+            .add("age", "min", "must be at least 18");
+
+        let response = error_response(&errors.into());
+        assert_eq!(response.status, 422);
+
+        let body: serde_json::Value = serde_json::from_slice(response.body_ref()).unwrap();
+        assert_eq!(body["errors"][0]["field"], "email");
+        assert_eq!(body["errors"][1]["rule"], "min");
+        assert_eq!(body["errors"][1]["message"], "must be at least 18");
+    }
+
+    #[test]
+    fn test_error_response_uses_http_error_message() {
+        let err = Error::from(
+            crate::HttpError::new(503, "search is down")
+                .with_source(std::io::Error::other("connection refused to 10.0.0.7")),
+        );
+        let response = error_response(&err);
+        assert_eq!(response.status, 503);
+
+        let body: serde_json::Value = serde_json::from_slice(response.body_ref()).unwrap();
+        assert_eq!(body["error"], "search is down");
+        assert!(!response.body_ref().windows(8).any(|w| w == b"10.0.0.7"));
+    }
+
+    #[test]
+    fn test_error_response_hides_internal_errors() {
+        for err in [
+            Error::Internal("password=hunter2".to_string()),
+            Error::other(std::io::Error::other("password=hunter2")),
+        ] {
+            let response = error_response(&err);
+            assert_eq!(response.status, 500);
+
+            let body: serde_json::Value = serde_json::from_slice(response.body_ref()).unwrap();
+            assert_eq!(body["error"], "Internal Server Error");
+            assert_eq!(body["status"], 500);
+        }
+    }
+
+    async fn start_server(
+        router: Router,
+    ) -> (
+        SocketAddr,
+        ShutdownHandle,
+        tokio::task::JoinHandle<Result<(), Error>>,
+    ) {
+        start_app(Application::new(Container::new(), router)).await
+    }
+
+    async fn start_app(
+        app: Application,
+    ) -> (
+        SocketAddr,
+        ShutdownHandle,
+        tokio::task::JoinHandle<Result<(), Error>>,
+    ) {
+        let handle = app.shutdown_handle();
+        let listener = TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let addr = listener.local_addr().unwrap();
+        let server = tokio::spawn(app.serve(listener));
+        (addr, handle, server)
+    }
+
+    async fn send_get(addr: SocketAddr, path: &str) -> tokio::net::TcpStream {
+        use tokio::io::AsyncWriteExt;
+
+        let mut stream = tokio::net::TcpStream::connect(addr).await.unwrap();
+        let request = format!("GET {} HTTP/1.1\r\nHost: localhost\r\n\r\n", path);
+        stream.write_all(request.as_bytes()).await.unwrap();
+        stream
+    }
+
+    async fn send_raw(addr: SocketAddr, request: &str) -> String {
+        use tokio::io::AsyncWriteExt;
+
+        let mut stream = tokio::net::TcpStream::connect(addr).await.unwrap();
+        stream.write_all(request.as_bytes()).await.unwrap();
+        read_to_string(&mut stream).await
+    }
+
+    async fn read_to_string(stream: &mut tokio::net::TcpStream) -> String {
+        use tokio::io::AsyncReadExt;
+
+        let mut response = Vec::new();
+        stream.read_to_end(&mut response).await.unwrap();
+        String::from_utf8_lossy(&response).into_owned()
+    }
+
+    #[tokio::test]
+    async fn test_shutdown_waits_for_in_flight_request() {
+        let (entered_tx, entered_rx) = tokio::sync::oneshot::channel::<()>();
+        let entered_tx = Arc::new(std::sync::Mutex::new(Some(entered_tx)));
+
+        let mut router = Router::new();
+        router.get("/slow", move |_req: HttpRequest| {
+            if let Some(tx) = entered_tx.lock().unwrap().take() {
+                let _ = tx.send(());
+            }
+            async {
+                tokio::time::sleep(std::time::Duration::from_millis(100)).await;
+                Ok(HttpResponse::ok().with_body(b"done".to_vec()))
+            }
+        });
+
+        let (addr, handle, server) = start_server(router).await;
+        let hook_ran = Arc::new(std::sync::atomic::AtomicBool::new(false));
+        let flag = hook_ran.clone();
+        handle.on_shutdown(move || {
+            let flag = flag.clone();
+            async move {
+                flag.store(true, std::sync::atomic::Ordering::SeqCst);
+                Ok(())
+            }
+        });
+
+        let mut client = send_get(addr, "/slow").await;
+        entered_rx.await.unwrap();
+        assert_eq!(handle.active_connections(), 1);
+
+        handle
+            .shutdown(std::time::Duration::from_secs(5))
+            .await
+            .unwrap();
+
+        let response = read_to_string(&mut client).await;
+        assert!(response.starts_with("HTTP/1.1 200"), "{}", response);
+        assert!(response.ends_with("done"), "{}", response);
+        assert!(hook_ran.load(std::sync::atomic::Ordering::SeqCst));
+
+        server.await.unwrap().unwrap();
+        assert!(tokio::net::TcpStream::connect(addr).await.is_err());
+    }
+
+    #[tokio::test]
+    async fn test_shutdown_closes_idle_connections() {
+        let mut router = Router::new();
+        router.get("/", |_req: HttpRequest| async { Ok(HttpResponse::ok()) });
+
+        let (addr, handle, server) = start_server(router).await;
+
+        // Completes one request, then sits idle on keep-alive
+        let mut client = send_get(addr, "/").await;
+        let mut buf = [0u8; 12];
+        tokio::io::AsyncReadExt::read_exact(&mut client, &mut buf)
+            .await
+            .unwrap();
+        assert_eq!(&buf, b"HTTP/1.1 200");
+
+        handle
+            .shutdown(std::time::Duration::from_secs(5))
+            .await
+            .unwrap();
+        read_to_string(&mut client).await;
+
+        server.await.unwrap().unwrap();
+    }
+
+    #[tokio::test]
+    async fn test_shutdown_deadline_closes_stuck_stream() {
+        let mut router = Router::new();
+        router.get("/stream", |_req: HttpRequest| async {
+            Ok(HttpResponse::ok().stream(|writer| async move {
+                writer.write("first chunk").await?;
+                std::future::pending::<()>().await;
+                Ok(())
+            }))
+        });
+
+        let (addr, handle, server) = start_server(router).await;
+
+        let mut client = send_get(addr, "/stream").await;
+        let mut buf = [0u8; 12];
+        tokio::io::AsyncReadExt::read_exact(&mut client, &mut buf)
+            .await
+            .unwrap();
+
+        let start = std::time::Instant::now();
+        let result = handle.shutdown(std::time::Duration::from_millis(100)).await;
+        assert!(start.elapsed() < std::time::Duration::from_secs(2));
+        assert!(
+            matches!(result, Err(Error::ShutdownTimeout(_))),
+            "{:?}",
+            result
+        );
+
+        // The connection is closed without finishing the chunked body
+        let rest = read_to_string(&mut client).await;
+        assert!(rest.contains("first chunk"), "{}", rest);
+        assert!(!rest.ends_with("0\r\n\r\n"), "{}", rest);
+
+        server.await.unwrap().unwrap();
+        assert_eq!(handle.active_connections(), 0);
+    }
+
+    /// Serve `router` until a signal is sent on the returned channel
+    async fn start_with_signals(
+        router: Router,
+        drain_timeout: std::time::Duration,
+    ) -> (
+        SocketAddr,
+        ShutdownHandle,
+        tokio::sync::mpsc::UnboundedSender<String>,
+        tokio::task::JoinHandle<Result<(), Error>>,
+    ) {
+        let app = Application::new(Container::new(), router);
+        let handle = app.shutdown_handle();
+        let listener = TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let addr = listener.local_addr().unwrap();
+        let (signals, received) = tokio::sync::mpsc::unbounded_channel();
+        let server = tokio::spawn(app.serve_until_signalled(listener, received, drain_timeout));
+        (addr, handle, signals, server)
+    }
+
+    async fn wait_for_shutdown(handle: &ShutdownHandle) {
+        while !handle.is_shutting_down() {
+            tokio::time::sleep(std::time::Duration::from_millis(5)).await;
+        }
+    }
+
+    /// Whether a new request on a fresh connection goes unanswered
+    async fn is_refused(addr: SocketAddr) -> bool {
+        use tokio::io::{AsyncReadExt, AsyncWriteExt};
+
+        let Ok(mut stream) = tokio::net::TcpStream::connect(addr).await else {
+            return true;
+        };
+        let _ = stream
+            .write_all(b"GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+            .await;
+        let mut response = Vec::new();
+        matches!(stream.read_to_end(&mut response).await, Err(_) | Ok(0))
+    }
+
+    #[tokio::test]
+    async fn test_signal_drains_in_flight_requests() {
+        let (entered_tx, entered_rx) = tokio::sync::oneshot::channel::<()>();
+        let entered_tx = Arc::new(std::sync::Mutex::new(Some(entered_tx)));
+
+        let mut router = Router::new();
+        router.get("/", |_req: HttpRequest| async { Ok(HttpResponse::ok()) });
+        router.get("/slow", move |_req: HttpRequest| {
+            if let Some(tx) = entered_tx.lock().unwrap().take() {
+                let _ = tx.send(());
+            }
+            async {
+                tokio::time::sleep(std::time::Duration::from_millis(200)).await;
+                Ok(HttpResponse::ok().with_body(b"done".to_vec()))
+            }
+        });
+
+        let (addr, handle, signals, server) =
+            start_with_signals(router, std::time::Duration::from_secs(5)).await;
+        assert!(!is_refused(addr).await);
+
+        let mut client = send_get(addr, "/slow").await;
+        entered_rx.await.unwrap();
+        signals.send("SIGTERM".to_string()).unwrap();
+        wait_for_shutdown(&handle).await;
+
+        assert!(is_refused(addr).await);
+        let response = read_to_string(&mut client).await;
+        assert!(response.starts_with("HTTP/1.1 200"), "{}", response);
+        assert!(response.ends_with("done"), "{}", response);
+
+        server.await.unwrap().unwrap();
+    }
+
+    #[tokio::test]
+    async fn test_second_signal_forces_shutdown() {
+        let mut router = Router::new();
+        router.get("/stream", |_req: HttpRequest| async {
+            Ok(HttpResponse::ok().stream(|writer| async move {
+                writer.write("first chunk").await?;
+                std::future::pending::<()>().await;
+                Ok(())
+            }))
+        });
+
+        let (addr, handle, signals, server) =
+            start_with_signals(router, std::time::Duration::from_secs(60)).await;
+
+        let mut client = send_get(addr, "/stream").await;
+        let mut buf = [0u8; 12];
+        tokio::io::AsyncReadExt::read_exact(&mut client, &mut buf)
+            .await
+            .unwrap();
+
+        signals.send("SIGINT".to_string()).unwrap();
+        wait_for_shutdown(&handle).await;
+        let start = std::time::Instant::now();
+        signals.send("SIGINT".to_string()).unwrap();
+
+        let result = server.await.unwrap();
+        assert!(start.elapsed() < std::time::Duration::from_secs(2));
+        assert!(
+            matches!(result, Err(Error::ShutdownTimeout(ref m)) if m.contains("SIGINT")),
+            "{:?}",
+            result
+        );
+        read_to_string(&mut client).await;
+        assert_eq!(handle.active_connections(), 0);
+    }
+
+    #[tokio::test]
+    async fn test_websocket_upgrade_handshake() {
+        use tokio::io::{AsyncReadExt, AsyncWriteExt};
+
+        let mut router = Router::new();
+        router.get("/ws", |req: HttpRequest| async move {
+            let options = crate::UpgradeOptions::new().protocols(["chat"]);
+            req.upgrade_with(&options, |ws| async move {
+                ws.close().await;
+                Ok(())
+            })
+        });
+
+        let (addr, _handle, _server) = start_server(router).await;
+
+        let mut client = tokio::net::TcpStream::connect(addr).await.unwrap();
+        client
+            .write_all(
+                b"GET /ws HTTP/1.1\r\n\
+                  Host: localhost\r\n\
+                  Upgrade: websocket\r\n\
+                  Connection: Upgrade\r\n\
+                  Sec-WebSocket-Version: 13\r\n\
+                  Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\
+                  Sec-WebSocket-Protocol: superchat, chat\r\n\r\n",
+            )
+            .await
+            .unwrap();
+
+        let mut head = Vec::new();
+        while !head.ends_with(b"\r\n\r\n") {
+            let mut byte = [0u8; 1];
+            client.read_exact(&mut byte).await.unwrap();
+            head.push(byte[0]);
+        }
+        let head = String::from_utf8(head).unwrap().to_lowercase();
+        assert!(head.starts_with("http/1.1 101"), "{}", head);
+        assert!(head.contains("upgrade: websocket"), "{}", head);
+        assert!(
+            head.contains("sec-websocket-accept: s3pplmbitxaq9kygzzhzrbk+xoo="),
+            "{}",
+            head
+        );
+        assert!(
+            head.contains("sec-websocket-protocol: chat\r\n"),
+            "{}",
+            head
+        );
+    }
+
+    #[tokio::test]
+    async fn test_upgraded_connections_outlive_timeouts() {
+        use tokio::io::{AsyncReadExt, AsyncWriteExt};
+
+        let mut router = Router::new();
+        router.get("/upgrade", |req: HttpRequest| async move {
+            let on_upgrade = req
+                .extensions
+                .get::<crate::websocket::PendingUpgrade>()
+                .and_then(|pending| pending.take())
+                .unwrap();
+            tokio::spawn(async move {
+                let mut io = TokioIo::new(on_upgrade.await.unwrap());
+                // Wait well past the idle timeout of an HTTP connection
+                let mut buf = [0u8; 16];
+                let read =
+                    tokio::time::timeout(std::time::Duration::from_millis(600), io.read(&mut buf))
+                        .await;
+                let reply: &[u8] = if read.is_err() { b"late" } else { b"fail" };
+                io.write_all(reply).await.unwrap();
+            });
+            Ok(HttpResponse::new(101)
+                .with_header("Upgrade".into(), "echo".into())
+                .with_header("Connection".into(), "Upgrade".into()))
+        });
+        let app = Application::new(Container::new(), router).with_server_timeouts(short_timeouts());
+        let (addr, _handle, _server) = start_app(app).await;
+
+        let mut client = tokio::net::TcpStream::connect(addr).await.unwrap();
+        client
+            .write_all(
+                b"GET /upgrade HTTP/1.1\r\nHost: localhost\r\n\
+                  Upgrade: echo\r\nConnection: Upgrade\r\n\r\n",
+            )
+            .await
+            .unwrap();
+
+        let mut head = Vec::new();
+        while !head.ends_with(b"\r\n\r\n") {
+            let mut byte = [0u8; 1];
+            client.read_exact(&mut byte).await.unwrap();
+            head.push(byte[0]);
+        }
+        assert!(head.starts_with(b"HTTP/1.1 101"));
+
+        let mut data = [0u8; 4];
+        client.read_exact(&mut data).await.unwrap();
+        assert_eq!(&data, b"late");
+    }
+
+    async fn start_limited_server() -> (SocketAddr, ShutdownHandle) {
+        let mut router = Router::new();
+        for path in ["/echo", "/upload"] {
+            router.post(path, |req: HttpRequest| async move {
+                Ok(HttpResponse::ok().with_body(req.body_ref().len().to_string().into_bytes()))
+            });
+        }
+        let app = Application::new(Container::new(), router).with_body_limit(
+            crate::BodyLimitConfig::new()
+                .default_limit(10)
+                .route_limit("/upload", 20),
+        );
+        let (addr, handle, _server) = start_app(app).await;
+        (addr, handle)
+    }
+
+    fn post(path: &str, body: &str) -> String {
+        format!(
+            "POST {} HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\nContent-Length: {}\r\n\r\n{}",
+            path,
+            body.len(),
+            body
+        )
+    }
+
+    fn post_chunked(path: &str, chunks: &[&str]) -> String {
+        let mut request = format!(
+            "POST {} HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\nTransfer-Encoding: chunked\r\n\r\n",
+            path
+        );
+        for chunk in chunks {
+            request.push_str(&format!("{:x}\r\n{}\r\n", chunk.len(), chunk));
+        }
+        request.push_str("0\r\n\r\n");
+        request
+    }
+
+    #[tokio::test]
+    async fn test_body_limit_content_length() {
+        let (addr, handle) = start_limited_server().await;
+
+        // Exactly at the limit
+        let response = send_raw(addr, &post("/echo", "0123456789")).await;
+        assert!(response.starts_with("HTTP/1.1 200"), "{}", response);
+        assert!(response.ends_with("\r\n\r\n10"), "{}", response);
+
+        // One byte over
+        let response = send_raw(addr, &post("/echo", "0123456789a")).await;
+        assert!(response.starts_with("HTTP/1.1 413"), "{}", response);
+        assert!(response.contains("connection: close"), "{}", response);
+
+        // Advertised sizes are rejected before the body arrives
+        let response = send_raw(
+            addr,
+            "POST /echo HTTP/1.1\r\nHost: localhost\r\nContent-Length: 1000000\r\n\r\n",
+        )
+        .await;
+        assert!(response.starts_with("HTTP/1.1 413"), "{}", response);
+
+        handle
+            .shutdown(std::time::Duration::from_secs(5))
+            .await
+            .unwrap();
+    }
+
+    #[tokio::test]
+    async fn test_body_limit_chunked_and_route_override() {
+        let (addr, handle) = start_limited_server().await;
+
+        let response = send_raw(addr, &post_chunked("/echo", &["01234", "56789"])).await;
+        assert!(response.starts_with("HTTP/1.1 200"), "{}", response);
+
+        let response = send_raw(addr, &post_chunked("/echo", &["01234", "56789", "a"])).await;
+        assert!(response.starts_with("HTTP/1.1 413"), "{}", response);
+
+        // The upload route allows twice as much
+        let body = "x".repeat(20);
+        let response = send_raw(addr, &post("/upload", &body)).await;
+        assert!(response.ends_with("\r\n\r\n20"), "{}", response);
+
+        let body = "x".repeat(21);
+        let response = send_raw(addr, &post_chunked("/upload", &[&body])).await;
+        assert!(response.starts_with("HTTP/1.1 413"), "{}", response);
+
+        handle
+            .shutdown(std::time::Duration::from_secs(5))
+            .await
+            .unwrap();
+    }
+
+    fn short_timeouts() -> ServerTimeouts {
+        let limit = std::time::Duration::from_millis(200);
+        ServerTimeouts::new()
+            .read_header_timeout(limit)
+            .read_timeout(limit * 2)
+            .write_timeout(limit)
+            .idle_timeout(limit)
+    }
+
+    /// Wait up to two seconds for the server to have `count` open connections
+    async fn wait_for_connections(stats: &PipelineStats, count: usize) -> bool {
+        for _ in 0..200 {
+            if stats.active_connections() == count {
+                return true;
+            }
+            tokio::time::sleep(std::time::Duration::from_millis(10)).await;
+        }
+        false
+    }
+
+    #[tokio::test]
+    async fn test_timeouts_close_slow_requests() {
+        use tokio::io::AsyncWriteExt;
+
+        let mut router = Router::new();
+        router.get("/", |_req: HttpRequest| async { Ok(HttpResponse::ok()) });
+        router.post("/echo", |req: HttpRequest| async move {
+            Ok(HttpResponse::ok().with_body(req.body_ref().to_vec()))
+        });
+        let app = Application::new(Container::new(), router).with_server_timeouts(short_timeouts());
+        let stats = app.pipeline_stats();
+        let (addr, handle, _server) = start_app(app).await;
+
+        // A connection that never sends a request
+        let mut client = tokio::net::TcpStream::connect(addr).await.unwrap();
+        assert_eq!(read_to_string(&mut client).await, "");
+
+        // Headers that never finish
+        let mut client = tokio::net::TcpStream::connect(addr).await.unwrap();
+        client
+            .write_all(b"GET / HTTP/1.1\r\nHost: localhost\r\n")
+            .await
+            .unwrap();
+        assert_eq!(read_to_string(&mut client).await, "");
+
+        // A body that never arrives in full never reaches the handler
+        let mut client = tokio::net::TcpStream::connect(addr).await.unwrap();
+        client
+            .write_all(b"POST /echo HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\n01")
+            .await
+            .unwrap();
+        assert_eq!(read_to_string(&mut client).await, "");
+        assert!(wait_for_connections(&stats, 0).await);
+
+        handle
+            .shutdown(std::time::Duration::from_secs(5))
+            .await
+            .unwrap();
+    }
+
+    #[tokio::test]
+    async fn test_timeouts_allow_prompt_keep_alive_requests() {
+        use tokio::io::{AsyncReadExt, AsyncWriteExt};
+
+        let mut router = Router::new();
+        router.get("/", |_req: HttpRequest| async {
+            Ok(HttpResponse::ok().with_body(b"hi".to_vec()))
+        });
+        let app = Application::new(Container::new(), router).with_server_timeouts(short_timeouts());
+        let (addr, handle, _server) = start_app(app).await;
+
+        let mut client = tokio::net::TcpStream::connect(addr).await.unwrap();
+        // Each request restarts the idle timeout, so the connection outlives it
+        for _ in 0..3 {
+            tokio::time::sleep(std::time::Duration::from_millis(100)).await;
+            client
+                .write_all(b"GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+                .await
+                .unwrap();
+            let mut response = vec![0u8; 1024];
+            let n = client.read(&mut response).await.unwrap();
+            let response = String::from_utf8_lossy(&response[..n]);
+            assert!(response.starts_with("HTTP/1.1 200"), "{}", response);
+            assert!(response.ends_with("hi"), "{}", response);
+        }
+
+        // Then it is closed once idle for too long
+        assert_eq!(read_to_string(&mut client).await, "");
+
+        handle
+            .shutdown(std::time::Duration::from_secs(5))
+            .await
+            .unwrap();
+    }
+
+    #[tokio::test]
+    async fn test_write_timeout_and_per_request_overrides() {
+        use tokio::io::{AsyncReadExt, AsyncWriteExt};
+
+        // Far more than the socket buffers hold, so an unread response stalls
+        const SIZE: usize = 64 * 1024 * 1024;
+
+        let mut router = Router::new();
+        router.get("/default", |_req: HttpRequest| async {
+            Ok(HttpResponse::ok().with_body(vec![b'x'; SIZE]))
+        });
+        router.get("/disabled", |req: HttpRequest| async move {
+            req.disable_write_timeout();
+            Ok(HttpResponse::ok().with_body(vec![b'x'; SIZE]))
+        });
+        router.get("/deadline", |req: HttpRequest| async move {
+            req.set_write_deadline(
+                tokio::time::Instant::now() + std::time::Duration::from_secs(30),
+            );
+            Ok(HttpResponse::ok().with_body(vec![b'x'; SIZE]))
+        });
+        router.get("/events", |_req: HttpRequest| async {
+            Ok(HttpResponse::ok()
+                .with_header("Content-Type".into(), "text/event-stream".into())
+                .with_body(vec![b'x'; SIZE]))
+        });
+        let app = Application::new(Container::new(), router).with_server_timeouts(short_timeouts());
+        let stats = app.pipeline_stats();
+        let (addr, handle, _server) = start_app(app).await;
+
+        // The client stops reading, so the response can't be sent in time
+        let client = send_get(addr, "/default").await;
+        assert!(wait_for_connections(&stats, 0).await);
+        drop(client);
+
+        for path in ["/disabled", "/deadline", "/events"] {
+            let mut client = send_get(addr, path).await;
+            tokio::time::sleep(std::time::Duration::from_millis(500)).await;
+            assert_eq!(stats.active_connections(), 1, "{}", path);
+
+            // The whole response still arrives once the client reads it
+            let mut received = 0;
+            let mut buf = vec![0u8; 64 * 1024];
+            while received < SIZE {
+                let n = client.read(&mut buf).await.unwrap();
+                assert!(n > 0, "{} closed after {} bytes", path, received);
+                received += n;
+            }
+            client.shutdown().await.unwrap();
+            drop(client);
+            assert!(wait_for_connections(&stats, 0).await, "{}", path);
+        }
+
+        handle
+            .shutdown(std::time::Duration::from_secs(5))
+            .await
+            .unwrap();
+    }
+
+    const TEST_CERT: &[u8] = include_bytes!(concat!(
+        env!("CARGO_MANIFEST_DIR"),
+        "/tests/fixtures/localhost-cert.pem"
+    ));
+    const TEST_KEY: &[u8] = include_bytes!(concat!(
+        env!("CARGO_MANIFEST_DIR"),
+        "/tests/fixtures/localhost-key.pem"
+    ));
+
+    /// Router with a streaming route that holds its second chunk until the
+    /// returned sender fires
+    fn streaming_router() -> (Router, tokio::sync::oneshot::Sender<()>) {
+        let (release, gate) = tokio::sync::oneshot::channel::<()>();
+        let gate = Arc::new(parking_lot::Mutex::new(Some(gate)));
+
+        let mut router = Router::new();
+        router.get("/hello", |req: HttpRequest| async move {
+            let host = req.header("host").unwrap_or_default();
+            Ok(HttpResponse::ok().with_body(format!("hello {}", host).into_bytes()))
+        });
+        router.get("/stream", move |_req: HttpRequest| {
+            let gate = gate.lock().take();
+            async move {
+                Ok(HttpResponse::ok().stream(|writer| async move {
+                    writer.write("first").await?;
+                    if let Some(gate) = gate {
+                        let _ = gate.await;
+                    }
+                    writer.write("second").await?;
+                    Ok(())
+                }))
+            }
+        });
+        (router, release)
+    }
+
+    async fn start_tls_server(router: Router) -> (SocketAddr, ShutdownHandle) {
+        let app = Application::new(Container::new(), router);
+        let handle = app.shutdown_handle();
+        let tls = TlsConfig::from_pem_bytes(TEST_CERT, TEST_KEY).unwrap();
+        let listener = TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let addr = listener.local_addr().unwrap();
+        tokio::spawn(app.serve_tls(listener, tls));
+        (addr, handle)
+    }
+
+    async fn connect_tls(
+        addr: SocketAddr,
+        alpn: &[&[u8]],
+    ) -> tokio_rustls::client::TlsStream<tokio::net::TcpStream> {
+        let mut roots = rustls::RootCertStore::empty();
+        for cert in rustls_pemfile::certs(&mut &TEST_CERT[..]) {
+            roots.add(cert.unwrap()).unwrap();
+        }
+        let mut config = rustls::ClientConfig::builder_with_provider(Arc::new(
+            rustls::crypto::ring::default_provider(),
+        ))
+        .with_safe_default_protocol_versions()
+        .unwrap()
+        .with_root_certificates(roots)
+        .with_no_client_auth();
+        config.alpn_protocols = alpn.iter().map(|p| p.to_vec()).collect();
+
+        let connector = tokio_rustls::TlsConnector::from(Arc::new(config));
+        let stream = tokio::net::TcpStream::connect(addr).await.unwrap();
+        let name = rustls::pki_types::ServerName::try_from("localhost").unwrap();
+        connector.connect(name, stream).await.unwrap()
+    }
+
+    async fn h2_client<IO>(
+        io: IO,
+    ) -> hyper::client::conn::http2::SendRequest<http_body_util::Empty<bytes::Bytes>>
+    where
+        IO: tokio::io::AsyncRead + tokio::io::AsyncWrite + Unpin + Send + 'static,
+    {
+        let (sender, conn) =
+            hyper::client::conn::http2::handshake(TokioExecutor::new(), TokioIo::new(io))
+                .await
+                .unwrap();
+        tokio::spawn(conn);
+        sender
+    }
+
+    fn h2_get(uri: &str) -> Request<http_body_util::Empty<bytes::Bytes>> {
+        Request::get(uri)
+            .body(http_body_util::Empty::new())
+            .unwrap()
+    }
+
+    async fn next_data(body: &mut IncomingBody) -> Option<bytes::Bytes> {
+        use http_body_util::BodyExt;
+
+        while let Some(frame) = body.frame().await {
+            // The stream may end with an empty END_STREAM frame
+            if let Ok(data) = frame.unwrap().into_data()
+                && !data.is_empty()
+            {
+                return Some(data);
+            }
+        }
+        None
+    }
+
+    #[tokio::test]
+    async fn test_tls_negotiates_h2_and_streams() {
+        let (router, release) = streaming_router();
+        let (addr, handle) = start_tls_server(router).await;
+
+        let tls = connect_tls(addr, &[b"h2", b"http/1.1"]).await;
+        assert_eq!(tls.get_ref().1.alpn_protocol(), Some(&b"h2"[..]));
+        let mut client = h2_client(tls).await;
+
+        let response = client
+            .send_request(h2_get("https://localhost/hello"))
+            .await
+            .unwrap();
+        assert_eq!(response.version(), hyper::Version::HTTP_2);
+        assert_eq!(response.status(), 200);
+        let mut body = response.into_body();
+        assert_eq!(next_data(&mut body).await.unwrap(), &b"hello localhost"[..]);
+
+        // The first chunk arrives while the handler is still blocked
+        let response = client
+            .send_request(h2_get("https://localhost/stream"))
+            .await
+            .unwrap();
+        let mut body = response.into_body();
+        let first = tokio::time::timeout(std::time::Duration::from_secs(5), next_data(&mut body))
+            .await
+            .expect("first chunk was not flushed");
+        assert_eq!(first.unwrap(), &b"first"[..]);
+
+        release.send(()).unwrap();
+        assert_eq!(next_data(&mut body).await.unwrap(), &b"second"[..]);
+        assert!(next_data(&mut body).await.is_none());
+
+        handle
+            .shutdown(std::time::Duration::from_secs(5))
+            .await
+            .unwrap();
+    }
+
+    #[tokio::test]
+    async fn test_tls_falls_back_to_http1() {
+        use tokio::io::AsyncWriteExt;
+
+        let (router, _release) = streaming_router();
+        let (addr, handle) = start_tls_server(router).await;
+
+        let mut tls = connect_tls(addr, &[b"http/1.1"]).await;
+        assert_eq!(tls.get_ref().1.alpn_protocol(), Some(&b"http/1.1"[..]));
+        tls.write_all(b"GET /hello HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+            .await
+            .unwrap();
+        let mut response = Vec::new();
+        tokio::io::AsyncReadExt::read_to_end(&mut tls, &mut response)
+            .await
+            .unwrap();
+        let response = String::from_utf8_lossy(&response);
+        assert!(response.starts_with("HTTP/1.1 200"), "{}", response);
+        assert!(response.ends_with("hello localhost"), "{}", response);
+
+        handle
+            .shutdown(std::time::Duration::from_secs(5))
+            .await
+            .unwrap();
+    }
+
+    #[tokio::test]
+    async fn test_h2c_prior_knowledge() {
+        let (router, _release) = streaming_router();
+        let app = Application::new(Container::new(), router).with_h2c(true);
+        let (addr, handle, server) = start_app(app).await;
+
+        let stream = tokio::net::TcpStream::connect(addr).await.unwrap();
+        let mut client = h2_client(stream).await;
+        let response = client
+            .send_request(h2_get("http://example.com/hello"))
+            .await
+            .unwrap();
+        assert_eq!(response.version(), hyper::Version::HTTP_2);
+        let mut body = response.into_body();
+        assert_eq!(
+            next_data(&mut body).await.unwrap(),
+            &b"hello example.com"[..]
+        );
+        drop(client);
+
+        // HTTP/1.1 keeps working on the same listener
+        let response = send_raw(
+            addr,
+            "GET /hello HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n",
+        )
+        .await;
+        assert!(response.starts_with("HTTP/1.1 200"), "{}", response);
+
+        handle
+            .shutdown(std::time::Duration::from_secs(5))
+            .await
+            .unwrap();
+        server.await.unwrap().unwrap();
+    }
+
+    #[tokio::test]
+    async fn test_h2c_applies_timeouts() {
+        use tokio::io::AsyncWriteExt;
+
+        let (router, _release) = streaming_router();
+        let app = Application::new(Container::new(), router)
+            .with_h2c(true)
+            .with_server_timeouts(
+                short_timeouts().keep_alive_interval(std::time::Duration::from_millis(50)),
+            );
+        let stats = app.pipeline_stats();
+        let (addr, handle, server) = start_app(app).await;
+
+        // HTTP/1.1 clients on an h2c listener are still timed
+        let mut slow = tokio::net::TcpStream::connect(addr).await.unwrap();
+        slow.write_all(b"GET /hello HTTP/1.1\r\nHost: localhost\r\n")
+            .await
+            .unwrap();
+        assert_eq!(read_to_string(&mut slow).await, "");
+        let mut idle = tokio::net::TcpStream::connect(addr).await.unwrap();
+        assert_eq!(read_to_string(&mut idle).await, "");
+
+        // An HTTP/2 connection outlives the HTTP/1.1 idle timeout between
+        // requests, answering keep-alive pings in the meantime
+        let stream = tokio::net::TcpStream::connect(addr).await.unwrap();
+        let mut client = h2_client(stream).await;
+        for _ in 0..2 {
+            let response = client
+                .send_request(h2_get("http://example.com/hello"))
+                .await
+                .unwrap();
+            assert_eq!(response.version(), hyper::Version::HTTP_2);
+            let mut body = response.into_body();
+            assert_eq!(
+                next_data(&mut body).await.unwrap(),
+                &b"hello example.com"[..]
+            );
+            tokio::time::sleep(std::time::Duration::from_millis(500)).await;
+        }
+        drop(client);
+        assert!(wait_for_connections(&stats, 0).await);
+
+        handle
+            .shutdown(std::time::Duration::from_secs(5))
+            .await
+            .unwrap();
+        server.await.unwrap().unwrap();
+    }
+
+    #[tokio::test]
+    async fn test_h2c_disabled_by_default() {
+        let (router, _release) = streaming_router();
+        let (addr, handle, server) = start_server(router).await;
+
+        let response = send_raw(addr, "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n").await;
+        assert!(!response.contains("hello"), "{}", response);
+
+        handle
+            .shutdown(std::time::Duration::from_secs(5))
+            .await
+            .unwrap();
+        server.await.unwrap().unwrap();
+    }
 }
@@ -0,0 +1,2613 @@
+//! Request binding with validation.
+//!
+//! [`HttpRequest::bind_json`] decodes the request body into a typed struct
+//! and then runs its [`Validate`] implementation. Every problem found is
+//! reported as a [`FieldError`], and all of them are collected into a single
+//! [`ValidationError`] instead of stopping at the first one.
+//!
+//! [`HttpRequest::bind_query`] does the same for the query string,
+//! converting each parameter to its field type, and
+//! [`HttpRequest::bind_headers`] and [`HttpRequest::bind_path`] apply the
+//! same conversions to headers and path parameters. [`HttpRequest::bind`]
+//! binds all of them and the JSON body into one struct.
+//! [`HttpRequest::bind_form`] binds HTML form submissions, and
+//! [`FormErrors`] turns the resulting errors into a message per field for
+//! rendering the form again.
+//!
+//! A `ValidationError` converts into [`Error::ValidationFailed`], which the
+//! server renders as a `422 Unprocessable Entity` JSON response with an
+//! `errors` array, so handlers can simply use `?`.
+//!
+//! # Examples
+//!
+//! ```
+//! use armature_core::{HttpRequest, Validate, ValidationError};
+//! use serde::Deserialize;
+//!
+//! #[derive(Deserialize)]
+//! struct CreateUser {
+//!     name: Option<String>,
+//!     email: Option<String>,
+//! }
+//!
+//! impl Validate for CreateUser {
+//!     fn validate(&self) -> Result<(), ValidationError> {
+//!         let mut errors = ValidationError::new();
+//!         errors.require("name", &self.name);
+//!         let email = self.email.as_deref().unwrap_or_default();
+//!         errors.require("email", &self.email).check(
+//!             email.is_empty() || email.contains('@'),
+//!             "email",
+//!             "email",
+//!             "must be a valid email address",
+//!         );
+//!         errors.into_result()
+//!     }
+//! }
+//!
+//! let mut req = HttpRequest::new("POST".into(), "/users".into());
+//! req.headers.insert("Content-Type".into(), "application/json".into());
+//! req.body = br#"{"email": "not-an-email"}"#.to_vec();
+//!
+//! let err = req.bind_json::<CreateUser>().err().unwrap();
+//! assert_eq!(err.status_code(), 422);
+//! ```
+
+use crate::{Error, HttpRequest};
+use serde::Serialize;
+use serde::de::DeserializeOwned;
+use std::collections::BTreeMap;
+use std::fmt;
+
+// ============================================================================
+// Validation Errors
+// ============================================================================
+
+/// A single failed validation rule for one field.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+pub struct FieldError {
+    /// Field path, e.g. `email` or `items[0].name`
+    pub field: String,
+    /// Rule that failed, e.g. `required`, `email`, `type`, `unknown`
+    pub rule: String,
+    /// Human-readable message
+    pub message: String,
+}
+
+impl FieldError {
+    /// Create a new field error.
+    pub fn new(
+        field: impl Into<String>,
+        rule: impl Into<String>,
+        message: impl Into<String>,
+    ) -> Self {
+        Self {
+            field: field.into(),
+            rule: rule.into(),
+            message: message.into(),
+        }
+    }
+}
+
+impl fmt::Display for FieldError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "{} ({}): {}", self.field, self.rule, self.message)
+    }
+}
+
+/// Aggregated field-level validation failures.
+///
+/// Serializes as `{"errors": [{"field", "rule", "message"}, ...]}`.
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize)]
+pub struct ValidationError {
+    errors: Vec<FieldError>,
+}
+
+impl ValidationError {
+    /// Create an empty set of errors.
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Record a failed rule.
+    pub fn add(
+        &mut self,
+        field: impl Into<String>,
+        rule: impl Into<String>,
+        message: impl Into<String>,
+    ) -> &mut Self {
+        self.errors.push(FieldError::new(field, rule, message));
+        self
+    }
+
+    /// Record a failed rule unless `ok` is true.
+    pub fn check(&mut self, ok: bool, field: &str, rule: &str, message: &str) -> &mut Self {
+        if !ok {
+            self.add(field, rule, message);
+        }
+        self
+    }
+
+    /// Record a `required` failure if the value is missing or blank.
+    pub fn require<T: Required + ?Sized>(&mut self, field: &str, value: &T) -> &mut Self {
+        self.check(value.is_present(), field, "required", "is required")
+    }
+
+    /// Append a field error.
+    pub fn push(&mut self, error: FieldError) {
+        self.errors.push(error);
+    }
+
+    /// Append all errors from another set, prefixing their fields with
+    /// `prefix.` (useful for nested structs).
+    pub fn nest(&mut self, prefix: &str, other: ValidationError) -> &mut Self {
+        for mut error in other.errors {
+            error.field = format!("{}.{}", prefix, error.field);
+            self.errors.push(error);
+        }
+        self
+    }
+
+    /// All recorded errors, in the order they were found.
+    pub fn errors(&self) -> &[FieldError] {
+        &self.errors
+    }
+
+    /// Errors recorded for one field.
+    pub fn field_errors<'a>(&'a self, field: &'a str) -> impl Iterator<Item = &'a FieldError> {
+        self.errors.iter().filter(move |e| e.field == field)
+    }
+
+    /// Check whether no errors were recorded.
+    pub fn is_empty(&self) -> bool {
+        self.errors.is_empty()
+    }
+
+    /// Number of recorded errors.
+    pub fn len(&self) -> usize {
+        self.errors.len()
+    }
+
+    /// `Ok(())` if no errors were recorded, otherwise `Err(self)`.
+    pub fn into_result(self) -> Result<(), ValidationError> {
+        if self.errors.is_empty() {
+            Ok(())
+        } else {
+            Err(self)
+        }
+    }
+}
+
+impl fmt::Display for ValidationError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        for (i, error) in self.errors.iter().enumerate() {
+            if i > 0 {
+                f.write_str("; ")?;
+            }
+            write!(f, "{}", error)?;
+        }
+        Ok(())
+    }
+}
+
+impl std::error::Error for ValidationError {}
+
+impl From<FieldError> for ValidationError {
+    fn from(error: FieldError) -> Self {
+        Self {
+            errors: vec![error],
+        }
+    }
+}
+
+impl From<Vec<FieldError>> for ValidationError {
+    fn from(errors: Vec<FieldError>) -> Self {
+        Self { errors }
+    }
+}
+
+impl From<ValidationError> for Error {
+    fn from(err: ValidationError) -> Self {
+        Error::ValidationFailed(err)
+    }
+}
+
+/// Values that can be checked by [`ValidationError::require`].
+pub trait Required {
+    /// Whether the value counts as present.
+    fn is_present(&self) -> bool;
+}
+
+impl Required for str {
+    fn is_present(&self) -> bool {
+        !self.trim().is_empty()
+    }
+}
+
+impl Required for String {
+    fn is_present(&self) -> bool {
+        self.as_str().is_present()
+    }
+}
+
+impl<T: Required> Required for Option<T> {
+    fn is_present(&self) -> bool {
+        self.as_ref().is_some_and(Required::is_present)
+    }
+}
+
+impl<T> Required for Vec<T> {
+    fn is_present(&self) -> bool {
+        !self.is_empty()
+    }
+}
+
+/// Types that can validate themselves after being bound from a request.
+pub trait Validate {
+    /// Check every rule and return all failures.
+    fn validate(&self) -> Result<(), ValidationError>;
+}
+
+// ============================================================================
+// Bind Options
+// ============================================================================
+
+/// How the `Content-Type` header is checked before binding.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub enum ContentTypePolicy {
+    /// Reject a non-JSON `Content-Type`, but accept a missing header
+    #[default]
+    IfPresent,
+    /// Require a JSON `Content-Type`
+    Required,
+    /// Do not check the header
+    Ignore,
+}
+
+/// Options controlling [`HttpRequest::bind_json_with`].
+///
+/// The default accepts a missing `Content-Type`, rejects an empty body and
+/// ignores unknown fields. [`BindOptions::strict`] and
+/// [`BindOptions::lenient`] cover the common alternatives.
+#[derive(Debug, Clone, Default)]
+pub struct BindOptions {
+    content_type: ContentTypePolicy,
+    allow_empty_body: bool,
+    deny_unknown_fields: bool,
+}
+
+impl BindOptions {
+    /// Default options.
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Require a JSON content type, a body, and no unknown fields.
+    pub fn strict() -> Self {
+        Self {
+            content_type: ContentTypePolicy::Required,
+            allow_empty_body: false,
+            deny_unknown_fields: true,
+        }
+    }
+
+    /// Ignore the content type, treat an empty body as `{}`, and ignore
+    /// unknown fields.
+    pub fn lenient() -> Self {
+        Self {
+            content_type: ContentTypePolicy::Ignore,
+            allow_empty_body: true,
+            deny_unknown_fields: false,
+        }
+    }
+
+    /// Set how the `Content-Type` header is checked.
+    pub fn content_type(mut self, policy: ContentTypePolicy) -> Self {
+        self.content_type = policy;
+        self
+    }
+
+    /// Treat an empty body as `{}` instead of rejecting it.
+    pub fn allow_empty_body(mut self, allow: bool) -> Self {
+        self.allow_empty_body = allow;
+        self
+    }
+
+    /// Report fields not present in the target type as errors.
+    pub fn deny_unknown_fields(mut self, deny: bool) -> Self {
+        self.deny_unknown_fields = deny;
+        self
+    }
+}
+
+// ============================================================================
+// JSON Binding
+// ============================================================================
+
+impl HttpRequest {
+    /// Decode the JSON body into `T` and validate it, using default
+    /// [`BindOptions`].
+    ///
+    /// # Errors
+    ///
+    /// - [`Error::UnsupportedMediaType`] if the `Content-Type` is not JSON
+    /// - [`Error::BadRequest`] if the body is empty or not valid JSON
+    /// - [`Error::ValidationFailed`] for missing fields, type mismatches,
+    ///   unknown fields (when denied) and failed [`Validate`] rules
+    pub fn bind_json<T>(&self) -> Result<T, Error>
+    where
+        T: DeserializeOwned + Validate,
+    {
+        self.bind_json_with(&BindOptions::default())
+    }
+
+    /// Decode the JSON body into `T` and validate it with explicit options.
+    pub fn bind_json_with<T>(&self, options: &BindOptions) -> Result<T, Error>
+    where
+        T: DeserializeOwned + Validate,
+    {
+        check_json_content_type(self, options.content_type)?;
+
+        let body = self.body_ref();
+        let body: &[u8] = if body.iter().all(u8::is_ascii_whitespace) {
+            if !options.allow_empty_body {
+                return Err(Error::BadRequest("request body is empty".to_string()));
+            }
+            b"{}"
+        } else {
+            body
+        };
+
+        let value: T = decode_json(body, options.deny_unknown_fields)?;
+        value.validate()?;
+        Ok(value)
+    }
+}
+
+fn check_json_content_type(req: &HttpRequest, policy: ContentTypePolicy) -> Result<(), Error> {
+    if policy == ContentTypePolicy::Ignore {
+        return Ok(());
+    }
+
+    match req.header("content-type") {
+        Some(value) if is_json_media_type(value) => Ok(()),
+        Some(value) => Err(Error::UnsupportedMediaType(format!(
+            "expected application/json, got {}",
+            value
+        ))),
+        None if policy == ContentTypePolicy::Required => Err(Error::UnsupportedMediaType(
+            "expected application/json, got no Content-Type".to_string(),
+        )),
+        None => Ok(()),
+    }
+}
+
+/// `application/json`, `application/*+json` and parameters like `charset`.
+fn is_json_media_type(value: &str) -> bool {
+    let essence = value.split(';').next().unwrap_or("").trim();
+    let Some((kind, subtype)) = essence.split_once('/') else {
+        return false;
+    };
+    kind.eq_ignore_ascii_case("application")
+        && (subtype.eq_ignore_ascii_case("json")
+            || subtype.len() > 5 && subtype[subtype.len() - 5..].eq_ignore_ascii_case("+json"))
+}
+
+fn decode_json<T: DeserializeOwned>(body: &[u8], deny_unknown: bool) -> Result<T, Error> {
+    let mut unknown = Vec::new();
+    let mut de = serde_json::Deserializer::from_slice(body);
+
+    let mut record = |path: serde_ignored::Path<'_>| unknown.push(format_ignored_path(&path));
+    let result = {
+        let tracked = serde_ignored::Deserializer::new(&mut de, &mut record);
+        serde_path_to_error::deserialize::<_, T>(tracked)
+    };
+
+    let value = match result {
+        Ok(value) => value,
+        Err(err) => {
+            let path = format_path(err.path());
+            return Err(json_error(path, err.into_inner()));
+        }
+    };
+    de.end().map_err(|e| json_error(String::new(), e))?;
+
+    if deny_unknown && !unknown.is_empty() {
+        let mut errors = ValidationError::new();
+        for field in unknown {
+            errors.add(field, "unknown", "unknown field");
+        }
+        return Err(errors.into());
+    }
+
+    Ok(value)
+}
+
+/// Format an ignored-field path the same way as [`format_path`].
+///
+/// `serde_ignored` renders `Option` layers as `?`; those are not part of
+/// the JSON shape, so they are skipped.
+fn format_ignored_path(path: &serde_ignored::Path<'_>) -> String {
+    use serde_ignored::Path;
+
+    match path {
+        Path::Root => String::new(),
+        Path::Seq { parent, index } => format!("{}[{}]", format_ignored_path(parent), index),
+        Path::Map { parent, key } => {
+            let parent = format_ignored_path(parent);
+            if parent.is_empty() {
+                key.clone()
+            } else {
+                format!("{}.{}", parent, key)
+            }
+        }
+        Path::Some { parent }
+        | Path::NewtypeStruct { parent }
+        | Path::NewtypeVariant { parent } => format_ignored_path(parent),
+    }
+}
+
+fn format_path(path: &serde_path_to_error::Path) -> String {
+    use serde_path_to_error::Segment;
+
+    let mut out = String::new();
+    for segment in path.iter() {
+        match segment {
+            Segment::Seq { index } => out.push_str(&format!("[{}]", index)),
+            Segment::Map { key } => {
+                if !out.is_empty() {
+                    out.push('.');
+                }
+                out.push_str(key);
+            }
+            Segment::Enum { variant } => {
+                if !out.is_empty() {
+                    out.push('.');
+                }
+                out.push_str(variant);
+            }
+            Segment::Unknown => {}
+        }
+    }
+    out
+}
+
+/// Map a serde_json error to a request error.
+///
+/// Syntax errors are a malformed request; data errors point at a field and
+/// become field-level validation failures.
+fn json_error(path: String, err: serde_json::Error) -> Error {
+    use serde_json::error::Category;
+
+    match err.classify() {
+        Category::Data => {
+            let full = err.to_string();
+            let message = full
+                .rfind(" at line ")
+                .map_or(full.as_str(), |i| &full[..i]);
+
+            if let Some(name) = message
+                .strip_prefix("missing field `")
+                .and_then(|rest| rest.strip_suffix('`'))
+            {
+                let field = if path.is_empty() {
+                    name.to_string()
+                } else {
+                    format!("{}.{}", path, name)
+                };
+                return ValidationError::from(FieldError::new(field, "required", "is required"))
+                    .into();
+            }
+
+            let field = if path.is_empty() {
+                "body".to_string()
+            } else {
+                path
+            };
+            ValidationError::from(FieldError::new(field, "type", message)).into()
+        }
+        Category::Syntax | Category::Eof | Category::Io => Error::BadRequest(format!(
+            "malformed JSON at line {}, column {}: {}",
+            err.line(),
+            err.column(),
+            err
+        )),
+    }
+}
+
+// ============================================================================
+// Query Binding
+// ============================================================================
+
+impl HttpRequest {
+    /// Decode the query string into `T`.
+    ///
+    /// Field names come from the struct (use `#[serde(rename = "...")]` to
+    /// map a different parameter name) and absent parameters fall back to
+    /// `#[serde(default)]`. Values are percent-decoded and converted to the
+    /// field type:
+    ///
+    /// - integers, floats and `char` are parsed from the text
+    /// - `bool` accepts `true`/`false`, `1`/`0` and `on`/`off`
+    /// - `Vec<T>` takes every value of a repeated key (`?tag=a&tag=b`) or a
+    ///   single comma-separated value (`?tag=a,b`)
+    /// - `Option<T>` is `None` when the parameter is absent or empty
+    /// - types deserialized from strings, such as unit enums or
+    ///   `chrono::DateTime`, receive the raw text
+    ///
+    /// When a parameter is repeated but the field is not a `Vec`, the last
+    /// value wins, matching [`HttpRequest::query`].
+    ///
+    /// # Errors
+    ///
+    /// [`Error::ValidationFailed`] with one [`FieldError`] per bad parameter:
+    /// rule `type` for values that don't convert (every such field is
+    /// reported, not just the first) and `required` for missing fields
+    /// without a default.
+    ///
+    /// # Examples
+    ///
+    /// ```
+    /// use armature_core::HttpRequest;
+    /// use serde::Deserialize;
+    ///
+    /// fn default_per_page() -> u32 {
+    ///     20
+    /// }
+    ///
+    /// #[derive(Deserialize)]
+    /// struct Search {
+    ///     q: String,
+    ///     #[serde(default)]
+    ///     page: u32,
+    ///     #[serde(default = "default_per_page")]
+    ///     per_page: u32,
+    ///     #[serde(default, rename = "tag")]
+    ///     tags: Vec<String>,
+    /// }
+    ///
+    /// let mut req = HttpRequest::new("GET".into(), "/search".into());
+    /// req.set_query_string("q=rust%20web&tag=async&tag=http");
+    ///
+    /// let search: Search = req.bind_query().unwrap();
+    /// assert_eq!(search.q, "rust web");
+    /// assert_eq!(search.page, 0);
+    /// assert_eq!(search.per_page, 20);
+    /// assert_eq!(search.tags, ["async", "http"]);
+    /// ```
+    pub fn bind_query<T>(&self) -> Result<T, Error>
+    where
+        T: DeserializeOwned,
+    {
+        bind_params(vec![(Source::Query, query_params(self))], None, false)
+    }
+
+    /// Decode request headers into `T`.
+    ///
+    /// Works like [`HttpRequest::bind_query`], with header names as field
+    /// names. Names match case-insensitively, so either
+    /// `#[serde(rename = "X-Api-Version")]` or `#[serde(rename_all =
+    /// "kebab-case")]` maps fields to headers. A `Vec<T>` field takes every
+    /// element of a comma-separated header, including all values of a
+    /// header sent more than once.
+    ///
+    /// # Errors
+    ///
+    /// [`Error::ValidationFailed`] as for [`HttpRequest::bind_query`], with
+    /// the header names as fields.
+    ///
+    /// # Examples
+    ///
+    /// ```
+    /// use armature_core::HttpRequest;
+    /// use serde::Deserialize;
+    ///
+    /// #[derive(Deserialize)]
+    /// #[serde(rename_all = "kebab-case")]
+    /// struct ApiHeaders {
+    ///     #[serde(rename = "X-Api-Version")]
+    ///     version: u32,
+    ///     #[serde(default)]
+    ///     accept_language: Vec<String>,
+    /// }
+    ///
+    /// let mut req = HttpRequest::new("GET".into(), "/items".into());
+    /// req.headers.insert("x-api-version".into(), "2".into());
+    /// req.headers.insert("Accept-Language".into(), "en-GB, en;q=0.8".into());
+    ///
+    /// let headers: ApiHeaders = req.bind_headers().unwrap();
+    /// assert_eq!(headers.version, 2);
+    /// assert_eq!(headers.accept_language, ["en-GB", "en;q=0.8"]);
+    /// ```
+    pub fn bind_headers<T>(&self) -> Result<T, Error>
+    where
+        T: DeserializeOwned,
+    {
+        bind_params(vec![(Source::Header, header_params(self))], None, false)
+    }
+
+    /// Decode path parameters into `T`.
+    ///
+    /// Works like [`HttpRequest::bind_query`], with the route's parameter
+    /// names (`id` for `/users/:id`) as field names.
+    ///
+    /// # Errors
+    ///
+    /// [`Error::ValidationFailed`] as for [`HttpRequest::bind_query`].
+    ///
+    /// # Examples
+    ///
+    /// ```
+    /// use armature_core::HttpRequest;
+    /// use serde::Deserialize;
+    ///
+    /// #[derive(Deserialize)]
+    /// struct CommentPath {
+    ///     post_id: u64,
+    ///     id: u32,
+    /// }
+    ///
+    /// let mut req = HttpRequest::new("GET".into(), "/posts/7/comments/3".into());
+    /// req.path_params.insert("post_id".into(), "7".into());
+    /// req.path_params.insert("id".into(), "3".into());
+    ///
+    /// let path: CommentPath = req.bind_path().unwrap();
+    /// assert_eq!((path.post_id, path.id), (7, 3));
+    /// ```
+    pub fn bind_path<T>(&self) -> Result<T, Error>
+    where
+        T: DeserializeOwned,
+    {
+        bind_params(vec![(Source::Path, path_params(self))], None, false)
+    }
+
+    /// Bind the path parameters, query string, headers and JSON body into
+    /// `T` at once, then validate it.
+    ///
+    /// `T` has one field for each source it reads, named after the source:
+    /// `path`, `query`, `headers` and `body`. The first three are bound like
+    /// [`HttpRequest::bind_path`], [`HttpRequest::bind_query`] and
+    /// [`HttpRequest::bind_headers`], and `body` is decoded as JSON. Leave
+    /// out the sources a handler doesn't need, and use `#[serde(default)]`
+    /// for an optional body. The body is only decoded when the request has
+    /// one.
+    ///
+    /// # Errors
+    ///
+    /// - [`Error::UnsupportedMediaType`] if the body's `Content-Type` is
+    ///   present but not JSON
+    /// - [`Error::BadRequest`] if the body is not valid JSON
+    /// - [`Error::ValidationFailed`] for every parameter that doesn't
+    ///   convert, missing fields and failed [`Validate`] rules. Fields are
+    ///   prefixed with their source, e.g. `query.page` or `body.email`.
+    ///
+    /// # Examples
+    ///
+    /// ```
+    /// use armature_core::{HttpRequest, Validate, ValidationError};
+    /// use serde::Deserialize;
+    ///
+    /// #[derive(Deserialize)]
+    /// struct UpdateUser {
+    ///     path: UserPath,
+    ///     headers: Preconditions,
+    ///     body: UserChanges,
+    /// }
+    ///
+    /// #[derive(Deserialize)]
+    /// struct UserPath {
+    ///     id: u64,
+    /// }
+    ///
+    /// #[derive(Deserialize)]
+    /// struct Preconditions {
+    ///     #[serde(rename = "If-Match")]
+    ///     if_match: Option<String>,
+    /// }
+    ///
+    /// #[derive(Deserialize)]
+    /// struct UserChanges {
+    ///     name: String,
+    /// }
+    ///
+    /// impl Validate for UpdateUser {
+    ///     fn validate(&self) -> Result<(), ValidationError> {
+    ///         let mut errors = ValidationError::new();
+    ///         errors.require("body.name", &self.body.name);
+    ///         errors.into_result()
+    ///     }
+    /// }
+    ///
+    /// let mut req = HttpRequest::new("PUT".into(), "/users/42".into());
+    /// req.path_params.insert("id".into(), "42".into());
+    /// req.headers.insert("if-match".into(), "\"v3\"".into());
+    /// req.body = br#"{"name": "Ada"}"#.to_vec();
+    ///
+    /// let update: UpdateUser = req.bind().unwrap();
+    /// assert_eq!(update.path.id, 42);
+    /// assert_eq!(update.headers.if_match.as_deref(), Some("\"v3\""));
+    /// assert_eq!(update.body.name, "Ada");
+    /// ```
+    pub fn bind<T>(&self) -> Result<T, Error>
+    where
+        T: DeserializeOwned + Validate,
+    {
+        let body = self.body_ref();
+        let body = if body.iter().all(u8::is_ascii_whitespace) {
+            None
+        } else {
+            check_json_content_type(self, ContentTypePolicy::IfPresent)?;
+            Some(body)
+        };
+
+        let sections = vec![
+            (Source::Path, path_params(self)),
+            (Source::Query, query_params(self)),
+            (Source::Header, header_params(self)),
+        ];
+        let value: T = bind_params(sections, body, true)?;
+        value.validate()?;
+        Ok(value)
+    }
+}
+
+// ============================================================================
+// Form Binding
+// ============================================================================
+
+/// Field errors of a form, keyed by field name.
+///
+/// Built from the [`ValidationError`] [`HttpRequest::bind_form`] fails with,
+/// so the form can be rendered again with a message next to each field.
+/// Only the first message of each field is kept. Serializes as an object
+/// such as `{"email": "is required"}`, sorted by field.
+///
+/// # Examples
+///
+/// ```
+/// use armature_core::{FormErrors, ValidationError};
+///
+/// let mut errors = ValidationError::new();
+/// errors.add("email", "required", "is required");
+/// errors.add("email", "email", "must be an email");
+/// errors.add("age", "type", "expected a non-negative integer, got `old`");
+///
+/// let form = FormErrors::from(errors);
+/// assert_eq!(form.get("email"), Some("is required"));
+/// assert!(form.has("age"));
+/// assert!(!form.has("name"));
+/// assert_eq!(
+///     serde_json::to_value(&form).unwrap(),
+///     serde_json::json!({
+///         "age": "expected a non-negative integer, got `old`",
+///         "email": "is required",
+///     })
+/// );
+/// ```
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize)]
+#[serde(transparent)]
+pub struct FormErrors(BTreeMap<String, String>);
+
+impl FormErrors {
+    /// Create an empty map.
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Record `message` for `field` unless it already has one.
+    pub fn add(&mut self, field: impl Into<String>, message: impl Into<String>) -> &mut Self {
+        self.0.entry(field.into()).or_insert_with(|| message.into());
+        self
+    }
+
+    /// Message for `field`, if it has an error.
+    pub fn get(&self, field: &str) -> Option<&str> {
+        self.0.get(field).map(String::as_str)
+    }
+
+    /// Whether `field` has an error.
+    pub fn has(&self, field: &str) -> bool {
+        self.0.contains_key(field)
+    }
+
+    /// Fields and their messages, sorted by field.
+    pub fn iter(&self) -> impl Iterator<Item = (&str, &str)> {
+        self.0
+            .iter()
+            .map(|(field, message)| (field.as_str(), message.as_str()))
+    }
+
+    /// Check whether no field has an error.
+    pub fn is_empty(&self) -> bool {
+        self.0.is_empty()
+    }
+
+    /// Number of fields with an error.
+    pub fn len(&self) -> usize {
+        self.0.len()
+    }
+}
+
+impl From<&ValidationError> for FormErrors {
+    fn from(errors: &ValidationError) -> Self {
+        let mut form = Self::new();
+        for error in &errors.errors {
+            form.add(error.field.as_str(), error.message.as_str());
+        }
+        form
+    }
+}
+
+impl From<ValidationError> for FormErrors {
+    fn from(errors: ValidationError) -> Self {
+        Self::from(&errors)
+    }
+}
+
+impl HttpRequest {
+    /// Decode an `application/x-www-form-urlencoded` or
+    /// `multipart/form-data` body into `T` and validate it.
+    ///
+    /// Fields are matched by name; use `#[serde(rename = "...")]` for a
+    /// form field named differently from the struct field. Values convert
+    /// as in [`HttpRequest::bind_query`], except that browsers submit every
+    /// input, filled in or not, so:
+    ///
+    /// - an empty field binds as the zero value of its type: `0`, `false`,
+    ///   an empty string, or `None` for an `Option`
+    /// - a `bool` field that wasn't submitted is `false`, the way an
+    ///   unchecked checkbox is left out of the form
+    /// - a `Vec<T>` takes every value of a repeated field, such as a
+    ///   multi-select, skipping empty ones; commas are not split
+    ///
+    /// Other fields that weren't submitted are `required` errors unless
+    /// they have a default. Files in a multipart form are ignored; read
+    /// them with [`HttpRequest::multipart_form`].
+    ///
+    /// # Errors
+    ///
+    /// - [`Error::UnsupportedMediaType`] if the `Content-Type` is not a form
+    /// - [`Error::BadRequest`] if a multipart body is malformed
+    /// - [`Error::ValidationFailed`] with every field that doesn't convert,
+    ///   is missing or fails a [`Validate`] rule. Rules are checked even
+    ///   when some fields don't convert, with those fields bound as if they
+    ///   were empty, and only the conversion error is kept for them.
+    ///   Convert it to [`FormErrors`] to show the messages in a template,
+    ///   or use [`bind_form_partial`](Self::bind_form_partial) to keep the
+    ///   values that did bind.
+    ///
+    /// # Examples
+    ///
+    /// ```
+    /// # tokio_test::block_on(async {
+    /// use armature_core::{Error, FormErrors, HttpRequest, Validate, ValidationError};
+    /// use serde::Deserialize;
+    ///
+    /// #[derive(Deserialize)]
+    /// struct Signup {
+    ///     email: String,
+    ///     age: Option<u8>,
+    ///     newsletter: bool,
+    ///     #[serde(rename = "interest")]
+    ///     interests: Vec<String>,
+    /// }
+    ///
+    /// impl Validate for Signup {
+    ///     fn validate(&self) -> Result<(), ValidationError> {
+    ///         let mut errors = ValidationError::new();
+    ///         errors.require("email", &self.email);
+    ///         errors.into_result()
+    ///     }
+    /// }
+    ///
+    /// let mut req = HttpRequest::new("POST".into(), "/signup".into());
+    /// req.headers.insert(
+    ///     "Content-Type".into(),
+    ///     "application/x-www-form-urlencoded".into(),
+    /// );
+    /// req.body = b"email=ada%40example.com&age=&interest=rust&interest=web".to_vec();
+    ///
+    /// let signup: Signup = req.bind_form().await.unwrap();
+    /// assert_eq!(signup.email, "ada@example.com");
+    /// assert_eq!(signup.age, None);
+    /// assert!(!signup.newsletter);
+    /// assert_eq!(signup.interests, ["rust", "web"]);
+    ///
+    /// req.body = b"email=&age=old".to_vec();
+    /// let Err(Error::ValidationFailed(errors)) = req.bind_form::<Signup>().await else {
+    ///     panic!("expected a validation failure");
+    /// };
+    /// let errors = FormErrors::from(errors);
+    /// assert_eq!(errors.get("email"), Some("is required"));
+    /// assert_eq!(errors.get("age"), Some("expected a non-negative integer, got `old`"));
+    /// # });
+    /// ```
+    pub async fn bind_form<T>(&self) -> Result<T, Error>
+    where
+        T: DeserializeOwned + Validate,
+    {
+        let (value, errors) = self.bind_form_fields().await?;
+        errors.into_result()?;
+        Ok(value)
+    }
+
+    /// Like [`bind_form`](Self::bind_form), but return the form together
+    /// with its field errors instead of failing on them.
+    ///
+    /// The value holds every field that converted, so the form can be
+    /// rendered again with what the user typed; fields that didn't convert
+    /// are bound as if they were empty. The errors are empty if the form is
+    /// valid.
+    ///
+    /// # Errors
+    ///
+    /// - [`Error::UnsupportedMediaType`] if the `Content-Type` is not a form
+    /// - [`Error::BadRequest`] if a multipart body is malformed
+    ///
+    /// # Examples
+    ///
+    /// ```
+    /// # tokio_test::block_on(async {
+    /// use armature_core::{HttpRequest, Validate, ValidationError};
+    /// use serde::Deserialize;
+    ///
+    /// #[derive(Deserialize)]
+    /// struct Signup {
+    ///     email: String,
+    ///     age: Option<u8>,
+    /// }
+    ///
+    /// impl Validate for Signup {
+    ///     fn validate(&self) -> Result<(), ValidationError> {
+    ///         Ok(())
+    ///     }
+    /// }
+    ///
+    /// let mut req = HttpRequest::new("POST".into(), "/signup".into());
+    /// req.body = b"email=ada%40example.com&age=old".to_vec();
+    ///
+    /// let (signup, errors) = req.bind_form_partial::<Signup>().await.unwrap();
+    /// assert_eq!(signup.email, "ada@example.com");
+    /// assert_eq!(signup.age, None);
+    /// assert!(errors.has("age"));
+    /// # });
+    /// ```
+    pub async fn bind_form_partial<T>(&self) -> Result<(T, FormErrors), Error>
+    where
+        T: DeserializeOwned + Validate,
+    {
+        let (value, errors) = self.bind_form_fields().await?;
+        Ok((value, FormErrors::from(errors)))
+    }
+
+    /// Bind and validate the form, collecting conversion and rule errors
+    async fn bind_form_fields<T>(&self) -> Result<(T, ValidationError), Error>
+    where
+        T: DeserializeOwned + Validate,
+    {
+        let params = form_params(self).await?;
+        let (value, mut errors) =
+            deserialize_params::<T>(vec![(Source::Form, params)], None, false)?;
+
+        if let Err(failed) = value.validate() {
+            for error in failed.errors {
+                // A field that didn't convert was validated as empty
+                if errors.field_errors(&error.field).next().is_none() {
+                    errors.push(error);
+                }
+            }
+        }
+        Ok((value, errors))
+    }
+}
+
+/// Text fields of a URL-encoded or multipart body. A request without a
+/// `Content-Type` is read as URL-encoded.
+async fn form_params(req: &HttpRequest) -> Result<Params, Error> {
+    let content_type = req
+        .header("content-type")
+        .unwrap_or("application/x-www-form-urlencoded");
+    let essence = content_type.split(';').next().unwrap_or_default().trim();
+
+    if essence.eq_ignore_ascii_case("application/x-www-form-urlencoded") {
+        let body = String::from_utf8_lossy(req.body_ref());
+        Ok(parse_query_pairs(&body))
+    } else if essence.eq_ignore_ascii_case("multipart/form-data") {
+        let form = req.multipart_form().await?;
+        // Multipart fields are kept by name; sort them so errors come in a
+        // stable order
+        let mut names: Vec<&str> = form.value_names().collect();
+        names.sort_unstable();
+        Ok(names
+            .into_iter()
+            .map(|name| (name.to_string(), form.values(name).to_vec()))
+            .collect())
+    } else {
+        Err(Error::UnsupportedMediaType(format!(
+            "expected a form, got {}",
+            content_type
+        )))
+    }
+}
+
+/// Deserialize `T` from request parameters, reporting every value that
+/// fails to convert.
+///
+/// Without `nested`, `T` is bound from the only section. With it, `T` has
+/// a field per section named after its source, plus a `body` field decoded
+/// from `body` as JSON.
+fn bind_params<T>(
+    sections: Vec<(Source, Params)>,
+    body: Option<&[u8]>,
+    nested: bool,
+) -> Result<T, Error>
+where
+    T: DeserializeOwned,
+{
+    let (value, errors) = deserialize_params(sections, body, nested)?;
+    errors.into_result()?;
+    Ok(value)
+}
+
+/// Deserialize `T` like [`bind_params`], returning the value bound without
+/// the parameters that failed to convert along with their errors.
+///
+/// Form fields that fail to convert are bound as if they were empty, so a
+/// value is returned whenever the form has no other errors.
+fn deserialize_params<T>(
+    mut sections: Vec<(Source, Params)>,
+    body: Option<&[u8]>,
+    nested: bool,
+) -> Result<(T, ValidationError), Error>
+where
+    T: DeserializeOwned,
+{
+    // A value that fails to convert aborts deserialization. To report
+    // every bad parameter, drop the offending one and try again; the
+    // loop ends after at most one retry per parameter.
+    let mut errors = ValidationError::new();
+    let mut rejected: Vec<String> = Vec::new();
+    loop {
+        let result = if nested {
+            serde_path_to_error::deserialize::<_, T>(RequestDeserializer {
+                sections: &sections,
+                body,
+            })
+        } else {
+            let (source, params) = &sections[0];
+            serde_path_to_error::deserialize::<_, T>(ParamsDeserializer::new(params, *source))
+        };
+        let err = match result {
+            Ok(value) => return Ok((value, errors)),
+            Err(err) => err,
+        };
+
+        let path = format_path(err.path());
+        match err.into_inner() {
+            // An unchecked checkbox isn't submitted at all. Bind missing
+            // form fields as absent, which makes a `bool` false and leaves
+            // other types to report `BindError::Absent`.
+            BindError::Missing(name)
+                if !nested
+                    && path.is_empty()
+                    && sections[0].0 == Source::Form
+                    && !sections[0].1.iter().any(|(k, _)| k == name) =>
+            {
+                sections[0].1.push((name.to_string(), Vec::new()));
+            }
+            BindError::Missing(name) => {
+                let field = if path.is_empty() {
+                    name.to_string()
+                } else {
+                    format!("{}.{}", path, name)
+                };
+                // Fields dropped after a conversion error are already
+                // reported
+                if !rejected.contains(&field) {
+                    errors.add(field, "required", "is required");
+                }
+                return Err(errors.into());
+            }
+            BindError::Absent => {
+                if !rejected.contains(&path) {
+                    errors.add(path, "required", "is required");
+                }
+                return Err(errors.into());
+            }
+            BindError::Json(err) => {
+                return Err(match json_error(path, err) {
+                    Error::ValidationFailed(body_errors) => {
+                        errors.errors.extend(body_errors.errors);
+                        errors.into()
+                    }
+                    other => other,
+                });
+            }
+            BindError::Invalid(message) => {
+                // Find the parameter the error points at
+                let (section, rest) = if nested {
+                    match path.split_once('.') {
+                        Some((name, rest)) => {
+                            (sections.iter().position(|(s, _)| s.name() == name), rest)
+                        }
+                        None => (None, ""),
+                    }
+                } else {
+                    (Some(0), path.as_str())
+                };
+                let key = rest.split(['[', '.']).next().unwrap_or_default();
+                let field = path[..path.len() - rest.len() + key.len()].to_string();
+                let found = section.and_then(|i| {
+                    let (source, params) = &sections[i];
+                    let j = params.iter().position(|(k, _)| source.matches(k, key))?;
+                    Some((i, j))
+                });
+
+                let Some((i, j)) = found else {
+                    let label = if nested {
+                        "request"
+                    } else {
+                        sections[0].0.name()
+                    };
+                    errors.add(
+                        if path.is_empty() {
+                            label.to_string()
+                        } else {
+                            path
+                        },
+                        "type",
+                        message,
+                    );
+                    return Err(errors.into());
+                };
+                errors.add(path, "type", message);
+                let (source, params) = &mut sections[i];
+                if *source == Source::Form && !rejected.contains(&field) {
+                    params[j].1 = vec![String::new()];
+                } else {
+                    params.remove(j);
+                }
+                rejected.push(field);
+            }
+        }
+    }
+}
+
+/// Part of the request that parameters are bound from.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Source {
+    Path,
+    Query,
+    Header,
+    Form,
+}
+
+impl Source {
+    /// Field that holds this source in [`HttpRequest::bind`]
+    fn name(self) -> &'static str {
+        match self {
+            Source::Path => "path",
+            Source::Query => "query",
+            Source::Header => "headers",
+            Source::Form => "form",
+        }
+    }
+
+    /// Whether the parameter `key` belongs to the field `field`
+    fn matches(self, key: &str, field: &str) -> bool {
+        match self {
+            Source::Header => key.eq_ignore_ascii_case(field),
+            Source::Path | Source::Query | Source::Form => key == field,
+        }
+    }
+}
+
+/// Request parameters grouped by key, in order of first appearance.
+type Params = Vec<(String, Vec<String>)>;
+
+/// Query parameters from the raw query string, or from `query_params` for
+/// requests built without one
+fn query_params(req: &HttpRequest) -> Params {
+    match req.query_string() {
+        Some(query) => parse_query_pairs(query),
+        None => group_pairs(req.query_params.iter().map(|(k, v)| (k.clone(), v.clone()))),
+    }
+}
+
+/// Headers by lowercase name, sorted so errors come in a stable order
+fn header_params(req: &HttpRequest) -> Params {
+    let mut headers: Vec<(String, String)> = req
+        .headers
+        .iter()
+        .map(|(k, v)| (k.to_ascii_lowercase(), v.clone()))
+        .collect();
+    headers.sort();
+    group_pairs(headers.into_iter())
+}
+
+/// Path parameters, sorted so errors come in a stable order
+fn path_params(req: &HttpRequest) -> Params {
+    let mut params: Vec<(String, String)> = req
+        .path_params
+        .iter()
+        .map(|(k, v)| (k.clone(), v.clone()))
+        .collect();
+    params.sort();
+    group_pairs(params.into_iter())
+}
+
+/// Split and percent-decode a raw query string, treating `+` as a space.
+fn parse_query_pairs(query: &str) -> Params {
+    let decode = |s: &str| {
+        let s = s.replace('+', " ");
+        match urlencoding::decode(&s) {
+            Ok(decoded) => decoded.into_owned(),
+            Err(_) => s,
+        }
+    };
+    group_pairs(
+        query
+            .split('&')
+            .filter(|pair| !pair.is_empty())
+            .map(|pair| {
+                let (key, value) = pair.split_once('=').unwrap_or((pair, ""));
+                (decode(key), decode(value))
+            }),
+    )
+}
+
+fn group_pairs(pairs: impl Iterator<Item = (String, String)>) -> Params {
+    let mut params: Params = Vec::new();
+    for (key, value) in pairs {
+        match params.iter_mut().find(|(k, _)| *k == key) {
+            Some((_, values)) => values.push(value),
+            None => params.push((key, vec![value])),
+        }
+    }
+    params
+}
+
+/// Error raised while deserializing request parameters.
+#[derive(Debug)]
+enum BindError {
+    /// A field without a default was absent
+    Missing(&'static str),
+    /// A value could not be converted
+    Invalid(String),
+    /// A form field without a zero value was not submitted
+    Absent,
+    /// The body of [`HttpRequest::bind`] is not valid JSON for its field
+    Json(serde_json::Error),
+}
+
+impl fmt::Display for BindError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            BindError::Missing(field) => write!(f, "missing field `{}`", field),
+            BindError::Invalid(message) => f.write_str(message),
+            BindError::Absent => f.write_str("field was not submitted"),
+            BindError::Json(err) => err.fmt(f),
+        }
+    }
+}
+
+impl std::error::Error for BindError {}
+
+impl serde::de::Error for BindError {
+    fn custom<T: fmt::Display>(msg: T) -> Self {
+        BindError::Invalid(msg.to_string())
+    }
+
+    fn missing_field(field: &'static str) -> Self {
+        BindError::Missing(field)
+    }
+}
+
+/// Deserializes one source of parameters as a map.
+struct ParamsDeserializer<'a> {
+    params: &'a [(String, Vec<String>)],
+    source: Source,
+}
+
+impl<'a> ParamsDeserializer<'a> {
+    fn new(params: &'a [(String, Vec<String>)], source: Source) -> Self {
+        Self { params, source }
+    }
+}
+
+impl<'de> serde::Deserializer<'de> for ParamsDeserializer<'_> {
+    type Error = BindError;
+
+    fn deserialize_any<V: serde::de::Visitor<'de>>(
+        self,
+        visitor: V,
+    ) -> Result<V::Value, BindError> {
+        self.deserialize_struct("", &[], visitor)
+    }
+
+    fn deserialize_struct<V: serde::de::Visitor<'de>>(
+        self,
+        _name: &'static str,
+        fields: &'static [&'static str],
+        visitor: V,
+    ) -> Result<V::Value, BindError> {
+        visitor.visit_map(ParamsMap {
+            params: self.params.iter(),
+            values: None,
+            source: self.source,
+            fields,
+        })
+    }
+
+    serde::forward_to_deserialize_any! {
+        bool i8 i16 i32 i64 i128 u8 u16 u32 u64 u128 f32 f64 char str string
+        bytes byte_buf option unit unit_struct newtype_struct seq tuple
+        tuple_struct map enum identifier ignored_any
+    }
+}
+
+struct ParamsMap<'a> {
+    params: std::slice::Iter<'a, (String, Vec<String>)>,
+    values: Option<&'a [String]>,
+    source: Source,
+    /// Field names of the struct being bound, if known
+    fields: &'static [&'static str],
+}
+
+impl<'de> serde::de::MapAccess<'de> for ParamsMap<'_> {
+    type Error = BindError;
+
+    fn next_key_seed<K>(&mut self, seed: K) -> Result<Option<K::Value>, BindError>
+    where
+        K: serde::de::DeserializeSeed<'de>,
+    {
+        use serde::de::IntoDeserializer;
+
+        match self.params.next() {
+            Some((key, values)) => {
+                self.values = Some(values);
+                // Spell case-insensitive keys like the field they match
+                let key = self
+                    .fields
+                    .iter()
+                    .copied()
+                    .find(|field| self.source.matches(key, field))
+                    .unwrap_or(key);
+                seed.deserialize(key.into_deserializer()).map(Some)
+            }
+            None => Ok(None),
+        }
+    }
+
+    fn next_value_seed<V>(&mut self, seed: V) -> Result<V::Value, BindError>
+    where
+        V: serde::de::DeserializeSeed<'de>,
+    {
+        let values = self.values.take().unwrap_or_default();
+        seed.deserialize(ParamValues(values, self.source))
+    }
+}
+
+/// Deserializes a request for [`HttpRequest::bind`], with one map entry
+/// per parameter source followed by the body.
+struct RequestDeserializer<'a> {
+    sections: &'a [(Source, Params)],
+    body: Option<&'a [u8]>,
+}
+
+impl<'de> serde::Deserializer<'de> for RequestDeserializer<'de> {
+    type Error = BindError;
+
+    fn deserialize_any<V: serde::de::Visitor<'de>>(
+        self,
+        visitor: V,
+    ) -> Result<V::Value, BindError> {
+        visitor.visit_map(RequestMap {
+            sections: self.sections.iter(),
+            section: None,
+            body: self.body,
+        })
+    }
+
+    serde::forward_to_deserialize_any! {
+        bool i8 i16 i32 i64 i128 u8 u16 u32 u64 u128 f32 f64 char str string
+        bytes byte_buf option unit unit_struct newtype_struct seq tuple
+        tuple_struct map struct enum identifier ignored_any
+    }
+}
+
+struct RequestMap<'a> {
+    sections: std::slice::Iter<'a, (Source, Params)>,
+    section: Option<&'a (Source, Params)>,
+    body: Option<&'a [u8]>,
+}
+
+impl<'de> serde::de::MapAccess<'de> for RequestMap<'de> {
+    type Error = BindError;
+
+    fn next_key_seed<K>(&mut self, seed: K) -> Result<Option<K::Value>, BindError>
+    where
+        K: serde::de::DeserializeSeed<'de>,
+    {
+        use serde::de::IntoDeserializer;
+
+        if let Some(section) = self.sections.next() {
+            self.section = Some(section);
+            seed.deserialize(section.0.name().into_deserializer())
+                .map(Some)
+        } else if self.body.is_some() {
+            seed.deserialize("body".into_deserializer()).map(Some)
+        } else {
+            Ok(None)
+        }
+    }
+
+    fn next_value_seed<V>(&mut self, seed: V) -> Result<V::Value, BindError>
+    where
+        V: serde::de::DeserializeSeed<'de>,
+    {
+        if let Some((source, params)) = self.section.take() {
+            return seed.deserialize(ParamsDeserializer::new(params, *source));
+        }
+        let body = self.body.take().unwrap_or_default();
+        let mut de = serde_json::Deserializer::from_slice(body);
+        let value = seed.deserialize(&mut de).map_err(BindError::Json)?;
+        de.end().map_err(BindError::Json)?;
+        Ok(value)
+    }
+}
+
+/// Every value given for one parameter.
+struct ParamValues<'a>(&'a [String], Source);
+
+impl ParamValues<'_> {
+    /// The value used for scalar fields.
+    ///
+    /// Fails with [`BindError::Absent`] for a form field that wasn't
+    /// submitted.
+    fn last(&self) -> Result<ParamValue<'_>, BindError> {
+        match self.0.last() {
+            Some(value) => Ok(ParamValue(value, self.1)),
+            None if self.1 == Source::Form => Err(BindError::Absent),
+            None => Ok(ParamValue("", self.1)),
+        }
+    }
+}
+
+/// Deserialize scalars from the last value of a parameter.
+macro_rules! forward_to_last {
+    ($($method:ident)*) => {
+        $(
+            fn $method<V: serde::de::Visitor<'de>>(self, visitor: V) -> Result<V::Value, BindError> {
+                self.last()?.$method(visitor)
+            }
+        )*
+    };
+}
+
+impl<'de> serde::Deserializer<'de> for ParamValues<'_> {
+    type Error = BindError;
+
+    fn deserialize_any<V: serde::de::Visitor<'de>>(
+        self,
+        visitor: V,
+    ) -> Result<V::Value, BindError> {
+        if self.0.len() > 1 {
+            self.deserialize_seq(visitor)
+        } else {
+            self.last()?.deserialize_any(visitor)
+        }
+    }
+
+    fn deserialize_bool<V: serde::de::Visitor<'de>>(
+        self,
+        visitor: V,
+    ) -> Result<V::Value, BindError> {
+        // Checkboxes are only submitted when checked
+        if self.0.is_empty() && self.1 == Source::Form {
+            return visitor.visit_bool(false);
+        }
+        self.last()?.deserialize_bool(visitor)
+    }
+
+    fn deserialize_seq<V: serde::de::Visitor<'de>>(
+        self,
+        visitor: V,
+    ) -> Result<V::Value, BindError> {
+        let source = self.1;
+        let items: Vec<&str> = match (self.0, source) {
+            // Header lists are comma-separated with optional whitespace
+            (values, Source::Header) => values
+                .iter()
+                .flat_map(|value| value.split(','))
+                .map(str::trim)
+                .filter(|item| !item.is_empty())
+                .collect(),
+            // Form lists come from repeated fields; blank ones are skipped
+            (values, Source::Form) => values
+                .iter()
+                .map(String::as_str)
+                .filter(|item| !item.is_empty())
+                .collect(),
+            ([single], _) if single.is_empty() => Vec::new(),
+            ([single], _) => single.split(',').collect(),
+            (values, _) => values.iter().map(String::as_str).collect(),
+        };
+        visitor.visit_seq(serde::de::value::SeqDeserializer::new(
+            items.into_iter().map(|item| ParamValue(item, source)),
+        ))
+    }
+
+    fn deserialize_option<V: serde::de::Visitor<'de>>(
+        self,
+        visitor: V,
+    ) -> Result<V::Value, BindError> {
+        if self.0.iter().all(String::is_empty) {
+            visitor.visit_none()
+        } else {
+            visitor.visit_some(self)
+        }
+    }
+
+    fn deserialize_newtype_struct<V: serde::de::Visitor<'de>>(
+        self,
+        _name: &'static str,
+        visitor: V,
+    ) -> Result<V::Value, BindError> {
+        visitor.visit_newtype_struct(self)
+    }
+
+    fn deserialize_tuple<V: serde::de::Visitor<'de>>(
+        self,
+        _len: usize,
+        visitor: V,
+    ) -> Result<V::Value, BindError> {
+        self.deserialize_seq(visitor)
+    }
+
+    fn deserialize_map<V: serde::de::Visitor<'de>>(
+        self,
+        _visitor: V,
+    ) -> Result<V::Value, BindError> {
+        Err(BindError::Invalid(
+            "nested structures are not supported in query strings".to_string(),
+        ))
+    }
+
+    fn deserialize_struct<V: serde::de::Visitor<'de>>(
+        self,
+        _name: &'static str,
+        _fields: &'static [&'static str],
+        visitor: V,
+    ) -> Result<V::Value, BindError> {
+        self.deserialize_map(visitor)
+    }
+
+    forward_to_last! {
+        deserialize_i8 deserialize_i16 deserialize_i32 deserialize_i64 deserialize_i128
+        deserialize_u8 deserialize_u16 deserialize_u32 deserialize_u64
+        deserialize_u128 deserialize_f32 deserialize_f64 deserialize_char deserialize_str
+        deserialize_string deserialize_bytes deserialize_byte_buf deserialize_unit
+        deserialize_identifier deserialize_ignored_any
+    }
+
+    fn deserialize_unit_struct<V: serde::de::Visitor<'de>>(
+        self,
+        name: &'static str,
+        visitor: V,
+    ) -> Result<V::Value, BindError> {
+        self.last()?.deserialize_unit_struct(name, visitor)
+    }
+
+    fn deserialize_tuple_struct<V: serde::de::Visitor<'de>>(
+        self,
+        _name: &'static str,
+        _len: usize,
+        visitor: V,
+    ) -> Result<V::Value, BindError> {
+        self.deserialize_seq(visitor)
+    }
+
+    fn deserialize_enum<V: serde::de::Visitor<'de>>(
+        self,
+        name: &'static str,
+        variants: &'static [&'static str],
+        visitor: V,
+    ) -> Result<V::Value, BindError> {
+        self.last()?.deserialize_enum(name, variants, visitor)
+    }
+}
+
+/// A single parameter value, converted to the requested type.
+struct ParamValue<'a>(&'a str, Source);
+
+impl ParamValue<'_> {
+    /// Whether the value is an empty form field, bound as the zero value
+    fn is_blank_field(&self) -> bool {
+        self.0.is_empty() && self.1 == Source::Form
+    }
+
+    fn parse<T: std::str::FromStr>(&self, expected: &str) -> Result<T, BindError> {
+        let text = if self.is_blank_field() { "0" } else { self.0 };
+        text.parse()
+            .map_err(|_| BindError::Invalid(format!("expected {}, got `{}`", expected, self.0)))
+    }
+}
+
+macro_rules! deserialize_parsed {
+    ($($method:ident => $visit:ident, $expected:literal;)*) => {
+        $(
+            fn $method<V: serde::de::Visitor<'de>>(self, visitor: V) -> Result<V::Value, BindError> {
+                visitor.$visit(self.parse($expected)?)
+            }
+        )*
+    };
+}
+
+impl<'de> serde::Deserializer<'de> for ParamValue<'_> {
+    type Error = BindError;
+
+    fn deserialize_any<V: serde::de::Visitor<'de>>(
+        self,
+        visitor: V,
+    ) -> Result<V::Value, BindError> {
+        visitor.visit_str(self.0)
+    }
+
+    fn deserialize_bool<V: serde::de::Visitor<'de>>(
+        self,
+        visitor: V,
+    ) -> Result<V::Value, BindError> {
+        let value = match self.0.to_ascii_lowercase().as_str() {
+            "true" | "1" | "on" => true,
+            "false" | "0" | "off" => false,
+            "" if self.is_blank_field() => false,
+            _ => {
+                return Err(BindError::Invalid(format!(
+                    "expected a boolean, got `{}`",
+                    self.0
+                )));
+            }
+        };
+        visitor.visit_bool(value)
+    }
+
+    deserialize_parsed! {
+        deserialize_i8 => visit_i8, "an integer";
+        deserialize_i16 => visit_i16, "an integer";
+        deserialize_i32 => visit_i32, "an integer";
+        deserialize_i64 => visit_i64, "an integer";
+        deserialize_i128 => visit_i128, "an integer";
+        deserialize_u8 => visit_u8, "a non-negative integer";
+        deserialize_u16 => visit_u16, "a non-negative integer";
+        deserialize_u32 => visit_u32, "a non-negative integer";
+        deserialize_u64 => visit_u64, "a non-negative integer";
+        deserialize_u128 => visit_u128, "a non-negative integer";
+        deserialize_f32 => visit_f32, "a number";
+        deserialize_f64 => visit_f64, "a number";
+        deserialize_char => visit_char, "a single character";
+    }
+
+    fn deserialize_option<V: serde::de::Visitor<'de>>(
+        self,
+        visitor: V,
+    ) -> Result<V::Value, BindError> {
+        if self.0.is_empty() {
+            visitor.visit_none()
+        } else {
+            visitor.visit_some(self)
+        }
+    }
+
+    fn deserialize_newtype_struct<V: serde::de::Visitor<'de>>(
+        self,
+        _name: &'static str,
+        visitor: V,
+    ) -> Result<V::Value, BindError> {
+        visitor.visit_newtype_struct(self)
+    }
+
+    fn deserialize_enum<V: serde::de::Visitor<'de>>(
+        self,
+        _name: &'static str,
+        _variants: &'static [&'static str],
+        visitor: V,
+    ) -> Result<V::Value, BindError> {
+        use serde::de::IntoDeserializer;
+
+        visitor.visit_enum(self.0.into_deserializer())
+    }
+
+    fn deserialize_unit<V: serde::de::Visitor<'de>>(
+        self,
+        visitor: V,
+    ) -> Result<V::Value, BindError> {
+        visitor.visit_unit()
+    }
+
+    serde::forward_to_deserialize_any! {
+        str string bytes byte_buf unit_struct seq tuple tuple_struct map struct
+        identifier ignored_any
+    }
+}
+
+impl<'de> serde::de::IntoDeserializer<'de, BindError> for ParamValue<'_> {
+    type Deserializer = Self;
+
+    fn into_deserializer(self) -> Self {
+        self
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde::Deserialize;
+
+    #[derive(Debug, Deserialize)]
+    struct Signup {
+        name: Option<String>,
+        email: Option<String>,
+        #[serde(default)]
+        age: u8,
+        #[serde(default)]
+        address: Option<Address>,
+    }
+
+    #[derive(Debug, Deserialize)]
+    struct Address {
+        city: String,
+    }
+
+    impl Validate for Signup {
+        fn validate(&self) -> Result<(), ValidationError> {
+            let mut errors = ValidationError::new();
+            errors.require("name", &self.name);
+            errors.require("email", &self.email);
+            if let Some(email) = &self.email {
+                errors.check(email.contains('@'), "email", "email", "must be an email");
+            }
+            errors.check(self.age >= 18, "age", "min", "must be at least 18");
+            errors.into_result()
+        }
+    }
+
+    fn json_request(body: &str) -> HttpRequest {
+        let mut req = HttpRequest::new("POST".into(), "/signup".into());
+        req.headers
+            .insert("Content-Type".into(), "application/json".into());
+        req.body = body.as_bytes().to_vec();
+        req
+    }
+
+    fn field_errors(err: Error) -> Vec<(String, String)> {
+        match err {
+            Error::ValidationFailed(errors) => errors
+                .errors()
+                .iter()
+                .map(|e| (e.field.clone(), e.rule.clone()))
+                .collect(),
+            other => panic!("expected validation failure, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn test_bind_json_valid() {
+        let req = json_request(
+            r#"{"name": "Ada", "email": "ada@example.com", "age": 36, "address": {"city": "London"}}"#,
+        );
+        let signup: Signup = req.bind_json().unwrap();
+        assert_eq!(signup.name.as_deref(), Some("Ada"));
+        assert_eq!(signup.age, 36);
+        assert_eq!(signup.address.unwrap().city, "London");
+    }
+
+    #[test]
+    fn test_bind_json_collects_all_rule_failures() {
+        let req = json_request(r#"{"name": "  ", "email": "nope", "age": 12}"#);
+        let err = req.bind_json::<Signup>().unwrap_err();
+        assert_eq!(err.status_code(), 422);
+        assert_eq!(
+            field_errors(err),
+            vec![
+                ("name".to_string(), "required".to_string()),
+                ("email".to_string(), "email".to_string()),
+                ("age".to_string(), "min".to_string()),
+            ]
+        );
+    }
+
+    #[test]
+    fn test_bind_json_type_mismatch_reports_field_path() {
+        let req = json_request(r#"{"name": "Ada", "age": "old"}"#);
+        assert_eq!(
+            field_errors(req.bind_json::<Signup>().unwrap_err()),
+            vec![("age".to_string(), "type".to_string())]
+        );
+
+        let req = json_request(r#"{"name": "Ada", "address": {"city": 5}}"#);
+        assert_eq!(
+            field_errors(req.bind_json::<Signup>().unwrap_err()),
+            vec![("address.city".to_string(), "type".to_string())]
+        );
+    }
+
+    #[test]
+    fn test_bind_json_missing_nested_field_is_required() {
+        let req = json_request(r#"{"name": "Ada", "address": {}}"#);
+        assert_eq!(
+            field_errors(req.bind_json::<Signup>().unwrap_err()),
+            vec![("address.city".to_string(), "required".to_string())]
+        );
+    }
+
+    #[test]
+    fn test_bind_json_malformed_is_bad_request() {
+        let req = json_request(r#"{"name": "Ada""#);
+        let err = req.bind_json::<Signup>().unwrap_err();
+        assert!(matches!(err, Error::BadRequest(_)));
+
+        let req = json_request(r#"{"name": "Ada"} trailing"#);
+        assert!(matches!(
+            req.bind_json::<Signup>().unwrap_err(),
+            Error::BadRequest(_)
+        ));
+    }
+
+    #[test]
+    fn test_bind_json_empty_body() {
+        let req = json_request("  ");
+        let err = req.bind_json::<Signup>().unwrap_err();
+        assert!(matches!(err, Error::BadRequest(ref m) if m.contains("empty")));
+
+        // Lenient mode binds `{}` and then reports the missing fields
+        let err = req
+            .bind_json_with::<Signup>(&BindOptions::lenient())
+            .unwrap_err();
+        assert_eq!(
+            field_errors(err),
+            vec![
+                ("name".to_string(), "required".to_string()),
+                ("email".to_string(), "required".to_string()),
+                ("age".to_string(), "min".to_string()),
+            ]
+        );
+    }
+
+    #[test]
+    fn test_bind_json_content_type_policies() {
+        let mut req = json_request(r#"{"name": "Ada", "email": "a@b.c", "age": 20}"#);
+
+        req.headers.insert(
+            "Content-Type".into(),
+            "application/json; charset=utf-8".into(),
+        );
+        assert!(req.bind_json::<Signup>().is_ok());
+
+        req.headers
+            .insert("Content-Type".into(), "application/vnd.api+json".into());
+        assert!(req.bind_json::<Signup>().is_ok());
+
+        req.headers
+            .insert("Content-Type".into(), "text/plain".into());
+        let err = req.bind_json::<Signup>().unwrap_err();
+        assert_eq!(err.status_code(), 415);
+        assert!(
+            req.bind_json_with::<Signup>(&BindOptions::lenient())
+                .is_ok()
+        );
+
+        req.headers.clear();
+        assert!(req.bind_json::<Signup>().is_ok());
+        let err = req
+            .bind_json_with::<Signup>(&BindOptions::strict())
+            .unwrap_err();
+        assert_eq!(err.status_code(), 415);
+    }
+
+    #[test]
+    fn test_bind_json_unknown_fields() {
+        let req = json_request(
+            r#"{"name": "Ada", "email": "a@b.c", "age": 20, "admin": true, "address": {"city": "x", "zip": 1}}"#,
+        );
+        assert!(req.bind_json::<Signup>().is_ok());
+
+        let options = BindOptions::new().deny_unknown_fields(true);
+        assert_eq!(
+            field_errors(req.bind_json_with::<Signup>(&options).unwrap_err()),
+            vec![
+                ("admin".to_string(), "unknown".to_string()),
+                ("address.zip".to_string(), "unknown".to_string()),
+            ]
+        );
+    }
+
+    #[test]
+    fn test_validation_error_helpers() {
+        let mut inner = ValidationError::new();
+        inner.add("city", "required", "is required");
+
+        let mut errors = ValidationError::new();
+        errors
+            .require("tags", &Vec::<String>::new())
+            .nest("address", inner);
+
+        assert_eq!(errors.len(), 2);
+        assert_eq!(errors.field_errors("address.city").count(), 1);
+        assert_eq!(
+            errors.to_string(),
+            "tags (required): is required; address.city (required): is required"
+        );
+
+        let json = serde_json::to_value(&errors).unwrap();
+        assert_eq!(json["errors"][1]["field"], "address.city");
+        assert!(ValidationError::new().into_result().is_ok());
+    }
+
+    #[derive(Debug, Deserialize, PartialEq)]
+    #[serde(rename_all = "lowercase")]
+    enum Sort {
+        Asc,
+        Desc,
+    }
+
+    /// A `YYYY-MM-DD` date, deserialized from a string like chrono types
+    #[derive(Debug, PartialEq)]
+    struct Date(u16, u8, u8);
+
+    impl<'de> Deserialize<'de> for Date {
+        fn deserialize<D: serde::Deserializer<'de>>(d: D) -> Result<Self, D::Error> {
+            let text = String::deserialize(d)?;
+            let parts: Vec<&str> = text.split('-').collect();
+            match parts.as_slice() {
+                [y, m, d] => match (y.parse(), m.parse(), d.parse()) {
+                    (Ok(y), Ok(m), Ok(d)) => Ok(Date(y, m, d)),
+                    _ => Err(serde::de::Error::custom("expected a YYYY-MM-DD date")),
+                },
+                _ => Err(serde::de::Error::custom("expected a YYYY-MM-DD date")),
+            }
+        }
+    }
+
+    fn default_limit() -> u32 {
+        25
+    }
+
+    #[derive(Debug, Deserialize)]
+    struct Listing {
+        #[serde(default)]
+        page: u32,
+        #[serde(default = "default_limit")]
+        limit: u32,
+        #[serde(default)]
+        tags: Vec<String>,
+        #[serde(default)]
+        ids: Vec<u64>,
+        #[serde(default)]
+        archived: bool,
+        sort: Option<Sort>,
+        since: Option<Date>,
+        #[serde(rename = "q", default)]
+        search: String,
+    }
+
+    fn query_request(query: &str) -> HttpRequest {
+        let mut req = HttpRequest::new("GET".into(), "/items".into());
+        req.set_query_string(query);
+        req
+    }
+
+    #[test]
+    fn test_bind_query_converts_types() {
+        let listing: Listing =
+            query_request("page=3&limit=10&archived=on&sort=desc&since=2024-02-29&q=red+shoes%21")
+                .bind_query()
+                .unwrap();
+
+        assert_eq!(listing.page, 3);
+        assert_eq!(listing.limit, 10);
+        assert!(listing.archived);
+        assert_eq!(listing.sort, Some(Sort::Desc));
+        assert_eq!(listing.since, Some(Date(2024, 2, 29)));
+        assert_eq!(listing.search, "red shoes!");
+    }
+
+    #[test]
+    fn test_bind_query_defaults() {
+        let listing: Listing = query_request("").bind_query().unwrap();
+        assert_eq!(listing.page, 0);
+        assert_eq!(listing.limit, 25);
+        assert!(listing.tags.is_empty());
+        assert!(!listing.archived);
+        assert_eq!(listing.sort, None);
+
+        // Empty values count as absent for options
+        let listing: Listing = query_request("sort=&since=").bind_query().unwrap();
+        assert_eq!(listing.sort, None);
+        assert_eq!(listing.since, None);
+    }
+
+    #[test]
+    fn test_bind_query_slices() {
+        let listing: Listing = query_request("tags=a&tags=b&ids=1,2,3")
+            .bind_query()
+            .unwrap();
+        assert_eq!(listing.tags, ["a", "b"]);
+        assert_eq!(listing.ids, [1, 2, 3]);
+
+        let listing: Listing = query_request("tags=a%2Cb&ids=4&ids=5")
+            .bind_query()
+            .unwrap();
+        assert_eq!(listing.tags, ["a", "b"]);
+        assert_eq!(listing.ids, [4, 5]);
+
+        // A single value still fills a slice, and a repeated scalar keeps
+        // the last value
+        let listing: Listing = query_request("tags=solo&page=1&page=2")
+            .bind_query()
+            .unwrap();
+        assert_eq!(listing.tags, ["solo"]);
+        assert_eq!(listing.page, 2);
+    }
+
+    #[test]
+    fn test_bind_query_reports_every_bad_field() {
+        let err = query_request("page=two&limit=10&archived=maybe&ids=1,x")
+            .bind_query::<Listing>()
+            .unwrap_err();
+        assert_eq!(
+            field_errors(err),
+            [
+                ("page".to_string(), "type".to_string()),
+                ("archived".to_string(), "type".to_string()),
+                ("ids[1]".to_string(), "type".to_string()),
+            ]
+        );
+
+        let err = query_request("page=-1")
+            .bind_query::<Listing>()
+            .unwrap_err();
+        let Error::ValidationFailed(errors) = err else {
+            panic!("expected validation failure");
+        };
+        assert_eq!(
+            errors.errors()[0].message,
+            "expected a non-negative integer, got `-1`"
+        );
+    }
+
+    #[test]
+    fn test_bind_query_required_fields() {
+        #[derive(Debug, Deserialize)]
+        struct Lookup {
+            #[allow(dead_code)]
+            id: u32,
+            #[allow(dead_code)]
+            name: String,
+        }
+
+        let err = query_request("name=x").bind_query::<Lookup>().unwrap_err();
+        assert_eq!(
+            field_errors(err),
+            [("id".to_string(), "required".to_string())]
+        );
+
+        // A bad value is reported once, not again as missing
+        let err = query_request("id=abc&name=x")
+            .bind_query::<Lookup>()
+            .unwrap_err();
+        assert_eq!(field_errors(err), [("id".to_string(), "type".to_string())]);
+    }
+
+    #[test]
+    fn test_bind_query_without_raw_query_string() {
+        let mut req = HttpRequest::new("GET".into(), "/items".into());
+        req.query_params.insert("page".into(), "4".into());
+
+        let listing: Listing = req.bind_query().unwrap();
+        assert_eq!(listing.page, 4);
+    }
+
+    #[derive(Debug, Deserialize)]
+    #[serde(rename_all = "kebab-case")]
+    struct ApiHeaders {
+        #[serde(rename = "X-Api-Version")]
+        version: u32,
+        #[serde(default)]
+        accept_language: Vec<String>,
+        user_agent: Option<String>,
+    }
+
+    fn header_request(headers: &[(&str, &str)]) -> HttpRequest {
+        let mut req = HttpRequest::new("GET".into(), "/items".into());
+        for (name, value) in headers {
+            req.headers.insert(name.to_string(), value.to_string());
+        }
+        req
+    }
+
+    #[test]
+    fn test_bind_headers_match_case_insensitively() {
+        let headers: ApiHeaders = header_request(&[
+            ("x-api-version", "3"),
+            ("USER-AGENT", "curl/8.0, like Gecko"),
+        ])
+        .bind_headers()
+        .unwrap();
+        assert_eq!(headers.version, 3);
+        assert!(headers.accept_language.is_empty());
+        // Scalars keep commas in the value
+        assert_eq!(headers.user_agent.as_deref(), Some("curl/8.0, like Gecko"));
+    }
+
+    #[test]
+    fn test_bind_headers_lists() {
+        let headers: ApiHeaders = header_request(&[
+            ("X-Api-Version", "1"),
+            ("Accept-Language", "en-GB , en;q=0.8,, fr"),
+        ])
+        .bind_headers()
+        .unwrap();
+        assert_eq!(headers.accept_language, ["en-GB", "en;q=0.8", "fr"]);
+
+        // The same header under names differing only in case
+        let headers: ApiHeaders = header_request(&[
+            ("X-Api-Version", "1"),
+            ("accept-language", "de"),
+            ("Accept-Language", "en, fr"),
+        ])
+        .bind_headers()
+        .unwrap();
+        let mut languages = headers.accept_language;
+        languages.sort();
+        assert_eq!(languages, ["de", "en", "fr"]);
+    }
+
+    #[test]
+    fn test_bind_headers_errors() {
+        let err = header_request(&[("User-Agent", "x")])
+            .bind_headers::<ApiHeaders>()
+            .unwrap_err();
+        assert_eq!(
+            field_errors(err),
+            [("X-Api-Version".to_string(), "required".to_string())]
+        );
+
+        let err = header_request(&[("x-api-version", "latest")])
+            .bind_headers::<ApiHeaders>()
+            .unwrap_err();
+        assert_eq!(
+            field_errors(err),
+            [("X-Api-Version".to_string(), "type".to_string())]
+        );
+    }
+
+    #[derive(Debug, Deserialize)]
+    struct CommentPath {
+        post_id: u64,
+        id: u32,
+    }
+
+    fn path_request(params: &[(&str, &str)]) -> HttpRequest {
+        let mut req = HttpRequest::new("GET".into(), "/posts/1/comments/2".into());
+        for (name, value) in params {
+            req.path_params.insert(name.to_string(), value.to_string());
+        }
+        req
+    }
+
+    #[test]
+    fn test_bind_path() {
+        let path: CommentPath = path_request(&[("post_id", "7"), ("id", "3")])
+            .bind_path()
+            .unwrap();
+        assert_eq!((path.post_id, path.id), (7, 3));
+
+        // Path parameter names are case-sensitive
+        let err = path_request(&[("post_id", "7"), ("ID", "3")])
+            .bind_path::<CommentPath>()
+            .unwrap_err();
+        assert_eq!(
+            field_errors(err),
+            [("id".to_string(), "required".to_string())]
+        );
+
+        let err = path_request(&[("post_id", "x"), ("id", "-3")])
+            .bind_path::<CommentPath>()
+            .unwrap_err();
+        assert_eq!(
+            field_errors(err),
+            [
+                ("id".to_string(), "type".to_string()),
+                ("post_id".to_string(), "type".to_string()),
+            ]
+        );
+    }
+
+    #[derive(Debug, Deserialize)]
+    struct UpdateComment {
+        path: CommentPath,
+        query: Notify,
+        headers: ApiHeaders,
+        #[serde(default)]
+        body: Option<CommentChanges>,
+    }
+
+    #[derive(Debug, Deserialize)]
+    struct Notify {
+        #[serde(default)]
+        notify: bool,
+    }
+
+    #[derive(Debug, Deserialize)]
+    struct CommentChanges {
+        text: String,
+    }
+
+    impl Validate for UpdateComment {
+        fn validate(&self) -> Result<(), ValidationError> {
+            let mut errors = ValidationError::new();
+            if let Some(body) = &self.body {
+                errors.require("body.text", &body.text);
+            }
+            errors.into_result()
+        }
+    }
+
+    fn update_request(body: &str) -> HttpRequest {
+        let mut req = path_request(&[("post_id", "7"), ("id", "3")]);
+        req.set_query_string("notify=1");
+        req.headers.insert("X-Api-Version".into(), "2".into());
+        req.body = body.as_bytes().to_vec();
+        req
+    }
+
+    #[test]
+    fn test_bind_combines_sources() {
+        let update: UpdateComment = update_request(r#"{"text": "Nice"}"#).bind().unwrap();
+        assert_eq!((update.path.post_id, update.path.id), (7, 3));
+        assert!(update.query.notify);
+        assert_eq!(update.headers.version, 2);
+        assert_eq!(update.body.unwrap().text, "Nice");
+
+        // Without a body, a defaulted `body` field stays empty
+        let update: UpdateComment = update_request("").bind().unwrap();
+        assert!(update.body.is_none());
+
+        // Validation runs on the combined value
+        let err = update_request(r#"{"text": " "}"#)
+            .bind::<UpdateComment>()
+            .unwrap_err();
+        assert_eq!(
+            field_errors(err),
+            [("body.text".to_string(), "required".to_string())]
+        );
+    }
+
+    #[test]
+    fn test_bind_reports_errors_from_every_source() {
+        let mut req = update_request(r#"{"text": 5}"#);
+        req.set_query_string("notify=maybe");
+        req.headers.clear();
+
+        assert_eq!(
+            field_errors(req.bind::<UpdateComment>().unwrap_err()),
+            [
+                ("query.notify".to_string(), "type".to_string()),
+                ("headers.X-Api-Version".to_string(), "required".to_string()),
+            ]
+        );
+
+        let mut req = update_request(r#"{"text": 5}"#);
+        req.set_query_string("notify=maybe");
+        assert_eq!(
+            field_errors(req.bind::<UpdateComment>().unwrap_err()),
+            [
+                ("query.notify".to_string(), "type".to_string()),
+                ("body.text".to_string(), "type".to_string()),
+            ]
+        );
+
+        let mut req = update_request("{}");
+        req.path_params.insert("id".into(), "three".into());
+        assert_eq!(
+            field_errors(req.bind::<UpdateComment>().unwrap_err()),
+            [("path.id".to_string(), "type".to_string())]
+        );
+
+        let req = update_request("{}");
+        assert_eq!(
+            field_errors(req.bind::<UpdateComment>().unwrap_err()),
+            [("body.text".to_string(), "required".to_string())]
+        );
+    }
+
+    #[test]
+    fn test_bind_body_errors() {
+        let err = update_request(r#"{"text": "#)
+            .bind::<UpdateComment>()
+            .unwrap_err();
+        assert!(matches!(err, Error::BadRequest(_)));
+
+        let err = update_request(r#"{"text": "a"} trailing"#)
+            .bind::<UpdateComment>()
+            .unwrap_err();
+        assert!(matches!(err, Error::BadRequest(_)));
+
+        let mut req = update_request(r#"{"text": "a"}"#);
+        req.headers
+            .insert("Content-Type".into(), "text/plain".into());
+        assert_eq!(req.bind::<UpdateComment>().unwrap_err().status_code(), 415);
+    }
+
+    #[derive(Debug, Deserialize)]
+    struct Profile {
+        name: String,
+        age: u8,
+        nickname: Option<String>,
+        #[serde(rename = "newsletter")]
+        subscribed: bool,
+        #[serde(rename = "terms")]
+        accepted_terms: bool,
+        #[serde(rename = "role")]
+        roles: Vec<String>,
+        #[serde(default)]
+        scores: Vec<u32>,
+    }
+
+    impl Validate for Profile {
+        fn validate(&self) -> Result<(), ValidationError> {
+            let mut errors = ValidationError::new();
+            errors.require("name", &self.name);
+            errors.check(self.age >= 18, "age", "min", "must be at least 18");
+            errors.check(self.accepted_terms, "terms", "accepted", "must be accepted");
+            errors.into_result()
+        }
+    }
+
+    fn form_request(body: &str) -> HttpRequest {
+        let mut req = HttpRequest::new("POST".into(), "/profile".into());
+        req.headers.insert(
+            "Content-Type".into(),
+            "application/x-www-form-urlencoded".into(),
+        );
+        req.body = body.as_bytes().to_vec();
+        req
+    }
+
+    fn form_errors(err: Error) -> Vec<(String, String, String)> {
+        match err {
+            Error::ValidationFailed(errors) => errors
+                .errors()
+                .iter()
+                .map(|e| (e.field.clone(), e.rule.clone(), e.message.clone()))
+                .collect(),
+            other => panic!("expected validation failure, got {:?}", other),
+        }
+    }
+
+    #[tokio::test]
+    async fn test_bind_form_missing_checkboxes() {
+        let profile: Profile = form_request("name=Ada&age=36&terms=on&newsletter=on")
+            .bind_form()
+            .await
+            .unwrap();
+        assert!(profile.subscribed);
+        assert!(profile.accepted_terms);
+
+        // Unchecked boxes aren't submitted and bind as false
+        let profile: Profile = form_request("name=Ada&age=36&terms=on")
+            .bind_form()
+            .await
+            .unwrap();
+        assert!(!profile.subscribed);
+        assert!(profile.accepted_terms);
+        assert!(profile.roles.is_empty());
+
+        let err = form_request("name=Ada&age=36")
+            .bind_form::<Profile>()
+            .await
+            .unwrap_err();
+        assert_eq!(
+            form_errors(err),
+            [(
+                "terms".to_string(),
+                "accepted".to_string(),
+                "must be accepted".to_string()
+            )]
+        );
+
+        // Other fields that aren't submitted are still required
+        let err = form_request("age=36&terms=on")
+            .bind_form::<Profile>()
+            .await
+            .unwrap_err();
+        assert_eq!(
+            form_errors(err),
+            [(
+                "name".to_string(),
+                "required".to_string(),
+                "is required".to_string()
+            )]
+        );
+    }
+
+    #[tokio::test]
+    async fn test_bind_form_empty_fields() {
+        let profile: Profile =
+            form_request("name=Ada&age=36&nickname=&terms=1&newsletter=&scores=")
+                .bind_form()
+                .await
+                .unwrap();
+        assert_eq!(profile.nickname, None);
+        assert!(!profile.subscribed);
+        assert!(profile.scores.is_empty());
+
+        // An empty number is zero, which validation then rejects
+        let err = form_request("name=Ada&age=&terms=on")
+            .bind_form::<Profile>()
+            .await
+            .unwrap_err();
+        assert_eq!(
+            form_errors(err),
+            [(
+                "age".to_string(),
+                "min".to_string(),
+                "must be at least 18".to_string()
+            )]
+        );
+    }
+
+    #[tokio::test]
+    async fn test_bind_form_repeated_fields() {
+        let profile: Profile = form_request(
+            "name=Ada&age=36&terms=on&role=admin&role=&role=editor%2C+writer&scores=3&scores=5",
+        )
+        .bind_form()
+        .await
+        .unwrap();
+        // Every value is kept, blank ones are skipped and commas are not split
+        assert_eq!(profile.roles, ["admin", "editor, writer"]);
+        assert_eq!(profile.scores, [3, 5]);
+
+        // A scalar field takes the last value
+        let profile: Profile = form_request("name=Ada&name=Grace&age=36&terms=on")
+            .bind_form()
+            .await
+            .unwrap();
+        assert_eq!(profile.name, "Grace");
+    }
+
+    #[tokio::test]
+    async fn test_bind_form_aggregates_errors() {
+        let err = form_request("name=+&age=old&newsletter=maybe&scores=1&scores=x")
+            .bind_form::<Profile>()
+            .await
+            .unwrap_err();
+        let Error::ValidationFailed(errors) = err else {
+            panic!("expected validation failure, got {:?}", err);
+        };
+        assert_eq!(
+            errors
+                .errors()
+                .iter()
+                .map(|e| (e.field.as_str(), e.rule.as_str()))
+                .collect::<Vec<_>>(),
+            [
+                ("age", "type"),
+                ("newsletter", "type"),
+                ("scores[1]", "type"),
+                ("name", "required"),
+                ("terms", "accepted"),
+            ]
+        );
+
+        let form = FormErrors::from(&errors);
+        assert_eq!(form.len(), 5);
+        assert_eq!(form.get("name"), Some("is required"));
+        // The conversion error is kept, not the rule checked on the zero value
+        assert_eq!(
+            form.get("age"),
+            Some("expected a non-negative integer, got `old`")
+        );
+        assert_eq!(
+            form.get("newsletter"),
+            Some("expected a boolean, got `maybe`")
+        );
+        assert_eq!(form.get("terms"), Some("must be accepted"));
+        assert!(!form.has("nickname"));
+        assert_eq!(
+            form.iter().map(|(field, _)| field).collect::<Vec<_>>(),
+            ["age", "name", "newsletter", "scores[1]", "terms"]
+        );
+    }
+
+    #[tokio::test]
+    async fn test_bind_form_partial_keeps_valid_fields() {
+        let (profile, errors) =
+            form_request("name=Ada&age=old&nickname=ace&role=admin&scores=1&scores=x")
+                .bind_form_partial::<Profile>()
+                .await
+                .unwrap();
+        assert_eq!(profile.name, "Ada");
+        assert_eq!(profile.nickname.as_deref(), Some("ace"));
+        assert_eq!(profile.roles, ["admin"]);
+        assert_eq!(
+            errors.iter().map(|(field, _)| field).collect::<Vec<_>>(),
+            ["age", "scores[1]", "terms"]
+        );
+
+        let (profile, errors) = form_request("name=Ada&age=36&terms=on")
+            .bind_form_partial::<Profile>()
+            .await
+            .unwrap();
+        assert_eq!(profile.age, 36);
+        assert!(errors.is_empty());
+    }
+
+    #[tokio::test]
+    async fn test_bind_form_multipart() {
+        const BOUNDARY: &str = "form-boundary";
+        let mut body = String::new();
+        for (name, value) in [
+            ("name", "Ada"),
+            ("age", "36"),
+            ("role", "admin"),
+            ("role", "editor"),
+        ] {
+            body.push_str(&format!(
+                "--{}\r\nContent-Disposition: form-data; name=\"{}\"\r\n\r\n{}\r\n",
+                BOUNDARY, name, value
+            ));
+        }
+        body.push_str(&format!(
+            "--{}\r\nContent-Disposition: form-data; name=\"avatar\"; filename=\"a.png\"\r\n\
+             Content-Type: image/png\r\n\r\npng\r\n--{}--\r\n",
+            BOUNDARY, BOUNDARY
+        ));
+
+        let mut req = HttpRequest::new("POST".into(), "/profile".into());
+        req.headers.insert(
+            "Content-Type".into(),
+            format!("multipart/form-data; boundary={}", BOUNDARY),
+        );
+        req.body = body.into_bytes();
+
+        let err = req.bind_form::<Profile>().await.unwrap_err();
+        assert_eq!(
+            form_errors(err),
+            [(
+                "terms".to_string(),
+                "accepted".to_string(),
+                "must be accepted".to_string()
+            )]
+        );
+        #[derive(Debug, Deserialize)]
+        struct Roles {
+            name: String,
+            role: Vec<String>,
+        }
+        impl Validate for Roles {
+            fn validate(&self) -> Result<(), ValidationError> {
+                Ok(())
+            }
+        }
+        let roles: Roles = req.bind_form().await.unwrap();
+        assert_eq!(roles.name, "Ada");
+        assert_eq!(roles.role, ["admin", "editor"]);
+    }
+
+    #[tokio::test]
+    async fn test_bind_form_content_type() {
+        let mut req = form_request("name=Ada&age=36&terms=on");
+        req.headers.remove("Content-Type");
+        assert!(req.bind_form::<Profile>().await.is_ok());
+
+        req.headers
+            .insert("Content-Type".into(), "application/json".into());
+        assert_eq!(
+            req.bind_form::<Profile>().await.unwrap_err().status_code(),
+            415
+        );
+    }
+}
@@ -15,6 +15,22 @@
 //! let middleware = BodyLimitMiddleware::megabytes(10);
 //! ```
 //!
+//! ## Enforcing Limits While Reading
+//!
+//! The middleware checks bodies after the server has read them. To stop
+//! oversized uploads before they are buffered, including chunked requests
+//! without a `Content-Length`, configure the limit on the application:
+//!
+//! ```rust,ignore
+//! use armature_core::{Application, body_limits::BodyLimitConfig};
+//!
+//! let app = Application::new(container, router).with_body_limit(
+//!     BodyLimitConfig::new()
+//!         .default_limit_kb(64)
+//!         .route_limit_mb("/api/upload", 100),
+//! );
+//! ```
+//!
 //! ## Using the Decorator
 //!
 //! ```ignore
@@ -35,6 +51,8 @@
 
 use crate::{Error, HttpRequest, HttpResponse};
 use async_trait::async_trait;
+use bytes::{Buf, Bytes};
+use http_body_util::BodyExt;
 use std::collections::HashMap;
 use std::sync::Arc;
 
@@ -180,14 +198,12 @@ impl BodyLimitConfig {
             return *limit;
         }
 
-        // Check for prefix matches
-        for (pattern, limit) in &self.route_limits {
-            if path.starts_with(pattern) {
-                return *limit;
-            }
-        }
-
-        self.default_limit
+        // Check for prefix matches, preferring the most specific
+        self.route_limits
+            .iter()
+            .filter(|(pattern, _)| path.starts_with(pattern.as_str()))
+            .max_by_key(|(pattern, _)| pattern.len())
+            .map_or(self.default_limit, |(_, limit)| *limit)
     }
 
     /// Formats the error message for a limit violation.
@@ -215,6 +231,10 @@ impl BodyLimitConfig {
 
 /// Simple body limit middleware with a fixed size limit.
 ///
+/// The check runs once the body has been read. Use
+/// `Application::with_body_limit` to reject oversized bodies while they
+/// are still being received.
+///
 /// ## Example
 ///
 /// ```rust
@@ -267,10 +287,10 @@ impl crate::middleware::Middleware for BodyLimitMiddleware {
         req: HttpRequest,
         next: crate::middleware::Next,
     ) -> Result<HttpResponse, Error> {
-        if req.body.len() > self.max_size {
+        if req.body_ref().len() > self.max_size {
             return Err(Error::PayloadTooLarge(format!(
                 "Request body size ({}) exceeds maximum allowed size ({})",
-                format_bytes(req.body.len()),
+                format_bytes(req.body_ref().len()),
                 format_bytes(self.max_size)
             )));
         }
@@ -319,8 +339,8 @@ impl crate::middleware::Middleware for ConfigurableBodyLimitMiddleware {
     ) -> Result<HttpResponse, Error> {
         let limit = self.config.get_limit_for_path(&req.path);
 
-        if req.body.len() > limit {
-            let error_msg = self.config.format_error(req.body.len(), limit);
+        if req.body_ref().len() > limit {
+            let error_msg = self.config.format_error(req.body_ref().len(), limit);
             return Err(Error::PayloadTooLarge(error_msg));
         }
 
@@ -411,6 +431,49 @@ impl BodyLimitBuilder {
     }
 }
 
+/// Outcome of reading a request body under a size limit.
+#[derive(Debug)]
+pub(crate) enum LimitedBody {
+    /// The whole body, within the limit
+    Complete(Bytes),
+    /// The body exceeded the limit; holds the advertised or read size
+    TooLarge(usize),
+}
+
+/// Reads a request body, enforcing `limit` as bytes arrive.
+///
+/// A `Content-Length` above the limit is rejected before anything is read.
+/// Bodies without one (chunked transfer) are rejected as soon as the
+/// running total exceeds the limit.
+pub(crate) async fn read_limited<B>(
+    mut body: B,
+    content_length: Option<u64>,
+    limit: usize,
+) -> Result<LimitedBody, B::Error>
+where
+    B: hyper::body::Body + Unpin,
+{
+    if let Some(length) = content_length
+        && length > limit as u64
+    {
+        return Ok(LimitedBody::TooLarge(
+            usize::try_from(length).unwrap_or(usize::MAX),
+        ));
+    }
+
+    let mut buffer = Vec::new();
+    while let Some(frame) = body.frame().await {
+        if let Ok(mut data) = frame?.into_data() {
+            let size = buffer.len() + data.remaining();
+            if size > limit {
+                return Ok(LimitedBody::TooLarge(size));
+            }
+            buffer.extend_from_slice(&data.copy_to_bytes(data.remaining()));
+        }
+    }
+    Ok(LimitedBody::Complete(Bytes::from(buffer)))
+}
+
 /// Formats a byte count into a human-readable string.
 pub fn format_bytes(bytes: usize) -> String {
     if bytes >= sizes::GB {
@@ -657,4 +720,34 @@ mod tests {
 
         assert!(result.is_err());
     }
+
+    #[test]
+    fn test_body_limit_config_longest_prefix_wins() {
+        let config = BodyLimitConfig::new()
+            .route_limit("/api", 10)
+            .route_limit("/api/upload", 20);
+
+        for _ in 0..10 {
+            assert_eq!(config.get_limit_for_path("/api/upload/1"), 20);
+            assert_eq!(config.get_limit_for_path("/api/users"), 10);
+        }
+    }
+
+    #[tokio::test]
+    async fn test_read_limited() {
+        use http_body_util::Full;
+
+        let body = Full::new(Bytes::from_static(b"0123456789"));
+        let result = read_limited(body, None, 10).await.unwrap();
+        assert!(matches!(result, LimitedBody::Complete(bytes) if bytes.len() == 10));
+
+        let body = Full::new(Bytes::from_static(b"0123456789a"));
+        let result = read_limited(body, None, 10).await.unwrap();
+        assert!(matches!(result, LimitedBody::TooLarge(11)));
+
+        // The advertised length is checked before reading
+        let body = Full::new(Bytes::new());
+        let result = read_limited(body, Some(11), 10).await.unwrap();
+        assert!(matches!(result, LimitedBody::TooLarge(11)));
+    }
 }
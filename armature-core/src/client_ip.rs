@@ -0,0 +1,538 @@
+//! Client IP resolution behind reverse proxies.
+//!
+//! [`HttpRequest::client_ip`] returns the address of the client that sent a
+//! request. By default that is the TCP peer ([`HttpRequest::remote_addr`]).
+//! Behind a load balancer the peer is the proxy, and the client's address is
+//! carried in a forwarding header instead: `Forwarded` ([RFC 7239]),
+//! `X-Forwarded-For` or `X-Real-IP`. Anyone can send those headers, so they
+//! are only believed when the peer is a proxy listed in [`TrustedProxies`],
+//! configured with
+//! [`Application::with_trusted_proxies`](crate::Application::with_trusted_proxies),
+//! and only the one header the proxies are known to set is read (see
+//! [`ForwardedHeader`]); the others may come straight from the client.
+//!
+//! Each proxy appends the address it received the request from, so the chain
+//! is read right to left: trusted proxies are skipped and the first address
+//! that isn't one of them is the client. Entries further left were written by
+//! the client itself and are ignored.
+//!
+//! [RFC 7239]: https://www.rfc-editor.org/rfc/rfc7239
+//!
+//! # Examples
+//!
+//! ```
+//! use armature_core::{HttpRequest, RemoteAddr, TrustedProxies};
+//!
+//! let proxies = TrustedProxies::new().trust("10.0.0.0/8").unwrap();
+//!
+//! let mut req = HttpRequest::new("GET".into(), "/".into());
+//! req.extensions.insert(RemoteAddr("10.0.0.2:41000".parse().unwrap()));
+//! req.headers.insert(
+//!     "X-Forwarded-For".into(),
+//!     "6.6.6.6, 203.0.113.7, 10.0.0.1".into(),
+//! );
+//!
+//! assert_eq!(proxies.client_ip(&req), Some("203.0.113.7".parse().unwrap()));
+//! ```
+
+use crate::{Error, HttpRequest};
+use std::net::{IpAddr, SocketAddr};
+
+/// Address of the peer a request was received from.
+///
+/// The server stores this in the request extensions for every connection.
+/// Requests passed to [`Application::test`](crate::Application::test) can
+/// carry one, set with `http::request::Builder::extension`, to simulate a
+/// peer.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct RemoteAddr(pub SocketAddr);
+
+/// Marks a request the client sent over HTTPS.
+///
+/// The server stores this in the request extensions for TLS connections, and
+/// for requests a trusted proxy reports as HTTPS (see
+/// [`TrustedProxies::forwarded_https`]). Read it with
+/// [`HttpRequest::is_secure`]; like [`RemoteAddr`], tests can set it to
+/// simulate an HTTPS request.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct Https;
+
+/// The forwarding header set by the trusted proxies.
+///
+/// Proxies add to their own header but pass the others through from the
+/// client unchanged, so only this one is read.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub enum ForwardedHeader {
+    /// The `for=` parameters of the standard `Forwarded` header
+    Forwarded,
+    /// A comma-separated `X-Forwarded-For` list
+    #[default]
+    XForwardedFor,
+    /// A single `X-Real-IP` address, replaced by the proxy
+    XRealIp,
+}
+
+/// Client address resolved by [`TrustedProxies`] when the request arrived
+#[derive(Debug, Clone, Copy)]
+pub(crate) struct ClientIp(pub(crate) IpAddr);
+
+/// An IP network in CIDR notation
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+struct IpRange {
+    addr: IpAddr,
+    prefix: u8,
+}
+
+impl IpRange {
+    fn parse(s: &str) -> Option<Self> {
+        let (addr, prefix) = match s.split_once('/') {
+            Some((addr, prefix)) => (addr.parse::<IpAddr>().ok()?, Some(prefix.parse().ok()?)),
+            None => (s.parse::<IpAddr>().ok()?, None),
+        };
+        let addr = addr.to_canonical();
+        let max = if addr.is_ipv4() { 32 } else { 128 };
+        let prefix = prefix.unwrap_or(max);
+        (prefix <= max).then_some(Self { addr, prefix })
+    }
+
+    fn contains(&self, ip: IpAddr) -> bool {
+        match (self.addr, ip) {
+            (IpAddr::V4(net), IpAddr::V4(ip)) => {
+                prefix_eq(u32::from(net).into(), u32::from(ip).into(), 32, self.prefix)
+            }
+            (IpAddr::V6(net), IpAddr::V6(ip)) => {
+                prefix_eq(u128::from(net), u128::from(ip), 128, self.prefix)
+            }
+            _ => false,
+        }
+    }
+}
+
+/// Whether the top `prefix` bits of two `bits`-wide addresses are equal
+fn prefix_eq(a: u128, b: u128, bits: u8, prefix: u8) -> bool {
+    prefix == 0 || (a ^ b) >> (bits - prefix) == 0
+}
+
+/// The set of reverse proxies whose forwarding headers are believed.
+///
+/// Ranges are given in CIDR notation (`10.0.0.0/8`, `fd00::/8`) or as single
+/// addresses. IPv4-mapped IPv6 addresses such as `::ffff:10.0.0.1` match the
+/// corresponding IPv4 ranges. The client address is read from
+/// `X-Forwarded-For` unless another [`ForwardedHeader`] is chosen with
+/// [`header`](Self::header).
+///
+/// # Examples
+///
+/// ```
+/// use armature_core::TrustedProxies;
+///
+/// let proxies = TrustedProxies::new()
+///     .trust("10.0.0.0/8")?
+///     .trust("2001:db8::1")?;
+/// assert!(proxies.contains("10.1.2.3".parse().unwrap()));
+/// assert!(!proxies.contains("192.168.0.1".parse().unwrap()));
+///
+/// assert!(TrustedProxies::new().trust("10.0.0.0/33").is_err());
+/// # Ok::<(), armature_core::Error>(())
+/// ```
+#[derive(Debug, Clone, Default)]
+pub struct TrustedProxies {
+    ranges: Vec<IpRange>,
+    header: ForwardedHeader,
+}
+
+impl TrustedProxies {
+    /// Create an empty set that trusts no proxy
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Trust loopback and private network addresses.
+    ///
+    /// Covers `127.0.0.0/8`, `10.0.0.0/8`, `172.16.0.0/12`, `192.168.0.0/16`,
+    /// `::1` and `fc00::/7`, which suits a proxy on the same host or inside
+    /// the same private network.
+    pub fn private_networks() -> Self {
+        let ranges = [
+            "127.0.0.0/8",
+            "10.0.0.0/8",
+            "172.16.0.0/12",
+            "192.168.0.0/16",
+            "::1",
+            "fc00::/7",
+        ];
+        Self {
+            ranges: ranges.iter().filter_map(|r| IpRange::parse(r)).collect(),
+            ..Self::default()
+        }
+    }
+
+    /// Read the client address from `header` instead of `X-Forwarded-For`.
+    ///
+    /// Pick the header your proxies set; any other forwarding header on a
+    /// request is ignored.
+    pub fn header(mut self, header: ForwardedHeader) -> Self {
+        self.header = header;
+        self
+    }
+
+    /// Add a trusted range in CIDR notation, or a single address.
+    ///
+    /// # Errors
+    ///
+    /// Returns [`Error::Internal`] if `cidr` is not a valid address or range.
+    pub fn trust(mut self, cidr: &str) -> Result<Self, Error> {
+        let range = IpRange::parse(cidr.trim())
+            .ok_or_else(|| Error::Internal(format!("invalid trusted proxy range {:?}", cidr)))?;
+        self.ranges.push(range);
+        Ok(self)
+    }
+
+    /// Whether `ip` belongs to a trusted proxy
+    pub fn contains(&self, ip: IpAddr) -> bool {
+        let ip = ip.to_canonical();
+        self.ranges.iter().any(|range| range.contains(ip))
+    }
+
+    /// Resolve the client address of a request.
+    ///
+    /// Returns the peer address unless the peer is trusted. Otherwise only
+    /// the configured [`ForwardedHeader`] is read. A `Forwarded` or
+    /// `X-Forwarded-For` chain is walked right to left, skipping trusted
+    /// proxies; if every hop is trusted, the leftmost one is the client. A
+    /// hop that can't be parsed (including `unknown` and obfuscated
+    /// identifiers) ends the walk at the proxy that reported it. `X-Real-IP`
+    /// is used when it holds a valid address. Without the header, the peer
+    /// is the client.
+    ///
+    /// Returns `None` if the request has no [`RemoteAddr`].
+    pub fn client_ip(&self, req: &HttpRequest) -> Option<IpAddr> {
+        let peer = req.remote_addr()?.ip().to_canonical();
+        if !self.contains(peer) {
+            return Some(peer);
+        }
+
+        let hops: Vec<&str> = match self.header {
+            ForwardedHeader::Forwarded => match req.header("forwarded") {
+                Some(forwarded) => forwarded.split(',').filter_map(forwarded_for).collect(),
+                None => Vec::new(),
+            },
+            ForwardedHeader::XForwardedFor => match req.header("x-forwarded-for") {
+                Some(xff) => xff.split(',').map(str::trim).collect(),
+                None => Vec::new(),
+            },
+            ForwardedHeader::XRealIp => {
+                return Some(req.header("x-real-ip").and_then(parse_hop).unwrap_or(peer));
+            }
+        };
+
+        let mut client = peer;
+        for hop in hops.iter().rev() {
+            let Some(ip) = parse_hop(hop) else {
+                break;
+            };
+            client = ip;
+            if !self.contains(ip) {
+                break;
+            }
+        }
+        Some(client)
+    }
+
+    /// Whether a trusted proxy reports that the client connected to it over
+    /// HTTPS.
+    ///
+    /// With [`ForwardedHeader::Forwarded`] this reads the last `proto=` of
+    /// the `Forwarded` header; otherwise the last `X-Forwarded-Proto` entry.
+    /// Either way it is the value the nearest proxy wrote. Always `false`
+    /// when the peer isn't trusted.
+    pub fn forwarded_https(&self, req: &HttpRequest) -> bool {
+        let Some(peer) = req.remote_addr() else {
+            return false;
+        };
+        if !self.contains(peer.ip()) {
+            return false;
+        }
+        let proto = match self.header {
+            ForwardedHeader::Forwarded => req.header("forwarded").and_then(|forwarded| {
+                forwarded
+                    .rsplit(',')
+                    .find_map(|element| forwarded_param(element, "proto"))
+            }),
+            ForwardedHeader::XForwardedFor | ForwardedHeader::XRealIp => req
+                .header("x-forwarded-proto")
+                .and_then(|protos| protos.rsplit(',').next())
+                .map(str::trim),
+        };
+        proto.is_some_and(|proto| proto.eq_ignore_ascii_case("https"))
+    }
+}
+
+/// The `for=` value of one `Forwarded` element, unquoted
+fn forwarded_for(element: &str) -> Option<&str> {
+    forwarded_param(element, "for")
+}
+
+/// The value of parameter `param` in one `Forwarded` element, unquoted
+fn forwarded_param<'a>(element: &'a str, param: &str) -> Option<&'a str> {
+    element.split(';').find_map(|pair| {
+        let (name, value) = pair.split_once('=')?;
+        name.trim()
+            .eq_ignore_ascii_case(param)
+            .then(|| value.trim().trim_matches('"'))
+    })
+}
+
+/// Parse a forwarded hop, which may carry a port or IPv6 brackets
+fn parse_hop(hop: &str) -> Option<IpAddr> {
+    let hop = hop.trim();
+    let ip = hop
+        .parse::<IpAddr>()
+        .ok()
+        .or_else(|| hop.parse::<SocketAddr>().ok().map(|addr| addr.ip()))
+        .or_else(|| hop.strip_prefix('[')?.strip_suffix(']')?.parse().ok())?;
+    Some(ip.to_canonical())
+}
+
+impl HttpRequest {
+    /// Address of the peer the request was received from.
+    ///
+    /// This is the immediate TCP peer, which is the proxy when the server
+    /// runs behind one. Use [`client_ip`](Self::client_ip) for the client's
+    /// address. Returns `None` for requests that didn't come from a
+    /// connection, such as ones built directly in tests.
+    pub fn remote_addr(&self) -> Option<SocketAddr> {
+        self.extensions.get::<RemoteAddr>().map(|addr| addr.0)
+    }
+
+    /// Address of the client that sent the request.
+    ///
+    /// Forwarding headers are only honored when the peer is one of the
+    /// application's trusted proxies; see [`TrustedProxies::client_ip`] for
+    /// the rules. Without trusted proxies this is the peer address, so a
+    /// client can't spoof it by sending `X-Forwarded-For`.
+    pub fn client_ip(&self) -> Option<IpAddr> {
+        match self.extensions.get::<ClientIp>() {
+            Some(ip) => Some(ip.0),
+            None => self.remote_addr().map(|addr| addr.ip().to_canonical()),
+        }
+    }
+
+    /// Whether the client sent the request over HTTPS.
+    ///
+    /// True for requests received on a TLS listener, and for requests a
+    /// trusted proxy reports as HTTPS; see [`Https`].
+    pub fn is_secure(&self) -> bool {
+        self.extensions.contains::<Https>()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn request(peer: &str, headers: &[(&str, &str)]) -> HttpRequest {
+        let mut req = HttpRequest::new("GET".into(), "/".into());
+        req.extensions.insert(RemoteAddr(peer.parse().unwrap()));
+        for (name, value) in headers {
+            req.headers.insert(name.to_string(), value.to_string());
+        }
+        req
+    }
+
+    fn ip(s: &str) -> Option<IpAddr> {
+        Some(s.parse().unwrap())
+    }
+
+    fn proxies() -> TrustedProxies {
+        TrustedProxies::new()
+            .trust("10.0.0.0/8")
+            .unwrap()
+            .trust("2001:db8::/32")
+            .unwrap()
+    }
+
+    #[test]
+    fn test_ranges() {
+        let proxies = proxies();
+        assert!(proxies.contains("10.255.0.1".parse().unwrap()));
+        assert!(proxies.contains("::ffff:10.0.0.1".parse().unwrap()));
+        assert!(proxies.contains("2001:db8:1::5".parse().unwrap()));
+        assert!(!proxies.contains("11.0.0.1".parse().unwrap()));
+        assert!(!proxies.contains("2001:db9::1".parse().unwrap()));
+
+        let everything = TrustedProxies::new().trust("0.0.0.0/0").unwrap();
+        assert!(everything.contains("198.51.100.1".parse().unwrap()));
+        assert!(!everything.contains("::2".parse().unwrap()));
+
+        for invalid in [
+            "10.0.0.0/33",
+            "::/129",
+            "10.0.0/8",
+            "localhost",
+            "10.0.0.0/",
+        ] {
+            assert!(TrustedProxies::new().trust(invalid).is_err(), "{}", invalid);
+        }
+    }
+
+    #[test]
+    fn test_untrusted_peer_cannot_spoof() {
+        let proxies = proxies();
+        let req = request(
+            "198.51.100.9:5000",
+            &[
+                ("X-Forwarded-For", "1.2.3.4"),
+                ("X-Real-IP", "1.2.3.4"),
+                ("Forwarded", "for=1.2.3.4"),
+            ],
+        );
+        assert_eq!(proxies.client_ip(&req), ip("198.51.100.9"));
+
+        // Nothing is trusted by default
+        assert_eq!(TrustedProxies::new().client_ip(&req), ip("198.51.100.9"));
+    }
+
+    #[test]
+    fn test_multi_hop_x_forwarded_for() {
+        let proxies = proxies();
+
+        // The leftmost entry is client-supplied and must be ignored
+        let req = request(
+            "10.0.0.3:80",
+            &[("X-Forwarded-For", "9.9.9.9, 203.0.113.7, 10.0.0.2,10.0.0.1")],
+        );
+        assert_eq!(proxies.client_ip(&req), ip("203.0.113.7"));
+
+        // Every hop trusted: the leftmost is the client
+        let req = request("10.0.0.3:80", &[("X-Forwarded-For", "10.0.0.9, 10.0.0.1")]);
+        assert_eq!(proxies.client_ip(&req), ip("10.0.0.9"));
+
+        // Garbage stops the walk at the proxy that reported it
+        let req = request(
+            "10.0.0.3:80",
+            &[("X-Forwarded-For", "9.9.9.9, not-an-ip, 10.0.0.1")],
+        );
+        assert_eq!(proxies.client_ip(&req), ip("10.0.0.1"));
+        let req = request("10.0.0.3:80", &[("X-Forwarded-For", "bogus")]);
+        assert_eq!(proxies.client_ip(&req), ip("10.0.0.3"));
+
+        // Ports and IPv6 are accepted
+        let req = request(
+            "[2001:db8::1]:443",
+            &[("X-Forwarded-For", "203.0.113.7:5123, [2001:db8::2]:80")],
+        );
+        assert_eq!(proxies.client_ip(&req), ip("203.0.113.7"));
+    }
+
+    #[test]
+    fn test_forwarded_header() {
+        let proxies = proxies().header(ForwardedHeader::Forwarded);
+        let req = request(
+            "10.0.0.1:80",
+            &[
+                (
+                    "Forwarded",
+                    "for=6.6.6.6, For=\"[2001:db8:cafe::17]:4711\";proto=https, \
+                     for=198.51.100.4:80;by=10.0.0.1, for=10.0.0.2",
+                ),
+                // Not the configured header
+                ("X-Forwarded-For", "7.7.7.7"),
+            ],
+        );
+        assert_eq!(proxies.client_ip(&req), ip("198.51.100.4"));
+
+        let req = request("10.0.0.1:80", &[("Forwarded", "for=unknown")]);
+        assert_eq!(proxies.client_ip(&req), ip("10.0.0.1"));
+        let req = request("10.0.0.1:80", &[("X-Forwarded-For", "7.7.7.7")]);
+        assert_eq!(proxies.client_ip(&req), ip("10.0.0.1"));
+    }
+
+    #[test]
+    fn test_only_configured_header_is_read() {
+        // A proxy setting X-Forwarded-For passes a client's Forwarded and
+        // X-Real-IP through untouched
+        let req = request(
+            "10.0.0.1:80",
+            &[
+                ("Forwarded", "for=6.6.6.6"),
+                ("X-Real-IP", "6.6.6.6"),
+                ("X-Forwarded-For", "203.0.113.7"),
+            ],
+        );
+        assert_eq!(proxies().client_ip(&req), ip("203.0.113.7"));
+
+        let req = request(
+            "10.0.0.1:80",
+            &[("Forwarded", "for=6.6.6.6"), ("X-Real-IP", "6.6.6.6")],
+        );
+        assert_eq!(proxies().client_ip(&req), ip("10.0.0.1"));
+    }
+
+    #[test]
+    fn test_x_real_ip() {
+        let proxies = proxies().header(ForwardedHeader::XRealIp);
+        let req = request(
+            "10.0.0.1:80",
+            &[("X-Real-IP", "203.0.113.7"), ("X-Forwarded-For", "6.6.6.6")],
+        );
+        assert_eq!(proxies.client_ip(&req), ip("203.0.113.7"));
+
+        let req = request("10.0.0.1:80", &[("X-Real-IP", "garbage")]);
+        assert_eq!(proxies.client_ip(&req), ip("10.0.0.1"));
+    }
+
+    #[test]
+    fn test_forwarded_https() {
+        let proxies = proxies();
+
+        let req = request("10.0.0.1:80", &[("X-Forwarded-Proto", "https")]);
+        assert!(proxies.forwarded_https(&req));
+        let req = request("10.0.0.1:80", &[("X-Forwarded-Proto", "https, http")]);
+        assert!(!proxies.forwarded_https(&req));
+        let req = request("10.0.0.1:80", &[]);
+        assert!(!proxies.forwarded_https(&req));
+
+        // Forwarded is only read when configured, and then exclusively
+        let forwarded = request(
+            "10.0.0.1:80",
+            &[
+                (
+                    "Forwarded",
+                    "for=1.2.3.4;proto=http, for=5.6.7.8;proto=HTTPS",
+                ),
+                ("X-Forwarded-Proto", "http"),
+            ],
+        );
+        assert!(!proxies.forwarded_https(&forwarded));
+        let proxies = proxies.header(ForwardedHeader::Forwarded);
+        assert!(proxies.forwarded_https(&forwarded));
+        let req = request("10.0.0.1:80", &[("X-Forwarded-Proto", "https")]);
+        assert!(!proxies.forwarded_https(&req));
+
+        // Only trusted proxies are believed
+        let req = request("198.51.100.9:5000", &[("X-Forwarded-Proto", "https")]);
+        assert!(!proxies.forwarded_https(&req));
+    }
+
+    #[test]
+    fn test_request_accessors() {
+        let req = HttpRequest::new("GET".into(), "/".into());
+        assert_eq!(req.remote_addr(), None);
+        assert_eq!(req.client_ip(), None);
+
+        let mut req = request(
+            "[::ffff:198.51.100.9]:5000",
+            &[("X-Forwarded-For", "1.2.3.4")],
+        );
+        assert_eq!(req.client_ip(), ip("198.51.100.9"));
+
+        req.extensions
+            .insert(ClientIp("203.0.113.7".parse().unwrap()));
+        assert_eq!(req.client_ip(), ip("203.0.113.7"));
+
+        assert!(!req.is_secure());
+        req.extensions.insert(Https);
+        assert!(req.is_secure());
+    }
+}
@@ -54,7 +54,7 @@
 use crate::{Error, HttpRequest, HttpResponse};
 use std::fmt;
 use std::hash::{Hash, Hasher};
-use std::time::SystemTime;
+use std::time::{Duration, SystemTime};
 
 // ============================================================================
 // ETag
@@ -172,6 +172,7 @@ impl ETag {
     }
 
     /// Generate an ETag from a string using a hash.
+    #[allow(clippy::should_implement_trait)]
     pub fn from_str(s: &str) -> Self {
         Self::from_bytes(s.as_bytes())
     }
@@ -288,9 +289,11 @@ impl ETagList {
     }
 
     /// Check if any ETag in the list matches (strong comparison).
+    ///
+    /// The wildcard matches any current representation, weak or strong.
     pub fn contains_strong(&self, etag: &ETag) -> bool {
         if self.any {
-            return !etag.weak;
+            return true;
         }
         self.etags.iter().any(|e| e.strong_match(etag))
     }
@@ -320,93 +323,125 @@ pub struct ConditionalHeaders {
 
 impl ConditionalHeaders {
     /// Parse conditional headers from an HTTP request.
+    ///
+    /// Dates that don't parse are ignored, as RFC 9110 requires.
     pub fn from_request(request: &HttpRequest) -> Self {
-        let if_none_match = request
-            .headers
-            .get("If-None-Match")
-            .or_else(|| request.headers.get("if-none-match"))
-            .map(|h| ETagList::parse(h));
-
-        let if_match = request
-            .headers
-            .get("If-Match")
-            .or_else(|| request.headers.get("if-match"))
-            .map(|h| ETagList::parse(h));
-
-        let if_modified_since = request
-            .headers
-            .get("If-Modified-Since")
-            .or_else(|| request.headers.get("if-modified-since"))
-            .and_then(|h| httpdate::parse_http_date(h).ok());
-
-        let if_unmodified_since = request
-            .headers
-            .get("If-Unmodified-Since")
-            .or_else(|| request.headers.get("if-unmodified-since"))
-            .and_then(|h| httpdate::parse_http_date(h).ok());
-
         Self {
-            if_none_match,
-            if_match,
-            if_modified_since,
-            if_unmodified_since,
+            if_none_match: request.if_none_match(),
+            if_match: request.if_match(),
+            if_modified_since: request.if_modified_since(),
+            if_unmodified_since: request.if_unmodified_since(),
         }
     }
 
     /// Check if the resource should return 304 Not Modified.
     ///
-    /// Returns true if:
-    /// - If-None-Match contains a matching ETag (weak comparison), or
-    /// - If-Modified-Since is after the resource's last modification
-    pub fn is_not_modified(&self, etag: Option<&ETag>, last_modified: Option<SystemTime>) -> bool {
-        // Check If-None-Match first (takes precedence)
-        if let Some(ref if_none_match) = self.if_none_match {
-            if let Some(etag) = etag {
-                return if_none_match.contains_weak(etag);
-            }
+    /// Returns true if the resource `exists` and:
+    /// - If-None-Match contains a matching ETag (weak comparison) or `*`, or
+    /// - If-None-Match is absent and the resource hasn't been modified after
+    ///   If-Modified-Since
+    ///
+    /// A resource that doesn't exist never matches, so `If-None-Match: *`
+    /// lets a create go ahead. If-Modified-Since only applies to `GET` and
+    /// `HEAD`; see [`evaluate`](Self::evaluate).
+    pub fn is_not_modified(
+        &self,
+        etag: Option<&ETag>,
+        last_modified: Option<SystemTime>,
+        exists: bool,
+    ) -> bool {
+        if !exists {
+            return false;
         }
-
-        // Check If-Modified-Since
-        if let (Some(if_modified_since), Some(last_modified)) =
-            (self.if_modified_since, last_modified)
-        {
-            return last_modified <= if_modified_since;
+        match &self.if_none_match {
+            Some(if_none_match) => {
+                if_none_match.any || etag.is_some_and(|etag| if_none_match.contains_weak(etag))
+            }
+            None => matches!(
+                (self.if_modified_since, last_modified),
+                (Some(since), Some(modified)) if whole_seconds(modified) <= since
+            ),
         }
-
-        false
     }
 
     /// Check if the precondition fails (should return 412).
     ///
     /// Returns true if:
-    /// - If-Match is present and no ETag matches (strong comparison), or
-    /// - If-Unmodified-Since is before the resource's last modification
+    /// - If-Match is present and no ETag matches (strong comparison), or the
+    ///   resource doesn't `exist` (even for `If-Match: *`), or
+    /// - If-Match is absent and the resource has been modified after
+    ///   If-Unmodified-Since
     pub fn precondition_failed(
         &self,
         etag: Option<&ETag>,
         last_modified: Option<SystemTime>,
+        exists: bool,
     ) -> bool {
-        // Check If-Match first (takes precedence)
-        if let Some(ref if_match) = self.if_match {
-            if let Some(etag) = etag {
-                return !if_match.contains_strong(etag);
-            } else {
-                // If-Match present but no ETag to compare - fail
-                return !if_match.any;
+        match &self.if_match {
+            Some(if_match) => {
+                !exists
+                    || !if_match.any && !etag.is_some_and(|etag| if_match.contains_strong(etag))
             }
+            None => matches!(
+                (self.if_unmodified_since, last_modified),
+                (Some(since), Some(modified)) if whole_seconds(modified) > since
+            ),
         }
+    }
 
-        // Check If-Unmodified-Since
-        if let (Some(if_unmodified_since), Some(last_modified)) =
-            (self.if_unmodified_since, last_modified)
-        {
-            return last_modified > if_unmodified_since;
-        }
+    /// Evaluate the preconditions in the order of [RFC 9110 section 13.2.2].
+    ///
+    /// 1. `If-Match`: 412 unless it matches (strong comparison) an existing
+    ///    resource.
+    /// 2. Without `If-Match`, `If-Unmodified-Since`: 412 if the resource has
+    ///    been modified since.
+    /// 3. `If-None-Match`: if it matches (weak comparison) an existing
+    ///    resource, 304 for `GET` and `HEAD`, 412 for other methods.
+    /// 4. Without `If-None-Match`, `If-Modified-Since` on `GET` and `HEAD`:
+    ///    304 if the resource hasn't been modified since.
+    ///
+    /// Returns the status to answer with, or `None` to proceed. `etag` and
+    /// `last_modified` describe the current state of the resource, and
+    /// `exists` whether it has one at all ([RFC 9110 section 13.1.1]): on
+    /// a missing resource `If-Match: *` fails and `If-None-Match: *` passes.
+    /// Date conditions are ignored without `last_modified`.
+    ///
+    /// [RFC 9110 section 13.2.2]: https://www.rfc-editor.org/rfc/rfc9110#section-13.2.2
+    /// [RFC 9110 section 13.1.1]: https://www.rfc-editor.org/rfc/rfc9110#section-13.1.1
+    pub fn evaluate(
+        &self,
+        method: &str,
+        etag: Option<&ETag>,
+        last_modified: Option<SystemTime>,
+        exists: bool,
+    ) -> Option<u16> {
+        let safe = method.eq_ignore_ascii_case("GET") || method.eq_ignore_ascii_case("HEAD");
 
-        false
+        if self.precondition_failed(etag, last_modified, exists) {
+            return Some(412);
+        }
+        match self.if_none_match {
+            Some(_) if self.is_not_modified(etag, last_modified, exists) => {
+                Some(if safe { 304 } else { 412 })
+            }
+            None if safe && self.is_not_modified(etag, last_modified, exists) => Some(304),
+            _ => None,
+        }
     }
 }
 
+/// Drop sub-second precision, which HTTP dates can't carry.
+///
+/// Without this, a resource modified at 10.5s would never match the
+/// `Last-Modified: ...10` the client echoes back.
+fn whole_seconds(time: SystemTime) -> SystemTime {
+    let secs = time
+        .duration_since(SystemTime::UNIX_EPOCH)
+        .map(|d| d.as_secs())
+        .unwrap_or(0);
+    SystemTime::UNIX_EPOCH + Duration::from_secs(secs)
+}
+
 // ============================================================================
 // Request Extensions
 // ============================================================================
@@ -446,6 +481,9 @@ pub trait ConditionalRequest {
 
     /// Evaluate all conditional headers and return the appropriate response.
     ///
+    /// Follows the evaluation order of RFC 9110 for a resource that exists;
+    /// see [`ConditionalHeaders::evaluate`].
+    ///
     /// Returns:
     /// - `Some(304)` if resource is not modified
     /// - `Some(412)` if precondition failed
@@ -455,6 +493,38 @@ pub trait ConditionalRequest {
         etag: Option<&ETag>,
         last_modified: Option<SystemTime>,
     ) -> Option<u16>;
+
+    /// Check the request's preconditions against the current state of the
+    /// resource.
+    ///
+    /// Returns the response to send when a precondition fails: `304 Not
+    /// Modified` (with the `ETag` and `Last-Modified` headers) for `GET` and
+    /// `HEAD`, `412 Precondition Failed` otherwise. Returns `None` when the
+    /// handler should go ahead, e.g. with the update. Pass `exists: false`
+    /// when there is no resource yet, so `If-None-Match: *` allows creating
+    /// it and `If-Match` fails.
+    ///
+    /// # Examples
+    ///
+    /// ```
+    /// use armature_core::HttpRequest;
+    /// use armature_core::conditional::{ConditionalRequest, ETag};
+    ///
+    /// let mut req = HttpRequest::new("PUT".into(), "/articles/1".into());
+    /// req.headers.insert("If-Match".into(), "\"v1\"".into());
+    ///
+    /// // Someone else saved v2 in the meantime
+    /// let response = req.check_preconditions(Some(&ETag::from_version(2)), None, true);
+    /// assert_eq!(response.map(|r| r.status), Some(412));
+    ///
+    /// assert!(req.check_preconditions(Some(&ETag::strong("v1")), None, true).is_none());
+    /// ```
+    fn check_preconditions(
+        &self,
+        etag: Option<&ETag>,
+        last_modified: Option<SystemTime>,
+        exists: bool,
+    ) -> Option<HttpResponse>;
 }
 
 impl ConditionalRequest for HttpRequest {
@@ -463,30 +533,20 @@ impl ConditionalRequest for HttpRequest {
     }
 
     fn if_none_match(&self) -> Option<ETagList> {
-        self.headers
-            .get("If-None-Match")
-            .or_else(|| self.headers.get("if-none-match"))
-            .map(|h| ETagList::parse(h))
+        self.header("if-none-match").map(ETagList::parse)
     }
 
     fn if_match(&self) -> Option<ETagList> {
-        self.headers
-            .get("If-Match")
-            .or_else(|| self.headers.get("if-match"))
-            .map(|h| ETagList::parse(h))
+        self.header("if-match").map(ETagList::parse)
     }
 
     fn if_modified_since(&self) -> Option<SystemTime> {
-        self.headers
-            .get("If-Modified-Since")
-            .or_else(|| self.headers.get("if-modified-since"))
+        self.header("if-modified-since")
             .and_then(|h| httpdate::parse_http_date(h).ok())
     }
 
     fn if_unmodified_since(&self) -> Option<SystemTime> {
-        self.headers
-            .get("If-Unmodified-Since")
-            .or_else(|| self.headers.get("if-unmodified-since"))
+        self.header("if-unmodified-since")
             .and_then(|h| httpdate::parse_http_date(h).ok())
     }
 
@@ -505,13 +565,13 @@ impl ConditionalRequest for HttpRequest {
 
     fn not_modified_since(&self, last_modified: SystemTime) -> bool {
         self.if_modified_since()
-            .map(|since| last_modified <= since)
+            .map(|since| whole_seconds(last_modified) <= since)
             .unwrap_or(false)
     }
 
     fn modified_since_precondition(&self, last_modified: SystemTime) -> bool {
         self.if_unmodified_since()
-            .map(|since| last_modified > since)
+            .map(|since| whole_seconds(last_modified) > since)
             .unwrap_or(false)
     }
 
@@ -520,20 +580,34 @@ impl ConditionalRequest for HttpRequest {
         etag: Option<&ETag>,
         last_modified: Option<SystemTime>,
     ) -> Option<u16> {
-        let headers = self.conditional_headers();
-
-        // Check preconditions first (412)
-        if headers.precondition_failed(etag, last_modified) {
-            return Some(412);
-        }
+        self.conditional_headers()
+            .evaluate(&self.method, etag, last_modified, true)
+    }
 
-        // Check not modified (304) - only for safe methods
-        let method = self.method.to_uppercase();
-        if (method == "GET" || method == "HEAD") && headers.is_not_modified(etag, last_modified) {
-            return Some(304);
+    fn check_preconditions(
+        &self,
+        etag: Option<&ETag>,
+        last_modified: Option<SystemTime>,
+        exists: bool,
+    ) -> Option<HttpResponse> {
+        let status = self
+            .conditional_headers()
+            .evaluate(&self.method, etag, last_modified, exists)?;
+        match status {
+            304 => {
+                let mut response = HttpResponse::not_modified();
+                if let Some(etag) = etag {
+                    response = response.with_etag(etag);
+                }
+                if let Some(lm) = last_modified {
+                    response = response.with_last_modified(lm);
+                }
+                Some(response)
+            }
+            _ => Some(HttpResponse::precondition_failed_with_message(
+                "Resource state does not match the request preconditions",
+            )),
         }
-
-        None
     }
 }
 
@@ -615,10 +689,9 @@ impl ConditionalResponse for HttpResponse {
 
 /// Check conditional headers and return appropriate response or proceed.
 ///
-/// This is a convenience function that handles the common pattern of:
-/// 1. Check preconditions (return 412 if failed)
-/// 2. Check not-modified (return 304 if not modified)
-/// 3. Continue with normal processing
+/// Same as [`ConditionalRequest::check_preconditions`] for a resource that
+/// exists: returns a 304 or 412 response when a precondition fails, and
+/// `None` to continue with normal processing.
 ///
 /// # Example
 ///
@@ -648,22 +721,7 @@ pub fn check_conditionals(
     etag: Option<&ETag>,
     last_modified: Option<SystemTime>,
 ) -> Option<HttpResponse> {
-    match request.evaluate_conditionals(etag, last_modified) {
-        Some(304) => {
-            let mut response = HttpResponse::not_modified();
-            if let Some(etag) = etag {
-                response = response.with_etag(etag);
-            }
-            if let Some(lm) = last_modified {
-                response = response.with_last_modified(lm);
-            }
-            Some(response)
-        }
-        Some(412) => Some(HttpResponse::precondition_failed_with_message(
-            "Resource has been modified",
-        )),
-        _ => None,
-    }
+    request.check_preconditions(etag, last_modified, true)
 }
 
 /// Generate a cache-friendly response with ETag and Last-Modified headers.
@@ -836,7 +894,7 @@ mod tests {
         assert!(headers.if_none_match.is_some());
 
         let etag = ETag::strong("abc123");
-        assert!(headers.is_not_modified(Some(&etag), None));
+        assert!(headers.is_not_modified(Some(&etag), None, true));
     }
 
     #[test]
@@ -852,8 +910,9 @@ mod tests {
         let matching = ETag::strong("abc123");
         let non_matching = ETag::strong("xyz789");
 
-        assert!(!headers.precondition_failed(Some(&matching), None));
-        assert!(headers.precondition_failed(Some(&non_matching), None));
+        assert!(!headers.precondition_failed(Some(&matching), None, true));
+        assert!(headers.precondition_failed(Some(&non_matching), None, true));
+        assert!(headers.precondition_failed(Some(&matching), None, false));
     }
 
     #[test]
@@ -974,6 +1033,149 @@ mod tests {
         let response = check_conditionals(&request, Some(&etag), None);
         assert!(response.is_none());
     }
+
+    #[test]
+    fn test_precondition_evaluation_order() {
+        // Resource state: ETag "v2", last modified 10.5s after the date below
+        let date = "Sun, 06 Nov 1994 08:49:37 GMT";
+        let earlier = "Sun, 06 Nov 1994 08:49:30 GMT";
+        let modified = httpdate::parse_http_date(date).unwrap() + Duration::from_millis(500);
+        let etag = ETag::strong("v2");
+
+        type Case<'a> = (&'a str, &'a [(&'a str, &'a str)], Option<u16>);
+        #[rustfmt::skip]
+        let cases: &[Case] = &[
+            ("GET", &[], None),
+            // 1. If-Match, strong comparison
+            ("PUT", &[("If-Match", "\"v2\"")], None),
+            ("PUT", &[("If-Match", "\"v1\", \"v2\"")], None),
+            ("PUT", &[("If-Match", "*")], None),
+            ("PUT", &[("If-Match", "\"v1\"")], Some(412)),
+            ("PUT", &[("If-Match", "W/\"v2\"")], Some(412)),
+            ("GET", &[("If-Match", "\"v1\"")], Some(412)),
+            // 2. If-Unmodified-Since, only without If-Match
+            ("PUT", &[("If-Unmodified-Since", date)], None),
+            ("PUT", &[("If-Unmodified-Since", earlier)], Some(412)),
+            ("PUT", &[("If-Unmodified-Since", "not a date")], None),
+            ("PUT", &[("If-Match", "\"v2\""), ("If-Unmodified-Since", earlier)], None),
+            ("PUT", &[("If-Match", "\"v1\""), ("If-Unmodified-Since", date)], Some(412)),
+            // 3. If-None-Match, weak comparison: 304 when safe, 412 otherwise
+            ("GET", &[("If-None-Match", "\"v2\"")], Some(304)),
+            ("HEAD", &[("If-None-Match", "W/\"v2\"")], Some(304)),
+            ("GET", &[("If-None-Match", "\"v1\"")], None),
+            ("PUT", &[("If-None-Match", "\"v2\"")], Some(412)),
+            ("DELETE", &[("If-None-Match", "W/\"v2\"")], Some(412)),
+            ("PUT", &[("If-None-Match", "*")], Some(412)),
+            ("PUT", &[("If-None-Match", "\"v1\"")], None),
+            // Steps 1 and 2 come before step 3
+            ("GET", &[("If-Match", "\"v1\""), ("If-None-Match", "\"v2\"")], Some(412)),
+            ("GET", &[("If-Unmodified-Since", earlier), ("If-None-Match", "\"v2\"")], Some(412)),
+            ("GET", &[("If-Match", "\"v2\""), ("If-None-Match", "\"v2\"")], Some(304)),
+            // 4. If-Modified-Since, only for GET/HEAD without If-None-Match
+            ("GET", &[("If-Modified-Since", date)], Some(304)),
+            ("HEAD", &[("If-Modified-Since", date)], Some(304)),
+            ("GET", &[("If-Modified-Since", earlier)], None),
+            ("GET", &[("If-Modified-Since", "garbage")], None),
+            ("PUT", &[("If-Modified-Since", date)], None),
+            ("GET", &[("If-None-Match", "\"v1\""), ("If-Modified-Since", date)], None),
+            ("GET", &[("If-None-Match", "\"v2\""), ("If-Modified-Since", earlier)], Some(304)),
+        ];
+
+        for (method, headers, expected) in cases {
+            let mut request = HttpRequest::new(method.to_string(), "/resource".to_string());
+            for (name, value) in *headers {
+                request.headers.insert(name.to_string(), value.to_string());
+            }
+            assert_eq!(
+                request.evaluate_conditionals(Some(&etag), Some(modified)),
+                *expected,
+                "{} {:?}",
+                method,
+                headers
+            );
+        }
+    }
+
+    #[test]
+    fn test_preconditions_without_validators() {
+        let mut request = HttpRequest::new("PUT".to_string(), "/resource".to_string());
+        request
+            .headers
+            .insert("If-Match".to_string(), "\"v1\"".to_string());
+        assert_eq!(request.evaluate_conditionals(None, None), Some(412));
+
+        // Date conditions are ignored without a modification time
+        let mut request = HttpRequest::new("GET".to_string(), "/resource".to_string());
+        request.headers.insert(
+            "if-modified-since".to_string(),
+            "Sun, 06 Nov 1994 08:49:37 GMT".to_string(),
+        );
+        assert_eq!(request.evaluate_conditionals(None, None), None);
+    }
+
+    #[test]
+    fn test_preconditions_on_missing_resource() {
+        let cases: &[(&str, &str, &str, bool, Option<u16>)] = &[
+            // Create only if absent
+            ("PUT", "If-None-Match", "*", false, None),
+            ("PUT", "If-None-Match", "*", true, Some(412)),
+            ("GET", "If-None-Match", "*", false, None),
+            ("GET", "If-None-Match", "*", true, Some(304)),
+            // Update only if present
+            ("PUT", "If-Match", "*", false, Some(412)),
+            ("PUT", "If-Match", "*", true, None),
+            ("PUT", "If-Match", "\"v1\"", false, Some(412)),
+        ];
+
+        for (method, name, value, exists, expected) in cases {
+            let mut request = HttpRequest::new(method.to_string(), "/resource".to_string());
+            request.headers.insert(name.to_string(), value.to_string());
+            let etag = exists.then(|| ETag::strong("v1"));
+            assert_eq!(
+                request
+                    .conditional_headers()
+                    .evaluate(method, etag.as_ref(), None, *exists),
+                *expected,
+                "{} {}: {} exists={}",
+                method,
+                name,
+                value,
+                exists
+            );
+            assert_eq!(
+                request
+                    .check_preconditions(etag.as_ref(), None, *exists)
+                    .map(|response| response.status),
+                *expected
+            );
+        }
+    }
+
+    #[test]
+    fn test_check_preconditions_response() {
+        let etag = ETag::strong("v2");
+        let modified = httpdate::parse_http_date("Sun, 06 Nov 1994 08:49:37 GMT").unwrap();
+
+        let mut request = HttpRequest::new("GET".to_string(), "/resource".to_string());
+        request
+            .headers
+            .insert("If-None-Match".to_string(), "\"v2\"".to_string());
+        let response = request
+            .check_preconditions(Some(&etag), Some(modified), true)
+            .unwrap();
+        assert_eq!(response.status, 304);
+        assert_eq!(response.headers.get("ETag"), Some(&"\"v2\"".to_string()));
+        assert_eq!(
+            response.headers.get("Last-Modified"),
+            Some(&"Sun, 06 Nov 1994 08:49:37 GMT".to_string())
+        );
+
+        request.method = "PATCH".to_string();
+        let response = request
+            .check_preconditions(Some(&etag), Some(modified), true)
+            .unwrap();
+        assert_eq!(response.status, 412);
+    }
 }
 
 
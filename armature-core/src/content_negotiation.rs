@@ -120,6 +120,9 @@ impl MediaType {
 
         let type_ = type_parts.next()?.trim().to_lowercase();
         let subtype = type_parts.next()?.trim().to_lowercase();
+        if type_.is_empty() || subtype.is_empty() {
+            return None;
+        }
 
         let mut params = HashMap::new();
         for param in parts {
@@ -191,12 +194,18 @@ impl fmt::Display for MediaType {
 // ============================================================================
 
 /// Represents a parsed `Accept` header with quality values.
-#[derive(Debug, Clone, Default)]
+#[derive(Debug, Clone)]
 pub struct Accept {
     /// Media types with their quality values, sorted by preference.
     pub media_types: Vec<(MediaType, f32)>,
 }
 
+impl Default for Accept {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
 impl Accept {
     /// Create an empty Accept header (accepts anything).
     pub fn new() -> Self {
@@ -207,6 +216,9 @@ impl Accept {
 
     /// Parse an Accept header string.
     ///
+    /// Ranges are ordered by quality, then by specificity. Malformed ranges
+    /// are ignored, and a lone `*` is read as `*/*`.
+    ///
     /// # Example
     ///
     /// ```
@@ -224,14 +236,17 @@ impl Accept {
                     return None;
                 }
 
-                // Extract quality value
-                let (media_part, quality) = Self::extract_quality(part);
-
-                MediaType::parse(media_part).map(|mt| (mt, quality))
+                let media_type = if part.split(';').next().map(str::trim) == Some("*") {
+                    MediaType::parse(&format!("*/{}", part))?
+                } else {
+                    MediaType::parse(part)?
+                };
+                Some((media_type, Self::extract_quality(part)))
             })
             .collect();
 
-        // Sort by quality (highest first), then by specificity
+        // Sort by quality (highest first), then by specificity. The sort is
+        // stable, so equal ranges keep the client's order.
         media_types.sort_by(|a, b| {
             // First compare by quality
             match b.1.partial_cmp(&a.1) {
@@ -248,25 +263,15 @@ impl Accept {
         Self { media_types }
     }
 
-    /// Extract quality value from a media type string.
-    fn extract_quality(s: &str) -> (&str, f32) {
-        // Find q= parameter
-        if let Some(q_pos) = s.to_lowercase().find(";q=") {
-            let media_part = &s[..q_pos];
-            let q_part = &s[q_pos + 3..];
-
-            // Parse quality value
-            let quality = q_part
-                .split(';')
-                .next()
-                .and_then(|q| q.trim().parse::<f32>().ok())
-                .unwrap_or(1.0)
-                .clamp(0.0, 1.0);
-
-            (media_part, quality)
-        } else {
-            (s, 1.0)
-        }
+    /// Extract the `q` parameter from a media range, defaulting to 1.
+    fn extract_quality(s: &str) -> f32 {
+        s.split(';')
+            .skip(1)
+            .filter_map(|param| param.split_once('='))
+            .find(|(key, _)| key.trim().eq_ignore_ascii_case("q"))
+            .and_then(|(_, q)| q.trim().parse::<f32>().ok())
+            .unwrap_or(1.0)
+            .clamp(0.0, 1.0)
     }
 
     /// Calculate specificity of a media type.
@@ -278,22 +283,47 @@ impl Accept {
         if mt.subtype != "*" {
             score += 1;
         }
+        if !mt.params.is_empty() {
+            score += 4;
+        }
         score
     }
 
+    /// Quality and specificity of the most specific range matching
+    /// `media_type`.
+    ///
+    /// A range with parameters only matches a media type carrying the same
+    /// parameters.
+    fn best_match(&self, media_type: &MediaType) -> Option<(f32, u8)> {
+        let mut best: Option<(f32, u8)> = None;
+        for (range, quality) in &self.media_types {
+            let params_match = range
+                .params
+                .iter()
+                .all(|(key, value)| media_type.params.get(key) == Some(value));
+            if !range.matches(media_type) || !params_match {
+                continue;
+            }
+            let specificity = Self::specificity(range);
+            if best.is_none_or(|(_, best_specificity)| specificity > best_specificity) {
+                best = Some((*quality, specificity));
+            }
+        }
+        best
+    }
+
     /// Check if a media type is acceptable.
     pub fn accepts(&self, media_type: &MediaType) -> bool {
         self.quality_for(media_type) > 0.0
     }
 
     /// Get the quality value for a specific media type.
+    ///
+    /// The most specific matching range decides, so `text/*;q=0.5,
+    /// text/html;q=0` rejects `text/html` but accepts `text/plain`.
     pub fn quality_for(&self, media_type: &MediaType) -> f32 {
-        for (mt, quality) in &self.media_types {
-            if mt.matches(media_type) {
-                return *quality;
-            }
-        }
-        0.0
+        self.best_match(media_type)
+            .map_or(0.0, |(quality, _)| quality)
     }
 
     /// Get the preferred media type from this Accept header.
@@ -315,29 +345,39 @@ impl Accept {
 /// Negotiate the best media type from available options.
 ///
 /// Returns the media type from `available` that best matches the client's
-/// preferences in `accept`.
+/// preferences in `accept`, or `None` if none is acceptable. Offers with the
+/// same quality are ranked by how specifically the client named them, so
+/// `application/json, */*` picks JSON; a remaining tie goes to the offer
+/// listed first.
 pub fn negotiate_media_type<'a>(
     accept: &Accept,
     available: &'a [MediaType],
 ) -> Option<&'a MediaType> {
-    let mut best: Option<(&'a MediaType, f32, u8)> = None;
+    best_offer(accept, available.iter().map(Some)).map(|index| &available[index])
+}
 
-    for available_mt in available {
-        let quality = accept.quality_for(available_mt);
-        if quality > 0.0 {
-            let specificity = Accept::specificity(available_mt);
-            match &best {
-                None => best = Some((available_mt, quality, specificity)),
-                Some((_, best_q, best_s)) => {
-                    if quality > *best_q || (quality == *best_q && specificity > *best_s) {
-                        best = Some((available_mt, quality, specificity));
-                    }
-                }
-            }
+/// Index of the best acceptable offer; `None` entries are never chosen.
+fn best_offer<'m>(
+    accept: &Accept,
+    offers: impl IntoIterator<Item = Option<&'m MediaType>>,
+) -> Option<usize> {
+    let mut best: Option<(usize, f32, u8)> = None;
+
+    for (index, offer) in offers.into_iter().enumerate() {
+        let Some((quality, specificity)) = offer.and_then(|offer| accept.best_match(offer)) else {
+            continue;
+        };
+        if quality <= 0.0 {
+            continue;
+        }
+        if best.is_none_or(|(_, best_q, best_s)| {
+            quality > best_q || (quality == best_q && specificity > best_s)
+        }) {
+            best = Some((index, quality, specificity));
         }
     }
 
-    best.map(|(mt, _, _)| mt)
+    best.map(|(index, _, _)| index)
 }
 
 // ============================================================================
@@ -399,19 +439,14 @@ impl LanguageTag {
             (Some(_), None) => false, // "en-US" doesn't match just "en"
         }
     }
-
-    /// Get the full tag string.
-    pub fn to_string(&self) -> String {
-        match &self.subtag {
-            Some(sub) => format!("{}-{}", self.primary, sub),
-            None => self.primary.clone(),
-        }
-    }
 }
 
 impl fmt::Display for LanguageTag {
     fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
-        write!(f, "{}", self.to_string())
+        match &self.subtag {
+            Some(sub) => write!(f, "{}-{}", self.primary, sub),
+            None => write!(f, "{}", self.primary),
+        }
     }
 }
 
@@ -734,37 +769,27 @@ impl AcceptCharset {
 impl HttpRequest {
     /// Get the Accept header parsed into media types.
     pub fn accept(&self) -> Accept {
-        self.headers
-            .get("Accept")
-            .or_else(|| self.headers.get("accept"))
-            .map(|h| Accept::parse(h))
-            .unwrap_or_else(Accept::new)
+        self.header("accept").map(Accept::parse).unwrap_or_default()
     }
 
     /// Get the Accept-Language header parsed into language tags.
     pub fn accept_language(&self) -> AcceptLanguage {
-        self.headers
-            .get("Accept-Language")
-            .or_else(|| self.headers.get("accept-language"))
-            .map(|h| AcceptLanguage::parse(h))
+        self.header("accept-language")
+            .map(AcceptLanguage::parse)
             .unwrap_or_default()
     }
 
     /// Get the Accept-Encoding header parsed into encodings.
     pub fn accept_encoding(&self) -> AcceptEncoding {
-        self.headers
-            .get("Accept-Encoding")
-            .or_else(|| self.headers.get("accept-encoding"))
-            .map(|h| AcceptEncoding::parse(h))
+        self.header("accept-encoding")
+            .map(AcceptEncoding::parse)
             .unwrap_or_default()
     }
 
     /// Get the Accept-Charset header parsed into charsets.
     pub fn accept_charset(&self) -> AcceptCharset {
-        self.headers
-            .get("Accept-Charset")
-            .or_else(|| self.headers.get("accept-charset"))
-            .map(|h| AcceptCharset::parse(h))
+        self.header("accept-charset")
+            .map(AcceptCharset::parse)
             .unwrap_or_default()
     }
 
@@ -788,6 +813,31 @@ impl HttpRequest {
         negotiate_media_type(&self.accept(), available)
     }
 
+    /// Pick the best of `offers` (MIME type strings) for the `Accept` header.
+    ///
+    /// Returns `None` when the client accepts none of them. Without an
+    /// `Accept` header the first offer is returned.
+    ///
+    /// ```
+    /// use armature_core::HttpRequest;
+    ///
+    /// let mut request = HttpRequest::new("GET".to_string(), "/".to_string());
+    /// request.headers.insert(
+    ///     "Accept".to_string(),
+    ///     "text/html;q=0.8, application/*".to_string(),
+    /// );
+    /// assert_eq!(
+    ///     request.preferred_type(&["text/html", "application/json"]),
+    ///     Some("application/json")
+    /// );
+    /// assert_eq!(request.preferred_type(&["image/png"]), None);
+    /// ```
+    pub fn preferred_type<'a>(&self, offers: &[&'a str]) -> Option<&'a str> {
+        let parsed: Vec<Option<MediaType>> =
+            offers.iter().map(|offer| MediaType::parse(offer)).collect();
+        best_offer(&self.accept(), parsed.iter().map(Option::as_ref)).map(|index| offers[index])
+    }
+
     /// Negotiate the best language from available options.
     pub fn negotiate_language<'a>(&self, available: &'a [LanguageTag]) -> Option<&'a LanguageTag> {
         negotiate_language(&self.accept_language(), available)
@@ -803,195 +853,153 @@ impl HttpRequest {
 // Content Negotiation Response Helper
 // ============================================================================
 
+/// Renders the response for one negotiated media type.
+type Renderer<'a> = Box<dyn FnOnce() -> Result<HttpResponse, Error> + 'a>;
+
 /// Helper for building responses with content negotiation.
 ///
+/// Each offer is a media type with a function producing the response. Only
+/// the chosen function runs. The response gets `Vary: Accept`, and a
+/// `Content-Type` for the chosen type unless the renderer set one. When the
+/// client accepts none of the offers, [`negotiate`](Self::negotiate) returns
+/// [`Error::NotAcceptable`] (406).
+///
 /// # Example
 ///
-/// ```ignore
+/// ```
 /// use armature_core::content_negotiation::{ContentNegotiator, MediaType};
+/// use armature_core::{HttpRequest, HttpResponse};
+///
+/// let mut request = HttpRequest::new("GET".to_string(), "/".to_string());
+/// request.headers.insert("Accept".to_string(), "text/csv".to_string());
 ///
-/// let negotiator = ContentNegotiator::new()
+/// let response = ContentNegotiator::new()
 ///     .json(|| serde_json::json!({"message": "Hello"}))
 ///     .html(|| "<h1>Hello</h1>".to_string())
-///     .plain_text(|| "Hello".to_string());
+///     .offer(MediaType::new("text", "csv"), || {
+///         Ok(HttpResponse::ok().with_body(b"message\nHello\n".to_vec()))
+///     })
+///     .negotiate(&request)
+///     .unwrap();
 ///
-/// let response = negotiator.negotiate(&request)?;
+/// assert_eq!(response.headers.get("Content-Type"), Some(&"text/csv".to_string()));
+/// assert_eq!(response.headers.get("Vary"), Some(&"Accept".to_string()));
 /// ```
-pub struct ContentNegotiator<J, H, T, X>
-where
-    J: FnOnce() -> serde_json::Value,
-    H: FnOnce() -> String,
-    T: FnOnce() -> String,
-    X: FnOnce() -> String,
-{
-    json_fn: Option<J>,
-    html_fn: Option<H>,
-    text_fn: Option<T>,
-    xml_fn: Option<X>,
-    default_media_type: MediaType,
+pub struct ContentNegotiator<'a> {
+    offers: Vec<(MediaType, Renderer<'a>)>,
+    default_media_type: Option<MediaType>,
 }
 
-impl ContentNegotiator<fn() -> serde_json::Value, fn() -> String, fn() -> String, fn() -> String> {
-    /// Create a new content negotiator with JSON as the default.
+impl<'a> ContentNegotiator<'a> {
+    /// Create a negotiator with no offers.
     pub fn new() -> Self {
         Self {
-            json_fn: None,
-            html_fn: None,
-            text_fn: None,
-            xml_fn: None,
-            default_media_type: MediaType::json(),
+            offers: Vec::new(),
+            default_media_type: None,
         }
     }
-}
 
-impl<J, H, T, X> ContentNegotiator<J, H, T, X>
-where
-    J: FnOnce() -> serde_json::Value,
-    H: FnOnce() -> String,
-    T: FnOnce() -> String,
-    X: FnOnce() -> String,
-{
-    /// Set the JSON response generator.
-    pub fn json<NJ: FnOnce() -> serde_json::Value>(
-        self,
-        f: NJ,
-    ) -> ContentNegotiator<NJ, H, T, X> {
-        ContentNegotiator {
-            json_fn: Some(f),
-            html_fn: self.html_fn,
-            text_fn: self.text_fn,
-            xml_fn: self.xml_fn,
-            default_media_type: self.default_media_type,
-        }
+    /// Offer `media_type`, rendered by `render`.
+    ///
+    /// Offers are ranked in the order they are added when the client
+    /// rates them equally.
+    pub fn offer<F>(mut self, media_type: MediaType, render: F) -> Self
+    where
+        F: FnOnce() -> Result<HttpResponse, Error> + 'a,
+    {
+        self.offers.push((media_type, Box::new(render)));
+        self
     }
 
-    /// Set the HTML response generator.
-    pub fn html<NH: FnOnce() -> String>(self, f: NH) -> ContentNegotiator<J, NH, T, X> {
-        ContentNegotiator {
-            json_fn: self.json_fn,
-            html_fn: Some(f),
-            text_fn: self.text_fn,
-            xml_fn: self.xml_fn,
-            default_media_type: self.default_media_type,
-        }
+    /// Offer `application/json`, serializing the returned value.
+    pub fn json<F: FnOnce() -> serde_json::Value + 'a>(self, f: F) -> Self {
+        self.offer(MediaType::json(), move || HttpResponse::json(&f()))
     }
 
-    /// Set the plain text response generator.
-    pub fn plain_text<NT: FnOnce() -> String>(self, f: NT) -> ContentNegotiator<J, H, NT, X> {
-        ContentNegotiator {
-            json_fn: self.json_fn,
-            html_fn: self.html_fn,
-            text_fn: Some(f),
-            xml_fn: self.xml_fn,
-            default_media_type: self.default_media_type,
-        }
+    /// Offer `text/html`.
+    pub fn html<F: FnOnce() -> String + 'a>(self, f: F) -> Self {
+        self.offer(MediaType::html(), move || Ok(HttpResponse::html(f())))
     }
 
-    /// Set the XML response generator.
-    pub fn xml<NX: FnOnce() -> String>(self, f: NX) -> ContentNegotiator<J, H, T, NX> {
-        ContentNegotiator {
-            json_fn: self.json_fn,
-            html_fn: self.html_fn,
-            text_fn: self.text_fn,
-            xml_fn: Some(f),
-            default_media_type: self.default_media_type,
-        }
+    /// Offer `text/plain`.
+    pub fn plain_text<F: FnOnce() -> String + 'a>(self, f: F) -> Self {
+        self.offer(MediaType::plain_text(), move || Ok(HttpResponse::text(f())))
     }
 
-    /// Set the default media type when no Accept header is present.
+    /// Offer `application/xml`.
+    pub fn xml<F: FnOnce() -> String + 'a>(self, f: F) -> Self {
+        self.offer(MediaType::xml(), move || {
+            Ok(HttpResponse::ok()
+                .with_header(
+                    "Content-Type".to_string(),
+                    "application/xml; charset=utf-8".to_string(),
+                )
+                .with_body(f().into_bytes()))
+        })
+    }
+
+    /// Set the media type served when the request has no Accept header.
+    ///
+    /// Defaults to the first offer.
     pub fn default_to(mut self, media_type: MediaType) -> Self {
-        self.default_media_type = media_type;
+        self.default_media_type = Some(media_type);
         self
     }
 
     /// Negotiate and build the response based on the request's Accept header.
-    pub fn negotiate(self, request: &HttpRequest) -> Result<HttpResponse, Error> {
-        let accept = request.accept();
-
-        // Build list of available media types
-        let mut available = Vec::new();
-        if self.json_fn.is_some() {
-            available.push(MediaType::json());
-        }
-        if self.html_fn.is_some() {
-            available.push(MediaType::html());
-        }
-        if self.text_fn.is_some() {
-            available.push(MediaType::plain_text());
-        }
-        if self.xml_fn.is_some() {
-            available.push(MediaType::xml());
+    pub fn negotiate(mut self, request: &HttpRequest) -> Result<HttpResponse, Error> {
+        if self.offers.is_empty() {
+            return Err(Error::Internal(
+                "No response formats configured".to_string(),
+            ));
         }
 
-        // If no formats available, return error
-        if available.is_empty() {
-            return Err(Error::Internal("No response formats configured".to_string()));
-        }
-
-        // Negotiate best media type
-        let best = negotiate_media_type(&accept, &available)
-            .cloned()
-            .unwrap_or_else(|| self.default_media_type.clone());
-
-        // Build response based on negotiated type
-        let mut response = HttpResponse::ok();
-
-        if best.matches(&MediaType::json()) {
-            if let Some(f) = self.json_fn {
-                let value = f();
-                let body = serde_json::to_vec(&value)
-                    .map_err(|e| Error::Serialization(e.to_string()))?;
-                response.body = body;
-                response
-                    .headers
-                    .insert("Content-Type".to_string(), "application/json".to_string());
-            }
-        } else if best.matches(&MediaType::html()) {
-            if let Some(f) = self.html_fn {
-                let html = f();
-                response.body = html.into_bytes();
-                response.headers.insert(
-                    "Content-Type".to_string(),
-                    "text/html; charset=utf-8".to_string(),
-                );
-            }
-        } else if best.matches(&MediaType::plain_text()) {
-            if let Some(f) = self.text_fn {
-                let text = f();
-                response.body = text.into_bytes();
-                response.headers.insert(
-                    "Content-Type".to_string(),
-                    "text/plain; charset=utf-8".to_string(),
-                );
-            }
-        } else if best.matches(&MediaType::xml()) {
-            if let Some(f) = self.xml_fn {
-                let xml = f();
-                response.body = xml.into_bytes();
-                response.headers.insert(
-                    "Content-Type".to_string(),
-                    "application/xml; charset=utf-8".to_string(),
-                );
-            }
+        let index = if request.header("accept").is_none() {
+            self.default_media_type
+                .as_ref()
+                .and_then(|default| {
+                    self.offers
+                        .iter()
+                        .position(|(offer, _)| offer.mime_type() == default.mime_type())
+                })
+                .unwrap_or(0)
         } else {
-            return Err(Error::NotAcceptable(format!(
-                "Cannot produce response in requested format: {}",
-                best
-            )));
-        }
-
-        // Add Vary header
-        response
+            best_offer(
+                &request.accept(),
+                self.offers.iter().map(|(offer, _)| Some(offer)),
+            )
+            .ok_or_else(|| {
+                let offered: Vec<String> = self
+                    .offers
+                    .iter()
+                    .map(|(offer, _)| offer.mime_type())
+                    .collect();
+                Error::NotAcceptable(format!(
+                    "Cannot produce a response in a requested format; available: {}",
+                    offered.join(", ")
+                ))
+            })?
+        };
+
+        let (media_type, render) = self.offers.swap_remove(index);
+        let mut response = render()?;
+
+        let has_content_type = response
             .headers
-            .insert("Vary".to_string(), "Accept".to_string());
+            .iter()
+            .any(|(name, _)| name.eq_ignore_ascii_case("content-type"));
+        if !has_content_type {
+            response
+                .headers
+                .insert("Content-Type".to_string(), media_type.to_header_value());
+        }
+        response.add_vary("Accept");
 
         Ok(response)
     }
 }
 
-impl Default
-    for ContentNegotiator<fn() -> serde_json::Value, fn() -> String, fn() -> String, fn() -> String>
-{
+impl Default for ContentNegotiator<'_> {
     fn default() -> Self {
         Self::new()
     }
@@ -1034,21 +1042,19 @@ pub fn respond_with<T: Serialize>(request: &HttpRequest, data: &T) -> Result<Htt
     } else {
         // Default to JSON
         response.body =
-            serde_json::to_vec(data).map_err(|e| Error::Serialization(e.to_string()))?;
+            crate::json::encode(data).map_err(|e| Error::Serialization(e.to_string()))?;
         response
             .headers
             .insert("Content-Type".to_string(), "application/json".to_string());
     }
 
-    response
-        .headers
-        .insert("Vary".to_string(), "Accept".to_string());
+    response.add_vary("Accept");
 
     Ok(response)
 }
 
 /// Simple HTML escaping for content.
-fn html_escape(s: &str) -> String {
+pub(crate) fn html_escape(s: &str) -> String {
     s.replace('&', "&amp;")
         .replace('<', "&lt;")
         .replace('>', "&gt;")
@@ -1136,6 +1142,180 @@ mod tests {
         assert_eq!(best, Some(&MediaType::json()));
     }
 
+    #[test]
+    fn test_accept_parse_spacing_and_wildcards() {
+        let accept = Accept::parse("text/html ; q=0.2, application/json;Q=0.9, *, garbage");
+        assert_eq!(accept.media_types.len(), 3);
+        assert_eq!(accept.media_types[0].0, MediaType::any());
+        assert_eq!(accept.quality_for(&MediaType::json()), 0.9);
+        assert_eq!(accept.quality_for(&MediaType::html()), 0.2);
+        assert_eq!(accept.quality_for(&MediaType::xml()), 1.0);
+    }
+
+    #[test]
+    fn test_accept_most_specific_range_wins() {
+        let accept = Accept::parse("text/*;q=0.5, text/html;q=0, */*;q=0.1");
+        assert_eq!(accept.quality_for(&MediaType::html()), 0.0);
+        assert_eq!(accept.quality_for(&MediaType::plain_text()), 0.5);
+        assert_eq!(accept.quality_for(&MediaType::json()), 0.1);
+        assert!(!accept.accepts(&MediaType::html()));
+    }
+
+    #[test]
+    fn test_accept_range_with_params() {
+        let accept = Accept::parse("text/html;level=1, text/html;q=0.4");
+        let level1 = MediaType::html().with_param("level", "1");
+        assert_eq!(accept.quality_for(&level1), 1.0);
+        assert_eq!(accept.quality_for(&MediaType::html()), 0.4);
+    }
+
+    #[test]
+    fn test_negotiate_wildcards() {
+        let offers = vec![MediaType::html(), MediaType::json()];
+
+        let accept = Accept::parse("*/*");
+        assert_eq!(negotiate_media_type(&accept, &offers), Some(&offers[0]));
+
+        let accept = Accept::parse("application/*");
+        assert_eq!(negotiate_media_type(&accept, &offers), Some(&offers[1]));
+
+        // Named explicitly beats matching through */* at the same quality
+        let accept = Accept::parse("*/*, application/json");
+        assert_eq!(negotiate_media_type(&accept, &offers), Some(&offers[1]));
+
+        let accept = Accept::parse("image/*");
+        assert_eq!(negotiate_media_type(&accept, &offers), None);
+    }
+
+    #[test]
+    fn test_negotiate_equal_quality_first_offer_wins() {
+        let accept = Accept::parse("text/html, application/json");
+        let offers = vec![MediaType::json(), MediaType::html()];
+        assert_eq!(negotiate_media_type(&accept, &offers), Some(&offers[0]));
+
+        let offers = vec![MediaType::html(), MediaType::json()];
+        assert_eq!(negotiate_media_type(&accept, &offers), Some(&offers[0]));
+    }
+
+    #[test]
+    fn test_http_request_preferred_type() {
+        let mut request = HttpRequest::new("GET".to_string(), "/".to_string());
+        let offers = ["application/json", "text/html"];
+        assert_eq!(request.preferred_type(&offers), Some("application/json"));
+
+        request.headers.insert(
+            "accept".to_string(),
+            "text/html, application/json;q=0.5".to_string(),
+        );
+        assert_eq!(request.preferred_type(&offers), Some("text/html"));
+        assert_eq!(
+            request.preferred_type(&["not a type", "text/html"]),
+            Some("text/html")
+        );
+        assert_eq!(request.preferred_type(&["image/png"]), None);
+        assert_eq!(request.preferred_type(&[]), None);
+    }
+
+    fn request_accepting(accept: &str) -> HttpRequest {
+        let mut request = HttpRequest::new("GET".to_string(), "/".to_string());
+        request
+            .headers
+            .insert("Accept".to_string(), accept.to_string());
+        request
+    }
+
+    fn negotiator<'a>() -> ContentNegotiator<'a> {
+        ContentNegotiator::new()
+            .json(|| serde_json::json!({"name": "widget"}))
+            .xml(|| "<name>widget</name>".to_string())
+            .html(|| "<p>widget</p>".to_string())
+    }
+
+    #[test]
+    fn test_content_negotiator_selects_renderer() {
+        let response = negotiator()
+            .negotiate(&request_accepting(
+                "application/xml, application/json;q=0.9",
+            ))
+            .unwrap();
+        assert_eq!(response.body, b"<name>widget</name>");
+        assert_eq!(
+            response.headers.get("Content-Type"),
+            Some(&"application/xml; charset=utf-8".to_string())
+        );
+        assert_eq!(response.headers.get("Vary"), Some(&"Accept".to_string()));
+
+        let response = negotiator()
+            .negotiate(&request_accepting("text/*"))
+            .unwrap();
+        assert_eq!(response.body, b"<p>widget</p>");
+    }
+
+    #[test]
+    fn test_content_negotiator_not_acceptable() {
+        let err = negotiator()
+            .negotiate(&request_accepting("image/png"))
+            .unwrap_err();
+        assert_eq!(err.status_code(), 406);
+        assert!(err.to_string().contains("application/json"));
+
+        let err = negotiator()
+            .negotiate(&request_accepting("application/json;q=0"))
+            .unwrap_err();
+        assert_eq!(err.status_code(), 406);
+    }
+
+    #[test]
+    fn test_content_negotiator_without_accept_header() {
+        let request = HttpRequest::new("GET".to_string(), "/".to_string());
+        let response = negotiator().negotiate(&request).unwrap();
+        assert_eq!(
+            response.headers.get("Content-Type"),
+            Some(&"application/json".to_string())
+        );
+
+        let response = negotiator()
+            .default_to(MediaType::html())
+            .negotiate(&request)
+            .unwrap();
+        assert_eq!(response.body, b"<p>widget</p>");
+    }
+
+    #[test]
+    fn test_content_negotiator_custom_offer() {
+        let rendered = std::cell::Cell::new(0);
+        let response = ContentNegotiator::new()
+            .json(|| {
+                rendered.set(rendered.get() + 1);
+                serde_json::json!([])
+            })
+            .offer(MediaType::new("text", "csv"), || {
+                Ok(HttpResponse::ok()
+                    .with_header("Vary".to_string(), "Accept-Encoding".to_string())
+                    .with_body(b"a,b\n".to_vec()))
+            })
+            .negotiate(&request_accepting("text/csv, application/json;q=0.1"))
+            .unwrap();
+
+        assert_eq!(rendered.get(), 0);
+        assert_eq!(
+            response.headers.get("Content-Type"),
+            Some(&"text/csv".to_string())
+        );
+        assert_eq!(
+            response.headers.get("Vary"),
+            Some(&"Accept-Encoding, Accept".to_string())
+        );
+    }
+
+    #[test]
+    fn test_content_negotiator_requires_offers() {
+        let err = ContentNegotiator::new()
+            .negotiate(&request_accepting("*/*"))
+            .unwrap_err();
+        assert_eq!(err.status_code(), 500);
+    }
+
     #[test]
     fn test_language_tag_parse() {
         let tag = LanguageTag::parse("en-US").unwrap();
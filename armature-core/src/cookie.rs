@@ -0,0 +1,698 @@
+//! HTTP cookies, with optional HMAC signing.
+//!
+//! [`Cookie`] builds a `Set-Cookie` value with secure defaults (`Path=/`,
+//! `Secure`, `HttpOnly`, `SameSite=Lax`), attached with
+//! [`HttpResponse::with_cookie`]. [`HttpRequest::cookie`] reads a cookie back.
+//!
+//! Signed cookies carry an HMAC-SHA256 tag over the name, the value and the
+//! expiry, so a client can read but not alter them. A signed cookie with a
+//! `Max-Age` or `Expires` stops verifying once it expires, even if the
+//! browser keeps sending it.
+//!
+//! ## Quick Start
+//!
+//! ```rust
+//! use armature_core::cookie::{Cookie, CookieKey, SameSite};
+//! use armature_core::{HttpRequest, HttpResponse};
+//! use std::time::Duration;
+//!
+//! let key = CookieKey::new(b"a secret of at least thirty-two bytes!");
+//!
+//! let response = HttpResponse::ok()
+//!     .with_cookie(Cookie::new("theme", "dark")?.http_only(false))
+//!     .with_cookie(
+//!         Cookie::new("session", "user-42")?
+//!             .same_site(SameSite::Strict)
+//!             .max_age(Duration::from_secs(3600))
+//!             .sign(&key),
+//!     );
+//! assert_eq!(response.cookies().len(), 2);
+//!
+//! // On the next request
+//! let mut req = HttpRequest::new("GET".to_string(), "/".to_string());
+//! let session = response.cookies()[1].value().to_string();
+//! req.headers
+//!     .insert("Cookie".to_string(), format!("theme=dark; session={}", session));
+//!
+//! assert_eq!(req.cookie("theme").as_deref(), Some("dark"));
+//! assert_eq!(req.signed_cookie("session", &key).unwrap(), "user-42");
+//! # Ok::<(), armature_core::cookie::CookieError>(())
+//! ```
+
+use crate::{HttpRequest, HttpResponse};
+use base64::Engine;
+use base64::engine::general_purpose::URL_SAFE_NO_PAD;
+use ring::hmac;
+use std::fmt;
+use std::time::{Duration, SystemTime, UNIX_EPOCH};
+
+/// The `SameSite` attribute of a cookie.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum SameSite {
+    /// Only sent with same-site requests
+    Strict,
+    /// Also sent with top-level cross-site navigations (browser default)
+    Lax,
+    /// Sent with all requests; always serialized with `Secure`
+    None,
+}
+
+impl SameSite {
+    /// The attribute value as it appears in `Set-Cookie`.
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            SameSite::Strict => "Strict",
+            SameSite::Lax => "Lax",
+            SameSite::None => "None",
+        }
+    }
+}
+
+impl fmt::Display for SameSite {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str(self.as_str())
+    }
+}
+
+/// A cookie to send in a `Set-Cookie` header.
+///
+/// The name must be a valid HTTP token, and the `Path` and `Domain`
+/// attributes are checked when set, so a cookie always serializes to a valid
+/// header. Values are percent-encoded where needed and decoded again by
+/// [`HttpRequest::cookie`].
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Cookie {
+    name: String,
+    value: String,
+    path: Option<String>,
+    domain: Option<String>,
+    max_age: Option<Duration>,
+    expires: Option<SystemTime>,
+    secure: bool,
+    http_only: bool,
+    same_site: Option<SameSite>,
+}
+
+impl Cookie {
+    /// Create a cookie with `Path=/`, `Secure`, `HttpOnly` and
+    /// `SameSite=Lax`.
+    ///
+    /// Fails with [`CookieError::InvalidName`] unless `name` is an HTTP token.
+    pub fn new(name: impl Into<String>, value: impl Into<String>) -> Result<Self, CookieError> {
+        let name = name.into();
+        if name.is_empty() || !name.bytes().all(is_token_char) {
+            return Err(CookieError::InvalidName(name));
+        }
+        Ok(Self {
+            name,
+            value: value.into(),
+            path: Some("/".to_string()),
+            domain: None,
+            max_age: None,
+            expires: None,
+            secure: true,
+            http_only: true,
+            same_site: Some(SameSite::Lax),
+        })
+    }
+
+    /// A cookie that tells the browser to delete `name` immediately.
+    ///
+    /// Path and domain must match the original cookie for the browser to
+    /// remove it.
+    pub fn removal(name: impl Into<String>) -> Result<Self, CookieError> {
+        Ok(Self::new(name, "")?
+            .max_age(Duration::ZERO)
+            .expires(UNIX_EPOCH))
+    }
+
+    /// Cookie name.
+    pub fn name(&self) -> &str {
+        &self.name
+    }
+
+    /// Cookie value, as sent to the client.
+    pub fn value(&self) -> &str {
+        &self.value
+    }
+
+    /// Set the `Path` attribute.
+    ///
+    /// Fails with [`CookieError::InvalidPath`] unless `path` starts with `/`
+    /// and contains only printable ASCII other than `;`.
+    pub fn path(mut self, path: impl Into<String>) -> Result<Self, CookieError> {
+        let path = path.into();
+        let valid = path.starts_with('/')
+            && path
+                .bytes()
+                .all(|b| (0x20..=0x7E).contains(&b) && b != b';');
+        if !valid {
+            return Err(CookieError::InvalidPath(path));
+        }
+        self.path = Some(path);
+        Ok(self)
+    }
+
+    /// Set the `Domain` attribute.
+    ///
+    /// Fails with [`CookieError::InvalidDomain`] unless `domain` is a host
+    /// name: dot-separated labels of ASCII letters, digits and `-`, with an
+    /// optional leading dot.
+    pub fn domain(mut self, domain: impl Into<String>) -> Result<Self, CookieError> {
+        let domain = domain.into();
+        let host = domain.strip_prefix('.').unwrap_or(&domain);
+        let valid = !host.is_empty()
+            && host.split('.').all(|label| {
+                !label.is_empty()
+                    && label
+                        .bytes()
+                        .all(|b| b.is_ascii_alphanumeric() || b == b'-')
+            });
+        if !valid {
+            return Err(CookieError::InvalidDomain(domain));
+        }
+        self.domain = Some(domain);
+        Ok(self)
+    }
+
+    /// Set the `Max-Age` attribute; a zero duration expires the cookie
+    /// immediately.
+    pub fn max_age(mut self, max_age: Duration) -> Self {
+        self.max_age = Some(max_age);
+        self
+    }
+
+    /// Set the `Expires` attribute.
+    pub fn expires(mut self, expires: SystemTime) -> Self {
+        self.expires = Some(expires);
+        self
+    }
+
+    /// Set or clear the `Secure` attribute.
+    pub fn secure(mut self, secure: bool) -> Self {
+        self.secure = secure;
+        self
+    }
+
+    /// Set or clear the `HttpOnly` attribute.
+    pub fn http_only(mut self, http_only: bool) -> Self {
+        self.http_only = http_only;
+        self
+    }
+
+    /// Set the `SameSite` attribute.
+    pub fn same_site(mut self, same_site: SameSite) -> Self {
+        self.same_site = Some(same_site);
+        self
+    }
+
+    /// Leave out the `SameSite` attribute.
+    pub fn no_same_site(mut self) -> Self {
+        self.same_site = None;
+        self
+    }
+
+    /// Replace the value with a signed one that [`HttpRequest::signed_cookie`]
+    /// can verify.
+    ///
+    /// Sign after setting `max_age` or `expires`: the expiry is part of the
+    /// signature.
+    pub fn sign(mut self, key: &CookieKey) -> Self {
+        let expires_at = self.expires_at().map(unix_secs);
+        self.value = key.sign(&self.name, &self.value, expires_at);
+        self
+    }
+
+    /// When the cookie expires, from `Max-Age` or else `Expires`.
+    fn expires_at(&self) -> Option<SystemTime> {
+        match self.max_age {
+            Some(max_age) => Some(SystemTime::now() + max_age),
+            None => self.expires,
+        }
+    }
+
+    /// Serialize as a `Set-Cookie` header value.
+    pub fn to_header_value(&self) -> String {
+        let mut header = format!("{}={}", self.name, encode_value(&self.value));
+        if let Some(path) = &self.path {
+            header.push_str("; Path=");
+            header.push_str(path);
+        }
+        if let Some(domain) = &self.domain {
+            header.push_str("; Domain=");
+            header.push_str(domain);
+        }
+        if let Some(max_age) = self.max_age {
+            header.push_str(&format!("; Max-Age={}", max_age.as_secs()));
+        }
+        if let Some(expires) = self.expires {
+            header.push_str("; Expires=");
+            header.push_str(&httpdate::fmt_http_date(expires));
+        }
+        // Browsers reject SameSite=None without Secure
+        if self.secure || self.same_site == Some(SameSite::None) {
+            header.push_str("; Secure");
+        }
+        if self.http_only {
+            header.push_str("; HttpOnly");
+        }
+        if let Some(same_site) = self.same_site {
+            header.push_str("; SameSite=");
+            header.push_str(same_site.as_str());
+        }
+        header
+    }
+}
+
+impl fmt::Display for Cookie {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str(&self.to_header_value())
+    }
+}
+
+/// HMAC-SHA256 key for signing cookies.
+///
+/// Use at least 32 random bytes, and keep the key stable across restarts
+/// and instances, or previously issued cookies stop verifying.
+#[derive(Clone)]
+pub struct CookieKey {
+    key: hmac::Key,
+}
+
+impl CookieKey {
+    /// Create a key from secret bytes.
+    pub fn new(secret: impl AsRef<[u8]>) -> Self {
+        Self {
+            key: hmac::Key::new(hmac::HMAC_SHA256, secret.as_ref()),
+        }
+    }
+
+    /// Signed value: `base64(expiry|value).base64(tag)`.
+    fn sign(&self, name: &str, value: &str, expires_at: Option<u64>) -> String {
+        let payload = match expires_at {
+            Some(expires_at) => format!("{}|{}", expires_at, value),
+            None => format!("|{}", value),
+        };
+        let tag = hmac::sign(&self.key, &Self::message(name, &payload));
+        format!(
+            "{}.{}",
+            URL_SAFE_NO_PAD.encode(&payload),
+            URL_SAFE_NO_PAD.encode(tag.as_ref())
+        )
+    }
+
+    fn verify(&self, name: &str, signed: &str) -> Result<String, CookieError> {
+        let invalid = || CookieError::InvalidSignature(name.to_string());
+
+        let (payload, tag) = signed.split_once('.').ok_or_else(invalid)?;
+        let payload = URL_SAFE_NO_PAD.decode(payload).map_err(|_| invalid())?;
+        let tag = URL_SAFE_NO_PAD.decode(tag).map_err(|_| invalid())?;
+        let payload = String::from_utf8(payload).map_err(|_| invalid())?;
+        hmac::verify(&self.key, &Self::message(name, &payload), &tag).map_err(|_| invalid())?;
+
+        let (expires_at, value) = payload.split_once('|').ok_or_else(invalid)?;
+        if !expires_at.is_empty() {
+            let expires_at: u64 = expires_at.parse().map_err(|_| invalid())?;
+            if unix_secs(SystemTime::now()) >= expires_at {
+                return Err(CookieError::Expired(name.to_string()));
+            }
+        }
+        Ok(value.to_string())
+    }
+
+    /// The name is signed too, so a value can't be moved to another cookie.
+    fn message(name: &str, payload: &str) -> Vec<u8> {
+        format!("{}={}", name, payload).into_bytes()
+    }
+}
+
+impl fmt::Debug for CookieKey {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str("CookieKey(<redacted>)")
+    }
+}
+
+/// Error building a cookie or reading a signed one.
+#[derive(Debug, Clone, PartialEq, Eq, thiserror::Error)]
+pub enum CookieError {
+    /// The name is not a valid HTTP token.
+    #[error("invalid cookie name '{0}'")]
+    InvalidName(String),
+    /// The `Path` attribute would not fit in a `Set-Cookie` header.
+    #[error("invalid cookie path '{0}'")]
+    InvalidPath(String),
+    /// The `Domain` attribute is not a host name.
+    #[error("invalid cookie domain '{0}'")]
+    InvalidDomain(String),
+    /// The request has no cookie with this name.
+    #[error("cookie '{0}' not found")]
+    NotFound(String),
+    /// The value was not signed with this key, or has been changed.
+    #[error("cookie '{0}' has an invalid signature")]
+    InvalidSignature(String),
+    /// The signature is valid but the cookie's expiry has passed.
+    #[error("cookie '{0}' has expired")]
+    Expired(String),
+}
+
+impl From<CookieError> for crate::Error {
+    fn from(err: CookieError) -> Self {
+        match err {
+            // Building an invalid cookie is a server bug, not a bad request
+            CookieError::InvalidName(_)
+            | CookieError::InvalidPath(_)
+            | CookieError::InvalidDomain(_) => crate::Error::Internal(err.to_string()),
+            _ => crate::Error::BadRequest(err.to_string()),
+        }
+    }
+}
+
+impl HttpRequest {
+    /// All cookies sent with the request, in order.
+    pub fn cookies(&self) -> Vec<(String, String)> {
+        self.headers
+            .iter()
+            .filter(|(name, _)| name.eq_ignore_ascii_case("cookie"))
+            .flat_map(|(_, value)| value.split(';'))
+            .filter_map(|pair| {
+                let (name, value) = pair.split_once('=')?;
+                let name = name.trim();
+                if name.is_empty() {
+                    return None;
+                }
+                Some((name.to_string(), decode_value(value.trim())))
+            })
+            .collect()
+    }
+
+    /// Value of the cookie `name`, if sent.
+    ///
+    /// The first cookie wins if the client sent the name more than once.
+    pub fn cookie(&self, name: &str) -> Option<String> {
+        self.cookies()
+            .into_iter()
+            .find(|(cookie, _)| cookie == name)
+            .map(|(_, value)| value)
+    }
+
+    /// Value of the signed cookie `name`, verified with `key`.
+    pub fn signed_cookie(&self, name: &str, key: &CookieKey) -> Result<String, CookieError> {
+        let signed = self
+            .cookie(name)
+            .ok_or_else(|| CookieError::NotFound(name.to_string()))?;
+        key.verify(name, &signed)
+    }
+}
+
+impl HttpResponse {
+    /// Add a `Set-Cookie` header for `cookie`.
+    pub fn with_cookie(mut self, cookie: Cookie) -> Self {
+        self.set_cookie(cookie);
+        self
+    }
+
+    /// Tell the browser to delete the cookie `name`.
+    ///
+    /// Fails like [`Cookie::new`] for an invalid name.
+    pub fn remove_cookie(self, name: impl Into<String>) -> Result<Self, CookieError> {
+        Ok(self.with_cookie(Cookie::removal(name)?))
+    }
+}
+
+fn unix_secs(time: SystemTime) -> u64 {
+    time.duration_since(UNIX_EPOCH)
+        .map(|d| d.as_secs())
+        .unwrap_or(0)
+}
+
+/// Whether `b` may appear in an HTTP token (RFC 9110 `tchar`)
+fn is_token_char(b: u8) -> bool {
+    b.is_ascii_alphanumeric() || b"!#$%&'*+-.^_`|~".contains(&b)
+}
+
+/// Whether `b` may appear unencoded in a cookie value (RFC 6265
+/// `cookie-octet`, minus `%` so encoding round-trips).
+fn is_cookie_octet(b: u8) -> bool {
+    matches!(b, 0x21 | 0x23..=0x24 | 0x26..=0x2B | 0x2D..=0x3A | 0x3C..=0x5B | 0x5D..=0x7E)
+}
+
+fn encode_value(value: &str) -> String {
+    let mut encoded = String::with_capacity(value.len());
+    for b in value.bytes() {
+        if is_cookie_octet(b) {
+            encoded.push(b as char);
+        } else {
+            encoded.push_str(&format!("%{:02X}", b));
+        }
+    }
+    encoded
+}
+
+fn decode_value(value: &str) -> String {
+    let value = value
+        .strip_prefix('"')
+        .and_then(|v| v.strip_suffix('"'))
+        .unwrap_or(value);
+    urlencoding::decode(value)
+        .map(|decoded| decoded.into_owned())
+        .unwrap_or_else(|_| value.to_string())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn key() -> CookieKey {
+        CookieKey::new(b"0123456789abcdef0123456789abcdef")
+    }
+
+    fn request_with_cookies(header: &str) -> HttpRequest {
+        let mut req = HttpRequest::new("GET".to_string(), "/".to_string());
+        req.headers.insert("Cookie".to_string(), header.to_string());
+        req
+    }
+
+    /// Value part of a Set-Cookie header, as a browser would send it back
+    fn sent_back(cookie: &Cookie) -> String {
+        let header = cookie.to_header_value();
+        header.split(';').next().unwrap().to_string()
+    }
+
+    #[test]
+    fn test_secure_defaults() {
+        assert_eq!(
+            Cookie::new("id", "7").unwrap().to_header_value(),
+            "id=7; Path=/; Secure; HttpOnly; SameSite=Lax"
+        );
+    }
+
+    #[test]
+    fn test_attributes() {
+        let cookie = Cookie::new("id", "7")
+            .unwrap()
+            .path("/app")
+            .unwrap()
+            .domain("example.com")
+            .unwrap()
+            .max_age(Duration::from_secs(3600))
+            .expires(UNIX_EPOCH + Duration::from_secs(784_111_777))
+            .secure(false)
+            .http_only(false);
+        assert_eq!(
+            cookie.to_header_value(),
+            "id=7; Path=/app; Domain=example.com; Max-Age=3600; \
+             Expires=Sun, 06 Nov 1994 08:49:37 GMT; SameSite=Lax"
+        );
+    }
+
+    #[test]
+    fn test_same_site_serialization() {
+        let strict = Cookie::new("a", "1").unwrap().same_site(SameSite::Strict);
+        assert!(strict.to_header_value().ends_with("; SameSite=Strict"));
+
+        // SameSite=None is only accepted by browsers together with Secure
+        let none = Cookie::new("a", "1")
+            .unwrap()
+            .secure(false)
+            .same_site(SameSite::None);
+        assert_eq!(
+            none.to_header_value(),
+            "a=1; Path=/; Secure; HttpOnly; SameSite=None"
+        );
+
+        let unset = Cookie::new("a", "1").unwrap().no_same_site();
+        assert!(!unset.to_header_value().contains("SameSite"));
+    }
+
+    #[test]
+    fn test_removal() {
+        assert_eq!(
+            Cookie::removal("id").unwrap().to_header_value(),
+            "id=; Path=/; Max-Age=0; Expires=Thu, 01 Jan 1970 00:00:00 GMT; \
+             Secure; HttpOnly; SameSite=Lax"
+        );
+    }
+
+    #[test]
+    fn test_value_encoding_round_trip() {
+        let cookie = Cookie::new("note", "a b;c,\"d\"%").unwrap();
+        assert!(
+            cookie
+                .to_header_value()
+                .starts_with("note=a%20b%3Bc%2C%22d%22%25;")
+        );
+
+        let req = request_with_cookies(&sent_back(&cookie));
+        assert_eq!(req.cookie("note").as_deref(), Some("a b;c,\"d\"%"));
+    }
+
+    #[test]
+    fn test_read_cookies() {
+        let req = request_with_cookies("a=1; b=\"two\";c=; =bad; junk; a=shadowed");
+        assert_eq!(req.cookie("a").as_deref(), Some("1"));
+        assert_eq!(req.cookie("b").as_deref(), Some("two"));
+        assert_eq!(req.cookie("c").as_deref(), Some(""));
+        assert_eq!(req.cookie("missing"), None);
+        assert_eq!(req.cookies().len(), 4);
+
+        let mut lower = HttpRequest::new("GET".to_string(), "/".to_string());
+        lower
+            .headers
+            .insert("cookie".to_string(), "a=1".to_string());
+        assert_eq!(lower.cookie("a").as_deref(), Some("1"));
+    }
+
+    #[test]
+    fn test_signed_cookie_verifies() {
+        let cookie = Cookie::new("session", "user|42.x").unwrap().sign(&key());
+        assert_ne!(cookie.value(), "user|42.x");
+
+        let req = request_with_cookies(&sent_back(&cookie));
+        assert_eq!(req.signed_cookie("session", &key()).unwrap(), "user|42.x");
+    }
+
+    #[test]
+    fn test_signed_cookie_rejects_tampering() {
+        let cookie = Cookie::new("session", "user-42").unwrap().sign(&key());
+
+        // Changed payload, keeping the original tag
+        let (_, tag) = cookie.value().split_once('.').unwrap();
+        let forged = format!("{}.{}", URL_SAFE_NO_PAD.encode("|admin"), tag);
+        let req = request_with_cookies(&format!("session={}", forged));
+        assert_eq!(
+            req.signed_cookie("session", &key()),
+            Err(CookieError::InvalidSignature("session".to_string()))
+        );
+
+        // Unsigned value
+        let req = request_with_cookies("session=user-42");
+        assert!(matches!(
+            req.signed_cookie("session", &key()),
+            Err(CookieError::InvalidSignature(_))
+        ));
+
+        // Signed with a different key
+        let req = request_with_cookies(&sent_back(&cookie));
+        let other = CookieKey::new(b"another key of thirty-two bytes!!");
+        assert!(req.signed_cookie("session", &other).is_err());
+
+        // Valid value moved to a different cookie name
+        let req = request_with_cookies(&format!("admin={}", cookie.value()));
+        assert!(req.signed_cookie("admin", &key()).is_err());
+
+        assert_eq!(
+            req.signed_cookie("session", &key()),
+            Err(CookieError::NotFound("session".to_string()))
+        );
+    }
+
+    #[test]
+    fn test_signed_cookie_max_age() {
+        let live = Cookie::new("session", "42")
+            .unwrap()
+            .max_age(Duration::from_secs(60))
+            .sign(&key());
+        let req = request_with_cookies(&sent_back(&live));
+        assert_eq!(req.signed_cookie("session", &key()).unwrap(), "42");
+
+        let expired = Cookie::new("session", "42")
+            .unwrap()
+            .max_age(Duration::ZERO)
+            .sign(&key());
+        assert!(expired.to_header_value().contains("; Max-Age=0;"));
+        let req = request_with_cookies(&sent_back(&expired));
+        assert_eq!(
+            req.signed_cookie("session", &key()),
+            Err(CookieError::Expired("session".to_string()))
+        );
+
+        let past = Cookie::new("session", "42")
+            .unwrap()
+            .expires(SystemTime::now() - Duration::from_secs(5))
+            .sign(&key());
+        let req = request_with_cookies(&sent_back(&past));
+        assert!(matches!(
+            req.signed_cookie("session", &key()),
+            Err(CookieError::Expired(_))
+        ));
+    }
+
+    #[test]
+    fn test_response_cookies() {
+        let response = HttpResponse::ok()
+            .with_cookie(Cookie::new("a", "1").unwrap())
+            .with_cookie(Cookie::new("b", "2").unwrap())
+            .remove_cookie("old")
+            .unwrap();
+        let names: Vec<&str> = response.cookies().iter().map(Cookie::name).collect();
+        assert_eq!(names, ["a", "b", "old"]);
+
+        let hyper_response = response.into_hyper_response();
+        let set_cookies: Vec<&str> = hyper_response
+            .headers()
+            .get_all("set-cookie")
+            .iter()
+            .map(|v| v.to_str().unwrap())
+            .collect();
+        assert_eq!(set_cookies.len(), 3);
+        assert!(set_cookies[0].starts_with("a=1;"));
+        assert!(set_cookies[2].starts_with("old=;"));
+    }
+
+    #[test]
+    fn test_invalid_cookies_are_rejected() {
+        for name in ["", "a b", "a;b", "a=b", "a\r\nb", "caf\u{e9}"] {
+            assert_eq!(
+                Cookie::new(name, "1"),
+                Err(CookieError::InvalidName(name.to_string()))
+            );
+        }
+        assert!(Cookie::removal("a,b").is_err());
+        assert!(HttpResponse::ok().remove_cookie("a\nb").is_err());
+
+        let cookie = || Cookie::new("id", "7").unwrap();
+        for path in ["", "app", "/a;b", "/a\r\nSet-Cookie: x=1", "/caf\u{e9}"] {
+            assert_eq!(
+                cookie().path(path),
+                Err(CookieError::InvalidPath(path.to_string()))
+            );
+        }
+        for domain in ["", ".", "a..b", "example.com;", "exa mple.com", "a_b.com"] {
+            assert_eq!(
+                cookie().domain(domain),
+                Err(CookieError::InvalidDomain(domain.to_string()))
+            );
+        }
+        assert!(cookie().domain(".example.com").is_ok());
+        assert!(cookie().domain("127.0.0.1").is_ok());
+    }
+
+    #[test]
+    fn test_error_conversion() {
+        let err: crate::Error = CookieError::Expired("session".to_string()).into();
+        assert_eq!(err.status_code(), 400);
+
+        let err: crate::Error = CookieError::InvalidName("a b".to_string()).into();
+        assert_eq!(err.status_code(), 500);
+    }
+}
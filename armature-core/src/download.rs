@@ -0,0 +1,371 @@
+//! File downloads.
+//!
+//! [`HttpRequest::download`] streams a file from disk as an attachment, with
+//! its MIME type and support for `Range` requests so interrupted downloads
+//! can resume. [`HttpResponse::attachment`] does the same for content built
+//! in memory, such as a generated report.
+//!
+//! The name the browser saves the file as goes in the `Content-Disposition`
+//! header. Names that aren't plain ASCII are sent both as an ASCII fallback
+//! and RFC 5987-encoded, so `résumé.pdf` is saved as `résumé.pdf`:
+//!
+//! ```text
+//! Content-Disposition: attachment; filename="r_sum_.pdf"; filename*=UTF-8''r%C3%A9sum%C3%A9.pdf
+//! ```
+//!
+//! # Examples
+//!
+//! ```no_run
+//! use armature_core::{HttpRequest, HttpResponse, Router};
+//!
+//! let mut router = Router::new();
+//! router.get("/reports/latest", |req: HttpRequest| async move {
+//!     req.download("/var/reports/2024-q1.pdf", "Q1 report.pdf").await
+//! });
+//! router.get("/export.csv", |_req: HttpRequest| async {
+//!     Ok(HttpResponse::attachment("export.csv", "id,name\n1,Ada\n"))
+//! });
+//! ```
+
+use crate::static_assets::{ByteRange, FileType, if_range_matches, parse_range, truncate_to_secs};
+use crate::{Error, HttpRequest, HttpResponse};
+use bytes::Bytes;
+use std::io::SeekFrom;
+use std::path::{Component, Path};
+
+impl HttpRequest {
+    /// Send the file at `path` as a download saved as `filename`.
+    ///
+    /// The file is streamed rather than read into memory. The response has
+    /// a `Content-Type` guessed from the extension of `filename`, a
+    /// `Last-Modified` date, and honors single `Range` requests (with
+    /// `If-Range`) by answering `206 Partial Content`.
+    ///
+    /// # Errors
+    ///
+    /// - [`Error::Forbidden`] if `path` contains a `..` component, so a path
+    ///   built from request input can't escape its directory.
+    /// - [`Error::NotFound`] if there is no file at `path`.
+    /// - [`Error::Internal`] if the file can't be read.
+    pub async fn download(
+        &self,
+        path: impl AsRef<Path>,
+        filename: &str,
+    ) -> Result<HttpResponse, Error> {
+        use tokio::io::{AsyncReadExt, AsyncSeekExt};
+
+        let path = path.as_ref();
+        let escapes = path
+            .components()
+            .any(|component| component == Component::ParentDir);
+        if escapes || path.as_os_str().to_string_lossy().contains('\0') {
+            return Err(Error::Forbidden(
+                "Access denied: path traversal attempt".to_string(),
+            ));
+        }
+
+        let not_found = || Error::NotFound(format!("File not found: {}", filename));
+        let metadata = match tokio::fs::metadata(path).await {
+            Ok(metadata) if metadata.is_file() => metadata,
+            Ok(_) => return Err(not_found()),
+            Err(err) if err.kind() == std::io::ErrorKind::NotFound => return Err(not_found()),
+            Err(err) => {
+                return Err(Error::Internal(format!(
+                    "Failed to read file metadata: {}",
+                    err
+                )));
+            }
+        };
+        let size = metadata.len();
+        let modified = metadata.modified().ok().map(truncate_to_secs);
+
+        let range = self
+            .header("Range")
+            .filter(|_| if_range_matches(self, None, modified))
+            .and_then(|header| parse_range(header, size));
+        let mut response = match range {
+            Some(ByteRange::Unsatisfiable) => {
+                return Ok(HttpResponse::new(416)
+                    .with_header("Content-Range".to_string(), format!("bytes */{}", size))
+                    .with_header("Accept-Ranges".to_string(), "bytes".to_string()));
+            }
+            Some(ByteRange::Satisfiable { start, end }) => HttpResponse::new(206).with_header(
+                "Content-Range".to_string(),
+                format!("bytes {}-{}/{}", start, end, size),
+            ),
+            None => HttpResponse::ok(),
+        };
+        let (start, len) = match range {
+            Some(ByteRange::Satisfiable { start, end }) => (start, end - start + 1),
+            _ => (0, size),
+        };
+
+        let read_error =
+            |err: std::io::Error| Error::Internal(format!("Failed to read file: {}", err));
+        let mut file = tokio::fs::File::open(path).await.map_err(read_error)?;
+        if start > 0 {
+            file.seek(SeekFrom::Start(start))
+                .await
+                .map_err(read_error)?;
+        }
+
+        response
+            .headers
+            .insert("Content-Length".to_string(), len.to_string());
+        response
+            .headers
+            .insert("Accept-Ranges".to_string(), "bytes".to_string());
+        if let Some(modified) = modified {
+            response.headers.insert(
+                "Last-Modified".to_string(),
+                httpdate::fmt_http_date(modified),
+            );
+        }
+        Ok(response
+            .with_header("Content-Type".to_string(), mime_type(filename))
+            .as_attachment(filename)
+            .send_stream(file.take(len)))
+    }
+}
+
+impl HttpResponse {
+    /// A `200 OK` download of `content`, saved as `filename`.
+    ///
+    /// The `Content-Type` is guessed from the extension of `filename`. To
+    /// stream the content instead, build the response with
+    /// [`send_stream`](Self::send_stream) and call
+    /// [`as_attachment`](Self::as_attachment).
+    pub fn attachment(filename: &str, content: impl Into<Bytes>) -> Self {
+        Self::ok()
+            .with_header("Content-Type".to_string(), mime_type(filename))
+            .as_attachment(filename)
+            .with_bytes_body(content.into())
+    }
+
+    /// Have the browser save the response as `filename` instead of
+    /// displaying it.
+    ///
+    /// Sets `Content-Disposition: attachment`; see the
+    /// [module docs](crate::download) for how the name is encoded.
+    pub fn as_attachment(self, filename: &str) -> Self {
+        self.with_header(
+            "Content-Disposition".to_string(),
+            content_disposition(filename),
+        )
+    }
+}
+
+/// MIME type for a file name, from its extension
+fn mime_type(filename: &str) -> String {
+    let path = Path::new(filename);
+    FileType::from_path(path).mime_type(path)
+}
+
+/// `Content-Disposition` value for an attachment saved as `filename`
+///
+/// The quoted `filename` parameter is an ASCII fallback with anything else
+/// replaced by `_`. When that loses information, `filename*` carries the
+/// full name as percent-encoded UTF-8 (RFC 5987), which browsers prefer.
+fn content_disposition(filename: &str) -> String {
+    // Separators and control characters never belong in a saved name
+    let name: String = filename
+        .chars()
+        .map(|c| {
+            if c == '/' || c == '\\' || c.is_control() {
+                '_'
+            } else {
+                c
+            }
+        })
+        .collect();
+    if name.is_empty() {
+        return "attachment".to_string();
+    }
+
+    let fallback: String = name
+        .chars()
+        .map(|c| if c.is_ascii() && c != '"' { c } else { '_' })
+        .collect();
+    if fallback == name {
+        format!("attachment; filename=\"{}\"", fallback)
+    } else {
+        format!(
+            "attachment; filename=\"{}\"; filename*=UTF-8''{}",
+            fallback,
+            urlencoding::encode(&name)
+        )
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use http_body_util::BodyExt;
+
+    struct TempFile(std::path::PathBuf);
+
+    impl TempFile {
+        fn new(content: &[u8]) -> Self {
+            let path =
+                std::env::temp_dir().join(format!("armature-download-{}", uuid::Uuid::new_v4()));
+            std::fs::write(&path, content).unwrap();
+            Self(path)
+        }
+    }
+
+    impl Drop for TempFile {
+        fn drop(&mut self) {
+            let _ = std::fs::remove_file(&self.0);
+        }
+    }
+
+    fn request(headers: &[(&str, &str)]) -> HttpRequest {
+        let mut req = HttpRequest::new("GET".into(), "/download".into());
+        for (name, value) in headers {
+            req.headers.insert(name.to_string(), value.to_string());
+        }
+        req
+    }
+
+    async fn body(response: HttpResponse) -> Vec<u8> {
+        let body = response.into_hyper_response().into_body();
+        body.collect().await.unwrap().to_bytes().to_vec()
+    }
+
+    #[test]
+    fn test_content_disposition() {
+        assert_eq!(
+            content_disposition("report.pdf"),
+            "attachment; filename=\"report.pdf\""
+        );
+        assert_eq!(
+            content_disposition("résumé.pdf"),
+            "attachment; filename=\"r_sum_.pdf\"; filename*=UTF-8''r%C3%A9sum%C3%A9.pdf"
+        );
+        assert_eq!(
+            content_disposition("日報 \"final\".txt"),
+            "attachment; filename=\"__ _final_.txt\"; \
+             filename*=UTF-8''%E6%97%A5%E5%A0%B1%20%22final%22.txt"
+        );
+        assert_eq!(
+            content_disposition("../etc/passwd\r\n"),
+            "attachment; filename=\".._etc_passwd__\""
+        );
+        assert_eq!(content_disposition(""), "attachment");
+    }
+
+    #[tokio::test]
+    async fn test_download_streams_file() {
+        let file = TempFile::new(b"%PDF-1.7 contents");
+
+        let response = request(&[]).download(&file.0, "résumé.pdf").await.unwrap();
+        assert_eq!(response.status, 200);
+        assert!(response.is_streaming());
+        assert_eq!(
+            response.headers.get("Content-Type").unwrap(),
+            "application/pdf"
+        );
+        assert_eq!(response.headers.get("Content-Length").unwrap(), "17");
+        assert_eq!(response.headers.get("Accept-Ranges").unwrap(), "bytes");
+        assert!(response.headers.get("Last-Modified").is_some());
+        assert!(
+            response
+                .headers
+                .get("Content-Disposition")
+                .unwrap()
+                .ends_with("filename*=UTF-8''r%C3%A9sum%C3%A9.pdf")
+        );
+        assert_eq!(body(response).await, b"%PDF-1.7 contents");
+    }
+
+    #[tokio::test]
+    async fn test_download_ranges() {
+        let file = TempFile::new(b"0123456789");
+
+        let response = request(&[("range", "bytes=2-5")])
+            .download(&file.0, "digits.txt")
+            .await
+            .unwrap();
+        assert_eq!(response.status, 206);
+        assert_eq!(
+            response.headers.get("Content-Range").unwrap(),
+            "bytes 2-5/10"
+        );
+        assert_eq!(response.headers.get("Content-Length").unwrap(), "4");
+        assert_eq!(body(response).await, b"2345");
+
+        let response = request(&[("range", "bytes=-3")])
+            .download(&file.0, "digits.txt")
+            .await
+            .unwrap();
+        assert_eq!(body(response).await, b"789");
+
+        let response = request(&[("range", "bytes=20-")])
+            .download(&file.0, "digits.txt")
+            .await
+            .unwrap();
+        assert_eq!(response.status, 416);
+        assert_eq!(response.headers.get("Content-Range").unwrap(), "bytes */10");
+
+        // A stale If-Range gets the whole file
+        let response = request(&[
+            ("range", "bytes=2-5"),
+            ("if-range", "Wed, 21 Oct 2015 07:28:00 GMT"),
+        ])
+        .download(&file.0, "digits.txt")
+        .await
+        .unwrap();
+        assert_eq!(response.status, 200);
+        assert_eq!(body(response).await, b"0123456789");
+    }
+
+    #[tokio::test]
+    async fn test_download_errors() {
+        let missing = std::env::temp_dir().join(format!("missing-{}.pdf", uuid::Uuid::new_v4()));
+        let err = request(&[])
+            .download(&missing, "report.pdf")
+            .await
+            .unwrap_err();
+        assert!(matches!(err, Error::NotFound(_)), "{:?}", err);
+        assert!(err.to_string().contains("report.pdf"));
+        assert!(!err.to_string().contains("missing-"));
+
+        let err = request(&[])
+            .download(std::env::temp_dir(), "dir")
+            .await
+            .unwrap_err();
+        assert!(matches!(err, Error::NotFound(_)), "{:?}", err);
+
+        let file = TempFile::new(b"secret");
+        let escaping = file
+            .0
+            .parent()
+            .unwrap()
+            .join("..")
+            .join(file.0.strip_prefix("/").unwrap());
+        let err = request(&[]).download(&escaping, "x").await.unwrap_err();
+        assert!(matches!(err, Error::Forbidden(_)), "{:?}", err);
+    }
+
+    #[tokio::test]
+    async fn test_attachment() {
+        let response = HttpResponse::attachment("export.csv", "id\n1\n");
+        assert_eq!(response.status, 200);
+        assert_eq!(response.headers.get("Content-Type").unwrap(), "text/csv");
+        assert_eq!(
+            response.headers.get("Content-Disposition").unwrap(),
+            "attachment; filename=\"export.csv\""
+        );
+        assert_eq!(response.body_ref(), b"id\n1\n");
+
+        let response = HttpResponse::attachment("naïve", vec![1, 2, 3]);
+        assert_eq!(
+            response.headers.get("Content-Type").unwrap(),
+            "application/octet-stream"
+        );
+        assert_eq!(
+            response.headers.get("Content-Disposition").unwrap(),
+            "attachment; filename=\"na_ve\"; filename*=UTF-8''na%C3%AFve"
+        );
+    }
+}
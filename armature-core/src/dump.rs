@@ -0,0 +1,566 @@
+//! Request and response body dumping, for debugging integrations.
+//!
+//! [`BodyDump`] logs each request and its response with their headers and
+//! bodies, so the payloads exchanged with a misbehaving client can be seen
+//! as they were:
+//!
+//! ```rust
+//! use armature_core::{BodyDump, MiddlewareChain};
+//!
+//! let mut chain = MiddlewareChain::new();
+//! chain.use_middleware(
+//!     BodyDump::new()
+//!         .max_body_size(16 * 1024)
+//!         .redact_header("X-Session")
+//!         .redact_field("card_number"),
+//! );
+//! ```
+//!
+//! ## What Gets Dumped
+//!
+//! Bodies are dumped when their content type is on the allowlist, which by
+//! default holds JSON, XML, URL-encoded forms and `text/*`, so images,
+//! archives and other binary payloads only show their size. Bodies longer
+//! than [`max_body_size`](BodyDump::max_body_size) are cut off there.
+//!
+//! Streaming responses, such as event streams and downloads, are passed on
+//! untouched and logged without their body.
+//!
+//! ## Redaction
+//!
+//! The values of sensitive headers (`Authorization`, `Cookie`, ...) and of
+//! sensitive fields in JSON and URL-encoded bodies and in the query string
+//! (`password`, `token`, ...) are replaced with `[REDACTED]`, at any depth
+//! of a JSON document. Names are compared case-insensitively. A JSON or form
+//! body that can't be parsed can't be redacted either, so it is left out of
+//! the dump, as is a query string that can't be parsed.
+//!
+//! `text/*` and `application/xml` bodies are dumped as they are, without
+//! redaction; take them off the allowlist with
+//! [`content_types`](BodyDump::content_types) if they may carry secrets.
+//!
+//! Only the dump is redacted: the handler and the client get the request
+//! and response unchanged.
+//!
+//! ## Output
+//!
+//! Each exchange is one `tracing` event at DEBUG level, or one JSON object
+//! per line on the writer given to [`with_writer`](BodyDump::with_writer).
+//! Dumps contain user data; enable this while debugging, not in
+//! production.
+
+use crate::logging::debug;
+use crate::middleware::{Middleware, Next};
+use crate::{Error, HttpRequest, HttpResponse};
+use async_trait::async_trait;
+use serde_json::{Map, Value, json};
+use std::collections::{BTreeMap, HashSet};
+use std::io::Write;
+use std::sync::{Arc, Mutex};
+
+/// Replacement for redacted header and field values
+pub const REDACTED: &str = "[REDACTED]";
+
+type SharedWriter = Arc<Mutex<Box<dyn Write + Send>>>;
+
+/// Middleware that logs request and response headers and bodies.
+///
+/// See the [module documentation](self) for what is dumped and redacted.
+#[derive(Clone)]
+pub struct BodyDump {
+    max_body_size: usize,
+    content_types: Vec<String>,
+    redact_headers: HashSet<String>,
+    redact_fields: HashSet<String>,
+    writer: Option<SharedWriter>,
+}
+
+impl BodyDump {
+    /// Dump bodies up to 4 KiB of the default content types, redacting the
+    /// default headers and fields.
+    pub fn new() -> Self {
+        Self {
+            max_body_size: 4096,
+            content_types: [
+                "application/json",
+                "application/xml",
+                "application/x-www-form-urlencoded",
+                "text/*",
+            ]
+            .map(String::from)
+            .to_vec(),
+            redact_headers: [
+                "authorization",
+                "proxy-authorization",
+                "cookie",
+                "set-cookie",
+                "x-api-key",
+            ]
+            .map(String::from)
+            .into(),
+            redact_fields: [
+                "password",
+                "token",
+                "access_token",
+                "refresh_token",
+                "secret",
+                "client_secret",
+            ]
+            .map(String::from)
+            .into(),
+            writer: None,
+        }
+    }
+
+    /// Cut bodies off after `bytes` bytes.
+    pub fn max_body_size(mut self, bytes: usize) -> Self {
+        self.max_body_size = bytes;
+        self
+    }
+
+    /// Replace the content types whose bodies are dumped.
+    ///
+    /// Entries are media types without parameters, or `type/*` for all
+    /// subtypes. `application/json` and `application/xml` also cover
+    /// `+json` and `+xml` types such as `application/problem+json`.
+    pub fn content_types<I, S>(mut self, content_types: I) -> Self
+    where
+        I: IntoIterator<Item = S>,
+        S: Into<String>,
+    {
+        self.content_types = content_types
+            .into_iter()
+            .map(|content_type| content_type.into().to_ascii_lowercase())
+            .collect();
+        self
+    }
+
+    /// Also redact the value of the header `name`.
+    pub fn redact_header(mut self, name: impl Into<String>) -> Self {
+        self.redact_headers.insert(name.into().to_ascii_lowercase());
+        self
+    }
+
+    /// Also redact the JSON and form field `name`.
+    pub fn redact_field(mut self, name: impl Into<String>) -> Self {
+        self.redact_fields.insert(name.into().to_ascii_lowercase());
+        self
+    }
+
+    /// Write one JSON object per exchange to `writer` instead of logging
+    /// `tracing` events.
+    pub fn with_writer<W: Write + Send + 'static>(mut self, writer: W) -> Self {
+        self.writer = Some(Arc::new(Mutex::new(Box::new(writer))));
+        self
+    }
+
+    fn headers<'a>(&self, headers: impl Iterator<Item = (&'a String, &'a String)>) -> Value {
+        let headers: BTreeMap<_, _> = headers
+            .map(|(name, value)| {
+                let value = if self.redact_headers.contains(&name.to_ascii_lowercase()) {
+                    REDACTED
+                } else {
+                    value.as_str()
+                };
+                (name.clone(), Value::from(value))
+            })
+            .collect();
+        json!(headers)
+    }
+
+    /// Describe a body: its size, and its redacted, capped text when the
+    /// content type allows it
+    fn body(&self, content_type: Option<&str>, body: &[u8]) -> Map<String, Value> {
+        let mut dump = Map::new();
+        dump.insert("size".to_string(), body.len().into());
+        if body.is_empty() {
+            return dump;
+        }
+
+        let essence = content_type
+            .and_then(|content_type| content_type.split(';').next())
+            .map(|essence| essence.trim().to_ascii_lowercase())
+            .unwrap_or_default();
+        if !self.allows(&essence) {
+            dump.insert("omitted".to_string(), "content type".into());
+            return dump;
+        }
+
+        let redacted = if is_json(&essence) {
+            serde_json::from_slice::<Value>(body).ok().map(|mut value| {
+                self.redact(&mut value);
+                value.to_string().into_bytes()
+            })
+        } else if essence == "application/x-www-form-urlencoded" {
+            self.redact_form(body)
+        } else {
+            Some(body.to_vec())
+        };
+        let Some(text) = redacted else {
+            dump.insert("omitted".to_string(), "unparseable".into());
+            return dump;
+        };
+
+        if text.len() > self.max_body_size {
+            dump.insert(
+                "body".to_string(),
+                String::from_utf8_lossy(&text[..self.max_body_size]).into(),
+            );
+            dump.insert("truncated".to_string(), true.into());
+        } else {
+            dump.insert("body".to_string(), String::from_utf8_lossy(&text).into());
+        }
+        dump
+    }
+
+    fn allows(&self, essence: &str) -> bool {
+        if essence.is_empty() {
+            return false;
+        }
+        self.content_types.iter().any(|allowed| {
+            if let Some(type_) = allowed.strip_suffix("/*") {
+                essence.split('/').next() == Some(type_)
+            } else {
+                allowed == essence
+                    || (allowed == "application/json" && essence.ends_with("+json"))
+                    || (allowed == "application/xml" && essence.ends_with("+xml"))
+            }
+        })
+    }
+
+    fn redact(&self, value: &mut Value) {
+        match value {
+            Value::Object(object) => {
+                for (key, value) in object.iter_mut() {
+                    if self.redact_fields.contains(&key.to_ascii_lowercase()) {
+                        *value = REDACTED.into();
+                    } else {
+                        self.redact(value);
+                    }
+                }
+            }
+            Value::Array(values) => values.iter_mut().for_each(|value| self.redact(value)),
+            _ => {}
+        }
+    }
+
+    fn redact_form(&self, body: &[u8]) -> Option<Vec<u8>> {
+        let mut fields: Vec<(String, String)> = serde_urlencoded::from_bytes(body).ok()?;
+        for (key, value) in &mut fields {
+            if self.redact_fields.contains(&key.to_ascii_lowercase()) {
+                *value = REDACTED.to_string();
+            }
+        }
+        serde_urlencoded::to_string(fields)
+            .ok()
+            .map(String::into_bytes)
+    }
+
+    /// The path with sensitive query parameters redacted
+    fn redact_path(&self, path: &str) -> String {
+        let Some((path, query)) = path.split_once('?') else {
+            return path.to_string();
+        };
+        match self.redact_form(query.as_bytes()) {
+            Some(query) => format!("{}?{}", path, String::from_utf8_lossy(&query)),
+            None => path.to_string(),
+        }
+    }
+
+    fn emit(&self, dump: Value) {
+        match &self.writer {
+            Some(writer) => {
+                if let Ok(mut writer) = writer.lock() {
+                    let _ = writeln!(writer, "{}", dump);
+                }
+            }
+            None => debug!(
+                method = %dump["method"].as_str().unwrap_or_default(),
+                path = %dump["path"].as_str().unwrap_or_default(),
+                request = %dump["request"],
+                response = %dump["response"],
+                "HTTP exchange"
+            ),
+        }
+    }
+}
+
+impl Default for BodyDump {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+impl std::fmt::Debug for BodyDump {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.debug_struct("BodyDump")
+            .field("max_body_size", &self.max_body_size)
+            .field("content_types", &self.content_types)
+            .field("redact_headers", &self.redact_headers)
+            .field("redact_fields", &self.redact_fields)
+            .field("writer", &self.writer.is_some())
+            .finish()
+    }
+}
+
+#[async_trait]
+impl Middleware for BodyDump {
+    async fn handle(&self, req: HttpRequest, next: Next) -> Result<HttpResponse, Error> {
+        // The body is already buffered; it is only read here, so the handler
+        // gets it unchanged
+        let mut request = self.body(req.header("content-type"), req.body_ref());
+        request.insert("headers".to_string(), self.headers(req.headers.iter()));
+        let mut dump = json!({
+            "msg": "dump",
+            "method": req.method,
+            "path": self.redact_path(&req.path),
+            "request": request,
+        });
+
+        let result = next(req).await;
+        dump["response"] = match &result {
+            Ok(response) => {
+                let mut body = if response.is_streaming() || is_chunked(response) {
+                    let mut body = Map::new();
+                    body.insert("omitted".to_string(), "streaming".into());
+                    body
+                } else {
+                    self.body(header(response, "content-type"), response.body_ref())
+                };
+                body.insert("status".to_string(), response.status.into());
+                body.insert("headers".to_string(), self.headers(response.headers.iter()));
+                Value::Object(body)
+            }
+            Err(err) => json!({ "status": err.status_code(), "error": err.to_string() }),
+        };
+
+        self.emit(dump);
+        result
+    }
+}
+
+fn is_json(essence: &str) -> bool {
+    essence == "application/json" || essence.ends_with("+json")
+}
+
+fn is_chunked(response: &HttpResponse) -> bool {
+    header(response, "transfer-encoding").is_some_and(|value| {
+        value
+            .split(',')
+            .any(|coding| coding.trim().eq_ignore_ascii_case("chunked"))
+    })
+}
+
+fn header<'a>(response: &'a HttpResponse, name: &str) -> Option<&'a str> {
+    response
+        .headers
+        .iter()
+        .find(|(key, _)| key.eq_ignore_ascii_case(name))
+        .map(|(_, value)| value.as_str())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::middleware::{HandlerFn, MiddlewareChain};
+
+    /// Writer that keeps everything written to it
+    #[derive(Clone, Default)]
+    struct Capture(Arc<Mutex<Vec<u8>>>);
+
+    impl Write for Capture {
+        fn write(&mut self, buf: &[u8]) -> std::io::Result<usize> {
+            self.0.lock().unwrap().extend_from_slice(buf);
+            Ok(buf.len())
+        }
+
+        fn flush(&mut self) -> std::io::Result<()> {
+            Ok(())
+        }
+    }
+
+    impl Capture {
+        fn dumps(&self) -> Vec<Value> {
+            String::from_utf8(self.0.lock().unwrap().clone())
+                .unwrap()
+                .lines()
+                .map(|line| serde_json::from_str(line).unwrap())
+                .collect()
+        }
+    }
+
+    async fn run(dump: BodyDump, req: HttpRequest, handler: HandlerFn) -> Value {
+        let capture = Capture::default();
+        let mut chain = MiddlewareChain::new();
+        chain.use_middleware(dump.with_writer(capture.clone()));
+        let _ = chain.apply(req, handler).await;
+        let mut dumps = capture.dumps();
+        assert_eq!(dumps.len(), 1);
+        dumps.remove(0)
+    }
+
+    fn post(content_type: &str, body: &[u8]) -> HttpRequest {
+        let mut req = HttpRequest::new("POST".into(), "/login".into());
+        req.headers
+            .insert("Content-Type".into(), content_type.into());
+        req.body = body.to_vec();
+        req
+    }
+
+    fn echo() -> HandlerFn {
+        Arc::new(|req: HttpRequest| {
+            Box::pin(async move {
+                let content_type = req.header("content-type").unwrap_or_default().to_string();
+                Ok(HttpResponse::ok()
+                    .content_type(content_type)
+                    .with_body(req.body_ref().to_vec()))
+            })
+        })
+    }
+
+    #[tokio::test]
+    async fn test_redacts_headers_and_fields() {
+        let mut req = post(
+            "application/json",
+            br#"{"user":"ann","Password":"hunter2","nested":[{"token":"t0k"}]}"#,
+        );
+        req.headers
+            .insert("Authorization".into(), "Bearer abc".into());
+        req.headers.insert("X-Session".into(), "s1".into());
+        let dump = run(BodyDump::new().redact_header("x-session"), req, echo()).await;
+
+        let request = &dump["request"];
+        assert_eq!(request["headers"]["Authorization"], REDACTED);
+        assert_eq!(request["headers"]["X-Session"], REDACTED);
+        assert_eq!(request["headers"]["Content-Type"], "application/json");
+        let body: Value = serde_json::from_str(request["body"].as_str().unwrap()).unwrap();
+        assert_eq!(
+            body,
+            json!({"user": "ann", "Password": REDACTED, "nested": [{"token": REDACTED}]})
+        );
+        // The echoed response is redacted the same way
+        let body: Value = serde_json::from_str(dump["response"]["body"].as_str().unwrap()).unwrap();
+        assert_eq!(body["Password"], REDACTED);
+
+        // Form fields, with a field added to the defaults
+        let req = post(
+            "application/x-www-form-urlencoded; charset=utf-8",
+            b"user=ann&password=hunter2&pin=1234",
+        );
+        let dump = run(BodyDump::new().redact_field("PIN"), req, echo()).await;
+        assert_eq!(
+            dump["request"]["body"],
+            "user=ann&password=%5BREDACTED%5D&pin=%5BREDACTED%5D"
+        );
+
+        // Query parameters in the path
+        let mut req = post("application/json", b"{}");
+        req.path = "/login?user=ann&token=t0k".into();
+        let dump = run(BodyDump::new(), req, echo()).await;
+        assert_eq!(dump["path"], "/login?user=ann&token=%5BREDACTED%5D");
+
+        // Unparseable JSON could leak fields, so it isn't shown
+        let req = post("application/json", br#"{"password":"hunter2""#);
+        let dump = run(BodyDump::new(), req, echo()).await;
+        assert_eq!(dump["request"]["omitted"], "unparseable");
+        assert!(dump["request"].get("body").is_none());
+    }
+
+    #[tokio::test]
+    async fn test_caps_body_size_and_skips_binary() {
+        let req = post("text/plain", &[b'a'; 100]);
+        let dump = run(BodyDump::new().max_body_size(10), req, echo()).await;
+        assert_eq!(dump["request"]["body"], "aaaaaaaaaa");
+        assert_eq!(dump["request"]["size"], 100);
+        assert_eq!(dump["request"]["truncated"], true);
+        assert_eq!(dump["response"]["body"], "aaaaaaaaaa");
+
+        let req = post("text/plain", b"short");
+        let dump = run(BodyDump::new().max_body_size(10), req, echo()).await;
+        assert_eq!(dump["request"]["body"], "short");
+        assert!(dump["request"].get("truncated").is_none());
+
+        let req = post("image/png", b"\x89PNG\r\n");
+        let dump = run(BodyDump::new(), req, echo()).await;
+        assert_eq!(dump["request"]["size"], 6);
+        assert_eq!(dump["request"]["omitted"], "content type");
+        assert!(dump["response"].get("body").is_none());
+
+        // Suffixed JSON types count as JSON; other lists replace the defaults
+        let req = post("application/problem+json", br#"{"title":"x"}"#);
+        let dump = run(BodyDump::new(), req, echo()).await;
+        assert_eq!(dump["request"]["body"], r#"{"title":"x"}"#);
+        let req = post("text/plain", b"hi");
+        let dump = run(BodyDump::new().content_types(["image/*"]), req, echo()).await;
+        assert_eq!(dump["request"]["omitted"], "content type");
+    }
+
+    #[tokio::test]
+    async fn test_handler_gets_body_unchanged() {
+        let seen = Arc::new(Mutex::new(Vec::new()));
+        let handler: HandlerFn = {
+            let seen = seen.clone();
+            Arc::new(move |req: HttpRequest| {
+                let seen = seen.clone();
+                Box::pin(async move {
+                    *seen.lock().unwrap() = req.body_ref().to_vec();
+                    Ok(HttpResponse::ok()
+                        .content_type("application/json")
+                        .with_body(br#"{"password":"p"}"#.to_vec()))
+                })
+            })
+        };
+        let body = br#"{"password":"hunter2","bulk":"0123456789"}"#;
+
+        let capture = Capture::default();
+        let mut chain = MiddlewareChain::new();
+        chain.use_middleware(
+            BodyDump::new()
+                .max_body_size(8)
+                .with_writer(capture.clone()),
+        );
+        let response = chain
+            .apply(post("application/json", body), handler)
+            .await
+            .unwrap();
+
+        assert_eq!(*seen.lock().unwrap(), body.to_vec());
+        assert_eq!(response.body_ref(), br#"{"password":"p"}"#);
+        assert_eq!(capture.dumps()[0]["request"]["truncated"], true);
+    }
+
+    #[tokio::test]
+    async fn test_streaming_responses_are_not_captured() {
+        let handler: HandlerFn = Arc::new(|_req: HttpRequest| {
+            Box::pin(async move {
+                Ok(HttpResponse::ok()
+                    .content_type("text/plain")
+                    .stream(|w| async move { w.write_str("chunk").await }))
+            })
+        });
+        let capture = Capture::default();
+        let mut chain = MiddlewareChain::new();
+        chain.use_middleware(BodyDump::new().with_writer(capture.clone()));
+        let response = chain
+            .apply(HttpRequest::new("GET".into(), "/events".into()), handler)
+            .await
+            .unwrap();
+
+        assert!(response.is_streaming());
+        let dump = &capture.dumps()[0];
+        assert_eq!(dump["response"]["omitted"], "streaming");
+        assert_eq!(dump["response"]["status"], 200);
+
+        // Errors are logged with their status
+        let handler: HandlerFn =
+            Arc::new(|_req: HttpRequest| Box::pin(async { Err(Error::Forbidden("no".into())) }));
+        let dump = run(
+            BodyDump::new(),
+            HttpRequest::new("GET".into(), "/".into()),
+            handler,
+        )
+        .await;
+        assert_eq!(dump["response"]["status"], 403);
+        assert!(dump["response"]["error"].as_str().unwrap().contains("no"));
+    }
+}
@@ -1,6 +1,8 @@
 // Error types for the Armature framework
 
 use crate::HttpStatus;
+use std::error::Error as StdError;
+use std::fmt;
 use thiserror::Error;
 
 #[derive(Error, Debug)]
@@ -37,6 +39,9 @@ pub enum Error {
     #[error("Validation error: {0}")]
     Validation(String),
 
+    #[error("Validation failed: {0}")]
+    ValidationFailed(crate::bind::ValidationError),
+
     #[error("Internal server error: {0}. Check server logs for details.")]
     Internal(String),
 
@@ -46,6 +51,19 @@ pub enum Error {
     #[error("IO error: {0}")]
     Io(#[from] std::io::Error),
 
+    #[error("Shutdown timed out: {0}. Remaining connections were closed.")]
+    ShutdownTimeout(String),
+
+    /// An error with an explicit status code and client-facing message
+    #[error(transparent)]
+    Status(#[from] HttpError),
+
+    /// Any other error
+    ///
+    /// The status comes from an [`HttpError`] in its source chain, or is 500.
+    #[error(transparent)]
+    Other(Box<dyn StdError + Send + Sync>),
+
     // 4xx Client Errors
     #[error("Bad Request: {0}. Check the request parameters and body format.")]
     BadRequest(String),
@@ -174,10 +192,14 @@ impl Error {
     /// Get the HTTP status code for this error
     pub fn status_code(&self) -> u16 {
         match self {
+            Error::Status(err) => err.status(),
+            Error::Other(_) => self.http_error().map_or(500, HttpError::status),
+
             // Legacy mappings
             Error::RouteNotFound(_) => HttpStatus::NotFound.code(),
             Error::MethodNotAllowed(_) => HttpStatus::MethodNotAllowed.code(),
             Error::Validation(_) => HttpStatus::BadRequest.code(),
+            Error::ValidationFailed(_) => HttpStatus::UnprocessableEntity.code(),
             Error::Deserialization(_) => HttpStatus::BadRequest.code(),
             Error::Forbidden(_) => HttpStatus::Forbidden.code(),
 
@@ -229,6 +251,30 @@ impl Error {
         }
     }
 
+    /// The [`HttpError`] this error is or wraps, if any
+    ///
+    /// For [`Error::Other`] the whole source chain is searched, so an
+    /// `HttpError` wrapped inside another error type is still found.
+    pub fn http_error(&self) -> Option<&HttpError> {
+        match self {
+            Error::Status(err) => Some(err),
+            Error::Other(err) => {
+                let mut current: Option<&(dyn StdError + 'static)> = Some(err.as_ref());
+                while let Some(err) = current {
+                    if let Some(http) = err.downcast_ref::<HttpError>() {
+                        return Some(http);
+                    }
+                    if let Some(http) = err.downcast_ref::<Error>().and_then(Error::http_error) {
+                        return Some(http);
+                    }
+                    current = err.source();
+                }
+                None
+            }
+            _ => None,
+        }
+    }
+
     /// Get the HttpStatus enum for this error
     pub fn http_status(&self) -> HttpStatus {
         HttpStatus::from_code(self.status_code()).unwrap_or(HttpStatus::InternalServerError)
@@ -298,6 +344,11 @@ impl Error {
         Self::ServiceUnavailable(msg.into())
     }
 
+    /// Wrap any other error.
+    pub fn other(err: impl Into<Box<dyn StdError + Send + Sync>>) -> Self {
+        Self::Other(err.into())
+    }
+
     /// Get a help message with suggestions for resolving this error.
     pub fn help(&self) -> Option<&'static str> {
         match self {
@@ -338,6 +389,85 @@ impl Error {
     }
 }
 
+/// An error with a status code, a client-facing message and an optional
+/// internal cause
+///
+/// The message is what clients see; the cause is only logged. Convert into
+/// [`Error`] with `?` or `.into()`.
+///
+/// ```
+/// use armature_core::{Error, HttpError};
+///
+/// fn find_user(id: u64) -> Result<String, Error> {
+///     let io = std::io::Error::other("connection refused");
+///     Err(HttpError::new(503, "user store unavailable")
+///         .with_source(io)
+///         .into())
+/// }
+///
+/// let err = find_user(7).unwrap_err();
+/// assert_eq!(err.status_code(), 503);
+/// assert_eq!(err.to_string(), "user store unavailable");
+/// ```
+#[derive(Debug)]
+pub struct HttpError {
+    status: u16,
+    message: String,
+    source: Option<Box<dyn StdError + Send + Sync>>,
+}
+
+impl HttpError {
+    /// Create an error with a status code and message.
+    ///
+    /// A status outside `100..=999`, which can't be sent, becomes 500.
+    pub fn new(status: u16, message: impl Into<String>) -> Self {
+        Self {
+            status: if (100..=999).contains(&status) {
+                status
+            } else {
+                500
+            },
+            message: message.into(),
+            source: None,
+        }
+    }
+
+    /// Create an error for a status with its standard reason as the message.
+    pub fn from_status(status: HttpStatus) -> Self {
+        Self::new(status.code(), status.reason())
+    }
+
+    /// Attach the internal cause.
+    pub fn with_source(mut self, source: impl Into<Box<dyn StdError + Send + Sync>>) -> Self {
+        self.source = Some(source.into());
+        self
+    }
+
+    /// The status code.
+    pub fn status(&self) -> u16 {
+        self.status
+    }
+
+    /// The client-facing message.
+    pub fn message(&self) -> &str {
+        &self.message
+    }
+}
+
+impl fmt::Display for HttpError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str(&self.message)
+    }
+}
+
+impl StdError for HttpError {
+    fn source(&self) -> Option<&(dyn StdError + 'static)> {
+        self.source
+            .as_deref()
+            .map(|err| err as &(dyn StdError + 'static))
+    }
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -390,6 +520,78 @@ mod tests {
         assert_eq!(err.status_code(), 410);
     }
 
+    /// An application error that keeps its cause as the source
+    #[derive(Debug)]
+    struct LookupError(HttpError);
+
+    impl fmt::Display for LookupError {
+        fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+            f.write_str("lookup failed")
+        }
+    }
+
+    impl StdError for LookupError {
+        fn source(&self) -> Option<&(dyn StdError + 'static)> {
+            Some(&self.0)
+        }
+    }
+
+    #[test]
+    fn test_http_error_status_and_source() {
+        let err: Error = HttpError::new(409, "name taken")
+            .with_source(std::io::Error::other("unique constraint"))
+            .into();
+        assert_eq!(err.status_code(), 409);
+        assert_eq!(err.to_string(), "name taken");
+
+        let http = err.http_error().unwrap();
+        assert_eq!(http.message(), "name taken");
+        assert_eq!(
+            StdError::source(http).unwrap().to_string(),
+            "unique constraint"
+        );
+
+        let err: Error = HttpError::from_status(HttpStatus::TooManyRequests).into();
+        assert_eq!(err.status_code(), 429);
+        assert_eq!(err.to_string(), "Too Many Requests");
+    }
+
+    #[test]
+    fn test_http_error_invalid_status() {
+        for status in [0, 42, 1000] {
+            let err: Error = HttpError::new(status, "odd").into();
+            assert_eq!(err.status_code(), 500);
+            assert_eq!(err.to_string(), "odd");
+            let response = crate::HttpResponse::new(err.status_code()).into_hyper_response();
+            assert_eq!(response.status(), 500);
+        }
+        assert_eq!(HttpError::new(999, "custom").status(), 999);
+    }
+
+    #[test]
+    fn test_wrapped_http_error_is_found() {
+        let err = Error::other(LookupError(HttpError::new(404, "no such user")));
+        assert_eq!(err.status_code(), 404);
+        assert_eq!(err.http_error().unwrap().message(), "no such user");
+        assert_eq!(err.to_string(), "lookup failed");
+
+        // An Error wrapped inside another error is searched too
+        let inner: Box<dyn StdError + Send + Sync> =
+            Box::new(Error::from(HttpError::new(402, "upgrade your plan")));
+        let err = Error::other(inner);
+        assert_eq!(err.status_code(), 402);
+    }
+
+    #[test]
+    fn test_other_error_without_http_error_is_500() {
+        let err = Error::other(std::io::Error::other("disk on fire"));
+        assert_eq!(err.status_code(), 500);
+        assert!(err.http_error().is_none());
+
+        // Other variants are not HTTP errors, whatever their status
+        assert!(Error::NotFound("user".to_string()).http_error().is_none());
+    }
+
     #[test]
     fn test_payload_too_large() {
         let err = Error::PayloadTooLarge("file too big".to_string());
@@ -0,0 +1,203 @@
+//! Error and panic hooks for observability integrations.
+//!
+//! Register hooks with [`Application::on_error`](crate::Application::on_error)
+//! and [`Application::on_panic`](crate::Application::on_panic) to forward
+//! failures to a service such as Sentry or Datadog. Hooks run before the
+//! error response is written, in the order they were registered, and get
+//! the method, path and request ID through [`RequestInfo`]. A hook that
+//! panics is logged and skipped, so it can't take the server down.
+//!
+//! Error hooks see every error a handler or middleware returns, whatever
+//! its status; check [`Error::status_code`] to report only server errors.
+//! Panic hooks see the panics caught by
+//! [`RecoverMiddleware`](crate::recover::RecoverMiddleware), and the 500
+//! that replaces such a panic is not reported to the error hooks again.
+//!
+//! # Examples
+//!
+//! ```
+//! use armature_core::*;
+//!
+//! let app = Application::new(Container::new(), Router::new())
+//!     .on_error(|info: &RequestInfo, err: &Error| {
+//!         if err.status_code() >= 500 {
+//!             eprintln!("{} {} failed: {}", info.method, info.path, err);
+//!         }
+//!     })
+//!     .on_panic(|info: &RequestInfo, panic: &RecoveredPanic| {
+//!         eprintln!("{} {} panicked: {}", info.method, info.path, panic.message);
+//!     });
+//! ```
+
+use crate::Error;
+use crate::logging::error;
+use crate::recover::RecoveredPanic;
+use std::panic::{self, AssertUnwindSafe};
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::{Arc, OnceLock};
+
+/// Callback invoked with a request's error.
+pub type ErrorHook = Arc<dyn Fn(&RequestInfo, &Error) + Send + Sync>;
+
+/// Callback invoked with a panic recovered while handling a request.
+pub type PanicHook = Arc<dyn Fn(&RequestInfo, &RecoveredPanic) + Send + Sync>;
+
+/// The request an error or panic hook is called for.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct RequestInfo {
+    /// HTTP method
+    pub method: String,
+    /// Request path, without the query string
+    pub path: String,
+    /// ID assigned by [`RequestIdMiddleware`](crate::RequestIdMiddleware),
+    /// if it ran before the failure
+    pub request_id: Option<String>,
+}
+
+/// Hooks registered on an application
+#[derive(Clone, Default)]
+pub(crate) struct ErrorHooks {
+    pub(crate) errors: Vec<ErrorHook>,
+    pub(crate) panics: Vec<PanicHook>,
+}
+
+/// Per-request hook state, kept in the request extensions so middleware
+/// can report to it
+#[derive(Clone)]
+pub(crate) struct HookScope(Arc<ScopeState>);
+
+struct ScopeState {
+    hooks: Arc<ErrorHooks>,
+    method: String,
+    path: String,
+    request_id: OnceLock<String>,
+    panicked: AtomicBool,
+}
+
+impl HookScope {
+    pub(crate) fn new(hooks: Arc<ErrorHooks>, method: String, path: String) -> Self {
+        Self(Arc::new(ScopeState {
+            hooks,
+            method,
+            path,
+            request_id: OnceLock::new(),
+            panicked: AtomicBool::new(false),
+        }))
+    }
+
+    /// Record the ID assigned to the request
+    pub(crate) fn set_request_id(&self, id: &str) {
+        let _ = self.0.request_id.set(id.to_string());
+    }
+
+    fn info(&self) -> RequestInfo {
+        RequestInfo {
+            method: self.0.method.clone(),
+            path: self.0.path.clone(),
+            request_id: self.0.request_id.get().cloned(),
+        }
+    }
+
+    /// Run the error hooks, unless the error replaces a reported panic
+    pub(crate) fn report_error(&self, err: &Error) {
+        if self.0.panicked.load(Ordering::Acquire) {
+            return;
+        }
+        let info = self.info();
+        for (i, hook) in self.0.hooks.errors.iter().enumerate() {
+            contain("error", i, || hook(&info, err));
+        }
+    }
+
+    /// Run the panic hooks
+    pub(crate) fn report_panic(&self, panic: &RecoveredPanic) {
+        self.0.panicked.store(true, Ordering::Release);
+        let info = self.info();
+        for (i, hook) in self.0.hooks.panics.iter().enumerate() {
+            contain("panic", i, || hook(&info, panic));
+        }
+    }
+}
+
+/// Run a hook, logging instead of unwinding if it panics
+fn contain(kind: &str, index: usize, hook: impl FnOnce()) {
+    if panic::catch_unwind(AssertUnwindSafe(hook)).is_err() {
+        error!("{} hook {} panicked", kind, index + 1);
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use parking_lot::Mutex;
+
+    fn panic_info() -> RecoveredPanic {
+        RecoveredPanic {
+            method: "GET".to_string(),
+            path: "/boom".to_string(),
+            message: "boom".to_string(),
+            location: None,
+            backtrace: None,
+        }
+    }
+
+    #[test]
+    fn test_hooks_run_in_order_and_contain_panics() {
+        let log = Arc::new(Mutex::new(Vec::new()));
+        let mut hooks = ErrorHooks::default();
+        for name in ["first", "second"] {
+            let log = log.clone();
+            hooks
+                .errors
+                .push(Arc::new(move |info: &RequestInfo, err: &Error| {
+                    log.lock().push(format!(
+                        "{} {} {}: {}",
+                        name,
+                        info.method,
+                        info.path,
+                        err.status_code()
+                    ));
+                }));
+            if name == "first" {
+                hooks
+                    .errors
+                    .push(Arc::new(|_: &RequestInfo, _: &Error| panic!("hook failed")));
+            }
+        }
+
+        let scope = HookScope::new(Arc::new(hooks), "POST".into(), "/orders".into());
+        scope.report_error(&Error::BadRequest("no".into()));
+        assert_eq!(
+            *log.lock(),
+            ["first POST /orders: 400", "second POST /orders: 400",]
+        );
+    }
+
+    #[test]
+    fn test_errors_after_a_panic_are_not_reported_twice() {
+        let log = Arc::new(Mutex::new(Vec::new()));
+        let mut hooks = ErrorHooks::default();
+        let errors = log.clone();
+        hooks
+            .errors
+            .push(Arc::new(move |_: &RequestInfo, err: &Error| {
+                errors.lock().push(format!("error: {}", err.status_code()));
+            }));
+        let panics = log.clone();
+        hooks.panics.push(Arc::new(
+            move |info: &RequestInfo, panic: &RecoveredPanic| {
+                panics.lock().push(format!(
+                    "panic: {} ({})",
+                    panic.message,
+                    info.request_id.as_deref().unwrap_or("-")
+                ));
+            },
+        ));
+
+        let scope = HookScope::new(Arc::new(hooks), "GET".into(), "/boom".into());
+        scope.set_request_id("req-1");
+        scope.report_panic(&panic_info());
+        scope.report_error(&Error::Internal("request handler panicked".into()));
+        assert_eq!(*log.lock(), ["panic: boom (req-1)"]);
+    }
+}
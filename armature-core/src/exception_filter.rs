@@ -765,9 +765,13 @@ fn get_error_type_name(error: &Error) -> &'static str {
         Error::Serialization(_) => "Serialization",
         Error::Deserialization(_) => "Deserialization",
         Error::Validation(_) => "Validation",
+        Error::ValidationFailed(_) => "ValidationFailed",
         Error::Internal(_) => "Internal",
         Error::Forbidden(_) => "Forbidden",
         Error::Io(_) => "Io",
+        Error::ShutdownTimeout(_) => "ShutdownTimeout",
+        Error::Status(_) => "Status",
+        Error::Other(_) => "Other",
         Error::BadRequest(_) => "BadRequest",
         Error::Unauthorized(_) => "Unauthorized",
         Error::PaymentRequired(_) => "PaymentRequired",
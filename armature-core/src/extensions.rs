@@ -134,6 +134,31 @@ impl Extensions {
             .and_then(|arc| arc.clone().downcast::<T>().ok())
     }
 
+    /// Get a mutable reference to a typed value, inserting the default if
+    /// there is none.
+    ///
+    /// A value shared with a clone of this container is cloned first, so
+    /// the clone doesn't see the change.
+    pub(crate) fn get_mut_or_default<T>(&mut self) -> &mut T
+    where
+        T: Clone + Default + Send + Sync + 'static,
+    {
+        let arc = self
+            .map
+            .entry(TypeId::of::<T>())
+            .or_insert_with(|| Arc::new(T::default()));
+        if Arc::get_mut(arc).is_none() {
+            let value = arc
+                .downcast_ref::<T>()
+                .expect("extension stored under another type's id")
+                .clone();
+            *arc = Arc::new(value);
+        }
+        Arc::get_mut(arc)
+            .and_then(|value| value.downcast_mut::<T>())
+            .expect("extension is uniquely owned after cloning")
+    }
+
     /// Check if a value of this type exists.
     #[inline]
     pub fn contains<T: Send + Sync + 'static>(&self) -> bool {
@@ -1,8 +1,16 @@
 //! Form processing and multipart support
 
 use crate::Error;
+use bytes::Bytes;
 use serde::de::DeserializeOwned;
 use std::collections::HashMap;
+use std::path::{Path, PathBuf};
+use std::sync::Arc;
+
+/// Default amount of uploaded file data kept in memory per request
+///
+/// Files beyond this budget are written to temporary files.
+pub const DEFAULT_MULTIPART_MEMORY: usize = 32 * 1024 * 1024;
 
 /// Parse URL-encoded form data
 pub fn parse_form<T: DeserializeOwned>(body: &[u8]) -> Result<T, Error> {
@@ -177,116 +185,485 @@ impl MultipartParser {
         Ok(Self { boundary })
     }
 
+    /// The boundary separating parts
+    pub fn boundary(&self) -> &str {
+        &self.boundary
+    }
+
+    /// The first text field called `name`, without copying any file parts
+    pub(crate) fn text_field(&self, body: &[u8], name: &str) -> Option<String> {
+        parse_parts(body, &self.boundary)
+            .ok()?
+            .into_iter()
+            .find(|part| part.filename.is_none() && part.name == name)
+            .and_then(|part| String::from_utf8(part.data.to_vec()).ok())
+    }
+
     /// Parse multipart form data
     pub fn parse(&self, body: &[u8]) -> Result<Vec<FormField>, Error> {
-        let mut fields = Vec::new();
-        let boundary_marker = format!("--{}", self.boundary);
-        let body_str = String::from_utf8_lossy(body);
+        let fields = parse_parts(body, &self.boundary)?
+            .into_iter()
+            .map(|part| match part.filename {
+                Some(filename) => FormField {
+                    name: part.name,
+                    value: None,
+                    file: Some(FormFile::new(
+                        filename,
+                        part.content_type,
+                        part.data.to_vec(),
+                    )),
+                },
+                None => FormField {
+                    name: part.name,
+                    value: Some(String::from_utf8_lossy(part.data).into_owned()),
+                    file: None,
+                },
+            })
+            .collect();
 
-        // Split by boundary
-        let parts: Vec<&str> = body_str.split(&boundary_marker).collect();
+        Ok(fields)
+    }
 
-        for part in parts.iter().skip(1) {
-            if part.trim() == "--" || part.trim().is_empty() {
-                continue;
-            }
+    /// Convert parsed fields to HashMap
+    pub fn to_map(fields: Vec<FormField>) -> HashMap<String, String> {
+        fields
+            .into_iter()
+            .filter_map(|field| field.value.map(|value| (field.name, value)))
+            .collect()
+    }
 
-            // Parse each part
-            if let Some(field) = self.parse_part(part)? {
-                fields.push(field);
-            }
+    /// Get files from parsed fields
+    pub fn get_files(fields: &[FormField]) -> Vec<(String, &FormFile)> {
+        fields
+            .iter()
+            .filter_map(|field| field.file.as_ref().map(|file| (field.name.clone(), file)))
+            .collect()
+    }
+}
+
+/// Error from reading a multipart form
+#[derive(Debug, thiserror::Error)]
+pub enum MultipartError {
+    /// The request is not `multipart/form-data` or has no boundary
+    #[error("request is not multipart: {0}")]
+    NotMultipart(String),
+
+    /// The body does not follow the multipart format
+    #[error("malformed multipart body: {0}")]
+    Malformed(String),
+
+    /// The form has no field with this name
+    #[error("missing multipart field `{0}`")]
+    MissingField(String),
+
+    /// An upload could not be written to or read from disk
+    #[error("failed to store uploaded file: {0}")]
+    Io(#[from] std::io::Error),
+}
+
+impl From<MultipartError> for Error {
+    fn from(err: MultipartError) -> Self {
+        match err {
+            MultipartError::Io(err) => Error::Io(err),
+            err => Error::BadRequest(err.to_string()),
         }
+    }
+}
 
-        Ok(fields)
+/// A parsed `multipart/form-data` body
+///
+/// Text fields are kept as strings. Files are copied out of the body and
+/// kept in memory until the request's memory budget runs out, after which
+/// they are written to temporary files, so the form never holds more file
+/// data in memory than the budget. Temporary files are removed when the form and every
+/// [`MultipartFile`] taken from it are dropped, which for a form read with
+/// [`HttpRequest::multipart_form`](crate::HttpRequest::multipart_form) is
+/// when the request finishes.
+#[derive(Debug, Clone, Default)]
+pub struct MultipartForm {
+    values: HashMap<String, Vec<String>>,
+    files: HashMap<String, Vec<MultipartFile>>,
+}
+
+impl MultipartForm {
+    /// Parse a multipart body, keeping at most `max_memory` bytes of file
+    /// data in memory
+    ///
+    /// `body` itself is already in memory; `max_memory` limits the copies of
+    /// the files kept after it is dropped.
+    pub async fn parse(
+        body: &[u8],
+        boundary: &str,
+        max_memory: usize,
+    ) -> Result<Self, MultipartError> {
+        let mut form = Self::default();
+        let mut memory_left = max_memory;
+
+        for part in parse_parts(body, boundary)? {
+            let Some(filename) = part.filename else {
+                let value = String::from_utf8(part.data.to_vec()).map_err(|_| {
+                    MultipartError::Malformed(format!("field `{}` is not valid UTF-8", part.name))
+                })?;
+                form.values.entry(part.name).or_default().push(value);
+                continue;
+            };
+
+            let size = part.data.len();
+            // Copy rather than slice, so a kept file doesn't pin the body
+            let content = if size <= memory_left {
+                memory_left -= size;
+                FileContent::Memory(Bytes::copy_from_slice(part.data))
+            } else {
+                FileContent::Temp(Arc::new(TempFile::write(part.data).await?))
+            };
+
+            form.files
+                .entry(part.name)
+                .or_default()
+                .push(MultipartFile {
+                    filename: base_filename(&filename),
+                    content_type: part.content_type,
+                    size,
+                    headers: part.headers,
+                    content,
+                });
+        }
+
+        Ok(form)
     }
 
-    /// Parse a single multipart part
-    fn parse_part(&self, part: &str) -> Result<Option<FormField>, Error> {
-        let lines: Vec<&str> = part.lines().collect();
+    /// First value of a text field
+    pub fn value(&self, name: &str) -> Option<&str> {
+        self.values(name).first().map(String::as_str)
+    }
+
+    /// All values of a text field, in the order they were sent
+    pub fn values(&self, name: &str) -> &[String] {
+        self.values.get(name).map(Vec::as_slice).unwrap_or_default()
+    }
+
+    /// First file uploaded under a field
+    pub fn file(&self, name: &str) -> Option<&MultipartFile> {
+        self.files(name).first()
+    }
+
+    /// All files uploaded under a field, in the order they were sent
+    pub fn files(&self, name: &str) -> &[MultipartFile] {
+        self.files.get(name).map(Vec::as_slice).unwrap_or_default()
+    }
+
+    /// Names of the text fields
+    pub fn value_names(&self) -> impl Iterator<Item = &str> {
+        self.values.keys().map(String::as_str)
+    }
+
+    /// Names of the file fields
+    pub fn file_names(&self) -> impl Iterator<Item = &str> {
+        self.files.keys().map(String::as_str)
+    }
+}
+
+/// A file from a [`MultipartForm`]
+///
+/// Cloning is cheap; clones share the same memory or temporary file.
+#[derive(Debug, Clone)]
+pub struct MultipartFile {
+    /// Filename sent by the client, without any directory components or
+    /// control characters; empty if nothing is left, such as for `..`
+    ///
+    /// Still chosen by the client, so don't use it as a path on its own.
+    pub filename: String,
+
+    /// Content type (MIME type)
+    pub content_type: String,
+
+    /// File size in bytes
+    pub size: usize,
 
-        if lines.is_empty() {
-            return Ok(None);
+    /// Part headers, with lowercase names
+    pub headers: HashMap<String, String>,
+
+    content: FileContent,
+}
+
+#[derive(Debug, Clone)]
+enum FileContent {
+    Memory(Bytes),
+    Temp(Arc<TempFile>),
+}
+
+impl MultipartFile {
+    /// Get file extension
+    pub fn extension(&self) -> Option<&str> {
+        self.filename.rsplit_once('.').map(|(_, ext)| ext)
+    }
+
+    /// Whether the contents are held in memory rather than a temporary file
+    pub fn is_in_memory(&self) -> bool {
+        matches!(self.content, FileContent::Memory(_))
+    }
+
+    /// Path of the temporary file holding the contents, if spilled to disk
+    pub fn temp_path(&self) -> Option<&Path> {
+        match &self.content {
+            FileContent::Memory(_) => None,
+            FileContent::Temp(file) => Some(&file.path),
         }
+    }
 
-        // Parse headers
-        let mut name = None;
-        let mut filename = None;
-        let mut content_type = None;
-        let mut content_start = 0;
+    /// Read the whole file
+    pub async fn bytes(&self) -> Result<Bytes, MultipartError> {
+        match &self.content {
+            FileContent::Memory(data) => Ok(data.clone()),
+            FileContent::Temp(file) => Ok(tokio::fs::read(&file.path).await?.into()),
+        }
+    }
 
-        for (i, line) in lines.iter().enumerate() {
-            if line.trim().is_empty() {
-                content_start = i + 1;
-                break;
+    /// Save the file to `dst`, replacing any existing file
+    pub async fn save_to(&self, dst: impl AsRef<Path>) -> Result<(), MultipartError> {
+        match &self.content {
+            FileContent::Memory(data) => tokio::fs::write(dst, data).await?,
+            FileContent::Temp(file) => {
+                tokio::fs::copy(&file.path, dst).await?;
             }
+        }
+        Ok(())
+    }
+}
 
-            if line.starts_with("Content-Disposition:") {
-                // Parse name and filename
-                for attr in line.split(';') {
-                    let attr = attr.trim();
-                    if attr.starts_with("name=") {
-                        name = Some(
-                            attr.trim_start_matches("name=")
-                                .trim_matches('"')
-                                .to_string(),
-                        );
-                    } else if attr.starts_with("filename=") {
-                        filename = Some(
-                            attr.trim_start_matches("filename=")
-                                .trim_matches('"')
-                                .to_string(),
-                        );
-                    }
-                }
-            } else if line.starts_with("Content-Type:") {
-                content_type = Some(line.trim_start_matches("Content-Type:").trim().to_string());
-            }
+/// A temporary upload, deleted on drop
+#[derive(Debug)]
+struct TempFile {
+    path: PathBuf,
+}
+
+impl TempFile {
+    async fn write(data: &[u8]) -> std::io::Result<Self> {
+        use tokio::io::AsyncWriteExt;
+
+        let path = std::env::temp_dir().join(format!("armature-upload-{}", uuid::Uuid::new_v4()));
+        let mut options = tokio::fs::OpenOptions::new();
+        options.write(true).create_new(true);
+        #[cfg(unix)]
+        options.mode(0o600);
+        let mut file = options.open(&path).await?;
+
+        // Delete the file even if writing it fails
+        let temp = Self { path };
+        file.write_all(data).await?;
+        file.flush().await?;
+        Ok(temp)
+    }
+}
+
+impl Drop for TempFile {
+    fn drop(&mut self) {
+        let _ = std::fs::remove_file(&self.path);
+    }
+}
+
+/// Strip any directory components and control characters a client put in
+/// a filename
+///
+/// `.` and `..` become an empty name, so the result never points outside
+/// the directory it is joined to.
+fn base_filename(filename: &str) -> String {
+    let name: String = filename
+        .rsplit(['/', '\\'])
+        .next()
+        .unwrap_or_default()
+        .chars()
+        .filter(|c| !c.is_control())
+        .collect();
+    match name.as_str() {
+        "." | ".." => String::new(),
+        _ => name,
+    }
+}
+
+/// One part of a multipart body
+struct Part<'a> {
+    name: String,
+    filename: Option<String>,
+    content_type: String,
+    headers: HashMap<String, String>,
+    data: &'a [u8],
+}
+
+/// Split a multipart body into its parts
+///
+/// Part contents are slices of `body`, so nothing is copied.
+fn parse_parts<'a>(body: &'a [u8], boundary: &str) -> Result<Vec<Part<'a>>, MultipartError> {
+    let delimiter = format!("--{}", boundary);
+    let delimiter = delimiter.as_bytes();
+    let malformed = |message: &str| MultipartError::Malformed(message.to_string());
+
+    // Skip the preamble up to the first delimiter
+    let mut pos = if body.starts_with(delimiter) {
+        0
+    } else {
+        let opening = [b"\n".as_slice(), delimiter].concat();
+        memchr::memmem::find(body, &opening)
+            .map(|i| i + 1)
+            .ok_or_else(|| malformed("missing opening boundary"))?
+    };
+
+    let mut parts = Vec::new();
+    loop {
+        pos += delimiter.len();
+        let rest = &body[pos..];
+        if rest.starts_with(b"--") {
+            return Ok(parts);
+        }
+
+        // Transport padding, then the line break ending the delimiter line
+        let line_end = memchr::memchr(b'\n', rest).ok_or_else(|| malformed("truncated part"))?;
+        if !rest[..line_end]
+            .iter()
+            .all(|b| matches!(b, b' ' | b'\t' | b'\r'))
+        {
+            return Err(malformed("unexpected data after boundary"));
         }
+        pos += line_end + 1;
+
+        // Headers run until the first empty line
+        let rest = &body[pos..];
+        let (header_len, content_start) =
+            split_headers(rest).ok_or_else(|| malformed("part headers never end"))?;
+        let headers = parse_part_headers(&rest[..header_len])?;
+        pos += content_start;
+
+        // Contents run until the line break before the next delimiter
+        let rest = &body[pos..];
+        let end = memchr::memmem::find_iter(rest, delimiter)
+            .find(|&i| i > 0 && rest[i - 1] == b'\n')
+            .ok_or_else(|| malformed("missing closing boundary"))?;
+        let content_end = if end >= 2 && rest[end - 2] == b'\r' {
+            end - 2
+        } else {
+            end - 1
+        };
+        let data = &body[pos..pos + content_end];
+        pos += end;
+
+        let disposition = headers
+            .get("content-disposition")
+            .ok_or_else(|| malformed("part without Content-Disposition"))?;
+        let params = disposition_params(disposition);
+        let name = params
+            .get("name")
+            .cloned()
+            .ok_or_else(|| malformed("part without a field name"))?;
+        let filename = params
+            .get("filename*")
+            .and_then(|value| decode_ext_value(value))
+            .or_else(|| params.get("filename").cloned());
+        let content_type = headers
+            .get("content-type")
+            .cloned()
+            .unwrap_or_else(|| "application/octet-stream".to_string());
+
+        parts.push(Part {
+            name,
+            filename,
+            content_type,
+            headers,
+            data,
+        });
+    }
+}
 
-        let name = name.ok_or_else(|| Error::BadRequest("Missing field name".to_string()))?;
-
-        // Get content
-        let content_lines = &lines[content_start..];
-        let content = content_lines.join("\n").trim().to_string();
-
-        // Create field
-        if let Some(filename) = filename {
-            // File field
-            let file = FormFile::new(
-                filename,
-                content_type.unwrap_or_else(|| "application/octet-stream".to_string()),
-                content.into_bytes(),
-            );
-            Ok(Some(FormField {
-                name,
-                value: None,
-                file: Some(file),
-            }))
+/// Find the empty line ending a part's headers
+///
+/// Returns the length of the header block and where the contents start.
+fn split_headers(rest: &[u8]) -> Option<(usize, usize)> {
+    let blank_line_at = |i: usize| {
+        if rest[i..].starts_with(b"\r\n") {
+            Some(i + 2)
+        } else if rest[i..].starts_with(b"\n") {
+            Some(i + 1)
         } else {
-            // Text field
-            Ok(Some(FormField {
-                name,
-                value: Some(content),
-                file: None,
-            }))
+            None
         }
+    };
+
+    // A part with no headers starts with the empty line
+    if let Some(start) = blank_line_at(0) {
+        return Some((0, start));
     }
+    memchr::memchr_iter(b'\n', rest).find_map(|i| blank_line_at(i + 1).map(|start| (i + 1, start)))
+}
 
-    /// Convert parsed fields to HashMap
-    pub fn to_map(fields: Vec<FormField>) -> HashMap<String, String> {
-        fields
-            .into_iter()
-            .filter_map(|field| field.value.map(|value| (field.name, value)))
-            .collect()
+/// Parse part headers into a map with lowercase names
+fn parse_part_headers(block: &[u8]) -> Result<HashMap<String, String>, MultipartError> {
+    let block = std::str::from_utf8(block)
+        .map_err(|_| MultipartError::Malformed("part headers are not UTF-8".to_string()))?;
+
+    let mut headers = HashMap::new();
+    for line in block.lines().filter(|line| !line.trim().is_empty()) {
+        let (name, value) = line
+            .split_once(':')
+            .ok_or_else(|| MultipartError::Malformed(format!("invalid part header `{}`", line)))?;
+        headers.insert(name.trim().to_ascii_lowercase(), value.trim().to_string());
+    }
+    Ok(headers)
+}
+
+/// Parameters of a `Content-Disposition` value, with lowercase names
+fn disposition_params(value: &str) -> HashMap<String, String> {
+    let mut params = HashMap::new();
+    let mut chars = value.chars().peekable();
+
+    // Skip the disposition type
+    for c in chars.by_ref() {
+        if c == ';' {
+            break;
+        }
     }
 
-    /// Get files from parsed fields
-    pub fn get_files(fields: &[FormField]) -> Vec<(String, &FormFile)> {
-        fields
-            .iter()
-            .filter_map(|field| field.file.as_ref().map(|file| (field.name.clone(), file)))
-            .collect()
+    loop {
+        let name: String = chars.by_ref().take_while(|&c| c != '=').collect();
+        let name = name.trim().to_ascii_lowercase();
+        if name.is_empty() {
+            return params;
+        }
+
+        while chars.next_if(|c| c.is_whitespace()).is_some() {}
+        let mut value = String::new();
+        if chars.next_if_eq(&'"').is_some() {
+            while let Some(c) = chars.next() {
+                match c {
+                    '"' => break,
+                    '\\' => value.extend(chars.next()),
+                    c => value.push(c),
+                }
+            }
+            for c in chars.by_ref() {
+                if c == ';' {
+                    break;
+                }
+            }
+        } else {
+            value = chars.by_ref().take_while(|&c| c != ';').collect();
+            value = value.trim().to_string();
+        }
+
+        params.insert(name, value);
+    }
+}
+
+/// Decode an RFC 5987 `charset'language'value` parameter
+fn decode_ext_value(value: &str) -> Option<String> {
+    let mut fields = value.splitn(3, '\'');
+    let charset = fields.next()?;
+    let _language = fields.next()?;
+    let encoded = fields.next()?;
+    if !charset.eq_ignore_ascii_case("utf-8") {
+        return None;
     }
+    urlencoding::decode(encoded)
+        .ok()
+        .map(|value| value.into_owned())
 }
 
 #[cfg(test)]
@@ -350,4 +727,201 @@ mod tests {
 
         assert!(result.is_err());
     }
+
+    const BOUNDARY: &str = "X-BOUNDARY";
+
+    /// Build a multipart body from `(name, filename, contents)` parts
+    fn multipart_body(parts: &[(&str, Option<&str>, &[u8])]) -> Vec<u8> {
+        let mut body = b"preamble to ignore\r\n".to_vec();
+        for (name, filename, contents) in parts {
+            body.extend_from_slice(format!("--{}\r\n", BOUNDARY).as_bytes());
+            match filename {
+                Some(filename) => body.extend_from_slice(
+                    format!(
+                        "Content-Disposition: form-data; name=\"{}\"; filename=\"{}\"\r\n\
+                         Content-Type: application/octet-stream\r\n\r\n",
+                        name, filename
+                    )
+                    .as_bytes(),
+                ),
+                None => body.extend_from_slice(
+                    format!("Content-Disposition: form-data; name=\"{}\"\r\n\r\n", name).as_bytes(),
+                ),
+            }
+            body.extend_from_slice(contents);
+            body.extend_from_slice(b"\r\n");
+        }
+        body.extend_from_slice(format!("--{}--\r\n", BOUNDARY).as_bytes());
+        body
+    }
+
+    fn multipart_request(body: Vec<u8>) -> crate::HttpRequest {
+        let mut req = crate::HttpRequest::new("POST".to_string(), "/upload".to_string());
+        req.headers.insert(
+            "Content-Type".to_string(),
+            format!("multipart/form-data; boundary={}", BOUNDARY),
+        );
+        req.body = body;
+        req
+    }
+
+    #[test]
+    fn test_multipart_parser_keeps_binary_contents() {
+        let image = b"\x89PNG\r\n\x1a\n\0\xff  trailing space ";
+        let body = multipart_body(&[
+            ("title", None, b"  padded  ".as_slice()),
+            ("image", Some("a.png"), image.as_slice()),
+        ]);
+        let parser = MultipartParser::from_content_type(&format!(
+            "multipart/form-data; boundary={}",
+            BOUNDARY
+        ))
+        .unwrap();
+
+        let fields = parser.parse(&body).unwrap();
+        assert_eq!(fields.len(), 2);
+        assert_eq!(fields[0].value.as_deref(), Some("  padded  "));
+        let file = fields[1].file.as_ref().unwrap();
+        assert_eq!(file.filename, "a.png");
+        assert_eq!(file.data, image);
+    }
+
+    #[test]
+    fn test_multipart_parser_rejects_malformed_body() {
+        let parser = MultipartParser::from_content_type("multipart/form-data; boundary=b").unwrap();
+
+        // No closing boundary
+        let body = b"--b\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\nvalue";
+        assert!(parser.parse(body).is_err());
+
+        // No boundary at all
+        assert!(parser.parse(b"just some text").is_err());
+    }
+
+    #[test]
+    fn test_disposition_params() {
+        let params = disposition_params(
+            r#"form-data; name="field; with \"quotes\""; filename*=UTF-8''na%C3%AFve.txt"#,
+        );
+        assert_eq!(params["name"], r#"field; with "quotes""#);
+        assert_eq!(
+            decode_ext_value(&params["filename*"]).as_deref(),
+            Some("naïve.txt")
+        );
+    }
+
+    #[tokio::test]
+    async fn test_multipart_form_multiple_files_under_one_field() {
+        let req = multipart_request(multipart_body(&[
+            ("note", None, b"hello".as_slice()),
+            ("docs", Some("one.txt"), b"first".as_slice()),
+            ("docs", Some("../../etc/two.txt"), b"second".as_slice()),
+            ("tag", None, b"a".as_slice()),
+            ("tag", None, b"b".as_slice()),
+        ]));
+
+        let form = req.multipart_form().await.unwrap();
+        assert_eq!(form.value("note"), Some("hello"));
+        assert_eq!(form.values("tag"), ["a", "b"]);
+
+        let docs = form.files("docs");
+        assert_eq!(docs.len(), 2);
+        assert_eq!(docs[0].filename, "one.txt");
+        assert_eq!(docs[0].bytes().await.unwrap(), "first");
+        // Directory components from the client are dropped
+        assert_eq!(docs[1].filename, "two.txt");
+        assert_eq!(docs[1].bytes().await.unwrap(), "second");
+
+        // form_file returns the first file under the field
+        let first = req.form_file("docs").await.unwrap();
+        assert_eq!(first.filename, "one.txt");
+    }
+
+    #[test]
+    fn test_base_filename() {
+        assert_eq!(base_filename("report.pdf"), "report.pdf");
+        assert_eq!(base_filename("C:\\Users\\ann\\report.pdf"), "report.pdf");
+        assert_eq!(base_filename("a/b/.."), "");
+        assert_eq!(base_filename(".."), "");
+        assert_eq!(base_filename("."), "");
+        assert_eq!(base_filename("dir/"), "");
+        assert_eq!(base_filename("evil\0.txt\r\n"), "evil.txt");
+        assert_eq!(base_filename("..\u{7f}"), "");
+    }
+
+    #[tokio::test]
+    async fn test_form_file_missing_field() {
+        let req = multipart_request(multipart_body(&[("note", None, b"hi".as_slice())]));
+
+        // Text fields are not files
+        assert!(matches!(
+            req.form_file("note").await,
+            Err(MultipartError::MissingField(name)) if name == "note"
+        ));
+        assert!(matches!(
+            req.form_file("avatar").await,
+            Err(MultipartError::MissingField(_))
+        ));
+
+        let missing: Error = MultipartError::MissingField("avatar".to_string()).into();
+        assert_eq!(missing.status_code(), 400);
+    }
+
+    #[tokio::test]
+    async fn test_multipart_form_requires_multipart_request() {
+        let mut req = crate::HttpRequest::new("POST".to_string(), "/upload".to_string());
+        assert!(matches!(
+            req.multipart_form().await,
+            Err(MultipartError::NotMultipart(_))
+        ));
+
+        req.headers
+            .insert("Content-Type".to_string(), "application/json".to_string());
+        assert!(matches!(
+            req.multipart_form().await,
+            Err(MultipartError::NotMultipart(_))
+        ));
+    }
+
+    #[tokio::test]
+    async fn test_multipart_form_spills_to_temp_files_and_cleans_up() {
+        let req = multipart_request(multipart_body(&[
+            ("files", Some("small.txt"), b"tiny".as_slice()),
+            ("files", Some("big.bin"), vec![7u8; 64].as_slice()),
+            ("files", Some("big2.bin"), vec![9u8; 64].as_slice()),
+        ]));
+
+        let form = req.parse_multipart_form(16).await.unwrap();
+        let files = form.files("files");
+        assert!(files[0].is_in_memory());
+        // In-memory files are copies, not views into the request body
+        let body = req.body_ref().as_ptr_range();
+        let kept = files[0].bytes().await.unwrap();
+        assert!(!body.contains(&kept.as_ptr()));
+        assert!(!files[1].is_in_memory());
+        assert!(!files[2].is_in_memory());
+
+        let paths: Vec<PathBuf> = files[1..]
+            .iter()
+            .map(|file| file.temp_path().unwrap().to_path_buf())
+            .collect();
+        assert!(paths.iter().all(|path| path.exists()));
+        assert_eq!(files[1].bytes().await.unwrap(), vec![7u8; 64]);
+
+        // save_to copies, so the temporary file is still cleaned up
+        let saved = std::env::temp_dir().join(format!("armature-saved-{}", uuid::Uuid::new_v4()));
+        files[2].save_to(&saved).await.unwrap();
+        assert_eq!(std::fs::read(&saved).unwrap(), vec![9u8; 64]);
+        std::fs::remove_file(&saved).unwrap();
+
+        // A file taken from the form keeps its temporary file alive
+        let kept = files[1].clone();
+        drop(form);
+        drop(req);
+        assert!(paths[0].exists());
+        assert!(!paths[1].exists());
+
+        drop(kept);
+        assert!(!paths[0].exists());
+    }
 }
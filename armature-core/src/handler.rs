@@ -110,6 +110,10 @@ where
 /// as optimized as possible via vtable dispatch with inlined inner handlers.
 pub struct BoxedHandler {
     inner: Arc<dyn ErasedHandler>,
+    /// Type name of the function or closure behind the handler
+    name: &'static str,
+    /// Whether a `GET` route with this handler also answers `HEAD`
+    auto_head: bool,
 }
 
 impl BoxedHandler {
@@ -123,9 +127,43 @@ impl BoxedHandler {
                 handler,
                 _marker: PhantomData,
             }),
+            name: std::any::type_name::<H>(),
+            auto_head: true,
         }
     }
 
+    /// Type name of the function or closure handling requests, such as
+    /// `my_app::users::show`, as reported by [`std::any::type_name`].
+    ///
+    /// Meant for diagnostics like
+    /// [`Router::registered_routes`](crate::Router::registered_routes); the
+    /// exact text is not stable across compiler versions.
+    #[inline]
+    pub fn name(&self) -> &'static str {
+        self.name
+    }
+
+    /// Report `name` as the handler's name
+    #[inline]
+    pub(crate) fn with_name(mut self, name: &'static str) -> Self {
+        self.name = name;
+        self
+    }
+
+    /// Set whether a `GET` route with this handler answers `HEAD`; see
+    /// [`Route::without_auto_head`](crate::Route::without_auto_head)
+    #[inline]
+    pub(crate) fn with_auto_head(mut self, enabled: bool) -> Self {
+        self.auto_head = enabled;
+        self
+    }
+
+    /// Whether a `GET` route with this handler answers `HEAD`
+    #[inline]
+    pub(crate) fn auto_head(&self) -> bool {
+        self.auto_head
+    }
+
     /// Call the handler.
     ///
     /// This goes through a vtable but the actual handler implementation
@@ -145,6 +183,8 @@ impl Clone for BoxedHandler {
     fn clone(&self) -> Self {
         Self {
             inner: self.inner.clone(),
+            name: self.name,
+            auto_head: self.auto_head,
         }
     }
 }
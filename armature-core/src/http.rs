@@ -2,6 +2,8 @@
 
 use crate::body::RequestBody;
 use crate::extensions::Extensions;
+use crate::route_params::ParamError;
+use crate::streaming::{ByteStream, HyperBody, StreamWriter};
 use bytes::Bytes;
 use serde::{Deserialize, Serialize};
 use std::collections::HashMap;
@@ -31,6 +33,11 @@ pub struct HttpRequest {
     /// Optional zero-copy body storage using Bytes.
     /// When set, this takes precedence over `body` for read operations.
     body_bytes: Option<Bytes>,
+    /// Raw query string without the leading `?`, if the request had one
+    query_string: Option<String>,
+    /// Multipart form parsed on first use; temporary files live as long as
+    /// the request
+    multipart_form: tokio::sync::OnceCell<Arc<crate::form::MultipartForm>>,
 }
 
 impl HttpRequest {
@@ -44,9 +51,22 @@ impl HttpRequest {
             query_params: HashMap::new(),
             extensions: Extensions::new(),
             body_bytes: None,
+            query_string: None,
+            multipart_form: tokio::sync::OnceCell::new(),
         }
     }
 
+    /// A copy of the method, path, headers and parameters, without the body
+    /// or extensions.
+    pub(crate) fn head(&self) -> Self {
+        let mut head = Self::new(self.method.clone(), self.path.clone());
+        head.headers = self.headers.clone();
+        head.path_params = self.path_params.clone();
+        head.query_params = self.query_params.clone();
+        head.query_string = self.query_string.clone();
+        head
+    }
+
     /// Create a new request with pre-allocated extensions capacity.
     #[inline]
     pub fn with_extensions_capacity(method: String, path: String, capacity: usize) -> Self {
@@ -59,6 +79,8 @@ impl HttpRequest {
             query_params: HashMap::new(),
             extensions: Extensions::with_capacity(capacity),
             body_bytes: None,
+            query_string: None,
+            multipart_form: tokio::sync::OnceCell::new(),
         }
     }
 
@@ -77,6 +99,8 @@ impl HttpRequest {
             query_params: HashMap::new(),
             extensions: Extensions::new(),
             body_bytes: Some(body),
+            query_string: None,
+            multipart_form: tokio::sync::OnceCell::new(),
         }
     }
 
@@ -153,6 +177,8 @@ impl HttpRequest {
             query_params,
             extensions: Extensions::new(),
             body_bytes: None,
+            query_string: None,
+            multipart_form: tokio::sync::OnceCell::new(),
         }
     }
 
@@ -193,6 +219,27 @@ impl HttpRequest {
         self.extensions.get_arc::<T>()
     }
 
+    /// Get a header value by name, ignoring ASCII case.
+    ///
+    /// # Example
+    ///
+    /// ```
+    /// use armature_core::HttpRequest;
+    ///
+    /// let mut req = HttpRequest::new("GET".into(), "/".into());
+    /// req.headers.insert("content-type".into(), "application/json".into());
+    /// assert_eq!(req.header("Content-Type"), Some("application/json"));
+    /// ```
+    pub fn header(&self, name: &str) -> Option<&str> {
+        if let Some(value) = self.headers.get(name) {
+            return Some(value);
+        }
+        self.headers
+            .iter()
+            .find(|(key, _)| key.eq_ignore_ascii_case(name))
+            .map(|(_, value)| value.as_str())
+    }
+
     /// Parse the request body as JSON.
     ///
     /// With the `simd-json` feature enabled, this uses SIMD-accelerated parsing
@@ -231,15 +278,189 @@ impl HttpRequest {
         parser.parse(self.body_ref())
     }
 
+    /// The request's `multipart/form-data` body
+    ///
+    /// Parsed on first use with up to
+    /// [`DEFAULT_MULTIPART_MEMORY`](crate::form::DEFAULT_MULTIPART_MEMORY)
+    /// bytes of files kept in memory; see
+    /// [`parse_multipart_form`](Self::parse_multipart_form).
+    ///
+    /// # Example
+    ///
+    /// ```no_run
+    /// # use armature_core::{Error, HttpRequest, HttpResponse};
+    /// async fn upload(req: HttpRequest) -> Result<HttpResponse, Error> {
+    ///     let form = req.multipart_form().await?;
+    ///     for file in form.files("attachments") {
+    ///         // The filename comes from the client; don't build paths from it
+    ///         file.save_to(format!("uploads/{}", uuid::Uuid::new_v4())).await?;
+    ///     }
+    ///     Ok(HttpResponse::no_content())
+    /// }
+    /// ```
+    pub async fn multipart_form(
+        &self,
+    ) -> Result<Arc<crate::form::MultipartForm>, crate::form::MultipartError> {
+        self.parse_multipart_form(crate::form::DEFAULT_MULTIPART_MEMORY)
+            .await
+    }
+
+    /// Parse the `multipart/form-data` body, keeping at most `max_memory`
+    /// bytes of files in memory
+    ///
+    /// Files that don't fit are written to temporary files, which are
+    /// removed once the request and any [`MultipartFile`](crate::form::MultipartFile)
+    /// taken from it are dropped. The form is parsed once; later calls
+    /// return the same form whatever `max_memory` they pass.
+    ///
+    /// The body is already buffered in the request, so `max_memory` only
+    /// limits the copies of the files; cap the body size itself with
+    /// [`BodyLimitMiddleware`](crate::BodyLimitMiddleware).
+    pub async fn parse_multipart_form(
+        &self,
+        max_memory: usize,
+    ) -> Result<Arc<crate::form::MultipartForm>, crate::form::MultipartError> {
+        use crate::form::MultipartError;
+
+        self.multipart_form
+            .get_or_try_init(|| async {
+                let content_type = self.header("content-type").ok_or_else(|| {
+                    MultipartError::NotMultipart("missing Content-Type header".to_string())
+                })?;
+                let essence = content_type.split(';').next().unwrap_or_default().trim();
+                if !essence.eq_ignore_ascii_case("multipart/form-data") {
+                    return Err(MultipartError::NotMultipart(format!(
+                        "Content-Type is {}",
+                        essence
+                    )));
+                }
+                let parser = crate::form::MultipartParser::from_content_type(content_type)
+                    .map_err(|_| MultipartError::NotMultipart("missing boundary".to_string()))?;
+
+                let form = crate::form::MultipartForm::parse(
+                    self.body_ref(),
+                    parser.boundary(),
+                    max_memory,
+                )
+                .await?;
+                Ok(Arc::new(form))
+            })
+            .await
+            .cloned()
+    }
+
+    /// The first file uploaded under a multipart field
+    ///
+    /// Returns [`MultipartError::MissingField`](crate::form::MultipartError::MissingField)
+    /// if no file was sent under `name`; use [`multipart_form`](Self::multipart_form)
+    /// and [`MultipartForm::files`](crate::form::MultipartForm::files) to read
+    /// every file under a field.
+    pub async fn form_file(
+        &self,
+        name: &str,
+    ) -> Result<crate::form::MultipartFile, crate::form::MultipartError> {
+        self.multipart_form()
+            .await?
+            .file(name)
+            .cloned()
+            .ok_or_else(|| crate::form::MultipartError::MissingField(name.to_string()))
+    }
+
     /// Get a path parameter by name
     pub fn param(&self, name: &str) -> Option<&String> {
         self.path_params.get(name)
     }
 
+    /// Get a path parameter parsed into any `FromStr` type.
+    ///
+    /// Returns [`ParamError::NotFound`] if the route did not capture `name`,
+    /// and [`ParamError::Invalid`] if the value is present but fails to parse.
+    ///
+    /// # Example
+    ///
+    /// ```
+    /// use armature_core::HttpRequest;
+    ///
+    /// let mut req = HttpRequest::new("GET".to_string(), "/items/7".to_string());
+    /// req.path_params.insert("id".to_string(), "7".to_string());
+    ///
+    /// let id: u8 = req.param_as("id").unwrap();
+    /// assert_eq!(id, 7);
+    /// assert!(req.param_as::<u8>("missing").unwrap_err().is_not_found());
+    /// ```
+    pub fn param_as<T>(&self, name: &str) -> Result<T, ParamError>
+    where
+        T: std::str::FromStr,
+        T::Err: std::fmt::Display,
+    {
+        let value = self
+            .path_params
+            .get(name)
+            .ok_or_else(|| ParamError::NotFound(name.to_string()))?;
+
+        value.parse().map_err(|e: T::Err| ParamError::Invalid {
+            name: name.to_string(),
+            value: value.clone(),
+            reason: e.to_string(),
+        })
+    }
+
+    /// Get a path parameter as an `i32`.
+    ///
+    /// Values outside the `i32` range return [`ParamError::Invalid`]
+    /// rather than wrapping or panicking.
+    #[inline]
+    pub fn param_i32(&self, name: &str) -> Result<i32, ParamError> {
+        self.param_as(name)
+    }
+
+    /// Get a path parameter as an `i64`.
+    ///
+    /// # Example
+    ///
+    /// ```
+    /// use armature_core::{HttpRequest, ParamError};
+    ///
+    /// let mut req = HttpRequest::new("GET".to_string(), "/users/42".to_string());
+    /// req.path_params.insert("id".to_string(), "42".to_string());
+    ///
+    /// assert_eq!(req.param_i64("id"), Ok(42));
+    /// assert_eq!(
+    ///     req.param_i64("user_id"),
+    ///     Err(ParamError::NotFound("user_id".to_string()))
+    /// );
+    /// ```
+    #[inline]
+    pub fn param_i64(&self, name: &str) -> Result<i64, ParamError> {
+        self.param_as(name)
+    }
+
+    /// Get a path parameter as a UUID (hyphenated, simple, URN or braced form).
+    #[inline]
+    pub fn param_uuid(&self, name: &str) -> Result<uuid::Uuid, ParamError> {
+        self.param_as(name)
+    }
+
     /// Get a query parameter by name
     pub fn query(&self, name: &str) -> Option<&String> {
         self.query_params.get(name)
     }
+
+    /// The raw query string, without the leading `?` and not decoded.
+    ///
+    /// `None` if the request had no query string. Unlike
+    /// [`query_params`](Self::query_params) this keeps repeated keys.
+    pub fn query_string(&self) -> Option<&str> {
+        self.query_string.as_deref()
+    }
+
+    /// Set the query string and re-parse [`query_params`](Self::query_params)
+    /// from it.
+    pub fn set_query_string(&mut self, query: impl Into<String>) {
+        let query = query.into();
+        self.query_params = crate::routing::parse_query_string(&query);
+        self.query_string = Some(query);
+    }
 }
 
 /// Lazy-initialized HashMap that doesn't allocate until first insert.
@@ -389,6 +610,11 @@ pub struct HttpResponse {
     /// Optional zero-copy body storage using Bytes.
     /// When set, this takes precedence over `body`.
     body_bytes: Option<Bytes>,
+    /// Optional streaming body. When set, this takes precedence over both
+    /// `body` and `body_bytes` once the response is sent.
+    stream: Option<ByteStream>,
+    /// Cookies sent as separate `Set-Cookie` headers.
+    cookies: Vec<crate::cookie::Cookie>,
 }
 
 /// Default pre-allocated response buffer size (512 bytes).
@@ -407,6 +633,8 @@ impl HttpResponse {
             headers: LazyHeaders::new(),
             body: Vec::new(),
             body_bytes: None,
+            stream: None,
+            cookies: Vec::new(),
         }
     }
 
@@ -428,6 +656,8 @@ impl HttpResponse {
             headers: LazyHeaders::with_capacity(8),
             body: Vec::with_capacity(capacity),
             body_bytes: None,
+            stream: None,
+            cookies: Vec::new(),
         }
     }
 
@@ -525,6 +755,107 @@ impl HttpResponse {
         }
     }
 
+    /// Stream the body from a writer callback.
+    ///
+    /// The callback runs on a background task and every
+    /// [`StreamWriter::write`] is sent to the client as soon as it is made.
+    /// Without a `Content-Length` header the body is sent with
+    /// `Transfer-Encoding: chunked`.
+    ///
+    /// If the client disconnects, writes fail with a `BrokenPipe`
+    /// [`Error::Io`](crate::Error::Io) so the callback can stop. An error
+    /// returned by the callback is logged and the body is ended at that
+    /// point; the headers have already been sent, so the status cannot
+    /// change.
+    ///
+    /// Must be called from within a Tokio runtime.
+    ///
+    /// # Example
+    ///
+    /// ```
+    /// use armature_core::HttpResponse;
+    ///
+    /// # tokio_test::block_on(async {
+    /// let response = HttpResponse::ok()
+    ///     .with_header("Content-Type".to_string(), "text/csv".to_string())
+    ///     .stream(|w| async move {
+    ///         w.write_str("id,name\n").await?;
+    ///         for id in 0..3 {
+    ///             w.write(format!("{},user{}\n", id, id)).await?;
+    ///         }
+    ///         Ok(())
+    ///     });
+    /// assert!(response.is_streaming());
+    /// # });
+    /// ```
+    pub fn stream<F, Fut>(self, f: F) -> Self
+    where
+        F: FnOnce(StreamWriter) -> Fut + Send + 'static,
+        Fut: std::future::Future<Output = Result<(), crate::Error>> + Send + 'static,
+    {
+        self.with_stream(crate::streaming::spawn_writer(f))
+    }
+
+    /// Stream the body from an async reader, such as a file.
+    ///
+    /// Read errors are logged and end the body early, like an error returned
+    /// from a [`HttpResponse::stream`] callback.
+    pub fn send_stream<R>(self, reader: R) -> Self
+    where
+        R: tokio::io::AsyncRead + Unpin + Send + 'static,
+    {
+        use tokio::io::AsyncReadExt;
+
+        self.stream(|w| async move {
+            let mut reader = reader;
+            let mut buffer = vec![0u8; 8192];
+            loop {
+                let n = reader.read(&mut buffer).await?;
+                if n == 0 {
+                    return Ok(());
+                }
+                w.write(Bytes::copy_from_slice(&buffer[..n])).await?;
+            }
+        })
+    }
+
+    /// Use an existing byte stream as the body.
+    pub fn with_stream(mut self, stream: ByteStream) -> Self {
+        self.stream = Some(stream);
+        self.body_bytes = None;
+        self.body.clear();
+        self
+    }
+
+    /// Check whether the body is streamed.
+    #[inline]
+    pub fn is_streaming(&self) -> bool {
+        self.stream.is_some()
+    }
+
+    /// Take the streaming body, leaving a buffered (empty) body in its place.
+    pub fn take_stream(&mut self) -> Option<ByteStream> {
+        self.stream.take()
+    }
+
+    /// Consume the response and convert it into a hyper response.
+    pub fn into_hyper_response(mut self) -> hyper::Response<HyperBody> {
+        let mut builder = hyper::Response::builder().status(self.status);
+        for (key, value) in &self.headers {
+            builder = builder.header(key, value);
+        }
+        for cookie in &self.cookies {
+            builder = builder.header("Set-Cookie", cookie.to_header_value());
+        }
+
+        let body = match self.stream.take() {
+            Some(stream) => crate::streaming::stream_body(stream),
+            // Zero-copy body passthrough to Hyper
+            None => crate::streaming::full_body(self.into_body_bytes()),
+        };
+        builder.body(body).unwrap()
+    }
+
     /// Get a reference to the body bytes.
     #[inline]
     pub fn body_ref(&self) -> &[u8] {
@@ -553,8 +884,11 @@ impl HttpResponse {
 
     /// Serialize a value as JSON and set it as the response body.
     ///
-    /// With the `simd-json` feature enabled, this uses SIMD-accelerated serialization
-    /// which can be 1.5-2x faster on modern x86_64 CPUs.
+    /// Serialization goes through the encoder of the router handling the
+    /// request, set with [`Router::json_encoder`](crate::Router::json_encoder),
+    /// if any. Otherwise, with the `simd-json` feature enabled, this uses
+    /// SIMD-accelerated serialization which can be 1.5-2x faster on modern
+    /// x86_64 CPUs.
     ///
     /// The body is stored as `Bytes` for zero-copy passthrough to Hyper.
     ///
@@ -566,7 +900,7 @@ impl HttpResponse {
     #[inline]
     pub fn with_json<T: Serialize>(mut self, value: &T) -> Result<Self, crate::Error> {
         let vec =
-            crate::json::to_vec(value).map_err(|e| crate::Error::Serialization(e.to_string()))?;
+            crate::json::encode(value).map_err(|e| crate::Error::Serialization(e.to_string()))?;
         self.body_bytes = Some(Bytes::from(vec));
         self.body.clear();
         self.headers
@@ -594,6 +928,8 @@ impl HttpResponse {
             headers: LazyHeaders::from(headers),
             body: Vec::new(),
             body_bytes: None,
+            stream: None,
+            cookies: Vec::new(),
         }
     }
 
@@ -607,6 +943,8 @@ impl HttpResponse {
             headers: LazyHeaders::from(headers),
             body,
             body_bytes: None,
+            stream: None,
+            cookies: Vec::new(),
         }
     }
 
@@ -688,6 +1026,51 @@ impl HttpResponse {
         Self::ok().with_json(value)
     }
 
+    /// Create a JSONP response with 200 OK status.
+    ///
+    /// The value is serialized as JSON and wrapped in a call to `callback`,
+    /// with `Content-Type: application/javascript`. The callback name must be
+    /// a plain JavaScript identifier (see
+    /// [`json::is_valid_callback`](crate::json::is_valid_callback));
+    /// anything else is rejected with `400 Bad Request`.
+    ///
+    /// # Example
+    /// ```
+    /// use armature_core::HttpResponse;
+    /// use serde_json::json;
+    ///
+    /// let response = HttpResponse::jsonp("handle", &json!({"ok": true})).unwrap();
+    /// assert_eq!(response.body_ref(), b"/**/handle({\"ok\":true});");
+    ///
+    /// assert!(HttpResponse::jsonp("alert(document.cookie)//", &json!({})).is_err());
+    /// ```
+    pub fn jsonp<T: Serialize>(callback: &str, value: &T) -> Result<Self, crate::Error> {
+        if !crate::json::is_valid_callback(callback) {
+            return Err(crate::Error::BadRequest(format!(
+                "Invalid JSONP callback name: {:?}",
+                callback
+            )));
+        }
+
+        let json =
+            crate::json::encode(value).map_err(|e| crate::Error::Serialization(e.to_string()))?;
+        // U+2028 and U+2029 are valid in JSON strings but end lines in older
+        // JavaScript engines
+        let json = String::from_utf8_lossy(&json)
+            .replace('\u{2028}', "\\u2028")
+            .replace('\u{2029}', "\\u2029");
+        // The leading comment stops the body from being read as a Flash file
+        let body = format!("/**/{}({});", callback, json);
+
+        Ok(Self::ok()
+            .with_header(
+                "Content-Type".to_string(),
+                "application/javascript".to_string(),
+            )
+            .with_header("X-Content-Type-Options".to_string(), "nosniff".to_string())
+            .with_body(body.into_bytes()))
+    }
+
     /// Create an HTML response with 200 OK status.
     ///
     /// # Example
@@ -797,6 +1180,39 @@ impl HttpResponse {
         self.with_header("Cache-Control".to_string(), directive.into())
     }
 
+    /// Add a header name to `Vary`, keeping any names already listed.
+    ///
+    /// # Example
+    /// ```
+    /// use armature_core::HttpResponse;
+    /// let mut response = HttpResponse::ok();
+    /// response.add_vary("Accept");
+    /// response.add_vary("Origin");
+    /// response.add_vary("accept");
+    /// assert_eq!(response.headers.get("Vary"), Some(&"Accept, Origin".to_string()));
+    /// ```
+    pub fn add_vary(&mut self, header: &str) {
+        let existing = self
+            .headers
+            .iter()
+            .find(|(name, _)| name.eq_ignore_ascii_case("vary"))
+            .map(|(name, value)| (name.clone(), value.clone()));
+
+        match existing {
+            None => {
+                self.headers.insert("Vary".to_string(), header.to_string());
+            }
+            Some((name, value)) => {
+                let listed = value
+                    .split(',')
+                    .any(|v| v.trim() == "*" || v.trim().eq_ignore_ascii_case(header));
+                if !listed {
+                    self.headers.insert(name, format!("{}, {}", value, header));
+                }
+            }
+        }
+    }
+
     /// Mark the response as not cacheable.
     ///
     /// # Example
@@ -822,6 +1238,18 @@ impl HttpResponse {
         )
     }
 
+    /// Add a `Set-Cookie` header for `cookie`.
+    ///
+    /// Unlike [`cookie`](Self::cookie), each call adds a separate header.
+    pub fn set_cookie(&mut self, cookie: crate::cookie::Cookie) {
+        self.cookies.push(cookie);
+    }
+
+    /// Cookies added with [`set_cookie`](Self::set_cookie).
+    pub fn cookies(&self) -> &[crate::cookie::Cookie] {
+        &self.cookies
+    }
+
     /// Get the response body as a string (lossy UTF-8 conversion).
     pub fn body_string(&self) -> String {
         String::from_utf8_lossy(self.body_ref()).to_string()
@@ -907,6 +1335,80 @@ mod tests {
         assert_eq!(req.param("name"), None);
     }
 
+    fn request_with_param(name: &str, value: &str) -> HttpRequest {
+        let mut req = HttpRequest::new("GET".to_string(), "/test".to_string());
+        req.path_params.insert(name.to_string(), value.to_string());
+        req
+    }
+
+    #[test]
+    fn test_param_i64_negative() {
+        let req = request_with_param("offset", "-15");
+        assert_eq!(req.param_i64("offset"), Ok(-15));
+        assert_eq!(req.param_i32("offset"), Ok(-15));
+    }
+
+    #[test]
+    fn test_param_i64_overflow_boundary() {
+        let req = request_with_param("id", &i64::MAX.to_string());
+        assert_eq!(req.param_i64("id"), Ok(i64::MAX));
+
+        let req = request_with_param("id", "9223372036854775808");
+        let err = req.param_i64("id").unwrap_err();
+        assert!(!err.is_not_found());
+        assert!(err.to_string().contains("too large"));
+
+        let req = request_with_param("id", "99999999999999999999");
+        assert!(matches!(
+            req.param_i64("id"),
+            Err(ParamError::Invalid { ref value, .. }) if value == "99999999999999999999"
+        ));
+    }
+
+    #[test]
+    fn test_param_i32_overflow() {
+        let req = request_with_param("id", "2147483648");
+        assert!(matches!(
+            req.param_i32("id"),
+            Err(ParamError::Invalid { .. })
+        ));
+        assert_eq!(req.param_i64("id"), Ok(2_147_483_648));
+    }
+
+    #[test]
+    fn test_param_empty_value_is_invalid() {
+        let req = request_with_param("id", "");
+        let err = req.param_i64("id").unwrap_err();
+        assert!(matches!(err, ParamError::Invalid { .. }));
+        assert_eq!(err.name(), "id");
+    }
+
+    #[test]
+    fn test_param_missing_is_not_found() {
+        let req = HttpRequest::new("GET".to_string(), "/test".to_string());
+        let err = req.param_i64("id").unwrap_err();
+        assert_eq!(err, ParamError::NotFound("id".to_string()));
+        assert!(err.is_not_found());
+
+        let err: crate::Error = err.into();
+        assert_eq!(err.status_code(), 400);
+    }
+
+    #[test]
+    fn test_param_uuid() {
+        let req = request_with_param("id", "67e55044-10b1-426f-9247-bb680e5fe0c8");
+        assert_eq!(
+            req.param_uuid("id").unwrap().to_string(),
+            "67e55044-10b1-426f-9247-bb680e5fe0c8"
+        );
+
+        let req = request_with_param("id", "not-a-uuid");
+        assert!(matches!(
+            req.param_uuid("id"),
+            Err(ParamError::Invalid { .. })
+        ));
+    }
+
     #[test]
     fn test_http_request_query() {
         let mut req = HttpRequest::new("GET".to_string(), "/users".to_string());
@@ -1018,6 +1520,42 @@ mod tests {
         assert!(!response.body_ref().is_empty());
     }
 
+    #[test]
+    fn test_jsonp() {
+        let response = HttpResponse::jsonp("render", &serde_json::json!({"n": 1})).unwrap();
+
+        assert_eq!(response.status, 200);
+        assert_eq!(response.body_ref(), br#"/**/render({"n":1});"#);
+        assert_eq!(
+            response.headers.get("Content-Type"),
+            Some(&"application/javascript".to_string())
+        );
+        assert_eq!(
+            response.headers.get("X-Content-Type-Options"),
+            Some(&"nosniff".to_string())
+        );
+    }
+
+    #[test]
+    fn test_jsonp_malicious_callback() {
+        for callback in [
+            "alert(document.cookie);cb",
+            "cb</script><script>alert(1)</script>",
+            "cb //",
+            "window.location",
+            "",
+        ] {
+            let err = HttpResponse::jsonp(callback, &1).unwrap_err();
+            assert_eq!(err.status_code(), 400, "callback {:?}", callback);
+        }
+    }
+
+    #[test]
+    fn test_jsonp_escapes_line_separators() {
+        let response = HttpResponse::jsonp("cb", &"a\u{2028}b\u{2029}c").unwrap();
+        assert_eq!(response.body_ref(), br#"/**/cb("a\u2028b\u2029c");"#);
+    }
+
     #[test]
     fn test_http_request_with_headers() {
         let mut req = HttpRequest::new("GET".to_string(), "/api".to_string());
@@ -34,6 +34,25 @@
 //! let pretty = json::to_string_pretty(&data)?;
 //! ```
 //!
+//! ## Custom Encoders
+//!
+//! Responses built with `HttpResponse::json` go through [`encode`], which
+//! uses the serializer above unless the router handling the request has a
+//! [`JsonEncoder`], set with [`Router::json_encoder`] (or
+//! `Application::with_json_encoder`):
+//!
+//! ```rust,ignore
+//! struct SonicEncoder;
+//!
+//! impl json::JsonEncoder for SonicEncoder {
+//!     fn encode(&self, value: &dyn json::erased_serde::Serialize) -> json::Result<Vec<u8>> {
+//!         sonic_rs::to_vec(value).map_err(|e| json::JsonError::new(e.to_string()))
+//!     }
+//! }
+//!
+//! router.json_encoder(SonicEncoder);
+//! ```
+//!
 //! ## Feature Flags
 //!
 //! - `simd-json`: Use SIMD-accelerated JSON parsing (requires x86_64 with AVX2)
@@ -45,7 +64,10 @@
 //! armature-core = { version = "0.1", features = ["simd-json"] }
 //! ```
 
+use crate::Router;
 use serde::{Serialize, de::DeserializeOwned};
+use std::future::Future;
+use std::sync::Arc;
 
 /// Error type for JSON operations.
 ///
@@ -55,6 +77,23 @@ pub struct JsonError {
     message: String,
 }
 
+impl JsonError {
+    /// Create an error with a message, e.g. from a custom [`JsonEncoder`].
+    pub fn new(message: impl Into<String>) -> Self {
+        JsonError {
+            message: message.into(),
+        }
+    }
+}
+
+impl From<erased_serde::Error> for JsonError {
+    fn from(err: erased_serde::Error) -> Self {
+        JsonError {
+            message: err.to_string(),
+        }
+    }
+}
+
 impl std::fmt::Display for JsonError {
     fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
         write!(f, "{}", self.message)
@@ -315,6 +354,99 @@ pub fn from_value<T: DeserializeOwned>(value: serde_json::Value) -> Result<T> {
     serde_json::from_value(value).map_err(Into::into)
 }
 
+// ============================================================================
+// Response Encoding
+// ============================================================================
+
+pub use erased_serde;
+
+/// Serializer used for JSON response bodies.
+///
+/// Implement this to plug in a different JSON library. The value is passed
+/// as an [`erased_serde::Serialize`] trait object (re-exported here), which
+/// itself implements [`serde::Serialize`] and so works with any serde-based
+/// serializer.
+pub trait JsonEncoder: Send + Sync {
+    /// Serialize a value to JSON bytes.
+    fn encode(&self, value: &dyn erased_serde::Serialize) -> Result<Vec<u8>>;
+}
+
+/// The built-in encoder, backed by [`to_vec`].
+#[derive(Debug, Clone, Copy, Default)]
+pub struct DefaultJsonEncoder;
+
+impl JsonEncoder for DefaultJsonEncoder {
+    fn encode(&self, value: &dyn erased_serde::Serialize) -> Result<Vec<u8>> {
+        to_vec(&value)
+    }
+}
+
+tokio::task_local! {
+    /// Encoder of the router handling the current request
+    static ENCODER: Arc<dyn JsonEncoder>;
+}
+
+impl Router {
+    /// Serialize JSON responses with `encoder` instead of the built-in
+    /// serializer.
+    ///
+    /// Applies to [`encode`], and so to `HttpResponse::json`, while this
+    /// router handles a request: in its handlers, middleware and error
+    /// handler, and in mounted routers that don't set their own. Responses
+    /// built outside a request, including in tasks spawned by a handler, use
+    /// the built-in serializer.
+    pub fn json_encoder(&mut self, encoder: impl JsonEncoder + 'static) -> &mut Self {
+        self.json_encoder = Some(Arc::new(encoder));
+        self
+    }
+
+    /// Run `future` with this router's encoder, if it has one
+    pub(crate) async fn with_json_encoder<F: Future>(&self, future: F) -> F::Output {
+        match &self.json_encoder {
+            Some(encoder) => ENCODER.scope(Arc::clone(encoder), future).await,
+            None => future.await,
+        }
+    }
+}
+
+/// Serialize a response body with the current router's [`JsonEncoder`].
+///
+/// Falls back to [`to_vec`] outside a request, or when the router has no
+/// encoder.
+#[inline]
+pub fn encode<T: Serialize>(value: &T) -> Result<Vec<u8>> {
+    ENCODER
+        .try_with(|encoder| encoder.encode(value))
+        .unwrap_or_else(|_| to_vec(value))
+}
+
+/// Check that a JSONP callback name is a plain JavaScript identifier.
+///
+/// Only ASCII letters, digits, `_` and `$` are accepted, not starting with a
+/// digit and at most 128 characters long. Anything else (dots, brackets,
+/// whitespace, quotes) is rejected, so a callback taken from the query
+/// string cannot inject script.
+///
+/// # Example
+///
+/// ```
+/// use armature_core::json::is_valid_callback;
+///
+/// assert!(is_valid_callback("handleData"));
+/// assert!(is_valid_callback("jQuery_123$"));
+/// assert!(!is_valid_callback("alert(1);cb"));
+/// assert!(!is_valid_callback("1cb"));
+/// ```
+pub fn is_valid_callback(name: &str) -> bool {
+    let mut chars = name.chars();
+    let Some(first) = chars.next() else {
+        return false;
+    };
+    name.len() <= 128
+        && (first.is_ascii_alphabetic() || first == '_' || first == '$')
+        && chars.all(|c| c.is_ascii_alphanumeric() || c == '_' || c == '$')
+}
+
 // ============================================================================
 // Utility Functions
 // ============================================================================
@@ -396,6 +528,75 @@ mod tests {
         assert_eq!(user, parsed);
     }
 
+    #[test]
+    fn test_is_valid_callback() {
+        assert!(is_valid_callback("cb"));
+        assert!(is_valid_callback("_private"));
+        assert!(is_valid_callback("$jsonp_1"));
+
+        assert!(!is_valid_callback(""));
+        assert!(!is_valid_callback("9lives"));
+        assert!(!is_valid_callback("a.b"));
+        assert!(!is_valid_callback("cb;alert(1)"));
+        assert!(!is_valid_callback("<script>"));
+        assert!(!is_valid_callback("caf\u{e9}"));
+        assert!(!is_valid_callback(&"a".repeat(129)));
+    }
+
+    struct WrappingEncoder(&'static str);
+
+    impl JsonEncoder for WrappingEncoder {
+        fn encode(&self, value: &dyn erased_serde::Serialize) -> Result<Vec<u8>> {
+            let inner = to_string(&value)?;
+            Ok(format!(r#"{{"{}":{}}}"#, self.0, inner).into_bytes())
+        }
+    }
+
+    #[tokio::test]
+    async fn test_custom_encoder() {
+        use crate::{Error, HttpRequest, HttpResponse};
+
+        let mut admin = Router::new();
+        admin.get("/", |_req: HttpRequest| async {
+            HttpResponse::json(&serde_json::json!({"a": 1}))
+        });
+        let mut plain = Router::new();
+        plain.get("/", |_req: HttpRequest| async {
+            HttpResponse::jsonp("cb", &1)
+        });
+
+        let mut router = Router::new();
+        router
+            .json_encoder(WrappingEncoder("wrapped"))
+            .error_handler(|_req, _err| async { HttpResponse::json(&1).unwrap() });
+        admin.json_encoder(WrappingEncoder("admin"));
+        router.mount("/admin", admin).unwrap();
+        router.mount("/plain", plain).unwrap();
+
+        let get = |path: &str| HttpRequest::new("GET".into(), path.into());
+        let response = router.route(get("/plain")).await.unwrap();
+        assert_eq!(response.body_ref(), br#"/**/cb({"wrapped":1});"#);
+        let response = router.route(get("/admin")).await.unwrap();
+        assert_eq!(response.body_ref(), br#"{"admin":{"a":1}}"#);
+        let err = Error::Internal("boom".to_string());
+        let response = router.handle_error(Some(get("/boom")), err).await;
+        assert_eq!(response.body_ref(), br#"{"wrapped":1}"#);
+
+        // Other routers and code outside a request keep the default
+        let response = plain_router().route(get("/")).await.unwrap();
+        assert_eq!(response.body_ref(), b"1");
+        let response = HttpResponse::json(&serde_json::json!({"a": 1})).unwrap();
+        assert_eq!(response.body_ref(), br#"{"a":1}"#);
+    }
+
+    fn plain_router() -> Router {
+        let mut router = Router::new();
+        router.get("/", |_req: crate::HttpRequest| async {
+            crate::HttpResponse::json(&1)
+        });
+        router
+    }
+
     #[test]
     fn test_to_string() {
         let user = TestUser {
@@ -128,18 +128,26 @@
 pub mod application;
 pub mod arena;
 pub mod batch;
+pub mod bind;
 pub mod body;
 pub mod body_limits;
 pub mod body_parser;
 pub mod buffer_pool;
 pub mod cache_local;
+pub mod client_ip;
+pub mod conditional;
 pub mod connection;
 pub mod connection_manager;
 pub mod connection_tuning;
 pub mod container;
+pub mod content_negotiation;
+pub mod cookie;
 pub mod cow_state;
+pub mod download;
+pub mod dump;
 pub mod epoll_tuning;
 pub mod error;
+pub mod error_hooks;
 pub mod extensions;
 pub mod extractors;
 pub mod fast_response;
@@ -155,8 +163,10 @@ pub mod io_uring;
 pub mod json;
 pub mod lifecycle;
 pub mod load_balancer;
+pub mod locals;
 pub mod logging;
 pub mod memory_opt;
+pub mod method_override;
 pub mod middleware;
 pub mod module;
 pub mod numa;
@@ -164,17 +174,25 @@ pub mod pagination;
 pub mod pipeline;
 pub mod read_buffer;
 pub mod read_state;
+pub mod recover;
+pub mod redirect;
+pub mod render;
+pub mod request_logger;
 pub mod resilience;
 pub mod response_buffer;
+pub mod response_cache;
 pub mod response_pipeline;
 pub mod route_cache;
 pub mod route_constraint;
 pub mod route_group;
+pub mod route_info;
+pub mod route_names;
 pub mod route_params;
 pub mod route_registry;
 pub mod routing;
 pub mod runtime_config;
 pub mod serialization_pool;
+pub mod server_timeouts;
 pub mod shutdown;
 pub mod simd_parser;
 pub mod small_vec;
@@ -183,10 +201,14 @@ pub mod sse;
 pub mod static_assets;
 pub mod status;
 pub mod streaming;
+#[cfg(feature = "templates")]
+pub mod templates;
 pub mod timeout;
 pub mod tls;
 pub mod tower_compat;
 pub mod traits;
+#[cfg(unix)]
+pub mod unix_socket;
 pub mod vectored_io;
 pub mod websocket;
 pub mod worker;
@@ -195,7 +217,9 @@ pub mod zero_cost;
 
 // Re-export commonly used types
 pub use application::*;
+pub use bind::*;
 pub use body_limits::*;
+pub use client_ip::{ForwardedHeader, Https, RemoteAddr, TrustedProxies};
 pub use connection::{
     Connection, ConnectionConfig, ConnectionEvent, ConnectionPool, ConnectionRecycler,
     ConnectionState, ConnectionStats, PoolHandle, Recyclable, RecyclableConnection, RecyclePool,
@@ -203,7 +227,11 @@ pub use connection::{
     TransitionError, connection_stats, recycle_stats,
 };
 pub use container::*;
+pub use content_negotiation::{Accept, ContentNegotiator, MediaType};
+pub use cookie::*;
+pub use dump::BodyDump;
 pub use error::*;
+pub use error_hooks::{ErrorHook, PanicHook, RequestInfo};
 pub use extensions::Extensions;
 pub use extractors::{
     Body, ContentType, Form, FromRequest, FromRequestNamed, Header, Headers, Method, Path,
@@ -218,7 +246,9 @@ pub use hmr::*;
 pub use http::*;
 pub use interceptor::*;
 pub use lifecycle::*;
+pub use locals::Local;
 pub use logging::*;
+pub use method_override::MethodOverride;
 pub use middleware::*;
 pub use module::*;
 pub use numa::{
@@ -232,6 +262,9 @@ pub use read_buffer::{
     LARGE_BUFFER, MAX_BUFFER, MEDIUM_BUFFER, MIN_BUFFER, PayloadTracker, ReadBufferConfig,
     SMALL_BUFFER, TINY_BUFFER, buffer_sizing_stats,
 };
+pub use recover::*;
+pub use render::Renderer;
+pub use request_logger::*;
 pub use resilience::{
     BackoffStrategy, Bulkhead, BulkheadConfig, BulkheadError, BulkheadStats, CircuitBreaker,
     CircuitBreakerConfig, CircuitBreakerError, CircuitBreakerStats, CircuitState, Fallback,
@@ -249,8 +282,11 @@ pub use response_pipeline::{
 };
 pub use route_constraint::*;
 pub use route_group::*;
+pub use route_info::RouteInfo;
+pub use route_params::ParamError;
 pub use route_registry::{OptimizedRouteHandler, RouteEntry, RouteHandlerFn};
-pub use routing::{OptimizedHandler, Route, Router}; // Explicit exports to avoid ambiguous HandlerFn
+pub use routing::{MatchedPath, MountPrefix, OptimizedHandler, Route, Router}; // Explicit exports to avoid ambiguous HandlerFn
+pub use server_timeouts::ServerTimeouts;
 pub use shutdown::*;
 pub use sse::*;
 pub use static_assets::*;
@@ -258,6 +294,8 @@ pub use status::*;
 pub use timeout::*;
 pub use tls::*;
 pub use traits::*;
+#[cfg(unix)]
+pub use unix_socket::UnixSocketConfig;
 pub use vectored_io::{
     MAX_IO_SLICES, ResponseChunks, VectoredIoStats, VectoredResponse, status_line, vectored_stats,
 };
@@ -0,0 +1,181 @@
+//! Per-request values stored under string keys.
+//!
+//! Middleware uses these to hand data such as the authenticated user or the
+//! tenant to the handlers behind it:
+//!
+//! ```
+//! use armature_core::HttpRequest;
+//!
+//! #[derive(Debug, PartialEq)]
+//! struct User {
+//!     id: u64,
+//! }
+//!
+//! let mut req = HttpRequest::new("GET".into(), "/".into());
+//! req.set("user", User { id: 7 });
+//!
+//! assert_eq!(req.get_as::<User>("user"), Some(&User { id: 7 }));
+//! assert!(req.get_as::<String>("user").is_none());
+//! assert!(req.get("tenant").is_none());
+//! ```
+//!
+//! The values live in the request, so they are dropped with it when the
+//! request ends. Values must be `Send + Sync`; setting one needs a mutable
+//! request, so a task spawned by a handler can't race with a write. Use
+//! [`HttpRequest::get_shared`] to move a value into such a task.
+//!
+//! Unlike [`Extensions`](crate::Extensions), which hold one value per type,
+//! several values of the same type can be stored under different keys.
+
+use crate::HttpRequest;
+use std::any::Any;
+use std::collections::HashMap;
+use std::sync::Arc;
+
+/// A value stored with [`HttpRequest::set`]
+pub type Local = dyn Any + Send + Sync;
+
+/// Values set on a request, kept in its extensions
+#[derive(Clone, Default)]
+pub(crate) struct Locals(HashMap<String, Arc<Local>>);
+
+impl HttpRequest {
+    /// Store `value` under `key`, replacing any value already stored there.
+    pub fn set<T: Send + Sync + 'static>(&mut self, key: impl Into<String>, value: T) {
+        self.extensions
+            .get_mut_or_default::<Locals>()
+            .0
+            .insert(key.into(), Arc::new(value));
+    }
+
+    /// Value stored under `key`, whatever its type.
+    ///
+    /// Use [`get_as`](Self::get_as) to get it as a concrete type.
+    pub fn get(&self, key: &str) -> Option<&Local> {
+        self.extensions
+            .get::<Locals>()
+            .and_then(|locals| locals.0.get(key))
+            .map(|value| value.as_ref())
+    }
+
+    /// Value stored under `key`.
+    ///
+    /// # Panics
+    ///
+    /// Panics if nothing is stored under `key`. Use it for values the
+    /// middleware in front of the handler always sets.
+    pub fn must_get(&self, key: &str) -> &Local {
+        self.get(key)
+            .unwrap_or_else(|| panic!("no request value is set for {:?}", key))
+    }
+
+    /// Value stored under `key`, if there is one of type `T`.
+    ///
+    /// Returns `None` both when nothing is stored under `key` and when the
+    /// value has another type.
+    pub fn get_as<T: Send + Sync + 'static>(&self, key: &str) -> Option<&T> {
+        self.get(key)?.downcast_ref()
+    }
+
+    /// Shared handle to the value of type `T` stored under `key`, for use
+    /// outside the request, such as in a spawned task.
+    pub fn get_shared<T: Send + Sync + 'static>(&self, key: &str) -> Option<Arc<T>> {
+        let value = self.extensions.get::<Locals>()?.0.get(key)?;
+        Arc::clone(value).downcast().ok()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn request() -> HttpRequest {
+        HttpRequest::new("GET".into(), "/".into())
+    }
+
+    #[test]
+    fn test_set_and_get() {
+        let mut req = request();
+        assert!(req.get("user").is_none());
+
+        req.set("user", "ada".to_string());
+        req.set("tenant", 42u32);
+        assert_eq!(req.get_as::<String>("user").unwrap(), "ada");
+        assert_eq!(req.get_as::<u32>("tenant"), Some(&42));
+        assert!(req.get("user").unwrap().is::<String>());
+        assert!(req.must_get("tenant").is::<u32>());
+
+        req.set("tenant", 7u32);
+        assert_eq!(req.get_as::<u32>("tenant"), Some(&7));
+        assert!(req.get("missing").is_none());
+    }
+
+    #[test]
+    fn test_get_as_wrong_type() {
+        let mut req = request();
+        req.set("count", 3i64);
+
+        assert!(req.get_as::<i32>("count").is_none());
+        assert!(req.get_shared::<String>("count").is_none());
+        assert!(req.get_as::<i32>("missing").is_none());
+        assert_eq!(req.get_as::<i64>("count"), Some(&3));
+    }
+
+    #[test]
+    #[should_panic(expected = "no request value is set for \"user\"")]
+    fn test_must_get_missing() {
+        request().must_get("user");
+    }
+
+    #[test]
+    fn test_clones_are_independent() {
+        let mut req = request();
+        req.set("role", "admin");
+        let mut copy = req.clone();
+        copy.set("role", "guest");
+        copy.set("extra", true);
+
+        assert_eq!(req.get_as::<&str>("role"), Some(&"admin"));
+        assert!(req.get("extra").is_none());
+        assert_eq!(copy.get_as::<&str>("role"), Some(&"guest"));
+    }
+
+    #[tokio::test]
+    async fn test_shared_value_in_spawned_task() {
+        let mut req = request();
+        req.set("user", "ada".to_string());
+
+        let user = req.get_shared::<String>("user").unwrap();
+        let len = tokio::spawn(async move { user.len() }).await.unwrap();
+        assert_eq!(len, 3);
+    }
+
+    #[tokio::test]
+    async fn test_middleware_passes_values_to_handler() {
+        use crate::{Error, HttpResponse, Middleware, Router, middleware::Next};
+
+        struct Authenticate;
+
+        #[async_trait::async_trait]
+        impl Middleware for Authenticate {
+            async fn handle(
+                &self,
+                mut req: HttpRequest,
+                next: Next,
+            ) -> Result<HttpResponse, Error> {
+                req.set("user", "ada".to_string());
+                next(req).await
+            }
+        }
+
+        let mut router = Router::new();
+        router.use_middleware(Authenticate);
+        router.get("/", |req: HttpRequest| async move {
+            let user = req.get_as::<String>("user").cloned().unwrap_or_default();
+            Ok(HttpResponse::text(user))
+        });
+
+        let response = router.route(request()).await.unwrap();
+        assert_eq!(response.body_ref(), b"ada");
+    }
+}
@@ -0,0 +1,311 @@
+//! Method override for clients that can only send `GET` and `POST`.
+//!
+//! HTML forms and some proxies only send `GET` and `POST`. With
+//! [`Router::method_override`], a `POST` can name the method it stands for
+//! in the `X-HTTP-Method-Override` header or in a `_method` form field, and is
+//! routed as that method. The field is read from URL-encoded and multipart
+//! bodies, so forms with a file input work too:
+//!
+//! ```
+//! # tokio_test::block_on(async {
+//! use armature_core::{HttpRequest, HttpResponse, MethodOverride, Router};
+//!
+//! let mut router = Router::new();
+//! router.method_override(MethodOverride::new());
+//! router.delete("/posts/:id", |_req: HttpRequest| async {
+//!     Ok(HttpResponse::text("deleted"))
+//! });
+//!
+//! let mut req = HttpRequest::new("POST".into(), "/posts/7".into());
+//! req.headers.insert(
+//!     "Content-Type".into(),
+//!     "application/x-www-form-urlencoded".into(),
+//! );
+//! req.body = b"_method=DELETE".to_vec();
+//!
+//! let response = router.route(req).await.unwrap();
+//! assert_eq!(response.body_ref(), b"deleted");
+//! # });
+//! ```
+//!
+//! Only `POST` requests are rewritten, so a link or a prefetched `GET` can
+//! never trigger a `DELETE`. The override happens before the route is
+//! matched, so the handler and router middleware only see the new method.
+
+use crate::form::MultipartParser;
+use crate::{Error, HttpMethod, HttpRequest, Router};
+
+/// Which methods a `POST` may be rewritten to, and where to read them.
+///
+/// Registered with [`Router::method_override`].
+#[derive(Debug, Clone)]
+pub struct MethodOverride {
+    methods: Vec<HttpMethod>,
+    header: String,
+    form_field: String,
+}
+
+impl MethodOverride {
+    /// Allow `PUT`, `PATCH` and `DELETE`, read from the
+    /// `X-HTTP-Method-Override` header or the `_method` form field.
+    pub fn new() -> Self {
+        Self {
+            methods: vec![HttpMethod::PUT, HttpMethod::PATCH, HttpMethod::DELETE],
+            header: "X-HTTP-Method-Override".to_string(),
+            form_field: "_method".to_string(),
+        }
+    }
+
+    /// Replace the methods a `POST` may be rewritten to.
+    ///
+    /// `GET` and `HEAD` are never used even if listed: a request with a body
+    /// turned into one would reach handlers that assume it has no side
+    /// effects.
+    pub fn methods(mut self, methods: impl IntoIterator<Item = HttpMethod>) -> Self {
+        self.methods = methods
+            .into_iter()
+            .filter(|method| !matches!(method, HttpMethod::GET | HttpMethod::HEAD))
+            .collect();
+        self
+    }
+
+    /// Read the method from the header `name` instead.
+    pub fn header(mut self, name: impl Into<String>) -> Self {
+        self.header = name.into();
+        self
+    }
+
+    /// Read the method from the form field `name` instead.
+    pub fn form_field(mut self, name: impl Into<String>) -> Self {
+        self.form_field = name.into();
+        self
+    }
+
+    /// Rewrite the method of `request` if it is a `POST` asking for one.
+    ///
+    /// The header is used if present; otherwise the form field of a
+    /// URL-encoded or multipart body. Asking for `POST` itself leaves the
+    /// request as is.
+    ///
+    /// # Errors
+    ///
+    /// Returns [`Error::BadRequest`] if the requested method isn't one of
+    /// [`methods`](Self::methods).
+    pub(crate) fn apply(&self, request: &mut HttpRequest) -> Result<(), Error> {
+        if request.method != "POST" {
+            return Ok(());
+        }
+        let requested = match request.header(&self.header) {
+            Some(method) => method.trim().to_string(),
+            None => match self.form_value(request) {
+                Some(method) => method,
+                None => return Ok(()),
+            },
+        };
+        if requested.is_empty() || requested.eq_ignore_ascii_case("POST") {
+            return Ok(());
+        }
+
+        let method = HttpMethod::from_str(&requested)
+            .filter(|method| self.methods.contains(method))
+            .ok_or_else(|| {
+                Error::BadRequest(format!(
+                    "Overriding POST with {:?} is not allowed",
+                    requested
+                ))
+            })?;
+        request.method = method.as_str().to_string();
+        Ok(())
+    }
+
+    /// The override field of a URL-encoded or multipart body, if it has one
+    fn form_value(&self, request: &HttpRequest) -> Option<String> {
+        let content_type = request.header("content-type")?;
+        let mime = content_type.split(';').next().unwrap_or_default().trim();
+        if mime.eq_ignore_ascii_case("multipart/form-data") {
+            // Forms with a file input are sent as multipart
+            return MultipartParser::from_content_type(content_type)
+                .ok()?
+                .text_field(request.body_ref(), &self.form_field)
+                .map(|value| value.trim().to_string());
+        }
+        if !mime.eq_ignore_ascii_case("application/x-www-form-urlencoded") {
+            return None;
+        }
+        let fields: Vec<(String, String)> =
+            serde_urlencoded::from_bytes(request.body_ref()).ok()?;
+        fields
+            .into_iter()
+            .find(|(name, _)| *name == self.form_field)
+            .map(|(_, value)| value.trim().to_string())
+    }
+}
+
+impl Default for MethodOverride {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+impl Router {
+    /// Route `POST` requests as the method named in their override header
+    /// or form field; see [`MethodOverride`].
+    ///
+    /// Applies to requests this router matches, including those it passes
+    /// to mounted routers.
+    pub fn method_override(&mut self, config: MethodOverride) -> &mut Self {
+        self.method_override = Some(config);
+        self
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::HttpResponse;
+
+    fn router() -> Router {
+        let mut router = Router::new();
+        router.method_override(MethodOverride::new());
+        for (method, name) in [
+            (HttpMethod::POST, "post"),
+            (HttpMethod::PUT, "put"),
+            (HttpMethod::PATCH, "patch"),
+            (HttpMethod::DELETE, "delete"),
+            (HttpMethod::GET, "get"),
+        ] {
+            router.add_route(crate::Route::new(
+                method,
+                "/posts/:id",
+                move |req: HttpRequest| async move {
+                    Ok(HttpResponse::text(format!("{} {}", name, req.method)))
+                },
+            ));
+        }
+        router
+    }
+
+    fn form(method: &str, body: &str) -> HttpRequest {
+        let mut req = HttpRequest::new(method.into(), "/posts/7".into());
+        req.headers.insert(
+            "Content-Type".into(),
+            "application/x-www-form-urlencoded; charset=utf-8".into(),
+        );
+        req.body = body.as_bytes().to_vec();
+        req
+    }
+
+    async fn body(router: &Router, req: HttpRequest) -> String {
+        let response = router.route(req).await.unwrap();
+        String::from_utf8(response.body_ref().to_vec()).unwrap()
+    }
+
+    #[tokio::test]
+    async fn test_form_field_override() {
+        let router = router();
+
+        let req = form("POST", "title=Hello&_method=delete");
+        assert_eq!(body(&router, req).await, "delete DELETE");
+
+        let req = form("POST", "_method=PATCH");
+        assert_eq!(body(&router, req).await, "patch PATCH");
+
+        // Without the field, or in a body that isn't a form, it stays a POST
+        let req = form("POST", "title=Hello");
+        assert_eq!(body(&router, req).await, "post POST");
+        let mut req = form("POST", "_method=DELETE");
+        req.headers
+            .insert("Content-Type".into(), "text/plain".into());
+        assert_eq!(body(&router, req).await, "post POST");
+    }
+
+    #[tokio::test]
+    async fn test_multipart_form_field_override() {
+        let router = router();
+        let multipart = |method: &str| {
+            let mut req = HttpRequest::new("POST".into(), "/posts/1".into());
+            req.headers.insert(
+                "Content-Type".into(),
+                "multipart/form-data; boundary=XyZ".into(),
+            );
+            req.body = format!(
+                "--XyZ\r\n\
+                 Content-Disposition: form-data; name=\"avatar\"; filename=\"_method\"\r\n\r\n\
+                 DELETE\r\n\
+                 --XyZ\r\n\
+                 Content-Disposition: form-data; name=\"_method\"\r\n\r\n\
+                 {}\r\n\
+                 --XyZ--\r\n",
+                method
+            )
+            .into_bytes();
+            req
+        };
+
+        assert_eq!(body(&router, multipart("PATCH")).await, "patch PATCH");
+        assert_eq!(body(&router, multipart(" put ")).await, "put PUT");
+    }
+
+    #[tokio::test]
+    async fn test_header_override() {
+        let router = router();
+
+        let mut req = HttpRequest::new("POST".into(), "/posts/7".into());
+        req.headers
+            .insert("x-http-method-override".into(), "PUT".into());
+        assert_eq!(body(&router, req).await, "put PUT");
+
+        // The header wins over the form field
+        let mut req = form("POST", "_method=DELETE");
+        req.headers
+            .insert("X-HTTP-Method-Override".into(), "PATCH".into());
+        assert_eq!(body(&router, req).await, "patch PATCH");
+    }
+
+    #[tokio::test]
+    async fn test_disallowed_overrides() {
+        let router = router();
+
+        for method in ["GET", "HEAD", "OPTIONS", "CONNECT", "BREW"] {
+            let mut req = HttpRequest::new("POST".into(), "/posts/7".into());
+            req.headers
+                .insert("X-HTTP-Method-Override".into(), method.into());
+            let err = router.route(req).await.unwrap_err();
+            assert!(matches!(err, Error::BadRequest(_)), "{}", method);
+        }
+
+        // Only POST is ever rewritten
+        let mut req = HttpRequest::new("GET".into(), "/posts/7".into());
+        req.headers
+            .insert("X-HTTP-Method-Override".into(), "DELETE".into());
+        assert_eq!(body(&router, req).await, "get GET");
+        let req = form("PUT", "_method=DELETE");
+        assert_eq!(body(&router, req).await, "put PUT");
+    }
+
+    #[tokio::test]
+    async fn test_configured_methods_and_names() {
+        let mut router = router();
+        router.method_override(
+            MethodOverride::new()
+                .methods([HttpMethod::DELETE, HttpMethod::GET])
+                .header("X-Method")
+                .form_field("verb"),
+        );
+
+        let req = form("POST", "verb=DELETE");
+        assert_eq!(body(&router, req).await, "delete DELETE");
+
+        let mut req = HttpRequest::new("POST".into(), "/posts/7".into());
+        req.headers.insert("X-Method".into(), "PUT".into());
+        assert!(router.route(req).await.is_err());
+
+        // GET can't be allowed
+        let req = form("POST", "verb=GET");
+        assert!(router.route(req).await.is_err());
+
+        // The default names are no longer read
+        let req = form("POST", "_method=DELETE");
+        assert_eq!(body(&router, req).await, "post POST");
+    }
+}
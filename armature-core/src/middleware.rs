@@ -1,9 +1,11 @@
 // Middleware system for request/response processing
 
+use crate::error_hooks::HookScope;
+use crate::handler::{BoxedHandler, IntoHandler};
 use crate::logging::{debug, trace};
+use crate::request_logger::LogFields;
 use crate::{Error, HttpRequest, HttpResponse};
 use async_trait::async_trait;
-use std::collections::HashMap;
 use std::future::Future;
 use std::pin::Pin;
 use std::sync::Arc;
@@ -29,9 +31,21 @@ pub trait Middleware: Send + Sync {
 }
 
 /// Middleware chain executor
+///
+/// Middleware runs in ascending priority order: the lowest priority is the
+/// outermost middleware, so it sees the request first and the response
+/// last. Middleware with the same priority runs in the order it was added.
+/// [`use_middleware`](Self::use_middleware) adds at priority `0`.
+///
+/// Priorities are plain numbers; a useful convention is to give
+/// middleware that must see everything (request IDs, logging, panic
+/// recovery) a negative priority and middleware that transforms the
+/// response body (compression) a positive one, so adding middleware in a
+/// different order doesn't change how they nest.
 #[derive(Clone)]
 pub struct MiddlewareChain {
-    middlewares: Arc<Vec<Arc<dyn Middleware>>>,
+    /// Middleware in execution order, with their priorities
+    middlewares: Arc<Vec<(i32, Arc<dyn Middleware>)>>,
 }
 
 impl MiddlewareChain {
@@ -41,10 +55,67 @@ impl MiddlewareChain {
         }
     }
 
-    /// Add a middleware to the chain
+    /// Create a chain from an ordered list of middleware
+    ///
+    /// The first middleware in the list is the outermost one and runs first.
+    pub fn from_middleware(middlewares: Vec<Arc<dyn Middleware>>) -> Self {
+        Self {
+            middlewares: Arc::new(middlewares.into_iter().map(|mw| (0, mw)).collect()),
+        }
+    }
+
+    /// Number of middleware in the chain
+    pub fn len(&self) -> usize {
+        self.middlewares.len()
+    }
+
+    /// Whether the chain contains no middleware
+    pub fn is_empty(&self) -> bool {
+        self.middlewares.is_empty()
+    }
+
+    /// Wrap a route handler so every call runs through this chain first
+    ///
+    /// The chain is captured as it is now; middleware added to `self`
+    /// afterwards does not affect the returned handler.
+    pub fn wrap(&self, handler: BoxedHandler) -> BoxedHandler {
+        if self.is_empty() {
+            return handler;
+        }
+
+        let chain = self.clone();
+        let name = handler.name();
+        let auto_head = handler.auto_head();
+        let inner: HandlerFn = Arc::new(move |req| handler.call(req));
+        BoxedHandler::new(
+            (move |req: HttpRequest| {
+                let chain = chain.clone();
+                let inner = inner.clone();
+                async move { chain.apply(req, inner).await }
+            })
+            .into_handler(),
+        )
+        .with_name(name)
+        .with_auto_head(auto_head)
+    }
+
+    /// Add a middleware to the chain at priority `0`
     pub fn use_middleware<M: Middleware + 'static>(&mut self, middleware: M) {
+        self.use_middleware_with_priority(middleware, 0);
+    }
+
+    /// Add a middleware to the chain at the given priority
+    ///
+    /// It runs after (inside) all middleware with a lower priority or the
+    /// same priority, and before (outside) all middleware with a higher one.
+    pub fn use_middleware_with_priority<M: Middleware + 'static>(
+        &mut self,
+        middleware: M,
+        priority: i32,
+    ) {
         let mut mws = (*self.middlewares).clone();
-        mws.push(Arc::new(middleware));
+        let index = mws.partition_point(|(p, _)| *p <= priority);
+        mws.insert(index, (priority, Arc::new(middleware)));
         self.middlewares = Arc::new(mws);
     }
 
@@ -70,7 +141,7 @@ impl MiddlewareChain {
             trace!("Middleware chain complete, calling handler");
             handler(req)
         } else {
-            let middleware = self.middlewares[index].clone();
+            let middleware = self.middlewares[index].1.clone();
             let chain = self.clone();
             let handler_clone = handler.clone();
 
@@ -95,13 +166,35 @@ impl Default for MiddlewareChain {
 
 // ========== Built-in Middleware ==========
 
+/// Callback deciding whether a request origin is allowed
+pub type OriginValidator = Arc<dyn Fn(&str) -> bool + Send + Sync>;
+
 /// CORS (Cross-Origin Resource Sharing) middleware
+///
+/// Answers preflight requests (`OPTIONS` with `Origin` and
+/// `Access-Control-Request-Method`) directly, so they succeed even for paths
+/// without an `OPTIONS` route, and adds `Access-Control-Allow-Origin` to
+/// other responses for allowed origins.
+///
+/// Origins come from an allowlist ([`allow_origin`](Self::allow_origin)) or
+/// a callback ([`allow_origin_fn`](Self::allow_origin_fn)); the default
+/// allows any origin. With credentials enabled a wildcard is never sent: the
+/// request's origin is echoed instead, as browsers require.
+///
+/// Errors returned further down the chain pass through unchanged, without
+/// CORS headers.
 pub struct CorsMiddleware {
+    /// Allowed origins, comma-separated, or `*` for any
     pub allow_origin: String,
     pub allow_methods: String,
     pub allow_headers: String,
+    /// Response headers exposed to scripts, comma-separated
+    pub expose_headers: String,
     pub allow_credentials: bool,
     pub max_age: u32,
+    origin_validator: Option<OriginValidator>,
+    /// `*` was passed to `allow_origin`, so the allowlist is ignored
+    any_origin: bool,
 }
 
 impl CorsMiddleware {
@@ -110,13 +203,59 @@ impl CorsMiddleware {
             allow_origin: "*".to_string(),
             allow_methods: "GET, POST, PUT, DELETE, OPTIONS, PATCH".to_string(),
             allow_headers: "Content-Type, Authorization, Accept".to_string(),
+            expose_headers: String::new(),
             allow_credentials: false,
             max_age: 86400, // 24 hours
+            origin_validator: None,
+            any_origin: false,
         }
     }
 
+    /// Allow an origin; call repeatedly to build an allowlist
+    ///
+    /// `"*"` allows any origin: it replaces the origins added before it, and
+    /// those added after it are ignored.
     pub fn allow_origin(mut self, origin: &str) -> Self {
-        self.allow_origin = origin.to_string();
+        if origin.trim() == "*" {
+            self.any_origin = true;
+            self.allow_origin = "*".to_string();
+        } else if self.any_origin {
+            // Already allowed by `*`
+        } else if self.allow_origin.trim() == "*" {
+            self.allow_origin = origin.to_string();
+        } else {
+            self.allow_origin = format!("{}, {}", self.allow_origin, origin);
+        }
+        self
+    }
+
+    /// Decide per request whether an origin is allowed
+    ///
+    /// Takes precedence over the allowlist.
+    pub fn allow_origin_fn<F>(mut self, validator: F) -> Self
+    where
+        F: Fn(&str) -> bool + Send + Sync + 'static,
+    {
+        self.origin_validator = Some(Arc::new(validator));
+        self
+    }
+
+    /// Set the methods allowed in preflight requests, comma-separated
+    pub fn allow_methods(mut self, methods: &str) -> Self {
+        self.allow_methods = methods.to_string();
+        self
+    }
+
+    /// Set the request headers allowed in preflight requests, comma-separated,
+    /// or `*` for any
+    pub fn allow_headers(mut self, headers: &str) -> Self {
+        self.allow_headers = headers.to_string();
+        self
+    }
+
+    /// Set the response headers scripts may read, comma-separated
+    pub fn expose_headers(mut self, headers: &str) -> Self {
+        self.expose_headers = headers.to_string();
         self
     }
 
@@ -124,6 +263,113 @@ impl CorsMiddleware {
         self.allow_credentials = allow;
         self
     }
+
+    /// Set how long browsers may cache a preflight result, in seconds
+    pub fn max_age(mut self, seconds: u32) -> Self {
+        self.max_age = seconds;
+        self
+    }
+
+    fn allows_any_origin(&self) -> bool {
+        self.origin_validator.is_none()
+            && self
+                .allow_origin
+                .split(',')
+                .any(|origin| origin.trim() == "*")
+    }
+
+    /// Whether the `Access-Control-Allow-Origin` value depends on the request
+    fn varies_by_origin(&self) -> bool {
+        !self.allows_any_origin() || self.allow_credentials
+    }
+
+    /// Value for `Access-Control-Allow-Origin`, or `None` if the origin is
+    /// not allowed
+    fn allowed_origin(&self, origin: Option<&str>) -> Option<String> {
+        if let Some(validator) = &self.origin_validator {
+            return origin
+                .filter(|origin| validator(origin))
+                .map(str::to_string);
+        }
+        if self.allows_any_origin() {
+            return if self.allow_credentials {
+                origin.map(str::to_string)
+            } else {
+                Some("*".to_string())
+            };
+        }
+
+        let mut allowed = self
+            .allow_origin
+            .split(',')
+            .map(str::trim)
+            .filter(|o| !o.is_empty());
+        match origin {
+            Some(origin) => allowed
+                .find(|allowed| allowed.eq_ignore_ascii_case(origin))
+                .map(|_| origin.to_string()),
+            // Not a cross-origin request; a single fixed origin can still be sent as is
+            None => {
+                let first = allowed.next()?;
+                allowed.next().is_none().then(|| first.to_string())
+            }
+        }
+    }
+
+    fn allows_method(&self, method: &str) -> bool {
+        self.allow_methods
+            .split(',')
+            .any(|allowed| allowed.trim().eq_ignore_ascii_case(method.trim()))
+    }
+
+    fn preflight(&self, req: &HttpRequest, requested_method: &str) -> HttpResponse {
+        let mut response = HttpResponse::new(204);
+        if self.varies_by_origin() {
+            response.add_vary("Origin");
+        }
+        response.add_vary("Access-Control-Request-Method");
+        response.add_vary("Access-Control-Request-Headers");
+
+        let Some(origin) = self.allowed_origin(req.header("origin")) else {
+            return response;
+        };
+        if !self.allows_method(requested_method) {
+            return response;
+        }
+
+        let allow_headers = match (self.allow_headers.trim(), self.allow_credentials) {
+            // `*` is taken literally on credentialed requests
+            ("*", true) => req
+                .header("access-control-request-headers")
+                .unwrap_or_default()
+                .to_string(),
+            _ => self.allow_headers.clone(),
+        };
+
+        response
+            .headers
+            .insert("Access-Control-Allow-Origin".to_string(), origin);
+        response.headers.insert(
+            "Access-Control-Allow-Methods".to_string(),
+            self.allow_methods.clone(),
+        );
+        if !allow_headers.is_empty() {
+            response
+                .headers
+                .insert("Access-Control-Allow-Headers".to_string(), allow_headers);
+        }
+        response.headers.insert(
+            "Access-Control-Max-Age".to_string(),
+            self.max_age.to_string(),
+        );
+        if self.allow_credentials {
+            response.headers.insert(
+                "Access-Control-Allow-Credentials".to_string(),
+                "true".to_string(),
+            );
+        }
+        response
+    }
 }
 
 impl Default for CorsMiddleware {
@@ -136,48 +382,40 @@ impl Default for CorsMiddleware {
 impl Middleware for CorsMiddleware {
     async fn handle(&self, req: HttpRequest, next: Next) -> Result<HttpResponse, Error> {
         // Handle preflight requests
-        if req.method == "OPTIONS" {
-            let mut headers = HashMap::new();
-            headers.insert(
-                "Access-Control-Allow-Origin".to_string(),
-                self.allow_origin.clone(),
-            );
-            headers.insert(
-                "Access-Control-Allow-Methods".to_string(),
-                self.allow_methods.clone(),
-            );
-            headers.insert(
-                "Access-Control-Allow-Headers".to_string(),
-                self.allow_headers.clone(),
-            );
-            headers.insert(
-                "Access-Control-Max-Age".to_string(),
-                self.max_age.to_string(),
-            );
-
-            if self.allow_credentials {
-                headers.insert(
-                    "Access-Control-Allow-Credentials".to_string(),
-                    "true".to_string(),
-                );
-            }
-
-            return Ok(HttpResponse::with_status_and_headers(204, headers));
+        if req.method == "OPTIONS"
+            && req.header("origin").is_some()
+            && let Some(requested_method) = req.header("access-control-request-method")
+        {
+            return Ok(self.preflight(&req, requested_method));
         }
 
+        let allowed_origin = self.allowed_origin(req.header("origin"));
+
         // Process request and add CORS headers to response
         let mut response = next(req).await?;
 
-        response.headers.insert(
-            "Access-Control-Allow-Origin".to_string(),
-            self.allow_origin.clone(),
-        );
+        if self.varies_by_origin() {
+            response.add_vary("Origin");
+        }
+        let Some(origin) = allowed_origin else {
+            return Ok(response);
+        };
+
+        response
+            .headers
+            .insert("Access-Control-Allow-Origin".to_string(), origin);
         if self.allow_credentials {
             response.headers.insert(
                 "Access-Control-Allow-Credentials".to_string(),
                 "true".to_string(),
             );
         }
+        if !self.expose_headers.trim().is_empty() {
+            response.headers.insert(
+                "Access-Control-Expose-Headers".to_string(),
+                self.expose_headers.clone(),
+            );
+        }
 
         Ok(response)
     }
@@ -237,31 +475,399 @@ impl Middleware for LoggerMiddleware {
     }
 }
 
+/// Callback that generates a new request ID
+pub type RequestIdGenerator = Arc<dyn Fn() -> String + Send + Sync>;
+
+/// ID assigned to a request by [`RequestIdMiddleware`]
+///
+/// Stored in the request extensions; read it with [`HttpRequest::request_id`].
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct RequestId(pub String);
+
+impl HttpRequest {
+    /// The ID assigned by [`RequestIdMiddleware`], if it has run.
+    pub fn request_id(&self) -> Option<&str> {
+        self.extensions.get::<RequestId>().map(|id| id.0.as_str())
+    }
+}
+
 /// Request ID middleware
-pub struct RequestIdMiddleware;
+///
+/// Reuses the `X-Request-ID` sent by the client, or generates a UUID v4 when
+/// it is missing. The ID is stored on the request (see
+/// [`HttpRequest::request_id`]), forwarded in the request header, handed to
+/// an enclosing [`RequestLogger`](crate::request_logger::RequestLogger), and
+/// echoed in the response header.
+///
+/// Incoming IDs longer than 128 characters or containing anything other
+/// than ASCII letters, digits and `-_.:` are replaced, so they can't be
+/// used to inject content into logs.
+#[derive(Clone)]
+pub struct RequestIdMiddleware {
+    header: String,
+    generator: RequestIdGenerator,
+    trust_incoming: bool,
+}
+
+impl RequestIdMiddleware {
+    /// Maximum length of an accepted incoming ID
+    const MAX_INCOMING_LEN: usize = 128;
+
+    pub fn new() -> Self {
+        Self {
+            header: "x-request-id".to_string(),
+            generator: Arc::new(|| uuid::Uuid::new_v4().to_string()),
+            trust_incoming: true,
+        }
+    }
+
+    /// Use a different header name, e.g. `x-correlation-id`
+    pub fn header(mut self, name: impl Into<String>) -> Self {
+        self.header = name.into().to_ascii_lowercase();
+        self
+    }
+
+    /// Generate IDs with a custom function instead of UUID v4
+    pub fn generator<F>(mut self, generator: F) -> Self
+    where
+        F: Fn() -> String + Send + Sync + 'static,
+    {
+        self.generator = Arc::new(generator);
+        self
+    }
+
+    /// Always generate a new ID, ignoring any ID sent by the client
+    pub fn always_regenerate(mut self) -> Self {
+        self.trust_incoming = false;
+        self
+    }
+
+    fn is_acceptable(id: &str) -> bool {
+        !id.is_empty()
+            && id.len() <= Self::MAX_INCOMING_LEN
+            && id
+                .bytes()
+                .all(|b| b.is_ascii_alphanumeric() || matches!(b, b'-' | b'_' | b'.' | b':'))
+    }
+}
+
+impl Default for RequestIdMiddleware {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+impl std::fmt::Debug for RequestIdMiddleware {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.debug_struct("RequestIdMiddleware")
+            .field("header", &self.header)
+            .field("trust_incoming", &self.trust_incoming)
+            .finish_non_exhaustive()
+    }
+}
 
 #[async_trait]
 impl Middleware for RequestIdMiddleware {
     async fn handle(&self, mut req: HttpRequest, next: Next) -> Result<HttpResponse, Error> {
-        // Generate or use existing request ID
+        // Use the client's ID when allowed and well-formed, otherwise generate one
         let request_id = req
-            .headers
-            .get("x-request-id")
-            .cloned()
-            .unwrap_or_else(|| uuid::Uuid::new_v4().to_string());
+            .header(&self.header)
+            .filter(|id| self.trust_incoming && Self::is_acceptable(id))
+            .map(str::to_string)
+            .unwrap_or_else(|| (self.generator)());
 
         req.headers
-            .insert("x-request-id".to_string(), request_id.clone());
+            .retain(|name, _| !name.eq_ignore_ascii_case(&self.header));
+        req.headers.insert(self.header.clone(), request_id.clone());
+        req.extensions.insert(RequestId(request_id.clone()));
+        if let Some(hooks) = req.extensions.get::<HookScope>() {
+            hooks.set_request_id(&request_id);
+        }
+        if let Some(fields) = req.extensions.get::<LogFields>() {
+            fields.set_request_id(&request_id);
+        }
 
         let mut response = next(req).await?;
-        response
-            .headers
-            .insert("x-request-id".to_string(), request_id);
+        response.headers.insert(self.header.clone(), request_id);
 
         Ok(response)
     }
 }
 
+/// Authenticated principal stored by [`BasicAuthMiddleware`] and [`BearerAuthMiddleware`]
+///
+/// Stored in the request extensions; read it with [`HttpRequest::user`].
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Principal<T>(pub T);
+
+impl HttpRequest {
+    /// The principal an authentication middleware stored for this request.
+    ///
+    /// [`BasicAuthMiddleware`] stores the username as a `String`;
+    /// [`BearerAuthMiddleware`] stores whatever its validator returned.
+    pub fn user<T: Send + Sync + 'static>(&self) -> Option<&T> {
+        self.extensions.get::<Principal<T>>().map(|user| &user.0)
+    }
+}
+
+/// Compare two byte strings in constant time
+///
+/// The time taken depends only on the lengths, not on where the inputs
+/// differ, so it is safe for checking passwords and tokens.
+pub fn constant_time_eq(a: &[u8], b: &[u8]) -> bool {
+    let mut diff = a.len() ^ b.len();
+    for i in 0..a.len().max(b.len()) {
+        let x = a.get(i).copied().unwrap_or(0);
+        let y = b.get(i).copied().unwrap_or(0);
+        diff |= usize::from(x ^ y);
+    }
+    std::hint::black_box(diff) == 0
+}
+
+/// The credentials of an `Authorization` header with the given scheme
+///
+/// The scheme is matched case-insensitively.
+fn authorization_credentials<'a>(req: &'a HttpRequest, scheme: &str) -> Option<&'a str> {
+    let value = req.header("authorization")?.trim();
+    let (given, credentials) = value.split_once(' ')?;
+    given
+        .eq_ignore_ascii_case(scheme)
+        .then(|| credentials.trim())
+        .filter(|credentials| !credentials.is_empty())
+}
+
+/// A `401 Unauthorized` error response with a `WWW-Authenticate` challenge
+fn unauthorized(challenge: String) -> HttpResponse {
+    crate::application::error_response(&Error::Unauthorized("authentication required".to_string()))
+        .with_header("WWW-Authenticate".to_string(), challenge)
+}
+
+/// Quote a value for an authentication challenge parameter
+fn quote_param(value: &str) -> String {
+    format!("\"{}\"", value.replace('\\', "\\\\").replace('"', "\\\""))
+}
+
+/// Callback that checks a username and password
+pub type BasicAuthValidator = Arc<dyn Fn(&str, &str) -> bool + Send + Sync>;
+
+/// HTTP Basic authentication middleware
+///
+/// Checks the `Authorization: Basic` header with a validator. Missing,
+/// malformed and rejected credentials all get `401 Unauthorized` with a
+/// `WWW-Authenticate` challenge for the realm. On success the username is
+/// stored on the request (see [`HttpRequest::user`]).
+///
+/// Validators should compare secrets with [`constant_time_eq`];
+/// [`BasicAuthMiddleware::with_credentials`] does so for a single account.
+///
+/// # Example
+///
+/// ```
+/// use armature_core::{BasicAuthMiddleware, constant_time_eq};
+///
+/// let auth = BasicAuthMiddleware::new(|user, pass| {
+///     user == "admin" && constant_time_eq(pass.as_bytes(), b"s3cret")
+/// })
+/// .realm("Admin area");
+/// ```
+#[derive(Clone)]
+pub struct BasicAuthMiddleware {
+    validator: BasicAuthValidator,
+    realm: String,
+}
+
+impl BasicAuthMiddleware {
+    /// Accept credentials for which `validator(user, password)` is true
+    pub fn new<F>(validator: F) -> Self
+    where
+        F: Fn(&str, &str) -> bool + Send + Sync + 'static,
+    {
+        Self {
+            validator: Arc::new(validator),
+            realm: "Restricted".to_string(),
+        }
+    }
+
+    /// Accept a single username and password
+    pub fn with_credentials(username: impl Into<String>, password: impl Into<String>) -> Self {
+        let username = username.into();
+        let password = password.into();
+        Self::new(move |user, pass| {
+            // Check both so the time taken doesn't reveal which was wrong
+            let user_ok = constant_time_eq(user.as_bytes(), username.as_bytes());
+            let pass_ok = constant_time_eq(pass.as_bytes(), password.as_bytes());
+            user_ok & pass_ok
+        })
+    }
+
+    /// Realm named in the challenge, "Restricted" by default
+    pub fn realm(mut self, realm: impl Into<String>) -> Self {
+        self.realm = realm.into();
+        self
+    }
+
+    /// Decode `user:password` from the header, if well-formed
+    fn credentials(req: &HttpRequest) -> Option<(String, String)> {
+        use base64::Engine;
+
+        let encoded = authorization_credentials(req, "Basic")?;
+        let decoded = base64::engine::general_purpose::STANDARD
+            .decode(encoded)
+            .ok()?;
+        let decoded = String::from_utf8(decoded).ok()?;
+        let (user, pass) = decoded.split_once(':')?;
+        Some((user.to_string(), pass.to_string()))
+    }
+}
+
+impl std::fmt::Debug for BasicAuthMiddleware {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.debug_struct("BasicAuthMiddleware")
+            .field("realm", &self.realm)
+            .finish_non_exhaustive()
+    }
+}
+
+#[async_trait]
+impl Middleware for BasicAuthMiddleware {
+    async fn handle(&self, mut req: HttpRequest, next: Next) -> Result<HttpResponse, Error> {
+        match Self::credentials(&req) {
+            Some((user, pass)) if (self.validator)(&user, &pass) => {
+                req.extensions.insert(Principal(user));
+                next(req).await
+            }
+            _ => {
+                debug!("Basic authentication failed for {}", req.path);
+                Ok(unauthorized(format!(
+                    "Basic realm={}, charset=\"UTF-8\"",
+                    quote_param(&self.realm)
+                )))
+            }
+        }
+    }
+}
+
+/// Callback that turns a bearer token into a principal
+pub type BearerAuthValidator<T> =
+    Arc<dyn Fn(String) -> Pin<Box<dyn Future<Output = Result<T, Error>> + Send>> + Send + Sync>;
+
+/// Bearer token authentication middleware
+///
+/// Passes the token from `Authorization: Bearer <token>` to an async
+/// validator. The principal it returns is stored on the request for
+/// handlers to read with [`HttpRequest::user`].
+///
+/// A missing or malformed header, or a validator error with status 401,
+/// gets `401 Unauthorized` with a `WWW-Authenticate: Bearer` challenge. Other
+/// validator errors, such as [`Error::Forbidden`] or a failed database
+/// lookup, are returned as they are.
+///
+/// # Example
+///
+/// ```
+/// use armature_core::{BearerAuthMiddleware, Error, HttpRequest};
+///
+/// #[derive(Clone)]
+/// struct User {
+///     name: String,
+/// }
+///
+/// let auth = BearerAuthMiddleware::new(|token: String| async move {
+///     match token.as_str() {
+///         "valid-token" => Ok(User { name: "ada".into() }),
+///         _ => Err(Error::Unauthorized("unknown token".into())),
+///     }
+/// });
+///
+/// async fn whoami(req: HttpRequest) -> Result<String, Error> {
+///     let user = req.user::<User>().ok_or_else(|| Error::Unauthorized("no user".into()))?;
+///     Ok(user.name.clone())
+/// }
+/// ```
+pub struct BearerAuthMiddleware<T> {
+    validator: BearerAuthValidator<T>,
+    realm: Option<String>,
+}
+
+impl<T: Send + Sync + 'static> BearerAuthMiddleware<T> {
+    /// Authenticate requests with `validator`
+    pub fn new<F, Fut>(validator: F) -> Self
+    where
+        F: Fn(String) -> Fut + Send + Sync + 'static,
+        Fut: Future<Output = Result<T, Error>> + Send + 'static,
+    {
+        Self {
+            validator: Arc::new(move |token| Box::pin(validator(token))),
+            realm: None,
+        }
+    }
+
+    /// Realm named in the challenge
+    pub fn realm(mut self, realm: impl Into<String>) -> Self {
+        self.realm = Some(realm.into());
+        self
+    }
+
+    /// The challenge sent with a 401, with an optional RFC 6750 error code
+    fn challenge(&self, error: Option<&str>) -> String {
+        let mut params = Vec::new();
+        if let Some(realm) = &self.realm {
+            params.push(format!("realm={}", quote_param(realm)));
+        }
+        if let Some(error) = error {
+            params.push(format!("error=\"{}\"", error));
+        }
+        if params.is_empty() {
+            "Bearer".to_string()
+        } else {
+            format!("Bearer {}", params.join(", "))
+        }
+    }
+}
+
+impl<T> Clone for BearerAuthMiddleware<T> {
+    fn clone(&self) -> Self {
+        Self {
+            validator: Arc::clone(&self.validator),
+            realm: self.realm.clone(),
+        }
+    }
+}
+
+impl<T> std::fmt::Debug for BearerAuthMiddleware<T> {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.debug_struct("BearerAuthMiddleware")
+            .field("realm", &self.realm)
+            .finish_non_exhaustive()
+    }
+}
+
+#[async_trait]
+impl<T: Send + Sync + 'static> Middleware for BearerAuthMiddleware<T> {
+    async fn handle(&self, mut req: HttpRequest, next: Next) -> Result<HttpResponse, Error> {
+        if req.header("authorization").is_none() {
+            debug!("Bearer token missing for {}", req.path);
+            return Ok(unauthorized(self.challenge(None)));
+        }
+        let Some(token) = authorization_credentials(&req, "Bearer").map(str::to_string) else {
+            debug!("Malformed bearer authorization for {}", req.path);
+            return Ok(unauthorized(self.challenge(Some("invalid_request"))));
+        };
+
+        match (self.validator)(token).await {
+            Ok(user) => {
+                req.extensions.insert(Principal(user));
+                next(req).await
+            }
+            Err(err) if err.status_code() == 401 => {
+                debug!("Bearer token rejected for {}: {}", req.path, err);
+                Ok(unauthorized(self.challenge(Some("invalid_token"))))
+            }
+            Err(err) => Err(err),
+        }
+    }
+}
+
 /// Body size limit middleware
 pub struct BodySizeLimitMiddleware {
     max_size: usize,
@@ -276,7 +882,7 @@ impl BodySizeLimitMiddleware {
 #[async_trait]
 impl Middleware for BodySizeLimitMiddleware {
     async fn handle(&self, req: HttpRequest, next: Next) -> Result<HttpResponse, Error> {
-        if req.body.len() > self.max_size {
+        if req.body_ref().len() > self.max_size {
             return Err(Error::PayloadTooLarge(format!(
                 "Request body exceeds maximum size of {} bytes",
                 self.max_size
@@ -598,9 +1204,163 @@ mod tests {
         assert!(result.is_err());
     }
 
+    fn authorized(value: &str) -> HttpRequest {
+        let mut req = HttpRequest::new("GET".to_string(), "/admin".to_string());
+        req.headers
+            .insert("Authorization".to_string(), value.to_string());
+        req
+    }
+
+    fn basic(credentials: &str) -> HttpRequest {
+        use base64::Engine;
+        authorized(&format!(
+            "Basic {}",
+            base64::engine::general_purpose::STANDARD.encode(credentials)
+        ))
+    }
+
+    /// Next handler that echoes the authenticated username
+    fn echo_user() -> Next {
+        Box::new(|req: HttpRequest| {
+            Box::pin(async move {
+                let user = req.user::<String>().cloned().unwrap_or_default();
+                Ok(HttpResponse::ok().with_body(user.into_bytes()))
+            })
+        })
+    }
+
+    #[test]
+    fn test_constant_time_eq() {
+        assert!(constant_time_eq(b"secret", b"secret"));
+        assert!(constant_time_eq(b"", b""));
+        assert!(!constant_time_eq(b"secret", b"secreT"));
+        assert!(!constant_time_eq(b"secret", b"secret2"));
+        assert!(!constant_time_eq(b"", b"a"));
+    }
+
+    #[tokio::test]
+    async fn test_basic_auth_valid_credentials() {
+        let auth = BasicAuthMiddleware::with_credentials("admin", "p:ss");
+
+        let response = auth.handle(basic("admin:p:ss"), echo_user()).await.unwrap();
+        assert_eq!(response.status, 200);
+        assert_eq!(response.body, b"admin");
+
+        // The scheme is case-insensitive
+        let mut req = basic("admin:p:ss");
+        let value = req.headers["Authorization"].replacen("Basic", "bAsIc", 1);
+        req.headers.insert("Authorization".to_string(), value);
+        let response = auth.handle(req, echo_user()).await.unwrap();
+        assert_eq!(response.status, 200);
+    }
+
+    #[tokio::test]
+    async fn test_basic_auth_invalid_credentials() {
+        let auth = BasicAuthMiddleware::new(|user, pass| {
+            user == "admin" && constant_time_eq(pass.as_bytes(), b"secret")
+        })
+        .realm("Admin \"area\"");
+
+        for req in [
+            basic("admin:wrong"),
+            basic("root:secret"),
+            HttpRequest::new("GET".to_string(), "/admin".to_string()),
+        ] {
+            let response = auth.handle(req, echo_user()).await.unwrap();
+            assert_eq!(response.status, 401);
+            assert_eq!(
+                response.headers.get("WWW-Authenticate"),
+                Some(&"Basic realm=\"Admin \\\"area\\\"\", charset=\"UTF-8\"".to_string())
+            );
+        }
+    }
+
+    #[tokio::test]
+    async fn test_basic_auth_malformed_header() {
+        let auth = BasicAuthMiddleware::new(|_, _| true);
+
+        for req in [
+            authorized("Basic"),
+            authorized("Basic !!not-base64!!"),
+            authorized("YWRtaW46c2VjcmV0"),
+            authorized("Bearer YWRtaW46c2VjcmV0"),
+            // Valid base64 without a colon
+            authorized("Basic YWRtaW4="),
+            // Valid base64 that isn't UTF-8
+            authorized("Basic //79"),
+        ] {
+            let response = auth.handle(req, echo_user()).await.unwrap();
+            assert_eq!(response.status, 401);
+            assert!(response.headers.contains_key("WWW-Authenticate"));
+        }
+    }
+
+    fn bearer_auth() -> BearerAuthMiddleware<String> {
+        BearerAuthMiddleware::new(|token: String| async move {
+            match token.as_str() {
+                "good" => Ok("alice".to_string()),
+                "banned" => Err(Error::Forbidden("banned".to_string())),
+                _ => Err(Error::Unauthorized("unknown token".to_string())),
+            }
+        })
+        .realm("api")
+    }
+
+    #[tokio::test]
+    async fn test_bearer_auth_valid_token() {
+        let response = bearer_auth()
+            .handle(authorized("Bearer good"), echo_user())
+            .await
+            .unwrap();
+        assert_eq!(response.status, 200);
+        assert_eq!(response.body, b"alice");
+    }
+
+    #[tokio::test]
+    async fn test_bearer_auth_invalid_token() {
+        let response = bearer_auth()
+            .handle(authorized("Bearer forged"), echo_user())
+            .await
+            .unwrap();
+        assert_eq!(response.status, 401);
+        assert_eq!(
+            response.headers.get("WWW-Authenticate"),
+            Some(&"Bearer realm=\"api\", error=\"invalid_token\"".to_string())
+        );
+
+        // Errors other than Unauthorized pass through untouched
+        let result = bearer_auth()
+            .handle(authorized("Bearer banned"), echo_user())
+            .await;
+        assert!(matches!(result, Err(Error::Forbidden(_))));
+    }
+
+    #[tokio::test]
+    async fn test_bearer_auth_missing_or_malformed_header() {
+        let missing = HttpRequest::new("GET".to_string(), "/admin".to_string());
+        let response = bearer_auth().handle(missing, echo_user()).await.unwrap();
+        assert_eq!(response.status, 401);
+        assert_eq!(
+            response.headers.get("WWW-Authenticate"),
+            Some(&"Bearer realm=\"api\"".to_string())
+        );
+
+        for value in ["Bearer", "Bearer   ", "good", "Basic Z29vZA=="] {
+            let response = bearer_auth()
+                .handle(authorized(value), echo_user())
+                .await
+                .unwrap();
+            assert_eq!(response.status, 401, "{}", value);
+            assert_eq!(
+                response.headers.get("WWW-Authenticate"),
+                Some(&"Bearer realm=\"api\", error=\"invalid_request\"".to_string())
+            );
+        }
+    }
+
     #[tokio::test]
     async fn test_request_id_middleware() {
-        let middleware = RequestIdMiddleware;
+        let middleware = RequestIdMiddleware::new();
         let req = HttpRequest::new("GET".to_string(), "/test".to_string());
 
         let result = middleware
@@ -615,6 +1375,111 @@ mod tests {
         assert!(response.headers.contains_key("x-request-id"));
     }
 
+    /// Run `middleware` and return the ID seen by the handler and the response
+    async fn request_id_roundtrip(
+        middleware: RequestIdMiddleware,
+        req: HttpRequest,
+    ) -> (String, HttpResponse) {
+        let seen = Arc::new(std::sync::Mutex::new(String::new()));
+        let seen_in_handler = seen.clone();
+        let response = middleware
+            .handle(
+                req,
+                Box::new(move |req| {
+                    *seen_in_handler.lock().unwrap() = req.request_id().unwrap().to_string();
+                    Box::pin(async { Ok(HttpResponse::ok()) })
+                }),
+            )
+            .await
+            .unwrap();
+        let seen = seen.lock().unwrap().clone();
+        (seen, response)
+    }
+
+    #[tokio::test]
+    async fn test_request_id_passthrough() {
+        let mut req = HttpRequest::new("GET".to_string(), "/test".to_string());
+        req.headers
+            .insert("X-Request-ID".to_string(), "abc-123".to_string());
+
+        let (seen, response) = request_id_roundtrip(RequestIdMiddleware::new(), req).await;
+
+        assert_eq!(seen, "abc-123");
+        assert_eq!(
+            response.headers.get("x-request-id"),
+            Some(&"abc-123".to_string())
+        );
+    }
+
+    #[tokio::test]
+    async fn test_request_id_generated_when_missing_or_invalid() {
+        let req = HttpRequest::new("GET".to_string(), "/test".to_string());
+        let (seen, response) = request_id_roundtrip(RequestIdMiddleware::new(), req).await;
+        assert!(uuid::Uuid::parse_str(&seen).is_ok());
+        assert_eq!(response.headers.get("x-request-id"), Some(&seen));
+
+        let mut req = HttpRequest::new("GET".to_string(), "/test".to_string());
+        req.headers
+            .insert("x-request-id".to_string(), "bad\nid".to_string());
+        let (seen, _) = request_id_roundtrip(RequestIdMiddleware::new(), req).await;
+        assert!(uuid::Uuid::parse_str(&seen).is_ok());
+    }
+
+    #[tokio::test]
+    async fn test_request_id_always_regenerate() {
+        let mut req = HttpRequest::new("GET".to_string(), "/test".to_string());
+        req.headers
+            .insert("X-Request-ID".to_string(), "client-chosen".to_string());
+
+        let middleware = RequestIdMiddleware::new().always_regenerate();
+        let mut chain = MiddlewareChain::new();
+        chain.use_middleware(middleware);
+        let handler: HandlerFn = Arc::new(|req: HttpRequest| {
+            // The client's header must not survive under any casing
+            let forwarded: Vec<String> = req
+                .headers
+                .iter()
+                .filter(|(name, _)| name.eq_ignore_ascii_case("x-request-id"))
+                .map(|(_, value)| value.clone())
+                .collect();
+            let id = req.request_id().unwrap().to_string();
+            Box::pin(async move {
+                assert_eq!(forwarded, vec![id]);
+                Ok(HttpResponse::ok())
+            })
+        });
+        let response = chain.apply(req, handler).await.unwrap();
+
+        let id = response.headers.get("x-request-id").unwrap();
+        assert_ne!(id, "client-chosen");
+        assert!(uuid::Uuid::parse_str(id).is_ok());
+    }
+
+    #[tokio::test]
+    async fn test_request_id_custom_generator_and_header() {
+        let counter = Arc::new(std::sync::atomic::AtomicUsize::new(0));
+        let next_id = counter.clone();
+        let middleware = RequestIdMiddleware::new()
+            .header("X-Correlation-ID")
+            .generator(move || {
+                let n = next_id.fetch_add(1, std::sync::atomic::Ordering::SeqCst);
+                format!("req-{}", n)
+            });
+
+        let req = HttpRequest::new("GET".to_string(), "/test".to_string());
+        let (seen, response) = request_id_roundtrip(middleware.clone(), req).await;
+        assert_eq!(seen, "req-0");
+        assert_eq!(
+            response.headers.get("x-correlation-id"),
+            Some(&"req-0".to_string())
+        );
+        assert!(!response.headers.contains_key("x-request-id"));
+
+        let req = HttpRequest::new("GET".to_string(), "/test".to_string());
+        let (seen, _) = request_id_roundtrip(middleware, req).await;
+        assert_eq!(seen, "req-1");
+    }
+
     #[tokio::test]
     async fn test_security_headers_middleware() {
         let middleware = SecurityHeadersMiddleware::new();
@@ -695,7 +1560,7 @@ mod tests {
     async fn test_middleware_chain_multiple() {
         let mut chain = MiddlewareChain::new();
         chain.use_middleware(LoggerMiddleware::new());
-        chain.use_middleware(RequestIdMiddleware);
+        chain.use_middleware(RequestIdMiddleware::new());
         chain.use_middleware(SecurityHeadersMiddleware::new());
 
         let req = HttpRequest::new("GET".to_string(), "/test".to_string());
@@ -742,6 +1607,210 @@ mod tests {
         );
     }
 
+    fn cors_request(method: &str, origin: Option<&str>) -> HttpRequest {
+        let mut req = HttpRequest::new(method.to_string(), "/api".to_string());
+        if let Some(origin) = origin {
+            req.headers.insert("Origin".to_string(), origin.to_string());
+        }
+        req
+    }
+
+    fn preflight_request(origin: &str, method: &str) -> HttpRequest {
+        let mut req = cors_request("OPTIONS", Some(origin));
+        req.headers.insert(
+            "Access-Control-Request-Method".to_string(),
+            method.to_string(),
+        );
+        req
+    }
+
+    async fn run_cors(cors: &CorsMiddleware, req: HttpRequest) -> HttpResponse {
+        cors.handle(
+            req,
+            Box::new(|_req| Box::pin(async { Ok(HttpResponse::ok()) })),
+        )
+        .await
+        .unwrap()
+    }
+
+    #[test]
+    fn test_cors_allowlist_builder() {
+        let cors = CorsMiddleware::new()
+            .allow_origin("https://a.example")
+            .allow_origin("https://b.example");
+        assert_eq!(cors.allow_origin, "https://a.example, https://b.example");
+    }
+
+    #[tokio::test]
+    async fn test_cors_wildcard_replaces_allowlist() {
+        for cors in [
+            CorsMiddleware::new()
+                .allow_origin("https://a.example")
+                .allow_origin("*"),
+            CorsMiddleware::new()
+                .allow_origin("*")
+                .allow_origin("https://a.example"),
+        ] {
+            assert_eq!(cors.allow_origin, "*");
+            let response = run_cors(&cors, cors_request("GET", Some("https://b.example"))).await;
+            assert_eq!(
+                response.headers.get("Access-Control-Allow-Origin"),
+                Some(&"*".to_string())
+            );
+        }
+    }
+
+    #[tokio::test]
+    async fn test_cors_allowed_origin_echoed() {
+        let cors = CorsMiddleware::new()
+            .allow_origin("https://a.example")
+            .allow_origin("https://b.example")
+            .expose_headers("X-Total-Count");
+
+        let response = run_cors(&cors, cors_request("GET", Some("https://b.example"))).await;
+        assert_eq!(
+            response.headers.get("Access-Control-Allow-Origin"),
+            Some(&"https://b.example".to_string())
+        );
+        assert_eq!(
+            response.headers.get("Access-Control-Expose-Headers"),
+            Some(&"X-Total-Count".to_string())
+        );
+        assert_eq!(response.headers.get("Vary"), Some(&"Origin".to_string()));
+    }
+
+    #[tokio::test]
+    async fn test_cors_denied_origin() {
+        let cors = CorsMiddleware::new().allow_origin("https://a.example");
+
+        let response = run_cors(&cors, cors_request("GET", Some("https://evil.example"))).await;
+        assert_eq!(response.status, 200);
+        assert!(!response.headers.contains_key("Access-Control-Allow-Origin"));
+
+        let response = run_cors(&cors, preflight_request("https://evil.example", "POST")).await;
+        assert_eq!(response.status, 204);
+        assert!(!response.headers.contains_key("Access-Control-Allow-Origin"));
+        assert!(
+            !response
+                .headers
+                .contains_key("Access-Control-Allow-Methods")
+        );
+    }
+
+    #[tokio::test]
+    async fn test_cors_preflight_denied_method() {
+        let cors = CorsMiddleware::new().allow_methods("GET, POST");
+
+        let response = run_cors(&cors, preflight_request("https://a.example", "DELETE")).await;
+        assert!(!response.headers.contains_key("Access-Control-Allow-Origin"));
+
+        let response = run_cors(&cors, preflight_request("https://a.example", "post")).await;
+        assert_eq!(
+            response.headers.get("Access-Control-Allow-Methods"),
+            Some(&"GET, POST".to_string())
+        );
+        assert_eq!(
+            response.headers.get("Access-Control-Max-Age"),
+            Some(&"86400".to_string())
+        );
+    }
+
+    #[tokio::test]
+    async fn test_cors_wildcard() {
+        let cors = CorsMiddleware::new();
+
+        let response = run_cors(&cors, cors_request("GET", Some("https://a.example"))).await;
+        assert_eq!(
+            response.headers.get("Access-Control-Allow-Origin"),
+            Some(&"*".to_string())
+        );
+        assert!(!response.headers.contains_key("Vary"));
+        assert!(
+            !response
+                .headers
+                .contains_key("Access-Control-Allow-Credentials")
+        );
+    }
+
+    #[tokio::test]
+    async fn test_cors_credentials_never_wildcard() {
+        let cors = CorsMiddleware::new()
+            .allow_credentials(true)
+            .allow_headers("*");
+
+        let response = run_cors(&cors, cors_request("GET", Some("https://a.example"))).await;
+        assert_eq!(
+            response.headers.get("Access-Control-Allow-Origin"),
+            Some(&"https://a.example".to_string())
+        );
+        assert_eq!(
+            response.headers.get("Access-Control-Allow-Credentials"),
+            Some(&"true".to_string())
+        );
+        assert_eq!(response.headers.get("Vary"), Some(&"Origin".to_string()));
+
+        let mut req = preflight_request("https://a.example", "PUT");
+        req.headers.insert(
+            "Access-Control-Request-Headers".to_string(),
+            "X-Custom".to_string(),
+        );
+        let response = run_cors(&cors, req).await;
+        assert_eq!(
+            response.headers.get("Access-Control-Allow-Origin"),
+            Some(&"https://a.example".to_string())
+        );
+        assert_eq!(
+            response.headers.get("Access-Control-Allow-Headers"),
+            Some(&"X-Custom".to_string())
+        );
+        assert_eq!(
+            response.headers.get("Access-Control-Allow-Credentials"),
+            Some(&"true".to_string())
+        );
+    }
+
+    #[tokio::test]
+    async fn test_cors_origin_validator() {
+        let cors = CorsMiddleware::new()
+            .allow_origin_fn(|origin| origin.ends_with(".example.com"))
+            .max_age(600);
+
+        let response = run_cors(&cors, cors_request("GET", Some("https://app.example.com"))).await;
+        assert_eq!(
+            response.headers.get("Access-Control-Allow-Origin"),
+            Some(&"https://app.example.com".to_string())
+        );
+
+        let response = run_cors(&cors, cors_request("GET", Some("https://example.org"))).await;
+        assert!(!response.headers.contains_key("Access-Control-Allow-Origin"));
+
+        let response = run_cors(&cors, preflight_request("https://api.example.com", "GET")).await;
+        assert_eq!(
+            response.headers.get("Access-Control-Max-Age"),
+            Some(&"600".to_string())
+        );
+    }
+
+    #[tokio::test]
+    async fn test_cors_preflight_without_route() {
+        let mut router = crate::Router::new();
+        router.use_middleware(CorsMiddleware::new().allow_origin("https://a.example"));
+
+        let response = router
+            .route(preflight_request("https://a.example", "POST"))
+            .await
+            .unwrap();
+        assert_eq!(response.status, 204);
+        assert_eq!(
+            response.headers.get("Access-Control-Allow-Origin"),
+            Some(&"https://a.example".to_string())
+        );
+
+        // Plain OPTIONS without CORS headers still falls through to routing
+        let result = router.route(cors_request("OPTIONS", None)).await;
+        assert!(result.is_err());
+    }
+
     #[tokio::test]
     async fn test_body_size_within_limit() {
         let middleware = BodySizeLimitMiddleware::new(100);
@@ -473,6 +473,22 @@ impl PipelinedHttp1Builder {
 
         builder
     }
+
+    /// Apply the same HTTP/1.1 settings to a protocol-detecting builder.
+    ///
+    /// Used when cleartext HTTP/2 (h2c) is enabled, so HTTP/1.1 clients on
+    /// the same listener keep the pipelining configuration.
+    pub fn configure_auto_builder<E>(
+        &self,
+        builder: &mut hyper_util::server::conn::auto::Builder<E>,
+    ) {
+        builder
+            .http1()
+            .pipeline_flush(self.config.pipeline_flush)
+            .max_buf_size(self.config.read_buffer_size)
+            .preserve_header_case(true)
+            .keep_alive(true);
+    }
 }
 
 // ============================================================================
@@ -0,0 +1,438 @@
+//! Panic recovery middleware.
+//!
+//! A panic inside a handler (or any middleware further down the chain)
+//! normally unwinds through the connection task and drops the connection.
+//! [`RecoverMiddleware`] catches the panic, logs it, and turns it into a
+//! `500 Internal Server Error` so the server keeps serving other requests.
+//!
+//! Unwinding still runs every `Drop` implementation in the panicking
+//! handler, so guards, pooled connections and other RAII cleanup behave
+//! exactly as they would on a normal return.
+//!
+//! ## Quick Start
+//!
+//! ```rust
+//! use armature_core::recover::RecoverMiddleware;
+//!
+//! // Log panics with a backtrace and respond with a generic 500
+//! let recover = RecoverMiddleware::new();
+//!
+//! // Hook panics into custom alerting
+//! let recover = RecoverMiddleware::new()
+//!     .with_stack_trace(false)
+//!     .error_handler(|panic| {
+//!         eprintln!("panic on {} {}: {}", panic.method, panic.path, panic.message);
+//!         Ok(armature_core::HttpResponse::service_unavailable())
+//!     });
+//! ```
+//!
+//! ## Streaming responses
+//!
+//! Nothing is sent to the client until the middleware chain returns, so a
+//! panic caught here can never collide with a partially written response:
+//! the 500 replaces whatever the handler had built so far. Bodies produced by
+//! a separately spawned task (see [`crate::streaming`]) are outside the chain;
+//! a panic there ends the stream, and the original status is left untouched.
+
+use crate::error_hooks::HookScope;
+use crate::logging::error;
+use crate::middleware::{Middleware, Next};
+use crate::{Error, HttpRequest, HttpResponse};
+use async_trait::async_trait;
+use futures_util::FutureExt;
+use std::any::Any;
+use std::backtrace::Backtrace;
+use std::cell::{Cell, RefCell};
+use std::future::Future;
+use std::panic::{self, AssertUnwindSafe};
+use std::pin::Pin;
+use std::sync::{Arc, Once};
+use std::task::{Context, Poll};
+
+/// Callback invoked with a recovered panic to build the response.
+pub type PanicHandler = Arc<dyn Fn(&RecoveredPanic) -> Result<HttpResponse, Error> + Send + Sync>;
+
+/// Details of a panic caught by [`RecoverMiddleware`].
+#[derive(Debug, Clone)]
+pub struct RecoveredPanic {
+    /// HTTP method of the request that panicked
+    pub method: String,
+    /// Path of the request that panicked
+    pub path: String,
+    /// Panic message, or a placeholder if the payload was not a string or error
+    pub message: String,
+    /// Source location of the panic, when known
+    pub location: Option<String>,
+    /// Backtrace captured at the panic site, when stack traces are enabled
+    pub backtrace: Option<String>,
+}
+
+impl RecoveredPanic {
+    fn new(method: String, path: String, payload: &(dyn Any + Send)) -> Self {
+        Self {
+            method,
+            path,
+            message: panic_message(payload),
+            location: None,
+            backtrace: None,
+        }
+    }
+}
+
+/// Middleware that converts panics into `500 Internal Server Error` responses.
+///
+/// By default the panic message and backtrace are logged but not sent to the
+/// client. Use [`RecoverMiddleware::expose_message`] to include the message
+/// in the error, or [`RecoverMiddleware::error_handler`] to build the
+/// response yourself.
+#[derive(Clone)]
+pub struct RecoverMiddleware {
+    stack_trace: bool,
+    expose_message: bool,
+    handler: Option<PanicHandler>,
+}
+
+impl RecoverMiddleware {
+    /// Create a recover middleware that logs backtraces and hides panic details.
+    pub fn new() -> Self {
+        Self {
+            stack_trace: true,
+            expose_message: false,
+            handler: None,
+        }
+    }
+
+    /// Capture and log a backtrace for each panic (default: `true`).
+    pub fn with_stack_trace(mut self, enabled: bool) -> Self {
+        self.stack_trace = enabled;
+        self
+    }
+
+    /// Include the panic message in the error returned to the client
+    /// (default: `false`).
+    ///
+    /// Panic messages regularly contain internal details, so only enable
+    /// this in development.
+    pub fn expose_message(mut self, expose: bool) -> Self {
+        self.expose_message = expose;
+        self
+    }
+
+    /// Build the response for a recovered panic with a custom callback.
+    ///
+    /// The panic is still logged before the callback runs.
+    pub fn error_handler<F>(mut self, handler: F) -> Self
+    where
+        F: Fn(&RecoveredPanic) -> Result<HttpResponse, Error> + Send + Sync + 'static,
+    {
+        self.handler = Some(Arc::new(handler));
+        self
+    }
+
+    fn respond(&self, panic: &RecoveredPanic) -> Result<HttpResponse, Error> {
+        if let Some(handler) = &self.handler {
+            return handler(panic);
+        }
+
+        if self.expose_message {
+            Err(Error::Internal(panic.message.clone()))
+        } else {
+            Err(Error::Internal("request handler panicked".to_string()))
+        }
+    }
+}
+
+impl Default for RecoverMiddleware {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+impl std::fmt::Debug for RecoverMiddleware {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.debug_struct("RecoverMiddleware")
+            .field("stack_trace", &self.stack_trace)
+            .field("expose_message", &self.expose_message)
+            .field("handler", &self.handler.is_some())
+            .finish()
+    }
+}
+
+#[async_trait]
+impl Middleware for RecoverMiddleware {
+    async fn handle(&self, req: HttpRequest, next: Next) -> Result<HttpResponse, Error> {
+        install_panic_hook();
+
+        let method = req.method.clone();
+        let path = req.path.clone();
+        let hooks = req.extensions.get::<HookScope>().cloned();
+
+        // Call `next` on the first poll, so a handler that panics while
+        // building its future is caught too
+        let scoped = PanicScope {
+            inner: Box::pin(async move { next(req).await }),
+            stack_trace: self.stack_trace,
+        };
+        let result = AssertUnwindSafe(scoped).catch_unwind().await;
+
+        let payload = match result {
+            Ok(result) => return result,
+            Err(payload) => payload,
+        };
+
+        let mut panic = RecoveredPanic::new(method, path, payload.as_ref());
+        if let Some((location, backtrace)) = LAST_PANIC.with(|p| p.borrow_mut().take()) {
+            panic.location = Some(location);
+            panic.backtrace = backtrace;
+        }
+
+        error!(
+            method = %panic.method,
+            path = %panic.path,
+            location = panic.location.as_deref().unwrap_or("unknown"),
+            "Recovered from panic in request handler: {}",
+            panic.message
+        );
+        if let Some(backtrace) = &panic.backtrace {
+            error!("Panic backtrace:\n{}", backtrace);
+        }
+        if let Some(hooks) = hooks {
+            hooks.report_panic(&panic);
+        }
+
+        self.respond(&panic)
+    }
+}
+
+thread_local! {
+    /// Set while a [`PanicScope`] is polling on this thread; the value says
+    /// whether to capture a backtrace.
+    static PANIC_SCOPE: Cell<Option<bool>> = const { Cell::new(None) };
+    /// Location and backtrace of the most recent panic inside a scope.
+    static LAST_PANIC: RefCell<Option<(String, Option<String>)>> = const { RefCell::new(None) };
+}
+
+/// Future wrapper that marks the current thread as inside a recover scope
+/// for the duration of each poll.
+///
+/// A task can move between worker threads at every `.await`, so the marker
+/// has to be set per poll rather than once per request. The panic hook and
+/// `catch_unwind` then always run on the same thread within the same poll.
+struct PanicScope<F> {
+    inner: F,
+    stack_trace: bool,
+}
+
+impl<F: Future + Unpin> Future for PanicScope<F> {
+    type Output = F::Output;
+
+    fn poll(mut self: Pin<&mut Self>, cx: &mut Context<'_>) -> Poll<Self::Output> {
+        struct Restore(Option<bool>);
+        impl Drop for Restore {
+            fn drop(&mut self) {
+                PANIC_SCOPE.with(|s| s.set(self.0));
+            }
+        }
+
+        let _restore = Restore(PANIC_SCOPE.with(|s| s.replace(Some(self.stack_trace))));
+        Pin::new(&mut self.inner).poll(cx)
+    }
+}
+
+/// Install a process-wide panic hook that records the panic location and
+/// backtrace for [`RecoverMiddleware`], then defers to the previous hook.
+///
+/// Panics outside a [`PanicScope`] are passed straight to the previous hook.
+fn install_panic_hook() {
+    static INSTALL: Once = Once::new();
+    INSTALL.call_once(|| {
+        let previous = panic::take_hook();
+        panic::set_hook(Box::new(move |info| {
+            if let Some(stack_trace) = PANIC_SCOPE.with(|s| s.get()) {
+                let location = info
+                    .location()
+                    .map(|l| format!("{}:{}:{}", l.file(), l.line(), l.column()))
+                    .unwrap_or_else(|| "unknown".to_string());
+                let backtrace = stack_trace.then(|| Backtrace::force_capture().to_string());
+                LAST_PANIC.with(|p| *p.borrow_mut() = Some((location, backtrace)));
+            }
+            previous(info);
+        }));
+    });
+}
+
+/// Extract a human-readable message from a panic payload.
+fn panic_message(payload: &(dyn Any + Send)) -> String {
+    if let Some(s) = payload.downcast_ref::<&'static str>() {
+        (*s).to_string()
+    } else if let Some(s) = payload.downcast_ref::<String>() {
+        s.clone()
+    } else if let Some(err) = payload.downcast_ref::<Error>() {
+        err.to_string()
+    } else if let Some(err) = payload.downcast_ref::<Box<dyn std::error::Error + Send + Sync>>() {
+        err.to_string()
+    } else {
+        "panic with non-string payload".to_string()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::middleware::MiddlewareChain;
+    use std::sync::atomic::{AtomicBool, Ordering};
+
+    async fn run(
+        recover: RecoverMiddleware,
+        handler: crate::middleware::HandlerFn,
+    ) -> Result<HttpResponse, Error> {
+        let mut chain = MiddlewareChain::new();
+        chain.use_middleware(recover);
+        chain
+            .apply(HttpRequest::new("GET".into(), "/boom".into()), handler)
+            .await
+    }
+
+    fn panicking<P, F>(payload: F) -> crate::middleware::HandlerFn
+    where
+        P: Any + Send + 'static,
+        F: Fn() -> P + Send + Sync + 'static,
+    {
+        Arc::new(move |_req| {
+            let payload = payload();
+            Box::pin(async move { panic::panic_any(payload) })
+        })
+    }
+
+    #[tokio::test]
+    async fn test_passes_through_normal_responses() {
+        let handler: crate::middleware::HandlerFn =
+            Arc::new(|_req| Box::pin(async { Ok(HttpResponse::ok()) }));
+        let response = run(RecoverMiddleware::new(), handler).await.unwrap();
+        assert_eq!(response.status, 200);
+
+        let failing: crate::middleware::HandlerFn =
+            Arc::new(|_req| Box::pin(async { Err(Error::BadRequest("nope".into())) }));
+        let err = run(RecoverMiddleware::new(), failing).await.unwrap_err();
+        assert_eq!(err.status_code(), 400);
+    }
+
+    #[tokio::test]
+    async fn test_recovers_str_panic_without_leaking_message() {
+        let err = run(RecoverMiddleware::new(), panicking(|| "secret db password"))
+            .await
+            .unwrap_err();
+        assert_eq!(err.status_code(), 500);
+        assert!(!err.to_string().contains("secret"));
+    }
+
+    #[tokio::test]
+    async fn test_recovers_panic_before_the_future_is_built() {
+        let handler: crate::middleware::HandlerFn = Arc::new(|_req| panic!("unsupported"));
+        let recover = RecoverMiddleware::new().expose_message(true);
+        let err = run(recover, handler).await.unwrap_err();
+        assert_eq!(err.status_code(), 500);
+        assert!(err.to_string().contains("unsupported"));
+    }
+
+    #[tokio::test]
+    async fn test_expose_message() {
+        let recover = RecoverMiddleware::new().expose_message(true);
+        let err = run(recover, panicking(|| String::from("index out of range")))
+            .await
+            .unwrap_err();
+        assert_eq!(err.status_code(), 500);
+        assert!(err.to_string().contains("index out of range"));
+    }
+
+    #[tokio::test]
+    async fn test_recovers_error_payload() {
+        let seen = Arc::new(std::sync::Mutex::new(None));
+        let seen_clone = seen.clone();
+        let recover = RecoverMiddleware::new().error_handler(move |panic| {
+            *seen_clone.lock().unwrap() = Some(panic.clone());
+            Ok(HttpResponse::new(500).with_body(b"custom".to_vec()))
+        });
+
+        let response = run(recover, panicking(|| Error::Validation("bad state".into())))
+            .await
+            .unwrap();
+        assert_eq!(response.status, 500);
+        assert_eq!(response.body, b"custom");
+
+        let panic = seen.lock().unwrap().take().unwrap();
+        assert_eq!(panic.method, "GET");
+        assert_eq!(panic.path, "/boom");
+        assert!(panic.message.contains("bad state"));
+        assert!(panic.location.unwrap().contains("recover.rs"));
+        assert!(panic.backtrace.is_some());
+    }
+
+    #[tokio::test]
+    async fn test_recovers_unit_payload() {
+        let seen = Arc::new(std::sync::Mutex::new(None));
+        let seen_clone = seen.clone();
+        let recover = RecoverMiddleware::new()
+            .with_stack_trace(false)
+            .error_handler(move |panic| {
+                *seen_clone.lock().unwrap() = Some(panic.clone());
+                Err(Error::Internal("recovered".into()))
+            });
+
+        let err = run(recover, panicking(|| ())).await.unwrap_err();
+        assert_eq!(err.status_code(), 500);
+
+        let panic = seen.lock().unwrap().take().unwrap();
+        assert_eq!(panic.message, "panic with non-string payload");
+        assert!(panic.backtrace.is_none());
+    }
+
+    #[tokio::test(flavor = "multi_thread", worker_threads = 2)]
+    async fn test_runs_drop_cleanup() {
+        struct Cleanup(Arc<AtomicBool>);
+        impl Drop for Cleanup {
+            fn drop(&mut self) {
+                self.0.store(true, Ordering::SeqCst);
+            }
+        }
+
+        let cleaned = Arc::new(AtomicBool::new(false));
+        let flag = cleaned.clone();
+        let handler: crate::middleware::HandlerFn = Arc::new(move |_req| {
+            let flag = flag.clone();
+            Box::pin(async move {
+                let _guard = Cleanup(flag);
+                tokio::task::yield_now().await;
+                panic!("after await");
+            })
+        });
+
+        let err = run(RecoverMiddleware::new(), handler).await.unwrap_err();
+        assert_eq!(err.status_code(), 500);
+        assert!(cleaned.load(Ordering::SeqCst));
+    }
+
+    #[tokio::test]
+    async fn test_partial_response_is_discarded() {
+        // The handler has already built a response with headers when it
+        // panics; only the recovery response must reach the client.
+        let handler: crate::middleware::HandlerFn = Arc::new(|_req| {
+            Box::pin(async {
+                let response = HttpResponse::ok()
+                    .with_header("X-Partial".to_string(), "yes".to_string())
+                    .with_body(b"half".to_vec());
+                if response.status == 200 {
+                    panic!("failed while writing body");
+                }
+                Ok(response)
+            })
+        });
+
+        let recover =
+            RecoverMiddleware::new().error_handler(|_| Ok(HttpResponse::internal_server_error()));
+        let response = run(recover, handler).await.unwrap();
+        assert_eq!(response.status, 500);
+        assert!(response.headers.get("X-Partial").is_none());
+        assert!(response.body.is_empty());
+    }
+}
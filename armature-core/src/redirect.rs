@@ -0,0 +1,239 @@
+//! Redirect responses.
+//!
+//! [`HttpRequest::redirect`] builds a redirect from inside a handler. The
+//! target may be relative; it is resolved against the path the client
+//! requested, following [RFC 3986 section 5.2], so the `Location` header is
+//! always an absolute path or an absolute URL.
+//!
+//! [RFC 3986 section 5.2]: https://www.rfc-editor.org/rfc/rfc3986#section-5.2
+//!
+//! # Examples
+//!
+//! ```
+//! use armature_core::HttpRequest;
+//!
+//! let req = HttpRequest::new("GET".into(), "/account/settings".into());
+//!
+//! let response = req.redirect(302, "../login").unwrap();
+//! assert_eq!(response.status, 302);
+//! assert_eq!(response.headers.get("Location"), Some(&"/login".to_string()));
+//!
+//! // Header injection is rejected
+//! assert!(req.redirect(302, "/\r\nSet-Cookie: a=b").is_err());
+//! ```
+
+use crate::content_negotiation::html_escape;
+use crate::status::HttpStatus;
+use crate::{Error, HttpRequest, HttpResponse};
+
+impl HttpRequest {
+    /// Redirect the client to `url` with the given 3xx `status`.
+    ///
+    /// Relative URLs are resolved against the requested path (including any
+    /// [`mount_prefix`](Self::mount_prefix)), so `"../login"` from
+    /// `/account/settings` redirects to `/login`. Absolute URLs, with a scheme
+    /// or starting with `//`, are used as given.
+    ///
+    /// The response has a small HTML body linking to the target, for clients
+    /// that don't follow redirects.
+    ///
+    /// # Errors
+    ///
+    /// - [`Error::Internal`] if `status` is not a redirect status (3xx other
+    ///   than 304).
+    /// - [`Error::BadRequest`] if `url` contains control characters such as CR
+    ///   or LF, which could otherwise inject headers. Redirect targets often
+    ///   come from the request, e.g. a `?next=` parameter.
+    pub fn redirect(&self, status: u16, url: &str) -> Result<HttpResponse, Error> {
+        if !(300..400).contains(&status) || status == 304 {
+            return Err(Error::Internal(format!(
+                "{} is not a redirect status",
+                status
+            )));
+        }
+        if url.chars().any(|c| c.is_control()) {
+            return Err(Error::BadRequest(
+                "redirect URL contains control characters".to_string(),
+            ));
+        }
+
+        let path = self.path.split('?').next().unwrap_or_default();
+        let base = format!("{}{}", self.mount_prefix(), path);
+        let location = resolve_reference(&base, self.query_string(), url);
+
+        let reason = HttpStatus::from_code(status).map_or("Redirect", |s| s.reason());
+        let body = format!("<a href=\"{}\">{}</a>.\n", html_escape(&location), reason);
+        Ok(HttpResponse::new(status)
+            .with_header("Location".to_string(), location)
+            .with_header(
+                "Content-Type".to_string(),
+                "text/html; charset=utf-8".to_string(),
+            )
+            .with_body(body.into_bytes()))
+    }
+}
+
+/// Resolve `reference` against an absolute base path and its query string.
+fn resolve_reference(base_path: &str, base_query: Option<&str>, reference: &str) -> String {
+    if reference.starts_with("//") || has_scheme(reference) {
+        return reference.to_string();
+    }
+
+    let split = reference.find(['?', '#']).unwrap_or(reference.len());
+    let (path, suffix) = reference.split_at(split);
+
+    if path.is_empty() {
+        // Only a query and/or fragment; a lone fragment keeps the query
+        return match base_query {
+            Some(query) if suffix.starts_with('#') || suffix.is_empty() => {
+                format!("{}?{}{}", base_path, query, suffix)
+            }
+            _ => format!("{}{}", base_path, suffix),
+        };
+    }
+
+    let merged = if path.starts_with('/') {
+        path.to_string()
+    } else {
+        let dir = &base_path[..base_path.rfind('/').map_or(0, |i| i + 1)];
+        format!("/{}{}", dir.trim_start_matches('/'), path)
+    };
+    format!("{}{}", remove_dot_segments(&merged), suffix)
+}
+
+/// Whether a URI reference starts with a scheme such as `https:`.
+fn has_scheme(reference: &str) -> bool {
+    let Some(colon) = reference.find(':') else {
+        return false;
+    };
+    let scheme = &reference[..colon];
+    scheme.starts_with(|c: char| c.is_ascii_alphabetic())
+        && scheme
+            .chars()
+            .all(|c| c.is_ascii_alphanumeric() || matches!(c, '+' | '-' | '.'))
+}
+
+/// Remove `.` and `..` segments from an absolute path.
+pub(crate) fn remove_dot_segments(path: &str) -> String {
+    let segments: Vec<&str> = path.trim_start_matches('/').split('/').collect();
+    let mut output: Vec<&str> = Vec::with_capacity(segments.len());
+    for (i, segment) in segments.iter().enumerate() {
+        let last = i == segments.len() - 1;
+        match *segment {
+            "." => {}
+            ".." => {
+                output.pop();
+            }
+            segment => {
+                output.push(segment);
+                continue;
+            }
+        }
+        // `a/.` and `a/..` name a directory
+        if last {
+            output.push("");
+        }
+    }
+    format!("/{}", output.join("/"))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn location(response: &HttpResponse) -> &str {
+        response.headers.get("Location").unwrap()
+    }
+
+    #[test]
+    fn test_redirect_sets_location_and_body() {
+        let req = HttpRequest::new("GET".to_string(), "/old".to_string());
+        let response = req.redirect(301, "/new?a=1&b=2").unwrap();
+
+        assert_eq!(response.status, 301);
+        assert_eq!(location(&response), "/new?a=1&b=2");
+        assert_eq!(
+            response.body_ref(),
+            b"<a href=\"/new?a=1&amp;b=2\">Moved Permanently</a>.\n"
+        );
+    }
+
+    #[test]
+    fn test_redirect_validates_status() {
+        let req = HttpRequest::new("GET".to_string(), "/".to_string());
+        for status in [200, 299, 304, 400, 500] {
+            assert!(
+                matches!(req.redirect(status, "/"), Err(Error::Internal(_))),
+                "{}",
+                status
+            );
+        }
+        for status in [300, 301, 302, 303, 307, 308] {
+            assert_eq!(req.redirect(status, "/").unwrap().status, status);
+        }
+    }
+
+    #[test]
+    fn test_redirect_rejects_header_injection() {
+        let req = HttpRequest::new("GET".to_string(), "/".to_string());
+        for url in [
+            "/home\r\nSet-Cookie: session=evil",
+            "/home\nLocation: https://evil.example",
+            "/home\r",
+            "https://example.com/\u{0}",
+            "/home\u{85}",
+        ] {
+            assert!(
+                matches!(req.redirect(302, url), Err(Error::BadRequest(_))),
+                "{:?}",
+                url
+            );
+        }
+
+        // Percent-encoded line breaks are only text
+        let response = req.redirect(302, "/home%0d%0aX: y").unwrap();
+        assert_eq!(location(&response), "/home%0d%0aX: y");
+    }
+
+    #[test]
+    fn test_redirect_resolves_relative_urls() {
+        let mut req = HttpRequest::new("GET".to_string(), "/account/settings".to_string());
+        req.set_query_string("tab=2");
+
+        for (url, expected) in [
+            ("../login", "/login"),
+            ("profile", "/account/profile"),
+            ("./profile?x=1", "/account/profile?x=1"),
+            ("../../../../login", "/login"),
+            (".", "/account/"),
+            ("..", "/"),
+            ("a/./b/../c", "/account/a/c"),
+            ("/docs/../help", "/help"),
+            ("?tab=3", "/account/settings?tab=3"),
+            ("#top", "/account/settings?tab=2#top"),
+            ("", "/account/settings?tab=2"),
+            ("https://example.com/a/../b", "https://example.com/a/../b"),
+            ("//cdn.example.com/x", "//cdn.example.com/x"),
+            ("mailto:hi@example.com", "mailto:hi@example.com"),
+            ("a:b/c", "a:b/c"),
+            ("./a:b", "/account/a:b"),
+        ] {
+            assert_eq!(
+                location(&req.redirect(302, url).unwrap()),
+                expected,
+                "{}",
+                url
+            );
+        }
+    }
+
+    #[test]
+    fn test_redirect_resolves_against_mount_prefix() {
+        let mut req = HttpRequest::new("GET".to_string(), "/users/7".to_string());
+        req.extensions
+            .insert(crate::routing::MountPrefix("/api".to_string()));
+
+        let response = req.redirect(303, "../teams").unwrap();
+        assert_eq!(location(&response), "/api/teams");
+    }
+}
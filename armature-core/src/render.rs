@@ -0,0 +1,175 @@
+//! Server-side template rendering.
+//!
+//! Register a [`Renderer`] on the router with [`Router::renderer`], then
+//! render named templates from handlers with [`HttpRequest::render`]. Any
+//! template engine can be plugged in by implementing [`Renderer`]; closures
+//! with the same signature work too. With the `templates` feature,
+//! `templates::HandlebarsRenderer` loads Handlebars templates from a
+//! directory.
+//!
+//! # Examples
+//!
+//! ```
+//! use armature_core::{Error, HttpRequest, Router};
+//!
+//! let mut router = Router::new();
+//! router.renderer(|name: &str, data: &serde_json::Value| match name {
+//!     "hello" => Ok(format!("<h1>Hello, {}</h1>", data["name"].as_str().unwrap_or("?"))),
+//!     _ => Err(Error::Internal(format!("template {:?} not found", name))),
+//! });
+//! router.get("/hello", |req: HttpRequest| async move {
+//!     req.render(200, "hello", &serde_json::json!({ "name": "Ada" })).await
+//! });
+//! ```
+
+use crate::{Error, HttpRequest, HttpResponse, Router};
+use async_trait::async_trait;
+use serde::Serialize;
+use std::sync::Arc;
+
+/// A template engine that renders named templates to HTML.
+///
+/// Implementations must escape data as appropriate for HTML; the output is
+/// sent as is. Rendering runs on the async executor, so file I/O such as
+/// reloading templates belongs in `tokio::fs` or `spawn_blocking`.
+#[async_trait]
+pub trait Renderer: Send + Sync {
+    /// Render the template `name` with `data`.
+    ///
+    /// Returns an error if there is no template called `name` or rendering
+    /// fails.
+    async fn render(&self, name: &str, data: &serde_json::Value) -> Result<String, Error>;
+}
+
+#[async_trait]
+impl<F> Renderer for F
+where
+    F: Fn(&str, &serde_json::Value) -> Result<String, Error> + Send + Sync,
+{
+    async fn render(&self, name: &str, data: &serde_json::Value) -> Result<String, Error> {
+        self(name, data)
+    }
+}
+
+/// Renderer of the router that dispatched a request, kept in the request
+/// extensions
+#[derive(Clone)]
+pub(crate) struct RendererHandle(pub(crate) Arc<dyn Renderer>);
+
+impl Router {
+    /// Set the template engine used by [`HttpRequest::render`].
+    ///
+    /// Mounted routers use the renderer of the router they're mounted on
+    /// unless they set their own.
+    pub fn renderer(&mut self, renderer: impl Renderer + 'static) -> &mut Self {
+        self.renderer = Some(Arc::new(renderer));
+        self
+    }
+}
+
+impl HttpRequest {
+    /// Render the template `name` with `data` as an HTML response.
+    ///
+    /// The response has the given `status` and `Content-Type: text/html;
+    /// charset=utf-8`.
+    ///
+    /// # Errors
+    ///
+    /// - [`Error::Internal`] if no renderer is registered with
+    ///   [`Router::renderer`].
+    /// - [`Error::Serialization`] if `data` can't be serialized.
+    /// - Any error from the renderer, such as a missing template.
+    pub async fn render<T: Serialize + ?Sized>(
+        &self,
+        status: u16,
+        name: &str,
+        data: &T,
+    ) -> Result<HttpResponse, Error> {
+        let renderer = self
+            .extensions
+            .get::<RendererHandle>()
+            .ok_or_else(|| Error::Internal("no template renderer is registered".to_string()))?
+            .0
+            .clone();
+        let data =
+            serde_json::to_value(data).map_err(|err| Error::Serialization(err.to_string()))?;
+        let html = renderer.render(name, &data).await?;
+
+        let mut response = HttpResponse::html(html);
+        response.status = status;
+        Ok(response)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn templates(name: &str, data: &serde_json::Value) -> Result<String, Error> {
+        match name {
+            "greeting" => Ok(format!("<p>Hi {}</p>", data["name"].as_str().unwrap_or(""))),
+            _ => Err(Error::Internal(format!("template {:?} not found", name))),
+        }
+    }
+
+    fn request() -> HttpRequest {
+        let mut req = HttpRequest::new("GET".into(), "/".into());
+        req.extensions.insert(RendererHandle(Arc::new(templates)));
+        req
+    }
+
+    #[tokio::test]
+    async fn test_render() {
+        let response = request()
+            .render(201, "greeting", &serde_json::json!({ "name": "Ada" }))
+            .await
+            .unwrap();
+        assert_eq!(response.status, 201);
+        assert_eq!(
+            response.headers.get("Content-Type"),
+            Some(&"text/html; charset=utf-8".to_string())
+        );
+        assert_eq!(response.body_ref(), b"<p>Hi Ada</p>");
+    }
+
+    #[tokio::test]
+    async fn test_missing_template() {
+        let err = request().render(200, "nope", &()).await.unwrap_err();
+        assert!(err.to_string().contains("\"nope\" not found"), "{}", err);
+    }
+
+    #[tokio::test]
+    async fn test_no_renderer() {
+        let req = HttpRequest::new("GET".into(), "/".into());
+        assert!(matches!(
+            req.render(200, "greeting", &()).await,
+            Err(Error::Internal(_))
+        ));
+    }
+
+    #[tokio::test]
+    async fn test_mounted_router_inherits_renderer() {
+        let mut admin = Router::new();
+        admin.get("/", |req: HttpRequest| async move {
+            req.render(200, "greeting", &serde_json::json!({ "name": "admin" }))
+                .await
+        });
+
+        let mut themed = Router::new();
+        themed.renderer(|_: &str, _: &serde_json::Value| Ok("themed".to_string()));
+        themed.get("/", |req: HttpRequest| async move {
+            req.render(200, "greeting", &()).await
+        });
+
+        let mut router = Router::new();
+        router.renderer(templates);
+        router.mount("/admin", admin).unwrap();
+        router.mount("/themed", themed).unwrap();
+
+        let get = |path: &str| HttpRequest::new("GET".into(), path.into());
+        let response = router.route(get("/admin")).await.unwrap();
+        assert_eq!(response.body_ref(), b"<p>Hi admin</p>");
+        let response = router.route(get("/themed")).await.unwrap();
+        assert_eq!(response.body_ref(), b"themed");
+    }
+}
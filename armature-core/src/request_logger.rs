@@ -0,0 +1,662 @@
+//! Structured request logging middleware.
+//!
+//! [`RequestLogger`] records one entry per request with the method, path,
+//! status, latency, response size and request ID. Entries can be emitted as
+//! `tracing` events (so the format configured through
+//! [`LogConfig`](crate::logging::LogConfig), e.g. JSON in production,
+//! applies), as one JSON object per line, or as a compact line for the
+//! development console.
+//!
+//! ## Quick Start
+//!
+//! ```rust
+//! use armature_core::request_logger::RequestLogger;
+//! use serde_json::json;
+//!
+//! // tracing events, skipping health checks
+//! let logger = RequestLogger::new().skip_path("/health");
+//!
+//! // JSON lines on stdout with a custom field taken from the request
+//! let logger = RequestLogger::new().json().field_extractor(|req| {
+//!     req.header("x-tenant")
+//!         .map(|tenant| vec![("tenant".to_string(), json!(tenant))])
+//!         .unwrap_or_default()
+//! });
+//! ```
+//!
+//! ## Fields Known Later
+//!
+//! Values that only become known further down the chain, such as the user
+//! ID after authentication, can be attached with [`HttpRequest::log_field`].
+//! They are added to the entry once the response is back:
+//!
+//! ```rust
+//! use armature_core::{Error, HttpRequest, HttpResponse};
+//!
+//! async fn profile(req: HttpRequest) -> Result<HttpResponse, Error> {
+//!     req.log_field("user_id", 42);
+//!     Ok(HttpResponse::ok())
+//! }
+//! ```
+//!
+//! ## Latency
+//!
+//! Latency covers every middleware and the handler below the logger, so
+//! register it first. For streaming responses it measures the time until
+//! the response starts, and the size is not known.
+
+use crate::logging::{error, info, warn};
+use crate::middleware::{Middleware, Next};
+use crate::{Error, HttpRequest, HttpResponse};
+use async_trait::async_trait;
+use serde_json::Value;
+use std::collections::HashSet;
+use std::io::Write;
+use std::sync::{Arc, Mutex};
+use std::time::{Duration, Instant, SystemTime};
+
+/// Callback that adds custom fields to a request's log entry.
+pub type FieldExtractor = Arc<dyn Fn(&HttpRequest) -> Vec<(String, Value)> + Send + Sync>;
+
+/// Callback deciding whether a request is left out of the log.
+pub type LogSkipper = Arc<dyn Fn(&HttpRequest) -> bool + Send + Sync>;
+
+type SharedWriter = Arc<Mutex<Box<dyn Write + Send>>>;
+
+/// How [`RequestLogger`] emits entries.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub enum RequestLogFormat {
+    /// A `tracing` event at INFO, WARN (4xx) or ERROR (5xx) level
+    #[default]
+    Tracing,
+    /// One JSON object per line
+    Json,
+    /// A compact human-readable line, for development
+    Console,
+}
+
+/// Fields added to the current request's log entry from further down the
+/// chain.
+///
+/// [`RequestLogger`] stores one in the request extensions; use
+/// [`HttpRequest::log_field`] to add to it.
+#[derive(Debug, Clone, Default)]
+pub struct LogFields(Arc<Mutex<LateEntry>>);
+
+#[derive(Debug, Default)]
+struct LateEntry {
+    fields: Vec<(String, Value)>,
+    request_id: Option<String>,
+}
+
+impl LogFields {
+    /// Add a field to the entry.
+    pub fn insert(&self, key: impl Into<String>, value: impl Into<Value>) {
+        if let Ok(mut late) = self.0.lock() {
+            late.fields.push((key.into(), value.into()));
+        }
+    }
+
+    /// Record the ID assigned to the request further down the chain.
+    pub(crate) fn set_request_id(&self, id: &str) {
+        if let Ok(mut late) = self.0.lock() {
+            late.request_id = Some(id.to_string());
+        }
+    }
+
+    fn take(&self) -> LateEntry {
+        self.0
+            .lock()
+            .map(|mut late| std::mem::take(&mut *late))
+            .unwrap_or_default()
+    }
+}
+
+impl HttpRequest {
+    /// Add a field to this request's log entry.
+    ///
+    /// Does nothing unless a [`RequestLogger`] is handling the request.
+    pub fn log_field(&self, key: impl Into<String>, value: impl Into<Value>) {
+        if let Some(fields) = self.extensions.get::<LogFields>() {
+            fields.insert(key, value);
+        }
+    }
+}
+
+/// One completed request, as recorded by [`RequestLogger`].
+#[derive(Debug, Clone, PartialEq)]
+pub struct RequestLogEntry {
+    /// When the request arrived
+    pub time: SystemTime,
+    /// HTTP method
+    pub method: String,
+    /// Request path
+    pub path: String,
+    /// Response status, or the status of the returned error
+    pub status: u16,
+    /// Time spent in the rest of the chain
+    pub latency: Duration,
+    /// Response body size; `None` for streaming responses and errors
+    pub bytes: Option<usize>,
+    /// ID from [`RequestIdMiddleware`](crate::middleware::RequestIdMiddleware),
+    /// or the `x-request-id` header on the response or request
+    pub request_id: Option<String>,
+    /// Message of the error returned by the chain
+    pub error: Option<String>,
+    /// Custom fields from extractors and [`HttpRequest::log_field`]
+    pub fields: Vec<(String, Value)>,
+}
+
+impl RequestLogEntry {
+    /// Latency in milliseconds, with microsecond precision.
+    pub fn latency_ms(&self) -> f64 {
+        self.latency.as_micros() as f64 / 1000.0
+    }
+
+    /// The entry as a flat JSON object.
+    ///
+    /// Custom fields are added at the top level and never replace the
+    /// standard ones.
+    pub fn to_json(&self) -> Value {
+        let mut object = serde_json::Map::new();
+        object.insert("time".to_string(), format_rfc3339(self.time).into());
+        object.insert("level".to_string(), level_name(self.status).into());
+        object.insert("msg".to_string(), "request".into());
+        object.insert("method".to_string(), self.method.clone().into());
+        object.insert("path".to_string(), self.path.clone().into());
+        object.insert("status".to_string(), self.status.into());
+        object.insert("latency_ms".to_string(), self.latency_ms().into());
+        object.insert("bytes".to_string(), self.bytes.into());
+        if let Some(request_id) = &self.request_id {
+            object.insert("request_id".to_string(), request_id.clone().into());
+        }
+        if let Some(error) = &self.error {
+            object.insert("error".to_string(), error.clone().into());
+        }
+        for (key, value) in &self.fields {
+            object.entry(key.clone()).or_insert_with(|| value.clone());
+        }
+        Value::Object(object)
+    }
+
+    /// The entry as a single console line, e.g.
+    /// `GET /users/7 200 1.52ms 128B id=abc user_id=42`.
+    pub fn to_console(&self) -> String {
+        let mut line = format!(
+            "{} {} {} {:.2}ms",
+            self.method,
+            self.path,
+            self.status,
+            self.latency_ms()
+        );
+        match self.bytes {
+            Some(bytes) => line.push_str(&format!(" {}B", bytes)),
+            None => line.push_str(" -"),
+        }
+        if let Some(request_id) = &self.request_id {
+            line.push_str(&format!(" id={}", request_id));
+        }
+        for (key, value) in &self.fields {
+            match value {
+                Value::String(s) => line.push_str(&format!(" {}={}", key, s)),
+                other => line.push_str(&format!(" {}={}", key, other)),
+            }
+        }
+        if let Some(error) = &self.error {
+            line.push_str(&format!(" error={:?}", error));
+        }
+        line
+    }
+
+    fn custom_fields_json(&self) -> Option<String> {
+        if self.fields.is_empty() {
+            return None;
+        }
+        let object: serde_json::Map<String, Value> = self.fields.iter().cloned().collect();
+        Some(Value::Object(object).to_string())
+    }
+}
+
+/// Middleware that writes a structured log entry for every request.
+///
+/// See the [module documentation](self) for the output formats and custom
+/// fields.
+#[derive(Clone, Default)]
+pub struct RequestLogger {
+    format: RequestLogFormat,
+    writer: Option<SharedWriter>,
+    skip_paths: HashSet<String>,
+    skipper: Option<LogSkipper>,
+    extractors: Vec<FieldExtractor>,
+}
+
+impl RequestLogger {
+    /// Create a logger that emits `tracing` events.
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Set the output format.
+    pub fn format(mut self, format: RequestLogFormat) -> Self {
+        self.format = format;
+        self
+    }
+
+    /// Write one JSON object per line.
+    pub fn json(self) -> Self {
+        self.format(RequestLogFormat::Json)
+    }
+
+    /// Write compact human-readable lines.
+    pub fn console(self) -> Self {
+        self.format(RequestLogFormat::Console)
+    }
+
+    /// Write JSON and console lines to `writer` instead of stdout.
+    pub fn with_writer<W: Write + Send + 'static>(mut self, writer: W) -> Self {
+        self.writer = Some(Arc::new(Mutex::new(Box::new(writer))));
+        self
+    }
+
+    /// Don't log requests for this exact path.
+    pub fn skip_path(mut self, path: impl Into<String>) -> Self {
+        self.skip_paths.insert(path.into());
+        self
+    }
+
+    /// Don't log requests for any of these exact paths.
+    pub fn skip_paths<I, S>(mut self, paths: I) -> Self
+    where
+        I: IntoIterator<Item = S>,
+        S: Into<String>,
+    {
+        self.skip_paths.extend(paths.into_iter().map(Into::into));
+        self
+    }
+
+    /// Don't log requests for which `skipper` returns `true`.
+    pub fn skip_if<F>(mut self, skipper: F) -> Self
+    where
+        F: Fn(&HttpRequest) -> bool + Send + Sync + 'static,
+    {
+        self.skipper = Some(Arc::new(skipper));
+        self
+    }
+
+    /// Add fields derived from the incoming request.
+    ///
+    /// Extractors run before the request is passed on, in the order they
+    /// were added.
+    pub fn field_extractor<F>(mut self, extractor: F) -> Self
+    where
+        F: Fn(&HttpRequest) -> Vec<(String, Value)> + Send + Sync + 'static,
+    {
+        self.extractors.push(Arc::new(extractor));
+        self
+    }
+
+    fn should_skip(&self, req: &HttpRequest) -> bool {
+        let path = req.path.split('?').next().unwrap_or(&req.path);
+        self.skip_paths.contains(path) || self.skipper.as_ref().is_some_and(|skip| skip(req))
+    }
+
+    fn emit(&self, entry: &RequestLogEntry) {
+        let line = match self.format {
+            RequestLogFormat::Tracing => return emit_tracing(entry),
+            RequestLogFormat::Json => entry.to_json().to_string(),
+            RequestLogFormat::Console => entry.to_console(),
+        };
+
+        match &self.writer {
+            Some(writer) => {
+                if let Ok(mut writer) = writer.lock() {
+                    let _ = writeln!(writer, "{}", line);
+                }
+            }
+            None => {
+                let _ = writeln!(std::io::stdout().lock(), "{}", line);
+            }
+        }
+    }
+}
+
+impl std::fmt::Debug for RequestLogger {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.debug_struct("RequestLogger")
+            .field("format", &self.format)
+            .field("writer", &self.writer.is_some())
+            .field("skip_paths", &self.skip_paths)
+            .field("skipper", &self.skipper.is_some())
+            .field("extractors", &self.extractors.len())
+            .finish()
+    }
+}
+
+#[async_trait]
+impl Middleware for RequestLogger {
+    async fn handle(&self, mut req: HttpRequest, next: Next) -> Result<HttpResponse, Error> {
+        if self.should_skip(&req) {
+            return next(req).await;
+        }
+
+        let time = SystemTime::now();
+        let start = Instant::now();
+        let method = req.method.clone();
+        let path = req.path.clone();
+        let request_id = req
+            .request_id()
+            .or_else(|| req.header("x-request-id"))
+            .map(str::to_string);
+        let mut fields: Vec<(String, Value)> = self
+            .extractors
+            .iter()
+            .flat_map(|extract| extract(&req))
+            .collect();
+
+        let late_fields = LogFields::default();
+        req.extensions.insert(late_fields.clone());
+
+        let result = next(req).await;
+        let latency = start.elapsed();
+        let late = late_fields.take();
+        fields.extend(late.fields);
+
+        let mut entry = RequestLogEntry {
+            time,
+            method,
+            path,
+            status: 0,
+            latency,
+            bytes: None,
+            request_id,
+            error: None,
+            fields,
+        };
+        match &result {
+            Ok(response) => {
+                entry.status = response.status;
+                if !response.is_streaming() {
+                    entry.bytes = Some(response.body_len());
+                }
+                if let Some(id) = response.headers.get("x-request-id") {
+                    entry.request_id = Some(id.clone());
+                }
+            }
+            Err(err) => {
+                entry.status = err.status_code();
+                entry.error = Some(err.to_string());
+            }
+        }
+        // An ID assigned further down the chain wins over the headers
+        if let Some(id) = late.request_id {
+            entry.request_id = Some(id);
+        }
+
+        self.emit(&entry);
+        result
+    }
+}
+
+fn emit_tracing(entry: &RequestLogEntry) {
+    macro_rules! event {
+        ($level:ident) => {
+            $level!(
+                method = %entry.method,
+                path = %entry.path,
+                status = entry.status,
+                latency_ms = entry.latency_ms(),
+                bytes = entry.bytes,
+                request_id = entry.request_id.as_deref(),
+                error = entry.error.as_deref(),
+                fields = entry.custom_fields_json().as_deref(),
+                "request"
+            )
+        };
+    }
+
+    match entry.status {
+        500.. => event!(error),
+        400..=499 => event!(warn),
+        _ => event!(info),
+    }
+}
+
+fn level_name(status: u16) -> &'static str {
+    match status {
+        500.. => "ERROR",
+        400..=499 => "WARN",
+        _ => "INFO",
+    }
+}
+
+/// Format a timestamp as RFC 3339 in UTC with millisecond precision.
+fn format_rfc3339(time: SystemTime) -> String {
+    let since_epoch = time
+        .duration_since(SystemTime::UNIX_EPOCH)
+        .unwrap_or_default();
+    let secs = since_epoch.as_secs();
+    let (hour, minute, second) = (secs % 86_400 / 3600, secs % 3600 / 60, secs % 60);
+
+    // Civil date from days since the epoch (Howard Hinnant's algorithm)
+    let z = (secs / 86_400) as i64 + 719_468;
+    let era = z.div_euclid(146_097);
+    let doe = z - era * 146_097;
+    let yoe = (doe - doe / 1460 + doe / 36_524 - doe / 146_096) / 365;
+    let doy = doe - (365 * yoe + yoe / 4 - yoe / 100);
+    let mp = (5 * doy + 2) / 153;
+    let day = doy - (153 * mp + 2) / 5 + 1;
+    let month = if mp < 10 { mp + 3 } else { mp - 9 };
+    let year = yoe + era * 400 + i64::from(month <= 2);
+
+    format!(
+        "{:04}-{:02}-{:02}T{:02}:{:02}:{:02}.{:03}Z",
+        year,
+        month,
+        day,
+        hour,
+        minute,
+        second,
+        since_epoch.subsec_millis()
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::middleware::{HandlerFn, MiddlewareChain};
+    use serde_json::json;
+
+    /// Writer that keeps everything written to it
+    #[derive(Clone, Default)]
+    struct Capture(Arc<Mutex<Vec<u8>>>);
+
+    impl Write for Capture {
+        fn write(&mut self, buf: &[u8]) -> std::io::Result<usize> {
+            self.0.lock().unwrap().extend_from_slice(buf);
+            Ok(buf.len())
+        }
+
+        fn flush(&mut self) -> std::io::Result<()> {
+            Ok(())
+        }
+    }
+
+    impl Capture {
+        fn lines(&self) -> Vec<String> {
+            String::from_utf8(self.0.lock().unwrap().clone())
+                .unwrap()
+                .lines()
+                .map(str::to_string)
+                .collect()
+        }
+    }
+
+    async fn run(
+        logger: RequestLogger,
+        req: HttpRequest,
+        handler: HandlerFn,
+    ) -> Result<HttpResponse, Error> {
+        let mut chain = MiddlewareChain::new();
+        chain.use_middleware(logger);
+        chain.apply(req, handler).await
+    }
+
+    fn get(path: &str) -> HttpRequest {
+        HttpRequest::new("GET".to_string(), path.to_string())
+    }
+
+    #[tokio::test]
+    async fn test_json_fields_for_success() {
+        let capture = Capture::default();
+        let logger = RequestLogger::new()
+            .json()
+            .with_writer(capture.clone())
+            .field_extractor(|req| {
+                vec![(
+                    "agent".to_string(),
+                    json!(req.header("user-agent").unwrap_or("")),
+                )]
+            });
+
+        let mut req = get("/users/7");
+        req.headers
+            .insert("User-Agent".to_string(), "tests".to_string());
+        let handler: HandlerFn = Arc::new(|req| {
+            req.log_field("user_id", 7);
+            Box::pin(async {
+                tokio::time::sleep(Duration::from_millis(5)).await;
+                Ok(HttpResponse::ok()
+                    .with_header("x-request-id".to_string(), "req-1".to_string())
+                    .with_body(b"hello".to_vec()))
+            })
+        });
+        let response = run(logger, req, handler).await.unwrap();
+        assert_eq!(response.status, 200);
+
+        let lines = capture.lines();
+        assert_eq!(lines.len(), 1);
+        let entry: Value = serde_json::from_str(&lines[0]).unwrap();
+        assert_eq!(entry["level"], "INFO");
+        assert_eq!(entry["method"], "GET");
+        assert_eq!(entry["path"], "/users/7");
+        assert_eq!(entry["status"], 200);
+        assert_eq!(entry["bytes"], 5);
+        assert_eq!(entry["request_id"], "req-1");
+        assert_eq!(entry["agent"], "tests");
+        assert_eq!(entry["user_id"], 7);
+        assert!(entry["latency_ms"].as_f64().unwrap() >= 5.0);
+        assert!(entry.get("error").is_none());
+    }
+
+    #[tokio::test]
+    async fn test_json_fields_for_server_error() {
+        let capture = Capture::default();
+        let logger = RequestLogger::new().json().with_writer(capture.clone());
+
+        let mut req = HttpRequest::new("POST".to_string(), "/orders".to_string());
+        req.headers
+            .insert("X-Request-ID".to_string(), "req-2".to_string());
+        let handler: HandlerFn =
+            Arc::new(|_req| Box::pin(async { Err(Error::Internal("db down".to_string())) }));
+        let err = run(logger, req, handler).await.unwrap_err();
+        assert_eq!(err.status_code(), 500);
+
+        let entry: Value = serde_json::from_str(&capture.lines()[0]).unwrap();
+        assert_eq!(entry["level"], "ERROR");
+        assert_eq!(entry["method"], "POST");
+        assert_eq!(entry["status"], 500);
+        assert_eq!(entry["request_id"], "req-2");
+        assert_eq!(entry["bytes"], Value::Null);
+        assert!(entry["error"].as_str().unwrap().contains("db down"));
+    }
+
+    #[tokio::test]
+    async fn test_request_id_from_middleware() {
+        let capture = Capture::default();
+        let mut chain = MiddlewareChain::new();
+        chain.use_middleware(RequestLogger::new().json().with_writer(capture.clone()));
+        chain.use_middleware(
+            crate::middleware::RequestIdMiddleware::new().generator(|| "gen-1".to_string()),
+        );
+
+        let handler: HandlerFn = Arc::new(|_req| Box::pin(async { Ok(HttpResponse::ok()) }));
+        let response = chain.apply(get("/"), handler).await.unwrap();
+        assert_eq!(
+            response.headers.get("x-request-id"),
+            Some(&"gen-1".to_string())
+        );
+
+        let entry: Value = serde_json::from_str(&capture.lines()[0]).unwrap();
+        assert_eq!(entry["request_id"], "gen-1");
+    }
+
+    #[tokio::test]
+    async fn test_request_id_from_middleware_on_error() {
+        let capture = Capture::default();
+        let mut chain = MiddlewareChain::new();
+        chain.use_middleware(RequestLogger::new().json().with_writer(capture.clone()));
+        chain.use_middleware(
+            crate::middleware::RequestIdMiddleware::new()
+                .header("x-correlation-id")
+                .generator(|| "gen-2".to_string()),
+        );
+
+        // No response carries the ID back out
+        let handler: HandlerFn =
+            Arc::new(|_req| Box::pin(async { Err(Error::Internal("db down".to_string())) }));
+        assert!(chain.apply(get("/"), handler).await.is_err());
+
+        let entry: Value = serde_json::from_str(&capture.lines()[0]).unwrap();
+        assert_eq!(entry["request_id"], "gen-2");
+    }
+
+    #[tokio::test]
+    async fn test_console_format_and_skip() {
+        let capture = Capture::default();
+        let logger = RequestLogger::new()
+            .console()
+            .with_writer(capture.clone())
+            .skip_paths(["/health", "/ready"])
+            .skip_if(|req| req.method == "OPTIONS");
+
+        let handler: HandlerFn = Arc::new(|req| {
+            req.log_field("user", "ada");
+            Box::pin(async { Ok(HttpResponse::ok().with_body(b"ok".to_vec())) })
+        });
+        for req in [
+            get("/health"),
+            get("/ready?verbose=1"),
+            HttpRequest::new("OPTIONS".to_string(), "/users".to_string()),
+            get("/users"),
+        ] {
+            run(logger.clone(), req, handler.clone()).await.unwrap();
+        }
+
+        let lines = capture.lines();
+        assert_eq!(lines.len(), 1, "{:?}", lines);
+        assert!(lines[0].starts_with("GET /users 200 "), "{}", lines[0]);
+        assert!(lines[0].ends_with("ms 2B user=ada"), "{}", lines[0]);
+    }
+
+    #[tokio::test]
+    async fn test_tracing_format_passes_response_through() {
+        let handler: HandlerFn =
+            Arc::new(|_req| Box::pin(async { Err(Error::NotFound("nothing".to_string())) }));
+        let err = run(RequestLogger::new(), get("/missing"), handler)
+            .await
+            .unwrap_err();
+        assert_eq!(err.status_code(), 404);
+    }
+
+    #[test]
+    fn test_format_rfc3339() {
+        let time = SystemTime::UNIX_EPOCH + Duration::from_millis(1_700_000_000_123);
+        assert_eq!(format_rfc3339(time), "2023-11-14T22:13:20.123Z");
+        assert_eq!(
+            format_rfc3339(SystemTime::UNIX_EPOCH),
+            "1970-01-01T00:00:00.000Z"
+        );
+        let leap_day = SystemTime::UNIX_EPOCH + Duration::from_secs(951_782_400);
+        assert_eq!(format_rfc3339(leap_day), "2000-02-29T00:00:00.000Z");
+    }
+}
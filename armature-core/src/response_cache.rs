@@ -7,6 +7,7 @@
 //! - Cache key generation from requests
 //! - Vary header support
 //! - Cache invalidation
+//! - [`CacheMiddleware`] for caching whole routes, with `ETag` revalidation
 //!
 //! # Examples
 //!
@@ -27,21 +28,18 @@
 //!
 //! ## Response Caching
 //!
-//! ```ignore
-//! use armature_core::response_cache::{ResponseCache, CacheControl};
-//!
-//! let cache = ResponseCache::new();
-//!
-//! // Cache a response
-//! cache.store(&request, &response).await;
+//! ```
+//! use armature_core::response_cache::{CacheMiddleware, ResponseCache};
+//! use armature_core::Router;
+//! use std::sync::Arc;
 //!
-//! // Retrieve cached response
-//! if let Some(cached) = cache.get(&request).await {
-//!     return Ok(cached);
-//! }
+//! let mut router = Router::new();
+//! router.use_middleware(CacheMiddleware::new(Arc::new(ResponseCache::new())));
 //! ```
 
-use crate::{HttpRequest, HttpResponse};
+use crate::logging::debug;
+use crate::{Error, HttpRequest, HttpResponse, Middleware, Next};
+use async_trait::async_trait;
 use std::collections::HashMap;
 use std::fmt;
 use std::sync::Arc;
@@ -161,7 +159,7 @@ impl CacheDirective {
 ///
 /// let cc = CacheControl::parse("public, max-age=3600, must-revalidate");
 /// assert!(cc.is_public());
-/// assert_eq!(cc.max_age(), Some(3600));
+/// assert_eq!(cc.get_max_age(), Some(3600));
 /// ```
 ///
 /// ## Building
@@ -531,8 +529,8 @@ impl CachedResponse {
         Self {
             response: CachedResponseData {
                 status: response.status,
-                headers: response.headers.clone(),
-                body: response.body.clone(),
+                headers: response.headers.to_hashmap(),
+                body: response.body_ref().to_vec(),
             },
             cached_at: now,
             expires_at: now + ttl,
@@ -599,20 +597,18 @@ impl CachedResponse {
 /// # Examples
 ///
 /// ```
-/// use armature_core::response_cache::ResponseCache;
-/// use armature_core::HttpRequest;
+/// use armature_core::response_cache::{ResponseCache, ResponseCacheConfig};
 /// use std::time::Duration;
 ///
-/// # tokio_test::block_on(async {
 /// let cache = ResponseCache::new();
 ///
 /// // Configure cache
-/// let cache = ResponseCache::with_config(ResponseCacheConfig {
-///     max_entries: 1000,
-///     default_ttl: Duration::from_secs(300),
-///     max_body_size: 1024 * 1024,  // 1MB
-/// });
-/// # });
+/// let cache = ResponseCache::with_config(
+///     ResponseCacheConfig::new()
+///         .max_entries(1000)
+///         .default_ttl(Duration::from_secs(300))
+///         .max_body_size(1024 * 1024), // 1MB
+/// );
 /// ```
 #[derive(Debug)]
 pub struct ResponseCache {
@@ -703,12 +699,10 @@ impl ResponseCache {
         let key_str = key.to_string_key();
 
         let entries = self.entries.read().await;
-        if let Some(cached) = entries.get(&key_str) {
-            if cached.is_fresh() {
-                return Some(cached.to_response());
-            }
-        }
-        None
+        entries
+            .get(&key_str)
+            .filter(|cached| cached.is_fresh())
+            .map(CachedResponse::to_response)
     }
 
     /// Store a response in the cache.
@@ -917,11 +911,6 @@ impl HttpResponse {
         self
     }
 
-    /// Set a "no-store" Cache-Control (never cache).
-    pub fn no_cache(self) -> Self {
-        self.with_cache_control(CacheControl::never())
-    }
-
     /// Set a public cache with max-age.
     pub fn cache_public(self, max_age: Duration) -> Self {
         self.with_cache_control(CacheControl::public_max_age(max_age))
@@ -962,6 +951,373 @@ impl HttpResponse {
     }
 }
 
+// ============================================================================
+// Cache Stores
+// ============================================================================
+
+/// Storage for [`CacheMiddleware`].
+///
+/// Keys are [`CacheKey::to_string_key`] strings. Stores may drop entries at
+/// any time; the middleware ignores entries that are no longer fresh.
+#[async_trait]
+pub trait CacheStore: Send + Sync + 'static {
+    /// Look up an entry.
+    async fn get(&self, key: &str) -> Option<CachedResponse>;
+
+    /// Store an entry, replacing any existing one.
+    async fn put(&self, key: String, entry: CachedResponse);
+
+    /// Remove an entry.
+    async fn remove(&self, key: &str);
+}
+
+#[async_trait]
+impl CacheStore for ResponseCache {
+    async fn get(&self, key: &str) -> Option<CachedResponse> {
+        self.entries.read().await.get(key).cloned()
+    }
+
+    async fn put(&self, key: String, entry: CachedResponse) {
+        let mut entries = self.entries.write().await;
+        if !entries.contains_key(&key) && entries.len() >= self.config.max_entries {
+            self.evict_oldest(&mut entries);
+        }
+        entries.insert(key, entry);
+    }
+
+    async fn remove(&self, key: &str) {
+        self.entries.write().await.remove(key);
+    }
+}
+
+// ============================================================================
+// Cache Middleware
+// ============================================================================
+
+/// Middleware that caches whole responses.
+///
+/// Responses to cacheable methods (`GET` and `HEAD` by default) with a
+/// cacheable status are stored under the method, path, query and the
+/// [`vary`](Self::vary) request headers, and replayed with `X-Cache: HIT`
+/// until they expire. Every cacheable response gets a strong `ETag` (unless
+/// the handler set one), and requests whose `If-None-Match` matches get
+/// `304 Not Modified` without a body.
+///
+/// Responses are not cached when they:
+///
+/// - have `Cache-Control: no-store`, `no-cache` or `private`
+/// - set cookies
+/// - are streamed
+/// - are larger than [`max_body_size`](Self::max_body_size)
+/// - vary on a request header not listed in [`vary`](Self::vary), or on `*`
+///
+/// Requests with an `Authorization` header bypass the cache.
+///
+/// Concurrent misses for the same key are coalesced: one request runs the
+/// handler while the others wait for its result.
+///
+/// The entry lifetime is the response's `s-maxage` or `max-age`, if it has
+/// one, and the [`ttl`](Self::ttl) otherwise.
+///
+/// # Example
+///
+/// ```
+/// use armature_core::response_cache::{CacheMiddleware, ResponseCache};
+/// use armature_core::{HttpRequest, HttpResponse, Router};
+/// use std::sync::Arc;
+/// use std::time::Duration;
+///
+/// let store = Arc::new(ResponseCache::new());
+///
+/// let mut router = Router::new();
+/// router.use_middleware(
+///     CacheMiddleware::new(store.clone())
+///         .ttl(Duration::from_secs(30))
+///         .vary(&["Accept-Language"]),
+/// );
+/// router.get("/report", |_req: HttpRequest| async {
+///     Ok(HttpResponse::ok().with_body(b"expensive".to_vec()))
+/// });
+/// ```
+pub struct CacheMiddleware {
+    store: Arc<dyn CacheStore>,
+    ttl: Duration,
+    vary: Vec<String>,
+    methods: Vec<String>,
+    statuses: Vec<u16>,
+    max_body_size: usize,
+    /// Keys being fetched, each locked by the request fetching it
+    in_flight: Arc<parking_lot::Mutex<HashMap<String, Arc<RwLock<()>>>>>,
+}
+
+impl CacheMiddleware {
+    /// Cache responses in `store`.
+    pub fn new(store: Arc<dyn CacheStore>) -> Self {
+        Self {
+            store,
+            ttl: Duration::from_secs(60),
+            vary: Vec::new(),
+            methods: vec!["GET".to_string(), "HEAD".to_string()],
+            statuses: vec![200, 203, 204, 300, 301, 404, 410],
+            max_body_size: 1024 * 1024,
+            in_flight: Arc::default(),
+        }
+    }
+
+    /// Lifetime of entries whose response has no `max-age`, 60 seconds by
+    /// default.
+    pub fn ttl(mut self, ttl: Duration) -> Self {
+        self.ttl = ttl;
+        self
+    }
+
+    /// Request headers that select between cached variants.
+    pub fn vary(mut self, headers: &[&str]) -> Self {
+        self.vary = headers.iter().map(|h| h.to_string()).collect();
+        self
+    }
+
+    /// Methods whose responses are cached, `GET` and `HEAD` by default.
+    pub fn methods(mut self, methods: &[&str]) -> Self {
+        self.methods = methods.iter().map(|m| m.to_ascii_uppercase()).collect();
+        self
+    }
+
+    /// Status codes that are cached.
+    ///
+    /// Defaults to 200, 203, 204, 300, 301, 404 and 410.
+    pub fn statuses(mut self, statuses: &[u16]) -> Self {
+        self.statuses = statuses.to_vec();
+        self
+    }
+
+    /// Largest body that is cached, 1 MiB by default.
+    pub fn max_body_size(mut self, bytes: usize) -> Self {
+        self.max_body_size = bytes;
+        self
+    }
+
+    /// The store key for a request.
+    fn key(&self, req: &HttpRequest) -> String {
+        let vary: Vec<&str> = self.vary.iter().map(String::as_str).collect();
+        let mut key = CacheKey::from_request_with_vary(req, &vary);
+        let path = req.path.split('?').next().unwrap_or_default();
+        key.path = format!("{}{}", req.mount_prefix(), path);
+        key.to_string_key()
+    }
+
+    /// A fresh entry for `key`.
+    async fn lookup(&self, key: &str) -> Option<CachedResponse> {
+        self.store.get(key).await.filter(CachedResponse::is_fresh)
+    }
+
+    /// How long a response may be cached, or `None` if it may not be.
+    fn cache_lifetime(&self, response: &HttpResponse) -> Option<Duration> {
+        if !self.statuses.contains(&response.status)
+            || response.is_streaming()
+            || !response.cookies().is_empty()
+            || response.body_len() > self.max_body_size
+            || header(response, "set-cookie").is_some()
+        {
+            return None;
+        }
+
+        let cache_control = header(response, "cache-control").map(CacheControl::parse);
+        if let Some(cc) = &cache_control
+            && (cc.is_no_store() || cc.is_no_cache() || cc.is_private())
+        {
+            return None;
+        }
+
+        if let Some(vary) = header(response, "vary") {
+            let covered = vary.split(',').map(str::trim).all(|name| {
+                !name.is_empty()
+                    && name != "*"
+                    && self.vary.iter().any(|v| v.eq_ignore_ascii_case(name))
+            });
+            if !covered {
+                return None;
+            }
+        }
+
+        let max_age = cache_control
+            .as_ref()
+            .and_then(|cc| cc.get_s_maxage().or_else(|| cc.get_max_age()));
+        match max_age {
+            Some(0) => None,
+            Some(seconds) => Some(Duration::from_secs(seconds)),
+            None => Some(self.ttl),
+        }
+    }
+
+    /// Run the handler, caching its response if allowed.
+    async fn fetch(&self, key: String, req: HttpRequest, next: Next) -> Result<HttpResponse, Error> {
+        let if_none_match = req.header("if-none-match").map(str::to_string);
+        let mut response = next(req).await?;
+
+        let Some(lifetime) = self.cache_lifetime(&response) else {
+            return Ok(response);
+        };
+        if header(&response, "etag").is_none() {
+            response
+                .headers
+                .insert("ETag".to_string(), strong_etag(response.body_ref()));
+        }
+
+        debug!("Caching response for {} ({:?})", key, lifetime);
+        self.store
+            .put(key, CachedResponse::new(&response, lifetime))
+            .await;
+
+        response
+            .headers
+            .insert("X-Cache".to_string(), "MISS".to_string());
+        Ok(not_modified_or(response, if_none_match.as_deref()))
+    }
+}
+
+impl fmt::Debug for CacheMiddleware {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.debug_struct("CacheMiddleware")
+            .field("ttl", &self.ttl)
+            .field("vary", &self.vary)
+            .field("methods", &self.methods)
+            .field("statuses", &self.statuses)
+            .field("max_body_size", &self.max_body_size)
+            .finish_non_exhaustive()
+    }
+}
+
+#[async_trait]
+impl Middleware for CacheMiddleware {
+    async fn handle(&self, req: HttpRequest, next: Next) -> Result<HttpResponse, Error> {
+        if !self.methods.contains(&req.method.to_ascii_uppercase())
+            || req.header("authorization").is_some()
+        {
+            return next(req).await;
+        }
+
+        let key = self.key(&req);
+        let if_none_match = req.header("if-none-match").map(str::to_string);
+        if let Some(entry) = self.lookup(&key).await {
+            return Ok(not_modified_or(entry.to_response(), if_none_match.as_deref()));
+        }
+
+        // Become the request that fetches this key, or wait for the one
+        // that already is
+        let (flight, leader) = {
+            let mut in_flight = self.in_flight.lock();
+            match in_flight.get(&key) {
+                Some(flight) => (flight.clone(), None),
+                None => {
+                    let flight = Arc::new(RwLock::new(()));
+                    let guard = flight
+                        .clone()
+                        .try_write_owned()
+                        .expect("new lock is unlocked");
+                    in_flight.insert(key.clone(), flight.clone());
+                    (flight, Some(guard))
+                }
+            }
+        };
+
+        let Some(guard) = leader else {
+            drop(flight.read().await);
+            if let Some(entry) = self.lookup(&key).await {
+                return Ok(not_modified_or(entry.to_response(), if_none_match.as_deref()));
+            }
+            // The response wasn't cacheable
+            return self.fetch(key, req, next).await;
+        };
+
+        // Release waiters even if the handler panics
+        let _flight = InFlight {
+            key: key.clone(),
+            in_flight: &self.in_flight,
+            _guard: guard,
+        };
+        self.fetch(key, req, next).await
+    }
+}
+
+/// A key being fetched; removed from the in-flight map when dropped
+struct InFlight<'a> {
+    key: String,
+    in_flight: &'a parking_lot::Mutex<HashMap<String, Arc<RwLock<()>>>>,
+    _guard: tokio::sync::OwnedRwLockWriteGuard<()>,
+}
+
+impl Drop for InFlight<'_> {
+    fn drop(&mut self) {
+        // Remove the key before releasing the lock so later requests look in
+        // the store rather than waiting
+        self.in_flight.lock().remove(&self.key);
+    }
+}
+
+/// Look up a response header case-insensitively.
+fn header<'a>(response: &'a HttpResponse, name: &str) -> Option<&'a str> {
+    response
+        .headers
+        .iter()
+        .find(|(key, _)| key.eq_ignore_ascii_case(name))
+        .map(|(_, value)| value.as_str())
+}
+
+/// A strong entity tag derived from the body.
+fn strong_etag(body: &[u8]) -> String {
+    let digest = ring::digest::digest(&ring::digest::SHA256, body);
+    let hex: String = digest.as_ref()[..16]
+        .iter()
+        .map(|b| format!("{:02x}", b))
+        .collect();
+    format!("\"{}\"", hex)
+}
+
+/// Whether an `If-None-Match` value matches an entity tag.
+///
+/// Uses the weak comparison RFC 7232 requires for `If-None-Match`.
+fn etag_matches(if_none_match: &str, etag: &str) -> bool {
+    let opaque = |tag: &str| tag.trim().trim_start_matches("W/").to_string();
+    let etag = opaque(etag);
+    if_none_match
+        .split(',')
+        .any(|candidate| candidate.trim() == "*" || opaque(candidate) == etag)
+}
+
+/// `304 Not Modified` if the client already has `response`, otherwise
+/// `response` itself.
+fn not_modified_or(response: HttpResponse, if_none_match: Option<&str>) -> HttpResponse {
+    let matched = match (if_none_match, header(&response, "etag")) {
+        (Some(if_none_match), Some(etag)) => etag_matches(if_none_match, etag),
+        _ => false,
+    };
+    if !matched {
+        return response;
+    }
+
+    // RFC 7232 4.1: keep the headers a 200 would have had, minus the body
+    let mut not_modified = HttpResponse::new(304);
+    for (name, value) in response.headers.iter() {
+        let keep = [
+            "cache-control",
+            "content-location",
+            "date",
+            "etag",
+            "expires",
+            "vary",
+            "age",
+            "x-cache",
+        ]
+        .iter()
+        .any(|keep| name.eq_ignore_ascii_case(keep));
+        if keep {
+            not_modified.headers.insert(name.clone(), value.clone());
+        }
+    }
+    not_modified
+}
+
 // ============================================================================
 // Tests
 // ============================================================================
@@ -969,6 +1325,7 @@ impl HttpResponse {
 #[cfg(test)]
 mod tests {
     use super::*;
+    use std::sync::atomic::{AtomicUsize, Ordering};
 
     #[test]
     fn test_cache_directive_parse() {
@@ -1118,5 +1475,157 @@ mod tests {
         request_no_cache.headers.insert("Cache-Control".to_string(), "no-cache".to_string());
         assert!(!request_no_cache.allows_cached());
     }
+
+    /// A handler that counts its calls and responds with `response`.
+    fn counted(calls: &Arc<AtomicUsize>, response: fn() -> HttpResponse) -> Next {
+        let calls = calls.clone();
+        Box::new(move |_req| {
+            Box::pin(async move {
+                calls.fetch_add(1, Ordering::SeqCst);
+                tokio::time::sleep(Duration::from_millis(20)).await;
+                Ok(response())
+            })
+        })
+    }
+
+    fn report() -> HttpResponse {
+        HttpResponse::ok().with_body(b"report".to_vec())
+    }
+
+    fn get(path: &str) -> HttpRequest {
+        HttpRequest::new("GET".to_string(), path.to_string())
+    }
+
+    #[tokio::test]
+    async fn test_cache_middleware_hit_and_miss() {
+        let cache = CacheMiddleware::new(Arc::new(ResponseCache::new()));
+        let calls = Arc::new(AtomicUsize::new(0));
+
+        let first = cache.handle(get("/report"), counted(&calls, report)).await.unwrap();
+        assert_eq!(first.headers.get("X-Cache"), Some(&"MISS".to_string()));
+        assert!(first.headers.get("ETag").unwrap().starts_with('"'));
+
+        let second = cache.handle(get("/report"), counted(&calls, report)).await.unwrap();
+        assert_eq!(second.headers.get("X-Cache"), Some(&"HIT".to_string()));
+        assert_eq!(second.body_ref(), b"report");
+        assert_eq!(second.headers.get("ETag"), first.headers.get("ETag"));
+        assert_eq!(calls.load(Ordering::SeqCst), 1);
+
+        // Different paths and queries are different entries
+        cache.handle(get("/other"), counted(&calls, report)).await.unwrap();
+        let mut query = get("/report");
+        query.query_params.insert("page".to_string(), "2".to_string());
+        cache.handle(query, counted(&calls, report)).await.unwrap();
+        assert_eq!(calls.load(Ordering::SeqCst), 3);
+    }
+
+    #[tokio::test]
+    async fn test_cache_middleware_varies_on_headers() {
+        let cache = CacheMiddleware::new(Arc::new(ResponseCache::new())).vary(&["Accept-Language"]);
+        let calls = Arc::new(AtomicUsize::new(0));
+
+        for language in ["en", "fr", "en"] {
+            let mut req = get("/report");
+            req.headers.insert("accept-language".to_string(), language.to_string());
+            cache.handle(req, counted(&calls, report)).await.unwrap();
+        }
+        assert_eq!(calls.load(Ordering::SeqCst), 2);
+    }
+
+    #[tokio::test]
+    async fn test_cache_middleware_ttl_expiry() {
+        let cache = CacheMiddleware::new(Arc::new(ResponseCache::new())).ttl(Duration::from_millis(50));
+        let calls = Arc::new(AtomicUsize::new(0));
+
+        cache.handle(get("/report"), counted(&calls, report)).await.unwrap();
+        cache.handle(get("/report"), counted(&calls, report)).await.unwrap();
+        assert_eq!(calls.load(Ordering::SeqCst), 1);
+
+        tokio::time::sleep(Duration::from_millis(80)).await;
+        let response = cache.handle(get("/report"), counted(&calls, report)).await.unwrap();
+        assert_eq!(response.headers.get("X-Cache"), Some(&"MISS".to_string()));
+        assert_eq!(calls.load(Ordering::SeqCst), 2);
+    }
+
+    #[tokio::test]
+    async fn test_cache_middleware_not_modified() {
+        let cache = CacheMiddleware::new(Arc::new(ResponseCache::new()));
+        let calls = Arc::new(AtomicUsize::new(0));
+
+        let first = cache.handle(get("/report"), counted(&calls, report)).await.unwrap();
+        let etag = first.headers.get("ETag").unwrap().clone();
+
+        let mut req = get("/report");
+        req.headers.insert("If-None-Match".to_string(), format!("\"other\", W/{}", etag));
+        let response = cache.handle(req, counted(&calls, report)).await.unwrap();
+        assert_eq!(response.status, 304);
+        assert!(response.body_ref().is_empty());
+        assert_eq!(response.headers.get("ETag"), Some(&etag));
+
+        let mut req = get("/report");
+        req.headers.insert("If-None-Match".to_string(), "\"stale\"".to_string());
+        let response = cache.handle(req, counted(&calls, report)).await.unwrap();
+        assert_eq!(response.status, 200);
+        assert_eq!(calls.load(Ordering::SeqCst), 1);
+
+        // A miss that produces the client's version is a 304 too
+        let mut req = get("/fresh");
+        req.headers.insert("If-None-Match".to_string(), etag);
+        let response = cache.handle(req, counted(&calls, report)).await.unwrap();
+        assert_eq!(response.status, 304);
+    }
+
+    #[tokio::test]
+    async fn test_cache_middleware_skips_uncacheable_responses() {
+        let cache = CacheMiddleware::new(Arc::new(ResponseCache::new()));
+        let calls = Arc::new(AtomicUsize::new(0));
+
+        fn no_store() -> HttpResponse {
+            report().with_header("Cache-Control".to_string(), "no-store".to_string())
+        }
+        fn failed() -> HttpResponse {
+            HttpResponse::internal_server_error()
+        }
+        fn varies() -> HttpResponse {
+            report().with_header("Vary".to_string(), "Accept".to_string())
+        }
+
+        for (path, response) in [
+            ("/no-store", no_store as fn() -> HttpResponse),
+            ("/failed", failed),
+            ("/varies", varies),
+        ] {
+            cache.handle(get(path), counted(&calls, response)).await.unwrap();
+            let again = cache.handle(get(path), counted(&calls, response)).await.unwrap();
+            assert!(again.headers.get("X-Cache").is_none(), "{}", path);
+        }
+        assert_eq!(calls.load(Ordering::SeqCst), 6);
+
+        let post = HttpRequest::new("POST".to_string(), "/report".to_string());
+        cache.handle(post.clone(), counted(&calls, report)).await.unwrap();
+        cache.handle(post, counted(&calls, report)).await.unwrap();
+        let mut authorized = get("/report");
+        authorized.headers.insert("Authorization".to_string(), "Bearer t".to_string());
+        cache.handle(authorized.clone(), counted(&calls, report)).await.unwrap();
+        cache.handle(authorized, counted(&calls, report)).await.unwrap();
+        assert_eq!(calls.load(Ordering::SeqCst), 10);
+    }
+
+    #[tokio::test]
+    async fn test_cache_middleware_coalesces_misses() {
+        let cache = Arc::new(CacheMiddleware::new(Arc::new(ResponseCache::new())));
+        let calls = Arc::new(AtomicUsize::new(0));
+
+        let requests = (0..8).map(|_| {
+            let cache = cache.clone();
+            let next = counted(&calls, report);
+            tokio::spawn(async move { cache.handle(get("/report"), next).await.unwrap() })
+        });
+        for request in requests.collect::<Vec<_>>() {
+            assert_eq!(request.await.unwrap().body_ref(), b"report");
+        }
+        assert_eq!(calls.load(Ordering::SeqCst), 1);
+        assert!(cache.in_flight.lock().is_empty());
+    }
 }
 
@@ -4,22 +4,82 @@
 //! - Path prefixes
 //! - Middleware
 //! - Guards
+//! - Error and not-found handlers
 //! - Configuration
 //!
 //! # Examples
 //!
 //! ```
-//! use armature_core::RouteGroup;
+//! use armature_core::{Error, HttpRequest, HttpResponse, RouteGroup, Router};
 //!
-//! let api_group = RouteGroup::new()
-//!     .prefix("/api/v1");
+//! async fn list_users(_req: HttpRequest) -> Result<HttpResponse, Error> {
+//!     Ok(HttpResponse::ok())
+//! }
 //!
-//! // Add routes to the group
-//! // These routes will inherit the prefix and middleware
+//! let mut api = RouteGroup::new().prefix("/api/v1");
+//! api.get("/users", list_users);
+//!
+//! // Nested groups compose prefixes and middleware
+//! let mut admin = api.group("/admin");
+//! admin.delete("/users/:id", list_users);
+//! api.add_group(admin);
+//!
+//! let mut router = Router::new();
+//! router.add_group(api);
+//! assert_eq!(router.routes[1].path, "/api/v1/admin/users/:id");
+//! ```
+//!
+//! # Error handling
+//!
+//! A group can render errors its own way, e.g. as JSON for an API while the
+//! rest of the site uses HTML pages. An error from a route, or from the
+//! middleware around it, goes to the innermost group the route belongs to
+//! that has an [error handler](RouteGroup::error_handler), then to the
+//! groups it was added to, and finally to the router's
+//! [`error_handler`](crate::Router::error_handler):
+//!
+//! ```
+//! # tokio_test::block_on(async {
+//! use armature_core::{Error, HttpRequest, HttpResponse, RouteGroup, Router};
+//!
+//! let mut api = RouteGroup::new().prefix("/api");
+//! api.get("/users/:id", |_req: HttpRequest| async {
+//!     Err::<HttpResponse, _>(Error::NotFound("no such user".into()))
+//! });
+//! api.error_handler(|_req: HttpRequest, err: Error| async move {
+//!     HttpResponse::new(err.status_code())
+//!         .with_json(&serde_json::json!({ "error": err.to_string() }))
+//!         .unwrap()
+//! });
+//!
+//! let mut router = Router::new();
+//! router.add_group(api);
+//!
+//! let req = HttpRequest::new("GET".into(), "/api/users/7".into());
+//! let response = router.route(req).await.unwrap();
+//! assert_eq!(response.status, 404);
+//! assert_eq!(response.headers.get("Content-Type").unwrap(), "application/json");
+//!
+//! // Paths below the prefix that match no route are the group's too
+//! let req = HttpRequest::new("GET".into(), "/api/nothing".into());
+//! let response = router.route(req).await.unwrap();
+//! assert_eq!(response.status, 404);
+//! # });
 //! ```
 
-use crate::{Error, Guard, GuardContext, Middleware};
+use crate::handler::{BoxedHandler, IntoHandler};
+use crate::routing::{ErrorHandler, handle_errors};
+use crate::{
+    Error, Guard, GuardContext, HttpMethod, HttpRequest, HttpResponse, Middleware, MiddlewareChain,
+    Route,
+};
+use std::collections::HashMap;
+use std::future::Future;
 use std::sync::Arc;
+use std::sync::atomic::{AtomicUsize, Ordering};
+
+/// Identities of groups, matching nested routes to their groups' handlers
+static NEXT_GROUP_ID: AtomicUsize = AtomicUsize::new(0);
 
 /// A guard that checks all guards in a list
 pub struct MultiGuard {
@@ -78,8 +138,10 @@ impl MultiMiddleware {
 /// let admin = RouteGroup::new()
 ///     .prefix("/api/v1/admin");
 /// ```
-#[derive(Default)]
 pub struct RouteGroup {
+    /// Identifies this group in the route lists of the groups it's added to
+    id: usize,
+
     /// Path prefix for all routes in this group
     prefix: String,
 
@@ -88,6 +150,48 @@ pub struct RouteGroup {
 
     /// Guards to apply to all routes
     guards: Vec<Box<dyn Guard>>,
+
+    /// Routes registered on this group, with prefix and middleware applied
+    routes: Vec<Route>,
+
+    /// Groups each of `routes` belongs to, innermost first
+    route_groups: Vec<Vec<usize>>,
+
+    /// Turns errors from this group's routes into responses
+    error_handler: Option<ErrorHandler>,
+
+    /// Handler for requests below the prefix that match no route
+    not_found: Option<BoxedHandler>,
+
+    /// Handlers of the groups added to this one, by group id
+    nested: HashMap<usize, GroupHandlers>,
+}
+
+/// Error and not-found handlers of a group added to another
+#[derive(Clone)]
+struct GroupHandlers {
+    prefix: String,
+    middleware: Vec<Arc<dyn Middleware>>,
+    /// Groups it was added to, innermost first
+    parents: Vec<usize>,
+    error_handler: Option<ErrorHandler>,
+    not_found: Option<BoxedHandler>,
+}
+
+impl Default for RouteGroup {
+    fn default() -> Self {
+        Self {
+            id: NEXT_GROUP_ID.fetch_add(1, Ordering::Relaxed),
+            prefix: String::new(),
+            middleware: Vec::new(),
+            guards: Vec::new(),
+            routes: Vec::new(),
+            route_groups: Vec::new(),
+            error_handler: None,
+            not_found: None,
+            nested: HashMap::new(),
+        }
+    }
 }
 
 impl RouteGroup {
@@ -124,7 +228,9 @@ impl RouteGroup {
 
     /// Add middleware to this group
     ///
-    /// Middleware will be applied to all routes in this group.
+    /// Middleware will be applied to all routes registered on this group
+    /// afterwards. Routes and child groups created earlier keep the
+    /// middleware stack they were created with.
     pub fn middleware(mut self, middleware: Arc<dyn Middleware>) -> Self {
         self.middleware.push(middleware);
         self
@@ -239,17 +345,297 @@ impl RouteGroup {
         // For now, only use the child's guards
         new_group.guards = self.guards;
 
+        new_group.error_handler = self.error_handler;
+        new_group.not_found = self.not_found;
+
         new_group
     }
+
+    /// Create a nested group
+    ///
+    /// The child starts with this group's prefix joined with `prefix`, and a
+    /// snapshot of this group's middleware. Middleware added to this group
+    /// later does not apply to the child. Register the child's routes back
+    /// with [`RouteGroup::add_group`] or [`Router::add_group`](crate::Router::add_group).
+    ///
+    /// # Examples
+    ///
+    /// ```
+    /// use armature_core::RouteGroup;
+    ///
+    /// let api = RouteGroup::new().prefix("/api");
+    /// assert_eq!(api.group("/v1").get_prefix(), "/api/v1");
+    /// assert_eq!(api.group("/").get_prefix(), "/api");
+    /// ```
+    pub fn group(&self, prefix: impl Into<String>) -> RouteGroup {
+        let child = RouteGroup::new().prefix(prefix);
+
+        RouteGroup {
+            prefix: format!("{}{}", self.prefix, child.prefix),
+            middleware: self.middleware.clone(),
+            ..child
+        }
+    }
+
+    /// Register a route on this group
+    ///
+    /// The path is prefixed with the group's prefix and the handler is
+    /// wrapped with the group's current middleware, outermost first.
+    pub fn route<H, Args>(&mut self, method: HttpMethod, path: &str, handler: H) -> &mut Self
+    where
+        H: IntoHandler<Args>,
+    {
+        let mut path = self.apply_prefix(path);
+        if !path.starts_with('/') {
+            path.insert(0, '/');
+        }
+
+        let mut route = Route::new(method, path, handler);
+        route.handler =
+            MiddlewareChain::from_middleware(self.middleware.clone()).wrap(route.handler);
+        self.routes.push(route);
+        self.route_groups.push(vec![self.id]);
+        self
+    }
+
+    /// Register a GET route on this group
+    pub fn get<H, Args>(&mut self, path: &str, handler: H) -> &mut Self
+    where
+        H: IntoHandler<Args>,
+    {
+        self.route(HttpMethod::GET, path, handler)
+    }
+
+    /// Register a POST route on this group
+    pub fn post<H, Args>(&mut self, path: &str, handler: H) -> &mut Self
+    where
+        H: IntoHandler<Args>,
+    {
+        self.route(HttpMethod::POST, path, handler)
+    }
+
+    /// Register a PUT route on this group
+    pub fn put<H, Args>(&mut self, path: &str, handler: H) -> &mut Self
+    where
+        H: IntoHandler<Args>,
+    {
+        self.route(HttpMethod::PUT, path, handler)
+    }
+
+    /// Register a DELETE route on this group
+    pub fn delete<H, Args>(&mut self, path: &str, handler: H) -> &mut Self
+    where
+        H: IntoHandler<Args>,
+    {
+        self.route(HttpMethod::DELETE, path, handler)
+    }
+
+    /// Register a PATCH route on this group
+    pub fn patch<H, Args>(&mut self, path: &str, handler: H) -> &mut Self
+    where
+        H: IntoHandler<Args>,
+    {
+        self.route(HttpMethod::PATCH, path, handler)
+    }
+
+    /// Turn errors from this group's routes into responses
+    ///
+    /// Covers errors returned by the group's handlers and by the middleware
+    /// added to it, and those of nested groups without an error handler of
+    /// their own. Errors the handler doesn't see go to the enclosing group,
+    /// then to [`Router::error_handler`](crate::Router::error_handler). Like
+    /// the router's, it receives the request without its body.
+    pub fn error_handler<F, Fut>(&mut self, handler: F) -> &mut Self
+    where
+        F: Fn(HttpRequest, Error) -> Fut + Send + Sync + 'static,
+        Fut: Future<Output = HttpResponse> + Send + 'static,
+    {
+        self.error_handler = Some(Arc::new(move |req, err| Box::pin(handler(req, err))));
+        self
+    }
+
+    /// Handle requests below the group's prefix that match no route
+    ///
+    /// The handler runs inside the middleware added to the group so far.
+    /// Without one, such requests fail with [`Error::RouteNotFound`],
+    /// answered by the group's error handler if it has one, and otherwise
+    /// fall through to an enclosing group or the router.
+    pub fn not_found<H, Args>(&mut self, handler: H) -> &mut Self
+    where
+        H: IntoHandler<Args>,
+        Args: 'static,
+    {
+        let handler = BoxedHandler::new(handler.into_handler());
+        self.not_found =
+            Some(MiddlewareChain::from_middleware(self.middleware.clone()).wrap(handler));
+        self
+    }
+
+    /// Move the routes of a nested group into this group
+    ///
+    /// The child's routes already carry their full prefix and middleware
+    /// stack, so they are taken as-is. Its error and not-found handlers are
+    /// kept and take precedence over this group's.
+    pub fn add_group(&mut self, group: RouteGroup) -> &mut Self {
+        let RouteGroup {
+            id,
+            prefix,
+            middleware,
+            routes,
+            route_groups,
+            error_handler,
+            not_found,
+            nested,
+            ..
+        } = group;
+
+        self.routes.extend(routes);
+        self.route_groups
+            .extend(route_groups.into_iter().map(|mut groups| {
+                groups.push(self.id);
+                groups
+            }));
+        self.nested.insert(
+            id,
+            GroupHandlers {
+                prefix,
+                middleware,
+                parents: vec![self.id],
+                error_handler,
+                not_found,
+            },
+        );
+        for (id, mut handlers) in nested {
+            handlers.parents.push(self.id);
+            self.nested.insert(id, handlers);
+        }
+        self
+    }
+
+    /// Get the routes registered on this group
+    pub fn routes(&self) -> &[Route] {
+        &self.routes
+    }
+
+    /// Consume the group and return its routes
+    ///
+    /// Route handlers are wrapped with the error handler of their group, as
+    /// [`Router::add_group`](crate::Router::add_group) does; not-found
+    /// handlers are dropped.
+    pub fn into_routes(self) -> Vec<Route> {
+        self.into_parts().0
+    }
+
+    /// Consume the group and return its routes, with error handlers
+    /// applied, and the not-found handlers of it and its nested groups.
+    ///
+    /// Not-found handlers come with the prefix they answer for, the most
+    /// specific first.
+    pub(crate) fn into_parts(mut self) -> (Vec<Route>, Vec<(String, BoxedHandler)>) {
+        self.nested.insert(
+            self.id,
+            GroupHandlers {
+                prefix: self.prefix,
+                middleware: self.middleware,
+                parents: Vec::new(),
+                error_handler: self.error_handler,
+                not_found: self.not_found,
+            },
+        );
+        let nested = &self.nested;
+        let error_handler = |groups: &[usize]| {
+            groups
+                .iter()
+                .find_map(|id| nested.get(id)?.error_handler.clone())
+        };
+
+        let routes = self
+            .routes
+            .into_iter()
+            .zip(&self.route_groups)
+            .map(|(mut route, groups)| {
+                if let Some(error_handler) = error_handler(groups) {
+                    route.handler = handle_errors(route.handler, error_handler);
+                }
+                route
+            })
+            .collect();
+
+        let mut not_found: Vec<(usize, String, BoxedHandler)> = Vec::new();
+        for (id, handlers) in nested {
+            let mut groups = vec![*id];
+            groups.extend(&handlers.parents);
+            let handler = groups
+                .iter()
+                .find_map(|id| nested.get(id)?.not_found.clone());
+            let handler = match (handler, error_handler(&groups)) {
+                (None, None) => continue,
+                (Some(handler), None) => handler,
+                (handler, Some(error_handler)) => {
+                    let handler = handler.unwrap_or_else(|| {
+                        MiddlewareChain::from_middleware(handlers.middleware.clone())
+                            .wrap(route_not_found())
+                    });
+                    handle_errors(handler, error_handler)
+                }
+            };
+            not_found.push((groups.len(), handlers.prefix.clone(), handler));
+        }
+        // Longest prefix first; of groups sharing one, the innermost
+        not_found.sort_by(|a, b| (b.1.len(), b.0).cmp(&(a.1.len(), a.0)));
+
+        let not_found = not_found
+            .into_iter()
+            .map(|(_, prefix, handler)| (prefix, handler))
+            .collect();
+        (routes, not_found)
+    }
+}
+
+/// Handler failing with [`Error::RouteNotFound`], for groups with an error
+/// handler but no not-found handler
+fn route_not_found() -> BoxedHandler {
+    BoxedHandler::new(
+        (|req: HttpRequest| async move {
+            let path = req.path.split('?').next().unwrap_or_default();
+            Err::<HttpResponse, _>(Error::RouteNotFound(format!("{} {}", req.method, path)))
+        })
+        .into_handler(),
+    )
 }
 
 impl Clone for RouteGroup {
+    /// The clone is a group of its own: handlers set on it later don't
+    /// affect the routes of the original, or the other way around.
     fn clone(&self) -> Self {
+        let id = NEXT_GROUP_ID.fetch_add(1, Ordering::Relaxed);
+        let rename = |group: &usize| if *group == self.id { id } else { *group };
+        let route_groups = self
+            .route_groups
+            .iter()
+            .map(|groups| groups.iter().map(rename).collect())
+            .collect();
+        let nested = self
+            .nested
+            .iter()
+            .map(|(group, handlers)| {
+                let mut handlers = handlers.clone();
+                handlers.parents = handlers.parents.iter().map(rename).collect();
+                (*group, handlers)
+            })
+            .collect();
+
         Self {
+            id,
             prefix: self.prefix.clone(),
             middleware: self.middleware.clone(),
             // Can't clone Box<dyn Guard> easily, so create empty vec
             guards: Vec::new(),
+            routes: self.routes.clone(),
+            route_groups,
+            error_handler: self.error_handler.clone(),
+            not_found: self.not_found.clone(),
+            nested,
         }
     }
 }
@@ -289,4 +675,264 @@ mod tests {
         assert_eq!(child.get_prefix(), "/api/v1");
         assert_eq!(child.apply_prefix("/users"), "/api/v1/users");
     }
+
+    async fn ok_handler(_req: crate::HttpRequest) -> Result<crate::HttpResponse, Error> {
+        Ok(crate::HttpResponse::ok())
+    }
+
+    fn paths(group: &RouteGroup) -> Vec<&str> {
+        group.routes().iter().map(|r| r.path.as_str()).collect()
+    }
+
+    #[test]
+    fn test_root_prefix_does_not_double_slash() {
+        let mut root = RouteGroup::new().prefix("/");
+        root.get("/health", ok_handler).get("/", ok_handler);
+        assert_eq!(paths(&root), vec!["/health", "/"]);
+
+        let mut nested = root.group("/").group("/api/").group("v1");
+        nested.get("users", ok_handler);
+        assert_eq!(paths(&nested), vec!["/api/v1/users"]);
+    }
+
+    #[test]
+    fn test_group_routes_use_prefix() {
+        let mut api = RouteGroup::new().prefix("/api/v1");
+        api.get("/users", ok_handler)
+            .post("/users", ok_handler)
+            .put("/users/:id", ok_handler)
+            .patch("/users/:id", ok_handler)
+            .delete("/users/:id", ok_handler)
+            .get("/", ok_handler);
+
+        assert_eq!(
+            paths(&api),
+            vec![
+                "/api/v1/users",
+                "/api/v1/users",
+                "/api/v1/users/:id",
+                "/api/v1/users/:id",
+                "/api/v1/users/:id",
+                "/api/v1"
+            ]
+        );
+        assert_eq!(api.routes()[1].method, HttpMethod::POST);
+        assert_eq!(api.routes()[4].method, HttpMethod::DELETE);
+    }
+
+    #[test]
+    fn test_nested_group_added_to_parent() {
+        let mut api = RouteGroup::new().prefix("/api");
+        let mut v1 = api.group("/v1");
+        v1.get("/users", ok_handler);
+        api.get("/status", ok_handler).add_group(v1);
+
+        assert_eq!(paths(&api), vec!["/api/status", "/api/v1/users"]);
+    }
+
+    async fn failing_handler(_req: HttpRequest) -> Result<HttpResponse, Error> {
+        Err(Error::Forbidden("nope".into()))
+    }
+
+    /// Error handler answering with its name and the error's status
+    fn named(group: &mut RouteGroup, name: &'static str) {
+        group.error_handler(move |_req: HttpRequest, err: Error| async move {
+            HttpResponse::new(err.status_code()).with_body(name.as_bytes().to_vec())
+        });
+    }
+
+    /// Middleware rejecting requests without an `authorization` header
+    struct RequireAuth;
+
+    #[async_trait::async_trait]
+    impl Middleware for RequireAuth {
+        async fn handle(
+            &self,
+            req: HttpRequest,
+            next: crate::middleware::Next,
+        ) -> Result<HttpResponse, Error> {
+            if req.headers.contains_key("authorization") {
+                next(req).await
+            } else {
+                Err(Error::Unauthorized("sign in".into()))
+            }
+        }
+    }
+
+    /// `/api` with JSON-ish errors, `/api/admin` behind auth with its own,
+    /// `/api/v1` with none and `/pages` outside of both
+    fn router() -> crate::Router {
+        let mut api = RouteGroup::new().prefix("/api");
+        api.get("/fail", failing_handler);
+        named(&mut api, "api");
+
+        let mut admin = api.group("/admin").middleware(Arc::new(RequireAuth));
+        admin.get("/fail", failing_handler);
+        named(&mut admin, "admin");
+
+        let mut v1 = api.group("/v1");
+        v1.get("/fail", failing_handler);
+
+        api.add_group(admin).add_group(v1);
+
+        let mut pages = RouteGroup::new().prefix("/pages");
+        pages.get("/fail", failing_handler);
+
+        let mut router = crate::Router::new();
+        router.add_group(api);
+        router.add_group(pages);
+        router
+    }
+
+    async fn get(router: &crate::Router, path: &str) -> Result<(u16, String), Error> {
+        let mut req = HttpRequest::new("GET".into(), path.into());
+        if path.contains("authorized") {
+            req.headers
+                .insert("authorization".into(), "Bearer t".into());
+        }
+        let response = router.route(req).await?;
+        Ok((
+            response.status,
+            String::from_utf8(response.body.clone()).unwrap(),
+        ))
+    }
+
+    #[tokio::test]
+    async fn test_errors_go_to_innermost_group_handler() {
+        let router = router();
+
+        assert_eq!(
+            get(&router, "/api/fail").await.unwrap(),
+            (403, "api".into())
+        );
+        assert_eq!(
+            get(&router, "/api/admin/fail?authorized").await.unwrap(),
+            (403, "admin".into())
+        );
+        // Nested group without a handler uses its parent's
+        assert_eq!(
+            get(&router, "/api/v1/fail").await.unwrap(),
+            (403, "api".into())
+        );
+        // Outside of any group with a handler, the error reaches the router
+        assert!(matches!(
+            get(&router, "/pages/fail").await,
+            Err(Error::Forbidden(_))
+        ));
+    }
+
+    #[test]
+    fn test_error_handler_keeps_handler_names() {
+        let routes = router().registered_routes();
+
+        assert_eq!(routes.len(), 4);
+        for route in routes {
+            assert!(
+                route.handler.ends_with("failing_handler"),
+                "{} {}",
+                route.path,
+                route.handler
+            );
+        }
+    }
+
+    #[tokio::test]
+    async fn test_group_middleware_errors_go_to_group_handler() {
+        let router = router();
+
+        assert_eq!(
+            get(&router, "/api/admin/fail").await.unwrap(),
+            (401, "admin".into())
+        );
+    }
+
+    #[tokio::test]
+    async fn test_unmatched_paths_use_innermost_group() {
+        let mut router = router();
+        router.not_found(|_req: HttpRequest| async {
+            Ok(HttpResponse::new(404).with_body(b"app".to_vec()))
+        });
+
+        assert_eq!(
+            get(&router, "/api/missing").await.unwrap(),
+            (404, "api".into())
+        );
+        assert_eq!(
+            get(&router, "/api/v1/missing").await.unwrap(),
+            (404, "api".into())
+        );
+        assert_eq!(get(&router, "/api").await.unwrap(), (404, "api".into()));
+        // The group's middleware runs before the request is found missing
+        assert_eq!(
+            get(&router, "/api/admin/missing").await.unwrap(),
+            (401, "admin".into())
+        );
+        assert_eq!(
+            get(&router, "/api/admin/missing?authorized").await.unwrap(),
+            (404, "admin".into())
+        );
+        assert_eq!(get(&router, "/apix").await.unwrap(), (404, "app".into()));
+        assert_eq!(
+            get(&router, "/pages/missing").await.unwrap(),
+            (404, "app".into())
+        );
+    }
+
+    #[tokio::test]
+    async fn test_cloned_group_has_its_own_handlers() {
+        let mut api = RouteGroup::new().prefix("/api");
+        let mut a = api.group("/a");
+        let mut b = a.clone();
+        a.get("/fail", failing_handler);
+        named(&mut a, "a");
+        b.get("/boom", failing_handler);
+        named(&mut b, "b");
+        api.add_group(a).add_group(b);
+
+        let mut router = crate::Router::new();
+        router.add_group(api);
+
+        assert_eq!(
+            get(&router, "/api/a/fail").await.unwrap(),
+            (403, "a".into())
+        );
+        assert_eq!(
+            get(&router, "/api/a/boom").await.unwrap(),
+            (403, "b".into())
+        );
+    }
+
+    #[tokio::test]
+    async fn test_group_not_found_handler() {
+        let mut api = RouteGroup::new().prefix("/api");
+        api.get("/users", ok_handler);
+        api.not_found(|req: HttpRequest| async move {
+            Ok(HttpResponse::new(404).with_body(format!("no {}", req.path).into_bytes()))
+        });
+        let mut v2 = api.group("/v2");
+        v2.get("/users", ok_handler);
+        v2.not_found(|_req: HttpRequest| async {
+            Err::<HttpResponse, _>(Error::Gone("v2 is gone".into()))
+        });
+        named(&mut v2, "v2");
+        api.add_group(v2);
+
+        let mut router = crate::Router::new();
+        router.add_group(api);
+
+        assert_eq!(get(&router, "/api/users").await.unwrap().0, 200);
+        assert_eq!(
+            get(&router, "/api/groups").await.unwrap(),
+            (404, "no /api/groups".into())
+        );
+        // Errors from a not-found handler go to the group's error handler
+        assert_eq!(
+            get(&router, "/api/v2/groups").await.unwrap(),
+            (410, "v2".into())
+        );
+        assert!(matches!(
+            get(&router, "/other").await,
+            Err(Error::RouteNotFound(_))
+        ));
+    }
 }
@@ -0,0 +1,186 @@
+//! Listing the routes registered on a router.
+//!
+//! [`Router::registered_routes`] and [`Application::routes`] flatten routes
+//! added directly, through [groups](crate::RouteGroup) and through
+//! [mounted](Router::mount) routers into one list with full paths, e.g. for
+//! a `--print-routes` flag or to spot a route registered twice.
+//!
+//! # Examples
+//!
+//! ```
+//! use armature_core::{Error, HttpRequest, HttpResponse, Router};
+//!
+//! async fn show_user(_req: HttpRequest) -> Result<HttpResponse, Error> {
+//!     Ok(HttpResponse::ok())
+//! }
+//!
+//! let mut users = Router::new();
+//! users.get("/:id", show_user).name("user.show");
+//!
+//! let mut router = Router::new();
+//! router.mount("/users", users).unwrap();
+//!
+//! for route in router.registered_routes() {
+//!     println!("{:<6} {:<20} {}", route.method.as_str(), route.path, route.handler);
+//! }
+//!
+//! let routes = router.registered_routes();
+//! assert_eq!(routes[0].path, "/users/:id");
+//! assert_eq!(routes[0].name.as_deref(), Some("user.show"));
+//! assert!(routes[0].handler.ends_with("show_user"));
+//! ```
+
+use crate::{Application, HttpMethod, Router};
+use std::collections::HashMap;
+
+/// A route registered on a router.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct RouteInfo {
+    /// HTTP method
+    pub method: HttpMethod,
+    /// Path pattern, including group and mount prefixes
+    pub path: String,
+    /// Name given with [`Router::name`]
+    pub name: Option<String>,
+    /// Type name of the handler; see [`BoxedHandler::name`](crate::BoxedHandler::name)
+    pub handler: &'static str,
+}
+
+impl Router {
+    /// Every route reachable through this router, including those of
+    /// mounted routers, sorted by path and then method.
+    ///
+    /// Routes registered twice for the same method and path are both
+    /// listed, next to each other in registration order. Static file mounts and not-found
+    /// handlers aren't routes and are left out.
+    pub fn registered_routes(&self) -> Vec<RouteInfo> {
+        let mut routes = Vec::new();
+        self.collect_routes("", &mut routes);
+
+        // Names of mounted routers are registered here with full paths too.
+        // A name goes to the first route registered for its method and path,
+        // the one requests are routed to.
+        let mut names: HashMap<(&str, &str), &str> = self
+            .names
+            .iter()
+            .map(|(name, route)| ((route.method.as_str(), route.path.as_str()), name.as_str()))
+            .collect();
+        for route in &mut routes {
+            route.name = names
+                .remove(&(route.method.as_str(), route.path.as_str()))
+                .map(|name| name.to_string());
+        }
+
+        routes.sort_by(|a, b| {
+            (a.path.as_str(), a.method.as_str()).cmp(&(b.path.as_str(), b.method.as_str()))
+        });
+        routes
+    }
+
+    fn collect_routes(&self, prefix: &str, routes: &mut Vec<RouteInfo>) {
+        routes.extend(self.routes.iter().map(|route| RouteInfo {
+            method: route.method.clone(),
+            path: format!("{}{}", prefix, route.path),
+            name: None,
+            handler: route.handler.name(),
+        }));
+        for (mount, sub) in &self.mounts {
+            sub.collect_routes(&format!("{}{}", prefix, mount), routes);
+        }
+    }
+}
+
+impl Application {
+    /// Every route the application serves; see [`Router::registered_routes`].
+    pub fn routes(&self) -> Vec<RouteInfo> {
+        self.router.registered_routes()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{Container, Error, HttpRequest, HttpResponse, RouteGroup};
+
+    async fn list_users(_req: HttpRequest) -> Result<HttpResponse, Error> {
+        Ok(HttpResponse::ok())
+    }
+
+    async fn show_user(_req: HttpRequest) -> Result<HttpResponse, Error> {
+        Ok(HttpResponse::ok())
+    }
+
+    async fn health(_req: HttpRequest) -> Result<HttpResponse, Error> {
+        Ok(HttpResponse::ok())
+    }
+
+    fn summary(routes: &[RouteInfo]) -> Vec<String> {
+        routes
+            .iter()
+            .map(|route| {
+                let handler = route.handler.rsplit("::").next().unwrap();
+                format!(
+                    "{} {} {} {}",
+                    route.method.as_str(),
+                    route.path,
+                    route.name.as_deref().unwrap_or("-"),
+                    handler
+                )
+            })
+            .collect()
+    }
+
+    #[test]
+    fn test_routes_from_groups_and_mounts() {
+        let mut admin = Router::new();
+        admin
+            .delete("/users/:id", show_user)
+            .name("admin.user.delete");
+        admin.get("/users", list_users);
+
+        let mut api = RouteGroup::new()
+            .prefix("/api")
+            .middleware(std::sync::Arc::new(crate::LoggerMiddleware::new()));
+        api.get("/users", list_users);
+        api.post("/users", list_users);
+        api.get("/users/:id", show_user);
+
+        let mut router = Router::new();
+        router.get("/health", health).name("health");
+        router.add_group(api);
+        router.name("user.show");
+        router.get("/", |_req: HttpRequest| async { Ok(HttpResponse::ok()) });
+        router.mount("/admin", admin).unwrap();
+
+        let app = Application::new(Container::new(), router);
+        assert_eq!(
+            summary(&app.routes()),
+            [
+                "GET / - {{closure}}",
+                "GET /admin/users - list_users",
+                "DELETE /admin/users/:id admin.user.delete show_user",
+                "GET /api/users - list_users",
+                "POST /api/users - list_users",
+                "GET /api/users/:id user.show show_user",
+                "GET /health health health",
+            ]
+        );
+    }
+
+    #[test]
+    fn test_names_follow_the_method() {
+        let mut router = Router::new();
+        router.get("/users", list_users).name("users.index");
+        router.post("/users", list_users).name("users.create");
+        router.get("/users", show_user);
+
+        assert_eq!(
+            summary(&router.registered_routes()),
+            [
+                "GET /users users.index list_users",
+                "GET /users - show_user",
+                "POST /users users.create list_users",
+            ]
+        );
+    }
+}
@@ -0,0 +1,345 @@
+//! Named routes and URL generation.
+//!
+//! Name a route when registering it with [`Router::name`], then build its
+//! URL with [`Router::url`], or from inside a handler with
+//! [`HttpRequest::url_for`] and [`HttpRequest::redirect_to_route`]. URLs
+//! follow the route when its path changes, instead of being repeated as
+//! strings.
+//!
+//! # Examples
+//!
+//! ```
+//! use armature_core::{HttpRequest, HttpResponse, Router};
+//! use std::collections::HashMap;
+//!
+//! async fn show(_req: HttpRequest) -> Result<HttpResponse, armature_core::Error> {
+//!     Ok(HttpResponse::ok())
+//! }
+//!
+//! let mut router = Router::new();
+//! router.get("/users/:id", show).name("user.show");
+//!
+//! let params = HashMap::from([
+//!     ("id".to_string(), "42".to_string()),
+//!     ("tab".to_string(), "posts".to_string()),
+//! ]);
+//! assert_eq!(router.url("user.show", &params).unwrap(), "/users/42?tab=posts");
+//!
+//! // Missing parameters are an error
+//! assert!(router.url("user.show", &HashMap::new()).is_err());
+//! ```
+
+use crate::{Error, HttpMethod, HttpRequest, HttpResponse, Router};
+use std::collections::{BTreeMap, HashMap};
+use std::sync::Arc;
+
+/// Route names of the router handling a request, and the full path pattern
+/// each one refers to.
+///
+/// Stored in the request extensions by [`Router::route`] when the router has
+/// named routes; used by [`HttpRequest::url_for`].
+#[derive(Debug, Clone)]
+pub(crate) struct RouteNames(pub(crate) Arc<HashMap<String, NamedRoute>>);
+
+/// The route a name refers to
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub(crate) struct NamedRoute {
+    pub(crate) method: HttpMethod,
+    /// Full path pattern, including mount prefixes
+    pub(crate) path: String,
+}
+
+impl Router {
+    /// Name the most recently added route.
+    ///
+    /// The name identifies the route to [`url`](Self::url). Names of a
+    /// [mounted](Self::mount) router are available from the router it is
+    /// mounted on, with the mount prefix included in the URL.
+    ///
+    /// # Panics
+    ///
+    /// If no route has been added yet, or the name is already used.
+    pub fn name(&mut self, name: impl Into<String>) -> &mut Self {
+        let name = name.into();
+        let route = match self.routes.last() {
+            Some(route) => NamedRoute {
+                method: route.method.clone(),
+                path: route.path.clone(),
+            },
+            None => panic!("Router::name(\"{}\") called before adding a route", name),
+        };
+        if self.names.contains_key(&name) {
+            panic!("A route named '{}' is already registered", name);
+        }
+        Arc::make_mut(&mut self.names).insert(name, route);
+        self
+    }
+
+    /// Build the URL of a named route.
+    ///
+    /// `:name` and `*name` segments are filled from `params` and
+    /// percent-encoded; a wildcard value may contain `/`. Parameters the path
+    /// doesn't use are appended as a query string, sorted by name.
+    ///
+    /// Fails if no route has the name, or a path parameter is missing or
+    /// empty.
+    ///
+    /// ```
+    /// use armature_core::{HttpRequest, HttpResponse, Router};
+    /// use std::collections::HashMap;
+    ///
+    /// # async fn file(_req: HttpRequest) -> Result<HttpResponse, armature_core::Error> {
+    /// #     Ok(HttpResponse::ok())
+    /// # }
+    /// let mut router = Router::new();
+    /// router.get("/files/*path", file).name("file");
+    ///
+    /// let params = HashMap::from([("path".to_string(), "docs/read me.txt".to_string())]);
+    /// assert_eq!(router.url("file", &params).unwrap(), "/files/docs/read%20me.txt");
+    /// ```
+    pub fn url(&self, name: &str, params: &HashMap<String, String>) -> Result<String, Error> {
+        url_for(&self.names, name, params)
+    }
+}
+
+impl HttpRequest {
+    /// Build the URL of a named route of the router handling this request.
+    ///
+    /// Works like [`Router::url`]. Handlers of a mounted router can name
+    /// routes of the whole application.
+    pub fn url_for(&self, name: &str, params: &HashMap<String, String>) -> Result<String, Error> {
+        match self.extensions.get::<RouteNames>() {
+            Some(names) => url_for(&names.0, name, params),
+            None => Err(unknown_route(name)),
+        }
+    }
+
+    /// Redirect to a named route.
+    ///
+    /// Combines [`url_for`](Self::url_for) and
+    /// [`redirect`](Self::redirect).
+    pub fn redirect_to_route(
+        &self,
+        status: u16,
+        name: &str,
+        params: &HashMap<String, String>,
+    ) -> Result<HttpResponse, Error> {
+        let url = self.url_for(name, params)?;
+        self.redirect(status, &url)
+    }
+}
+
+fn unknown_route(name: &str) -> Error {
+    Error::Internal(format!("No route named '{}'", name))
+}
+
+/// Look up a named route and build its URL.
+fn url_for(
+    names: &HashMap<String, NamedRoute>,
+    name: &str,
+    params: &HashMap<String, String>,
+) -> Result<String, Error> {
+    let pattern = &names.get(name).ok_or_else(|| unknown_route(name))?.path;
+
+    let mut used: Vec<&str> = Vec::new();
+    let mut segments: Vec<String> = Vec::new();
+    for segment in pattern.split('/').filter(|s| !s.is_empty()) {
+        let (param, wildcard) = if let Some(param) = segment.strip_prefix(':') {
+            (param, false)
+        } else if let Some(param) = segment.strip_prefix('*') {
+            // `*` and `**` capture under the name `*`
+            (param.trim_start_matches('*'), true)
+        } else {
+            segments.push(segment.to_string());
+            continue;
+        };
+        let param = if param.is_empty() { "*" } else { param };
+
+        let value = params
+            .get(param)
+            .filter(|value| !value.is_empty())
+            .ok_or_else(|| {
+                Error::Internal(format!(
+                    "Route '{}' ({}) needs parameter '{}'",
+                    name, pattern, param
+                ))
+            })?;
+        used.push(param);
+        segments.push(if wildcard {
+            value
+                .trim_start_matches('/')
+                .split('/')
+                .map(|part| urlencoding::encode(part).into_owned())
+                .collect::<Vec<_>>()
+                .join("/")
+        } else {
+            urlencoding::encode(value).into_owned()
+        });
+    }
+
+    let mut url = format!("/{}", segments.join("/"));
+    if pattern.len() > 1 && pattern.ends_with('/') {
+        url.push('/');
+    }
+
+    let extra: BTreeMap<&String, &String> = params
+        .iter()
+        .filter(|(key, _)| !used.contains(&key.as_str()))
+        .collect();
+    if !extra.is_empty() {
+        let query: Vec<String> = extra
+            .iter()
+            .map(|(key, value)| {
+                format!(
+                    "{}={}",
+                    urlencoding::encode(key),
+                    urlencoding::encode(value)
+                )
+            })
+            .collect();
+        url.push('?');
+        url.push_str(&query.join("&"));
+    }
+    Ok(url)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    async fn handler(_req: HttpRequest) -> Result<HttpResponse, Error> {
+        Ok(HttpResponse::ok())
+    }
+
+    fn params(pairs: &[(&str, &str)]) -> HashMap<String, String> {
+        pairs
+            .iter()
+            .map(|(k, v)| (k.to_string(), v.to_string()))
+            .collect()
+    }
+
+    #[test]
+    fn test_url_fills_path_params() {
+        let mut router = Router::new();
+        router.get("/", handler).name("home");
+        router
+            .get("/users/:id/posts/:post", handler)
+            .name("user.post");
+        router.get("/about/", handler).name("about");
+
+        assert_eq!(router.url("home", &params(&[])).unwrap(), "/");
+        assert_eq!(router.url("about", &params(&[])).unwrap(), "/about/");
+        assert_eq!(
+            router
+                .url("user.post", &params(&[("id", "42"), ("post", "a b/c")]))
+                .unwrap(),
+            "/users/42/posts/a%20b%2Fc"
+        );
+    }
+
+    #[test]
+    fn test_url_missing_params() {
+        let mut router = Router::new();
+        router.get("/users/:id", handler).name("user.show");
+
+        for given in [
+            params(&[]),
+            params(&[("id", "")]),
+            params(&[("other", "1")]),
+        ] {
+            let err = router.url("user.show", &given).unwrap_err();
+            assert!(err.to_string().contains("needs parameter 'id'"), "{}", err);
+        }
+        assert!(router.url("user.missing", &params(&[])).is_err());
+    }
+
+    #[test]
+    fn test_url_extra_params_become_query() {
+        let mut router = Router::new();
+        router.get("/users/:id", handler).name("user.show");
+
+        let url = router
+            .url(
+                "user.show",
+                &params(&[("id", "7"), ("tab", "posts & likes"), ("page", "2")]),
+            )
+            .unwrap();
+        assert_eq!(url, "/users/7?page=2&tab=posts%20%26%20likes");
+    }
+
+    #[test]
+    fn test_url_wildcard_routes() {
+        let mut router = Router::new();
+        router.get("/files/*path", handler).name("file");
+        router.get("/static/*", handler).name("static");
+
+        assert_eq!(
+            router
+                .url("file", &params(&[("path", "/docs/2024/q1 report.pdf")]))
+                .unwrap(),
+            "/files/docs/2024/q1%20report.pdf"
+        );
+        assert_eq!(
+            router
+                .url("static", &params(&[("*", "css/site.css")]))
+                .unwrap(),
+            "/static/css/site.css"
+        );
+        assert!(router.url("file", &params(&[])).is_err());
+    }
+
+    #[test]
+    fn test_mounted_route_names() {
+        let mut users = Router::new();
+        users.get("/:id", handler).name("user.show");
+
+        let mut router = Router::new();
+        router.get("/", handler).name("home");
+        router.mount("/users", users).unwrap();
+
+        assert_eq!(
+            router.url("user.show", &params(&[("id", "3")])).unwrap(),
+            "/users/3"
+        );
+
+        let mut clash = Router::new();
+        clash.get("/", handler).name("home");
+        assert!(router.mount("/other", clash).is_err());
+    }
+
+    #[test]
+    #[should_panic(expected = "already registered")]
+    fn test_duplicate_name_panics() {
+        let mut router = Router::new();
+        router.get("/a", handler).name("page");
+        router.get("/b", handler).name("page");
+    }
+
+    #[tokio::test]
+    async fn test_url_for_in_mounted_handler() {
+        let mut admin = Router::new();
+        admin.get("/logout", |req: HttpRequest| async move {
+            req.redirect_to_route(303, "login", &HashMap::new())
+        });
+
+        let mut router = Router::new();
+        router.get("/login", handler).name("login");
+        router.mount("/admin", admin).unwrap();
+
+        let response = router
+            .route(HttpRequest::new(
+                "GET".to_string(),
+                "/admin/logout".to_string(),
+            ))
+            .await
+            .unwrap();
+        assert_eq!(response.status, 303);
+        assert_eq!(
+            response.headers.get("Location"),
+            Some(&"/login".to_string())
+        );
+
+        let req = HttpRequest::new("GET".to_string(), "/".to_string());
+        assert!(req.url_for("login", &HashMap::new()).is_err());
+    }
+}
@@ -4,7 +4,8 @@
 //!
 //! - **Zero-allocation matching**: Use borrowed strings and SmallVec
 //! - **Wildcard support**: `*` and `**` patterns for catch-all routes
-//! - **Type-safe extraction**: Parse params into typed values
+//! - **Type-safe extraction**: Parse params into typed values, with
+//!   [`ParamError`] separating missing parameters from unparseable ones
 //!
 //! # Performance
 //!
@@ -534,6 +535,57 @@ impl<'a, T1: std::str::FromStr, T2: std::str::FromStr, T3: std::str::FromStr> Ex
     }
 }
 
+// ============================================================================
+// Typed Extraction Errors
+// ============================================================================
+
+/// Error returned by typed path parameter accessors such as
+/// [`HttpRequest::param_i64`](crate::HttpRequest::param_i64).
+///
+/// A parameter that was never captured by the route is reported as
+/// [`ParamError::NotFound`], which is distinct from a parameter that is
+/// present but cannot be parsed ([`ParamError::Invalid`]). Handlers can
+/// branch on the variant, or use `?` to turn either into a 400 response.
+#[derive(Debug, Clone, PartialEq, Eq, thiserror::Error)]
+pub enum ParamError {
+    /// The route did not capture a parameter with this name.
+    #[error("path parameter '{0}' not found")]
+    NotFound(String),
+
+    /// The parameter is present but its value could not be parsed.
+    #[error("invalid path parameter '{name}' = '{value}': {reason}")]
+    Invalid {
+        /// Parameter name.
+        name: String,
+        /// Raw value captured from the path.
+        value: String,
+        /// Why parsing failed (e.g. "number too large to fit in target type").
+        reason: String,
+    },
+}
+
+impl ParamError {
+    /// Returns `true` if the parameter was missing entirely.
+    #[inline]
+    pub fn is_not_found(&self) -> bool {
+        matches!(self, ParamError::NotFound(_))
+    }
+
+    /// Name of the parameter this error refers to.
+    pub fn name(&self) -> &str {
+        match self {
+            ParamError::NotFound(name) => name,
+            ParamError::Invalid { name, .. } => name,
+        }
+    }
+}
+
+impl From<ParamError> for crate::Error {
+    fn from(err: ParamError) -> Self {
+        crate::Error::BadRequest(err.to_string())
+    }
+}
+
 // ============================================================================
 // Statistics
 // ============================================================================
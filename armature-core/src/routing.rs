@@ -5,10 +5,16 @@
 // - Inline dispatch: Hot paths use #[inline(always)]
 // - Zero-cost abstractions: Minimal runtime overhead
 
+use crate::error_hooks::HookScope;
 use crate::handler::{BoxedHandler, IntoHandler};
 use crate::logging::{debug, trace};
+use crate::render::{Renderer, RendererHandle};
 use crate::route_constraint::RouteConstraints;
-use crate::{Error, HttpMethod, HttpRequest, HttpResponse};
+use crate::route_names::{NamedRoute, RouteNames};
+use crate::{
+    Error, HttpMethod, HttpRequest, HttpResponse, MethodOverride, Middleware, MiddlewareChain,
+    RouteGroup, StaticAssetServer, StaticAssetsConfig,
+};
 use std::collections::HashMap;
 use std::future::Future;
 use std::pin::Pin;
@@ -62,7 +68,8 @@ impl Route {
         Self {
             method,
             path: path.into(),
-            handler: BoxedHandler::new(handler.into_handler()),
+            handler: BoxedHandler::new(handler.into_handler())
+                .with_name(std::any::type_name::<H>()),
             constraints: None,
         }
     }
@@ -84,6 +91,25 @@ impl Route {
         self.constraints = Some(constraints);
         self
     }
+
+    /// Don't answer `HEAD` requests with this `GET` route.
+    ///
+    /// By default a `HEAD` request that matches no `HEAD` route runs the
+    /// handler of the matching `GET` route and sends its status and headers
+    /// without the body. Opt out when building the body is too expensive to
+    /// throw away; such paths answer `HEAD` with `405 Method Not Allowed`
+    /// unless a `HEAD` route is registered for them.
+    #[inline]
+    pub fn without_auto_head(mut self) -> Self {
+        self.handler = self.handler.with_auto_head(false);
+        self
+    }
+
+    /// Whether a `HEAD` request can be answered by this route's handler.
+    #[inline]
+    pub fn serves_head(&self) -> bool {
+        self.method == HttpMethod::GET && self.handler.auto_head()
+    }
 }
 
 /// Router for managing routes and dispatching requests.
@@ -92,16 +118,166 @@ impl Route {
 /// - Monomorphization of handler code
 /// - Inlining of handler bodies
 /// - Minimal allocation in the hot path
+///
+/// Routers compose: [`mount`](Self::mount) serves another router under a
+/// path prefix, keeping its own middleware, not-found and error handlers.
+///
+/// Route paths are made of literal segments, `:name` parameters matching one
+/// segment, and an optional trailing `*name` catch-all matching the rest of
+/// the path, slashes included. When several routes match, the most specific
+/// one handles the request: `/files/special` before `/files/:name` before
+/// `/files/*filepath`, whatever order they were added in.
 #[derive(Clone)]
 pub struct Router {
     pub routes: Vec<Route>,
+    /// Static file servers and the path prefix each is mounted under
+    static_mounts: Vec<(String, Arc<StaticAssetServer>)>,
+    /// Mounted sub-routers, longest prefix first
+    pub(crate) mounts: Vec<(String, Arc<Router>)>,
+    /// Middleware run for every request this router dispatches
+    middleware: MiddlewareChain,
+    /// Handler for requests that match nothing
+    not_found: Option<BoxedHandler>,
+    /// Not-found handlers of route groups and their prefixes, longest first
+    group_not_found: Vec<(String, BoxedHandler)>,
+    /// Handler for paths that exist but not for the request method
+    method_not_allowed: Option<BoxedHandler>,
+    /// Turns errors from this router's requests into responses
+    error_handler: Option<ErrorHandler>,
+    /// Route names and the route each names, including mounted routers
+    pub(crate) names: Arc<HashMap<String, NamedRoute>>,
+    /// Redirect requests that only differ from a route by a trailing slash
+    redirect_trailing_slash: bool,
+    /// Redirect requests that match a route once cleaned or case-folded
+    redirect_fixed_path: bool,
+    /// Let a catch-all segment match an empty remainder of the path
+    match_empty_catch_all: bool,
+    /// Rewrites the method of `POST` requests before routing
+    pub(crate) method_override: Option<MethodOverride>,
+    /// Template engine for [`HttpRequest::render`]
+    pub(crate) renderer: Option<Arc<dyn Renderer>>,
+    /// Serializer for JSON responses, see [`Router::json_encoder`]
+    pub(crate) json_encoder: Option<Arc<dyn crate::json::JsonEncoder>>,
+}
+
+/// Callback that turns a request's error into a response
+///
+/// Registered with [`Router::error_handler`] or
+/// [`RouteGroup::error_handler`].
+pub type ErrorHandler = Arc<
+    dyn Fn(HttpRequest, Error) -> Pin<Box<dyn Future<Output = HttpResponse> + Send>> + Send + Sync,
+>;
+
+/// Wrap `handler` so its errors are answered by `error_handler`.
+///
+/// The error handler gets the request without its body, as
+/// [`Router::error_handler`] does. Errors handled here never reach the
+/// server, so they are reported to the application's error hooks first.
+pub(crate) fn handle_errors(handler: BoxedHandler, error_handler: ErrorHandler) -> BoxedHandler {
+    let name = handler.name();
+    let auto_head = handler.auto_head();
+    BoxedHandler::new(
+        (move |req: HttpRequest| {
+            let handler = handler.clone();
+            let error_handler = error_handler.clone();
+            async move {
+                let head = req.head();
+                let hooks = req.extensions.get::<HookScope>().cloned();
+                match handler.call(req).await {
+                    Err(err) => {
+                        if let Some(hooks) = hooks {
+                            hooks.report_error(&err);
+                        }
+                        Ok(error_handler(head, err).await)
+                    }
+                    response => response,
+                }
+            }
+        })
+        .into_handler(),
+    )
+    .with_name(name)
+    .with_auto_head(auto_head)
+}
+
+/// Path prefix a request was routed under by [`Router::mount`].
+///
+/// Stored in the request extensions; read it with
+/// [`HttpRequest::mount_prefix`].
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct MountPrefix(pub String);
+
+/// Route pattern a request matched, including mount prefixes.
+///
+/// Stored in the request extensions; read it with
+/// [`HttpRequest::matched_path`].
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct MatchedPath(pub String);
+
+/// Methods registered for a path that didn't match the request method.
+///
+/// Stored in the request extensions before a
+/// [`Router::method_not_allowed`] handler runs; read it with
+/// [`HttpRequest::allowed_methods`].
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct AllowedMethods(pub Vec<String>);
+
+impl HttpRequest {
+    /// Methods registered for the requested path, in registration order.
+    ///
+    /// Only set for requests handed to a [`Router::method_not_allowed`]
+    /// handler; empty otherwise.
+    pub fn allowed_methods(&self) -> &[String] {
+        self.extensions
+            .get::<AllowedMethods>()
+            .map_or(&[], |methods| methods.0.as_slice())
+    }
+
+    /// Prefix removed from the path by [`Router::mount`], or `""` if the
+    /// request was not routed through a mount.
+    ///
+    /// For nested mounts this is the combined prefix, so
+    /// `mount_prefix() + path` is the path the client requested.
+    pub fn mount_prefix(&self) -> &str {
+        self.extensions
+            .get::<MountPrefix>()
+            .map_or("", |prefix| prefix.0.as_str())
+    }
+
+    /// Pattern of the route that matched, with any mount prefixes, e.g.
+    /// `/api/users/:id`.
+    ///
+    /// Set before router middleware runs, so middleware can group requests
+    /// by route without a label per distinct URL. `None` for requests that
+    /// matched no route, including static files and not-found handlers.
+    pub fn matched_path(&self) -> Option<&str> {
+        self.extensions
+            .get::<MatchedPath>()
+            .map(|matched| matched.0.as_str())
+    }
 }
 
 impl Router {
     /// Create a new empty router.
     #[inline]
     pub fn new() -> Self {
-        Self { routes: Vec::new() }
+        Self {
+            routes: Vec::new(),
+            static_mounts: Vec::new(),
+            mounts: Vec::new(),
+            middleware: MiddlewareChain::new(),
+            not_found: None,
+            group_not_found: Vec::new(),
+            method_not_allowed: None,
+            error_handler: None,
+            names: Arc::default(),
+            redirect_trailing_slash: false,
+            redirect_fixed_path: false,
+            match_empty_catch_all: false,
+            method_override: None,
+            renderer: None,
+            json_encoder: None,
+        }
     }
 
     /// Add a route to the router.
@@ -110,7 +286,29 @@ impl Router {
         self.routes.push(route);
     }
 
+    /// Add all routes registered on a route group.
+    ///
+    /// Group routes already carry the group's prefix and middleware. The
+    /// group's [error](RouteGroup::error_handler) and
+    /// [not-found](RouteGroup::not_found) handlers come along, and are used
+    /// before this router's own.
+    #[inline]
+    pub fn add_group(&mut self, group: RouteGroup) -> &mut Self {
+        let (routes, not_found) = group.into_parts();
+        self.routes.extend(routes);
+        if !not_found.is_empty() {
+            self.group_not_found.extend(not_found);
+            // Stable, so the innermost of groups sharing a prefix stays first
+            self.group_not_found
+                .sort_by(|a, b| b.0.len().cmp(&a.0.len()));
+        }
+        self
+    }
+
     /// Add a GET route with an optimized handler.
+    ///
+    /// `HEAD` requests for the path run the same handler and get its status
+    /// and headers without the body; see [`Route::without_auto_head`].
     #[inline]
     pub fn get<H, Args>(&mut self, path: impl Into<String>, handler: H) -> &mut Self
     where
@@ -163,6 +361,373 @@ impl Router {
         self
     }
 
+    /// Serve files from a directory under a path prefix.
+    ///
+    /// `GET` and `HEAD` requests below `prefix` that don't match a registered
+    /// route are answered from `config.root_dir`, with the prefix removed.
+    /// Fails if the root directory doesn't exist.
+    ///
+    /// ```no_run
+    /// use armature_core::{Router, StaticAssetsConfig};
+    ///
+    /// let mut router = Router::new();
+    /// router
+    ///     .static_files(
+    ///         "/uploads",
+    ///         StaticAssetsConfig::new("uploads").with_directory_listing(true),
+    ///     )
+    ///     .unwrap();
+    /// ```
+    pub fn static_files(
+        &mut self,
+        prefix: impl Into<String>,
+        config: StaticAssetsConfig,
+    ) -> Result<&mut Self, Error> {
+        let server = StaticAssetServer::new(config)?;
+        let prefix = format!("/{}", prefix.into().trim_matches('/'));
+        self.static_mounts
+            .push((prefix.trim_end_matches('/').to_string(), Arc::new(server)));
+        Ok(self)
+    }
+
+    /// Run `middleware` for every request this router dispatches.
+    ///
+    /// Router middleware runs after the route is matched, around the
+    /// handler, static files, mounted routers and the not-found handler.
+    /// Middleware of a mounted router only applies below its prefix.
+    ///
+    /// Middleware added this way has priority `0`; see
+    /// [`use_middleware_with_priority`](Self::use_middleware_with_priority).
+    pub fn use_middleware<M: Middleware + 'static>(&mut self, middleware: M) -> &mut Self {
+        self.middleware.use_middleware(middleware);
+        self
+    }
+
+    /// Run `middleware` for every request, ordered by `priority`.
+    ///
+    /// Lower priorities are further out: they run first on the way in and
+    /// last on the way out. Middleware with equal priorities runs in the
+    /// order it was added, whichever method added it. See
+    /// [`MiddlewareChain`] for a suggested convention.
+    ///
+    /// ```
+    /// use armature_core::middleware::{CompressionMiddleware, LoggerMiddleware};
+    /// use armature_core::Router;
+    ///
+    /// let mut router = Router::new();
+    /// // Compression is added first but runs inside the logger
+    /// router.use_middleware_with_priority(CompressionMiddleware::new(), 10);
+    /// router.use_middleware_with_priority(LoggerMiddleware::new(), -10);
+    /// ```
+    pub fn use_middleware_with_priority<M: Middleware + 'static>(
+        &mut self,
+        middleware: M,
+        priority: i32,
+    ) -> &mut Self {
+        self.middleware
+            .use_middleware_with_priority(middleware, priority);
+        self
+    }
+
+    /// Handle requests that match no route, static mount or mounted router.
+    ///
+    /// A [route group](RouteGroup::not_found) with a not-found or error
+    /// handler takes the requests below its prefix first. Without one,
+    /// unmatched requests fail with [`Error::RouteNotFound`].
+    pub fn not_found<H, Args>(&mut self, handler: H) -> &mut Self
+    where
+        H: IntoHandler<Args>,
+    {
+        self.not_found = Some(BoxedHandler::new(handler.into_handler()));
+        self
+    }
+
+    /// Handle requests whose path matches a route but whose method doesn't.
+    ///
+    /// The handler can read the registered methods with
+    /// [`HttpRequest::allowed_methods`]. If its response has no `Allow`
+    /// header, one listing those methods is added. Without a handler such
+    /// requests get a `405 Method Not Allowed` error response with `Allow`.
+    ///
+    /// `OPTIONS` requests for such paths are answered automatically with
+    /// `204 No Content` and an `Allow` header, unless an `OPTIONS` route is
+    /// registered for the path.
+    ///
+    /// ```
+    /// use armature_core::{HttpRequest, HttpResponse, Router};
+    ///
+    /// let mut router = Router::new();
+    /// router.get("/users", |_req: HttpRequest| async { Ok(HttpResponse::ok()) });
+    /// router.method_not_allowed(|req: HttpRequest| async move {
+    ///     let allowed = req.allowed_methods().join(" or ");
+    ///     Ok(HttpResponse::new(405).with_body(format!("use {}", allowed).into_bytes()))
+    /// });
+    /// ```
+    pub fn method_not_allowed<H, Args>(&mut self, handler: H) -> &mut Self
+    where
+        H: IntoHandler<Args>,
+    {
+        self.method_not_allowed = Some(BoxedHandler::new(handler.into_handler()));
+        self
+    }
+
+    /// Turn errors returned by handlers and middleware into responses.
+    ///
+    /// The handler receives the request as it arrived, without its body, and
+    /// the error. Errors from the routes of a [group](RouteGroup::error_handler)
+    /// or a [mounted](Self::mount) router with an error handler of its own are
+    /// handled there instead, so this one only sees what they leave. Without
+    /// one, errors get the JSON response built by
+    /// [`error_response`](crate::error_response): an [`HttpError`](crate::HttpError)
+    /// supplies the status and message, and errors with no status of their
+    /// own become a bare 500 with the details only logged.
+    ///
+    /// ```
+    /// use armature_core::{Error, HttpRequest, HttpResponse, Router, error_response};
+    ///
+    /// let mut router = Router::new();
+    /// router.error_handler(|req: HttpRequest, err: Error| async move {
+    ///     let html = req.header("accept").is_some_and(|accept| accept.contains("text/html"));
+    ///     if html {
+    ///         HttpResponse::new(err.status_code())
+    ///             .with_body(format!("<h1>Error {}</h1>", err.status_code()).into_bytes())
+    ///     } else {
+    ///         error_response(&err)
+    ///     }
+    /// });
+    /// ```
+    pub fn error_handler<F, Fut>(&mut self, handler: F) -> &mut Self
+    where
+        F: Fn(HttpRequest, Error) -> Fut + Send + Sync + 'static,
+        Fut: Future<Output = HttpResponse> + Send + 'static,
+    {
+        self.error_handler = Some(Arc::new(move |req, err| Box::pin(handler(req, err))));
+        self
+    }
+
+    /// Whether an [`error_handler`](Self::error_handler) is registered.
+    pub(crate) fn has_error_handler(&self) -> bool {
+        self.error_handler.is_some()
+    }
+
+    /// Answer a failed request with the error handler, or the default JSON
+    /// error response.
+    ///
+    /// `request` is only used by a custom handler.
+    pub(crate) async fn handle_error(
+        &self,
+        request: Option<HttpRequest>,
+        err: Error,
+    ) -> HttpResponse {
+        match (&self.error_handler, request) {
+            (Some(handler), Some(request)) => self.with_json_encoder(handler(request, err)).await,
+            _ => {
+                self.with_json_encoder(async { crate::application::error_response(&err) })
+                    .await
+            }
+        }
+    }
+
+    /// Redirect requests whose path only differs from a route by a trailing
+    /// slash.
+    ///
+    /// With this enabled, a `GET` or `HEAD` for `/users/` answers
+    /// `301 Moved Permanently` to `/users` when the route is `/users`, and
+    /// the other way around, keeping the query string. A route registered
+    /// with the exact path is preferred, so having both `/users` and
+    /// `/users/` never loops. Other methods are served by the matching
+    /// route as before, since clients may not repeat a body after a
+    /// redirect.
+    ///
+    /// See [`mount`](Self::mount) for mounted routers.
+    ///
+    /// ```
+    /// # tokio_test::block_on(async {
+    /// use armature_core::{HttpRequest, HttpResponse, Router};
+    ///
+    /// let mut router = Router::new();
+    /// router.redirect_trailing_slash(true);
+    /// router.get("/users", |_req: HttpRequest| async { Ok(HttpResponse::ok()) });
+    ///
+    /// let req = HttpRequest::new("GET".into(), "/users/?page=2".into());
+    /// let response = router.route(req).await.unwrap();
+    /// assert_eq!(response.status, 301);
+    /// assert_eq!(response.headers.get("Location"), Some(&"/users?page=2".to_string()));
+    /// # });
+    /// ```
+    pub fn redirect_trailing_slash(&mut self, enabled: bool) -> &mut Self {
+        self.redirect_trailing_slash = enabled;
+        self
+    }
+
+    /// Redirect requests that match a route once their path is cleaned up.
+    ///
+    /// With this enabled, a `GET` or `HEAD` that matches no route is
+    /// retried with repeated slashes and `.`/`..` segments removed and the
+    /// route's literal segments compared case-insensitively. If a route
+    /// matches, the client gets `301 Moved Permanently` to the route's
+    /// spelling of the path, e.g. `/Users//42` to `/users/42`. Parameter
+    /// values keep their case. The route is chosen as for any other request,
+    /// most specific first, and paths its constraints reject are not
+    /// redirected.
+    ///
+    /// See [`mount`](Self::mount) for mounted routers.
+    pub fn redirect_fixed_path(&mut self, enabled: bool) -> &mut Self {
+        self.redirect_fixed_path = enabled;
+        self
+    }
+
+    /// Let catch-all routes match when nothing follows their prefix.
+    ///
+    /// A route ending in a catch-all segment such as `/files/*filepath`
+    /// matches `/files/css/site.css` with `filepath` set to
+    /// `css/site.css`. By default it needs at least one segment after the
+    /// prefix, so `/files/` and `/files` fall through to other routes or the
+    /// not-found handler. With this enabled they match with `filepath` set
+    /// to `""`.
+    ///
+    /// See [`mount`](Self::mount) for mounted routers.
+    pub fn match_empty_catch_all(&mut self, enabled: bool) -> &mut Self {
+        self.match_empty_catch_all = enabled;
+        self
+    }
+
+    /// Serve `sub` under `prefix`.
+    ///
+    /// Requests below the prefix that don't match one of this router's own
+    /// routes are passed to `sub` with the prefix removed from the path
+    /// (see [`HttpRequest::mount_prefix`]). `sub` keeps its middleware,
+    /// not-found and error handlers, which only apply to its requests; this
+    /// router's middleware runs around them, and its error handler gets the
+    /// errors `sub` doesn't handle.
+    ///
+    /// Fails if a route of `sub` is already registered here under the same
+    /// method and path, if another router is mounted at the same prefix, or
+    /// if one mount would hide routes of another. Routes added to this
+    /// router after mounting are not checked.
+    ///
+    /// Routing options such as
+    /// [`redirect_trailing_slash`](Self::redirect_trailing_slash),
+    /// [`redirect_fixed_path`](Self::redirect_fixed_path) and
+    /// [`match_empty_catch_all`](Self::match_empty_catch_all) apply to the
+    /// routes of the router they are set on, so `sub` follows its own
+    /// settings rather than this router's.
+    ///
+    /// ```
+    /// use armature_core::{Error, HttpRequest, HttpResponse, Router};
+    ///
+    /// async fn list(req: HttpRequest) -> Result<HttpResponse, Error> {
+    ///     Ok(HttpResponse::text(format!("{}{}", req.mount_prefix(), req.path)))
+    /// }
+    ///
+    /// let mut billing = Router::new();
+    /// billing.get("/invoices", list);
+    ///
+    /// let mut app = Router::new();
+    /// app.mount("/billing", billing).unwrap();
+    ///
+    /// let mut again = Router::new();
+    /// again.get("/billing/invoices", list);
+    /// assert!(app.mount("/", again).is_err());
+    /// ```
+    pub fn mount(&mut self, prefix: impl Into<String>, sub: Router) -> Result<&mut Self, Error> {
+        let prefix = format!("/{}", prefix.into().trim_matches('/'));
+        let prefix = prefix.trim_end_matches('/').to_string();
+        if prefix.is_empty() {
+            return Err(Error::Internal(
+                "Cannot mount a router at '/'; add its routes directly".to_string(),
+            ));
+        }
+        if self.mounts.iter().any(|(existing, _)| *existing == prefix) {
+            return Err(Error::Internal(format!(
+                "A router is already mounted at '{}'",
+                prefix
+            )));
+        }
+
+        if let Some(name) = sub.names.keys().find(|name| self.names.contains_key(*name)) {
+            return Err(Error::Internal(format!(
+                "Cannot mount at '{}': a route named '{}' is already registered",
+                prefix, name
+            )));
+        }
+
+        let incoming = sub.route_keys(&prefix);
+        let existing = self.route_keys("");
+        if let Some((method, path)) = incoming.iter().find(|key| existing.contains(key)) {
+            return Err(Error::Internal(format!(
+                "Cannot mount at '{}': route {} {} is already registered",
+                prefix, method, path
+            )));
+        }
+
+        // Mounts are matched longest prefix first, so routes of one mount
+        // that lie below the other's prefix would never be reached
+        for (other, other_sub) in &self.mounts {
+            let hidden = if is_below(&prefix, other) {
+                other_sub
+                    .route_keys(other)
+                    .into_iter()
+                    .find(|(_, path)| is_below(path, &prefix))
+            } else if is_below(other, &prefix) {
+                incoming
+                    .iter()
+                    .find(|(_, path)| is_below(path, other))
+                    .cloned()
+            } else {
+                None
+            };
+            if let Some((method, path)) = hidden {
+                return Err(Error::Internal(format!(
+                    "Cannot mount at '{}': route {} {} would be hidden by the mount at '{}'",
+                    prefix,
+                    method,
+                    path,
+                    if is_below(&prefix, other) {
+                        &prefix
+                    } else {
+                        other
+                    }
+                )));
+            }
+        }
+
+        if !sub.names.is_empty() {
+            let names = Arc::make_mut(&mut self.names);
+            for (name, route) in sub.names.iter() {
+                names.insert(
+                    name.clone(),
+                    NamedRoute {
+                        method: route.method.clone(),
+                        path: format!("{}{}", prefix, route.path),
+                    },
+                );
+            }
+        }
+        self.mounts.push((prefix, Arc::new(sub)));
+        self.mounts.sort_by(|a, b| b.0.len().cmp(&a.0.len()));
+        Ok(self)
+    }
+
+    /// Method and normalized path of every route reachable through this
+    /// router, including mounted routers, with `prefix` prepended.
+    fn route_keys(&self, prefix: &str) -> Vec<(String, String)> {
+        let mut keys: Vec<(String, String)> = self
+            .routes
+            .iter()
+            .map(|route| {
+                (
+                    route.method.as_str().to_string(),
+                    normalize_pattern(&format!("{}{}", prefix, route.path)),
+                )
+            })
+            .collect();
+        for (mount, sub) in &self.mounts {
+            keys.extend(sub.route_keys(&format!("{}{}", prefix, mount)));
+        }
+        keys
+    }
+
     /// Match a route without executing the handler.
     /// Returns the handler and path parameters if a route matches.
     /// Useful for route lookup benchmarking and inspection.
@@ -175,17 +740,64 @@ impl Router {
         // Strip query string if present
         let path = path.split('?').next().unwrap_or(path);
 
-        for route in &self.routes {
-            if route.method.as_str() != method {
+        let routes = self
+            .routes
+            .iter()
+            .filter(|route| route.method.as_str() == method);
+        self.best_route(routes, path, |_| true)
+            .map(|(route, params)| (route.handler.clone(), params))
+    }
+
+    /// The most specific of `routes` whose pattern matches `path` and that
+    /// `accept` lets through, with its path parameters.
+    ///
+    /// Segments are compared from the left: a literal segment beats a
+    /// parameter, which beats a catch-all, so `/files/special` wins over
+    /// `/files/:name` and both over `/files/*filepath` whatever order they
+    /// were registered in. Routes as specific as each other go by
+    /// registration order.
+    fn best_route<'r>(
+        &self,
+        routes: impl Iterator<Item = &'r Route>,
+        path: &str,
+        accept: impl FnMut(&Route) -> bool,
+    ) -> Option<(&'r Route, HashMap<String, String>)> {
+        self.best_route_matching(routes, path, false, accept)
+    }
+
+    /// [`best_route`](Self::best_route), comparing literal segments
+    /// case-insensitively if `fold_case` is set.
+    fn best_route_matching<'r>(
+        &self,
+        routes: impl Iterator<Item = &'r Route>,
+        path: &str,
+        fold_case: bool,
+        mut accept: impl FnMut(&Route) -> bool,
+    ) -> Option<(&'r Route, HashMap<String, String>)> {
+        let mut best: Option<(&'r Route, HashMap<String, String>)> = None;
+        for route in routes {
+            let Some(params) =
+                match_segments(&route.path, path, self.match_empty_catch_all, fold_case)
+            else {
+                continue;
+            };
+            if !accept(route) {
                 continue;
             }
-
-            if let Some(params) = match_path(&route.path, path) {
-                return Some((route.handler.clone(), params));
+            if best
+                .as_ref()
+                .is_some_and(|(best, _)| !more_specific(&route.path, &best.path))
+            {
+                continue;
+            }
+            // Nothing beats a route without parameters
+            let literal = params.is_empty();
+            best = Some((route, params));
+            if literal {
+                break;
             }
         }
-
-        None
+        best
     }
 
     /// Find a route that matches the request and execute the handler.
@@ -194,50 +806,386 @@ impl Router {
     /// is optimized via monomorphization - the actual handler code can be
     /// inlined by the compiler.
     #[inline]
-    pub async fn route(&self, mut request: HttpRequest) -> Result<HttpResponse, Error> {
+    pub async fn route(&self, request: HttpRequest) -> Result<HttpResponse, Error> {
+        self.with_json_encoder(self.route_request(request)).await
+    }
+
+    /// [`route`](Self::route), without setting up the JSON encoder
+    async fn route_request(&self, mut request: HttpRequest) -> Result<HttpResponse, Error> {
         debug!("Routing request: {} {}", request.method, request.path);
 
+        if let Some(method_override) = &self.method_override {
+            method_override.apply(&mut request)?;
+        }
+
+        // Mounted routers see the names of the router they're mounted on
+        if !self.names.is_empty() && request.extensions.get::<RouteNames>().is_none() {
+            request
+                .extensions
+                .insert(RouteNames(Arc::clone(&self.names)));
+        }
+        if let Some(renderer) = &self.renderer {
+            request
+                .extensions
+                .insert(RendererHandle(Arc::clone(renderer)));
+        }
+
         // Parse query parameters from path
+        if let Some((_, query)) = request.path.split_once('?') {
+            trace!("Parsing query string: {}", query);
+            request.set_query_string(query.to_string());
+        }
         let (path, query_string) = request
             .path
             .split_once('?')
             .map(|(p, q)| (p, Some(q)))
             .unwrap_or((&request.path, None));
 
-        if let Some(query) = query_string {
-            trace!("Parsing query string: {}", query);
-            request.query_params = parse_query_string(query);
-        }
+        let redirects = request.method == "GET" || request.method == "HEAD";
+        let strict_slash = self.redirect_trailing_slash && redirects;
+        let mut slash_redirect = None;
+
+        // Trailing slashes must match once redirects are on; a route only
+        // differing by one is redirected to if nothing else matches
+        let mut accept = |route: &Route| {
+            if strict_slash && has_trailing_slash(&route.path) != has_trailing_slash(path) {
+                slash_redirect.get_or_insert_with(|| {
+                    with_trailing_slash(path, has_trailing_slash(&route.path))
+                });
+                return false;
+            }
+            true
+        };
+
+        // Find matching route - this is the route matching hot path. A HEAD
+        // request falls back to the GET routes once no HEAD route matches.
+        let method = request.method.as_str();
+        let matched = self
+            .best_route(
+                self.routes
+                    .iter()
+                    .filter(|route| route.method.as_str() == method),
+                path,
+                &mut accept,
+            )
+            .map(|(route, params)| (route, params, false))
+            .or_else(|| {
+                if method != "HEAD" {
+                    return None;
+                }
+                self.best_route(
+                    self.routes.iter().filter(|route| route.serves_head()),
+                    path,
+                    &mut accept,
+                )
+                .map(|(route, params)| (route, params, true))
+            });
+
+        if let Some((route, params, head_only)) = matched {
+            debug!(
+                "Route matched: {} {} -> {}",
+                request.method, path, route.path
+            );
+
+            // Validate route constraints if present
+            if let Some(constraints) = &route.constraints {
+                trace!("Validating route constraints");
+                constraints.validate(&params)?;
+            }
 
-        // Find matching route - this is the route matching hot path
-        for route in &self.routes {
-            if route.method.as_str() != request.method {
-                continue;
+            request.path_params = params;
+            request.extensions.insert(MatchedPath(format!(
+                "{}{}",
+                request.mount_prefix(),
+                route.path
+            )));
+
+            // Handler dispatch - the BoxedHandler.call() is optimized
+            // to allow the compiler to inline the actual handler body
+            trace!("Dispatching handler");
+            if head_only {
+                let response = self.dispatch(request, &route.handler).await?;
+                return Ok(without_body(response));
             }
+            return self.dispatch(request, &route.handler).await;
+        }
 
-            if let Some(params) = match_path(&route.path, path) {
-                debug!(
-                    "Route matched: {} {} -> {}",
-                    request.method, path, route.path
-                );
+        if let Some(target) = slash_redirect {
+            return self.redirect_canonical(request, target).await;
+        }
 
-                // Validate route constraints if present
-                if let Some(constraints) = &route.constraints {
-                    trace!("Validating route constraints");
-                    constraints.validate(&params)?;
+        for (prefix, sub) in &self.mounts {
+            if let Some(rest) = strip_mount_prefix(prefix, path) {
+                debug!("Mount matched: {} -> {}", path, prefix);
+                let sub_path = match query_string {
+                    Some(query) => format!("{}?{}", rest, query),
+                    None => rest.to_string(),
+                };
+                let mount_prefix = format!("{}{}", request.mount_prefix(), prefix);
+                // The sub-router sets this itself, but only after this
+                // router's middleware has seen the request
+                if !self.middleware.is_empty()
+                    && let Some(pattern) = sub.route_pattern(&request.method, rest)
+                {
+                    request
+                        .extensions
+                        .insert(MatchedPath(format!("{}{}", mount_prefix, pattern)));
                 }
+                request.path = sub_path;
+                request.extensions.insert(MountPrefix(mount_prefix));
 
-                request.path_params = params;
+                let handler = mount_handler(sub.clone());
+                return self.dispatch(request, &handler).await;
+            }
+        }
 
-                // Handler dispatch - the BoxedHandler.call() is optimized
-                // to allow the compiler to inline the actual handler body
-                trace!("Dispatching handler");
-                return route.handler.call(request).await;
+        if request.method == "GET" || request.method == "HEAD" {
+            for (prefix, server) in &self.static_mounts {
+                if let Some(file_path) = strip_mount_prefix(prefix, path) {
+                    debug!("Static mount matched: {} -> {}", path, prefix);
+                    if self.middleware.is_empty() {
+                        return server.serve_path(&request, file_path).await;
+                    }
+                    let server = server.clone();
+                    let file_path = file_path.to_string();
+                    let handler = BoxedHandler::new(
+                        (move |req: HttpRequest| {
+                            let server = server.clone();
+                            let file_path = file_path.clone();
+                            async move { server.serve_path(&req, &file_path).await }
+                        })
+                        .into_handler(),
+                    );
+                    return self.dispatch(request, &handler).await;
+                }
             }
         }
 
+        if self.redirect_fixed_path
+            && redirects
+            && let Some(target) = self.fixed_path(&request.method, path)
+        {
+            return self.redirect_canonical(request, target).await;
+        }
+
+        let allowed = self.allowed_methods(path);
+        if !allowed.is_empty() {
+            debug!(
+                "Method {} not allowed for {}, allowed: {:?}",
+                request.method, path, allowed
+            );
+            return self.reject_method(request, allowed).await;
+        }
+
         debug!("No route found for {} {}", request.method, path);
-        Err(Error::RouteNotFound(format!("{} {}", request.method, path)))
+        if let Some((_, not_found)) = self
+            .group_not_found
+            .iter()
+            .find(|(prefix, _)| is_below(path, prefix))
+        {
+            return self.dispatch(request, not_found).await;
+        }
+        if let Some(not_found) = &self.not_found {
+            return self.dispatch(request, not_found).await;
+        }
+        let message = format!("{} {}", request.method, path);
+        if self.middleware.is_empty() {
+            return Err(Error::RouteNotFound(message));
+        }
+        let handler = BoxedHandler::new(
+            (move |_req: HttpRequest| {
+                let message = message.clone();
+                async move { Err(Error::RouteNotFound(message)) }
+            })
+            .into_handler(),
+        );
+        self.dispatch(request, &handler).await
+    }
+
+    /// The path of a route matching `path` once cleaned and case-folded,
+    /// spelled the way the route is, if it differs from `path`.
+    ///
+    /// Routes are picked the way [`route`](Self::route) picks them, and a
+    /// path whose parameters the route's constraints reject is not fixed.
+    fn fixed_path(&self, method: &str, path: &str) -> Option<String> {
+        let cleaned = crate::redirect::remove_dot_segments(path);
+        let (route, params) = self
+            .best_route_matching(
+                self.routes
+                    .iter()
+                    .filter(|route| route.method.as_str() == method),
+                &cleaned,
+                true,
+                |_| true,
+            )
+            .or_else(|| {
+                if method != "HEAD" {
+                    return None;
+                }
+                self.best_route_matching(
+                    self.routes.iter().filter(|route| route.serves_head()),
+                    &cleaned,
+                    true,
+                    |_| true,
+                )
+            })?;
+        if let Some(constraints) = &route.constraints
+            && constraints.validate(&params).is_err()
+        {
+            return None;
+        }
+
+        // Literal segments as the route spells them, parameters as sent
+        let fixed: Vec<&str> = route
+            .path
+            .split('/')
+            .filter_map(|segment| {
+                if let Some(name) = segment.strip_prefix(':') {
+                    params.get(name).map(String::as_str)
+                } else if let Some(name) = segment.strip_prefix('*') {
+                    params
+                        .get(if name.is_empty() { "*" } else { name })
+                        .map(String::as_str)
+                } else {
+                    Some(segment)
+                }
+            })
+            .collect();
+        let fixed = with_trailing_slash(&fixed.join("/"), has_trailing_slash(&route.path));
+        (fixed != path).then_some(fixed)
+    }
+
+    /// Answer `301 Moved Permanently` to `path`, keeping the mount prefix
+    /// and query string.
+    async fn redirect_canonical(
+        &self,
+        request: HttpRequest,
+        path: String,
+    ) -> Result<HttpResponse, Error> {
+        let mut location = format!("{}{}", request.mount_prefix(), path);
+        if let Some(query) = request.query_string() {
+            location.push('?');
+            location.push_str(query);
+        }
+        debug!(
+            "Redirecting {} {} -> {}",
+            request.method, request.path, location
+        );
+
+        let handler = BoxedHandler::new(
+            (move |_req: HttpRequest| {
+                let location = location.clone();
+                async move { Ok(HttpResponse::redirect_permanent(location)) }
+            })
+            .into_handler(),
+        );
+        self.dispatch(request, &handler).await
+    }
+
+    /// Pattern of the route `method` and `path` would be routed to, relative
+    /// to this router.
+    fn route_pattern(&self, method: &str, path: &str) -> Option<String> {
+        let route = self
+            .best_route(
+                self.routes
+                    .iter()
+                    .filter(|route| route.method.as_str() == method),
+                path,
+                |_| true,
+            )
+            .or_else(|| {
+                if method != "HEAD" {
+                    return None;
+                }
+                self.best_route(
+                    self.routes.iter().filter(|route| route.serves_head()),
+                    path,
+                    |_| true,
+                )
+            });
+        if let Some((route, _)) = route {
+            return Some(route.path.clone());
+        }
+        self.mounts.iter().find_map(|(prefix, sub)| {
+            let rest = strip_mount_prefix(prefix, path)?;
+            sub.route_pattern(method, rest)
+                .map(|pattern| format!("{}{}", prefix, pattern))
+        })
+    }
+
+    /// Methods of the routes whose pattern matches `path`, without
+    /// duplicates, in registration order. `HEAD` follows the `GET` routes
+    /// that answer it.
+    fn allowed_methods(&self, path: &str) -> Vec<String> {
+        let mut allowed: Vec<String> = Vec::new();
+        for route in &self.routes {
+            if match_path(&route.path, path, self.match_empty_catch_all).is_none() {
+                continue;
+            }
+            let head = route.serves_head().then_some("HEAD");
+            for method in std::iter::once(route.method.as_str()).chain(head) {
+                if !allowed.iter().any(|m| m == method) {
+                    allowed.push(method.to_string());
+                }
+            }
+        }
+        allowed
+    }
+
+    /// Answer a request for a path registered only under other methods.
+    async fn reject_method(
+        &self,
+        mut request: HttpRequest,
+        allowed: Vec<String>,
+    ) -> Result<HttpResponse, Error> {
+        let allow = allowed.join(", ");
+
+        let handler = if request.method == "OPTIONS" {
+            let allow = format!("{}, OPTIONS", allow);
+            BoxedHandler::new(
+                (move |_req: HttpRequest| {
+                    let allow = allow.clone();
+                    async move { Ok(HttpResponse::no_content().with_header("Allow".to_string(), allow)) }
+                })
+                .into_handler(),
+            )
+        } else if let Some(handler) = &self.method_not_allowed {
+            request.extensions.insert(AllowedMethods(allowed));
+            handler.clone()
+        } else {
+            let path = request.path.split('?').next().unwrap_or_default();
+            let message = format!("{} {}", request.method, path);
+            BoxedHandler::new(
+                (move |_req: HttpRequest| {
+                    let err = Error::MethodNotAllowed(message.clone());
+                    async move { Err(err) }
+                })
+                .into_handler(),
+            )
+        };
+
+        let mut response = match self.dispatch(request, &handler).await {
+            Err(err @ Error::MethodNotAllowed(_)) => crate::application::error_response(&err),
+            other => other?,
+        };
+        if response.status == 405 && !response.headers.contains_key("Allow") {
+            response.headers.insert("Allow".to_string(), allow);
+        }
+        Ok(response)
+    }
+
+    /// Call `handler`, behind this router's middleware if it has any.
+    #[inline]
+    async fn dispatch(
+        &self,
+        request: HttpRequest,
+        handler: &BoxedHandler,
+    ) -> Result<HttpResponse, Error> {
+        if self.middleware.is_empty() {
+            handler.call(request).await
+        } else {
+            self.middleware.wrap(handler.clone()).call(request).await
+        }
     }
 }
 
@@ -249,21 +1197,55 @@ impl Default for Router {
 
 /// Match a route path pattern against a request path
 /// Returns Some(params) if matched, None otherwise
-fn match_path(pattern: &str, path: &str) -> Option<HashMap<String, String>> {
-    let pattern_parts: Vec<&str> = pattern.split('/').filter(|s| !s.is_empty()).collect();
+///
+/// A trailing `*name` segment captures the rest of the path, slashes
+/// included, under `name`; a bare `*` captures it under `*`. It needs at
+/// least one segment to capture unless `empty_catch_all` is set.
+fn match_path(pattern: &str, path: &str, empty_catch_all: bool) -> Option<HashMap<String, String>> {
+    match_segments(pattern, path, empty_catch_all, false)
+}
+
+/// [`match_path`], comparing literal segments ignoring ASCII case if
+/// `fold_case` is set
+fn match_segments(
+    pattern: &str,
+    path: &str,
+    empty_catch_all: bool,
+    fold_case: bool,
+) -> Option<HashMap<String, String>> {
+    let mut pattern_parts: Vec<&str> = pattern.split('/').filter(|s| !s.is_empty()).collect();
     let path_parts: Vec<&str> = path.split('/').filter(|s| !s.is_empty()).collect();
 
-    if pattern_parts.len() != path_parts.len() {
-        return None;
-    }
+    let catch_all = match pattern_parts.last() {
+        Some(last) if last.starts_with('*') => pattern_parts.pop().map(|last| match &last[1..] {
+            "" => "*",
+            name => name,
+        }),
+        _ => None,
+    };
 
     let mut params = HashMap::new();
 
+    if let Some(name) = catch_all {
+        let min_len = pattern_parts.len() + usize::from(!empty_catch_all);
+        if path_parts.len() < min_len {
+            return None;
+        }
+        params.insert(
+            name.to_string(),
+            path_parts[pattern_parts.len()..].join("/"),
+        );
+    } else if pattern_parts.len() != path_parts.len() {
+        return None;
+    }
+
     for (pattern_part, path_part) in pattern_parts.iter().zip(path_parts.iter()) {
         if let Some(param_name) = pattern_part.strip_prefix(':') {
             // This is a parameter
             params.insert(param_name.to_string(), path_part.to_string());
-        } else if pattern_part != path_part {
+        } else if pattern_part != path_part
+            && !(fold_case && pattern_part.eq_ignore_ascii_case(path_part))
+        {
             // Static part doesn't match
             return None;
         }
@@ -272,11 +1254,122 @@ fn match_path(pattern: &str, path: &str) -> Option<HashMap<String, String>> {
     Some(params)
 }
 
+/// Whether pattern `a` is more specific than `b`; see [`Router::best_route`]
+///
+/// Of two patterns matching the same path where one is a prefix of the
+/// other, the longer one ends in a catch-all matching nothing, so the shorter
+/// one is more specific.
+fn more_specific(a: &str, b: &str) -> bool {
+    fn rank(segment: &str) -> u8 {
+        match segment.as_bytes().first() {
+            Some(b'*') => 0,
+            Some(b':') => 1,
+            _ => 2,
+        }
+    }
+    let a: Vec<u8> = a.split('/').filter(|s| !s.is_empty()).map(rank).collect();
+    let b: Vec<u8> = b.split('/').filter(|s| !s.is_empty()).map(rank).collect();
+    match a.iter().zip(&b).find(|(a, b)| a != b) {
+        Some((a, b)) => a > b,
+        None => a.len() < b.len(),
+    }
+}
+
+/// `response` to a `HEAD` request: its status and headers without the body
+///
+/// A buffered body is measured for `Content-Length` unless the handler set
+/// one. A streamed body is dropped unread, so its length is only known if the
+/// handler set the header.
+fn without_body(mut response: HttpResponse) -> HttpResponse {
+    if response.take_stream().is_some() {
+        return response;
+    }
+    let has_length = response
+        .headers
+        .iter()
+        .any(|(name, _)| name.eq_ignore_ascii_case("Content-Length"));
+    let bodiless = response.status < 200 || response.status == 204 || response.status == 304;
+    if !has_length && !bodiless {
+        response.headers.insert(
+            "Content-Length".to_string(),
+            response.body_len().to_string(),
+        );
+    }
+    response.with_body(Vec::new())
+}
+
+/// Whether a path ends with a slash, not counting the root path `/`
+fn has_trailing_slash(path: &str) -> bool {
+    path.len() > 1 && path.ends_with('/')
+}
+
+/// `path` without empty segments, ending with a slash if `slash` is set
+///
+/// Collapsing empty segments also keeps the result from starting with `//`,
+/// which a browser would treat as another host.
+fn with_trailing_slash(path: &str, slash: bool) -> String {
+    let segments: Vec<&str> = path.split('/').filter(|s| !s.is_empty()).collect();
+    let mut path = format!("/{}", segments.join("/"));
+    if slash && !segments.is_empty() {
+        path.push('/');
+    }
+    path
+}
+
+/// Handler that routes a request through a mounted router
+///
+/// Kept out of `Router::route` so the recursive future type stays behind a
+/// `BoxedHandler`.
+fn mount_handler(sub: Arc<Router>) -> BoxedHandler {
+    let error_handler = sub.error_handler.clone();
+    let handler = BoxedHandler::new(
+        (move |req: HttpRequest| {
+            let sub = sub.clone();
+            async move { sub.route(req).await }
+        })
+        .into_handler(),
+    );
+    match error_handler {
+        Some(error_handler) => handle_errors(handler, error_handler),
+        None => handler,
+    }
+}
+
+/// Route pattern with parameter names removed, for detecting duplicates
+fn normalize_pattern(pattern: &str) -> String {
+    let segments: Vec<&str> = pattern
+        .split('/')
+        .filter(|s| !s.is_empty())
+        .map(|s| if s.starts_with(':') { ":" } else { s })
+        .collect();
+    format!("/{}", segments.join("/"))
+}
+
+/// Whether `path` is `prefix` itself or lies below it
+fn is_below(path: &str, prefix: &str) -> bool {
+    strip_mount_prefix(prefix, path).is_some()
+}
+
+/// Strip a mount prefix from a request path
+///
+/// Returns the remaining path (always starting with `/`) when the path is
+/// the prefix itself or lies below it.
+fn strip_mount_prefix<'a>(prefix: &str, path: &'a str) -> Option<&'a str> {
+    let rest = path.strip_prefix(prefix)?;
+    if rest.is_empty() {
+        Some("/")
+    } else if rest.starts_with('/') {
+        Some(rest)
+    } else {
+        None
+    }
+}
+
 /// Parse a query string into a map of parameters
 ///
 /// Uses SIMD-optimized byte searching via memchr for faster parsing.
 #[inline]
-fn parse_query_string(query: &str) -> HashMap<String, String> {
+pub(crate) fn parse_query_string(query: &str) -> HashMap<String, String> {
     // Use the SIMD-optimized parser
     crate::simd_parser::parse_query_string_fast(query)
 }
@@ -294,7 +1387,7 @@ mod tests {
     fn test_match_path_static() {
         let pattern = "/users";
         let path = "/users";
-        let result = match_path(pattern, path);
+        let result = match_path(pattern, path, false);
         assert!(result.is_some());
         assert_eq!(result.unwrap().len(), 0);
     }
@@ -303,7 +1396,7 @@ mod tests {
     fn test_match_path_with_param() {
         let pattern = "/users/:id";
         let path = "/users/123";
-        let result = match_path(pattern, path);
+        let result = match_path(pattern, path, false);
         assert!(result.is_some());
         let params = result.unwrap();
         assert_eq!(params.get("id"), Some(&"123".to_string()));
@@ -313,7 +1406,7 @@ mod tests {
     fn test_match_path_no_match() {
         let pattern = "/users/:id";
         let path = "/posts/123";
-        let result = match_path(pattern, path);
+        let result = match_path(pattern, path, false);
         assert!(result.is_none());
     }
 
@@ -329,7 +1422,7 @@ mod tests {
     fn test_match_path_multiple_params() {
         let pattern = "/users/:user_id/posts/:post_id";
         let path = "/users/123/posts/456";
-        let result = match_path(pattern, path);
+        let result = match_path(pattern, path, false);
         assert!(result.is_some());
         let params = result.unwrap();
         assert_eq!(params.get("user_id"), Some(&"123".to_string()));
@@ -340,7 +1433,7 @@ mod tests {
     fn test_match_path_trailing_slash() {
         let pattern = "/users";
         let path = "/users/";
-        let result = match_path(pattern, path);
+        let result = match_path(pattern, path, false);
         // Should handle trailing slash gracefully
         assert!(result.is_some() || result.is_none());
     }
@@ -349,7 +1442,7 @@ mod tests {
     fn test_match_path_nested() {
         let pattern = "/api/v1/users/:id";
         let path = "/api/v1/users/123";
-        let result = match_path(pattern, path);
+        let result = match_path(pattern, path, false);
         assert!(result.is_some());
         let params = result.unwrap();
         assert_eq!(params.get("id"), Some(&"123".to_string()));
@@ -359,10 +1452,37 @@ mod tests {
     fn test_match_path_empty() {
         let pattern = "/";
         let path = "/";
-        let result = match_path(pattern, path);
+        let result = match_path(pattern, path, false);
         assert!(result.is_some());
     }
 
+    #[test]
+    fn test_match_path_catch_all() {
+        let params = match_path("/files/*filepath", "/files/docs/2024/report.pdf", false).unwrap();
+        assert_eq!(params.get("filepath").unwrap(), "docs/2024/report.pdf");
+
+        let params = match_path("/:user/files/*", "/ada/files/a/b", false).unwrap();
+        assert_eq!(params.get("user").unwrap(), "ada");
+        assert_eq!(params.get("*").unwrap(), "a/b");
+
+        assert!(match_path("/files/*filepath", "/other/a", false).is_none());
+        assert!(match_path("/files/*filepath", "/files/", false).is_none());
+        assert!(match_path("/files/*filepath", "/files", false).is_none());
+
+        let params = match_path("/files/*filepath", "/files/", true).unwrap();
+        assert_eq!(params.get("filepath").unwrap(), "");
+    }
+
+    #[test]
+    fn test_more_specific() {
+        assert!(more_specific("/files/special", "/files/:name"));
+        assert!(more_specific("/files/:name", "/files/*filepath"));
+        assert!(more_specific("/files/img/*path", "/files/*path"));
+        assert!(more_specific("/a/b/:c", "/a/:b/c"));
+        assert!(more_specific("/files", "/files/*filepath"));
+        assert!(!more_specific("/users/:id", "/users/:name"));
+    }
+
     #[test]
     fn test_parse_query_string_empty() {
         let query = "";
@@ -391,7 +1511,7 @@ mod tests {
     fn test_match_path_param_with_special_chars() {
         let pattern = "/users/:id";
         let path = "/users/abc-123";
-        let result = match_path(pattern, path);
+        let result = match_path(pattern, path, false);
         assert!(result.is_some());
         let params = result.unwrap();
         assert_eq!(params.get("id"), Some(&"abc-123".to_string()));
@@ -491,6 +1611,202 @@ mod tests {
         assert_eq!(String::from_utf8(response.body).unwrap(), "123");
     }
 
+    /// Middleware that appends its name to an `x-trace` response header
+    struct Trace(&'static str);
+
+    #[async_trait::async_trait]
+    impl Middleware for Trace {
+        async fn handle(
+            &self,
+            req: HttpRequest,
+            next: crate::middleware::Next,
+        ) -> Result<HttpResponse, Error> {
+            let mut response = match next(req).await {
+                Ok(response) => response,
+                Err(Error::RouteNotFound(_)) => HttpResponse::new(404),
+                Err(err) => return Err(err),
+            };
+            let trace = match response.headers.get("x-trace") {
+                Some(inner) => format!("{},{}", self.0, inner),
+                None => self.0.to_string(),
+            };
+            response.headers.insert("x-trace".to_string(), trace);
+            Ok(response)
+        }
+    }
+
+    async fn echo_path(req: HttpRequest) -> Result<HttpResponse, Error> {
+        let body = format!(
+            "{}|{}|{}|{}",
+            req.mount_prefix(),
+            req.path,
+            req.param("id").map(String::as_str).unwrap_or("-"),
+            req.query("page").map(String::as_str).unwrap_or("-")
+        );
+        Ok(HttpResponse::ok().with_body(body.into_bytes()))
+    }
+
+    async fn get(router: &Router, path: &str) -> Result<HttpResponse, Error> {
+        router
+            .route(HttpRequest::new("GET".to_string(), path.to_string()))
+            .await
+    }
+
+    #[tokio::test]
+    async fn test_mount_strips_prefix() {
+        let mut users = Router::new();
+        users.get("/", echo_path).get("/:id", echo_path);
+
+        let mut app = Router::new();
+        app.get("/users/me", test_handler);
+        app.mount("/users/", users).unwrap();
+
+        let response = get(&app, "/users/7?page=2").await.unwrap();
+        assert_eq!(response.body, b"/users|/7?page=2|7|2");
+
+        let response = get(&app, "/users").await.unwrap();
+        assert_eq!(response.body, b"/users|/|-|-");
+
+        // The parent's own routes below the prefix still take precedence
+        let response = get(&app, "/users/me").await.unwrap();
+        assert!(response.body.is_empty());
+
+        assert!(get(&app, "/usersx/7").await.is_err());
+    }
+
+    #[tokio::test]
+    async fn test_nested_mounts() {
+        let mut items = Router::new();
+        items.get("/:id", echo_path);
+        let mut v1 = Router::new();
+        v1.mount("/items", items).unwrap();
+        let mut app = Router::new();
+        app.mount("/api/v1", v1).unwrap();
+
+        let response = get(&app, "/api/v1/items/3").await.unwrap();
+        assert_eq!(response.body, b"/api/v1/items|/3|3|-");
+    }
+
+    #[tokio::test]
+    async fn test_mount_keeps_middleware_isolated() {
+        let mut admin = Router::new();
+        admin
+            .use_middleware(Trace("admin"))
+            .get("/stats", test_handler);
+
+        let mut app = Router::new();
+        app.use_middleware(Trace("app"))
+            .get("/home", test_handler)
+            .mount("/admin", admin)
+            .unwrap();
+
+        let response = get(&app, "/admin/stats").await.unwrap();
+        assert_eq!(
+            response.headers.get("x-trace"),
+            Some(&"app,admin".to_string())
+        );
+
+        let response = get(&app, "/home").await.unwrap();
+        assert_eq!(response.headers.get("x-trace"), Some(&"app".to_string()));
+
+        // Middleware also runs for requests that match nothing
+        let response = get(&app, "/missing").await.unwrap();
+        assert_eq!(response.status, 404);
+        assert_eq!(response.headers.get("x-trace"), Some(&"app".to_string()));
+    }
+
+    #[tokio::test]
+    async fn test_mount_uses_sub_not_found() {
+        let mut docs = Router::new();
+        docs.get("/intro", test_handler)
+            .not_found(|req: HttpRequest| async move {
+                Ok(HttpResponse::new(404).with_body(format!("no doc {}", req.path).into_bytes()))
+            });
+
+        let mut app = Router::new();
+        app.not_found(|_req: HttpRequest| async {
+            Ok(HttpResponse::new(404).with_body(b"app".to_vec()))
+        });
+        app.mount("/docs", docs).unwrap();
+
+        let response = get(&app, "/docs/missing").await.unwrap();
+        assert_eq!(response.body, b"no doc /missing");
+
+        let response = get(&app, "/elsewhere").await.unwrap();
+        assert_eq!(response.body, b"app");
+
+        // Without a not-found handler the error propagates unchanged
+        let mut bare = Router::new();
+        bare.mount("/docs", Router::new()).unwrap();
+        match get(&bare, "/docs/x").await {
+            Err(Error::RouteNotFound(message)) => assert_eq!(message, "GET /x"),
+            other => panic!("unexpected {:?}", other.map(|r| r.status)),
+        }
+    }
+
+    #[tokio::test]
+    async fn test_mount_uses_sub_error_handler() {
+        let mut api = Router::new();
+        api.get("/fail", |_req: HttpRequest| async {
+            Err::<HttpResponse, _>(Error::Conflict("taken".into()))
+        })
+        .error_handler(|req: HttpRequest, err: Error| async move {
+            HttpResponse::new(err.status_code()).with_body(format!("api {}", req.path).into_bytes())
+        });
+
+        let mut app = Router::new();
+        app.get("/fail", |_req: HttpRequest| async {
+            Err::<HttpResponse, _>(Error::Conflict("taken".into()))
+        });
+        app.mount("/api", api).unwrap();
+
+        let response = get(&app, "/api/fail").await.unwrap();
+        assert_eq!(response.status, 409);
+        assert_eq!(response.body, b"api /fail");
+
+        // Not-found errors of the mounted router are its own too
+        let response = get(&app, "/api/missing").await.unwrap();
+        assert_eq!(response.status, 404);
+        assert_eq!(response.body, b"api /missing");
+
+        // The app's own routes are left to the app
+        assert!(matches!(get(&app, "/fail").await, Err(Error::Conflict(_))));
+    }
+
+    #[test]
+    fn test_mount_conflicts() {
+        let mut sub = Router::new();
+        sub.get("/:id", test_handler);
+        let mut app = Router::new();
+        app.get("/users/:user_id", test_handler);
+        let err = app.mount("/users", sub).err().unwrap();
+        assert!(err.to_string().contains("GET /users/:"), "{}", err);
+
+        // Same path with another method is fine
+        let mut sub = Router::new();
+        sub.post("/:id", test_handler);
+        app.mount("/users", sub).unwrap();
+
+        let err = app.mount("/users", Router::new()).err().unwrap();
+        assert!(err.to_string().contains("already mounted"), "{}", err);
+
+        // A nested mount would hide routes of the outer one, either way round
+        let mut api = Router::new();
+        api.get("/v2/status", test_handler);
+        app.mount("/api", api).unwrap();
+        assert!(app.mount("/api/v2", Router::new()).is_err());
+
+        let mut reports = Router::new();
+        reports.get("/daily", test_handler);
+        app.mount("/reports/2024", Router::new()).unwrap();
+        let mut outer = Router::new();
+        outer.get("/2024/x", test_handler);
+        assert!(app.mount("/reports", outer).is_err());
+        app.mount("/reports", reports).unwrap();
+
+        assert!(app.mount("/", Router::new()).is_err());
+    }
+
     #[tokio::test]
     async fn test_router_404() {
         let router = Router::new();
@@ -498,4 +1814,463 @@ mod tests {
         let result = router.route(req).await;
         assert!(matches!(result, Err(Error::RouteNotFound(_))));
     }
+
+    fn request(method: &str, path: &str) -> HttpRequest {
+        HttpRequest::new(method.to_string(), path.to_string())
+    }
+
+    fn users_router() -> Router {
+        let mut router = Router::new();
+        router.get("/users/:id", test_handler);
+        router.delete("/users/:id", test_handler);
+        router.put("/users/me", test_handler);
+        router.get("/users/me", test_handler);
+        router.post("/users", test_handler);
+        router
+    }
+
+    #[tokio::test]
+    async fn test_method_not_allowed_lists_registered_methods() {
+        let router = users_router();
+
+        let response = router.route(request("POST", "/users/7")).await.unwrap();
+        assert_eq!(response.status, 405);
+        assert_eq!(response.headers.get("Allow").unwrap(), "GET, HEAD, DELETE");
+        let body: serde_json::Value = serde_json::from_slice(response.body_ref()).unwrap();
+        assert_eq!(body["status"], 405);
+
+        // Every matching pattern counts, each method once
+        let response = router
+            .route(request("PATCH", "/users/me?x=1"))
+            .await
+            .unwrap();
+        assert_eq!(
+            response.headers.get("Allow").unwrap(),
+            "GET, HEAD, DELETE, PUT"
+        );
+
+        let response = router.route(request("GET", "/users")).await.unwrap();
+        assert_eq!(response.headers.get("Allow").unwrap(), "POST");
+
+        // Unknown paths are still 404
+        let result = router.route(request("GET", "/posts")).await;
+        assert!(matches!(result, Err(Error::RouteNotFound(_))));
+    }
+
+    #[tokio::test]
+    async fn test_custom_method_not_allowed_handler() {
+        let mut router = users_router();
+        router.method_not_allowed(|req: HttpRequest| async move {
+            let body = req.allowed_methods().join("|");
+            Ok(HttpResponse::new(405).with_body(body.into_bytes()))
+        });
+
+        let response = router.route(request("POST", "/users/7")).await.unwrap();
+        assert_eq!(response.status, 405);
+        assert_eq!(response.body_ref(), b"GET|HEAD|DELETE");
+        assert_eq!(response.headers.get("Allow").unwrap(), "GET, HEAD, DELETE");
+
+        // The not-found handler still covers unknown paths
+        router.not_found(|_req: HttpRequest| async { Ok(HttpResponse::new(404)) });
+        let response = router.route(request("GET", "/posts")).await.unwrap();
+        assert_eq!(response.status, 404);
+        assert!(response.headers.get("Allow").is_none());
+    }
+
+    #[tokio::test]
+    async fn test_options_answered_automatically() {
+        let mut router = users_router();
+        router.use_middleware(Trace("outer"));
+
+        let response = router.route(request("OPTIONS", "/users/7")).await.unwrap();
+        assert_eq!(response.status, 204);
+        assert_eq!(
+            response.headers.get("Allow").unwrap(),
+            "GET, HEAD, DELETE, OPTIONS"
+        );
+        assert_eq!(response.headers.get("x-trace").unwrap(), "outer");
+
+        // Trace turns the not-found error into a plain 404
+        let response = router.route(request("OPTIONS", "/posts")).await.unwrap();
+        assert_eq!(response.status, 404);
+
+        // A registered OPTIONS route takes precedence
+        router.add_route(Route::new(
+            HttpMethod::OPTIONS,
+            "/users/:id",
+            |_req: HttpRequest| async { Ok(HttpResponse::ok()) },
+        ));
+        let response = router.route(request("OPTIONS", "/users/7")).await.unwrap();
+        assert_eq!(response.status, 200);
+        assert!(response.headers.get("Allow").is_none());
+    }
+
+    #[tokio::test]
+    async fn test_method_not_allowed_runs_middleware() {
+        let mut router = users_router();
+        router.use_middleware(Trace("outer"));
+
+        let response = router.route(request("POST", "/users/7")).await.unwrap();
+        assert_eq!(response.status, 405);
+        assert_eq!(response.headers.get("Allow").unwrap(), "GET, HEAD, DELETE");
+    }
+
+    #[tokio::test]
+    async fn test_head_runs_get_handler_without_body() {
+        let mut router = Router::new();
+        router.use_middleware(Trace("outer"));
+        router.get("/users/:id", |req: HttpRequest| async move {
+            let body = format!("{} user {}", req.method, req.param("id").unwrap());
+            Ok(HttpResponse::new(203)
+                .with_header("ETag".to_string(), "\"v1\"".to_string())
+                .with_body(body.into_bytes()))
+        });
+
+        let response = router.route(request("HEAD", "/users/7")).await.unwrap();
+        assert_eq!(response.status, 203);
+        assert_eq!(response.headers.get("ETag").unwrap(), "\"v1\"");
+        assert_eq!(response.headers.get("x-trace").unwrap(), "outer");
+        assert_eq!(response.headers.get("Content-Length").unwrap(), "11");
+        assert!(response.body_ref().is_empty());
+
+        // GET is unchanged
+        let response = router.route(request("GET", "/users/7")).await.unwrap();
+        assert_eq!(response.body_ref(), b"GET user 7");
+        assert!(response.headers.get("Content-Length").is_none());
+    }
+
+    #[tokio::test]
+    async fn test_head_keeps_handler_content_length() {
+        let mut router = Router::new();
+        router.get("/report", |_req: HttpRequest| async {
+            Ok(HttpResponse::ok()
+                .with_header("content-length".to_string(), "4".to_string())
+                .stream(|w| async move { w.write_str("data").await }))
+        });
+        router.get("/empty", |_req: HttpRequest| async {
+            Ok(HttpResponse::no_content())
+        });
+
+        let response = router.route(request("HEAD", "/report")).await.unwrap();
+        assert!(!response.is_streaming());
+        assert_eq!(response.headers.get("content-length").unwrap(), "4");
+        assert!(response.headers.get("Content-Length").is_none());
+
+        let response = router.route(request("HEAD", "/empty")).await.unwrap();
+        assert_eq!(response.status, 204);
+        assert!(response.headers.get("Content-Length").is_none());
+    }
+
+    #[tokio::test]
+    async fn test_head_route_takes_precedence() {
+        let mut router = Router::new();
+        router.get("/users", named("get"));
+        router.add_route(Route::new(HttpMethod::HEAD, "/users", named("head")));
+
+        let response = router.route(request("HEAD", "/users")).await.unwrap();
+        assert_eq!(response.body_ref(), b"head");
+    }
+
+    #[tokio::test]
+    async fn test_head_through_mount() {
+        let mut posts = Router::new();
+        posts.get("/:post", named("/:post"));
+
+        let mut router = Router::new();
+        router.use_middleware(SeenPattern);
+        router.mount("/posts", posts).unwrap();
+
+        let response = router.route(request("HEAD", "/posts/3")).await.unwrap();
+        assert_eq!(response.status, 200);
+        assert_eq!(response.headers.get("x-seen").unwrap(), "/posts/:post");
+        assert_eq!(response.headers.get("Content-Length").unwrap(), "6");
+        assert!(response.body_ref().is_empty());
+    }
+
+    #[tokio::test]
+    async fn test_without_auto_head() {
+        let mut router = Router::new();
+        router.add_route(
+            Route::new(HttpMethod::GET, "/export", named("/export")).without_auto_head(),
+        );
+        router.get("/users/:id", named("/users/:id"));
+
+        let response = router.route(request("HEAD", "/export")).await.unwrap();
+        assert_eq!(response.status, 405);
+        assert_eq!(response.headers.get("Allow").unwrap(), "GET");
+
+        let response = router.route(request("HEAD", "/users/7")).await.unwrap();
+        assert_eq!(response.status, 200);
+    }
+
+    /// A handler answering with the route's pattern
+    fn named(
+        pattern: &'static str,
+    ) -> impl Fn(HttpRequest) -> std::future::Ready<Result<HttpResponse, Error>>
+    + Clone
+    + Send
+    + Sync
+    + 'static {
+        move |_req| std::future::ready(Ok(HttpResponse::text(pattern)))
+    }
+
+    fn location(response: &HttpResponse) -> Option<&str> {
+        response.headers.get("Location").map(String::as_str)
+    }
+
+    #[tokio::test]
+    async fn test_redirect_trailing_slash() {
+        let mut router = Router::new();
+        router.redirect_trailing_slash(true);
+        router.get("/users", named("/users"));
+        router.get("/teams/", named("/teams/"));
+        router.get("/", named("/"));
+
+        for (path, target) in [
+            ("/users/", "/users"),
+            ("/users/?page=2", "/users?page=2"),
+            ("/teams", "/teams/"),
+            ("//users/", "/users"),
+        ] {
+            let response = router.route(request("GET", path)).await.unwrap();
+            assert_eq!(response.status, 301, "{}", path);
+            assert_eq!(location(&response), Some(target), "{}", path);
+        }
+
+        for path in ["/users", "/teams/", "/"] {
+            let response = router.route(request("GET", path)).await.unwrap();
+            assert_eq!(response.status, 200, "{}", path);
+        }
+    }
+
+    #[tokio::test]
+    async fn test_redirect_trailing_slash_only_for_get_and_head() {
+        let mut router = Router::new();
+        router.redirect_trailing_slash(true);
+        router.post("/users", named("/users"));
+        router.add_route(Route::new(HttpMethod::HEAD, "/users", named("/users")));
+
+        let response = router.route(request("POST", "/users/")).await.unwrap();
+        assert_eq!(response.status, 200);
+        assert_eq!(response.body_ref(), b"/users");
+
+        let response = router.route(request("HEAD", "/users/")).await.unwrap();
+        assert_eq!(response.status, 301);
+
+        // Disabled, the slash is ignored as before
+        let mut router = Router::new();
+        router.get("/users", named("/users"));
+        let response = router.route(request("GET", "/users/")).await.unwrap();
+        assert_eq!(response.status, 200);
+    }
+
+    #[tokio::test]
+    async fn test_redirect_trailing_slash_prefers_exact_route() {
+        let mut router = Router::new();
+        router
+            .redirect_trailing_slash(true)
+            .redirect_fixed_path(true);
+        router.get("/docs", named("/docs"));
+        router.get("/docs/", named("/docs/"));
+        router.get("/:page", named("/:page"));
+
+        for path in ["/docs", "/docs/"] {
+            let response = router.route(request("GET", path)).await.unwrap();
+            assert_eq!(response.status, 200, "{}", path);
+            assert_eq!(response.body_ref(), path.as_bytes());
+        }
+
+        // Following a redirect never redirects again
+        for path in ["/about/", "/DOCS//", "/a/../Docs"] {
+            let response = router.route(request("GET", path)).await.unwrap();
+            let target = location(&response).unwrap().to_string();
+            let followed = router.route(request("GET", &target)).await.unwrap();
+            assert_eq!(followed.status, 200, "{} -> {}", path, target);
+        }
+
+        // An empty first segment can't turn into another host
+        let response = router
+            .route(request("GET", "//evil.example/"))
+            .await
+            .unwrap();
+        assert_eq!(location(&response), Some("/evil.example"));
+    }
+
+    #[tokio::test]
+    async fn test_redirect_fixed_path() {
+        let mut router = Router::new();
+        router.redirect_fixed_path(true);
+        router.get("/users/me", named("/users/me"));
+        router.get("/users/:id/posts", named("/users/:id/posts"));
+        router.post("/users", named("/users"));
+
+        for (path, target) in [
+            ("/Users/ME", "/users/me"),
+            ("/Users//me", "/users/me"),
+            ("/USERS/AbC/Posts?x=1", "/users/AbC/posts?x=1"),
+            ("/users/me/../me", "/users/me"),
+        ] {
+            let response = router.route(request("GET", path)).await.unwrap();
+            assert_eq!(response.status, 301, "{}", path);
+            assert_eq!(location(&response), Some(target), "{}", path);
+        }
+
+        let result = router.route(request("POST", "/Users")).await;
+        assert!(matches!(result, Err(Error::RouteNotFound(_))));
+        let result = router.route(request("GET", "/Teams")).await;
+        assert!(matches!(result, Err(Error::RouteNotFound(_))));
+    }
+
+    #[tokio::test]
+    async fn test_redirect_fixed_path_matches_like_routing() {
+        let mut router = files_router();
+        router.redirect_fixed_path(true);
+        router.add_route(
+            Route::new(HttpMethod::GET, "/orders/:id", named("/orders/:id")).with_constraints(
+                crate::route_constraint::RouteConstraints::new()
+                    .add("id", Box::new(crate::route_constraint::UIntConstraint)),
+            ),
+        );
+
+        for (method, path, target) in [
+            // The most specific route wins, as when routing
+            ("GET", "/FILES/Special", "/files/special"),
+            ("GET", "/Files/Report.PDF", "/files/Report.PDF"),
+            ("GET", "/FILES/docs/../Docs/A.txt", "/files/Docs/A.txt"),
+            ("HEAD", "/Orders/42", "/orders/42"),
+        ] {
+            let response = router.route(request(method, path)).await.unwrap();
+            assert_eq!(response.status, 301, "{}", path);
+            assert_eq!(location(&response), Some(target), "{}", path);
+        }
+
+        // No redirect to a path the route's constraints would reject
+        let result = router.route(request("GET", "/Orders/abc")).await;
+        assert!(matches!(result, Err(Error::RouteNotFound(_))));
+    }
+
+    #[tokio::test]
+    async fn test_redirect_keeps_mount_prefix() {
+        let mut users = Router::new();
+        users.redirect_trailing_slash(true);
+        users.get("/:id", named("/:id"));
+
+        let mut router = Router::new();
+        router.mount("/users", users).unwrap();
+
+        let response = router.route(request("GET", "/users/7/")).await.unwrap();
+        assert_eq!(location(&response), Some("/users/7"));
+    }
+
+    /// Routes for `/files` registered in the reverse order of specificity
+    fn files_router() -> Router {
+        let mut router = Router::new();
+        router.get("/files/*filepath", |req: HttpRequest| async move {
+            let path = req.param("filepath").cloned().unwrap_or_default();
+            Ok(HttpResponse::text(format!("catch-all {}", path)))
+        });
+        router.get("/files/:name", named("/files/:name"));
+        router.get("/files/special", named("/files/special"));
+        router
+    }
+
+    #[tokio::test]
+    async fn test_catch_all_precedence() {
+        let router = files_router();
+
+        let response = get(&router, "/files/special").await.unwrap();
+        assert_eq!(response.body_ref(), b"/files/special");
+
+        let response = get(&router, "/files/report.pdf").await.unwrap();
+        assert_eq!(response.body_ref(), b"/files/:name");
+
+        let response = get(&router, "/files/docs/2024/report.pdf?v=2")
+            .await
+            .unwrap();
+        assert_eq!(response.body_ref(), b"catch-all docs/2024/report.pdf");
+
+        let response = get(&router, "/files/special/nested").await.unwrap();
+        assert_eq!(response.body_ref(), b"catch-all special/nested");
+
+        // Equally specific routes keep registration order
+        let mut router = Router::new();
+        router.get("/users/:id", named("/users/:id"));
+        router.get("/users/:name", named("/users/:name"));
+        let response = get(&router, "/users/7").await.unwrap();
+        assert_eq!(response.body_ref(), b"/users/:id");
+    }
+
+    #[tokio::test]
+    async fn test_catch_all_empty_remainder() {
+        let mut router = files_router();
+        assert!(get(&router, "/files/").await.is_err());
+        assert!(get(&router, "/files").await.is_err());
+
+        router.match_empty_catch_all(true);
+        let response = get(&router, "/files/").await.unwrap();
+        assert_eq!(response.body_ref(), b"catch-all ");
+
+        // An exact route still wins over an empty catch-all
+        router.get("/files", named("/files"));
+        let response = get(&router, "/files").await.unwrap();
+        assert_eq!(response.body_ref(), b"/files");
+    }
+
+    #[tokio::test]
+    async fn test_catch_all_redirect_trailing_slash() {
+        let mut router = files_router();
+        router.redirect_trailing_slash(true);
+
+        let response = get(&router, "/files/docs/a.pdf").await.unwrap();
+        assert_eq!(response.body_ref(), b"catch-all docs/a.pdf");
+
+        let response = get(&router, "/files/docs/").await.unwrap();
+        assert_eq!(response.status, 301);
+        assert_eq!(location(&response), Some("/files/docs"));
+    }
+
+    /// Middleware that reports the matched path it saw in a header
+    struct SeenPattern;
+
+    #[async_trait::async_trait]
+    impl Middleware for SeenPattern {
+        async fn handle(
+            &self,
+            req: HttpRequest,
+            next: crate::middleware::Next,
+        ) -> Result<HttpResponse, Error> {
+            let seen = req.matched_path().unwrap_or("none").to_string();
+            let response = match next(req).await {
+                Ok(response) => response,
+                Err(_) => HttpResponse::new(404),
+            };
+            Ok(response.with_header("x-seen".to_string(), seen))
+        }
+    }
+
+    #[tokio::test]
+    async fn test_matched_path() {
+        let mut posts = Router::new();
+        posts.get("/:post", |req: HttpRequest| async move {
+            Ok(HttpResponse::text(
+                req.matched_path().unwrap_or_default().to_string(),
+            ))
+        });
+
+        let mut router = Router::new();
+        router.use_middleware(SeenPattern);
+        router.get("/users/:id", named("/users/:id"));
+        router.mount("/posts", posts).unwrap();
+
+        let response = router.route(request("GET", "/users/7?x=1")).await.unwrap();
+        assert_eq!(response.headers.get("x-seen").unwrap(), "/users/:id");
+
+        // Middleware around a mount sees the mounted route's pattern
+        let response = router.route(request("GET", "/posts/3")).await.unwrap();
+        assert_eq!(response.headers.get("x-seen").unwrap(), "/posts/:post");
+        assert_eq!(response.body_ref(), b"/posts/:post");
+
+        let response = router.route(request("GET", "/missing")).await.unwrap();
+        assert_eq!(response.headers.get("x-seen").unwrap(), "none");
+    }
 }
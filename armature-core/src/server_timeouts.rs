@@ -0,0 +1,707 @@
+//! Connection timeouts that protect the server from slow clients.
+//!
+//! Without limits, a client can hold a connection open indefinitely by
+//! sending its request a byte at a time or by never reading the response.
+//! [`ServerTimeouts`] bounds each stage of an HTTP/1.1 connection:
+//!
+//! - **idle**: waiting for the next request on a kept-alive connection, or
+//!   for the first one on a new connection
+//! - **read header**: from the first byte of a request until its headers
+//!   are complete; also bounds the TLS handshake on HTTPS listeners
+//! - **read**: from the first byte of a request until its body has been
+//!   read, headers included
+//! - **write**: from when the handler returns its response until the
+//!   response has been sent
+//!
+//! A connection that exceeds a limit is closed. Every application applies
+//! the [defaults](ServerTimeouts::default); change them with
+//! [`Application::with_server_timeouts`](crate::Application::with_server_timeouts).
+//! A connection upgraded to another protocol, such as a
+//! [WebSocket](crate::websocket), is no longer subject to them once the
+//! upgrade response has been sent.
+//!
+//! ```
+//! use armature_core::ServerTimeouts;
+//! use std::time::Duration;
+//!
+//! let timeouts = ServerTimeouts::default()
+//!     .read_timeout(Duration::from_secs(300))
+//!     .idle_timeout(None);
+//! assert_eq!(timeouts.read_header_timeout, Some(Duration::from_secs(10)));
+//! ```
+//!
+//! # Long-lived responses
+//!
+//! The write timeout would cut off a response that legitimately stays open,
+//! such as a long download or an event stream. Responses with a
+//! `text/event-stream` content type are exempt from it. Other handlers can
+//! lift it for their own response with [`HttpRequest::disable_write_timeout`],
+//! or replace it with [`HttpRequest::set_write_deadline`]:
+//!
+//! ```
+//! use armature_core::{Error, HttpRequest, HttpResponse};
+//!
+//! async fn export(req: HttpRequest) -> Result<HttpResponse, Error> {
+//!     req.disable_write_timeout();
+//!     Ok(HttpResponse::ok().stream(|writer| async move {
+//!         for row in 0..1_000_000 {
+//!             writer.write(format!("{}\n", row)).await?;
+//!         }
+//!         Ok(())
+//!     }))
+//! }
+//! ```
+//!
+//! # HTTP/2
+//!
+//! HTTP/2 connections, whether negotiated through TLS or with
+//! [h2c](crate::Application::with_h2c), get the idle and read header
+//! timeouts until their first request arrives. After that they multiplex
+//! requests and are kept in check by keep-alive pings instead: every
+//! [`keep_alive_interval`](ServerTimeouts::keep_alive_interval) the server
+//! pings the client, and closes the connection if no reply arrives within
+//! [`keep_alive_timeout`](ServerTimeouts::keep_alive_timeout).
+
+use crate::HttpRequest;
+use crate::streaming::HyperBody;
+use hyper::body::{Body, Frame, SizeHint};
+use parking_lot::Mutex;
+use std::future::Future;
+use std::io;
+use std::pin::Pin;
+use std::sync::Arc;
+use std::task::{Context, Poll, Waker};
+use std::time::Duration;
+use tokio::io::{AsyncRead, AsyncWrite, ReadBuf};
+use tokio::time::{Instant, Sleep};
+
+/// Limits on how long a client may take at each stage of a connection.
+///
+/// `None` disables a limit. See the [module documentation](self) for what
+/// each one covers.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct ServerTimeouts {
+    /// Time to receive a request's headers, from its first byte
+    pub read_header_timeout: Option<Duration>,
+    /// Time to receive a whole request, from its first byte
+    pub read_timeout: Option<Duration>,
+    /// Time to send a response, from when the handler returns it
+    pub write_timeout: Option<Duration>,
+    /// Time a connection may wait for its next request
+    pub idle_timeout: Option<Duration>,
+    /// Time between HTTP/2 keep-alive pings
+    pub keep_alive_interval: Option<Duration>,
+    /// Time to wait for the reply to an HTTP/2 keep-alive ping
+    pub keep_alive_timeout: Duration,
+}
+
+impl Default for ServerTimeouts {
+    /// 10 seconds for headers, 60 for the whole request and for the
+    /// response, and 120 between requests. HTTP/2 connections are pinged
+    /// every 60 seconds and get 20 to reply.
+    fn default() -> Self {
+        Self {
+            read_header_timeout: Some(Duration::from_secs(10)),
+            read_timeout: Some(Duration::from_secs(60)),
+            write_timeout: Some(Duration::from_secs(60)),
+            idle_timeout: Some(Duration::from_secs(120)),
+            keep_alive_interval: Some(Duration::from_secs(60)),
+            keep_alive_timeout: Duration::from_secs(20),
+        }
+    }
+}
+
+impl ServerTimeouts {
+    /// The default limits.
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// No limits at all.
+    ///
+    /// Only suitable behind a proxy that enforces its own timeouts.
+    pub fn none() -> Self {
+        Self {
+            read_header_timeout: None,
+            read_timeout: None,
+            write_timeout: None,
+            idle_timeout: None,
+            keep_alive_interval: None,
+            keep_alive_timeout: Duration::from_secs(20),
+        }
+    }
+
+    /// Set the time allowed to receive request headers.
+    pub fn read_header_timeout(mut self, timeout: impl Into<Option<Duration>>) -> Self {
+        self.read_header_timeout = timeout.into();
+        self
+    }
+
+    /// Set the time allowed to receive a whole request.
+    pub fn read_timeout(mut self, timeout: impl Into<Option<Duration>>) -> Self {
+        self.read_timeout = timeout.into();
+        self
+    }
+
+    /// Set the time allowed to send a response.
+    pub fn write_timeout(mut self, timeout: impl Into<Option<Duration>>) -> Self {
+        self.write_timeout = timeout.into();
+        self
+    }
+
+    /// Set the time a connection may wait for its next request.
+    pub fn idle_timeout(mut self, timeout: impl Into<Option<Duration>>) -> Self {
+        self.idle_timeout = timeout.into();
+        self
+    }
+
+    /// Set how often HTTP/2 connections are pinged; `None` stops the pings.
+    pub fn keep_alive_interval(mut self, interval: impl Into<Option<Duration>>) -> Self {
+        self.keep_alive_interval = interval.into();
+        self
+    }
+
+    /// Set how long to wait for the reply to an HTTP/2 ping before closing
+    /// the connection.
+    pub fn keep_alive_timeout(mut self, timeout: Duration) -> Self {
+        self.keep_alive_timeout = timeout;
+        self
+    }
+}
+
+impl HttpRequest {
+    /// Send this request's response without the server's write timeout.
+    ///
+    /// Use it for responses that legitimately take long to send, such as
+    /// streams that stay open. Has no effect outside a server, or on
+    /// HTTP/2 connections.
+    pub fn disable_write_timeout(&self) {
+        if let Some(timeouts) = self.extensions.get::<RequestTimeouts>() {
+            *timeouts.write.lock() = WriteLimit::Disabled;
+        }
+    }
+
+    /// Require this request's response to be sent by `deadline`, instead
+    /// of within the server's write timeout.
+    ///
+    /// A deadline that has already passed when the response starts makes
+    /// any write that has to wait for the client fail.
+    pub fn set_write_deadline(&self, deadline: Instant) {
+        if let Some(timeouts) = self.extensions.get::<RequestTimeouts>() {
+            *timeouts.write.lock() = WriteLimit::Until(deadline);
+        }
+    }
+}
+
+/// Write limit chosen for one response
+#[derive(Debug, Clone, Copy)]
+enum WriteLimit {
+    /// The server's write timeout, unless the response is an event stream
+    Default,
+    Disabled,
+    Until(Instant),
+}
+
+/// What the connection is waiting to read
+#[derive(Debug, Clone, Copy)]
+enum ReadPhase {
+    /// The next request, since the given time
+    Idle(Instant),
+    /// The headers of a request that started at the given time
+    Head(Instant),
+    /// The body of a request that started at the given time
+    Body(Instant),
+    /// Nothing: the request has been read and is being handled
+    Handling,
+}
+
+#[derive(Debug)]
+struct TimerState {
+    read: ReadPhase,
+    /// Requests whose response hasn't been sent yet
+    in_flight: usize,
+    /// The last response has ended but may still be in the write buffer
+    unflushed: bool,
+    /// The connection switched protocols and is no longer HTTP
+    upgraded: bool,
+    /// The connection speaks HTTP/2, whose streams share the connection
+    multiplexed: bool,
+    write_deadline: Option<Instant>,
+    /// Task serving the connection, woken when a deadline changes
+    waker: Option<Waker>,
+}
+
+/// Deadlines of one connection, shared by its I/O and its requests.
+#[derive(Debug)]
+pub(crate) struct ConnectionTimer {
+    timeouts: ServerTimeouts,
+    state: Mutex<TimerState>,
+}
+
+impl ConnectionTimer {
+    pub(crate) fn new(timeouts: ServerTimeouts) -> Arc<Self> {
+        Arc::new(Self {
+            timeouts,
+            state: Mutex::new(TimerState {
+                read: ReadPhase::Idle(Instant::now()),
+                in_flight: 0,
+                unflushed: false,
+                upgraded: false,
+                multiplexed: false,
+                write_deadline: None,
+                waker: None,
+            }),
+        })
+    }
+
+    /// The limits this connection applies
+    pub(crate) fn timeouts(&self) -> &ServerTimeouts {
+        &self.timeouts
+    }
+
+    /// Start timing a request whose headers have been read.
+    ///
+    /// An HTTP/2 request means the connection multiplexes overlapping
+    /// streams, so from then on the per-request deadlines no longer apply
+    /// and keep-alive pings take over.
+    pub(crate) fn start_request(self: &Arc<Self>, version: hyper::Version) -> RequestTimeouts {
+        self.update(|state| {
+            state.multiplexed |= version == hyper::Version::HTTP_2;
+            let start = match state.read {
+                ReadPhase::Head(start) => start,
+                _ => Instant::now(),
+            };
+            state.read = ReadPhase::Body(start);
+            state.in_flight += 1;
+        });
+        RequestTimeouts {
+            connection: Arc::clone(self),
+            write: Arc::new(Mutex::new(WriteLimit::Default)),
+        }
+    }
+
+    fn read_deadline(&self) -> Option<Instant> {
+        let timeouts = &self.timeouts;
+        let state = self.state.lock();
+        if state.upgraded || state.multiplexed {
+            return None;
+        }
+        match state.read {
+            ReadPhase::Idle(since) => timeouts.idle_timeout.map(|t| since + t),
+            ReadPhase::Head(start) => {
+                let header = timeouts.read_header_timeout.map(|t| start + t);
+                let request = timeouts.read_timeout.map(|t| start + t);
+                header.into_iter().chain(request).min()
+            }
+            ReadPhase::Body(start) => timeouts.read_timeout.map(|t| start + t),
+            ReadPhase::Handling => None,
+        }
+    }
+
+    fn write_deadline(&self) -> Option<Instant> {
+        let state = self.state.lock();
+        state
+            .write_deadline
+            .filter(|_| !state.upgraded && !state.multiplexed)
+    }
+
+    /// Bytes arrived; on an idle connection they start a new request
+    fn bytes_read(&self) {
+        let mut state = self.state.lock();
+        if let ReadPhase::Idle(_) = state.read {
+            state.read = ReadPhase::Head(Instant::now());
+        }
+    }
+
+    /// A response body has ended. Hyper may still be writing it out, so
+    /// the connection only goes idle once it has been flushed.
+    fn request_finished(&self) {
+        let mut state = self.state.lock();
+        state.in_flight = state.in_flight.saturating_sub(1);
+        if state.in_flight == 0 {
+            state.unflushed = true;
+        }
+    }
+
+    /// Everything written so far has been flushed
+    fn flushed(&self) {
+        let mut state = self.state.lock();
+        if state.in_flight == 0 && state.unflushed {
+            state.unflushed = false;
+            state.write_deadline = None;
+            if let ReadPhase::Handling = state.read {
+                state.read = ReadPhase::Idle(Instant::now());
+            }
+        }
+    }
+
+    fn register(&self, waker: &Waker) {
+        let mut state = self.state.lock();
+        if !state.waker.as_ref().is_some_and(|w| w.will_wake(waker)) {
+            state.waker = Some(waker.clone());
+        }
+    }
+
+    /// Change the state and wake the connection so it re-arms its timers
+    fn update(&self, change: impl FnOnce(&mut TimerState)) {
+        let waker = {
+            let mut state = self.state.lock();
+            change(&mut state);
+            state.waker.clone()
+        };
+        if let Some(waker) = waker {
+            waker.wake();
+        }
+    }
+}
+
+/// Timeouts of one request, stored in its extensions.
+#[derive(Debug, Clone)]
+pub(crate) struct RequestTimeouts {
+    connection: Arc<ConnectionTimer>,
+    write: Arc<Mutex<WriteLimit>>,
+}
+
+impl RequestTimeouts {
+    /// The request body has been read; stop the read timeout
+    pub(crate) fn body_read(&self) {
+        self.connection.update(|state| {
+            if let ReadPhase::Body(_) = state.read {
+                state.read = ReadPhase::Handling;
+            }
+        });
+    }
+
+    /// Start the write timeout for `response` and track when it is sent.
+    pub(crate) fn respond(
+        self,
+        response: hyper::Response<HyperBody>,
+    ) -> hyper::Response<TimedBody<HyperBody>> {
+        let deadline = match *self.write.lock() {
+            WriteLimit::Disabled => None,
+            WriteLimit::Until(deadline) => Some(deadline),
+            WriteLimit::Default if is_event_stream(&response) => None,
+            WriteLimit::Default => self
+                .connection
+                .timeouts
+                .write_timeout
+                .map(|t| Instant::now() + t),
+        };
+        let upgraded = response.status() == hyper::StatusCode::SWITCHING_PROTOCOLS;
+        self.connection.update(|state| {
+            state.write_deadline = deadline;
+            state.upgraded |= upgraded;
+        });
+
+        let connection = self.connection;
+        response.map(|body| TimedBody {
+            inner: body,
+            connection: Some(connection),
+        })
+    }
+}
+
+fn is_event_stream<B>(response: &hyper::Response<B>) -> bool {
+    response
+        .headers()
+        .get(hyper::header::CONTENT_TYPE)
+        .and_then(|value| value.to_str().ok())
+        .is_some_and(|value| {
+            value
+                .split(';')
+                .next()
+                .unwrap_or_default()
+                .trim()
+                .eq_ignore_ascii_case("text/event-stream")
+        })
+}
+
+/// Response body that tells the connection when it has been sent.
+pub(crate) struct TimedBody<B> {
+    inner: B,
+    connection: Option<Arc<ConnectionTimer>>,
+}
+
+impl<B> TimedBody<B> {
+    fn finish(&mut self) {
+        if let Some(connection) = self.connection.take() {
+            connection.request_finished();
+        }
+    }
+}
+
+impl<B: Body + Unpin> Body for TimedBody<B> {
+    type Data = B::Data;
+    type Error = B::Error;
+
+    fn poll_frame(
+        mut self: Pin<&mut Self>,
+        cx: &mut Context<'_>,
+    ) -> Poll<Option<Result<Frame<Self::Data>, Self::Error>>> {
+        let frame = Pin::new(&mut self.inner).poll_frame(cx);
+        if let Poll::Ready(None) = frame {
+            self.finish();
+        }
+        frame
+    }
+
+    fn is_end_stream(&self) -> bool {
+        self.inner.is_end_stream()
+    }
+
+    fn size_hint(&self) -> SizeHint {
+        self.inner.size_hint()
+    }
+}
+
+impl<B> Drop for TimedBody<B> {
+    fn drop(&mut self) {
+        self.finish();
+    }
+}
+
+/// Connection I/O that fails with [`io::ErrorKind::TimedOut`] once a read
+/// or write waits past the connection's deadline.
+pub(crate) struct TimeoutStream<S> {
+    inner: S,
+    timer: Arc<ConnectionTimer>,
+    read_sleep: Pin<Box<Sleep>>,
+    write_sleep: Pin<Box<Sleep>>,
+}
+
+impl<S> TimeoutStream<S> {
+    pub(crate) fn new(inner: S, timer: Arc<ConnectionTimer>) -> Self {
+        Self {
+            inner,
+            timer,
+            read_sleep: Box::pin(tokio::time::sleep_until(Instant::now())),
+            write_sleep: Box::pin(tokio::time::sleep_until(Instant::now())),
+        }
+    }
+}
+
+/// Wait for `deadline` on `sleep`, failing once it passes
+fn poll_deadline<T>(
+    sleep: &mut Pin<Box<Sleep>>,
+    deadline: Option<Instant>,
+    cx: &mut Context<'_>,
+) -> Poll<io::Result<T>> {
+    let Some(deadline) = deadline else {
+        return Poll::Pending;
+    };
+    if sleep.deadline() != deadline {
+        sleep.as_mut().reset(deadline);
+    }
+    match sleep.as_mut().poll(cx) {
+        Poll::Ready(()) => Poll::Ready(Err(io::Error::new(
+            io::ErrorKind::TimedOut,
+            "client connection timed out",
+        ))),
+        Poll::Pending => Poll::Pending,
+    }
+}
+
+impl<S: AsyncRead + Unpin> AsyncRead for TimeoutStream<S> {
+    fn poll_read(
+        mut self: Pin<&mut Self>,
+        cx: &mut Context<'_>,
+        buf: &mut ReadBuf<'_>,
+    ) -> Poll<io::Result<()>> {
+        let this = &mut *self;
+        let filled = buf.filled().len();
+        match Pin::new(&mut this.inner).poll_read(cx, buf) {
+            Poll::Ready(Ok(())) => {
+                if buf.filled().len() > filled {
+                    this.timer.bytes_read();
+                }
+                Poll::Ready(Ok(()))
+            }
+            Poll::Ready(Err(err)) => Poll::Ready(Err(err)),
+            Poll::Pending => {
+                this.timer.register(cx.waker());
+                poll_deadline(&mut this.read_sleep, this.timer.read_deadline(), cx)
+            }
+        }
+    }
+}
+
+impl<S: AsyncWrite + Unpin> TimeoutStream<S> {
+    /// Map a pending write to a timeout once the write deadline passes
+    fn poll_write_with<T>(
+        &mut self,
+        cx: &mut Context<'_>,
+        write: impl FnOnce(Pin<&mut S>, &mut Context<'_>) -> Poll<io::Result<T>>,
+    ) -> Poll<io::Result<T>> {
+        match write(Pin::new(&mut self.inner), cx) {
+            Poll::Ready(result) => Poll::Ready(result),
+            Poll::Pending => {
+                self.timer.register(cx.waker());
+                poll_deadline(&mut self.write_sleep, self.timer.write_deadline(), cx)
+            }
+        }
+    }
+}
+
+impl<S: AsyncWrite + Unpin> AsyncWrite for TimeoutStream<S> {
+    fn poll_write(
+        mut self: Pin<&mut Self>,
+        cx: &mut Context<'_>,
+        buf: &[u8],
+    ) -> Poll<io::Result<usize>> {
+        self.poll_write_with(cx, |inner, cx| inner.poll_write(cx, buf))
+    }
+
+    fn poll_write_vectored(
+        mut self: Pin<&mut Self>,
+        cx: &mut Context<'_>,
+        bufs: &[io::IoSlice<'_>],
+    ) -> Poll<io::Result<usize>> {
+        self.poll_write_with(cx, |inner, cx| inner.poll_write_vectored(cx, bufs))
+    }
+
+    fn is_write_vectored(&self) -> bool {
+        self.inner.is_write_vectored()
+    }
+
+    fn poll_flush(mut self: Pin<&mut Self>, cx: &mut Context<'_>) -> Poll<io::Result<()>> {
+        let flushed = self.poll_write_with(cx, |inner, cx| inner.poll_flush(cx));
+        if let Poll::Ready(Ok(())) = flushed {
+            self.timer.flushed();
+        }
+        flushed
+    }
+
+    fn poll_shutdown(mut self: Pin<&mut Self>, cx: &mut Context<'_>) -> Poll<io::Result<()>> {
+        Pin::new(&mut self.inner).poll_shutdown(cx)
+    }
+}
+
+/// Whether a connection error was caused by one of these timeouts
+pub(crate) fn is_timeout(err: &(dyn std::error::Error + 'static)) -> bool {
+    let mut source = Some(err);
+    while let Some(err) = source {
+        if let Some(io) = err.downcast_ref::<io::Error>()
+            && io.kind() == io::ErrorKind::TimedOut
+        {
+            return true;
+        }
+        source = err.source();
+    }
+    false
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use http_body_util::{Either, Full};
+
+    fn timeouts() -> ServerTimeouts {
+        ServerTimeouts::none()
+            .read_header_timeout(Duration::from_secs(1))
+            .read_timeout(Duration::from_secs(5))
+            .write_timeout(Duration::from_secs(10))
+            .idle_timeout(Duration::from_secs(30))
+    }
+
+    fn response(content_type: &str) -> hyper::Response<HyperBody> {
+        hyper::Response::builder()
+            .header("content-type", content_type)
+            .body(Either::Left(Full::new(bytes::Bytes::from_static(b"hi"))))
+            .unwrap()
+    }
+
+    fn phase(timer: &ConnectionTimer) -> ReadPhase {
+        timer.state.lock().read
+    }
+
+    #[test]
+    fn test_read_deadlines_follow_the_request() {
+        let timer = ConnectionTimer::new(timeouts());
+        let ReadPhase::Idle(since) = phase(&timer) else {
+            panic!("new connections start idle");
+        };
+        assert_eq!(timer.read_deadline(), Some(since + Duration::from_secs(30)));
+
+        // The header timeout is the tighter one while headers arrive
+        timer.bytes_read();
+        let ReadPhase::Head(start) = phase(&timer) else {
+            panic!("bytes start a request");
+        };
+        assert_eq!(timer.read_deadline(), Some(start + Duration::from_secs(1)));
+        timer.bytes_read();
+        assert!(matches!(phase(&timer), ReadPhase::Head(t) if t == start));
+
+        // The read timeout still counts from the first byte
+        let request = timer.start_request(hyper::Version::HTTP_11);
+        assert_eq!(timer.read_deadline(), Some(start + Duration::from_secs(5)));
+
+        // Nothing is read while the handler runs
+        request.body_read();
+        assert_eq!(timer.read_deadline(), None);
+        assert_eq!(timer.write_deadline(), None);
+    }
+
+    #[test]
+    fn test_connection_idles_once_the_response_is_flushed() {
+        let timer = ConnectionTimer::new(timeouts());
+        let request = timer.start_request(hyper::Version::HTTP_11);
+        request.body_read();
+
+        let before = Instant::now();
+        let response = request.respond(response("text/plain"));
+        let deadline = timer.write_deadline().unwrap();
+        assert!(deadline >= before + Duration::from_secs(10));
+
+        // The body ending doesn't mean hyper has written it out yet
+        drop(response);
+        assert_eq!(timer.read_deadline(), None);
+        assert_eq!(timer.write_deadline(), Some(deadline));
+
+        timer.flushed();
+        assert_eq!(timer.write_deadline(), None);
+        let ReadPhase::Idle(since) = phase(&timer) else {
+            panic!("flushed connections go idle");
+        };
+        assert!(since >= before);
+        assert_eq!(timer.read_deadline(), Some(since + Duration::from_secs(30)));
+    }
+
+    #[test]
+    fn test_write_limits() {
+        let timer = ConnectionTimer::new(timeouts());
+
+        let request = timer.start_request(hyper::Version::HTTP_11);
+        let _response = request.respond(response("text/event-stream; charset=utf-8"));
+        assert_eq!(timer.write_deadline(), None);
+
+        let mut req = HttpRequest::new("GET".into(), "/".into());
+        let request = timer.start_request(hyper::Version::HTTP_11);
+        req.extensions.insert(request.clone());
+        req.disable_write_timeout();
+        let _response = request.respond(response("text/plain"));
+        assert_eq!(timer.write_deadline(), None);
+
+        let deadline = Instant::now() + Duration::from_secs(300);
+        let mut req = HttpRequest::new("GET".into(), "/".into());
+        let request = timer.start_request(hyper::Version::HTTP_11);
+        req.extensions.insert(request.clone());
+        req.set_write_deadline(deadline);
+        let _response = request.respond(response("text/plain"));
+        assert_eq!(timer.write_deadline(), Some(deadline));
+
+        // Outside a server the overrides do nothing
+        let req = HttpRequest::new("GET".into(), "/".into());
+        req.disable_write_timeout();
+    }
+
+    #[test]
+    fn test_multiplexed_connections_have_no_deadlines() {
+        let timer = ConnectionTimer::new(timeouts());
+        timer.bytes_read();
+        let request = timer.start_request(hyper::Version::HTTP_2);
+        assert_eq!(timer.read_deadline(), None);
+
+        let _response = request.respond(response("text/plain"));
+        assert_eq!(timer.write_deadline(), None);
+    }
+}
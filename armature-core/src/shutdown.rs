@@ -11,6 +11,36 @@
 //! - **Timeout Support** - Force shutdown after timeout
 //! - **Signal Handling** - Respond to SIGTERM, SIGINT
 //!
+//! # Stopping a Server
+//!
+//! [`ShutdownHandle`] stops a running [`Application`](crate::Application):
+//! the listener stops accepting, in-flight requests finish, `on_shutdown`
+//! hooks run, and connections still open at the deadline are closed.
+//!
+//! ```no_run
+//! use armature_core::*;
+//! use std::time::Duration;
+//!
+//! # async fn example(app: Application) -> Result<(), Error> {
+//! let handle = app.shutdown_handle();
+//! handle.on_shutdown(|| async {
+//!     println!("Flushing background workers...");
+//!     Ok(())
+//! });
+//!
+//! tokio::spawn(async move {
+//!     tokio::signal::ctrl_c().await.ok();
+//!     if let Err(e) = handle.shutdown(Duration::from_secs(30)).await {
+//!         eprintln!("Forced shutdown: {}", e);
+//!     }
+//! });
+//!
+//! // Returns once shutdown has completed
+//! app.listen(3000).await?;
+//! # Ok(())
+//! # }
+//! ```
+//!
 //! # Quick Start
 //!
 //! ```no_run
@@ -38,12 +68,20 @@
 //! ```
 
 use crate::Error;
+use hyper::body::{Body, Incoming};
+use hyper::rt::bounds::Http2ServerConnExec;
+use hyper::server::conn::{http1, http2};
+use hyper::service::{HttpService, Service};
+use hyper::{Request, Response};
+use hyper_util::server::conn::auto;
+use std::future::Future;
+use std::pin::Pin;
 use std::sync::Arc;
 use std::sync::atomic::{AtomicBool, AtomicU64, Ordering};
 use std::time::Duration;
-use tokio::sync::RwLock;
+use tokio::sync::{RwLock, watch};
 use tokio::time::timeout;
-use tracing::{error, info, warn};
+use tracing::{debug, error, info, warn};
 
 /// Shutdown hook function type
 ///
@@ -364,6 +402,441 @@ impl<'a> ShutdownPhases<'a> {
     }
 }
 
+/// State of a server controlled by a [`ShutdownHandle`]
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub(crate) enum ServerState {
+    /// Accepting and serving connections
+    Running,
+    /// No longer accepting; open connections finish their current request
+    Draining,
+    /// Shutdown finished; remaining connections must close immediately
+    Stopped,
+}
+
+/// Handle for gracefully stopping a running server
+///
+/// Obtained from [`Application::shutdown_handle`](crate::Application::shutdown_handle)
+/// before calling `listen`. The handle is cheap to clone and can be moved
+/// into a signal handler task.
+#[derive(Clone)]
+pub struct ShutdownHandle {
+    inner: Arc<ShutdownState>,
+}
+
+struct ShutdownState {
+    state: watch::Sender<ServerState>,
+    active: watch::Sender<usize>,
+    hooks: std::sync::Mutex<Vec<ShutdownHook>>,
+}
+
+impl ShutdownHandle {
+    /// Create a handle for a server that has not been shut down
+    pub fn new() -> Self {
+        Self {
+            inner: Arc::new(ShutdownState {
+                state: watch::Sender::new(ServerState::Running),
+                active: watch::Sender::new(0),
+                hooks: std::sync::Mutex::new(Vec::new()),
+            }),
+        }
+    }
+
+    /// Register a hook to run when shutdown begins
+    ///
+    /// Hooks run concurrently with connection draining and
+    /// [`shutdown`](Self::shutdown) waits for them within the same deadline.
+    /// Errors returned by hooks are logged.
+    pub fn on_shutdown<F, Fut>(&self, hook: F)
+    where
+        F: Fn() -> Fut + Send + Sync + 'static,
+        Fut: std::future::Future<Output = Result<(), Error>> + Send + 'static,
+    {
+        self.inner
+            .hooks
+            .lock()
+            .unwrap_or_else(|e| e.into_inner())
+            .push(Box::new(move || Box::pin(hook())));
+    }
+
+    /// Check if shutdown has been initiated
+    pub fn is_shutting_down(&self) -> bool {
+        *self.inner.state.borrow() != ServerState::Running
+    }
+
+    /// Number of connections currently open
+    pub fn active_connections(&self) -> usize {
+        *self.inner.active.borrow()
+    }
+
+    /// Gracefully stop the server
+    ///
+    /// Stops accepting new connections, lets open connections finish the
+    /// request they are serving, and runs the registered `on_shutdown` hooks.
+    /// If connections or hooks are still running when `timeout` elapses, the
+    /// remaining connections are closed and [`Error::ShutdownTimeout`] is
+    /// returned.
+    ///
+    /// Calling this again while a shutdown is in progress waits for it to
+    /// finish.
+    ///
+    /// # Examples
+    ///
+    /// ```
+    /// use armature_core::*;
+    /// use std::time::Duration;
+    ///
+    /// # tokio_test::block_on(async {
+    /// let handle = ShutdownHandle::new();
+    /// handle.shutdown(Duration::from_secs(5)).await.unwrap();
+    /// assert!(handle.is_shutting_down());
+    /// # });
+    /// ```
+    pub async fn shutdown(&self, timeout_duration: Duration) -> Result<(), Error> {
+        let initiated = self.inner.state.send_if_modified(|state| {
+            if *state == ServerState::Running {
+                *state = ServerState::Draining;
+                true
+            } else {
+                false
+            }
+        });
+        if !initiated {
+            self.stopped().await;
+            return Ok(());
+        }
+
+        info!(
+            active_connections = self.active_connections(),
+            "Initiating graceful shutdown"
+        );
+
+        let hooks: Vec<_> = self
+            .inner
+            .hooks
+            .lock()
+            .unwrap_or_else(|e| e.into_inner())
+            .iter()
+            .map(|hook| tokio::spawn(hook()))
+            .collect();
+
+        let mut active = self.inner.active.subscribe();
+        let completed = timeout(timeout_duration, async {
+            // The sender lives in `self`, so this cannot fail.
+            let _ = active.wait_for(|count| *count == 0).await;
+            for (i, hook) in hooks.into_iter().enumerate() {
+                match hook.await {
+                    Ok(Ok(())) => debug!("Shutdown hook {} completed", i + 1),
+                    Ok(Err(e)) => error!("Shutdown hook {} failed: {}", i + 1, e),
+                    Err(e) => error!("Shutdown hook {} panicked: {}", i + 1, e),
+                }
+            }
+        })
+        .await;
+
+        let remaining = self.active_connections();
+        self.inner.state.send_replace(ServerState::Stopped);
+
+        match completed {
+            Ok(()) => {
+                info!("Graceful shutdown complete");
+                Ok(())
+            }
+            Err(_) => {
+                warn!(
+                    active_connections = remaining,
+                    "Shutdown deadline exceeded, closing remaining connections"
+                );
+                Err(Error::ShutdownTimeout(format!(
+                    "{} connection(s) still active after {:?}",
+                    remaining, timeout_duration
+                )))
+            }
+        }
+    }
+
+    /// Close every connection now, without waiting for in-flight requests
+    pub(crate) fn force_stop(&self) {
+        self.inner.state.send_replace(ServerState::Stopped);
+    }
+
+    /// Wait until a shutdown has finished
+    pub(crate) async fn stopped(&self) {
+        let mut state = self.inner.state.subscribe();
+        let _ = state.wait_for(|s| *s == ServerState::Stopped).await;
+    }
+
+    /// Subscribe to server state changes
+    pub(crate) fn subscribe(&self) -> watch::Receiver<ServerState> {
+        self.inner.state.subscribe()
+    }
+
+    /// Register an open connection
+    ///
+    /// The connection counts as active until the returned guard is dropped.
+    pub(crate) fn track(&self) -> ActiveConnection {
+        self.inner.active.send_modify(|count| *count += 1);
+        ActiveConnection {
+            handle: self.clone(),
+        }
+    }
+}
+
+impl Default for ShutdownHandle {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+impl std::fmt::Debug for ShutdownHandle {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.debug_struct("ShutdownHandle")
+            .field("state", &*self.inner.state.borrow())
+            .field("active_connections", &self.active_connections())
+            .finish()
+    }
+}
+
+/// Signals that stop a server started with
+/// [`Application::listen_with_signals`](crate::Application::listen_with_signals)
+///
+/// The first signal starts a graceful shutdown that drains connections for
+/// up to the drain timeout. A second signal while draining closes the
+/// remaining connections immediately.
+///
+/// # Examples
+///
+/// ```no_run
+/// use armature_core::*;
+/// use std::time::Duration;
+///
+/// # async fn example(app: Application) -> Result<(), Error> {
+/// // SIGINT and SIGTERM, draining for up to 10 seconds
+/// let signals = ShutdownSignals::new().drain_timeout(Duration::from_secs(10));
+/// app.listen_with_signals(3000, signals).await?;
+/// # Ok(())
+/// # }
+/// ```
+#[derive(Debug, Clone)]
+pub struct ShutdownSignals {
+    #[cfg(unix)]
+    signals: Vec<tokio::signal::unix::SignalKind>,
+    drain_timeout: Duration,
+}
+
+impl ShutdownSignals {
+    /// SIGINT and SIGTERM (Ctrl-C on other platforms), draining for up to
+    /// 30 seconds
+    pub fn new() -> Self {
+        Self {
+            #[cfg(unix)]
+            signals: vec![
+                tokio::signal::unix::SignalKind::interrupt(),
+                tokio::signal::unix::SignalKind::terminate(),
+            ],
+            drain_timeout: Duration::from_secs(30),
+        }
+    }
+
+    /// Listen for these signals instead of SIGINT and SIGTERM
+    #[cfg(unix)]
+    pub fn signals(
+        mut self,
+        signals: impl IntoIterator<Item = tokio::signal::unix::SignalKind>,
+    ) -> Self {
+        self.signals = signals.into_iter().collect();
+        self
+    }
+
+    /// How long to wait for in-flight requests before closing connections
+    pub fn drain_timeout(mut self, timeout_duration: Duration) -> Self {
+        self.drain_timeout = timeout_duration;
+        self
+    }
+
+    /// The configured drain timeout
+    pub(crate) fn drain_timeout_duration(&self) -> Duration {
+        self.drain_timeout
+    }
+
+    /// Install the signal handlers and forward each signal received, by
+    /// name, to the returned channel
+    pub(crate) fn install(&self) -> std::io::Result<tokio::sync::mpsc::UnboundedReceiver<String>> {
+        let (tx, rx) = tokio::sync::mpsc::unbounded_channel();
+
+        #[cfg(unix)]
+        for kind in &self.signals {
+            let mut stream = tokio::signal::unix::signal(*kind)?;
+            let name = signal_name(*kind);
+            let tx = tx.clone();
+            tokio::spawn(async move {
+                while stream.recv().await.is_some() {
+                    if tx.send(name.clone()).is_err() {
+                        break;
+                    }
+                }
+            });
+        }
+
+        #[cfg(not(unix))]
+        tokio::spawn(async move {
+            while tokio::signal::ctrl_c().await.is_ok() {
+                if tx.send("CTRL_C".to_string()).is_err() {
+                    break;
+                }
+            }
+        });
+
+        Ok(rx)
+    }
+}
+
+impl Default for ShutdownSignals {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+/// Conventional name of a signal, for logs and lifecycle hooks
+#[cfg(unix)]
+fn signal_name(kind: tokio::signal::unix::SignalKind) -> String {
+    use tokio::signal::unix::SignalKind;
+
+    let known = [
+        (SignalKind::interrupt(), "SIGINT"),
+        (SignalKind::terminate(), "SIGTERM"),
+        (SignalKind::hangup(), "SIGHUP"),
+        (SignalKind::quit(), "SIGQUIT"),
+        (SignalKind::user_defined1(), "SIGUSR1"),
+        (SignalKind::user_defined2(), "SIGUSR2"),
+    ];
+    known.iter().find(|(known, _)| *known == kind).map_or_else(
+        || format!("signal {}", kind.as_raw_value()),
+        |(_, name)| name.to_string(),
+    )
+}
+
+/// RAII guard for a connection registered with a [`ShutdownHandle`]
+pub(crate) struct ActiveConnection {
+    handle: ShutdownHandle,
+}
+
+impl Drop for ActiveConnection {
+    fn drop(&mut self) {
+        self.handle.inner.active.send_modify(|count| *count -= 1);
+    }
+}
+
+/// A server connection that can be asked to finish and close
+pub(crate) trait GracefulConnection: Future<Output = Result<(), Self::Error>> {
+    /// Error the connection fails with
+    type Error;
+
+    /// Stop keep-alive and close once the in-flight request completes
+    fn graceful_shutdown(self: Pin<&mut Self>);
+}
+
+impl<I, S, B> GracefulConnection for http1::Connection<I, S>
+where
+    S: HttpService<Incoming, ResBody = B>,
+    S::Error: Into<Box<dyn std::error::Error + Send + Sync>>,
+    I: hyper::rt::Read + hyper::rt::Write + Unpin + 'static,
+    B: Body + 'static,
+    B::Error: Into<Box<dyn std::error::Error + Send + Sync>>,
+{
+    type Error = hyper::Error;
+
+    fn graceful_shutdown(self: Pin<&mut Self>) {
+        http1::Connection::graceful_shutdown(self);
+    }
+}
+
+impl<I, S, B> GracefulConnection for http1::UpgradeableConnection<I, S>
+where
+    S: HttpService<Incoming, ResBody = B>,
+    S::Error: Into<Box<dyn std::error::Error + Send + Sync>>,
+    I: hyper::rt::Read + hyper::rt::Write + Unpin + Send + 'static,
+    B: Body + 'static,
+    B::Error: Into<Box<dyn std::error::Error + Send + Sync>>,
+{
+    type Error = hyper::Error;
+
+    fn graceful_shutdown(self: Pin<&mut Self>) {
+        http1::UpgradeableConnection::graceful_shutdown(self);
+    }
+}
+
+impl<I, S, B, E> GracefulConnection for http2::Connection<I, S, E>
+where
+    S: HttpService<Incoming, ResBody = B>,
+    S::Error: Into<Box<dyn std::error::Error + Send + Sync>>,
+    I: hyper::rt::Read + hyper::rt::Write + Unpin,
+    B: Body + 'static,
+    B::Error: Into<Box<dyn std::error::Error + Send + Sync>>,
+    E: Http2ServerConnExec<S::Future, B>,
+{
+    type Error = hyper::Error;
+
+    /// Sends GOAWAY, letting open streams finish
+    fn graceful_shutdown(self: Pin<&mut Self>) {
+        http2::Connection::graceful_shutdown(self);
+    }
+}
+
+impl<I, S, B, E> GracefulConnection for auto::UpgradeableConnection<'_, I, S, E>
+where
+    S: Service<Request<Incoming>, Response = Response<B>>,
+    S::Future: 'static,
+    S::Error: Into<Box<dyn std::error::Error + Send + Sync>>,
+    I: hyper::rt::Read + hyper::rt::Write + Unpin + Send + 'static,
+    B: Body + 'static,
+    B::Error: Into<Box<dyn std::error::Error + Send + Sync>>,
+    E: auto::HttpServerConnExec<S::Future, B>,
+{
+    type Error = Box<dyn std::error::Error + Send + Sync>;
+
+    fn graceful_shutdown(self: Pin<&mut Self>) {
+        auto::UpgradeableConnection::graceful_shutdown(self);
+    }
+}
+
+/// Drive a connection until it completes or the server shuts down
+///
+/// When draining starts the connection is told to finish its current request
+/// and close; once shutdown has finished it is dropped, closing the socket.
+pub(crate) async fn serve_connection<C>(
+    conn: C,
+    mut state: watch::Receiver<ServerState>,
+) -> Result<(), C::Error>
+where
+    C: GracefulConnection,
+{
+    tokio::pin!(conn);
+    let mut draining = false;
+
+    loop {
+        match *state.borrow_and_update() {
+            ServerState::Running => {}
+            ServerState::Draining => {
+                if !draining {
+                    draining = true;
+                    conn.as_mut().graceful_shutdown();
+                }
+            }
+            ServerState::Stopped => return Ok(()),
+        }
+
+        tokio::select! {
+            result = conn.as_mut() => return result,
+            changed = state.changed() => {
+                if changed.is_err() {
+                    // The handle is gone, so no shutdown can be requested.
+                    return conn.await;
+                }
+            }
+        }
+    }
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -449,4 +922,70 @@ mod tests {
 
         assert_eq!(*manager.timeout.read().await, Duration::from_secs(60));
     }
+
+    #[tokio::test]
+    async fn test_shutdown_handle_runs_hooks() {
+        let handle = ShutdownHandle::new();
+        let calls = Arc::new(AtomicU64::new(0));
+
+        let counter = calls.clone();
+        handle.on_shutdown(move || {
+            let counter = counter.clone();
+            async move {
+                counter.fetch_add(1, Ordering::SeqCst);
+                Ok(())
+            }
+        });
+        handle.on_shutdown(|| async { Err(Error::Internal("flush failed".to_string())) });
+
+        assert!(!handle.is_shutting_down());
+        handle.shutdown(Duration::from_secs(1)).await.unwrap();
+        assert!(handle.is_shutting_down());
+        assert_eq!(calls.load(Ordering::SeqCst), 1);
+
+        // A second call does not run the hooks again
+        handle.shutdown(Duration::from_secs(1)).await.unwrap();
+        assert_eq!(calls.load(Ordering::SeqCst), 1);
+    }
+
+    #[tokio::test]
+    async fn test_shutdown_handle_waits_for_connections() {
+        let handle = ShutdownHandle::new();
+        let connection = handle.track();
+        assert_eq!(handle.active_connections(), 1);
+
+        tokio::spawn(async move {
+            tokio::time::sleep(Duration::from_millis(50)).await;
+            drop(connection);
+        });
+
+        handle.shutdown(Duration::from_secs(5)).await.unwrap();
+        assert_eq!(handle.active_connections(), 0);
+    }
+
+    #[tokio::test]
+    async fn test_shutdown_handle_deadline() {
+        let handle = ShutdownHandle::new();
+        let _connection = handle.track();
+        let mut state = handle.subscribe();
+
+        let start = tokio::time::Instant::now();
+        let result = handle.shutdown(Duration::from_millis(50)).await;
+
+        assert!(start.elapsed() < Duration::from_secs(1));
+        match result {
+            Err(Error::ShutdownTimeout(msg)) => assert!(msg.contains("1 connection")),
+            other => panic!("expected shutdown timeout, got {:?}", other),
+        }
+        assert_eq!(*state.borrow_and_update(), ServerState::Stopped);
+    }
+
+    #[tokio::test]
+    async fn test_shutdown_handle_hook_deadline() {
+        let handle = ShutdownHandle::new();
+        handle.on_shutdown(std::future::pending);
+
+        let result = handle.shutdown(Duration::from_millis(50)).await;
+        assert!(matches!(result, Err(Error::ShutdownTimeout(_))));
+    }
 }
@@ -1,6 +1,7 @@
 // Server-Sent Events (SSE) support for Armature
 
-use crate::Error;
+use crate::streaming::StreamWriter;
+use crate::{Error, HttpRequest, HttpResponse};
 use std::time::Duration;
 use tokio::sync::mpsc;
 use tokio_stream::wrappers::ReceiverStream;
@@ -49,21 +50,44 @@ impl ServerSentEvent {
         }
     }
 
+    /// Set the event ID
+    pub fn id(mut self, id: impl Into<String>) -> Self {
+        self.id = Some(id.into());
+        self
+    }
+
+    /// Set the event type
+    pub fn event(mut self, event: impl Into<String>) -> Self {
+        self.event = Some(event.into());
+        self
+    }
+
+    /// Set the reconnection delay the client should use
+    pub fn retry(mut self, retry: Duration) -> Self {
+        self.retry = Some(retry.as_millis() as u64);
+        self
+    }
+
     /// Convert to SSE format string
+    ///
+    /// Each line of `data` becomes its own `data:` field, so the client
+    /// reassembles the original text. Line breaks in `id` and `event` would
+    /// start new fields and are removed.
     #[allow(clippy::inherent_to_string)]
     pub fn to_string(&self) -> String {
         let mut output = String::new();
 
         if let Some(ref id) = self.id {
-            output.push_str(&format!("id: {}\n", id));
+            output.push_str(&format!("id: {}\n", single_line(id)));
         }
 
         if let Some(ref event) = self.event {
-            output.push_str(&format!("event: {}\n", event));
+            output.push_str(&format!("event: {}\n", single_line(event)));
         }
 
-        // Handle multi-line data
-        for line in self.data.lines() {
+        // Handle multi-line data; empty data still needs a data field for
+        // the client to dispatch the event
+        for line in self.data.split("\r\n").flat_map(|l| l.split(['\r', '\n'])) {
             output.push_str(&format!("data: {}\n", line));
         }
 
@@ -76,6 +100,113 @@ impl ServerSentEvent {
     }
 }
 
+/// Remove line breaks from a single-line field
+fn single_line(value: &str) -> std::borrow::Cow<'_, str> {
+    if value.contains(['\r', '\n']) {
+        value.replace(['\r', '\n'], "").into()
+    } else {
+        value.into()
+    }
+}
+
+/// Event stream handed to a [`HttpResponse::sse`] callback.
+///
+/// Each event is written and flushed to the client as soon as it is sent.
+/// When the client disconnects, sends fail with an [`Error::Io`] of kind
+/// [`BrokenPipe`](std::io::ErrorKind::BrokenPipe) and
+/// [`EventStream::closed`] resolves.
+pub struct EventStream {
+    writer: StreamWriter,
+}
+
+impl EventStream {
+    /// Send an event
+    pub async fn send(&self, event: ServerSentEvent) -> Result<(), Error> {
+        self.writer.write(event.to_string()).await
+    }
+
+    /// Send an event with just data
+    pub async fn send_data(&self, data: impl Into<String>) -> Result<(), Error> {
+        self.send(ServerSentEvent::new(data.into())).await
+    }
+
+    /// Send a value serialized as JSON data
+    pub async fn send_json<T: serde::Serialize>(&self, data: &T) -> Result<(), Error> {
+        let json = serde_json::to_string(data).map_err(|e| Error::Serialization(e.to_string()))?;
+        self.send_data(json).await
+    }
+
+    /// Send a comment, which clients ignore
+    ///
+    /// Sending one periodically keeps proxies from closing an idle stream.
+    pub async fn comment(&self, text: &str) -> Result<(), Error> {
+        let mut output = String::new();
+        for line in text.split("\r\n").flat_map(|l| l.split(['\r', '\n'])) {
+            output.push_str(&format!(": {}\n", line));
+        }
+        output.push('\n');
+        self.writer.write(output).await
+    }
+
+    /// Check whether the client has gone away
+    pub fn is_closed(&self) -> bool {
+        self.writer.is_closed()
+    }
+
+    /// Wait until the client disconnects
+    ///
+    /// Useful with `tokio::select!` while waiting for the next event.
+    pub async fn closed(&self) {
+        self.writer.closed().await
+    }
+}
+
+impl HttpResponse {
+    /// Respond with a stream of Server-Sent Events.
+    ///
+    /// Sets `Content-Type: text/event-stream`, disables caching and proxy
+    /// buffering, and runs `f` in the background to produce events. The
+    /// stream ends when `f` returns.
+    ///
+    /// # Example
+    ///
+    /// ```
+    /// use armature_core::{HttpResponse, ServerSentEvent};
+    /// use std::time::Duration;
+    ///
+    /// # #[tokio::main]
+    /// # async fn main() {
+    /// let response = HttpResponse::sse(|events| async move {
+    ///     for n in 1..=3 {
+    ///         let event = ServerSentEvent::new(n.to_string()).id(n.to_string()).event("tick");
+    ///         events.send(event).await?;
+    ///         tokio::time::sleep(Duration::from_secs(1)).await;
+    ///     }
+    ///     Ok(())
+    /// });
+    /// # let _ = response;
+    /// # }
+    /// ```
+    pub fn sse<F, Fut>(f: F) -> Self
+    where
+        F: FnOnce(EventStream) -> Fut + Send + 'static,
+        Fut: std::future::Future<Output = Result<(), Error>> + Send + 'static,
+    {
+        HttpResponse::ok()
+            .with_header("Content-Type".to_string(), "text/event-stream".to_string())
+            .with_header("Cache-Control".to_string(), "no-cache".to_string())
+            .with_header("X-Accel-Buffering".to_string(), "no".to_string())
+            .stream(|writer| f(EventStream { writer }))
+    }
+}
+
+impl HttpRequest {
+    /// The `Last-Event-ID` header a reconnecting SSE client sends
+    pub fn last_event_id(&self) -> Option<&str> {
+        self.header("last-event-id")
+    }
+}
+
 /// SSE stream builder
 pub struct SseStream {
     tx: mpsc::Sender<Result<String, Error>>,
@@ -206,3 +337,122 @@ impl Default for SseBroadcaster {
         Self::new()
     }
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use http_body_util::BodyExt;
+
+    #[test]
+    fn test_event_wire_format() {
+        let event = ServerSentEvent::new("hello".to_string())
+            .id("7")
+            .event("greeting")
+            .retry(Duration::from_secs(3));
+        assert_eq!(
+            event.to_string(),
+            "id: 7\nevent: greeting\ndata: hello\nretry: 3000\n\n"
+        );
+
+        assert_eq!(
+            ServerSentEvent::new("x".to_string()).to_string(),
+            "data: x\n\n"
+        );
+    }
+
+    #[test]
+    fn test_multi_line_data() {
+        let event = ServerSentEvent::new("one\ntwo\r\nthree\rfour".to_string());
+        assert_eq!(
+            event.to_string(),
+            "data: one\ndata: two\ndata: three\ndata: four\n\n"
+        );
+
+        // Leading, trailing and blank lines survive the round trip
+        let event = ServerSentEvent::new("\nmiddle\n".to_string());
+        assert_eq!(event.to_string(), "data: \ndata: middle\ndata: \n\n");
+
+        let event = ServerSentEvent::new(String::new());
+        assert_eq!(event.to_string(), "data: \n\n");
+    }
+
+    #[test]
+    fn test_single_line_fields_cannot_inject() {
+        let event = ServerSentEvent::new("x".to_string())
+            .id("1\ndata: forged")
+            .event("a\r\nb");
+        assert_eq!(
+            event.to_string(),
+            "id: 1data: forged\nevent: ab\ndata: x\n\n"
+        );
+    }
+
+    #[tokio::test]
+    async fn test_sse_response_flushes_each_event() {
+        let response = HttpResponse::sse(|events| async move {
+            events
+                .send(ServerSentEvent::new("first".to_string()).id("1"))
+                .await?;
+            events.comment("ping").await?;
+            events.send_json(&serde_json::json!({"n": 2})).await?;
+            Ok(())
+        });
+        assert_eq!(
+            response.headers.get("Content-Type").unwrap(),
+            "text/event-stream"
+        );
+        assert_eq!(response.headers.get("Cache-Control").unwrap(), "no-cache");
+
+        let mut body = response.into_hyper_response().into_body();
+        let mut frames = Vec::new();
+        while let Some(frame) = body.frame().await {
+            let data = frame.unwrap().into_data().unwrap();
+            frames.push(String::from_utf8(data.to_vec()).unwrap());
+        }
+        assert_eq!(
+            frames,
+            [
+                "id: 1\ndata: first\n\n",
+                ": ping\n\n",
+                "data: {\"n\":2}\n\n"
+            ]
+        );
+    }
+
+    #[tokio::test]
+    async fn test_sse_stops_when_client_disconnects() {
+        let (done_tx, done_rx) = tokio::sync::oneshot::channel();
+
+        let response = HttpResponse::sse(|events| async move {
+            events.send_data("hello").await?;
+            events.closed().await;
+            let result = events.send_data("too late").await;
+            let _ = done_tx.send((events.is_closed(), result));
+            Ok(())
+        });
+
+        let mut body = response.into_hyper_response().into_body();
+        let first = body.frame().await.unwrap().unwrap().into_data().unwrap();
+        assert_eq!(&first[..], b"data: hello\n\n");
+
+        // Dropping the body is what hyper does when the client goes away
+        drop(body);
+        let (closed, result) = tokio::time::timeout(Duration::from_secs(5), done_rx)
+            .await
+            .unwrap()
+            .unwrap();
+        assert!(closed);
+        match result {
+            Err(Error::Io(err)) => assert_eq!(err.kind(), std::io::ErrorKind::BrokenPipe),
+            other => panic!("expected a broken pipe, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn test_last_event_id() {
+        let mut req = HttpRequest::new("GET".into(), "/events".into());
+        assert_eq!(req.last_event_id(), None);
+        req.headers.insert("Last-Event-ID".into(), "42".into());
+        assert_eq!(req.last_event_id(), Some("42"));
+    }
+}
@@ -7,11 +7,25 @@
 //! - Content-Type detection
 //! - Security (path traversal prevention)
 //! - File type-based cache policies
+//! - Byte range requests for large media
+//! - Optional directory listings and custom 404 responses
+//!
+//! # Mounting Under a Prefix
+//!
+//! ```no_run
+//! use armature_core::{Router, StaticAssetsConfig};
+//!
+//! let mut router = Router::new();
+//! router
+//!     .static_files("/assets", StaticAssetsConfig::new("public").spa_mode())
+//!     .expect("public directory exists");
+//! ```
 
 use crate::{Error, HttpRequest, HttpResponse};
 use std::collections::HashMap;
-use std::io::Write;
-use std::path::{Path, PathBuf};
+use std::io::{SeekFrom, Write};
+use std::path::{Component, Path, PathBuf};
+use std::sync::Arc;
 use std::time::{Duration, SystemTime};
 
 /// Cache strategy for static assets
@@ -315,13 +329,60 @@ impl FileType {
             .to_string(),
             FileType::Html => "text/html".to_string(),
             FileType::Json => "application/json".to_string(),
-            FileType::Video => "video/mp4".to_string(),
-            FileType::Audio => "audio/mpeg".to_string(),
-            FileType::Other => "application/octet-stream".to_string(),
+            FileType::Video => match path.extension().and_then(|ext| ext.to_str()) {
+                Some("webm") => "video/webm",
+                Some("ogv") => "video/ogg",
+                _ => "video/mp4",
+            }
+            .to_string(),
+            FileType::Audio => match path.extension().and_then(|ext| ext.to_str()) {
+                Some("wav") => "audio/wav",
+                Some("ogg") => "audio/ogg",
+                Some("m4a") => "audio/mp4",
+                _ => "audio/mpeg",
+            }
+            .to_string(),
+            FileType::Other => match path.extension().and_then(|ext| ext.to_str()) {
+                Some("txt") => "text/plain; charset=utf-8",
+                Some("csv") => "text/csv",
+                Some("md") => "text/markdown",
+                Some("xml") => "application/xml",
+                Some("map") => "application/json",
+                Some("pdf") => "application/pdf",
+                Some("wasm") => "application/wasm",
+                Some("zip") => "application/zip",
+                _ => "application/octet-stream",
+            }
+            .to_string(),
         }
     }
 }
 
+/// Handler that builds the response for paths with no matching file
+#[derive(Clone)]
+pub struct NotFoundHandler(Arc<dyn Fn(&HttpRequest) -> HttpResponse + Send + Sync>);
+
+impl NotFoundHandler {
+    /// Create a handler from a closure
+    pub fn new<F>(handler: F) -> Self
+    where
+        F: Fn(&HttpRequest) -> HttpResponse + Send + Sync + 'static,
+    {
+        Self(Arc::new(handler))
+    }
+
+    /// Build the response for a request
+    pub fn call(&self, req: &HttpRequest) -> HttpResponse {
+        (self.0)(req)
+    }
+}
+
+impl std::fmt::Debug for NotFoundHandler {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.debug_tuple("NotFoundHandler").finish()
+    }
+}
+
 /// Configuration for static asset serving
 #[derive(Debug, Clone)]
 pub struct StaticAssetsConfig {
@@ -352,6 +413,12 @@ pub struct StaticAssetsConfig {
     /// List of index files to try (e.g., ["index.html", "index.htm"])
     pub index_files: Vec<String>,
 
+    /// Render an HTML listing for directories without an index file
+    pub directory_listing: bool,
+
+    /// Custom response for missing files (used when there is no fallback)
+    pub not_found: Option<NotFoundHandler>,
+
     /// Compression configuration
     pub compression: CompressionConfig,
 }
@@ -396,6 +463,8 @@ impl StaticAssetsConfig {
             cors_origin: None,
             fallback: None,
             index_files: vec!["index.html".to_string()],
+            directory_listing: false,
+            not_found: None,
             compression: CompressionConfig::new(),
         }
     }
@@ -448,6 +517,21 @@ impl StaticAssetsConfig {
         self
     }
 
+    /// Enable/disable directory listings
+    pub fn with_directory_listing(mut self, enable: bool) -> Self {
+        self.directory_listing = enable;
+        self
+    }
+
+    /// Set a custom handler for missing files
+    pub fn with_not_found<F>(mut self, handler: F) -> Self
+    where
+        F: Fn(&HttpRequest) -> HttpResponse + Send + Sync + 'static,
+    {
+        self.not_found = Some(NotFoundHandler::new(handler));
+        self
+    }
+
     /// Set compression configuration
     pub fn with_compression(mut self, compression: CompressionConfig) -> Self {
         self.compression = compression;
@@ -525,7 +609,15 @@ impl StaticAssetServer {
 
     /// Serve a static file
     pub async fn serve(&self, req: &HttpRequest) -> Result<HttpResponse, Error> {
-        let path = self.resolve_path(&req.path)?;
+        self.serve_path(req, &req.path).await
+    }
+
+    /// Serve the file at `path`, relative to the root directory
+    ///
+    /// Use this when the server is mounted under a prefix that is still
+    /// part of the request path.
+    pub async fn serve_path(&self, req: &HttpRequest, path: &str) -> Result<HttpResponse, Error> {
+        let path = self.resolve_path(path)?;
 
         // Check if path exists
         if !path.exists() {
@@ -536,7 +628,10 @@ impl StaticAssetServer {
                     return self.serve_file(&fallback_path, req).await;
                 }
             }
-            return Err(Error::NotFound(format!("File not found: {}", req.path)));
+            return match self.config.not_found {
+                Some(ref handler) => Ok(handler.call(req)),
+                None => Err(Error::NotFound(format!("File not found: {}", req.path))),
+            };
         }
 
         // If directory, try index files
@@ -547,12 +642,65 @@ impl StaticAssetServer {
                     return self.serve_file(&index_path, req).await;
                 }
             }
+            if self.config.directory_listing {
+                return self.list_directory(&path, req).await;
+            }
             return Err(Error::Forbidden("Directory listing disabled".to_string()));
         }
 
         self.serve_file(&path, req).await
     }
 
+    /// Render an HTML index of a directory
+    async fn list_directory(&self, dir: &Path, req: &HttpRequest) -> Result<HttpResponse, Error> {
+        let mut entries = tokio::fs::read_dir(dir)
+            .await
+            .map_err(|e| Error::Internal(format!("Failed to read directory: {}", e)))?;
+
+        let mut names = Vec::new();
+        while let Some(entry) = entries
+            .next_entry()
+            .await
+            .map_err(|e| Error::Internal(format!("Failed to read directory: {}", e)))?
+        {
+            let is_dir = entry.file_type().await.is_ok_and(|t| t.is_dir());
+            names.push((entry.file_name().to_string_lossy().into_owned(), is_dir));
+        }
+        names.sort();
+
+        // Links are absolute so they work with or without a trailing slash
+        let base = req.path.split('?').next().unwrap_or("/");
+        let base = format!("{}/", base.trim_end_matches('/'));
+        let title = html_escape(&base);
+
+        let mut html = format!(
+            "<!DOCTYPE html>\n<html>\n<head><meta charset=\"utf-8\"><title>Index of {title}</title></head>\n<body>\n<h1>Index of {title}</h1>\n<ul>\n"
+        );
+        for (name, is_dir) in names {
+            let slash = if is_dir { "/" } else { "" };
+            html.push_str(&format!(
+                "<li><a href=\"{}{}{}\">{}{}</a></li>\n",
+                title,
+                urlencoding::encode(&name),
+                slash,
+                html_escape(&name),
+                slash
+            ));
+        }
+        html.push_str("</ul>\n</body>\n</html>\n");
+
+        Ok(HttpResponse::ok()
+            .with_header(
+                "Content-Type".to_string(),
+                "text/html; charset=utf-8".to_string(),
+            )
+            .with_header(
+                "Cache-Control".to_string(),
+                CacheStrategy::NoCache.to_header_value(),
+            )
+            .with_body(html.into_bytes()))
+    }
+
     /// Serve a specific file
     async fn serve_file(&self, path: &Path, req: &HttpRequest) -> Result<HttpResponse, Error> {
         // Get file metadata
@@ -560,12 +708,19 @@ impl StaticAssetServer {
             .await
             .map_err(|e| Error::Internal(format!("Failed to read file metadata: {}", e)))?;
 
-        let modified = metadata.modified().ok();
-        let file_size = metadata.len() as usize;
+        // HTTP dates have one-second resolution
+        let modified = metadata.modified().ok().map(truncate_to_secs);
+        let file_size = metadata.len();
         let file_type = FileType::from_path(path);
 
-        // Determine compression
-        let compression = self.select_compression(req, file_type, file_size);
+        // Byte ranges address the unencoded file, so ranged requests are
+        // never compressed
+        let range_header = req.header("Range");
+        let compression = if range_header.is_some() {
+            None
+        } else {
+            self.select_compression(req, file_type, file_size as usize)
+        };
 
         // Generate ETag (include compression in ETag)
         let etag = if self.config.enable_etag {
@@ -573,57 +728,71 @@ impl StaticAssetServer {
         } else {
             None
         };
+        let last_modified = modified.filter(|_| self.config.enable_last_modified);
 
         // Check conditional headers
-        if let Some(ref etag_value) = etag
-            && let Some(if_none_match) = req.headers.get("If-None-Match")
-            && if_none_match == etag_value
-        {
-            return Ok(self.not_modified_response(etag_value));
-        }
-
-        if self.config.enable_last_modified
-            && let Some(modified_time) = modified
-            && let Some(if_modified_since) = req.headers.get("If-Modified-Since")
-            && let Ok(since_time) = httpdate::parse_http_date(if_modified_since)
-            && modified_time <= since_time
-        {
+        if is_not_modified(req, etag.as_deref(), last_modified) {
             return Ok(self.not_modified_response(etag.as_deref().unwrap_or("")));
         }
 
-        // Try to serve pre-compressed file first
-        let (content, used_compression) = if let Some(algo) = compression {
-            if self.config.compression.serve_precompressed {
-                if let Some(content) = self.try_serve_precompressed(path, algo).await? {
-                    (content, Some(algo))
-                } else {
-                    // Compress on-the-fly
-                    let raw_content = tokio::fs::read(path)
-                        .await
-                        .map_err(|e| Error::Internal(format!("Failed to read file: {}", e)))?;
+        let range = range_header
+            .filter(|_| if_range_matches(req, etag.as_deref(), last_modified))
+            .and_then(|header| parse_range(header, file_size));
+        let range = match range {
+            Some(ByteRange::Satisfiable { start, end }) => Some((start, end)),
+            Some(ByteRange::Unsatisfiable) => {
+                return Ok(HttpResponse::new(416)
+                    .with_header(
+                        "Content-Range".to_string(),
+                        format!("bytes */{}", file_size),
+                    )
+                    .with_header("Accept-Ranges".to_string(), "bytes".to_string()));
+            }
+            None => None,
+        };
 
-                    let compressed = self.compress_content(&raw_content, algo)?;
-                    (compressed, Some(algo))
-                }
+        // Stream the requested range, or try to serve pre-compressed file
+        // first. Only on-the-fly compression, which is limited to files below
+        // the compression max size, reads the file into memory.
+        let (mut response, used_compression) = if let Some((start, end)) = range {
+            (
+                stream_file(open_at(path, start).await?, end - start + 1),
+                None,
+            )
+        } else if let Some(algo) = compression {
+            let precompressed = if self.config.compression.serve_precompressed {
+                self.try_serve_precompressed(path, algo).await?
             } else {
-                // Compress on-the-fly only
+                None
+            };
+            if let Some((file, len)) = precompressed {
+                (stream_file(file, len), Some(algo))
+            } else {
+                // Compress on-the-fly
                 let raw_content = tokio::fs::read(path)
                     .await
                     .map_err(|e| Error::Internal(format!("Failed to read file: {}", e)))?;
 
                 let compressed = self.compress_content(&raw_content, algo)?;
-                (compressed, Some(algo))
+                (HttpResponse::ok().with_body(compressed), Some(algo))
             }
         } else {
             // No compression
-            let content = tokio::fs::read(path)
-                .await
-                .map_err(|e| Error::Internal(format!("Failed to read file: {}", e)))?;
-            (content, None)
+            (stream_file(open_at(path, 0).await?, file_size), None)
         };
 
-        // Build response
-        let mut response = HttpResponse::ok().with_body(content);
+        if let Some((start, end)) = range {
+            response.status = 206;
+            response.headers.insert(
+                "Content-Range".to_string(),
+                format!("bytes {}-{}/{}", start, end, file_size),
+            );
+        }
+        if used_compression.is_none() {
+            response
+                .headers
+                .insert("Accept-Ranges".to_string(), "bytes".to_string());
+        }
 
         // Content-Type
         let content_type = file_type.mime_type(path);
@@ -727,12 +896,12 @@ impl StaticAssetServer {
         }
     }
 
-    /// Try to serve a pre-compressed file
+    /// Try to open a pre-compressed file, returning it with its length
     async fn try_serve_precompressed(
         &self,
         path: &Path,
         algo: CompressionAlgorithm,
-    ) -> Result<Option<Vec<u8>>, Error> {
+    ) -> Result<Option<(tokio::fs::File, u64)>, Error> {
         let compressed_path = path.with_extension(format!(
             "{}{}",
             path.extension().and_then(|e| e.to_str()).unwrap_or(""),
@@ -740,10 +909,14 @@ impl StaticAssetServer {
         ));
 
         if compressed_path.exists() {
-            let content = tokio::fs::read(&compressed_path).await.map_err(|e| {
+            let read_error = |e: std::io::Error| {
                 Error::Internal(format!("Failed to read pre-compressed file: {}", e))
-            })?;
-            Ok(Some(content))
+            };
+            let file = tokio::fs::File::open(&compressed_path)
+                .await
+                .map_err(read_error)?;
+            let len = file.metadata().await.map_err(read_error)?.len();
+            Ok(Some((file, len)))
         } else {
             Ok(None)
         }
@@ -785,15 +958,33 @@ impl StaticAssetServer {
 
     /// Resolve request path to file system path
     fn resolve_path(&self, request_path: &str) -> Result<PathBuf, Error> {
-        // Remove leading slash and query string
-        let clean_path = request_path
-            .trim_start_matches('/')
-            .split('?')
-            .next()
-            .unwrap_or("");
+        // Remove query string and decode percent-escapes, so that encoded
+        // separators and dot segments are checked like literal ones
+        let raw_path = request_path.split('?').next().unwrap_or("");
+        let decoded = urlencoding::decode(raw_path)
+            .map_err(|_| Error::BadRequest("Invalid path encoding".to_string()))?;
+
+        // Security: reject traversal before touching the file system, since
+        // paths that don't exist can't be canonicalized below
+        let mut relative = PathBuf::new();
+        for segment in decoded.split('/') {
+            if segment.is_empty() || segment == "." {
+                continue;
+            }
+            let mut components = Path::new(segment).components();
+            let is_normal = matches!(components.next(), Some(Component::Normal(_)))
+                && components.next().is_none()
+                && !segment.contains(['\\', '\0']);
+            if !is_normal {
+                return Err(Error::Forbidden(
+                    "Access denied: path traversal attempt".to_string(),
+                ));
+            }
+            relative.push(segment);
+        }
 
         // Build full path
-        let full_path = self.config.root_dir.join(clean_path);
+        let full_path = self.config.root_dir.join(relative);
 
         // Security: prevent directory traversal
         let canonical_root =
@@ -873,6 +1064,152 @@ impl StaticAssetServer {
     }
 }
 
+/// Drop the sub-second part of a timestamp
+pub(crate) fn truncate_to_secs(time: SystemTime) -> SystemTime {
+    match time.duration_since(SystemTime::UNIX_EPOCH) {
+        Ok(elapsed) => SystemTime::UNIX_EPOCH + Duration::from_secs(elapsed.as_secs()),
+        Err(_) => time,
+    }
+}
+
+/// A parsed `Range` header
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub(crate) enum ByteRange {
+    /// Inclusive byte offsets within the file
+    Satisfiable { start: u64, end: u64 },
+    /// The range starts beyond the end of the file
+    Unsatisfiable,
+}
+
+/// Parse a `Range` header against a file of `size` bytes
+///
+/// Returns `None` when the header should be ignored and the full file
+/// served: other units, malformed values, and multiple ranges.
+pub(crate) fn parse_range(header: &str, size: u64) -> Option<ByteRange> {
+    let spec = header.trim().strip_prefix("bytes=")?;
+    if spec.contains(',') {
+        return None;
+    }
+    let (first, last) = spec.split_once('-')?;
+    let (first, last) = (first.trim(), last.trim());
+
+    if first.is_empty() {
+        // Suffix range: the final `last` bytes
+        let suffix: u64 = last.parse().ok()?;
+        if suffix == 0 || size == 0 {
+            return Some(ByteRange::Unsatisfiable);
+        }
+        return Some(ByteRange::Satisfiable {
+            start: size.saturating_sub(suffix),
+            end: size - 1,
+        });
+    }
+
+    let start: u64 = first.parse().ok()?;
+    let end = if last.is_empty() {
+        None
+    } else {
+        let end: u64 = last.parse().ok()?;
+        if end < start {
+            return None;
+        }
+        Some(end)
+    };
+    if start >= size {
+        return Some(ByteRange::Unsatisfiable);
+    }
+    Some(ByteRange::Satisfiable {
+        start,
+        end: end.map_or(size - 1, |end| end.min(size - 1)),
+    })
+}
+
+/// Strip the weak indicator from an entity tag
+fn opaque_tag(tag: &str) -> &str {
+    let tag = tag.trim();
+    tag.strip_prefix("W/").unwrap_or(tag)
+}
+
+/// Evaluate `If-None-Match` and `If-Modified-Since` for a GET request
+///
+/// `If-Modified-Since` is only considered when `If-None-Match` is absent.
+fn is_not_modified(
+    req: &HttpRequest,
+    etag: Option<&str>,
+    last_modified: Option<SystemTime>,
+) -> bool {
+    if let Some(if_none_match) = req.header("If-None-Match") {
+        let Some(etag) = etag else {
+            return false;
+        };
+        return if_none_match.trim() == "*"
+            || if_none_match
+                .split(',')
+                .any(|tag| opaque_tag(tag) == opaque_tag(etag));
+    }
+
+    match (req.header("If-Modified-Since"), last_modified) {
+        (Some(since), Some(last_modified)) => {
+            httpdate::parse_http_date(since).is_ok_and(|since| last_modified <= since)
+        }
+        _ => false,
+    }
+}
+
+/// Check whether an `If-Range` precondition allows a partial response
+///
+/// Entity tags must match strongly; dates must equal the modification time.
+pub(crate) fn if_range_matches(
+    req: &HttpRequest,
+    etag: Option<&str>,
+    last_modified: Option<SystemTime>,
+) -> bool {
+    let Some(value) = req.header("If-Range") else {
+        return true;
+    };
+    let value = value.trim();
+    if value.starts_with('"') || value.starts_with("W/") {
+        return !value.starts_with("W/") && etag.is_some_and(|etag| etag == value);
+    }
+    match (httpdate::parse_http_date(value), last_modified) {
+        (Ok(date), Some(last_modified)) => date == last_modified,
+        _ => false,
+    }
+}
+
+/// Simple HTML escaping for directory listings
+fn html_escape(s: &str) -> String {
+    s.replace('&', "&amp;")
+        .replace('<', "&lt;")
+        .replace('>', "&gt;")
+        .replace('"', "&quot;")
+        .replace('\'', "&#x27;")
+}
+
+/// Open a file positioned at `start`
+async fn open_at(path: &Path, start: u64) -> Result<tokio::fs::File, Error> {
+    use tokio::io::AsyncSeekExt;
+
+    let mut file = tokio::fs::File::open(path)
+        .await
+        .map_err(|e| Error::Internal(format!("Failed to read file: {}", e)))?;
+    if start > 0 {
+        file.seek(SeekFrom::Start(start))
+            .await
+            .map_err(|e| Error::Internal(format!("Failed to read file: {}", e)))?;
+    }
+    Ok(file)
+}
+
+/// A `200 OK` response streaming the next `len` bytes of `file`
+fn stream_file(file: tokio::fs::File, len: u64) -> HttpResponse {
+    use tokio::io::AsyncReadExt;
+
+    HttpResponse::ok()
+        .with_header("Content-Length".to_string(), len.to_string())
+        .send_stream(file.take(len))
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -1004,4 +1341,258 @@ mod tests {
         assert_eq!(config.compression.level, CompressionLevel::Best);
         assert!(config.compression.prefer_brotli);
     }
+
+    /// Create a fresh directory with a few files to serve
+    fn fixture(name: &str) -> PathBuf {
+        let root =
+            std::env::temp_dir().join(format!("armature-static-{}-{}", std::process::id(), name));
+        let _ = std::fs::remove_dir_all(&root);
+        std::fs::create_dir_all(root.join("docs")).unwrap();
+        std::fs::write(root.join("data.bin"), b"0123456789").unwrap();
+        std::fs::write(root.join("index.html"), b"<h1>app</h1>").unwrap();
+        std::fs::write(root.join("docs/a&b.txt"), b"notes").unwrap();
+        root
+    }
+
+    fn get(path: &str) -> HttpRequest {
+        HttpRequest::new("GET".to_string(), path.to_string())
+    }
+
+    fn with_header(mut req: HttpRequest, name: &str, value: &str) -> HttpRequest {
+        req.headers.insert(name.to_string(), value.to_string());
+        req
+    }
+
+    async fn body(response: HttpResponse) -> Vec<u8> {
+        use http_body_util::BodyExt;
+
+        let body = response.into_hyper_response().into_body();
+        body.collect().await.unwrap().to_bytes().to_vec()
+    }
+
+    #[test]
+    fn test_parse_range() {
+        assert_eq!(
+            parse_range("bytes=2-5", 10),
+            Some(ByteRange::Satisfiable { start: 2, end: 5 })
+        );
+        assert_eq!(
+            parse_range("bytes=7-", 10),
+            Some(ByteRange::Satisfiable { start: 7, end: 9 })
+        );
+        assert_eq!(
+            parse_range("bytes=-3", 10),
+            Some(ByteRange::Satisfiable { start: 7, end: 9 })
+        );
+        assert_eq!(
+            parse_range("bytes=5-100", 10),
+            Some(ByteRange::Satisfiable { start: 5, end: 9 })
+        );
+        assert_eq!(parse_range("bytes=10-", 10), Some(ByteRange::Unsatisfiable));
+        assert_eq!(parse_range("bytes=-0", 10), Some(ByteRange::Unsatisfiable));
+
+        // Ignored headers
+        assert_eq!(parse_range("bytes=5-2", 10), None);
+        assert_eq!(parse_range("bytes=0-1,4-5", 10), None);
+        assert_eq!(parse_range("items=0-1", 10), None);
+        assert_eq!(parse_range("bytes=abc", 10), None);
+    }
+
+    #[tokio::test]
+    async fn test_serve_range_offsets() {
+        let root = fixture("range");
+        let server = StaticAssetServer::new(StaticAssetsConfig::new(&root)).unwrap();
+
+        let response = server
+            .serve(&with_header(get("/data.bin"), "Range", "bytes=2-5"))
+            .await
+            .unwrap();
+        assert_eq!(response.status, 206);
+        assert!(response.is_streaming());
+        assert_eq!(
+            response.headers.get("Content-Range"),
+            Some(&"bytes 2-5/10".to_string())
+        );
+        assert_eq!(
+            response.headers.get("Content-Length"),
+            Some(&"4".to_string())
+        );
+        assert_eq!(body(response).await, b"2345");
+
+        let response = server
+            .serve(&with_header(get("/data.bin"), "Range", "bytes=-3"))
+            .await
+            .unwrap();
+        assert_eq!(response.status, 206);
+        assert_eq!(body(response).await, b"789");
+
+        let response = server
+            .serve(&with_header(get("/data.bin"), "Range", "bytes=20-"))
+            .await
+            .unwrap();
+        assert_eq!(response.status, 416);
+        assert_eq!(
+            response.headers.get("Content-Range"),
+            Some(&"bytes */10".to_string())
+        );
+
+        // Full responses advertise range support
+        let response = server.serve(&get("/data.bin")).await.unwrap();
+        assert_eq!(response.status, 200);
+        assert_eq!(
+            response.headers.get("Accept-Ranges"),
+            Some(&"bytes".to_string())
+        );
+
+        // A stale If-Range falls back to the full file
+        let req = with_header(get("/data.bin"), "Range", "bytes=2-5");
+        let response = server
+            .serve(&with_header(req, "If-Range", "\"stale\""))
+            .await
+            .unwrap();
+        assert_eq!(response.status, 200);
+        assert_eq!(body(response).await, b"0123456789");
+
+        let _ = std::fs::remove_dir_all(root);
+    }
+
+    #[tokio::test]
+    async fn test_serve_if_modified_since() {
+        let root = fixture("modified");
+        let server = StaticAssetServer::new(StaticAssetsConfig::new(&root)).unwrap();
+
+        let response = server.serve(&get("/data.bin")).await.unwrap();
+        let last_modified = response.headers.get("Last-Modified").unwrap().clone();
+
+        // The same second counts as not modified
+        let response = server
+            .serve(&with_header(
+                get("/data.bin"),
+                "If-Modified-Since",
+                &last_modified,
+            ))
+            .await
+            .unwrap();
+        assert_eq!(response.status, 304);
+        assert!(response.body.is_empty());
+
+        let response = server
+            .serve(&with_header(
+                get("/data.bin"),
+                "If-Modified-Since",
+                "Thu, 01 Jan 1970 00:00:00 GMT",
+            ))
+            .await
+            .unwrap();
+        assert_eq!(response.status, 200);
+
+        // If-None-Match takes precedence over If-Modified-Since
+        let req = with_header(get("/data.bin"), "If-Modified-Since", &last_modified);
+        let response = server
+            .serve(&with_header(req, "If-None-Match", "\"other\""))
+            .await
+            .unwrap();
+        assert_eq!(response.status, 200);
+
+        let _ = std::fs::remove_dir_all(root);
+    }
+
+    #[tokio::test]
+    async fn test_serve_blocks_path_traversal() {
+        let root = fixture("traversal");
+        let server = StaticAssetServer::new(
+            StaticAssetsConfig::new(root.join("docs")).with_fallback("a&b.txt"),
+        )
+        .unwrap();
+
+        for path in [
+            "/../data.bin",
+            "/%2e%2e/data.bin",
+            "/..%2fdata.bin",
+            "/sub/../../index.html",
+            "/..%5cdata.bin",
+            "/../missing.txt",
+        ] {
+            let result = server.serve(&get(path)).await;
+            assert!(
+                matches!(result, Err(Error::Forbidden(_))),
+                "{path} was not rejected"
+            );
+        }
+
+        let _ = std::fs::remove_dir_all(root);
+    }
+
+    #[tokio::test]
+    async fn test_serve_directory_listing_and_not_found() {
+        let root = fixture("listing");
+        let server = StaticAssetServer::new(StaticAssetsConfig::new(&root)).unwrap();
+        assert!(matches!(
+            server.serve(&get("/docs")).await,
+            Err(Error::Forbidden(_))
+        ));
+        assert!(matches!(
+            server.serve(&get("/missing.txt")).await,
+            Err(Error::NotFound(_))
+        ));
+
+        let server = StaticAssetServer::new(
+            StaticAssetsConfig::new(&root)
+                .with_directory_listing(true)
+                .with_not_found(|req| {
+                    HttpResponse::new(404).with_body(format!("no {}", req.path).into_bytes())
+                }),
+        )
+        .unwrap();
+
+        let response = server.serve(&get("/docs")).await.unwrap();
+        let html = String::from_utf8(response.body).unwrap();
+        assert!(html.contains("<a href=\"/docs/a%26b.txt\">a&amp;b.txt</a>"));
+
+        let response = server.serve(&get("/missing.txt")).await.unwrap();
+        assert_eq!(response.status, 404);
+        assert_eq!(response.body, b"no /missing.txt");
+
+        let _ = std::fs::remove_dir_all(root);
+    }
+
+    #[tokio::test]
+    async fn test_router_static_files_mount() {
+        let root = fixture("mount");
+        let mut router = crate::Router::new();
+        router
+            .get("/assets/special", |_req: HttpRequest| async {
+                Ok(HttpResponse::ok().with_body(b"route".to_vec()))
+            })
+            .static_files("/assets/", StaticAssetsConfig::new(&root).spa_mode())
+            .unwrap();
+
+        let response = router.route(get("/assets/data.bin")).await.unwrap();
+        assert_eq!(
+            response.headers.get("Content-Type"),
+            Some(&"application/octet-stream".to_string())
+        );
+        assert_eq!(body(response).await, b"0123456789");
+
+        // SPA routes fall back to the index file
+        let response = router.route(get("/assets/settings/profile")).await.unwrap();
+        assert_eq!(body(response).await, b"<h1>app</h1>");
+
+        // Registered routes win over the mount
+        let response = router.route(get("/assets/special")).await.unwrap();
+        assert_eq!(response.body, b"route");
+
+        // Only GET and HEAD are served, and only below the prefix
+        let post = HttpRequest::new("POST".to_string(), "/assets/data.bin".to_string());
+        assert!(router.route(post).await.is_err());
+        assert!(router.route(get("/assetsdata.bin")).await.is_err());
+
+        assert!(
+            crate::Router::new()
+                .static_files("/x", StaticAssetsConfig::new(root.join("missing")))
+                .is_err()
+        );
+
+        let _ = std::fs::remove_dir_all(root);
+    }
 }
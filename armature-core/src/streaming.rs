@@ -11,6 +11,7 @@
 //! - Text/line streaming
 //! - Binary data streaming
 //! - Progress callbacks
+//! - Writer callbacks via [`HttpResponse::stream`], flushed chunk by chunk
 //!
 //! # Examples
 //!
@@ -33,6 +34,22 @@
 //! }
 //! ```
 //!
+//! ## Writer Callback
+//!
+//! ```ignore
+//! use armature_core::HttpResponse;
+//!
+//! async fn export(_req: HttpRequest) -> Result<HttpResponse, Error> {
+//!     Ok(HttpResponse::ok().stream(|w| async move {
+//!         for row in load_rows().await? {
+//!             // Fails with BrokenPipe once the client has gone away
+//!             w.write(row.to_csv()).await?;
+//!         }
+//!         Ok(())
+//!     }))
+//! }
+//! ```
+//!
 //! ## JSON Streaming (NDJSON)
 //!
 //! ```ignore
@@ -51,9 +68,12 @@
 //! }
 //! ```
 
+use crate::logging::{debug, error, trace};
 use crate::{Error, HttpResponse};
 use bytes::Bytes;
 use futures_util::Stream;
+use http_body_util::{Either, Full};
+use hyper::body::Frame;
 use serde::Serialize;
 use std::collections::HashMap;
 use std::pin::Pin;
@@ -128,6 +148,12 @@ pub struct ByteStream {
     receiver: mpsc::Receiver<StreamChunk>,
 }
 
+impl std::fmt::Debug for ByteStream {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.debug_struct("ByteStream").finish_non_exhaustive()
+    }
+}
+
 /// Sender half of a byte stream.
 pub struct ByteStreamSender {
     sender: mpsc::Sender<StreamChunk>,
@@ -800,6 +826,155 @@ impl StreamingResponse {
         response.body = body;
         Ok(response)
     }
+
+    /// Convert into an `HttpResponse` that keeps streaming.
+    ///
+    /// Unlike [`StreamingResponse::into_buffered`], the body is forwarded to
+    /// the client chunk by chunk as it is produced.
+    pub fn into_response(self) -> HttpResponse {
+        let stream = match self.body {
+            StreamBody::Bytes(stream) => Some(stream),
+            StreamBody::Json(stream) => Some(stream.into_inner()),
+            StreamBody::Text(stream) => Some(stream.into_inner()),
+            StreamBody::Empty => None,
+        };
+
+        let mut response = HttpResponse::new(self.status);
+        response.headers = self.headers.into();
+        match stream {
+            Some(stream) => response.with_stream(stream),
+            None => response,
+        }
+    }
+}
+
+// ============================================================================
+// Stream Writer
+// ============================================================================
+
+/// Writer handed to a [`HttpResponse::stream`] callback.
+///
+/// Every write is forwarded to the client as its own chunk. When the client
+/// disconnects, writes fail with an [`Error::Io`] of kind
+/// [`BrokenPipe`](std::io::ErrorKind::BrokenPipe) and [`StreamWriter::closed`]
+/// resolves, so long-running producers can stop early.
+pub struct StreamWriter {
+    sender: ByteStreamSender,
+}
+
+impl StreamWriter {
+    pub(crate) fn new(sender: ByteStreamSender) -> Self {
+        Self { sender }
+    }
+
+    /// Write a chunk to the client.
+    pub async fn write(&self, data: impl Into<Bytes>) -> Result<(), Error> {
+        let bytes = data.into();
+        if bytes.is_empty() {
+            // An empty chunk would terminate chunked encoding early
+            return Ok(());
+        }
+        self.sender
+            .send_bytes(bytes)
+            .await
+            .map_err(|_| disconnected())
+    }
+
+    /// Write a string chunk to the client.
+    pub async fn write_str(&self, s: &str) -> Result<(), Error> {
+        self.write(Bytes::copy_from_slice(s.as_bytes())).await
+    }
+
+    /// Check whether the client has gone away.
+    pub fn is_closed(&self) -> bool {
+        self.sender.is_closed()
+    }
+
+    /// Wait until the client disconnects or the response is dropped.
+    ///
+    /// Useful with `tokio::select!` to abort a producer that is waiting on
+    /// something other than a write.
+    pub async fn closed(&self) {
+        self.sender.sender.closed().await
+    }
+
+    /// Total bytes written so far.
+    pub fn bytes_written(&self) -> u64 {
+        self.sender.bytes_sent()
+    }
+}
+
+fn disconnected() -> Error {
+    Error::Io(std::io::Error::new(
+        std::io::ErrorKind::BrokenPipe,
+        "client disconnected",
+    ))
+}
+
+/// Run a stream callback in the background, feeding a new byte stream.
+///
+/// An error returned by the callback is logged and the stream is ended
+/// normally, so the chunked body is still terminated correctly and the
+/// connection stays usable.
+pub(crate) fn spawn_writer<F, Fut>(f: F) -> ByteStream
+where
+    F: FnOnce(StreamWriter) -> Fut + Send + 'static,
+    Fut: std::future::Future<Output = Result<(), Error>> + Send + 'static,
+{
+    let (stream, sender) = ByteStream::new();
+    let bytes_sent = sender.bytes_sent.clone();
+
+    tokio::spawn(async move {
+        let result = f(StreamWriter::new(sender)).await;
+        let bytes = bytes_sent.load(Ordering::Relaxed);
+        match result {
+            Ok(()) => trace!(bytes = bytes, "Response stream completed"),
+            Err(Error::Io(err)) if err.kind() == std::io::ErrorKind::BrokenPipe => {
+                debug!(bytes = bytes, "Client disconnected during response stream")
+            }
+            Err(err) => error!(
+                bytes = bytes,
+                error = %err,
+                "Response stream callback failed; ending stream early"
+            ),
+        }
+    });
+
+    stream
+}
+
+// ============================================================================
+// Hyper Integration
+// ============================================================================
+
+/// Adapter turning a [`ByteStream`] into a stream of hyper body frames.
+pub struct ByteFrames {
+    inner: ByteStream,
+}
+
+impl Stream for ByteFrames {
+    type Item = Result<Frame<Bytes>, Error>;
+
+    fn poll_next(mut self: Pin<&mut Self>, cx: &mut Context<'_>) -> Poll<Option<Self::Item>> {
+        Pin::new(&mut self.inner)
+            .poll_next(cx)
+            .map(|chunk| chunk.map(|chunk| chunk.map(Frame::data)))
+    }
+}
+
+/// Body type sent to hyper: either a buffered body or a chunked stream.
+pub type HyperBody = Either<Full<Bytes>, http_body_util::StreamBody<ByteFrames>>;
+
+/// Build the hyper body for a buffered payload.
+pub(crate) fn full_body(bytes: Bytes) -> HyperBody {
+    Either::Left(Full::new(bytes))
+}
+
+/// Build the hyper body for a byte stream.
+pub(crate) fn stream_body(stream: ByteStream) -> HyperBody {
+    Either::Right(http_body_util::StreamBody::new(ByteFrames {
+        inner: stream,
+    }))
 }
 
 // ============================================================================
@@ -1173,6 +1348,162 @@ mod tests {
         let _ = stats.average_chunk_size();
         let _ = stats.average_rtt();
     }
+
+    // ------------------------------------------------------------------------
+    // Writer-based response streaming
+    // ------------------------------------------------------------------------
+
+    async fn next_data(body: &mut HyperBody) -> Option<Bytes> {
+        use http_body_util::BodyExt;
+
+        loop {
+            let frame = body.frame().await?.expect("body error");
+            if let Ok(data) = frame.into_data() {
+                return Some(data);
+            }
+        }
+    }
+
+    async fn read_chunked_response(io: &mut tokio::io::DuplexStream) -> String {
+        use tokio::io::AsyncReadExt;
+
+        let mut buf = Vec::new();
+        let mut chunk = [0u8; 1024];
+        while !buf.ends_with(b"0\r\n\r\n") {
+            let n = io.read(&mut chunk).await.unwrap();
+            assert!(n > 0, "connection closed before the chunked body ended");
+            buf.extend_from_slice(&chunk[..n]);
+        }
+        String::from_utf8(buf).unwrap()
+    }
+
+    #[tokio::test]
+    async fn test_stream_flushes_each_write() {
+        let (release, wait) = tokio::sync::oneshot::channel::<()>();
+        let response = HttpResponse::ok().stream(move |w| async move {
+            w.write("first").await?;
+            wait.await.ok();
+            w.write("second").await
+        });
+        assert!(response.is_streaming());
+
+        let mut body = response.into_hyper_response().into_body();
+        // The first chunk is available while the callback is still blocked
+        assert_eq!(next_data(&mut body).await.unwrap(), "first");
+        release.send(()).unwrap();
+        assert_eq!(next_data(&mut body).await.unwrap(), "second");
+        assert!(next_data(&mut body).await.is_none());
+    }
+
+    #[tokio::test]
+    async fn test_stream_client_disconnect_cancels_writer() {
+        let (report, outcome) = tokio::sync::oneshot::channel();
+        let response = HttpResponse::ok().stream(move |w| async move {
+            w.write("tick").await?;
+            w.closed().await;
+            let result = w.write("after disconnect").await;
+            let broken_pipe = matches!(
+                &result,
+                Err(Error::Io(e)) if e.kind() == std::io::ErrorKind::BrokenPipe
+            );
+            report.send(broken_pipe && w.is_closed()).ok();
+            result
+        });
+
+        let mut body = response.into_hyper_response().into_body();
+        assert_eq!(next_data(&mut body).await.unwrap(), "tick");
+        drop(body);
+
+        assert!(outcome.await.unwrap());
+    }
+
+    #[tokio::test]
+    async fn test_stream_chunked_over_http1_survives_callback_error() {
+        use tokio::io::AsyncWriteExt;
+
+        let (mut client, server) = tokio::io::duplex(64 * 1024);
+        tokio::spawn(async move {
+            let service = hyper::service::service_fn(
+                |req: hyper::Request<hyper::body::Incoming>| async move {
+                    let response = if req.uri().path() == "/fail" {
+                        HttpResponse::ok().stream(|w| async move {
+                            w.write("partial").await?;
+                            Err(Error::Internal("export source went away".into()))
+                        })
+                    } else {
+                        HttpResponse::ok().stream(|w| async move {
+                            w.write("hello").await?;
+                            w.write("world").await
+                        })
+                    };
+                    Ok::<_, std::convert::Infallible>(response.into_hyper_response())
+                },
+            );
+            hyper::server::conn::http1::Builder::new()
+                .serve_connection(hyper_util::rt::TokioIo::new(server), service)
+                .await
+        });
+
+        client
+            .write_all(b"GET /fail HTTP/1.1\r\nHost: test\r\n\r\n")
+            .await
+            .unwrap();
+        let failed = read_chunked_response(&mut client)
+            .await
+            .to_ascii_lowercase();
+        assert!(failed.starts_with("http/1.1 200"));
+        assert!(failed.contains("transfer-encoding: chunked"));
+        assert!(failed.contains("\r\n7\r\npartial\r\n0\r\n\r\n"));
+
+        // The connection is still usable for the next request
+        client
+            .write_all(b"GET /ok HTTP/1.1\r\nHost: test\r\n\r\n")
+            .await
+            .unwrap();
+        let ok = read_chunked_response(&mut client).await;
+        assert!(ok.contains("\r\n5\r\nhello\r\n5\r\nworld\r\n0\r\n\r\n"));
+    }
+
+    #[tokio::test]
+    async fn test_send_stream_from_reader() {
+        let response = HttpResponse::ok().send_stream(&b"streamed from a reader"[..]);
+        let mut body = response.into_hyper_response().into_body();
+
+        let mut collected = Vec::new();
+        while let Some(data) = next_data(&mut body).await {
+            collected.extend_from_slice(&data);
+        }
+        assert_eq!(collected, b"streamed from a reader");
+    }
+
+    #[tokio::test]
+    async fn test_streaming_response_into_response() {
+        let (stream, sender) = TextStream::new();
+        tokio::spawn(async move {
+            sender.send_line("line one").await.ok();
+            sender.close().await;
+        });
+
+        let response = StreamingResponse::text(stream).into_response();
+        assert_eq!(
+            response.headers.get("Content-Type").map(String::as_str),
+            Some("text/plain; charset=utf-8")
+        );
+
+        let mut body = response.into_hyper_response().into_body();
+        assert_eq!(next_data(&mut body).await.unwrap(), "line one\n");
+        assert!(next_data(&mut body).await.is_none());
+    }
+
+    #[test]
+    fn test_buffered_response_uses_full_body() {
+        let response = HttpResponse::ok().with_body(b"buffered".to_vec());
+        assert!(!response.is_streaming());
+        assert!(matches!(
+            response.into_hyper_response().into_body(),
+            Either::Left(_)
+        ));
+    }
 }
 
 // ============================================================================
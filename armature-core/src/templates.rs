@@ -0,0 +1,314 @@
+//! Handlebars templates loaded from a directory.
+//!
+//! Enabled with the `templates` feature. [`HandlebarsRenderer`] implements
+//! [`Renderer`] for Handlebars templates stored as `.hbs` files. Each
+//! template is named after its path relative to the directory, without the
+//! extension, so `templates/users/show.hbs` is `users/show`.
+//!
+//! Every template can also be used as a partial or a layout:
+//!
+//! ```text
+//! {{!-- templates/layouts/base.hbs --}}
+//! <html><body>{{> @partial-block}}</body></html>
+//!
+//! {{!-- templates/users/show.hbs --}}
+//! {{#> layouts/base}}
+//!   {{> partials/avatar}}
+//!   <h1>{{name}}</h1>
+//! {{/layouts/base}}
+//! ```
+//!
+//! In production the templates are parsed once. In
+//! [development mode](HandlebarsRenderer::dev_mode) the directory is read
+//! again on every render, on a blocking thread, so edits show up without a
+//! restart.
+
+use crate::Error;
+use crate::render::Renderer;
+use async_trait::async_trait;
+use handlebars::Handlebars;
+use parking_lot::RwLock;
+use std::path::{Path, PathBuf};
+use std::sync::Arc;
+
+/// Extension of template files
+const EXTENSION: &str = "hbs";
+
+/// Callback applied to every Handlebars registry the renderer builds
+type Configure = Arc<dyn Fn(&mut Handlebars<'static>) + Send + Sync>;
+
+/// [`Renderer`] for a directory of Handlebars templates.
+///
+/// Rendering uses strict mode, so referencing a missing field is an error
+/// rather than an empty string. Values are HTML-escaped unless written with
+/// triple braces.
+///
+/// # Examples
+///
+/// ```rust,ignore
+/// use armature_core::templates::HandlebarsRenderer;
+///
+/// let renderer = HandlebarsRenderer::new("templates")?
+///     .dev_mode(cfg!(debug_assertions));
+/// router.renderer(renderer);
+///
+/// router.get("/users/:id", |req: HttpRequest| async move {
+///     let user = load_user(req.param("id")).await?;
+///     req.render(200, "users/show", &user).await
+/// });
+/// ```
+pub struct HandlebarsRenderer {
+    dir: PathBuf,
+    dev_mode: bool,
+    configure: Option<Configure>,
+    registry: RwLock<Arc<Handlebars<'static>>>,
+}
+
+impl HandlebarsRenderer {
+    /// Load every `.hbs` file below `dir`.
+    ///
+    /// # Errors
+    ///
+    /// Returns [`Error::Internal`] if the directory can't be read or a
+    /// template doesn't parse.
+    pub fn new(dir: impl Into<PathBuf>) -> Result<Self, Error> {
+        let dir = dir.into();
+        let registry = load(&dir, None)?;
+        Ok(Self {
+            dir,
+            dev_mode: false,
+            configure: None,
+            registry: RwLock::new(Arc::new(registry)),
+        })
+    }
+
+    /// Read the templates again before every render.
+    ///
+    /// Off by default. Turn it on during development to pick up edited,
+    /// added and removed templates without restarting; leave it off in
+    /// production, where re-parsing on every request is wasted work.
+    pub fn dev_mode(mut self, enabled: bool) -> Self {
+        self.dev_mode = enabled;
+        self
+    }
+
+    /// Customize the Handlebars registry, e.g. to register helpers.
+    ///
+    /// `configure` also runs whenever templates are reloaded.
+    ///
+    /// # Errors
+    ///
+    /// Returns [`Error::Internal`] if the templates fail to load again.
+    pub fn configure<F>(mut self, configure: F) -> Result<Self, Error>
+    where
+        F: Fn(&mut Handlebars<'static>) + Send + Sync + 'static,
+    {
+        let configure: Configure = Arc::new(configure);
+        *self.registry.get_mut() = Arc::new(load(&self.dir, Some(&configure))?);
+        self.configure = Some(configure);
+        Ok(self)
+    }
+
+    /// Read the templates from the directory again.
+    ///
+    /// The files are read on a blocking thread. On error the previously
+    /// loaded templates stay in use.
+    pub async fn reload(&self) -> Result<(), Error> {
+        let dir = self.dir.clone();
+        let configure = self.configure.clone();
+        let registry = tokio::task::spawn_blocking(move || load(&dir, configure.as_ref()))
+            .await
+            .map_err(|err| Error::Internal(format!("template reload failed: {}", err)))??;
+        *self.registry.write() = Arc::new(registry);
+        Ok(())
+    }
+}
+
+#[async_trait]
+impl Renderer for HandlebarsRenderer {
+    async fn render(&self, name: &str, data: &serde_json::Value) -> Result<String, Error> {
+        if self.dev_mode {
+            self.reload().await?;
+        }
+        let registry = self.registry.read().clone();
+        if !registry.has_template(name) {
+            return Err(Error::Internal(format!("template {:?} not found", name)));
+        }
+        registry.render(name, data).map_err(|err| {
+            Error::Internal(format!("failed to render template {:?}: {}", name, err))
+        })
+    }
+}
+
+/// Build a registry with every template below `dir`
+fn load(dir: &Path, configure: Option<&Configure>) -> Result<Handlebars<'static>, Error> {
+    let mut registry = Handlebars::new();
+    registry.set_strict_mode(true);
+    if let Some(configure) = configure {
+        configure(&mut registry);
+    }
+
+    let mut pending = vec![dir.to_path_buf()];
+    while let Some(current) = pending.pop() {
+        let entries = std::fs::read_dir(&current).map_err(|err| {
+            Error::Internal(format!(
+                "can't read templates in {}: {}",
+                current.display(),
+                err
+            ))
+        })?;
+        for entry in entries {
+            let path = entry
+                .map_err(|err| Error::Internal(format!("can't read templates: {}", err)))?
+                .path();
+            if path.is_dir() {
+                pending.push(path);
+                continue;
+            }
+            if path.extension().and_then(|ext| ext.to_str()) != Some(EXTENSION) {
+                continue;
+            }
+
+            let name = template_name(dir, &path);
+            let source = std::fs::read_to_string(&path).map_err(|err| {
+                Error::Internal(format!("can't read template {}: {}", path.display(), err))
+            })?;
+            registry
+                .register_template_string(&name, source)
+                .map_err(|err| Error::Internal(format!("invalid template {:?}: {}", name, err)))?;
+        }
+    }
+    Ok(registry)
+}
+
+/// Template name for a file: its path below `dir` without the extension,
+/// separated by `/`
+fn template_name(dir: &Path, path: &Path) -> String {
+    let relative = path.strip_prefix(dir).unwrap_or(path).with_extension("");
+    relative
+        .components()
+        .map(|component| component.as_os_str().to_string_lossy())
+        .collect::<Vec<_>>()
+        .join("/")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    struct TempDir(PathBuf);
+
+    impl TempDir {
+        fn new() -> Self {
+            let dir =
+                std::env::temp_dir().join(format!("armature-templates-{}", uuid::Uuid::new_v4()));
+            std::fs::create_dir_all(dir.join("layouts")).unwrap();
+            Self(dir)
+        }
+
+        fn write(&self, name: &str, source: &str) {
+            std::fs::write(self.0.join(name), source).unwrap();
+        }
+    }
+
+    impl Drop for TempDir {
+        fn drop(&mut self) {
+            let _ = std::fs::remove_dir_all(&self.0);
+        }
+    }
+
+    #[tokio::test]
+    async fn test_layouts_and_partials() {
+        let dir = TempDir::new();
+        dir.write("layouts/base.hbs", "<main>{{> @partial-block}}</main>");
+        dir.write("name.hbs", "<b>{{name}}</b>");
+        dir.write(
+            "page.hbs",
+            "{{#> layouts/base}}Hi {{> name}}{{/layouts/base}}",
+        );
+        dir.write("notes.txt", "not a template");
+
+        let renderer = HandlebarsRenderer::new(&dir.0).unwrap();
+        let html = renderer
+            .render("page", &json!({ "name": "<Ada>" }))
+            .await
+            .unwrap();
+        assert_eq!(html, "<main>Hi <b>&lt;Ada&gt;</b></main>");
+    }
+
+    #[tokio::test]
+    async fn test_missing_template() {
+        let dir = TempDir::new();
+        dir.write("page.hbs", "{{title}}");
+        let renderer = HandlebarsRenderer::new(&dir.0).unwrap();
+
+        let err = renderer.render("missing", &json!({})).await.unwrap_err();
+        assert!(err.to_string().contains("\"missing\" not found"), "{}", err);
+        assert!(renderer.render("notes", &json!({})).await.is_err());
+
+        // Strict mode: missing data is an error too
+        assert!(renderer.render("page", &json!({})).await.is_err());
+    }
+
+    #[tokio::test]
+    async fn test_dev_mode_reloads_templates() {
+        let dir = TempDir::new();
+        dir.write("page.hbs", "v1");
+
+        let cached = HandlebarsRenderer::new(&dir.0).unwrap();
+        let dev = HandlebarsRenderer::new(&dir.0).unwrap().dev_mode(true);
+        assert_eq!(cached.render("page", &json!({})).await.unwrap(), "v1");
+        assert_eq!(dev.render("page", &json!({})).await.unwrap(), "v1");
+
+        dir.write("page.hbs", "v2");
+        dir.write("new.hbs", "added");
+        assert_eq!(cached.render("page", &json!({})).await.unwrap(), "v1");
+        assert!(cached.render("new", &json!({})).await.is_err());
+        assert_eq!(dev.render("page", &json!({})).await.unwrap(), "v2");
+        assert_eq!(dev.render("new", &json!({})).await.unwrap(), "added");
+
+        cached.reload().await.unwrap();
+        assert_eq!(cached.render("page", &json!({})).await.unwrap(), "v2");
+
+        // A broken edit keeps the last good templates around
+        dir.write("page.hbs", "{{#if}}");
+        assert!(cached.reload().await.is_err());
+        assert_eq!(cached.render("page", &json!({})).await.unwrap(), "v2");
+    }
+
+    #[tokio::test]
+    async fn test_configure_survives_reload() {
+        let dir = TempDir::new();
+        dir.write("page.hbs", "{{shout word}}");
+
+        let renderer = HandlebarsRenderer::new(&dir.0)
+            .unwrap()
+            .dev_mode(true)
+            .configure(|registry| {
+                registry.register_helper(
+                    "shout",
+                    Box::new(
+                        |h: &handlebars::Helper,
+                         _: &Handlebars,
+                         _: &handlebars::Context,
+                         _: &mut handlebars::RenderContext,
+                         out: &mut dyn handlebars::Output|
+                         -> handlebars::HelperResult {
+                            let word = h.param(0).and_then(|p| p.value().as_str()).unwrap_or("");
+                            out.write(&word.to_uppercase())?;
+                            Ok(())
+                        },
+                    ),
+                );
+            })
+            .unwrap();
+        assert_eq!(
+            renderer
+                .render("page", &json!({ "word": "hey" }))
+                .await
+                .unwrap(),
+            "HEY"
+        );
+    }
+}
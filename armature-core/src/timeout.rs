@@ -21,6 +21,30 @@
 //!     .route_timeout("/api/report", 120);  // 2 minutes for reports
 //! ```
 //!
+//! ## Reading the Deadline in Handlers
+//!
+//! The timeout middlewares store a [`RequestDeadline`] in the request so
+//! handlers can bound downstream calls and stop early:
+//!
+//! ```rust
+//! use armature_core::*;
+//!
+//! async fn handler(req: HttpRequest) -> Result<HttpResponse, Error> {
+//!     let Some(deadline) = req.deadline() else {
+//!         return Ok(HttpResponse::ok());
+//!     };
+//!
+//!     tokio::select! {
+//!         _ = deadline.expired() => Err(Error::GatewayTimeout("report query".into())),
+//!         _ = tokio::time::sleep(std::time::Duration::from_millis(10)) => Ok(HttpResponse::ok()),
+//!     }
+//! }
+//! ```
+//!
+//! When the deadline passes, the handler future is dropped and the timeout
+//! response is returned, so a slow handler can never write to the response
+//! after it has been sent.
+//!
 //! ## Using the Decorator
 //!
 //! ```ignore
@@ -38,6 +62,96 @@ use async_trait::async_trait;
 use std::collections::HashMap;
 use std::sync::Arc;
 use std::time::Duration;
+use tokio::time::Instant;
+
+/// Deadline for handling the current request.
+///
+/// Inserted by [`TimeoutMiddleware`] and [`ConfigurableTimeoutMiddleware`];
+/// read it with [`HttpRequest::deadline`]. Nested timeouts keep the earliest
+/// deadline.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct RequestDeadline {
+    at: Instant,
+}
+
+impl RequestDeadline {
+    /// Creates a deadline `duration` from now.
+    pub fn after(duration: Duration) -> Self {
+        Self {
+            at: Instant::now() + duration,
+        }
+    }
+
+    /// Returns the instant the deadline expires.
+    pub fn instant(&self) -> Instant {
+        self.at
+    }
+
+    /// Returns the time left before the deadline, or zero once it has passed.
+    pub fn remaining(&self) -> Duration {
+        self.at.saturating_duration_since(Instant::now())
+    }
+
+    /// Returns true once the deadline has passed.
+    pub fn is_expired(&self) -> bool {
+        Instant::now() >= self.at
+    }
+
+    /// Completes when the deadline passes.
+    pub async fn expired(&self) {
+        tokio::time::sleep_until(self.at).await;
+    }
+}
+
+impl HttpRequest {
+    /// Returns the deadline set by a timeout middleware, if any.
+    pub fn deadline(&self) -> Option<RequestDeadline> {
+        self.extensions.get::<RequestDeadline>().copied()
+    }
+}
+
+/// Response returned when a request times out.
+///
+/// Without a custom status or body the middleware fails with
+/// [`Error::GatewayTimeout`] (504) so the error is rendered like any other.
+#[derive(Debug, Clone, Default)]
+struct TimeoutResponse {
+    status: Option<u16>,
+    body: Option<Vec<u8>>,
+    content_type: Option<String>,
+}
+
+impl TimeoutResponse {
+    fn build(&self, message: String) -> Result<HttpResponse, Error> {
+        if self.status.is_none() && self.body.is_none() {
+            return Err(Error::GatewayTimeout(message));
+        }
+
+        let body = self.body.clone().unwrap_or_else(|| message.into_bytes());
+        let content_type = self
+            .content_type
+            .clone()
+            .unwrap_or_else(|| "text/plain; charset=utf-8".to_string());
+        Ok(HttpResponse::new(self.status.unwrap_or(504))
+            .with_header("Content-Type".to_string(), content_type)
+            .with_body(body))
+    }
+}
+
+/// Runs `next` with a deadline, returning `None` if it passes first.
+async fn run_with_deadline(
+    mut req: HttpRequest,
+    next: crate::middleware::Next,
+    timeout: Duration,
+) -> Option<Result<HttpResponse, Error>> {
+    let mut deadline = RequestDeadline::after(timeout);
+    if let Some(outer) = req.deadline() {
+        deadline.at = deadline.at.min(outer.at);
+    }
+    req.extensions.insert(deadline);
+
+    tokio::time::timeout_at(deadline.at, next(req)).await.ok()
+}
 
 /// Configuration for request timeouts.
 ///
@@ -61,6 +175,8 @@ pub struct TimeoutConfig {
     route_timeouts: HashMap<String, Duration>,
     /// Whether to include timeout info in error responses
     pub include_timeout_in_error: bool,
+    /// Response sent when a request times out
+    response: TimeoutResponse,
 }
 
 impl Default for TimeoutConfig {
@@ -69,6 +185,7 @@ impl Default for TimeoutConfig {
             default: Duration::from_secs(30),
             route_timeouts: HashMap::new(),
             include_timeout_in_error: true,
+            response: TimeoutResponse::default(),
         }
     }
 }
@@ -141,6 +258,24 @@ impl TimeoutConfig {
         self
     }
 
+    /// Sets the status code of the timeout response (default 504).
+    pub fn timeout_status(mut self, status: u16) -> Self {
+        self.response.status = Some(status);
+        self
+    }
+
+    /// Sets the body of the timeout response.
+    pub fn timeout_body(mut self, body: impl Into<Vec<u8>>) -> Self {
+        self.response.body = Some(body.into());
+        self
+    }
+
+    /// Sets the content type of a custom timeout response.
+    pub fn timeout_content_type(mut self, content_type: &str) -> Self {
+        self.response.content_type = Some(content_type.to_string());
+        self
+    }
+
     /// Gets the timeout for a specific path.
     ///
     /// Returns the route-specific timeout if configured, otherwise the default.
@@ -168,7 +303,9 @@ impl TimeoutConfig {
 
 /// A simple timeout middleware with a fixed duration.
 ///
-/// For more advanced use cases with per-route timeouts, use `ConfigurableTimeoutMiddleware`.
+/// Requests that do not complete in time fail with 504 Gateway Timeout; the
+/// status and body can be customized. For per-route timeouts, use
+/// `ConfigurableTimeoutMiddleware`.
 ///
 /// ## Example
 ///
@@ -180,30 +317,54 @@ impl TimeoutConfig {
 ///
 /// // Create middleware with millisecond precision
 /// let fast_middleware = TimeoutMiddleware::from_millis(500);
+///
+/// // Customize the timeout response
+/// let custom = TimeoutMiddleware::new(10)
+///     .with_status(503)
+///     .with_content_type("application/json")
+///     .with_body(r#"{"error":"try again later"}"#);
 /// ```
 #[derive(Debug, Clone)]
 pub struct TimeoutMiddleware {
     duration: Duration,
+    response: TimeoutResponse,
 }
 
 impl TimeoutMiddleware {
     /// Creates a new timeout middleware with the specified timeout in seconds.
     pub fn new(seconds: u64) -> Self {
-        Self {
-            duration: Duration::from_secs(seconds),
-        }
+        Self::from_duration(Duration::from_secs(seconds))
     }
 
     /// Creates a new timeout middleware with the specified timeout in milliseconds.
     pub fn from_millis(ms: u64) -> Self {
-        Self {
-            duration: Duration::from_millis(ms),
-        }
+        Self::from_duration(Duration::from_millis(ms))
     }
 
     /// Creates a new timeout middleware from a Duration.
     pub fn from_duration(duration: Duration) -> Self {
-        Self { duration }
+        Self {
+            duration,
+            response: TimeoutResponse::default(),
+        }
+    }
+
+    /// Sets the status code of the timeout response (default 504).
+    pub fn with_status(mut self, status: u16) -> Self {
+        self.response.status = Some(status);
+        self
+    }
+
+    /// Sets the body of the timeout response.
+    pub fn with_body(mut self, body: impl Into<Vec<u8>>) -> Self {
+        self.response.body = Some(body.into());
+        self
+    }
+
+    /// Sets the content type of a custom timeout response.
+    pub fn with_content_type(mut self, content_type: &str) -> Self {
+        self.response.content_type = Some(content_type.to_string());
+        self
     }
 
     /// Returns the configured timeout duration.
@@ -219,12 +380,11 @@ impl crate::middleware::Middleware for TimeoutMiddleware {
         req: HttpRequest,
         next: crate::middleware::Next,
     ) -> Result<HttpResponse, Error> {
-        match tokio::time::timeout(self.duration, next(req)).await {
-            Ok(result) => result,
-            Err(_) => Err(Error::RequestTimeout(format!(
-                "Request exceeded timeout of {:?}",
-                self.duration
-            ))),
+        match run_with_deadline(req, next, self.duration).await {
+            Some(result) => result,
+            None => self
+                .response
+                .build(format!("Request exceeded timeout of {:?}", self.duration)),
         }
     }
 }
@@ -270,15 +430,15 @@ impl crate::middleware::Middleware for ConfigurableTimeoutMiddleware {
     ) -> Result<HttpResponse, Error> {
         let timeout = self.config.get_timeout_for_path(&req.path);
 
-        match tokio::time::timeout(timeout, next(req)).await {
-            Ok(result) => result,
-            Err(_) => {
+        match run_with_deadline(req, next, timeout).await {
+            Some(result) => result,
+            None => {
                 let error_msg = if self.config.include_timeout_in_error {
                     format!("Request exceeded timeout of {:?}", timeout)
                 } else {
                     "Request timeout".to_string()
                 };
-                Err(Error::RequestTimeout(error_msg))
+                self.config.response.build(error_msg)
             }
         }
     }
@@ -338,6 +498,18 @@ impl TimeoutBuilder {
         self
     }
 
+    /// Sets the status code of the timeout response.
+    pub fn status(mut self, status: u16) -> Self {
+        self.config.response.status = Some(status);
+        self
+    }
+
+    /// Sets the body of the timeout response.
+    pub fn body(mut self, body: impl Into<Vec<u8>>) -> Self {
+        self.config.response.body = Some(body.into());
+        self
+    }
+
     /// Builds a ConfigurableTimeoutMiddleware.
     pub fn build(self) -> ConfigurableTimeoutMiddleware {
         ConfigurableTimeoutMiddleware::new(self.config)
@@ -345,7 +517,10 @@ impl TimeoutBuilder {
 
     /// Builds a simple TimeoutMiddleware (uses default timeout only).
     pub fn build_simple(self) -> TimeoutMiddleware {
-        TimeoutMiddleware::from_duration(self.config.default)
+        TimeoutMiddleware {
+            duration: self.config.default,
+            response: self.config.response,
+        }
     }
 }
 
@@ -452,4 +627,149 @@ mod tests {
 
         assert_eq!(middleware.config.default, Duration::from_secs(45));
     }
+
+    fn slow_next(
+        delay: Duration,
+        finished: Arc<std::sync::atomic::AtomicBool>,
+    ) -> crate::middleware::Next {
+        Box::new(move |_req| {
+            Box::pin(async move {
+                tokio::time::sleep(delay).await;
+                finished.store(true, std::sync::atomic::Ordering::SeqCst);
+                Ok(HttpResponse::ok())
+            })
+        })
+    }
+
+    #[tokio::test]
+    async fn test_timeout_middleware_handler_ignores_deadline() {
+        use crate::middleware::Middleware;
+        use std::sync::atomic::{AtomicBool, Ordering};
+
+        let finished = Arc::new(AtomicBool::new(false));
+        let middleware = TimeoutMiddleware::from_millis(20);
+        let req = HttpRequest::new("GET".to_string(), "/slow".to_string());
+
+        let start = std::time::Instant::now();
+        let result = middleware
+            .handle(req, slow_next(Duration::from_secs(5), finished.clone()))
+            .await;
+
+        assert!(start.elapsed() < Duration::from_secs(1));
+        let err = result.unwrap_err();
+        assert!(matches!(err, Error::GatewayTimeout(_)));
+        assert_eq!(err.status_code(), 504);
+
+        // The handler was cancelled and never completes its write
+        tokio::time::sleep(Duration::from_millis(50)).await;
+        assert!(!finished.load(Ordering::SeqCst));
+    }
+
+    #[tokio::test]
+    async fn test_timeout_middleware_handler_respects_deadline() {
+        use crate::middleware::Middleware;
+        use std::sync::atomic::{AtomicBool, Ordering};
+
+        let cancelled = Arc::new(AtomicBool::new(false));
+        let flag = cancelled.clone();
+        let next: crate::middleware::Next = Box::new(move |req: HttpRequest| {
+            Box::pin(async move {
+                let deadline = req.deadline().expect("deadline set by middleware");
+                assert!(deadline.remaining() <= Duration::from_millis(20));
+
+                tokio::select! {
+                    _ = tokio::time::sleep(Duration::from_secs(5)) => Ok(HttpResponse::ok()),
+                    _ = deadline.expired() => {
+                        flag.store(true, Ordering::SeqCst);
+                        Err(Error::GatewayTimeout("downstream call abandoned".to_string()))
+                    }
+                }
+            })
+        });
+
+        let middleware = TimeoutMiddleware::from_millis(20);
+        let req = HttpRequest::new("GET".to_string(), "/slow".to_string());
+        let err = middleware.handle(req, next).await.unwrap_err();
+
+        assert_eq!(err.status_code(), 504);
+        assert!(cancelled.load(Ordering::SeqCst));
+    }
+
+    #[tokio::test]
+    async fn test_timeout_middleware_custom_response() {
+        use crate::middleware::Middleware;
+
+        let middleware = TimeoutMiddleware::from_millis(10)
+            .with_status(503)
+            .with_content_type("application/json")
+            .with_body(r#"{"error":"busy"}"#);
+        let req = HttpRequest::new("GET".to_string(), "/slow".to_string());
+
+        let response = middleware
+            .handle(req, slow_next(Duration::from_secs(5), Default::default()))
+            .await
+            .unwrap();
+
+        assert_eq!(response.status, 503);
+        assert_eq!(response.body, br#"{"error":"busy"}"#);
+        assert_eq!(
+            response.headers.get("Content-Type").map(String::as_str),
+            Some("application/json")
+        );
+    }
+
+    #[tokio::test]
+    async fn test_configurable_timeout_per_route() {
+        use crate::middleware::Middleware;
+
+        let middleware = TimeoutConfig::new()
+            .default_timeout_ms(10)
+            .route_timeout("/api/report", 5)
+            .timeout_status(504)
+            .into_middleware();
+
+        let fast = HttpRequest::new("GET".to_string(), "/api/report".to_string());
+        let response = middleware
+            .handle(
+                fast,
+                slow_next(Duration::from_millis(30), Default::default()),
+            )
+            .await
+            .unwrap();
+        assert_eq!(response.status, 200);
+
+        let slow = HttpRequest::new("GET".to_string(), "/api/other".to_string());
+        let response = middleware
+            .handle(
+                slow,
+                slow_next(Duration::from_millis(30), Default::default()),
+            )
+            .await
+            .unwrap();
+        assert_eq!(response.status, 504);
+        assert_eq!(response.body, b"Request exceeded timeout of 10ms");
+    }
+
+    #[tokio::test]
+    async fn test_nested_timeouts_keep_earliest_deadline() {
+        use crate::middleware::Middleware;
+
+        let next: crate::middleware::Next = Box::new(|req: HttpRequest| {
+            Box::pin(async move {
+                let remaining = req.deadline().unwrap().remaining();
+                assert!(remaining <= Duration::from_secs(1), "{:?}", remaining);
+                Ok(HttpResponse::ok())
+            })
+        });
+
+        let inner = TimeoutMiddleware::new(60);
+        let outer = TimeoutMiddleware::new(1);
+        let result = outer
+            .handle(
+                HttpRequest::new("GET".to_string(), "/".to_string()),
+                Box::new(move |req| Box::pin(async move { inner.handle(req, next).await })),
+            )
+            .await;
+        assert!(result.is_ok());
+    }
 }
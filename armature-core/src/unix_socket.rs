@@ -0,0 +1,297 @@
+//! Serving HTTP on a Unix domain socket.
+//!
+//! Behind a reverse proxy or a sidecar on the same host, the server can
+//! listen on a Unix socket instead of a TCP port, so only processes allowed
+//! to open the socket file can reach it:
+//!
+//! ```no_run
+//! # async fn run(app: armature_core::Application) -> Result<(), armature_core::Error> {
+//! use armature_core::UnixSocketConfig;
+//!
+//! app.listen_unix_with_config(UnixSocketConfig::new("/run/myapp/http.sock").permissions(0o600))
+//!     .await
+//! # }
+//! ```
+//!
+//! A socket file left behind by a server that didn't exit cleanly is
+//! replaced. One that another server is still listening on is left alone,
+//! and so is any path that isn't a socket; both fail to start with an error
+//! instead. The socket file is removed when the server stops.
+//!
+//! Connections over a Unix socket have no peer address, so
+//! [`HttpRequest::remote_addr`](crate::HttpRequest::remote_addr) and
+//! [`HttpRequest::client_ip`](crate::HttpRequest::client_ip) return `None`,
+//! and [`TrustedProxies`](crate::TrustedProxies), which matches peers by IP,
+//! never reads forwarding headers for them.
+
+use crate::application::Listener;
+use crate::logging::debug;
+use crate::{Application, Error};
+use std::io;
+use std::net::SocketAddr;
+use std::os::unix::fs::{FileTypeExt, PermissionsExt};
+use std::path::{Path, PathBuf};
+use tokio::net::{UnixListener, UnixStream};
+
+/// Where and how to create the socket for
+/// [`Application::listen_unix_with_config`].
+#[derive(Debug, Clone)]
+pub struct UnixSocketConfig {
+    path: PathBuf,
+    permissions: u32,
+}
+
+impl UnixSocketConfig {
+    /// Listen on the socket at `path`, readable and writable by its owner
+    /// and group (`0o660`).
+    pub fn new(path: impl Into<PathBuf>) -> Self {
+        Self {
+            path: path.into(),
+            permissions: 0o660,
+        }
+    }
+
+    /// Set the file mode of the socket, e.g. `0o600` for its owner only.
+    pub fn permissions(mut self, mode: u32) -> Self {
+        self.permissions = mode;
+        self
+    }
+
+    /// Path of the socket file
+    pub fn path(&self) -> &Path {
+        &self.path
+    }
+}
+
+impl Application {
+    /// Start the HTTP server on a Unix domain socket at `path`
+    ///
+    /// Shorthand for [`listen_unix_with_config`](Self::listen_unix_with_config)
+    /// with the default permissions.
+    ///
+    /// # Example
+    ///
+    /// ```rust,ignore
+    /// app.listen_unix("/run/myapp/http.sock").await?;
+    /// ```
+    pub async fn listen_unix(self, path: impl Into<PathBuf>) -> Result<(), Error> {
+        self.listen_unix_with_config(UnixSocketConfig::new(path))
+            .await
+    }
+
+    /// Start the HTTP server on a Unix domain socket
+    ///
+    /// Serves like [`listen`](Self::listen) and returns once a shutdown
+    /// requested through the [`shutdown_handle`](Self::shutdown_handle) has
+    /// completed, after removing the socket file. See the
+    /// [module documentation](crate::unix_socket) for how an existing file
+    /// at the path is handled.
+    ///
+    /// # Errors
+    ///
+    /// Fails with [`Error::Io`] if another server is listening on the
+    /// socket (`AddrInUse`), if the path exists and isn't a socket
+    /// (`AlreadyExists`), or if the socket can't be created.
+    pub async fn listen_unix_with_config(self, config: UnixSocketConfig) -> Result<(), Error> {
+        debug!(path = %config.path.display(), "Binding to Unix socket");
+        let listener = UnixSocketListener::bind(&config).await?;
+
+        self.serve(listener).await
+    }
+}
+
+/// Listener on a socket file, which it removes when dropped
+struct UnixSocketListener {
+    listener: UnixListener,
+    path: PathBuf,
+}
+
+impl UnixSocketListener {
+    async fn bind(config: &UnixSocketConfig) -> io::Result<Self> {
+        remove_stale_socket(&config.path).await?;
+        let listener = Self {
+            listener: UnixListener::bind(&config.path)?,
+            path: config.path.clone(),
+        };
+        std::fs::set_permissions(
+            &listener.path,
+            std::fs::Permissions::from_mode(config.permissions),
+        )?;
+        Ok(listener)
+    }
+}
+
+impl Listener for UnixSocketListener {
+    type Io = UnixStream;
+
+    async fn accept(&self) -> io::Result<(Self::Io, Option<SocketAddr>)> {
+        let (stream, _) = self.listener.accept().await?;
+        Ok((stream, None))
+    }
+
+    fn local_addr(&self) -> io::Result<String> {
+        Ok(format!("unix:{}", self.path.display()))
+    }
+}
+
+impl Drop for UnixSocketListener {
+    fn drop(&mut self) {
+        if let Err(e) = std::fs::remove_file(&self.path) {
+            debug!(path = %self.path.display(), error = %e, "Failed to remove Unix socket");
+        }
+    }
+}
+
+/// Remove a socket file nothing listens on any more
+async fn remove_stale_socket(path: &Path) -> io::Result<()> {
+    let metadata = match std::fs::symlink_metadata(path) {
+        Ok(metadata) => metadata,
+        Err(e) if e.kind() == io::ErrorKind::NotFound => return Ok(()),
+        Err(e) => return Err(e),
+    };
+    if !metadata.file_type().is_socket() {
+        return Err(io::Error::new(
+            io::ErrorKind::AlreadyExists,
+            format!("{} already exists and is not a socket", path.display()),
+        ));
+    }
+
+    match UnixStream::connect(path).await {
+        Ok(_) => Err(io::Error::new(
+            io::ErrorKind::AddrInUse,
+            format!("{} is in use by another server", path.display()),
+        )),
+        Err(e) if e.kind() == io::ErrorKind::ConnectionRefused => {
+            debug!(path = %path.display(), "Removing stale Unix socket");
+            std::fs::remove_file(path)
+        }
+        Err(e) => Err(e),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{Container, HttpRequest, HttpResponse, Router};
+    use tokio::io::{AsyncReadExt, AsyncWriteExt};
+
+    fn socket_path() -> PathBuf {
+        std::env::temp_dir().join(format!("armature-{}.sock", uuid::Uuid::new_v4()))
+    }
+
+    fn app() -> Application {
+        let mut router = Router::new();
+        router.get("/peer", |req: HttpRequest| async move {
+            Ok(HttpResponse::text(format!(
+                "{:?} {:?}",
+                req.remote_addr(),
+                req.client_ip()
+            )))
+        });
+        Application::new(Container::new(), router)
+    }
+
+    /// Connect once the server is listening
+    async fn connect(path: &Path) -> UnixStream {
+        for _ in 0..200 {
+            if let Ok(stream) = UnixStream::connect(path).await {
+                return stream;
+            }
+            tokio::time::sleep(std::time::Duration::from_millis(10)).await;
+        }
+        panic!("server never listened on {}", path.display());
+    }
+
+    async fn get(path: &Path, target: &str) -> String {
+        let mut stream = connect(path).await;
+        let request = format!(
+            "GET {} HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n",
+            target
+        );
+        stream.write_all(request.as_bytes()).await.unwrap();
+        let mut response = String::new();
+        stream.read_to_string(&mut response).await.unwrap();
+        response
+    }
+
+    fn mode(path: &Path) -> u32 {
+        std::fs::metadata(path).unwrap().permissions().mode() & 0o777
+    }
+
+    #[tokio::test]
+    async fn test_serves_requests_over_unix_socket() {
+        let path = socket_path();
+        let app = app();
+        let handle = app.shutdown_handle();
+        let server = tokio::spawn(
+            app.listen_unix_with_config(UnixSocketConfig::new(&path).permissions(0o600)),
+        );
+
+        let response = get(&path, "/peer").await;
+        assert!(response.starts_with("HTTP/1.1 200"), "{}", response);
+        assert!(response.ends_with("None None"), "{}", response);
+        assert_eq!(mode(&path), 0o600);
+
+        handle
+            .shutdown(std::time::Duration::from_secs(5))
+            .await
+            .unwrap();
+        server.await.unwrap().unwrap();
+        assert!(!path.exists());
+    }
+
+    #[tokio::test]
+    async fn test_stale_socket_is_replaced() {
+        let path = socket_path();
+        // A listener that went away without removing its socket file
+        drop(std::os::unix::net::UnixListener::bind(&path).unwrap());
+        assert!(path.exists());
+
+        let app = app();
+        let handle = app.shutdown_handle();
+        let server = tokio::spawn(app.listen_unix(path.clone()));
+
+        let response = get(&path, "/peer").await;
+        assert!(response.starts_with("HTTP/1.1 200"), "{}", response);
+        assert_eq!(mode(&path), 0o660);
+
+        handle
+            .shutdown(std::time::Duration::from_secs(5))
+            .await
+            .unwrap();
+        server.await.unwrap().unwrap();
+        assert!(!path.exists());
+    }
+
+    #[tokio::test]
+    async fn test_existing_paths_are_not_clobbered() {
+        // Another server is still listening
+        let path = socket_path();
+        let _other = std::os::unix::net::UnixListener::bind(&path).unwrap();
+        let err = app().listen_unix(path.clone()).await.unwrap_err();
+        assert!(
+            matches!(&err, Error::Io(e) if e.kind() == io::ErrorKind::AddrInUse),
+            "{:?}",
+            err
+        );
+        assert!(
+            err.to_string().contains("in use by another server"),
+            "{}",
+            err
+        );
+        assert!(path.exists());
+        std::fs::remove_file(&path).unwrap();
+
+        // A file that isn't a socket
+        let path = socket_path();
+        std::fs::write(&path, "data").unwrap();
+        let err = app().listen_unix(path.clone()).await.unwrap_err();
+        assert!(
+            matches!(&err, Error::Io(e) if e.kind() == io::ErrorKind::AlreadyExists),
+            "{:?}",
+            err
+        );
+        assert_eq!(std::fs::read_to_string(&path).unwrap(), "data");
+        std::fs::remove_file(&path).unwrap();
+    }
+}
@@ -1,12 +1,21 @@
 // WebSocket support for Armature
 
-use crate::Error;
-use futures_util::StreamExt;
+use crate::logging::{debug, error};
+use crate::{Error, HttpRequest, HttpResponse};
+use base64::Engine;
+use futures_util::stream::SplitSink;
+use futures_util::{Sink, SinkExt, Stream, StreamExt};
+use hyper::upgrade::{OnUpgrade, Upgraded};
+use hyper_util::rt::TokioIo;
 use std::collections::HashMap;
 use std::sync::Arc;
-use tokio::sync::{RwLock, broadcast};
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::time::{Duration, Instant};
+use tokio::sync::{Mutex, Notify, RwLock, broadcast, mpsc};
 use tokio_tungstenite::WebSocketStream;
 use tokio_tungstenite::tungstenite::Message as WsMessage;
+use tokio_tungstenite::tungstenite::protocol::frame::coding::CloseCode;
+use tokio_tungstenite::tungstenite::protocol::{CloseFrame, Role};
 
 /// WebSocket message type
 #[derive(Debug, Clone)]
@@ -193,3 +202,787 @@ where
 
     Ok(())
 }
+
+/// Origin check used to accept or reject an upgrade request
+pub type OriginCheck = Arc<dyn Fn(&HttpRequest) -> bool + Send + Sync>;
+
+/// Options for accepting a WebSocket upgrade
+///
+/// By default only same-origin upgrades are accepted: a request with an
+/// `Origin` header must name the host it was sent to.
+///
+/// # Examples
+///
+/// ```
+/// use armature_core::*;
+/// use std::time::Duration;
+///
+/// let options = UpgradeOptions::new()
+///     .protocols(["chat.v2", "chat.v1"])
+///     .check_origin(|req| req.header("Origin") == Some("https://app.example.com"))
+///     .ping_interval(Duration::from_secs(30));
+/// ```
+#[derive(Clone, Default)]
+pub struct UpgradeOptions {
+    protocols: Vec<String>,
+    check_origin: Option<OriginCheck>,
+    ping_interval: Option<Duration>,
+}
+
+impl UpgradeOptions {
+    /// Create options with the default same-origin policy and no keepalive
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Set the supported subprotocols, most preferred first
+    pub fn protocols<I, S>(mut self, protocols: I) -> Self
+    where
+        I: IntoIterator<Item = S>,
+        S: Into<String>,
+    {
+        self.protocols = protocols.into_iter().map(Into::into).collect();
+        self
+    }
+
+    /// Decide which requests may upgrade, replacing the same-origin check
+    pub fn check_origin<F>(mut self, check: F) -> Self
+    where
+        F: Fn(&HttpRequest) -> bool + Send + Sync + 'static,
+    {
+        self.check_origin = Some(Arc::new(check));
+        self
+    }
+
+    /// Accept upgrades from any origin
+    pub fn allow_any_origin(self) -> Self {
+        self.check_origin(|_| true)
+    }
+
+    /// Send a ping at this interval
+    ///
+    /// The connection is closed if nothing is received from the peer for two
+    /// intervals.
+    pub fn ping_interval(mut self, interval: Duration) -> Self {
+        self.ping_interval = Some(interval);
+        self
+    }
+}
+
+impl std::fmt::Debug for UpgradeOptions {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.debug_struct("UpgradeOptions")
+            .field("protocols", &self.protocols)
+            .field("check_origin", &self.check_origin.is_some())
+            .field("ping_interval", &self.ping_interval)
+            .finish()
+    }
+}
+
+/// Upgrade future for the connection a request arrived on
+///
+/// Stored in the request extensions by the server so a handler can take it.
+pub(crate) struct PendingUpgrade(std::sync::Mutex<Option<OnUpgrade>>);
+
+impl PendingUpgrade {
+    pub(crate) fn new(on_upgrade: OnUpgrade) -> Self {
+        Self(std::sync::Mutex::new(Some(on_upgrade)))
+    }
+
+    pub(crate) fn take(&self) -> Option<OnUpgrade> {
+        self.0.lock().unwrap_or_else(|e| e.into_inner()).take()
+    }
+}
+
+/// Validated WebSocket handshake
+#[derive(Debug)]
+struct Handshake {
+    accept: String,
+    protocol: Option<String>,
+}
+
+impl HttpRequest {
+    /// Check if this is a WebSocket upgrade request
+    pub fn is_websocket_upgrade(&self) -> bool {
+        self.header("Upgrade")
+            .is_some_and(|v| has_token(v, "websocket"))
+    }
+
+    /// Upgrade the connection to a WebSocket using default options
+    ///
+    /// See [`HttpRequest::upgrade_with`].
+    pub fn upgrade<F, Fut>(&self, handler: F) -> Result<HttpResponse, Error>
+    where
+        F: FnOnce(WebSocket) -> Fut + Send + 'static,
+        Fut: std::future::Future<Output = Result<(), Error>> + Send + 'static,
+    {
+        self.upgrade_with(&UpgradeOptions::default(), handler)
+    }
+
+    /// Upgrade the connection to a WebSocket
+    ///
+    /// Validates the handshake and returns the `101 Switching Protocols`
+    /// response for the handler to return. Once the response has been sent,
+    /// `handler` runs with the connection; the connection is closed when the
+    /// handler returns.
+    ///
+    /// Fails with `400 Bad Request` for a malformed handshake, `426 Upgrade
+    /// Required` for an unsupported `Sec-WebSocket-Version`, and
+    /// `403 Forbidden` when the origin check rejects the request.
+    ///
+    /// # Examples
+    ///
+    /// ```no_run
+    /// use armature_core::*;
+    ///
+    /// let mut router = Router::new();
+    /// router.get("/ws", |req: HttpRequest| async move {
+    ///     let options = UpgradeOptions::new().protocols(["chat"]);
+    ///     req.upgrade_with(&options, |mut ws| async move {
+    ///         while let Some(message) = ws.read_message().await? {
+    ///             ws.write_message(message).await?;
+    ///         }
+    ///         Ok(())
+    ///     })
+    /// });
+    /// ```
+    pub fn upgrade_with<F, Fut>(
+        &self,
+        options: &UpgradeOptions,
+        handler: F,
+    ) -> Result<HttpResponse, Error>
+    where
+        F: FnOnce(WebSocket) -> Fut + Send + 'static,
+        Fut: std::future::Future<Output = Result<(), Error>> + Send + 'static,
+    {
+        let handshake = self.websocket_handshake(options)?;
+        let on_upgrade = self
+            .extensions
+            .get::<PendingUpgrade>()
+            .and_then(PendingUpgrade::take)
+            .ok_or_else(|| {
+                Error::Internal("connection does not support protocol upgrades".to_string())
+            })?;
+
+        let protocol = handshake.protocol.clone();
+        let ping_interval = options.ping_interval;
+        tokio::spawn(async move {
+            let upgraded = match on_upgrade.await {
+                Ok(upgraded) => upgraded,
+                Err(e) => {
+                    error!(error = %e, "WebSocket upgrade failed");
+                    return;
+                }
+            };
+            let stream =
+                WebSocketStream::from_raw_socket(TokioIo::new(upgraded), Role::Server, None).await;
+            let ws = WebSocket::new(stream, protocol, ping_interval);
+            let closer = ws.closer();
+
+            match handler(ws).await {
+                Ok(()) => closer.close(CloseCode::Normal, "").await,
+                Err(e) => {
+                    error!(error = %e, "WebSocket handler failed");
+                    closer.close(CloseCode::Error, "internal error").await;
+                }
+            }
+            debug!("WebSocket connection closed");
+        });
+
+        let mut response = HttpResponse::new(101)
+            .with_header("Upgrade".to_string(), "websocket".to_string())
+            .with_header("Connection".to_string(), "Upgrade".to_string())
+            .with_header("Sec-WebSocket-Accept".to_string(), handshake.accept);
+        if let Some(protocol) = handshake.protocol {
+            response = response.with_header("Sec-WebSocket-Protocol".to_string(), protocol);
+        }
+        Ok(response)
+    }
+
+    /// Validate the upgrade headers and negotiate a subprotocol
+    fn websocket_handshake(&self, options: &UpgradeOptions) -> Result<Handshake, Error> {
+        if self.method != "GET" {
+            return Err(Error::MethodNotAllowed(self.method.clone()));
+        }
+        let connection_upgrade = self
+            .header("Connection")
+            .is_some_and(|v| has_token(v, "upgrade"));
+        if !connection_upgrade || !self.is_websocket_upgrade() {
+            return Err(Error::BadRequest(
+                "not a WebSocket upgrade request".to_string(),
+            ));
+        }
+        if self.header("Sec-WebSocket-Version").map(str::trim) != Some("13") {
+            return Err(Error::UpgradeRequired(
+                "unsupported Sec-WebSocket-Version, expected 13".to_string(),
+            ));
+        }
+
+        let key = self
+            .header("Sec-WebSocket-Key")
+            .map(str::trim)
+            .filter(|key| {
+                base64::engine::general_purpose::STANDARD
+                    .decode(key)
+                    .is_ok_and(|nonce| nonce.len() == 16)
+            })
+            .ok_or_else(|| Error::BadRequest("invalid Sec-WebSocket-Key".to_string()))?;
+
+        let origin_allowed = match &options.check_origin {
+            Some(check) => check(self),
+            None => same_origin(self),
+        };
+        if !origin_allowed {
+            return Err(Error::Forbidden(
+                "cross-origin WebSocket upgrade rejected".to_string(),
+            ));
+        }
+
+        let offered: Vec<&str> = self
+            .header("Sec-WebSocket-Protocol")
+            .map(|v| v.split(',').map(str::trim).collect())
+            .unwrap_or_default();
+        let protocol = options
+            .protocols
+            .iter()
+            .find(|p| offered.contains(&p.as_str()))
+            .cloned();
+
+        Ok(Handshake {
+            accept: tokio_tungstenite::tungstenite::handshake::derive_accept_key(key.as_bytes()),
+            protocol,
+        })
+    }
+}
+
+/// Check a comma-separated header value for a token, ignoring case
+fn has_token(value: &str, token: &str) -> bool {
+    value
+        .split(',')
+        .any(|t| t.trim().eq_ignore_ascii_case(token))
+}
+
+/// Accept requests without an `Origin`, or whose origin names the `Host`
+fn same_origin(req: &HttpRequest) -> bool {
+    let Some(origin) = req.header("Origin") else {
+        return true;
+    };
+    let authority = origin
+        .split_once("://")
+        .map_or(origin, |(_, rest)| rest)
+        .trim_end_matches('/');
+    req.header("Host")
+        .is_some_and(|host| host.eq_ignore_ascii_case(authority))
+}
+
+type Socket = WebSocketStream<TokioIo<Upgraded>>;
+
+/// Messages buffered for [`WebSocket::read_message`] before the connection
+/// stops reading from the peer
+const READ_BUFFER: usize = 16;
+
+/// An upgraded WebSocket connection
+///
+/// Created by [`HttpRequest::upgrade`]. The connection is read in the
+/// background, so pings from the peer are answered and pongs keep it alive
+/// even while the handler only writes; pings and pongs are not returned by
+/// [`read_message`](Self::read_message).
+pub struct WebSocket {
+    messages: mpsc::Receiver<Result<WebSocketMessage, Error>>,
+    closer: Closer,
+    protocol: Option<String>,
+    timed_out: Arc<Notify>,
+    reader: tokio::task::JoinHandle<()>,
+    keepalive: Option<tokio::task::JoinHandle<()>>,
+}
+
+/// When the peer was last heard from
+#[derive(Clone)]
+struct Liveness {
+    last_seen: Arc<std::sync::Mutex<Instant>>,
+    /// Set while the reader waits for the handler to take buffered messages
+    backlogged: Arc<AtomicBool>,
+}
+
+impl Liveness {
+    fn new() -> Self {
+        Self {
+            last_seen: Arc::new(std::sync::Mutex::new(Instant::now())),
+            backlogged: Arc::new(AtomicBool::new(false)),
+        }
+    }
+
+    fn touch(&self) {
+        *self.last_seen.lock().unwrap_or_else(|e| e.into_inner()) = Instant::now();
+    }
+
+    /// Time since the peer was last heard from, zero while backlogged
+    fn idle(&self) -> Duration {
+        if self.backlogged.load(Ordering::Acquire) {
+            return Duration::ZERO;
+        }
+        self.last_seen
+            .lock()
+            .unwrap_or_else(|e| e.into_inner())
+            .elapsed()
+    }
+}
+
+/// Read frames from the peer until the connection ends
+///
+/// Every frame counts as a sign of life. Text and binary messages are passed
+/// on to `messages`; once that buffer is full the peer is not read until the
+/// handler catches up. A close frame from the peer is answered through
+/// `closer`.
+async fn read_frames<S, W>(
+    mut stream: S,
+    liveness: Liveness,
+    closer: Closer<W>,
+    messages: mpsc::Sender<Result<WebSocketMessage, Error>>,
+) where
+    S: Stream<Item = Result<WsMessage, tokio_tungstenite::tungstenite::Error>> + Unpin,
+    W: Sink<WsMessage, Error = tokio_tungstenite::tungstenite::Error> + Unpin,
+{
+    while let Some(next) = stream.next().await {
+        let message = match next {
+            Ok(message) => message,
+            Err(
+                tokio_tungstenite::tungstenite::Error::ConnectionClosed
+                | tokio_tungstenite::tungstenite::Error::AlreadyClosed,
+            ) => return,
+            Err(e) => {
+                let _ = messages.send(Err(ws_error(e))).await;
+                return;
+            }
+        };
+        liveness.touch();
+        match message {
+            WsMessage::Text(_) | WsMessage::Binary(_) => {
+                liveness.backlogged.store(true, Ordering::Release);
+                let sent = messages.send(Ok(message.into())).await;
+                liveness.touch();
+                liveness.backlogged.store(false, Ordering::Release);
+                if sent.is_err() {
+                    return;
+                }
+            }
+            WsMessage::Close(_) => {
+                closer.reply_to_close().await;
+                return;
+            }
+            _ => {}
+        }
+    }
+}
+
+/// Shared write half, used by the connection, reader, keepalive and cleanup
+struct Closer<W = SplitSink<Socket, WsMessage>> {
+    sink: Arc<Mutex<W>>,
+    closed: Arc<AtomicBool>,
+}
+
+impl<W> Clone for Closer<W> {
+    fn clone(&self) -> Self {
+        Self {
+            sink: self.sink.clone(),
+            closed: self.closed.clone(),
+        }
+    }
+}
+
+impl<W> Closer<W>
+where
+    W: Sink<WsMessage, Error = tokio_tungstenite::tungstenite::Error> + Unpin,
+{
+    async fn send(&self, message: WsMessage) -> Result<(), Error> {
+        if self.closed.load(Ordering::Acquire) {
+            return Err(Error::Internal(
+                "WebSocket connection is closed".to_string(),
+            ));
+        }
+        self.sink.lock().await.send(message).await.map_err(ws_error)
+    }
+
+    async fn close(&self, code: CloseCode, reason: &str) {
+        if self.closed.swap(true, Ordering::AcqRel) {
+            return;
+        }
+        let mut sink = self.sink.lock().await;
+        let frame = CloseFrame {
+            code,
+            reason: reason.into(),
+        };
+        let _ = sink.send(WsMessage::Close(Some(frame))).await;
+        let _ = sink.close().await;
+    }
+
+    /// Answer a close frame from the peer and shut the connection down
+    ///
+    /// tungstenite queues the reply, echoing the peer's status code, when it
+    /// reads the close frame, but only writes it on the next read or flush;
+    /// closing the sink sends it before the TCP connection goes away.
+    async fn reply_to_close(&self) {
+        self.closed.store(true, Ordering::Release);
+        let _ = self.sink.lock().await.close().await;
+    }
+}
+
+impl WebSocket {
+    fn new(stream: Socket, protocol: Option<String>, ping_interval: Option<Duration>) -> Self {
+        let (sink, stream) = stream.split();
+        let closer = Closer {
+            sink: Arc::new(Mutex::new(sink)),
+            closed: Arc::new(AtomicBool::new(false)),
+        };
+        let liveness = Liveness::new();
+        let timed_out = Arc::new(Notify::new());
+
+        let (tx, messages) = mpsc::channel(READ_BUFFER);
+        let reader = tokio::spawn(read_frames(stream, liveness.clone(), closer.clone(), tx));
+
+        let keepalive = ping_interval.map(|interval| {
+            let closer = closer.clone();
+            let timed_out = timed_out.clone();
+            tokio::spawn(async move {
+                let mut ticker = tokio::time::interval(interval);
+                ticker.tick().await;
+                loop {
+                    ticker.tick().await;
+                    if liveness.idle() >= interval * 2 {
+                        debug!("WebSocket peer stopped responding to pings");
+                        closer.close(CloseCode::Away, "ping timeout").await;
+                        timed_out.notify_one();
+                        return;
+                    }
+                    if closer
+                        .send(WsMessage::Ping(Default::default()))
+                        .await
+                        .is_err()
+                    {
+                        return;
+                    }
+                }
+            })
+        });
+
+        Self {
+            messages,
+            closer,
+            protocol,
+            timed_out,
+            reader,
+            keepalive,
+        }
+    }
+
+    /// The negotiated subprotocol, if any
+    pub fn protocol(&self) -> Option<&str> {
+        self.protocol.as_deref()
+    }
+
+    /// Read the next text or binary message
+    ///
+    /// Returns `Ok(None)` once the connection has been closed.
+    pub async fn read_message(&mut self) -> Result<Option<WebSocketMessage>, Error> {
+        if self.closer.closed.load(Ordering::Acquire) {
+            // Hand out what arrived before the close
+            return self.messages.try_recv().ok().transpose();
+        }
+        tokio::select! {
+            next = self.messages.recv() => next.transpose(),
+            _ = self.timed_out.notified() => Err(Error::RequestTimeout(
+                "WebSocket peer stopped responding to pings".to_string(),
+            )),
+        }
+    }
+
+    /// Send a message
+    pub async fn write_message(&self, message: WebSocketMessage) -> Result<(), Error> {
+        self.closer.send(message.into()).await
+    }
+
+    /// Send a text message
+    pub async fn write_text(&self, text: impl Into<String>) -> Result<(), Error> {
+        self.write_message(WebSocketMessage::Text(text.into()))
+            .await
+    }
+
+    /// Serialize a value and send it as a text message
+    pub async fn write_json<T: serde::Serialize>(&self, data: &T) -> Result<(), Error> {
+        let json = serde_json::to_string(data).map_err(|e| Error::Serialization(e.to_string()))?;
+        self.write_text(json).await
+    }
+
+    /// Close the connection normally
+    pub async fn close(&self) {
+        self.closer.close(CloseCode::Normal, "").await;
+    }
+
+    /// Close the connection with a status code and reason
+    pub async fn close_with(&self, code: u16, reason: &str) {
+        self.closer.close(CloseCode::from(code), reason).await;
+    }
+
+    fn closer(&self) -> Closer {
+        self.closer.clone()
+    }
+}
+
+impl Drop for WebSocket {
+    fn drop(&mut self) {
+        self.reader.abort();
+        if let Some(keepalive) = self.keepalive.take() {
+            keepalive.abort();
+        }
+    }
+}
+
+impl std::fmt::Debug for WebSocket {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.debug_struct("WebSocket")
+            .field("protocol", &self.protocol)
+            .field("closed", &self.closer.closed.load(Ordering::Acquire))
+            .finish()
+    }
+}
+
+fn ws_error(e: tokio_tungstenite::tungstenite::Error) -> Error {
+    Error::Internal(format!("WebSocket error: {}", e))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn upgrade_request() -> HttpRequest {
+        let mut req = HttpRequest::new("GET".to_string(), "/ws".to_string());
+        for (name, value) in [
+            ("Host", "example.com"),
+            ("Upgrade", "websocket"),
+            ("Connection", "keep-alive, Upgrade"),
+            ("Sec-WebSocket-Version", "13"),
+            ("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ=="),
+        ] {
+            req.headers.insert(name.to_string(), value.to_string());
+        }
+        req
+    }
+
+    #[test]
+    fn test_handshake_accept_key() {
+        let handshake = upgrade_request()
+            .websocket_handshake(&UpgradeOptions::default())
+            .unwrap();
+
+        // Example from RFC 6455, section 1.3
+        assert_eq!(handshake.accept, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=");
+        assert_eq!(handshake.protocol, None);
+    }
+
+    #[test]
+    fn test_handshake_rejects_invalid_requests() {
+        let options = UpgradeOptions::default();
+
+        let mut req = upgrade_request();
+        req.headers.remove("Sec-WebSocket-Key");
+        let err = req.websocket_handshake(&options).unwrap_err();
+        assert_eq!(err.status_code(), 400);
+
+        let mut req = upgrade_request();
+        req.headers
+            .insert("Sec-WebSocket-Key".to_string(), "c2hvcnQ=".to_string());
+        let err = req.websocket_handshake(&options).unwrap_err();
+        assert_eq!(err.status_code(), 400);
+
+        let mut req = upgrade_request();
+        req.headers
+            .insert("Sec-WebSocket-Version".to_string(), "8".to_string());
+        let err = req.websocket_handshake(&options).unwrap_err();
+        assert_eq!(err.status_code(), 426);
+
+        let mut req = upgrade_request();
+        req.headers.remove("Connection");
+        let err = req.websocket_handshake(&options).unwrap_err();
+        assert_eq!(err.status_code(), 400);
+
+        let mut req = upgrade_request();
+        req.method = "POST".to_string();
+        let err = req.websocket_handshake(&options).unwrap_err();
+        assert_eq!(err.status_code(), 405);
+    }
+
+    #[test]
+    fn test_handshake_origin_check() {
+        let default = UpgradeOptions::default();
+
+        let mut req = upgrade_request();
+        req.headers
+            .insert("Origin".to_string(), "https://example.com".to_string());
+        assert!(req.websocket_handshake(&default).is_ok());
+
+        req.headers
+            .insert("Origin".to_string(), "https://evil.example".to_string());
+        let err = req.websocket_handshake(&default).unwrap_err();
+        assert_eq!(err.status_code(), 403);
+
+        let allow_evil = UpgradeOptions::new()
+            .check_origin(|req| req.header("Origin") == Some("https://evil.example"));
+        assert!(req.websocket_handshake(&allow_evil).is_ok());
+        assert!(
+            req.websocket_handshake(&UpgradeOptions::new().allow_any_origin())
+                .is_ok()
+        );
+    }
+
+    #[test]
+    fn test_handshake_protocol_negotiation() {
+        let mut req = upgrade_request();
+        req.headers.insert(
+            "Sec-WebSocket-Protocol".to_string(),
+            "chat.v1, chat.v2".to_string(),
+        );
+
+        // The server's preference wins
+        let options = UpgradeOptions::new().protocols(["chat.v2", "chat.v1"]);
+        let handshake = req.websocket_handshake(&options).unwrap();
+        assert_eq!(handshake.protocol.as_deref(), Some("chat.v2"));
+
+        let options = UpgradeOptions::new().protocols(["graphql-ws"]);
+        let handshake = req.websocket_handshake(&options).unwrap();
+        assert_eq!(handshake.protocol, None);
+    }
+
+    /// Write half recording what the connection sends
+    #[derive(Default)]
+    struct RecordingSink {
+        sent: Vec<WsMessage>,
+        closed: bool,
+    }
+
+    impl Sink<WsMessage> for RecordingSink {
+        type Error = tokio_tungstenite::tungstenite::Error;
+
+        fn poll_ready(
+            self: std::pin::Pin<&mut Self>,
+            _cx: &mut std::task::Context<'_>,
+        ) -> std::task::Poll<Result<(), Self::Error>> {
+            std::task::Poll::Ready(Ok(()))
+        }
+
+        fn start_send(
+            mut self: std::pin::Pin<&mut Self>,
+            item: WsMessage,
+        ) -> Result<(), Self::Error> {
+            self.sent.push(item);
+            Ok(())
+        }
+
+        fn poll_flush(
+            self: std::pin::Pin<&mut Self>,
+            _cx: &mut std::task::Context<'_>,
+        ) -> std::task::Poll<Result<(), Self::Error>> {
+            std::task::Poll::Ready(Ok(()))
+        }
+
+        fn poll_close(
+            mut self: std::pin::Pin<&mut Self>,
+            _cx: &mut std::task::Context<'_>,
+        ) -> std::task::Poll<Result<(), Self::Error>> {
+            self.closed = true;
+            std::task::Poll::Ready(Ok(()))
+        }
+    }
+
+    fn recording_closer() -> (Closer<RecordingSink>, Arc<Mutex<RecordingSink>>) {
+        let sink = Arc::new(Mutex::new(RecordingSink::default()));
+        let closer = Closer {
+            sink: sink.clone(),
+            closed: Arc::new(AtomicBool::new(false)),
+        };
+        (closer, sink)
+    }
+
+    #[tokio::test]
+    async fn test_pongs_keep_connection_alive_without_reads() {
+        let (frames, rx) = mpsc::unbounded_channel();
+        let stream = futures_util::stream::unfold(rx, |mut rx| async move {
+            rx.recv().await.map(|frame| (frame, rx))
+        });
+        let liveness = Liveness::new();
+        let (closer, _sink) = recording_closer();
+        // Nothing ever reads the messages, as in a push-only handler
+        let (tx, _messages) = mpsc::channel(READ_BUFFER);
+        let reader = tokio::spawn(read_frames(
+            Box::pin(stream),
+            liveness.clone(),
+            closer.clone(),
+            tx,
+        ));
+
+        for _ in 0..5 {
+            tokio::time::sleep(Duration::from_millis(40)).await;
+            frames
+                .send(Ok(WsMessage::Pong(Default::default())))
+                .unwrap();
+        }
+        tokio::time::sleep(Duration::from_millis(10)).await;
+        assert!(liveness.idle() < Duration::from_millis(40));
+
+        frames.send(Ok(WsMessage::Close(None))).unwrap();
+        reader.await.unwrap();
+        assert!(closer.closed.load(Ordering::Acquire));
+    }
+
+    #[tokio::test]
+    async fn test_peer_close_is_answered() {
+        let frame = CloseFrame {
+            code: CloseCode::Away,
+            reason: "bye".into(),
+        };
+        let stream = futures_util::stream::iter([Ok(WsMessage::Close(Some(frame)))]);
+        let (closer, sink) = recording_closer();
+        let (tx, _messages) = mpsc::channel(READ_BUFFER);
+        read_frames(stream, Liveness::new(), closer.clone(), tx).await;
+
+        // The write half is flushed and closed, sending the queued reply
+        assert!(sink.lock().await.closed);
+        assert!(closer.closed.load(Ordering::Acquire));
+        assert!(closer.send(WsMessage::text("late")).await.is_err());
+        // Closing afterwards doesn't send a second close frame
+        closer.close(CloseCode::Normal, "").await;
+        assert!(sink.lock().await.sent.is_empty());
+    }
+
+    #[tokio::test]
+    async fn test_unread_messages_do_not_count_as_idle() {
+        let (frames, rx) = mpsc::unbounded_channel();
+        let stream = futures_util::stream::unfold(rx, |mut rx| async move {
+            rx.recv().await.map(|frame| (frame, rx))
+        });
+        let liveness = Liveness::new();
+        let (tx, mut messages) = mpsc::channel(1);
+        tokio::spawn(read_frames(
+            Box::pin(stream),
+            liveness.clone(),
+            recording_closer().0,
+            tx,
+        ));
+
+        for text in ["one", "two"] {
+            frames.send(Ok(WsMessage::text(text))).unwrap();
+        }
+        tokio::time::sleep(Duration::from_millis(60)).await;
+        assert_eq!(liveness.idle(), Duration::ZERO);
+
+        let first = messages.recv().await.unwrap().unwrap();
+        assert!(matches!(first, WebSocketMessage::Text(text) if text == "one"));
+    }
+
+    #[test]
+    fn test_upgrade_requires_server_connection() {
+        let err = upgrade_request()
+            .upgrade(|_ws| async { Ok(()) })
+            .unwrap_err();
+        assert!(matches!(err, Error::Internal(_)));
+    }
+}
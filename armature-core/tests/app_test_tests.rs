@@ -0,0 +1,614 @@
+// Tests for running requests in-process with Application::test
+
+use ::http::{Request, Response};
+use armature_core::streaming::HyperBody;
+use armature_core::*;
+use async_trait::async_trait;
+use http_body_util::BodyExt;
+use std::sync::Arc;
+use std::time::Duration;
+
+/// Middleware that records its name before and after calling the next one
+struct Trace {
+    name: &'static str,
+    log: Arc<parking_lot::Mutex<Vec<String>>>,
+}
+
+#[async_trait]
+impl Middleware for Trace {
+    async fn handle(&self, req: HttpRequest, next: Next) -> Result<HttpResponse, Error> {
+        self.log.lock().push(format!("{} before", self.name));
+        let response = next(req).await?;
+        self.log.lock().push(format!("{} after", self.name));
+        Ok(response.with_header(format!("x-{}", self.name), "1".to_string()))
+    }
+}
+
+fn app(router: Router) -> Application {
+    Application::new(Container::new(), router)
+}
+
+async fn body_string(response: Response<HyperBody>) -> String {
+    let bytes = response.into_body().collect().await.unwrap().to_bytes();
+    String::from_utf8(bytes.to_vec()).unwrap()
+}
+
+#[tokio::test]
+async fn test_matches_routes_and_extracts_params() {
+    let mut router = Router::new();
+    router.get("/users/:id", |req: HttpRequest| async move {
+        let id = req.param("id").cloned().unwrap_or_default();
+        Ok(HttpResponse::ok().with_body(format!("user {}", id).into_bytes()))
+    });
+    router.get("/users/:id/posts/:post", |req: HttpRequest| async move {
+        let post = req.param("post").cloned().unwrap_or_default();
+        Ok(HttpResponse::ok().with_body(format!("post {}", post).into_bytes()))
+    });
+    let app = app(router);
+
+    let response = app
+        .test(Request::get("/users/7").body("").unwrap())
+        .await
+        .unwrap();
+    assert_eq!(response.status(), 200);
+    assert_eq!(body_string(response).await, "user 7");
+
+    let response = app
+        .test(Request::get("/users/7/posts/3").body("").unwrap())
+        .await
+        .unwrap();
+    assert_eq!(body_string(response).await, "post 3");
+}
+
+#[tokio::test]
+async fn test_unmatched_route_returns_error_response() {
+    let app = app(Router::new());
+
+    let response = app
+        .test(Request::get("/missing").body("").unwrap())
+        .await
+        .unwrap();
+    assert_eq!(response.status(), 404);
+
+    let body: serde_json::Value = serde_json::from_str(&body_string(response).await).unwrap();
+    assert_eq!(body["status"], 404);
+}
+
+#[tokio::test]
+async fn test_passes_headers_and_body() {
+    let mut router = Router::new();
+    router.post("/echo", |req: HttpRequest| async move {
+        let kind = req.header("content-type").unwrap_or_default().to_string();
+        Ok(HttpResponse::ok()
+            .with_header("x-kind".to_string(), kind)
+            .with_body(req.body_ref().to_vec()))
+    });
+    let app = app(router);
+
+    let request = Request::post("/echo")
+        .header("Content-Type", "text/plain")
+        .body("ping")
+        .unwrap();
+    let response = app.test(request).await.unwrap();
+    assert_eq!(response.headers()["x-kind"], "text/plain");
+    assert_eq!(body_string(response).await, "ping");
+}
+
+#[tokio::test]
+async fn test_runs_middleware_in_order() {
+    let log = Arc::new(parking_lot::Mutex::new(Vec::new()));
+    let handler_log = Arc::clone(&log);
+
+    let mut router = Router::new();
+    router.use_middleware(Trace {
+        name: "outer",
+        log: Arc::clone(&log),
+    });
+    router.use_middleware(Trace {
+        name: "inner",
+        log: Arc::clone(&log),
+    });
+    router.get("/", move |_req: HttpRequest| {
+        let log = Arc::clone(&handler_log);
+        async move {
+            log.lock().push("handler".to_string());
+            Ok(HttpResponse::ok())
+        }
+    });
+    let app = app(router);
+
+    let response = app.test(Request::get("/").body("").unwrap()).await.unwrap();
+    assert_eq!(response.headers()["x-outer"], "1");
+    assert_eq!(response.headers()["x-inner"], "1");
+    assert_eq!(
+        *log.lock(),
+        [
+            "outer before",
+            "inner before",
+            "handler",
+            "inner after",
+            "outer after"
+        ]
+    );
+}
+
+#[tokio::test]
+async fn test_middleware_priorities() {
+    let log = Arc::new(parking_lot::Mutex::new(Vec::new()));
+    let trace = |name| Trace {
+        name,
+        log: Arc::clone(&log),
+    };
+
+    let mut router = Router::new();
+    router.use_middleware_with_priority(trace("compress"), 10);
+    router.use_middleware(trace("auth"));
+    router.use_middleware_with_priority(trace("logger"), -10);
+    router.use_middleware_with_priority(trace("request-id"), -20);
+    router.use_middleware_with_priority(trace("session"), 0);
+    router.use_middleware_with_priority(trace("recover"), -10);
+    router.get("/", |_req: HttpRequest| async { Ok(HttpResponse::ok()) });
+    let app = app(router);
+
+    app.test(Request::get("/").body("").unwrap()).await.unwrap();
+    let order = [
+        "request-id",
+        "logger",
+        "recover",
+        "auth",
+        "session",
+        "compress",
+    ];
+    let expected: Vec<String> = order
+        .iter()
+        .map(|name| format!("{} before", name))
+        .chain(order.iter().rev().map(|name| format!("{} after", name)))
+        .collect();
+    assert_eq!(*log.lock(), expected);
+}
+
+#[tokio::test]
+async fn test_enforces_body_limit() {
+    let mut router = Router::new();
+    router.post("/upload", |_req: HttpRequest| async {
+        Ok(HttpResponse::ok())
+    });
+    let app = app(router).with_body_limit(BodyLimitConfig::new().default_limit(4));
+
+    let response = app
+        .test(Request::post("/upload").body("12345").unwrap())
+        .await
+        .unwrap();
+    assert_eq!(response.status(), 413);
+}
+
+#[tokio::test]
+async fn test_streaming_body_is_readable() {
+    let mut router = Router::new();
+    router.get("/stream", |_req: HttpRequest| async {
+        Ok(HttpResponse::ok().stream(|writer| async move {
+            for i in 0..3 {
+                writer.write(format!("chunk {};", i)).await?;
+            }
+            Ok(())
+        }))
+    });
+    let app = app(router);
+
+    let response = app
+        .test(Request::get("/stream").body("").unwrap())
+        .await
+        .unwrap();
+    assert_eq!(body_string(response).await, "chunk 0;chunk 1;chunk 2;");
+}
+
+#[tokio::test]
+async fn test_timeout_fails_slow_handler() {
+    let mut router = Router::new();
+    router.get("/slow", |_req: HttpRequest| async {
+        tokio::time::sleep(Duration::from_secs(10)).await;
+        Ok(HttpResponse::ok())
+    });
+    router.get("/fast", |_req: HttpRequest| async {
+        Ok(HttpResponse::ok())
+    });
+    let app = app(router);
+
+    let result = app
+        .test_timeout(
+            Request::get("/slow").body("").unwrap(),
+            Duration::from_millis(50),
+        )
+        .await;
+    assert!(matches!(result, Err(Error::RequestTimeout(_))));
+
+    let response = app
+        .test_timeout(
+            Request::get("/fast").body("").unwrap(),
+            Duration::from_secs(5),
+        )
+        .await
+        .unwrap();
+    assert_eq!(response.status(), 200);
+}
+
+#[tokio::test]
+async fn test_query_string_reaches_handler() {
+    #[derive(serde::Deserialize)]
+    struct Filter {
+        #[serde(default)]
+        tag: Vec<String>,
+    }
+
+    let mut router = Router::new();
+    router.get("/items", |req: HttpRequest| async move {
+        let filter: Filter = req.bind_query()?;
+        let page = req.query("page").cloned().unwrap_or_default();
+        Ok(HttpResponse::ok().with_body(format!("{} {}", page, filter.tag.join("+")).into_bytes()))
+    });
+    let app = app(router);
+
+    let response = app
+        .test(Request::get("/items?page=2&tag=a&tag=b").body("").unwrap())
+        .await
+        .unwrap();
+    assert_eq!(body_string(response).await, "2 a+b");
+
+    let response = app
+        .test(Request::get("/items?tag=a&page=x").body("").unwrap())
+        .await
+        .unwrap();
+    assert_eq!(body_string(response).await, "x a");
+}
+
+#[tokio::test]
+async fn test_multipart_uploads_are_cleaned_up_after_request() {
+    let mut router = Router::new();
+    router.post("/upload", |req: HttpRequest| async move {
+        let form = req.parse_multipart_form(4).await?;
+        let files = form.files("files");
+        let paths: Vec<String> = files
+            .iter()
+            .map(|file| file.temp_path().unwrap().display().to_string())
+            .collect();
+        Ok(HttpResponse::ok().with_body(paths.join("\n").into_bytes()))
+    });
+    let app = app(router);
+
+    let body = "--b\r\n\
+                Content-Disposition: form-data; name=\"files\"; filename=\"a.txt\"\r\n\r\n\
+                first file\r\n\
+                --b\r\n\
+                Content-Disposition: form-data; name=\"files\"; filename=\"b.txt\"\r\n\r\n\
+                second file\r\n\
+                --b--\r\n";
+    let request = Request::post("/upload")
+        .header("Content-Type", "multipart/form-data; boundary=b")
+        .body(body)
+        .unwrap();
+    let response = app.test(request).await.unwrap();
+    assert_eq!(response.status(), 200);
+
+    let body = body_string(response).await;
+    let paths: Vec<&str> = body.lines().collect();
+    assert_eq!(paths.len(), 2);
+    assert!(
+        paths
+            .iter()
+            .all(|path| !std::path::Path::new(path).exists())
+    );
+}
+
+/// Stand-in for an application error type that wraps an `HttpError`
+#[derive(Debug)]
+struct RepoError(HttpError);
+
+impl std::fmt::Display for RepoError {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        write!(f, "repository error: {}", self.0)
+    }
+}
+
+impl std::error::Error for RepoError {
+    fn source(&self) -> Option<&(dyn std::error::Error + 'static)> {
+        Some(&self.0)
+    }
+}
+
+#[tokio::test]
+async fn test_error_handler_receives_request_and_error() {
+    let mut router = Router::new();
+    router.get("/items/:id", |_req: HttpRequest| async {
+        Err(Error::other(RepoError(HttpError::new(404, "no such item"))))
+    });
+    router.error_handler(|req: HttpRequest, err: Error| async move {
+        let status = err.http_error().map_or(500, HttpError::status);
+        HttpResponse::new(status).with_body(
+            format!(
+                "{} {} {}: {}",
+                req.method,
+                req.path,
+                req.header("x-trace").unwrap_or_default(),
+                err
+            )
+            .into_bytes(),
+        )
+    });
+    let app = app(router);
+
+    let request = Request::get("/items/9?full=1")
+        .header("X-Trace", "abc")
+        .body("")
+        .unwrap();
+    let response = app.test(request).await.unwrap();
+    assert_eq!(response.status(), 404);
+    assert_eq!(
+        body_string(response).await,
+        "GET /items/9 abc: repository error: no such item"
+    );
+
+    // Unmatched routes are errors too
+    let response = app
+        .test(Request::get("/missing").body("").unwrap())
+        .await
+        .unwrap();
+    assert!(
+        body_string(response)
+            .await
+            .starts_with("GET /missing : Route not found")
+    );
+}
+
+#[tokio::test]
+async fn test_default_error_handler() {
+    let mut router = Router::new();
+    router.get("/wrapped", |_req: HttpRequest| async {
+        Err(Error::other(RepoError(HttpError::new(
+            409,
+            "version conflict",
+        ))))
+    });
+    router.get("/internal", |_req: HttpRequest| async {
+        Err(Error::other(std::io::Error::other("db password rejected")))
+    });
+    let app = app(router);
+
+    let response = app
+        .test(Request::get("/wrapped").body("").unwrap())
+        .await
+        .unwrap();
+    assert_eq!(response.status(), 409);
+    let body: serde_json::Value = serde_json::from_str(&body_string(response).await).unwrap();
+    assert_eq!(body["error"], "version conflict");
+
+    let response = app
+        .test(Request::get("/internal").body("").unwrap())
+        .await
+        .unwrap();
+    assert_eq!(response.status(), 500);
+    let body = body_string(response).await;
+    assert!(!body.contains("password"), "{}", body);
+}
+
+#[tokio::test]
+async fn test_client_ip_from_trusted_proxy() {
+    let mut router = Router::new();
+    router.get("/ip", |req: HttpRequest| async move {
+        let ip = req.client_ip().map(|ip| ip.to_string()).unwrap_or_default();
+        Ok(HttpResponse::ok().with_body(ip.into_bytes()))
+    });
+    let app = app(router).with_trusted_proxies(TrustedProxies::new().trust("10.0.0.0/8").unwrap());
+
+    let from = |peer: &str| {
+        Request::get("/ip")
+            .header("X-Forwarded-For", "6.6.6.6, 203.0.113.7, 10.0.0.2")
+            .extension(RemoteAddr(peer.parse().unwrap()))
+            .body("")
+            .unwrap()
+    };
+
+    let response = app.test(from("10.0.0.1:40000")).await.unwrap();
+    assert_eq!(body_string(response).await, "203.0.113.7");
+
+    // A client connecting directly can't spoof its address
+    let response = app.test(from("198.51.100.9:40000")).await.unwrap();
+    assert_eq!(body_string(response).await, "198.51.100.9");
+}
+
+#[tokio::test]
+async fn test_repeated_headers_are_joined() {
+    #[derive(serde::Deserialize)]
+    struct Tags {
+        #[serde(rename = "X-Tag")]
+        tags: Vec<String>,
+    }
+
+    let mut router = Router::new();
+    router.get("/tags", |req: HttpRequest| async move {
+        let tags: Tags = req.bind_headers()?;
+        let cookie = req.header("cookie").unwrap_or_default();
+        Ok(HttpResponse::ok()
+            .with_body(format!("{} | {}", tags.tags.join(" "), cookie).into_bytes()))
+    });
+
+    let request = Request::get("/tags")
+        .header("X-Tag", "a, b")
+        .header("X-Tag", "c")
+        .header("Cookie", "session=1")
+        .header("Cookie", "theme=dark")
+        .body("")
+        .unwrap();
+    let response = app(router).test(request).await.unwrap();
+    assert_eq!(body_string(response).await, "a b c | session=1; theme=dark");
+}
+
+#[tokio::test]
+async fn test_error_and_panic_hooks() {
+    let log = Arc::new(parking_lot::Mutex::new(Vec::new()));
+
+    let mut router = Router::new();
+    router.use_middleware(RequestIdMiddleware::new());
+    router.use_middleware(RecoverMiddleware::new().with_stack_trace(false));
+    router.get("/missing", |_req: HttpRequest| async {
+        Err::<HttpResponse, _>(Error::NotFound("no such order".into()))
+    });
+    router.get("/boom", |_req: HttpRequest| async {
+        panic!("exploded");
+        #[allow(unreachable_code)]
+        Ok(HttpResponse::ok())
+    });
+
+    let errors = log.clone();
+    let panics = log.clone();
+    let app = app(router)
+        .on_error(move |info: &RequestInfo, err: &Error| {
+            errors.lock().push(format!(
+                "error {} {} {} {}",
+                info.method,
+                info.path,
+                info.request_id.as_deref().unwrap_or("-"),
+                err.status_code()
+            ));
+        })
+        .on_error(|_: &RequestInfo, _: &Error| panic!("broken hook"))
+        .on_panic(move |info: &RequestInfo, panic: &RecoveredPanic| {
+            panics.lock().push(format!(
+                "panic {} {} {} {}",
+                info.method,
+                info.path,
+                info.request_id.as_deref().unwrap_or("-"),
+                panic.message
+            ));
+        });
+
+    let get = |path: &str| {
+        Request::get(path)
+            .header("X-Request-ID", "req-7")
+            .body("")
+            .unwrap()
+    };
+    let response = app.test(get("/missing")).await.unwrap();
+    assert_eq!(response.status(), 404);
+    let response = app.test(get("/boom")).await.unwrap();
+    assert_eq!(response.status(), 500);
+
+    // Successful requests and the 500 replacing a panic don't reach the
+    // error hooks, and the panicking hook doesn't stop the others
+    assert_eq!(
+        *log.lock(),
+        [
+            "error GET /missing req-7 404",
+            "panic GET /boom req-7 exploded",
+        ]
+    );
+}
+
+#[tokio::test]
+async fn test_panicking_hooks_are_contained() {
+    let mut router = Router::new();
+    router.use_middleware(RecoverMiddleware::new().with_stack_trace(false));
+    router.get("/boom", |_req: HttpRequest| async {
+        panic!("exploded");
+        #[allow(unreachable_code)]
+        Ok(HttpResponse::ok())
+    });
+    router.get("/fail", |_req: HttpRequest| async {
+        Err::<HttpResponse, _>(Error::Internal("db down".into()))
+    });
+
+    let app = app(router)
+        .on_error(|_: &RequestInfo, _: &Error| panic!("error hook failed"))
+        .on_panic(|_: &RequestInfo, _: &RecoveredPanic| panic!("panic hook failed"));
+
+    for path in ["/boom", "/fail", "/boom"] {
+        let response = app
+            .test(Request::get(path).body("").unwrap())
+            .await
+            .unwrap();
+        assert_eq!(response.status(), 500);
+    }
+}
+
+#[tokio::test]
+async fn test_head_answered_by_get_route() {
+    let mut router = Router::new();
+    router.get("/users/:id", |req: HttpRequest| async move {
+        let id = req.param("id").cloned().unwrap_or_default();
+        Ok(HttpResponse::json(&serde_json::json!({ "id": id }))?
+            .with_header("Cache-Control".to_string(), "max-age=60".to_string()))
+    });
+    router.add_route(
+        Route::new(HttpMethod::GET, "/export", |_req: HttpRequest| async {
+            Ok(HttpResponse::ok())
+        })
+        .without_auto_head(),
+    );
+    let app = app(router);
+
+    let get = app
+        .test(Request::get("/users/42").body("").unwrap())
+        .await
+        .unwrap();
+    let head = app
+        .test(Request::head("/users/42").body("").unwrap())
+        .await
+        .unwrap();
+    assert_eq!(head.status(), 200);
+    for name in ["content-type", "cache-control"] {
+        assert_eq!(head.headers()[name], get.headers()[name], "{}", name);
+    }
+    let get_body = body_string(get).await;
+    assert_eq!(head.headers()["content-length"], get_body.len().to_string());
+    assert_eq!(body_string(head).await, "");
+
+    let response = app
+        .test(Request::head("/export").body("").unwrap())
+        .await
+        .unwrap();
+    assert_eq!(response.status(), 405);
+    assert_eq!(response.headers()["allow"], "GET");
+}
+
+#[tokio::test]
+async fn test_download() {
+    let dir = std::env::temp_dir().join(format!("armature-downloads-{}", uuid::Uuid::new_v4()));
+    std::fs::create_dir_all(&dir).unwrap();
+    std::fs::write(dir.join("q1.pdf"), b"%PDF report").unwrap();
+
+    let mut router = Router::new();
+    let root = dir.clone();
+    router.get("/reports/:file", move |req: HttpRequest| {
+        let path = root.join(req.param("file").cloned().unwrap_or_default());
+        async move { req.download(path, "résumé.pdf").await }
+    });
+    let app = app(router);
+
+    let response = app
+        .test(Request::get("/reports/q1.pdf").body("").unwrap())
+        .await
+        .unwrap();
+    assert_eq!(response.status(), 200);
+    assert_eq!(response.headers()["content-type"], "application/pdf");
+    assert_eq!(response.headers()["content-length"], "11");
+    assert_eq!(
+        response.headers()["content-disposition"],
+        "attachment; filename=\"r_sum_.pdf\"; filename*=UTF-8''r%C3%A9sum%C3%A9.pdf"
+    );
+    assert_eq!(body_string(response).await, "%PDF report");
+
+    let response = app
+        .test(Request::get("/reports/q2.pdf").body("").unwrap())
+        .await
+        .unwrap();
+    assert_eq!(response.status(), 404);
+    let body: serde_json::Value = serde_json::from_str(&body_string(response).await).unwrap();
+    assert_eq!(body["status"], 404);
+
+    let response = app
+        .test(Request::get("/reports/..").body("").unwrap())
+        .await
+        .unwrap();
+    assert_eq!(response.status(), 403);
+
+    std::fs::remove_dir_all(&dir).unwrap();
+}
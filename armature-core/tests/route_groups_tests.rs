@@ -1,7 +1,7 @@
 //! Integration tests for Route Groups
 
 use armature_core::*;
-use std::sync::Arc;
+use std::sync::{Arc, Mutex};
 
 #[test]
 fn test_route_group_prefix() {
@@ -185,7 +185,134 @@ fn test_route_group_clone() {
     assert_eq!(group1.get_middleware().len(), group2.get_middleware().len());
 }
 
+#[tokio::test]
+async fn test_route_group_dispatch_through_router() {
+    let log = Arc::new(Mutex::new(Vec::new()));
+
+    let mut api = RouteGroup::new()
+        .prefix("/api/v1")
+        .middleware(recorder("auth", &log));
+    api.get("/users/:id", |req: HttpRequest| async move {
+        let id = req.param("id").cloned().unwrap_or_default();
+        Ok(HttpResponse::ok().with_body(id.into_bytes()))
+    });
+
+    let mut router = Router::new();
+    router.add_group(api);
+
+    let response = router
+        .route(HttpRequest::new(
+            "GET".to_string(),
+            "/api/v1/users/42".to_string(),
+        ))
+        .await
+        .unwrap();
+    assert_eq!(response.body, b"42");
+    assert_eq!(*log.lock().unwrap(), vec!["auth"]);
+
+    let missing = router
+        .route(HttpRequest::new("GET".to_string(), "/users/42".to_string()))
+        .await;
+    assert!(matches!(missing, Err(Error::RouteNotFound(_))));
+}
+
+#[tokio::test]
+async fn test_nested_group_middleware_order() {
+    let log = Arc::new(Mutex::new(Vec::new()));
+
+    let api = RouteGroup::new()
+        .prefix("/api")
+        .middleware(recorder("api", &log));
+    let mut admin = api.group("/admin").middleware(recorder("admin", &log));
+
+    let handler_log = log.clone();
+    admin.get("/stats", move |_req: HttpRequest| {
+        let log = handler_log.clone();
+        async move {
+            log.lock().unwrap().push("handler");
+            Ok(HttpResponse::ok())
+        }
+    });
+
+    let mut router = Router::new();
+    router.add_group(admin);
+
+    router
+        .route(HttpRequest::new(
+            "GET".to_string(),
+            "/api/admin/stats".to_string(),
+        ))
+        .await
+        .unwrap();
+
+    assert_eq!(*log.lock().unwrap(), vec!["api", "admin", "handler"]);
+}
+
+#[tokio::test]
+async fn test_parent_middleware_not_retroactive() {
+    let log = Arc::new(Mutex::new(Vec::new()));
+
+    let mut api = RouteGroup::new()
+        .prefix("/api")
+        .middleware(recorder("first", &log));
+    api.get("/early", ok_handler);
+
+    let mut child = api.group("/v1");
+    child.get("/users", ok_handler);
+
+    let mut api = api.middleware(recorder("late", &log));
+    api.get("/late", ok_handler);
+    api.add_group(child);
+
+    let mut router = Router::new();
+    router.add_group(api);
+
+    for path in ["/api/early", "/api/v1/users"] {
+        log.lock().unwrap().clear();
+        router
+            .route(HttpRequest::new("GET".to_string(), path.to_string()))
+            .await
+            .unwrap();
+        assert_eq!(*log.lock().unwrap(), vec!["first"], "path {}", path);
+    }
+
+    log.lock().unwrap().clear();
+    router
+        .route(HttpRequest::new("GET".to_string(), "/api/late".to_string()))
+        .await
+        .unwrap();
+    assert_eq!(*log.lock().unwrap(), vec!["first", "late"]);
+}
+
 // Test helpers
+async fn ok_handler(_req: HttpRequest) -> Result<HttpResponse, Error> {
+    Ok(HttpResponse::ok())
+}
+
+struct RecordingMiddleware {
+    name: &'static str,
+    log: Arc<Mutex<Vec<&'static str>>>,
+}
+
+fn recorder(name: &'static str, log: &Arc<Mutex<Vec<&'static str>>>) -> Arc<dyn Middleware> {
+    Arc::new(RecordingMiddleware {
+        name,
+        log: log.clone(),
+    })
+}
+
+#[async_trait::async_trait]
+impl Middleware for RecordingMiddleware {
+    async fn handle(
+        &self,
+        request: HttpRequest,
+        next: middleware::Next,
+    ) -> Result<HttpResponse, Error> {
+        self.log.lock().unwrap().push(self.name);
+        next(request).await
+    }
+}
+
 struct TestMiddleware;
 
 #[async_trait::async_trait]
@@ -67,6 +67,42 @@ pub fn create_metrics_handler() -> BoxedHandler {
     }))
 }
 
+/// Create a handler serving the metrics of `registry`
+///
+/// Use it for a registry passed to
+/// [`MetricsOptions::registry`](crate::MetricsOptions::registry).
+///
+/// # Examples
+///
+/// ```
+/// use armature_core::*;
+/// use armature_metrics::*;
+/// use prometheus::Registry;
+///
+/// let registry = Registry::new();
+///
+/// let mut router = Router::new();
+/// router.add_route(Route {
+///     method: HttpMethod::GET,
+///     path: "/metrics".to_string(),
+///     handler: metrics_handler_for(registry),
+///     constraints: None,
+/// });
+/// ```
+pub fn metrics_handler_for(registry: prometheus::Registry) -> BoxedHandler {
+    from_legacy_handler(std::sync::Arc::new(move |_req: HttpRequest| {
+        let metrics = crate::export_metrics_from_registry(&registry);
+        Box::pin(async move {
+            Ok(HttpResponse::ok()
+                .with_header(
+                    "Content-Type".to_string(),
+                    "text/plain; version=0.0.4".to_string(),
+                )
+                .with_body(metrics.into_bytes()))
+        })
+    }))
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -1,10 +1,14 @@
 //! Request metrics middleware
 //!
 //! Automatically collect HTTP request metrics.
+//!
+//! [`MetricsMiddleware`] labels requests by route pattern and registers its
+//! metrics with a registry of your choice. [`RequestMetricsMiddleware`]
+//! labels by raw path and always uses the default registry.
 
 use armature_core::{Error, HttpRequest, HttpResponse, Middleware};
 use once_cell::sync::Lazy;
-use prometheus::{CounterVec, GaugeVec, HistogramVec};
+use prometheus::{CounterVec, GaugeVec, HistogramOpts, HistogramVec, Opts, Registry};
 use std::time::Instant;
 
 /// HTTP request metrics
@@ -137,7 +141,7 @@ impl Middleware for RequestMetricsMiddleware {
         next: armature_core::middleware::Next,
     ) -> Result<HttpResponse, Error> {
         let start = Instant::now();
-        let method = request.method.clone();
+        let method = method_label(&request.method).to_string();
         let path = self.sanitize_path(&request.path);
         let request_size = request.body.len() as f64;
 
@@ -200,6 +204,218 @@ impl Middleware for RequestMetricsMiddleware {
     }
 }
 
+/// Label used for requests that matched no route
+pub const UNMATCHED_ROUTE: &str = "unmatched";
+
+/// Options for [`MetricsMiddleware`]
+///
+/// # Examples
+///
+/// ```
+/// use armature_metrics::*;
+/// use prometheus::Registry;
+///
+/// let registry = Registry::new();
+/// let options = MetricsOptions::new()
+///     .registry(registry.clone())
+///     .namespace("shop")
+///     .buckets(vec![0.01, 0.1, 1.0]);
+/// let middleware = MetricsMiddleware::new(options).unwrap();
+/// ```
+#[derive(Clone)]
+pub struct MetricsOptions {
+    registry: Registry,
+    namespace: Option<String>,
+    buckets: Vec<f64>,
+}
+
+impl MetricsOptions {
+    /// Options using the [default registry](crate::default_registry)
+    pub fn new() -> Self {
+        Self {
+            registry: crate::default_registry().clone(),
+            namespace: None,
+            buckets: vec![
+                0.001, 0.005, 0.01, 0.025, 0.05, 0.075, 0.1, 0.25, 0.5, 0.75, 1.0, 2.5, 5.0, 7.5,
+                10.0,
+            ],
+        }
+    }
+
+    /// Register the metrics with `registry`, e.g. the one holding your
+    /// application's own metrics
+    pub fn registry(mut self, registry: Registry) -> Self {
+        self.registry = registry;
+        self
+    }
+
+    /// Prefix metric names with `namespace_`
+    pub fn namespace(mut self, namespace: impl Into<String>) -> Self {
+        self.namespace = Some(namespace.into());
+        self
+    }
+
+    /// Latency histogram buckets, in seconds
+    pub fn buckets(mut self, buckets: Vec<f64>) -> Self {
+        self.buckets = buckets;
+        self
+    }
+}
+
+impl Default for MetricsOptions {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+/// Prometheus request metrics labeled by route pattern
+///
+/// Records:
+/// - `http_requests_total` - requests by `method`, `route` and `status`
+/// - `http_request_duration_seconds` - latency histogram by `method`,
+///   `route` and `status`
+/// - `http_requests_in_flight` - requests being handled, by `method` and
+///   `route`
+///
+/// `route` is the pattern the request matched, such as `/users/:id`, so
+/// the number of series doesn't grow with the number of distinct URLs.
+/// Requests that match no route are labeled [`UNMATCHED_ROUTE`], and
+/// methods other than the standard ones (`GET`, `POST`, ...) `OTHER`.
+///
+/// Add it with [`Router::use_middleware`](armature_core::Router::use_middleware)
+/// and serve the registry with [`metrics_handler_for`](crate::metrics_handler_for).
+///
+/// # Examples
+///
+/// ```
+/// use armature_core::*;
+/// use armature_metrics::*;
+/// use prometheus::Registry;
+///
+/// let registry = Registry::new();
+/// let metrics = MetricsMiddleware::new(MetricsOptions::new().registry(registry.clone())).unwrap();
+///
+/// let mut router = Router::new();
+/// router.use_middleware(metrics);
+/// router.add_route(Route {
+///     method: HttpMethod::GET,
+///     path: "/metrics".to_string(),
+///     handler: metrics_handler_for(registry),
+///     constraints: None,
+/// });
+/// ```
+pub struct MetricsMiddleware {
+    requests: CounterVec,
+    duration: HistogramVec,
+    in_flight: GaugeVec,
+}
+
+impl MetricsMiddleware {
+    /// Create the metrics and register them
+    ///
+    /// Fails if the registry already has metrics with these names, e.g.
+    /// from a second `MetricsMiddleware` without its own namespace.
+    pub fn new(options: MetricsOptions) -> Result<Self, prometheus::Error> {
+        let opts = |name: &str, help: &str| {
+            let opts = Opts::new(name, help);
+            match &options.namespace {
+                Some(namespace) => opts.namespace(namespace.clone()),
+                None => opts,
+            }
+        };
+
+        let requests = CounterVec::new(
+            opts("http_requests_total", "Total number of HTTP requests"),
+            &["method", "route", "status"],
+        )?;
+        let duration = HistogramVec::new(
+            HistogramOpts::from(opts(
+                "http_request_duration_seconds",
+                "HTTP request duration in seconds",
+            ))
+            .buckets(options.buckets.clone()),
+            &["method", "route", "status"],
+        )?;
+        let in_flight = GaugeVec::new(
+            opts(
+                "http_requests_in_flight",
+                "Number of HTTP requests currently being processed",
+            ),
+            &["method", "route"],
+        )?;
+
+        options.registry.register(Box::new(requests.clone()))?;
+        options.registry.register(Box::new(duration.clone()))?;
+        options.registry.register(Box::new(in_flight.clone()))?;
+
+        Ok(Self {
+            requests,
+            duration,
+            in_flight,
+        })
+    }
+}
+
+/// Decrements the in-flight gauge when the request finishes, even if the
+/// handler panics or the request is cancelled
+struct InFlight(prometheus::Gauge);
+
+impl Drop for InFlight {
+    fn drop(&mut self) {
+        self.0.dec();
+    }
+}
+
+#[async_trait::async_trait]
+impl Middleware for MetricsMiddleware {
+    async fn handle(
+        &self,
+        request: HttpRequest,
+        next: armature_core::middleware::Next,
+    ) -> Result<HttpResponse, Error> {
+        let start = Instant::now();
+        let method = method_label(&request.method).to_string();
+        let route = request
+            .matched_path()
+            .unwrap_or(UNMATCHED_ROUTE)
+            .to_string();
+
+        let gauge = self
+            .in_flight
+            .with_label_values(&[method.as_str(), route.as_str()]);
+        gauge.inc();
+        let in_flight = InFlight(gauge);
+
+        let result = next(request).await;
+        drop(in_flight);
+
+        let status = match &result {
+            Ok(response) => response.status.to_string(),
+            Err(err) => err.status_code().to_string(),
+        };
+        let labels = [method.as_str(), route.as_str(), status.as_str()];
+        self.requests.with_label_values(&labels).inc();
+        self.duration
+            .with_label_values(&labels)
+            .observe(start.elapsed().as_secs_f64());
+
+        result
+    }
+}
+
+/// Label for a request method
+///
+/// Clients can send any method, so methods outside the standard set are
+/// labeled `OTHER` rather than adding series of their own.
+fn method_label(method: &str) -> &str {
+    match method {
+        "GET" | "HEAD" | "POST" | "PUT" | "DELETE" | "CONNECT" | "OPTIONS" | "TRACE" | "PATCH" => {
+            method
+        }
+        _ => "OTHER",
+    }
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -231,4 +447,136 @@ mod tests {
         let middleware = RequestMetricsMiddleware::without_path();
         assert_eq!(middleware.sanitize_path("/api/users"), "/");
     }
+
+    fn router(registry: &Registry) -> armature_core::Router {
+        let metrics =
+            MetricsMiddleware::new(MetricsOptions::new().registry(registry.clone())).unwrap();
+        let mut router = armature_core::Router::new();
+        router.use_middleware(metrics);
+        router.get("/users/:id", |_req: HttpRequest| async {
+            Ok(HttpResponse::ok())
+        });
+        router.get("/boom", |_req: HttpRequest| async {
+            Err(Error::Internal("boom".to_string()))
+        });
+        router
+    }
+
+    fn get(path: &str) -> HttpRequest {
+        HttpRequest::new("GET".to_string(), path.to_string())
+    }
+
+    #[tokio::test]
+    async fn test_metrics_middleware_labels_by_route_pattern() {
+        let registry = Registry::new();
+        let router = router(&registry);
+
+        router.route(get("/users/1")).await.unwrap();
+        router.route(get("/users/2?page=3")).await.unwrap();
+        let _ = router.route(get("/boom")).await;
+
+        let text = crate::export_metrics_from_registry(&registry);
+        assert!(
+            text.contains(r#"http_requests_total{method="GET",route="/users/:id",status="200"} 2"#),
+            "{}",
+            text
+        );
+        assert!(
+            text.contains(r#"http_requests_total{method="GET",route="/boom",status="500"} 1"#),
+            "{}",
+            text
+        );
+        assert!(text.contains(
+            r#"http_request_duration_seconds_count{method="GET",route="/users/:id",status="200"} 2"#
+        ));
+        assert!(!text.contains("/users/1"), "{}", text);
+    }
+
+    #[tokio::test]
+    async fn test_metrics_middleware_in_flight_returns_to_zero() {
+        let registry = Registry::new();
+        let metrics =
+            MetricsMiddleware::new(MetricsOptions::new().registry(registry.clone())).unwrap();
+        let in_flight = metrics.in_flight.clone();
+
+        let mut req = get("/users/1");
+        req.extensions
+            .insert(armature_core::MatchedPath("/users/:id".to_string()));
+        let gauge = in_flight.with_label_values(&["GET", "/users/:id"]);
+        let probe = gauge.clone();
+        let response = metrics
+            .handle(
+                req,
+                Box::new(move |_req| {
+                    Box::pin(async move {
+                        // The request is counted while the handler runs
+                        assert_eq!(probe.get(), 1.0);
+                        Ok(HttpResponse::ok())
+                    })
+                }),
+            )
+            .await
+            .unwrap();
+        assert_eq!(response.status, 200);
+        assert_eq!(gauge.get(), 0.0);
+
+        // Also after errors and unmatched requests
+        let registry = Registry::new();
+        let router = router(&registry);
+        router.route(get("/users/1")).await.unwrap();
+        let _ = router.route(get("/boom")).await;
+        let _ = router.route(get("/missing")).await;
+
+        let text = crate::export_metrics_from_registry(&registry);
+        for route in ["/users/:id", "/boom", UNMATCHED_ROUTE] {
+            let line = format!(
+                r#"http_requests_in_flight{{method="GET",route="{}"}} 0"#,
+                route
+            );
+            assert!(text.contains(&line), "{}", text);
+        }
+    }
+
+    #[tokio::test]
+    async fn test_metrics_middleware_groups_unknown_methods() {
+        let registry = Registry::new();
+        let router = router(&registry);
+
+        for method in ["BREW", "PROPFIND", "get"] {
+            let req = HttpRequest::new(method.to_string(), "/missing".to_string());
+            let _ = router.route(req).await;
+        }
+        let _ = router.route(get("/missing")).await;
+
+        let text = crate::export_metrics_from_registry(&registry);
+        let count = |method: &str| {
+            format!(
+                r#"http_requests_total{{method="{}",route="{}",status="404"}}"#,
+                method, UNMATCHED_ROUTE
+            )
+        };
+        assert!(text.contains(&format!("{} 3", count("OTHER"))), "{}", text);
+        assert!(text.contains(&format!("{} 1", count("GET"))), "{}", text);
+        assert!(
+            !text.contains("BREW") && !text.contains("PROPFIND"),
+            "{}",
+            text
+        );
+    }
+
+    #[test]
+    fn test_metrics_middleware_namespace_and_duplicates() {
+        let registry = Registry::new();
+        MetricsMiddleware::new(MetricsOptions::new().registry(registry.clone())).unwrap();
+        assert!(MetricsMiddleware::new(MetricsOptions::new().registry(registry.clone())).is_err());
+
+        MetricsMiddleware::new(
+            MetricsOptions::new()
+                .registry(registry.clone())
+                .namespace("admin"),
+        )
+        .unwrap();
+        let text = crate::export_metrics_from_registry(&registry);
+        assert!(text.contains("# TYPE admin_http_requests_total counter"));
+    }
 }
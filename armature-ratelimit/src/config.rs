@@ -65,6 +65,7 @@ pub struct RateLimiterBuilder {
     skip_on_error: bool,
     error_message: Option<String>,
     bypass_keys: Vec<String>,
+    store: Option<Arc<dyn RateLimitStore>>,
     #[cfg(feature = "redis")]
     redis_url: Option<String>,
 }
@@ -80,6 +81,7 @@ impl RateLimiterBuilder {
             skip_on_error: true,
             error_message: None,
             bypass_keys: Vec::new(),
+            store: None,
             #[cfg(feature = "redis")]
             redis_url: None,
         }
@@ -132,6 +134,16 @@ impl RateLimiterBuilder {
         self
     }
 
+    /// Use a custom store
+    ///
+    /// Any [`RateLimitStore`] implementation can be plugged in, for example
+    /// one backed by a shared database in a multi-instance deployment.
+    pub fn store(mut self, store: Arc<dyn RateLimitStore>) -> Self {
+        self.store_type = StoreType::Custom;
+        self.store = Some(store);
+        self
+    }
+
     /// Set the key prefix for storage
     pub fn key_prefix(mut self, prefix: impl Into<String>) -> Self {
         self.key_prefix = prefix.into();
@@ -205,6 +217,9 @@ impl RateLimiterBuilder {
                     "Redis feature is not enabled. Add `redis` feature to use Redis store.",
                 ));
             }
+            StoreType::Custom => self
+                .store
+                .ok_or_else(|| RateLimitError::config("Custom store must be specified"))?,
         };
 
         Ok(RateLimiter::new(store, algorithm, config))
@@ -220,6 +235,82 @@ impl Default for RateLimiterBuilder {
 #[cfg(test)]
 mod tests {
     use super::*;
+    use async_trait::async_trait;
+    use std::sync::atomic::{AtomicUsize, Ordering};
+
+    /// Store that counts calls and delegates to a `MemoryStore`
+    #[derive(Default)]
+    struct CountingStore {
+        inner: MemoryStore,
+        calls: AtomicUsize,
+    }
+
+    #[async_trait]
+    impl RateLimitStore for CountingStore {
+        async fn token_bucket_check(
+            &self,
+            key: &str,
+            capacity: u64,
+            refill_rate: f64,
+        ) -> RateLimitResult<(bool, u64)> {
+            self.calls.fetch_add(1, Ordering::SeqCst);
+            self.inner
+                .token_bucket_check(key, capacity, refill_rate)
+                .await
+        }
+
+        async fn sliding_window_check(
+            &self,
+            key: &str,
+            max_requests: u64,
+            window: Duration,
+        ) -> RateLimitResult<(bool, u64)> {
+            self.calls.fetch_add(1, Ordering::SeqCst);
+            self.inner
+                .sliding_window_check(key, max_requests, window)
+                .await
+        }
+
+        async fn fixed_window_check(
+            &self,
+            key: &str,
+            max_requests: u64,
+            window: Duration,
+        ) -> RateLimitResult<(bool, u64)> {
+            self.calls.fetch_add(1, Ordering::SeqCst);
+            self.inner
+                .fixed_window_check(key, max_requests, window)
+                .await
+        }
+
+        async fn reset(&self, key: &str) -> RateLimitResult<()> {
+            self.inner.reset(key).await
+        }
+
+        async fn remaining(&self, key: &str) -> RateLimitResult<u64> {
+            self.inner.remaining(key).await
+        }
+
+        fn store_type(&self) -> &'static str {
+            "counting"
+        }
+    }
+
+    #[tokio::test]
+    async fn test_builder_custom_store() {
+        let store = Arc::new(CountingStore::default());
+        let limiter = RateLimiterBuilder::new()
+            .fixed_window(1, Duration::from_secs(60))
+            .store(store.clone())
+            .build()
+            .await
+            .unwrap();
+
+        assert!(matches!(limiter.config().store_type, StoreType::Custom));
+        assert!(limiter.check("k").await.unwrap().allowed);
+        assert!(!limiter.check("k").await.unwrap().allowed);
+        assert_eq!(store.calls.load(Ordering::SeqCst), 2);
+    }
 
     #[test]
     fn test_default_config() {
@@ -94,7 +94,7 @@ pub use algorithms::{Algorithm, RateLimitAlgorithm};
 pub use config::{RateLimitConfig, RateLimiterBuilder};
 pub use error::{RateLimitError, RateLimitResult};
 pub use extractor::{KeyExtractor, KeyExtractorFn};
-pub use middleware::RateLimitMiddleware;
+pub use middleware::{RateLimitMiddleware, RequestKeyFn};
 pub use stores::{MemoryStore, RateLimitStore, StoreType};
 
 #[cfg(feature = "redis")]
@@ -273,12 +273,14 @@ impl RateLimiter {
             .fixed_window_check(key, max_requests, window)
             .await?;
 
-        let now = std::time::SystemTime::now()
+        // Work in milliseconds so sub-second windows don't divide by zero
+        let now_ms = std::time::SystemTime::now()
             .duration_since(std::time::UNIX_EPOCH)
             .unwrap()
-            .as_secs();
-        let window_secs = window.as_secs();
-        let reset_at = ((now / window_secs) + 1) * window_secs;
+            .as_millis() as u64;
+        let window_ms = (window.as_millis() as u64).max(1);
+        let reset_ms = ((now_ms / window_ms) + 1) * window_ms;
+        let reset_at = reset_ms.div_ceil(1000);
 
         if result.0 {
             debug!(key = %key, remaining = result.1, "Fixed window: request allowed");
@@ -288,7 +290,7 @@ impl RateLimiter {
                 reset_at,
             ))
         } else {
-            let retry_after = Duration::from_secs(reset_at - now);
+            let retry_after = Duration::from_millis(reset_ms - now_ms);
             warn!(key = %key, retry_after = ?retry_after, "Fixed window: request denied");
             Ok(RateLimitCheckResult::denied(
                 max_requests,
@@ -372,6 +374,71 @@ mod tests {
         assert!(!result.allowed, "4th request should be denied");
     }
 
+    #[tokio::test]
+    async fn test_token_bucket_burst_then_refill() {
+        let limiter = RateLimiter::builder()
+            .token_bucket(3, 20.0)
+            .build()
+            .await
+            .unwrap();
+
+        // The whole capacity can be used at once
+        for remaining in [2, 1, 0] {
+            let result = limiter.check("burst").await.unwrap();
+            assert!(result.allowed);
+            assert_eq!(result.remaining, remaining);
+        }
+        let result = limiter.check("burst").await.unwrap();
+        assert!(!result.allowed);
+        assert_eq!(result.retry_after, Some(Duration::from_millis(50)));
+
+        // 20 tokens per second refill one token every 50ms
+        tokio::time::sleep(Duration::from_millis(60)).await;
+        assert!(limiter.check("burst").await.unwrap().allowed);
+        assert!(!limiter.check("burst").await.unwrap().allowed);
+    }
+
+    #[tokio::test]
+    async fn test_fixed_window_rollover() {
+        let window = Duration::from_millis(100);
+        let limiter = RateLimiter::builder()
+            .fixed_window(2, window)
+            .build()
+            .await
+            .unwrap();
+
+        assert!(limiter.check("k").await.unwrap().allowed);
+        assert!(limiter.check("k").await.unwrap().allowed);
+        let denied = limiter.check("k").await.unwrap();
+        assert!(!denied.allowed);
+        assert!(denied.retry_after.unwrap() <= window);
+
+        tokio::time::sleep(window + Duration::from_millis(20)).await;
+        let result = limiter.check("k").await.unwrap();
+        assert!(result.allowed);
+        assert_eq!(result.remaining, 1);
+    }
+
+    #[tokio::test]
+    async fn test_sliding_window_rollover() {
+        let window = Duration::from_millis(100);
+        let limiter = RateLimiter::builder()
+            .sliding_window(2, window)
+            .build()
+            .await
+            .unwrap();
+
+        assert!(limiter.check("k").await.unwrap().allowed);
+        tokio::time::sleep(Duration::from_millis(60)).await;
+        assert!(limiter.check("k").await.unwrap().allowed);
+        assert!(!limiter.check("k").await.unwrap().allowed);
+
+        // Only the first request has left the window
+        tokio::time::sleep(Duration::from_millis(60)).await;
+        assert!(limiter.check("k").await.unwrap().allowed);
+        assert!(!limiter.check("k").await.unwrap().allowed);
+    }
+
     #[tokio::test]
     async fn test_different_keys() {
         let limiter = RateLimiter::builder()
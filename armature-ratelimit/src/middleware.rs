@@ -6,11 +6,28 @@
 use crate::RateLimiter;
 use crate::error::RateLimitHeaders;
 use crate::extractor::{KeyExtractor, RequestInfo};
+use armature_core::HttpRequest;
 use std::net::IpAddr;
 use std::sync::Arc;
+use std::time::Duration;
 use tracing::{debug, info, trace, warn};
 
+/// Function deriving a value (rate limit key or user ID) from a request
+pub type RequestKeyFn = Arc<dyn Fn(&HttpRequest) -> Option<String> + Send + Sync>;
+
 /// Rate limiting middleware for Armature applications
+///
+/// Requests are keyed by client IP by default, as returned by
+/// [`HttpRequest::client_ip`]. Behind a reverse proxy, configure the proxy
+/// with `Application::with_trusted_proxies` so the client address is taken
+/// from its forwarding headers. Use [`with_extractor`](Self::with_extractor) to pick
+/// another strategy, or [`with_key_fn`](Self::with_key_fn) to derive the key
+/// from the request directly, e.g. from an authenticated user stored in the
+/// request extensions.
+///
+/// Limited requests receive `429 Too Many Requests` with a `Retry-After`
+/// header; `X-RateLimit-Limit`, `X-RateLimit-Remaining` and
+/// `X-RateLimit-Reset` are added unless disabled.
 pub struct RateLimitMiddleware {
     /// The rate limiter instance
     limiter: Arc<RateLimiter>,
@@ -22,6 +39,10 @@ pub struct RateLimitMiddleware {
     error_message: String,
     /// Keys that bypass rate limiting
     bypass_keys: Vec<String>,
+    /// Derives the key from the request, replacing the key extractor
+    key_fn: Option<RequestKeyFn>,
+    /// Resolves the authenticated user ID for user-based extractors
+    user_id_fn: Option<RequestKeyFn>,
 }
 
 impl RateLimitMiddleware {
@@ -36,6 +57,8 @@ impl RateLimitMiddleware {
                 .error_message
                 .unwrap_or_else(|| "Rate limit exceeded".to_string()),
             bypass_keys: config.bypass_keys,
+            key_fn: None,
+            user_id_fn: None,
         }
     }
 
@@ -45,6 +68,39 @@ impl RateLimitMiddleware {
         self
     }
 
+    /// Derive the rate limit key from the request
+    ///
+    /// Takes precedence over the key extractor. Returning `None` lets the
+    /// request through unlimited.
+    ///
+    /// ```rust,ignore
+    /// let middleware = RateLimitMiddleware::new(limiter).with_key_fn(|req| {
+    ///     req.extensions
+    ///         .get::<CurrentUser>()
+    ///         .map(|user| format!("user:{}", user.id))
+    /// });
+    /// ```
+    pub fn with_key_fn<F>(mut self, key_fn: F) -> Self
+    where
+        F: Fn(&HttpRequest) -> Option<String> + Send + Sync + 'static,
+    {
+        self.key_fn = Some(Arc::new(key_fn));
+        self
+    }
+
+    /// Resolve the authenticated user ID used by [`KeyExtractor::UserId`]
+    /// and [`KeyExtractor::UserIdAndPath`]
+    ///
+    /// Without this the `X-User-Id` request header is used, which clients
+    /// control; only rely on it when a trusted proxy sets it.
+    pub fn with_user_id_fn<F>(mut self, user_id_fn: F) -> Self
+    where
+        F: Fn(&HttpRequest) -> Option<String> + Send + Sync + 'static,
+    {
+        self.user_id_fn = Some(Arc::new(user_id_fn));
+        self
+    }
+
     /// Set whether to include rate limit headers
     pub fn with_headers(mut self, include: bool) -> Self {
         self.include_headers = include;
@@ -67,25 +123,31 @@ impl RateLimitMiddleware {
     ///
     /// Returns Ok with headers if allowed, Err with response if rate limited.
     pub async fn check(&self, info: &RequestInfo) -> RateLimitCheckResponse {
-        // Extract key
-        let key = match self.key_extractor.extract(info) {
-            Some(k) => k,
+        self.check_extracted(self.key_extractor.extract(info)).await
+    }
+
+    async fn check_extracted(&self, key: Option<String>) -> RateLimitCheckResponse {
+        match key {
+            Some(key) => self.check_key(&key).await,
             None => {
                 warn!("Could not extract rate limit key, allowing request");
-                return RateLimitCheckResponse::Allowed { headers: None };
+                RateLimitCheckResponse::Allowed { headers: None }
             }
-        };
+        }
+    }
 
+    /// Check the rate limit for an already extracted key
+    pub async fn check_key(&self, key: &str) -> RateLimitCheckResponse {
         trace!(key = %key, "Checking rate limit");
 
         // Check bypass
-        if self.bypass_keys.contains(&key) {
+        if self.bypass_keys.iter().any(|k| k == key) {
             debug!(key = %key, "Key is in bypass list, allowing request");
             return RateLimitCheckResponse::Allowed { headers: None };
         }
 
         // Check rate limit
-        match self.limiter.check(&key).await {
+        match self.limiter.check(key).await {
             Ok(result) => {
                 let headers = if self.include_headers {
                     Some(RateLimitHeaders::allowed(
@@ -102,18 +164,19 @@ impl RateLimitMiddleware {
                     RateLimitCheckResponse::Allowed { headers }
                 } else {
                     info!(key = %key, retry_after = ?result.retry_after, "Rate limit exceeded");
+                    let retry_after = retry_after_secs(result.retry_after);
                     RateLimitCheckResponse::Limited {
                         headers: if self.include_headers {
                             Some(RateLimitHeaders::denied(
                                 result.limit,
                                 result.reset_at,
-                                result.retry_after.map(|d| d.as_secs()).unwrap_or(1),
+                                retry_after,
                             ))
                         } else {
                             None
                         },
                         message: self.error_message.clone(),
-                        retry_after: result.retry_after.map(|d| d.as_secs()),
+                        retry_after: Some(retry_after),
                     }
                 }
             }
@@ -159,12 +222,42 @@ impl RateLimitMiddleware {
         info
     }
 
+    /// Build the request info used by the key extractor
+    fn request_info(&self, req: &HttpRequest) -> RequestInfo {
+        let user_id = match &self.user_id_fn {
+            Some(user_id_fn) => user_id_fn(req),
+            None => req.header("x-user-id").map(str::to_string),
+        };
+
+        let headers: Vec<(String, String)> = req
+            .headers
+            .iter()
+            .map(|(k, v)| (k.clone(), v.clone()))
+            .collect();
+
+        Self::extract_request_info(
+            req.client_ip(),
+            &req.path,
+            &req.method,
+            user_id.as_deref(),
+            &headers,
+        )
+    }
+
     /// Get the underlying rate limiter
     pub fn limiter(&self) -> &RateLimiter {
         &self.limiter
     }
 }
 
+/// Whole seconds for `Retry-After`, rounded up so clients never retry early
+fn retry_after_secs(retry_after: Option<Duration>) -> u64 {
+    retry_after
+        .map(|d| d.as_secs() + u64::from(d.subsec_nanos() > 0))
+        .unwrap_or(1)
+        .max(1)
+}
+
 /// Response from rate limit check
 #[derive(Debug)]
 pub enum RateLimitCheckResponse {
@@ -227,6 +320,8 @@ pub struct RateLimitMiddlewareBuilder {
     include_headers: bool,
     error_message: Option<String>,
     bypass_keys: Vec<String>,
+    key_fn: Option<RequestKeyFn>,
+    user_id_fn: Option<RequestKeyFn>,
 }
 
 impl RateLimitMiddlewareBuilder {
@@ -238,6 +333,8 @@ impl RateLimitMiddlewareBuilder {
             include_headers: true,
             error_message: None,
             bypass_keys: Vec::new(),
+            key_fn: None,
+            user_id_fn: None,
         }
     }
 
@@ -273,6 +370,25 @@ impl RateLimitMiddlewareBuilder {
         self
     }
 
+    /// Derive the key from the request, see [`RateLimitMiddleware::with_key_fn`]
+    pub fn key_fn<F>(mut self, key_fn: F) -> Self
+    where
+        F: Fn(&HttpRequest) -> Option<String> + Send + Sync + 'static,
+    {
+        self.key_fn = Some(Arc::new(key_fn));
+        self
+    }
+
+    /// Resolve the authenticated user ID, see
+    /// [`RateLimitMiddleware::with_user_id_fn`]
+    pub fn user_id_fn<F>(mut self, user_id_fn: F) -> Self
+    where
+        F: Fn(&HttpRequest) -> Option<String> + Send + Sync + 'static,
+    {
+        self.user_id_fn = Some(Arc::new(user_id_fn));
+        self
+    }
+
     /// Extract key from IP and path combination
     pub fn by_ip_and_path(mut self) -> Self {
         self.key_extractor = KeyExtractor::IpAndPath;
@@ -309,6 +425,8 @@ impl RateLimitMiddlewareBuilder {
         if let Some(msg) = self.error_message {
             middleware = middleware.with_error_message(msg);
         }
+        middleware.key_fn = self.key_fn;
+        middleware.user_id_fn = self.user_id_fn;
 
         Some(middleware)
     }
@@ -339,25 +457,13 @@ impl armature_core::Middleware for RateLimitMiddleware {
                 > + Send,
         >,
     ) -> Result<armature_core::HttpResponse, armature_core::Error> {
-        // Extract request info
-        let ip = req
-            .headers
-            .get("x-forwarded-for")
-            .or_else(|| req.headers.get("x-real-ip"))
-            .and_then(|s| s.parse().ok());
-
-        let user_id = req.headers.get("x-user-id").map(|s| s.as_str());
-
-        let headers: Vec<(String, String)> = req
-            .headers
-            .iter()
-            .map(|(k, v)| (k.clone(), v.clone()))
-            .collect();
-
-        let info = Self::extract_request_info(ip, &req.path, &req.method, user_id, &headers);
+        let key = match &self.key_fn {
+            Some(key_fn) => key_fn(&req),
+            None => self.key_extractor.extract(&self.request_info(&req)),
+        };
 
         // Check rate limit
-        match self.check(&info).await {
+        match self.check_extracted(key).await {
             RateLimitCheckResponse::Allowed { headers } => {
                 // Request is allowed, call next handler
                 let mut response = next(req).await?;
@@ -551,6 +657,157 @@ mod tests {
         assert_eq!(info.api_key, Some("test_key".to_string()));
     }
 
+    fn request_from(ip: &str) -> HttpRequest {
+        let mut req = HttpRequest::new("GET".to_string(), "/api".to_string());
+        req.extensions.insert(armature_core::RemoteAddr(
+            format!("{}:40000", ip).parse().unwrap(),
+        ));
+        req
+    }
+
+    async fn call(
+        middleware: &RateLimitMiddleware,
+        req: HttpRequest,
+    ) -> armature_core::HttpResponse {
+        use armature_core::Middleware;
+
+        middleware
+            .handle(
+                req,
+                Box::new(|_req| Box::pin(async { Ok(armature_core::HttpResponse::ok()) })),
+            )
+            .await
+            .unwrap()
+    }
+
+    #[tokio::test]
+    async fn test_handle_headers_and_429() {
+        let limiter = Arc::new(
+            RateLimiter::builder()
+                .token_bucket(2, 0.5)
+                .build()
+                .await
+                .unwrap(),
+        );
+        let middleware = RateLimitMiddleware::new(limiter);
+
+        for remaining in ["1", "0"] {
+            let response = call(&middleware, request_from("203.0.113.7")).await;
+            assert_eq!(response.status, 200);
+            assert_eq!(
+                response.headers.get("X-RateLimit-Limit"),
+                Some(&"2".to_string())
+            );
+            assert_eq!(
+                response.headers.get("X-RateLimit-Remaining"),
+                Some(&remaining.to_string())
+            );
+            assert!(response.headers.contains_key("X-RateLimit-Reset"));
+        }
+
+        let response = call(&middleware, request_from("203.0.113.7")).await;
+        assert_eq!(response.status, 429);
+        assert_eq!(response.headers.get("Retry-After"), Some(&"2".to_string()));
+        assert_eq!(
+            response.headers.get("X-RateLimit-Remaining"),
+            Some(&"0".to_string())
+        );
+
+        // Keyed by the first forwarded hop, so other clients are unaffected
+        let response = call(&middleware, request_from("203.0.113.8")).await;
+        assert_eq!(response.status, 200);
+    }
+
+    #[tokio::test]
+    async fn test_handle_retry_after_without_headers() {
+        let limiter = Arc::new(
+            RateLimiter::builder()
+                .fixed_window(1, std::time::Duration::from_millis(300))
+                .build()
+                .await
+                .unwrap(),
+        );
+        let middleware = RateLimitMiddleware::new(limiter).with_headers(false);
+
+        let response = call(&middleware, request_from("203.0.113.7")).await;
+        assert!(!response.headers.contains_key("X-RateLimit-Limit"));
+
+        // Sub-second waits round up to a whole second
+        let response = call(&middleware, request_from("203.0.113.7")).await;
+        assert_eq!(response.status, 429);
+        assert_eq!(response.headers.get("Retry-After"), Some(&"1".to_string()));
+        assert!(!response.headers.contains_key("X-RateLimit-Limit"));
+    }
+
+    #[derive(Clone)]
+    struct CurrentUser(String);
+
+    #[tokio::test]
+    async fn test_handle_key_fn_uses_authenticated_user() {
+        let limiter = Arc::new(
+            RateLimiter::builder()
+                .token_bucket(1, 0.001)
+                .build()
+                .await
+                .unwrap(),
+        );
+        let middleware = RateLimitMiddleware::new(limiter).with_key_fn(|req| {
+            req.extensions
+                .get::<CurrentUser>()
+                .map(|user| format!("user:{}", user.0))
+        });
+
+        let as_user = |name: &str| {
+            // Same IP for everyone; only the user should matter
+            let mut req = request_from("203.0.113.7");
+            req.extensions.insert(CurrentUser(name.to_string()));
+            req
+        };
+
+        assert_eq!(call(&middleware, as_user("alice")).await.status, 200);
+        assert_eq!(call(&middleware, as_user("alice")).await.status, 429);
+        assert_eq!(call(&middleware, as_user("bob")).await.status, 200);
+    }
+
+    #[tokio::test]
+    async fn test_handle_user_id_fn_ignores_header() {
+        let limiter = Arc::new(
+            RateLimiter::builder()
+                .token_bucket(1, 0.001)
+                .build()
+                .await
+                .unwrap(),
+        );
+        let middleware = RateLimitMiddlewareBuilder::new()
+            .limiter(limiter)
+            .by_user_id()
+            .user_id_fn(|req| req.extensions.get::<CurrentUser>().map(|u| u.0.clone()))
+            .build()
+            .unwrap();
+
+        let spoofed = |id: &str| {
+            let mut req = request_from("203.0.113.7");
+            req.extensions.insert(CurrentUser("alice".to_string()));
+            req.headers.insert("X-User-Id".to_string(), id.to_string());
+            req
+        };
+
+        // A client-supplied header cannot move the request to another bucket
+        assert_eq!(call(&middleware, spoofed("a")).await.status, 200);
+        assert_eq!(call(&middleware, spoofed("b")).await.status, 429);
+    }
+
+    #[test]
+    fn test_retry_after_secs() {
+        use std::time::Duration;
+
+        assert_eq!(retry_after_secs(None), 1);
+        assert_eq!(retry_after_secs(Some(Duration::ZERO)), 1);
+        assert_eq!(retry_after_secs(Some(Duration::from_millis(200))), 1);
+        assert_eq!(retry_after_secs(Some(Duration::from_millis(2500))), 3);
+        assert_eq!(retry_after_secs(Some(Duration::from_secs(60))), 60);
+    }
+
     #[test]
     fn test_response_methods() {
         let allowed = RateLimitCheckResponse::Allowed { headers: None };
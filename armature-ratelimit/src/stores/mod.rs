@@ -25,6 +25,8 @@ pub enum StoreType {
     Memory,
     /// Redis store (distributed)
     Redis,
+    /// User-supplied store, see `RateLimiterBuilder::store`
+    Custom,
 }
 
 /// Trait for rate limit storage backends
@@ -82,4 +84,78 @@ mod tests {
         let store_type = StoreType::default();
         assert!(matches!(store_type, StoreType::Memory));
     }
+
+    /// Behaviour every `RateLimitStore` must provide, whatever its backend.
+    async fn assert_store_contract(store: &dyn RateLimitStore) {
+        let window = Duration::from_millis(100);
+
+        // Token bucket: the full capacity is available as a burst
+        assert_eq!(
+            store.token_bucket_check("tb", 2, 0.001).await.unwrap(),
+            (true, 1)
+        );
+        assert_eq!(
+            store.token_bucket_check("tb", 2, 0.001).await.unwrap(),
+            (true, 0)
+        );
+        assert_eq!(
+            store.token_bucket_check("tb", 2, 0.001).await.unwrap(),
+            (false, 0)
+        );
+
+        // Windows count down remaining requests and deny at the limit
+        for expected in [(true, 1), (true, 0), (false, 0)] {
+            assert_eq!(
+                store.sliding_window_check("sw", 2, window).await.unwrap(),
+                expected
+            );
+            assert_eq!(
+                store.fixed_window_check("fw", 2, window).await.unwrap(),
+                expected
+            );
+        }
+
+        // Keys are independent
+        assert!(store.token_bucket_check("other", 2, 0.001).await.unwrap().0);
+        assert!(
+            store
+                .sliding_window_check("other", 2, window)
+                .await
+                .unwrap()
+                .0
+        );
+        assert!(
+            store
+                .fixed_window_check("other", 2, window)
+                .await
+                .unwrap()
+                .0
+        );
+
+        // Windows roll over once they have passed
+        tokio::time::sleep(window + Duration::from_millis(20)).await;
+        assert_eq!(
+            store.sliding_window_check("sw", 2, window).await.unwrap(),
+            (true, 1)
+        );
+        assert_eq!(
+            store.fixed_window_check("fw", 2, window).await.unwrap(),
+            (true, 1)
+        );
+
+        // Reset clears all state for a key
+        store.reset("tb").await.unwrap();
+        assert_eq!(
+            store.token_bucket_check("tb", 2, 0.001).await.unwrap(),
+            (true, 1)
+        );
+
+        store.cleanup().await.unwrap();
+        assert!(!store.store_type().is_empty());
+    }
+
+    #[tokio::test]
+    async fn test_memory_store_contract() {
+        assert_store_contract(&MemoryStore::new()).await;
+    }
 }
@@ -1,8 +1,313 @@
 //! Content Security Policy (CSP) configuration
 //!
 //! CSP helps prevent XSS attacks by declaring which dynamic resources are allowed to load.
+//!
+//! Sources are given as [`Source`] values, so a misspelt keyword doesn't
+//! compile instead of silently loosening the policy. Schemes and hosts are
+//! checked by [`Source::scheme`] and [`Source::host`], and sources read from
+//! configuration are parsed with [`Source::try_from`], which rejects unknown
+//! keywords and malformed hosts:
+//!
+//! ```
+//! use armature_security::content_security_policy::{CspConfig, Source, SourceError};
+//!
+//! # fn main() -> Result<(), SourceError> {
+//! let csp = CspConfig::new()
+//!     .default_src([Source::SelfOrigin])
+//!     .script_src([Source::SelfOrigin, Source::host("https://cdn.example.com")?])
+//!     .img_src([Source::SelfOrigin, Source::scheme("data")?])
+//!     .object_src([Source::None])
+//!     .upgrade_insecure_requests();
+//!
+//! assert_eq!(
+//!     csp.to_header_value(),
+//!     "default-src 'self'; img-src 'self' data:; object-src 'none'; \
+//!      script-src 'self' https://cdn.example.com; upgrade-insecure-requests"
+//! );
+//!
+//! assert_eq!(Source::try_from("'self'"), Ok(Source::SelfOrigin));
+//! assert!(Source::try_from("'slef'").is_err());
+//! assert!(Source::host("https://cdn.example.com;").is_err());
+//! # Ok(())
+//! # }
+//! ```
 
 use std::collections::HashMap;
+use std::fmt;
+
+/// A source in a CSP fetch directive such as `script-src`
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum Source {
+    /// `'self'`: the origin the document was served from
+    SelfOrigin,
+    /// `'none'`: nothing may be loaded
+    None,
+    /// `'unsafe-inline'`: inline scripts, styles and event handlers
+    UnsafeInline,
+    /// `'unsafe-eval'`: `eval()` and similar
+    UnsafeEval,
+    /// `'unsafe-hashes'`: inline event handlers matching a hash source
+    UnsafeHashes,
+    /// `'strict-dynamic'`: trust scripts loaded by already trusted scripts
+    StrictDynamic,
+    /// `'wasm-unsafe-eval'`: WebAssembly compilation
+    WasmUnsafeEval,
+    /// `'report-sample'`: include a sample of the violating code in reports
+    ReportSample,
+    /// `'nonce-<value>'`, with a base64 nonce generated per response
+    Nonce(String),
+    /// `'sha256-<digest>'`, with a base64 digest of the allowed code
+    Sha256(String),
+    /// `'sha384-<digest>'`
+    Sha384(String),
+    /// `'sha512-<digest>'`
+    Sha512(String),
+    /// A scheme such as `data:` or `https:`, without the colon
+    ///
+    /// Built directly, the scheme isn't checked; prefer [`Source::scheme`].
+    Scheme(String),
+    /// A host such as `https://cdn.example.com` or `*.example.com`
+    ///
+    /// Built directly, the host isn't checked; prefer [`Source::host`].
+    Host(String),
+}
+
+impl Source {
+    /// A scheme source; `scheme("data")` is `data:`
+    ///
+    /// Fails unless the scheme follows RFC 3986: a letter, then letters,
+    /// digits, `+`, `-` or `.`. A trailing colon is optional.
+    pub fn scheme(scheme: impl AsRef<str>) -> Result<Self, SourceError> {
+        let scheme = scheme.as_ref();
+        let name = scheme.strip_suffix(':').unwrap_or(scheme);
+        if is_scheme(name) {
+            Ok(Self::Scheme(name.to_string()))
+        } else {
+            Err(SourceError::InvalidSource(scheme.to_string()))
+        }
+    }
+
+    /// A host source such as `https://cdn.example.com` or
+    /// `*.example.com:443`
+    ///
+    /// Fails unless `host` is a CSP host-source: an optional scheme, a host
+    /// whose first label may be `*`, an optional port or `*`, and an
+    /// optional path.
+    pub fn host(host: impl AsRef<str>) -> Result<Self, SourceError> {
+        let host = host.as_ref();
+        if is_host_source(host) {
+            Ok(Self::Host(host.to_string()))
+        } else {
+            Err(SourceError::InvalidSource(host.to_string()))
+        }
+    }
+
+    /// A nonce source for a per-response `nonce`
+    pub fn nonce(nonce: impl Into<String>) -> Self {
+        Self::Nonce(nonce.into())
+    }
+}
+
+impl fmt::Display for Source {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            Self::SelfOrigin => f.write_str("'self'"),
+            Self::None => f.write_str("'none'"),
+            Self::UnsafeInline => f.write_str("'unsafe-inline'"),
+            Self::UnsafeEval => f.write_str("'unsafe-eval'"),
+            Self::UnsafeHashes => f.write_str("'unsafe-hashes'"),
+            Self::StrictDynamic => f.write_str("'strict-dynamic'"),
+            Self::WasmUnsafeEval => f.write_str("'wasm-unsafe-eval'"),
+            Self::ReportSample => f.write_str("'report-sample'"),
+            Self::Nonce(nonce) => write!(f, "'nonce-{}'", nonce),
+            Self::Sha256(digest) => write!(f, "'sha256-{}'", digest),
+            Self::Sha384(digest) => write!(f, "'sha384-{}'", digest),
+            Self::Sha512(digest) => write!(f, "'sha512-{}'", digest),
+            Self::Scheme(scheme) => write!(f, "{}:", scheme),
+            Self::Host(host) => f.write_str(host),
+        }
+    }
+}
+
+/// Error parsing a [`Source`] from a string
+#[derive(Debug, Clone, PartialEq, Eq, thiserror::Error)]
+pub enum SourceError {
+    /// A quoted source that isn't a CSP keyword, nonce or hash
+    #[error("unknown CSP keyword {0}")]
+    UnknownKeyword(String),
+    /// Not a valid scheme or host source
+    #[error("invalid CSP source {0:?}")]
+    InvalidSource(String),
+}
+
+/// Parse a source written the way it appears in a policy, e.g. `'self'`.
+///
+/// Keywords are recognized with or without their quotes, since an unquoted
+/// `self` would otherwise be read by browsers as a host named `self`. Any
+/// other quoted value must be a nonce or hash, and unquoted values must be a
+/// scheme such as `data:` or a host source such as `https://*.example.com:443`.
+impl TryFrom<&str> for Source {
+    type Error = SourceError;
+
+    fn try_from(source: &str) -> Result<Self, Self::Error> {
+        let source = source.trim();
+        let quoted = source.len() >= 2 && source.starts_with('\'') && source.ends_with('\'');
+        let keyword = if quoted {
+            &source[1..source.len() - 1]
+        } else {
+            source
+        };
+        match keyword {
+            "self" => return Ok(Self::SelfOrigin),
+            "none" => return Ok(Self::None),
+            "unsafe-inline" => return Ok(Self::UnsafeInline),
+            "unsafe-eval" => return Ok(Self::UnsafeEval),
+            "unsafe-hashes" => return Ok(Self::UnsafeHashes),
+            "strict-dynamic" => return Ok(Self::StrictDynamic),
+            "wasm-unsafe-eval" => return Ok(Self::WasmUnsafeEval),
+            "report-sample" => return Ok(Self::ReportSample),
+            _ => {}
+        }
+
+        if quoted {
+            let (kind, value) = keyword
+                .split_once('-')
+                .filter(|(_, value)| is_base64(value))
+                .ok_or_else(|| SourceError::UnknownKeyword(source.to_string()))?;
+            let value = value.to_string();
+            return match kind {
+                "nonce" => Ok(Self::Nonce(value)),
+                "sha256" => Ok(Self::Sha256(value)),
+                "sha384" => Ok(Self::Sha384(value)),
+                "sha512" => Ok(Self::Sha512(value)),
+                _ => Err(SourceError::UnknownKeyword(source.to_string())),
+            };
+        }
+
+        if let Some(scheme) = source.strip_suffix(':')
+            && is_scheme(scheme)
+        {
+            Ok(Self::Scheme(scheme.to_string()))
+        } else if is_host_source(source) {
+            Ok(Self::Host(source.to_string()))
+        } else {
+            Err(SourceError::InvalidSource(source.to_string()))
+        }
+    }
+}
+
+impl TryFrom<String> for Source {
+    type Error = SourceError;
+
+    fn try_from(source: String) -> Result<Self, Self::Error> {
+        Self::try_from(source.as_str())
+    }
+}
+
+/// Base64 (or base64url) value of a nonce or hash source
+fn is_base64(value: &str) -> bool {
+    let value = value.trim_end_matches('=');
+    !value.is_empty()
+        && value
+            .bytes()
+            .all(|b| b.is_ascii_alphanumeric() || matches!(b, b'+' | b'/' | b'-' | b'_'))
+}
+
+/// `ALPHA *( ALPHA / DIGIT / "+" / "-" / "." )`
+fn is_scheme(scheme: &str) -> bool {
+    scheme.starts_with(|c: char| c.is_ascii_alphabetic())
+        && scheme
+            .bytes()
+            .all(|b| b.is_ascii_alphanumeric() || matches!(b, b'+' | b'-' | b'.'))
+}
+
+/// `[ scheme "://" ] host [ ":" port ] [ path ]`, where the host is `*` or
+/// `[ "*." ] label *( "." label )`
+fn is_host_source(source: &str) -> bool {
+    let rest = match source.split_once("://") {
+        Some((scheme, rest)) if is_scheme(scheme) => rest,
+        Some(_) => return false,
+        None => source,
+    };
+    let (authority, path) = match rest.find('/') {
+        Some(i) => rest.split_at(i),
+        None => (rest, ""),
+    };
+    if path.contains(|c: char| c == ';' || c == ',' || c.is_whitespace()) {
+        return false;
+    }
+    let (host, port) = match authority.split_once(':') {
+        Some((host, port)) => (host, Some(port)),
+        None => (authority, None),
+    };
+    if let Some(port) = port
+        && port != "*"
+        && (port.is_empty() || !port.bytes().all(|b| b.is_ascii_digit()))
+    {
+        return false;
+    }
+    if host == "*" {
+        return true;
+    }
+    let host = host.strip_prefix("*.").unwrap_or(host);
+    !host.is_empty()
+        && host.split('.').all(|label| {
+            !label.is_empty()
+                && label
+                    .bytes()
+                    .all(|b| b.is_ascii_alphanumeric() || b == b'-')
+        })
+}
+
+/// Error adding a directive with [`CspConfig::directive`]
+#[derive(Debug, Clone, PartialEq, Eq, thiserror::Error)]
+pub enum DirectiveError {
+    /// Not a directive of CSP Level 3
+    #[error("unknown CSP directive {0:?}")]
+    UnknownDirective(String),
+    /// A value that is empty or would end the directive early
+    #[error("invalid value {0:?} in CSP directive")]
+    InvalidValue(String),
+}
+
+/// Directives [`CspConfig::directive`] accepts
+const DIRECTIVES: &[&str] = &[
+    "base-uri",
+    "block-all-mixed-content",
+    "child-src",
+    "connect-src",
+    "default-src",
+    "fenced-frame-src",
+    "font-src",
+    "form-action",
+    "frame-ancestors",
+    "frame-src",
+    "img-src",
+    "manifest-src",
+    "media-src",
+    "object-src",
+    "report-to",
+    "report-uri",
+    "require-trusted-types-for",
+    "sandbox",
+    "script-src",
+    "script-src-attr",
+    "script-src-elem",
+    "style-src",
+    "style-src-attr",
+    "style-src-elem",
+    "trusted-types",
+    "upgrade-insecure-requests",
+    "webrtc",
+    "worker-src",
+];
+
+fn sources<S: Into<Source>>(sources: impl IntoIterator<Item = S>) -> Vec<String> {
+    sources
+        .into_iter()
+        .map(|source| source.into().to_string())
+        .collect()
+}
 
 /// Content Security Policy configuration
 #[derive(Debug, Clone)]
@@ -23,55 +328,117 @@ impl CspConfig {
         }
     }
 
-    /// Add a directive
-    pub fn directive(mut self, name: impl Into<String>, values: Vec<String>) -> Self {
-        self.directives.insert(name.into(), values);
+    /// Add a directive without a builder of its own, such as `sandbox`
+    ///
+    /// Fails if `name` isn't a CSP directive, so a misspelt name isn't
+    /// silently ignored by browsers, or if a value is empty or contains
+    /// whitespace, `;` or `,`, which would turn it into more values or
+    /// directives. Values aren't parsed as [`Source`]s, since not every
+    /// directive takes sources.
+    ///
+    /// To send a directive this list doesn't know yet, insert it into
+    /// [`directives`](Self::directives), which isn't checked.
+    ///
+    /// ```
+    /// use armature_security::content_security_policy::CspConfig;
+    ///
+    /// let csp = CspConfig::new()
+    ///     .directive("sandbox", vec!["allow-scripts".to_string()])
+    ///     .unwrap();
+    /// assert_eq!(csp.to_header_value(), "sandbox allow-scripts");
+    ///
+    /// assert!(CspConfig::new().directive("scirpt-src", Vec::new()).is_err());
+    /// ```
+    pub fn directive(self, name: &str, values: Vec<String>) -> Result<Self, DirectiveError> {
+        if !DIRECTIVES.contains(&name) {
+            return Err(DirectiveError::UnknownDirective(name.to_string()));
+        }
+        if let Some(value) = values.iter().find(|value| {
+            value.is_empty() || value.contains(|c: char| c == ';' || c == ',' || c.is_whitespace())
+        }) {
+            return Err(DirectiveError::InvalidValue(value.clone()));
+        }
+        Ok(self.set(name, values))
+    }
+
+    fn set(mut self, name: &str, values: Vec<String>) -> Self {
+        self.directives.insert(name.to_string(), values);
         self
     }
 
     /// Set default-src directive
-    pub fn default_src(self, sources: Vec<String>) -> Self {
-        self.directive("default-src", sources)
+    pub fn default_src<S: Into<Source>>(self, sources: impl IntoIterator<Item = S>) -> Self {
+        self.set("default-src", self::sources(sources))
     }
 
     /// Set script-src directive
-    pub fn script_src(self, sources: Vec<String>) -> Self {
-        self.directive("script-src", sources)
+    pub fn script_src<S: Into<Source>>(self, sources: impl IntoIterator<Item = S>) -> Self {
+        self.set("script-src", self::sources(sources))
     }
 
     /// Set style-src directive
-    pub fn style_src(self, sources: Vec<String>) -> Self {
-        self.directive("style-src", sources)
+    pub fn style_src<S: Into<Source>>(self, sources: impl IntoIterator<Item = S>) -> Self {
+        self.set("style-src", self::sources(sources))
     }
 
     /// Set img-src directive
-    pub fn img_src(self, sources: Vec<String>) -> Self {
-        self.directive("img-src", sources)
+    pub fn img_src<S: Into<Source>>(self, sources: impl IntoIterator<Item = S>) -> Self {
+        self.set("img-src", self::sources(sources))
     }
 
     /// Set connect-src directive
-    pub fn connect_src(self, sources: Vec<String>) -> Self {
-        self.directive("connect-src", sources)
+    pub fn connect_src<S: Into<Source>>(self, sources: impl IntoIterator<Item = S>) -> Self {
+        self.set("connect-src", self::sources(sources))
     }
 
     /// Set font-src directive
-    pub fn font_src(self, sources: Vec<String>) -> Self {
-        self.directive("font-src", sources)
+    pub fn font_src<S: Into<Source>>(self, sources: impl IntoIterator<Item = S>) -> Self {
+        self.set("font-src", self::sources(sources))
     }
 
     /// Set object-src directive
-    pub fn object_src(self, sources: Vec<String>) -> Self {
-        self.directive("object-src", sources)
+    pub fn object_src<S: Into<Source>>(self, sources: impl IntoIterator<Item = S>) -> Self {
+        self.set("object-src", self::sources(sources))
     }
 
     /// Set media-src directive
-    pub fn media_src(self, sources: Vec<String>) -> Self {
-        self.directive("media-src", sources)
+    pub fn media_src<S: Into<Source>>(self, sources: impl IntoIterator<Item = S>) -> Self {
+        self.set("media-src", self::sources(sources))
     }
 
     /// Set frame-src directive
-    pub fn frame_src(self, sources: Vec<String>) -> Self {
-        self.directive("frame-src", sources)
+    pub fn frame_src<S: Into<Source>>(self, sources: impl IntoIterator<Item = S>) -> Self {
+        self.set("frame-src", self::sources(sources))
+    }
+
+    /// Set worker-src directive
+    pub fn worker_src<S: Into<Source>>(self, sources: impl IntoIterator<Item = S>) -> Self {
+        self.set("worker-src", self::sources(sources))
+    }
+
+    /// Set the frame-ancestors directive, which pages may embed this one
+    pub fn frame_ancestors<S: Into<Source>>(self, sources: impl IntoIterator<Item = S>) -> Self {
+        self.set("frame-ancestors", self::sources(sources))
+    }
+
+    /// Set base-uri directive
+    pub fn base_uri<S: Into<Source>>(self, sources: impl IntoIterator<Item = S>) -> Self {
+        self.set("base-uri", self::sources(sources))
+    }
+
+    /// Set form-action directive
+    pub fn form_action<S: Into<Source>>(self, sources: impl IntoIterator<Item = S>) -> Self {
+        self.set("form-action", self::sources(sources))
+    }
+
+    /// Make browsers load `http:` URLs of the page over HTTPS
+    pub fn upgrade_insecure_requests(self) -> Self {
+        self.set("upgrade-insecure-requests", Vec::new())
+    }
+
+    /// Send violation reports to `url`
+    pub fn report_uri(self, url: impl Into<String>) -> Self {
+        self.set("report-uri", vec![url.into()])
     }
 
     /// Enable report-only mode
@@ -80,15 +447,33 @@ impl CspConfig {
         self
     }
 
+    /// Name of the header the policy is sent in, depending on
+    /// [`report_only`](Self::report_only)
+    pub fn header_name(&self) -> &'static str {
+        if self.report_only {
+            "Content-Security-Policy-Report-Only"
+        } else {
+            "Content-Security-Policy"
+        }
+    }
+
     /// Convert to header value
+    ///
+    /// Directives are listed in alphabetical order, so the value is the same
+    /// for every response.
     pub fn to_header_value(&self) -> String {
         let mut parts = Vec::new();
 
         for (directive, values) in &self.directives {
-            let value_str = values.join(" ");
-            parts.push(format!("{} {}", directive, value_str));
+            if values.is_empty() {
+                parts.push(directive.clone());
+            } else {
+                let value_str = values.join(" ");
+                parts.push(format!("{} {}", directive, value_str));
+            }
         }
 
+        parts.sort();
         parts.join("; ")
     }
 }
@@ -96,17 +481,17 @@ impl CspConfig {
 impl Default for CspConfig {
     fn default() -> Self {
         Self::new()
-            .default_src(vec!["'self'".to_string()])
-            .script_src(vec!["'self'".to_string()])
-            .style_src(vec!["'self'".to_string(), "'unsafe-inline'".to_string()])
-            .img_src(vec![
-                "'self'".to_string(),
-                "data:".to_string(),
-                "https:".to_string(),
+            .default_src([Source::SelfOrigin])
+            .script_src([Source::SelfOrigin])
+            .style_src([Source::SelfOrigin, Source::UnsafeInline])
+            .img_src([
+                Source::SelfOrigin,
+                Source::Scheme("data".to_string()),
+                Source::Scheme("https".to_string()),
             ])
-            .font_src(vec!["'self'".to_string()])
-            .connect_src(vec!["'self'".to_string()])
-            .object_src(vec!["'none'".to_string()])
+            .font_src([Source::SelfOrigin])
+            .connect_src([Source::SelfOrigin])
+            .object_src([Source::None])
     }
 }
 
@@ -127,10 +512,10 @@ mod tests {
     #[test]
     fn test_csp_custom() {
         let csp = CspConfig::new()
-            .default_src(vec!["'self'".to_string()])
+            .default_src(vec![Source::SelfOrigin])
             .script_src(vec![
-                "'self'".to_string(),
-                "https://cdn.example.com".to_string(),
+                Source::SelfOrigin,
+                Source::host("https://cdn.example.com").unwrap(),
             ]);
 
         let header = csp.to_header_value();
@@ -141,5 +526,133 @@ mod tests {
     fn test_csp_report_only() {
         let csp = CspConfig::default().report_only(true);
         assert!(csp.report_only);
+        assert_eq!(csp.header_name(), "Content-Security-Policy-Report-Only");
+        assert_eq!(CspConfig::new().header_name(), "Content-Security-Policy");
+    }
+
+    #[test]
+    fn test_csp_typed_sources() {
+        let csp = CspConfig::new()
+            .script_src([
+                Source::SelfOrigin,
+                Source::nonce("r4nd0m"),
+                Source::Sha256("abc=".to_string()),
+                Source::StrictDynamic,
+            ])
+            .frame_ancestors([Source::None])
+            .base_uri([Source::SelfOrigin])
+            .report_uri("/csp-reports");
+
+        assert_eq!(
+            csp.to_header_value(),
+            "base-uri 'self'; frame-ancestors 'none'; report-uri /csp-reports; \
+             script-src 'self' 'nonce-r4nd0m' 'sha256-abc=' 'strict-dynamic'"
+        );
+    }
+
+    #[test]
+    fn test_source_try_from_str() {
+        let parse = |source: &str| Source::try_from(source).unwrap();
+        assert_eq!(parse("'self'"), Source::SelfOrigin);
+        assert_eq!(parse("self"), Source::SelfOrigin);
+        assert_eq!(parse("'unsafe-inline'"), Source::UnsafeInline);
+        assert_eq!(parse("'nonce-abc'"), Source::nonce("abc"));
+        assert_eq!(
+            parse("'sha256-ab+/c='"),
+            Source::Sha256("ab+/c=".to_string())
+        );
+        assert_eq!(parse("data:"), Source::scheme("data").unwrap());
+        assert_eq!(
+            parse("https://cdn.example.com"),
+            Source::host("https://cdn.example.com").unwrap()
+        );
+        for host in [
+            "*.example.com",
+            "example.com:8080/static/",
+            "wss://*:*",
+            "*",
+        ] {
+            assert_eq!(parse(host), Source::host(host).unwrap());
+        }
+        assert_eq!(Source::scheme("https:").unwrap().to_string(), "https:");
+    }
+
+    #[test]
+    fn test_source_try_from_rejects_typos() {
+        for keyword in ["'slef'", "'unsafe_inline'", "'nonce-'", "'sha1-abc'"] {
+            assert!(
+                matches!(
+                    Source::try_from(keyword),
+                    Err(SourceError::UnknownKeyword(_))
+                ),
+                "{}",
+                keyword
+            );
+        }
+        for source in [
+            "",
+            "'self",
+            "https://",
+            "1http://example.com",
+            "example..com",
+            "example.com:80a",
+            "example.com;script-src",
+            "*.*.example.com",
+        ] {
+            assert!(
+                matches!(Source::try_from(source), Err(SourceError::InvalidSource(_))),
+                "{:?}",
+                source
+            );
+        }
+    }
+
+    #[test]
+    fn test_scheme_and_host_are_checked() {
+        for scheme in ["", ":", "1http", "ht tp", "data:;"] {
+            assert!(Source::scheme(scheme).is_err(), "{:?}", scheme);
+        }
+        for host in [
+            "",
+            "https://",
+            "cdn example.com",
+            "cdn.example.com;",
+            "a..b",
+        ] {
+            assert!(Source::host(host).is_err(), "{:?}", host);
+        }
+        assert_eq!(Source::scheme("blob").unwrap().to_string(), "blob:");
+        assert_eq!(
+            Source::host("*.example.com:443").unwrap().to_string(),
+            "*.example.com:443"
+        );
+    }
+
+    #[test]
+    fn test_directive_is_checked() {
+        let csp = CspConfig::new()
+            .directive("sandbox", vec!["allow-forms".to_string()])
+            .unwrap()
+            .directive("block-all-mixed-content", Vec::new())
+            .unwrap();
+        assert_eq!(
+            csp.to_header_value(),
+            "block-all-mixed-content; sandbox allow-forms"
+        );
+
+        assert_eq!(
+            CspConfig::new()
+                .directive("script_src", Vec::new())
+                .unwrap_err(),
+            DirectiveError::UnknownDirective("script_src".to_string())
+        );
+        for value in ["", "'self'; script-src *", "a b", "a,b"] {
+            assert_eq!(
+                CspConfig::new()
+                    .directive("script-src", vec![value.to_string()])
+                    .unwrap_err(),
+                DirectiveError::InvalidValue(value.to_string())
+            );
+        }
     }
 }
@@ -13,6 +13,9 @@ pub struct HstsConfig {
 
     /// Preload (submit to browser preload list)
     pub preload: bool,
+
+    /// Only send the header on requests made over HTTPS
+    pub https_only: bool,
 }
 
 impl HstsConfig {
@@ -22,6 +25,7 @@ impl HstsConfig {
             max_age,
             include_subdomains: true,
             preload: false,
+            https_only: false,
         }
     }
 
@@ -37,6 +41,19 @@ impl HstsConfig {
         self
     }
 
+    /// Only send the header on requests made over HTTPS.
+    ///
+    /// Browsers ignore HSTS received over plain HTTP, so this mostly keeps
+    /// the header off responses to local or internal HTTP traffic. Requests
+    /// count as HTTPS as described in
+    /// [`HttpRequest::is_secure`](armature_core::HttpRequest::is_secure);
+    /// behind a TLS-terminating proxy, configure it with
+    /// [`Application::with_trusted_proxies`](armature_core::Application::with_trusted_proxies).
+    pub fn https_only(mut self, https_only: bool) -> Self {
+        self.https_only = https_only;
+        self
+    }
+
     /// Convert to header value
     pub fn to_header_value(&self) -> String {
         let mut parts = vec![format!("max-age={}", self.max_age)];
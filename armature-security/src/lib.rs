@@ -52,17 +52,20 @@
 //!
 //! ```
 //! use armature_security::SecurityMiddleware;
-//! use armature_security::content_security_policy::CspConfig;
+//! use armature_security::content_security_policy::{CspConfig, Source};
 //!
+//! # fn main() -> Result<(), armature_security::content_security_policy::SourceError> {
 //! let csp = CspConfig::new()
-//!     .default_src(vec!["'self'".to_string()])
-//!     .script_src(vec!["'self'".to_string(), "'unsafe-inline'".to_string()])
-//!     .style_src(vec!["'self'".to_string(), "https://fonts.googleapis.com".to_string()]);
+//!     .default_src(vec![Source::SelfOrigin])
+//!     .script_src(vec![Source::SelfOrigin, Source::UnsafeInline])
+//!     .style_src(vec![Source::SelfOrigin, Source::host("https://fonts.googleapis.com")?]);
 //!
 //! let security = SecurityMiddleware::new().with_csp(csp);
 //! let response = security.apply(armature_core::HttpResponse::ok());
 //!
 //! assert!(response.headers.contains_key("Content-Security-Policy"));
+//! # Ok(())
+//! # }
 //! ```
 //!
 //! ## HSTS (HTTP Strict Transport Security)
@@ -135,7 +138,7 @@ pub struct SecurityMiddleware {
     pub expect_ct: Option<expect_ct::ExpectCtConfig>,
 
     /// Frame Guard (X-Frame-Options)
-    pub frame_guard: frame_guard::FrameGuard,
+    pub frame_guard: Option<frame_guard::FrameGuard>,
 
     /// HSTS (Strict-Transport-Security)
     pub hsts: Option<hsts::HstsConfig>,
@@ -144,13 +147,13 @@ pub struct SecurityMiddleware {
     pub hide_powered_by: bool,
 
     /// Referrer Policy
-    pub referrer_policy: referrer_policy::ReferrerPolicy,
+    pub referrer_policy: Option<referrer_policy::ReferrerPolicy>,
 
     /// X-XSS-Protection
     pub xss_filter: xss_filter::XssFilter,
 
     /// X-Content-Type-Options
-    pub content_type_options: content_type_options::ContentTypeOptions,
+    pub content_type_options: Option<content_type_options::ContentTypeOptions>,
 
     /// X-Download-Options
     pub download_options: download_options::DownloadOptions,
@@ -167,12 +170,12 @@ impl SecurityMiddleware {
             csp: None,
             dns_prefetch_control: dns_prefetch_control::DnsPrefetchControl::Off,
             expect_ct: None,
-            frame_guard: frame_guard::FrameGuard::Deny,
+            frame_guard: Some(frame_guard::FrameGuard::Deny),
             hsts: None,
             hide_powered_by: false,
-            referrer_policy: referrer_policy::ReferrerPolicy::NoReferrer,
+            referrer_policy: Some(referrer_policy::ReferrerPolicy::NoReferrer),
             xss_filter: xss_filter::XssFilter::Enabled,
-            content_type_options: content_type_options::ContentTypeOptions::NoSniff,
+            content_type_options: Some(content_type_options::ContentTypeOptions::NoSniff),
             download_options: download_options::DownloadOptions::NoOpen,
             permitted_cross_domain_policies:
                 permitted_cross_domain_policies::PermittedCrossDomainPolicies::None,
@@ -200,9 +203,21 @@ impl SecurityMiddleware {
         self
     }
 
+    /// Don't send Content-Security-Policy
+    pub fn without_csp(mut self) -> Self {
+        self.csp = None;
+        self
+    }
+
     /// Set Frame Guard policy
     pub fn with_frame_guard(mut self, guard: frame_guard::FrameGuard) -> Self {
-        self.frame_guard = guard;
+        self.frame_guard = Some(guard);
+        self
+    }
+
+    /// Don't send X-Frame-Options
+    pub fn without_frame_guard(mut self) -> Self {
+        self.frame_guard = None;
         self
     }
 
@@ -212,6 +227,12 @@ impl SecurityMiddleware {
         self
     }
 
+    /// Don't send Strict-Transport-Security
+    pub fn without_hsts(mut self) -> Self {
+        self.hsts = None;
+        self
+    }
+
     /// Hide X-Powered-By header
     pub fn hide_powered_by(mut self, hide: bool) -> Self {
         self.hide_powered_by = hide;
@@ -220,7 +241,19 @@ impl SecurityMiddleware {
 
     /// Set Referrer Policy
     pub fn with_referrer_policy(mut self, policy: referrer_policy::ReferrerPolicy) -> Self {
-        self.referrer_policy = policy;
+        self.referrer_policy = Some(policy);
+        self
+    }
+
+    /// Don't send Referrer-Policy
+    pub fn without_referrer_policy(mut self) -> Self {
+        self.referrer_policy = None;
+        self
+    }
+
+    /// Don't send X-Content-Type-Options
+    pub fn without_content_type_options(mut self) -> Self {
+        self.content_type_options = None;
         self
     }
 
@@ -231,12 +264,22 @@ impl SecurityMiddleware {
     }
 
     /// Apply security headers to a response
-    pub fn apply(&self, mut response: HttpResponse) -> HttpResponse {
+    ///
+    /// The request isn't known here, so HSTS is added even when set to
+    /// [`https_only`](hsts::HstsConfig::https_only); used as middleware, it
+    /// is left off responses to plain HTTP requests.
+    pub fn apply(&self, response: HttpResponse) -> HttpResponse {
+        self.apply_for(response, true)
+    }
+
+    /// Apply security headers to the response to a request, made over HTTPS
+    /// if `https` is set
+    fn apply_for(&self, mut response: HttpResponse, https: bool) -> HttpResponse {
         let mut headers = HashMap::new();
 
         // Content Security Policy
         if let Some(ref csp) = self.csp {
-            headers.insert("Content-Security-Policy".to_string(), csp.to_header_value());
+            headers.insert(csp.header_name().to_string(), csp.to_header_value());
         }
 
         // DNS Prefetch Control
@@ -251,13 +294,14 @@ impl SecurityMiddleware {
         }
 
         // Frame Guard
-        headers.insert(
-            "X-Frame-Options".to_string(),
-            self.frame_guard.to_header_value(),
-        );
+        if let Some(ref frame_guard) = self.frame_guard {
+            headers.insert("X-Frame-Options".to_string(), frame_guard.to_header_value());
+        }
 
         // HSTS
-        if let Some(ref hsts) = self.hsts {
+        if let Some(ref hsts) = self.hsts
+            && (https || !hsts.https_only)
+        {
             headers.insert(
                 "Strict-Transport-Security".to_string(),
                 hsts.to_header_value(),
@@ -265,10 +309,12 @@ impl SecurityMiddleware {
         }
 
         // Referrer Policy
-        headers.insert(
-            "Referrer-Policy".to_string(),
-            self.referrer_policy.to_header_value(),
-        );
+        if let Some(referrer_policy) = self.referrer_policy {
+            headers.insert(
+                "Referrer-Policy".to_string(),
+                referrer_policy.to_header_value(),
+            );
+        }
 
         // XSS Filter
         headers.insert(
@@ -277,10 +323,12 @@ impl SecurityMiddleware {
         );
 
         // Content Type Options
-        headers.insert(
-            "X-Content-Type-Options".to_string(),
-            self.content_type_options.to_header_value(),
-        );
+        if let Some(content_type_options) = self.content_type_options {
+            headers.insert(
+                "X-Content-Type-Options".to_string(),
+                content_type_options.to_header_value(),
+            );
+        }
 
         // Download Options
         headers.insert(
@@ -313,12 +361,12 @@ impl SecurityMiddleware {
             csp: Some(content_security_policy::CspConfig::default()),
             dns_prefetch_control: dns_prefetch_control::DnsPrefetchControl::Off,
             expect_ct: Some(expect_ct::ExpectCtConfig::new(max_age_seconds)),
-            frame_guard: frame_guard::FrameGuard::Deny,
+            frame_guard: Some(frame_guard::FrameGuard::Deny),
             hsts: Some(hsts::HstsConfig::new(max_age_seconds)),
             hide_powered_by: true,
-            referrer_policy: referrer_policy::ReferrerPolicy::NoReferrer,
+            referrer_policy: Some(referrer_policy::ReferrerPolicy::NoReferrer),
             xss_filter: xss_filter::XssFilter::Enabled,
-            content_type_options: content_type_options::ContentTypeOptions::NoSniff,
+            content_type_options: Some(content_type_options::ContentTypeOptions::NoSniff),
             download_options: download_options::DownloadOptions::NoOpen,
             permitted_cross_domain_policies:
                 permitted_cross_domain_policies::PermittedCrossDomainPolicies::None,
@@ -366,10 +414,11 @@ impl armature_core::Middleware for SecurityMiddleware {
                 > + Send,
         >,
     ) -> Result<armature_core::HttpResponse, armature_core::Error> {
+        let https = req.is_secure();
         // Call the next handler first
         let response = next(req).await?;
         // Apply security headers to the response
-        Ok(self.apply(response))
+        Ok(self.apply_for(response, https))
     }
 }
 
@@ -436,4 +485,95 @@ mod tests {
             Some(&"strict-origin-when-cross-origin".to_string())
         );
     }
+
+    /// Headers the default bundle sends, with their values
+    const BUNDLE: [(&str, &str); 5] = [
+        ("X-Content-Type-Options", "nosniff"),
+        ("X-Frame-Options", "DENY"),
+        (
+            "Strict-Transport-Security",
+            "max-age=31536000; includeSubDomains",
+        ),
+        ("Referrer-Policy", "no-referrer"),
+        (
+            "Content-Security-Policy",
+            "connect-src 'self'; default-src 'self'; font-src 'self'; \
+             img-src 'self' data: https:; object-src 'none'; script-src 'self'; \
+             style-src 'self' 'unsafe-inline'",
+        ),
+    ];
+
+    #[test]
+    fn test_default_bundle_headers() {
+        let secured = SecurityMiddleware::default().apply(HttpResponse::ok());
+        for (name, value) in BUNDLE {
+            assert_eq!(secured.headers.get(name).map(String::as_str), Some(value));
+        }
+    }
+
+    #[test]
+    fn test_disabled_headers_are_omitted() {
+        type Disable = fn(SecurityMiddleware) -> SecurityMiddleware;
+        let disabled: [(&str, Disable); 5] = [
+            ("X-Content-Type-Options", |m| {
+                m.without_content_type_options()
+            }),
+            ("X-Frame-Options", |m| m.without_frame_guard()),
+            ("Strict-Transport-Security", |m| m.without_hsts()),
+            ("Referrer-Policy", |m| m.without_referrer_policy()),
+            ("Content-Security-Policy", |m| m.without_csp()),
+        ];
+
+        for (omitted, disable) in disabled {
+            let secured = disable(SecurityMiddleware::default()).apply(HttpResponse::ok());
+            for (name, _) in BUNDLE {
+                assert_eq!(
+                    secured.headers.contains_key(name),
+                    name != omitted,
+                    "{} with {} disabled",
+                    name,
+                    omitted
+                );
+            }
+        }
+    }
+
+    #[test]
+    fn test_report_only_csp() {
+        let middleware = SecurityMiddleware::new()
+            .with_csp(content_security_policy::CspConfig::default().report_only(true));
+        let secured = middleware.apply(HttpResponse::ok());
+
+        assert!(
+            secured
+                .headers
+                .contains_key("Content-Security-Policy-Report-Only")
+        );
+        assert!(!secured.headers.contains_key("Content-Security-Policy"));
+    }
+
+    #[tokio::test]
+    async fn test_hsts_https_only() {
+        use armature_core::{HttpRequest, Https, Router};
+
+        let hsts = hsts::HstsConfig::new(63072000)
+            .preload(true)
+            .https_only(true);
+        let mut router = Router::new();
+        router.use_middleware(SecurityMiddleware::default().with_hsts(hsts));
+        router.get("/", |_req: HttpRequest| async { Ok(HttpResponse::ok()) });
+
+        let req = HttpRequest::new("GET".into(), "/".into());
+        let response = router.route(req).await.unwrap();
+        assert!(!response.headers.contains_key("Strict-Transport-Security"));
+        assert!(response.headers.contains_key("X-Frame-Options"));
+
+        let mut req = HttpRequest::new("GET".into(), "/".into());
+        req.extensions.insert(Https);
+        let response = router.route(req).await.unwrap();
+        assert_eq!(
+            response.headers.get("Strict-Transport-Security").unwrap(),
+            "max-age=63072000; includeSubDomains; preload"
+        );
+    }
 }
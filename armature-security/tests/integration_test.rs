@@ -2,7 +2,7 @@
 
 use armature_core::HttpResponse;
 use armature_security::SecurityMiddleware;
-use armature_security::content_security_policy::CspConfig;
+use armature_security::content_security_policy::{CspConfig, Source};
 use armature_security::content_type_options::ContentTypeOptions;
 use armature_security::dns_prefetch_control::DnsPrefetchControl;
 use armature_security::download_options::DownloadOptions;
@@ -67,9 +67,9 @@ fn test_frame_guard_variants() {
 #[test]
 fn test_csp_config() {
     let csp = CspConfig::new()
-        .default_src(vec!["'self'".to_string()])
-        .script_src(vec!["'self'".to_string(), "'unsafe-inline'".to_string()])
-        .style_src(vec!["'self'".to_string()]);
+        .default_src(vec![Source::SelfOrigin])
+        .script_src(vec![Source::SelfOrigin, Source::UnsafeInline])
+        .style_src(vec![Source::SelfOrigin]);
 
     let header = csp.to_header_value();
     assert!(header.contains("default-src 'self'"));
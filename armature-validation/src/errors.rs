@@ -114,3 +114,20 @@ impl From<Vec<ValidationError>> for ValidationErrors {
         Self::new(errors)
     }
 }
+
+impl From<ValidationError> for armature_core::FieldError {
+    fn from(error: ValidationError) -> Self {
+        armature_core::FieldError::new(error.field, error.constraint, error.message)
+    }
+}
+
+impl From<ValidationErrors> for armature_core::ValidationError {
+    fn from(errors: ValidationErrors) -> Self {
+        errors
+            .errors
+            .into_iter()
+            .map(armature_core::FieldError::from)
+            .collect::<Vec<_>>()
+            .into()
+    }
+}
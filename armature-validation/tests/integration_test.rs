@@ -91,3 +91,22 @@ fn test_validation_error_creation() {
     assert_eq!(error.field, "email");
     assert_eq!(error.message, "invalid email format");
 }
+
+#[test]
+fn test_validation_errors_into_core_validation_error() {
+    let mut errors = ValidationErrors::new(vec![]);
+    if let Err(e) = IsEmail::validate("nope", "email") {
+        errors.add(e);
+    }
+    if let Err(e) = MinLength(3).validate("ab", "name") {
+        errors.add(e);
+    }
+
+    let core: armature_core::ValidationError = errors.into();
+    assert_eq!(core.len(), 2);
+    assert_eq!(core.errors()[1].field, "name");
+    assert_eq!(core.errors()[1].rule, "minLength");
+
+    let err: armature_core::Error = core.into();
+    assert_eq!(err.status_code(), 422);
+}
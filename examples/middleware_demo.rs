@@ -215,7 +215,7 @@ async fn main() {
 
     // Create middleware chain (for demonstration)
     let mut _middleware_chain = MiddlewareChain::new();
-    _middleware_chain.use_middleware(RequestIdMiddleware);
+    _middleware_chain.use_middleware(RequestIdMiddleware::new());
     _middleware_chain.use_middleware(LoggerMiddleware::new());
     _middleware_chain.use_middleware(
         CorsMiddleware::new()
@@ -29,7 +29,7 @@
 
 use armature_core::handler::from_legacy_handler;
 use armature_core::*;
-use armature_security::content_security_policy::CspConfig;
+use armature_security::content_security_policy::{CspConfig, Source};
 use armature_security::cors::CorsConfig;
 use armature_security::hsts::HstsConfig;
 use armature_security::request_signing::RequestSigner;
@@ -78,28 +78,28 @@ async fn main() -> Result<(), Box<dyn std::error::Error>> {
     // Configure Content Security Policy
     info!("\nConfiguring CSP...");
     let _csp = CspConfig::new()
-        .default_src(vec!["'self'".to_string()])
+        .default_src(vec![Source::SelfOrigin])
         .script_src(vec![
-            "'self'".to_string(),
-            "https://cdn.example.com".to_string(),
+            Source::SelfOrigin,
+            Source::host("https://cdn.example.com")?,
         ])
         .style_src(vec![
-            "'self'".to_string(),
-            "'unsafe-inline'".to_string(), // For inline styles (use nonce in production)
-            "https://fonts.googleapis.com".to_string(),
+            Source::SelfOrigin,
+            Source::UnsafeInline, // For inline styles (use nonce in production)
+            Source::host("https://fonts.googleapis.com")?,
         ])
         .img_src(vec![
-            "'self'".to_string(),
-            "data:".to_string(),
-            "https:".to_string(),
+            Source::SelfOrigin,
+            Source::scheme("data")?,
+            Source::scheme("https")?,
         ])
         .font_src(vec![
-            "'self'".to_string(),
-            "https://fonts.gstatic.com".to_string(),
+            Source::SelfOrigin,
+            Source::host("https://fonts.gstatic.com")?,
         ])
         .connect_src(vec![
-            "'self'".to_string(),
-            "https://api.example.com".to_string(),
+            Source::SelfOrigin,
+            Source::host("https://api.example.com")?,
         ]);
 
     info!("✓ CSP configured with secure defaults");
@@ -12,8 +12,11 @@
 
 use armature::prelude::*;
 use armature_security::{
-    SecurityMiddleware, content_security_policy::CspConfig, frame_guard::FrameGuard,
-    hsts::HstsConfig, referrer_policy::ReferrerPolicy,
+    SecurityMiddleware,
+    content_security_policy::{CspConfig, Source},
+    frame_guard::FrameGuard,
+    hsts::HstsConfig,
+    referrer_policy::ReferrerPolicy,
 };
 
 // ========== Services ==========
@@ -176,16 +179,16 @@ async fn main() -> Result<(), Box<dyn std::error::Error>> {
     let _custom_security = SecurityMiddleware::new()
         .with_csp(
             CspConfig::new()
-                .default_src(vec!["'self'".to_string()])
+                .default_src(vec![Source::SelfOrigin])
                 .script_src(vec![
-                    "'self'".to_string(),
-                    "https://cdn.example.com".to_string(),
+                    Source::SelfOrigin,
+                    Source::host("https://cdn.example.com")?,
                 ])
-                .style_src(vec!["'self'".to_string(), "'unsafe-inline'".to_string()])
+                .style_src(vec![Source::SelfOrigin, Source::UnsafeInline])
                 .img_src(vec![
-                    "'self'".to_string(),
-                    "data:".to_string(),
-                    "https:".to_string(),
+                    Source::SelfOrigin,
+                    Source::scheme("data")?,
+                    Source::scheme("https")?,
                 ]),
         )
         .with_hsts(
@@ -69,6 +69,8 @@ pub mod prelude {
         Container,
         Controller,
         Error,
+        EventStream,
+        HttpError,
         HttpMethod,
         HttpRequest,
         HttpResponse,